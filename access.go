@@ -0,0 +1,142 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// audit logs the processes opening the files written, nil if disabled
+var audit *accessAudit
+
+// accessLogInterval is the interval an open of the same file by the same process is logged again
+var accessLogInterval = time.Duration(1) * time.Minute
+
+// accessAudit reports the processes which open the files in the directories the secrets are written to
+type accessAudit struct {
+	sync.Mutex
+	// the directories being watched
+	directories map[string]bool
+	// the last time an open was logged, by process and file
+	logged map[string]time.Time
+	// the fanotify descriptor, -1 when not watching
+	fd int
+}
+
+// accessProcess is the process which opened a file
+type accessProcess struct {
+	// the process id
+	pid int
+	// the name of the command
+	command string
+	// the real uid of the process
+	uid int
+}
+
+// newAccessAudit creates an audit watching no directories
+func newAccessAudit() *accessAudit {
+	return &accessAudit{
+		directories: make(map[string]bool, 0),
+		logged:      make(map[string]time.Time, 0),
+		fd:          -1,
+	}
+}
+
+// track watches the directory of a file written, so the processes opening it are reported
+//
+//	filename	: the file written
+func (a *accessAudit) track(filename string) {
+	dir := filepath.Dir(filename)
+	a.Lock()
+	defer a.Unlock()
+	if a.fd < 0 || a.directories[dir] {
+		return
+	}
+	if err := a.mark(dir); err != nil {
+		glog.Errorf("unable to audit the access to the directory: %s, error: %s", dir, err)
+		return
+	}
+	a.directories[dir] = true
+}
+
+// report logs the open of a file by a process, unless the process is the sidekick or the open was logged
+// within the interval
+//
+//	pid			: the process which opened the file
+//	filename	: the file opened
+func (a *accessAudit) report(pid int, filename string) bool {
+	if pid == os.Getpid() {
+		return false
+	}
+	key := fmt.Sprintf("%d:%s", pid, filename)
+	a.Lock()
+	if last, found := a.logged[key]; found && time.Since(last) < accessLogInterval {
+		a.Unlock()
+		return false
+	}
+	a.logged[key] = time.Now()
+	// step: forget the opens which can no longer be repeated within the interval
+	for k, last := range a.logged {
+		if time.Since(last) >= accessLogInterval {
+			delete(a.logged, k)
+		}
+	}
+	a.Unlock()
+
+	process := lookupProcess(pid)
+	glog.Infof("access: the file: %s was opened by pid: %d, command: %s, uid: %d", filename, pid, process.command, process.uid)
+	metrics.add(metricFileAccess, 1, "file", filename, "command", process.command)
+
+	return true
+}
+
+// lookupProcess reads the command and uid of a process from /proc, which may have exited already
+//
+//	pid			: the process id
+func lookupProcess(pid int) accessProcess {
+	process := accessProcess{pid: pid, command: "unknown", uid: -1}
+	if content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); err == nil {
+		process.command = strings.TrimSpace(string(content))
+	}
+	file, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return process
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 1 && fields[0] == "Uid:" {
+			if uid, err := strconv.Atoi(fields[1]); err == nil {
+				process.uid = uid
+			}
+			break
+		}
+	}
+
+	return process
+}
@@ -0,0 +1,124 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// the fanotify flags, the syscall package has none of them
+	fanClassNotif    = 0x00000000
+	fanCloexec       = 0x00000001
+	fanMarkAdd       = 0x00000001
+	fanOpen          = 0x00000020
+	fanEventOnChild  = 0x08000000
+	fanQueueOverflow = 0x00004000
+	// fanNoFD is the descriptor of an event without a file i.e. an overflow
+	fanNoFD = -1
+	// atFDCWD resolves the path of a mark relative to the working directory
+	atFDCWD = -100
+)
+
+// fanotifyEvent is the struct fanotify_event_metadata of the kernel
+type fanotifyEvent struct {
+	EventLen    uint32
+	Vers        uint8
+	Reserved    uint8
+	MetadataLen uint16
+	Mask        uint64
+	Fd          int32
+	Pid         int32
+}
+
+// start opens the fanotify descriptor and reads the events in the background, requiring CAP_SYS_ADMIN
+func (a *accessAudit) start() error {
+	fd, _, errno := syscall.Syscall(syscall.SYS_FANOTIFY_INIT, fanClassNotif|fanCloexec, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if errno != 0 {
+		return fmt.Errorf("unable to initialize fanotify, which requires CAP_SYS_ADMIN, error: %s", errno)
+	}
+	a.Lock()
+	a.fd = int(fd)
+	a.Unlock()
+
+	go a.readEvents(int(fd))
+
+	return nil
+}
+
+// mark watches the opens of the files in the directory; the lock must be held
+//
+//	dir			: the directory
+func (a *accessAudit) mark(dir string) error {
+	path, err := syscall.BytePtrFromString(dir)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_FANOTIFY_MARK, uintptr(a.fd), fanMarkAdd,
+		fanOpen|fanEventOnChild, uintptr(atFDCWD&0xffffffff), uintptr(unsafe.Pointer(path)), 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// readEvents reads the fanotify events, reporting the process which opened each file
+func (a *accessAudit) readEvents(fd int) {
+	size := int(unsafe.Sizeof(fanotifyEvent{}))
+	buffer := make([]byte, 256*size)
+	for {
+		n, err := syscall.Read(fd, buffer)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			glog.Errorf("stopped auditing the access to the output files, error: %v", err)
+			return
+		}
+		for offset := 0; offset+size <= n; {
+			event := (*fanotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			if event.EventLen < uint32(size) {
+				break
+			}
+			offset += int(event.EventLen)
+			if event.Mask&fanQueueOverflow != 0 {
+				glog.Warningf("the access audit queue overflowed, some opens of the output files were not logged")
+			}
+			if event.Fd == fanNoFD {
+				continue
+			}
+			// step: the descriptor of the event is the file opened, its path read from /proc
+			filename, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(int(event.Fd)))
+			syscall.Close(int(event.Fd))
+			if err != nil {
+				continue
+			}
+			a.report(int(event.Pid), filename)
+		}
+	}
+}
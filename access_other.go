@@ -0,0 +1,32 @@
+//go:build !linux || (!amd64 && !arm64)
+// +build !linux !amd64,!arm64
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "fmt"
+
+// start is unsupported, fanotify is only available on linux
+func (a *accessAudit) start() error {
+	return fmt.Errorf("auditing the access to the files requires fanotify, which is only supported on linux")
+}
+
+// mark is unsupported, the audit never starts
+func (a *accessAudit) mark(dir string) error {
+	return nil
+}
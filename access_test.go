@@ -0,0 +1,98 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessAuditReport(t *testing.T) {
+	a := newAccessAudit()
+	assert.False(t, a.report(os.Getpid(), "/etc/secrets/app.json"))
+	assert.True(t, a.report(1, "/etc/secrets/app.json"))
+	assert.False(t, a.report(1, "/etc/secrets/app.json"))
+	assert.True(t, a.report(1, "/etc/secrets/db.json"))
+
+	// step: an open is logged again once the interval has passed
+	a.logged[fmt.Sprintf("%d:%s", 1, "/etc/secrets/app.json")] = time.Now().Add(-accessLogInterval)
+	assert.True(t, a.report(1, "/etc/secrets/app.json"))
+}
+
+func TestAccessAuditTrackDisabled(t *testing.T) {
+	a := newAccessAudit()
+	a.track("/etc/secrets/app.json")
+	assert.Empty(t, a.directories)
+}
+
+func TestLookupProcess(t *testing.T) {
+	if _, err := os.Stat("/proc/self/status"); err != nil {
+		t.Skip("the system has no /proc")
+	}
+	process := lookupProcess(os.Getpid())
+	assert.NotEqual(t, "unknown", process.command)
+	assert.Equal(t, os.Getuid(), process.uid)
+
+	process = lookupProcess(-1)
+	assert.Equal(t, "unknown", process.command)
+	assert.Equal(t, -1, process.uid)
+}
+
+func TestAccessAuditFanotify(t *testing.T) {
+	a := newAccessAudit()
+	if err := a.start(); err != nil {
+		t.Skipf("fanotify is unavailable: %s", err)
+	}
+	dir, err := ioutil.TempDir("", "access")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.json")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("{}"), 0600))
+	a.track(filename)
+	assert.True(t, a.directories[dir])
+
+	// step: the open by another process is logged, the reads of the sidekick are not
+	_, err = ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	cmd := exec.Command("cat", filename)
+	assert.NoError(t, cmd.Run())
+
+	key := fmt.Sprintf("%d:%s", cmd.Process.Pid, filename)
+	for i := 0; i < 50; i++ {
+		a.Lock()
+		_, found := a.logged[key]
+		a.Unlock()
+		if found {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	a.Lock()
+	defer a.Unlock()
+	assert.Contains(t, a.logged, key)
+	assert.Len(t, a.logged, 1)
+}
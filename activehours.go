@@ -0,0 +1,231 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// activeWeekdays are the names of the days of the week an active window is given on
+var activeWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// activeWindow is the hours of the days the credentials of a resource are held, ending the next day if the end
+// is before the start i.e. 22:00-06:00
+type activeWindow struct {
+	// the days of the week the window opens on, every day if nil
+	days map[time.Weekday]bool
+	// the minute of the day the window opens and closes on
+	start int
+	end   int
+}
+
+// activeHours is the windows the credentials of a resource are held within, always if empty
+type activeHours []*activeWindow
+
+// parseActiveWindow parses an active window, the hours optionally preceded by a day or range of days i.e.
+// 'mon-fri 08:00-18:00', '22:00-06:00' or 'sat 10:00-14:00', in the local time
+//	value		: the window
+func parseActiveWindow(value string) (*activeWindow, error) {
+	fields := strings.Fields(strings.ToLower(value))
+	if len(fields) < 1 || len(fields) > 2 {
+		return nil, fmt.Errorf("the active hours: %s should be [DAYS ]HH:MM-HH:MM i.e. mon-fri 08:00-18:00", value)
+	}
+	w := &activeWindow{}
+	if len(fields) == 2 {
+		days, err := parseActiveDays(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("the active hours: %s are invalid, error: %s", value, err)
+		}
+		w.days = days
+	}
+	hours := strings.Split(fields[len(fields)-1], "-")
+	if len(hours) != 2 {
+		return nil, fmt.Errorf("the active hours: %s should be [DAYS ]HH:MM-HH:MM i.e. mon-fri 08:00-18:00", value)
+	}
+	for i, x := range []*int{&w.start, &w.end} {
+		t, err := time.Parse("15:04", hours[i])
+		if err != nil {
+			return nil, fmt.Errorf("the active hours: %s are invalid, the time: %s should be HH:MM", value, hours[i])
+		}
+		*x = t.Hour()*60 + t.Minute()
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("the active hours: %s must end at a different time to the start", value)
+	}
+
+	return w, nil
+}
+
+// parseActiveDays parses a day or range of days of the week i.e. mon-fri or fri-mon
+//	value		: the days
+func parseActiveDays(value string) (map[time.Weekday]bool, error) {
+	names := strings.Split(value, "-")
+	if len(names) > 2 {
+		return nil, fmt.Errorf("the days: %s should be a day or a range of days i.e. mon-fri", value)
+	}
+	var bounds []time.Weekday
+	for _, name := range names {
+		day, found := activeWeekdays[name]
+		if !found {
+			return nil, fmt.Errorf("the day: %s should be one of sun, mon, tue, wed, thu, fri or sat", name)
+		}
+		bounds = append(bounds, day)
+	}
+	days := make(map[time.Weekday]bool, 0)
+	for day := bounds[0]; ; day = (day + 1) % 7 {
+		days[day] = true
+		if day == bounds[len(bounds)-1] {
+			break
+		}
+	}
+
+	return days, nil
+}
+
+// opening returns the times the window opens and closes on the day of the time, false if it doesn't open that day
+//	day			: a time on the day
+func (w *activeWindow) opening(day time.Time) (time.Time, time.Time, bool) {
+	if w.days != nil && !w.days[day.Weekday()] {
+		return time.Time{}, time.Time{}, false
+	}
+	start := time.Date(day.Year(), day.Month(), day.Day(), w.start/60, w.start%60, 0, 0, day.Location())
+	end := time.Date(day.Year(), day.Month(), day.Day(), w.end/60, w.end%60, 0, 0, day.Location())
+	if w.end < w.start {
+		end = end.AddDate(0, 0, 1)
+	}
+
+	return start, end, true
+}
+
+// until checks if a window is open, returning when the windows close; a window opening as another closes
+// extends it, for up to a week
+//	now			: the time to check
+func (a activeHours) until(now time.Time) (time.Time, bool) {
+	closes, open := a.closing(now)
+	if !open {
+		return time.Time{}, false
+	}
+	for i := 0; i < 14; i++ {
+		next, found := a.closing(closes)
+		if !found || !next.After(closes) {
+			break
+		}
+		closes = next
+	}
+
+	return closes, true
+}
+
+// closing returns the latest time the windows open at the time close
+//	now			: the time to check
+func (a activeHours) closing(now time.Time) (time.Time, bool) {
+	var closes time.Time
+	open := false
+	for _, w := range a {
+		// step: a window opening the day before may still be open
+		for offset := -1; offset <= 0; offset++ {
+			start, end, found := w.opening(now.AddDate(0, 0, offset))
+			if found && !now.Before(start) && now.Before(end) && end.After(closes) {
+				closes, open = end, true
+			}
+		}
+	}
+
+	return closes, open
+}
+
+// next returns the time the first of the windows next opens after the time
+//	now			: the time to check
+func (a activeHours) next(now time.Time) time.Time {
+	var opens time.Time
+	for _, w := range a {
+		for offset := 0; offset <= 7; offset++ {
+			start, _, found := w.opening(now.AddDate(0, 0, offset))
+			if found && start.After(now) && (opens.IsZero() || start.Before(opens)) {
+				opens = start
+			}
+		}
+	}
+
+	return opens
+}
+
+// outsideActiveHours revokes the lease of a resource outside its active hours, deferring the retrieval until the
+// hours next open; false when within the hours, or the resource has none
+//	x			: the watched resource
+//	ch			: the channel the retrieval is scheduled on
+func (r VaultService) outsideActiveHours(x *watchedResource, ch chan *watchedResource) bool {
+	if len(x.resource.activeHours) == 0 {
+		return false
+	}
+	now := time.Now()
+	if _, open := x.resource.activeHours.until(now); open {
+		x.inactive = false
+		return false
+	}
+	// step: the retrieval has already been deferred until the hours open
+	if x.inactive {
+		return true
+	}
+	x.inactive = true
+	if x.secret != nil && x.secret.LeaseID != "" {
+		op := r.withRequestID()
+		if err := op.revokeIn(x.resource, x.secret.LeaseID); err != nil {
+			glog.Errorf("failed to revoke the lease: %s of resource: %s outside its active hours, request id: %s, error: %s",
+				x.secret.LeaseID, x.resource, op.requestID, err)
+		} else {
+			glog.Infof("revoked the lease: %s of resource: %s outside its active hours", x.secret.LeaseID, x.resource)
+		}
+		x.secret.LeaseID = ""
+		x.secret.Renewable = false
+		x.leaseExpireTime = now
+		if r.state != nil {
+			if err := r.state.remove(x.resource.ID()); err != nil {
+				glog.Errorf("failed to remove the lease of resource: %s from the state file, error: %s", x.resource, err)
+			}
+		}
+	}
+	// step: the startup isn't held up by a resource outside its hours
+	startup.complete(x.resource)
+
+	opens := x.resource.activeHours.next(now).Sub(now)
+	glog.V(3).Infof("resource: %s is outside its active hours, retrieving it again in: %s", x.resource, formatDuration(opens))
+	r.scheduleIn(x, ch, opens)
+	schedule.set(x.resource, scheduleFetch, opens, "the resource is outside its active hours", time.Time{})
+
+	return true
+}
+
+// closeActiveHours schedules a retrieval of the resource as its active hours close, so the lease is revoked
+//	x			: the watched resource
+//	ch			: the channel the retrieval is scheduled on
+func (r VaultService) closeActiveHours(x *watchedResource, ch chan *watchedResource) {
+	if len(x.resource.activeHours) == 0 {
+		return
+	}
+	now := time.Now()
+	if closes, open := x.resource.activeHours.until(now); open {
+		r.scheduleIn(x, ch, closes.Sub(now)+time.Second)
+	}
+}
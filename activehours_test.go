@@ -0,0 +1,159 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseActiveWindow(t *testing.T) {
+	cases := []struct {
+		Value string
+		Start int
+		End   int
+		Days  int
+		Ok    bool
+	}{
+		{Value: "08:00-18:00", Start: 480, End: 1080, Ok: true},
+		{Value: "mon-fri 08:30-18:00", Start: 510, End: 1080, Days: 5, Ok: true},
+		{Value: "fri-mon 22:00-06:00", Start: 1320, End: 360, Days: 4, Ok: true},
+		{Value: "SAT 10:00-14:00", Start: 600, End: 840, Days: 1, Ok: true},
+		{Value: "08:00"},
+		{Value: "08:00-08:00"},
+		{Value: "mon-fri-sat 08:00-18:00"},
+		{Value: "someday 08:00-18:00"},
+		{Value: "8am-6pm"},
+		{Value: "mon 08:00-18:00 extra"},
+	}
+	for i, c := range cases {
+		w, err := parseActiveWindow(c.Value)
+		if !c.Ok {
+			assert.Error(t, err, "case %d", i)
+			continue
+		}
+		if assert.NoError(t, err, "case %d", i) {
+			assert.Equal(t, c.Start, w.start, "case %d", i)
+			assert.Equal(t, c.End, w.end, "case %d", i)
+			assert.Equal(t, c.Days, len(w.days), "case %d", i)
+		}
+	}
+}
+
+func TestActiveHours(t *testing.T) {
+	parse := func(values ...string) activeHours {
+		var hours activeHours
+		for _, x := range values {
+			w, err := parseActiveWindow(x)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			hours = append(hours, w)
+		}
+		return hours
+	}
+	// step: 2020-03-02 was a monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2020, 3, day, hour, minute, 0, 0, time.Local)
+	}
+
+	hours := parse("mon-fri 08:00-18:00")
+	closes, open := hours.until(at(2, 9, 0))
+	assert.True(t, open)
+	assert.Equal(t, at(2, 18, 0), closes)
+	_, open = hours.until(at(2, 18, 0))
+	assert.False(t, open)
+	assert.Equal(t, at(3, 8, 0), hours.next(at(2, 18, 0)))
+	// step: the weekend is skipped
+	_, open = hours.until(at(7, 12, 0))
+	assert.False(t, open)
+	assert.Equal(t, at(9, 8, 0), hours.next(at(6, 19, 0)))
+
+	// step: a window ending the next day is open after midnight
+	hours = parse("fri 22:00-06:00")
+	closes, open = hours.until(at(7, 1, 0))
+	assert.True(t, open)
+	assert.Equal(t, at(7, 6, 0), closes)
+	_, open = hours.until(at(5, 1, 0))
+	assert.False(t, open)
+
+	// step: a window opening as another closes extends it
+	hours = parse("08:00-12:00", "12:00-18:00", "20:00-22:00")
+	closes, open = hours.until(at(2, 9, 0))
+	assert.True(t, open)
+	assert.Equal(t, at(2, 18, 0), closes)
+	assert.Equal(t, at(2, 20, 0), hours.next(at(2, 18, 30)))
+}
+
+func TestActiveHoursResourceOptions(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("aws:aws/creds/app:active-hours='mon-fri 08:00-18:00,sat 10:00-14:00'"))
+	rn := resources.items[0]
+	assert.NoError(t, rn.IsValid())
+	assert.Equal(t, 2, len(rn.activeHours))
+
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("secret:secret/app:active-hours='08:00-18:00'"))
+	assert.Error(t, resources.items[0].IsValid())
+
+	resources = VaultResources{}
+	assert.Error(t, resources.Set("aws:aws/creds/app:active-hours='mon-fri 8-18'"))
+}
+
+func TestOutsideActiveHours(t *testing.T) {
+	service, _ := newMockService(t)
+	ch := make(chan *watchedResource, 1)
+
+	// step: a window opening in a couple of hours, so closed now
+	now := time.Now()
+	window := func(from, to time.Duration) activeHours {
+		start, end := now.Add(from), now.Add(to)
+		w, err := parseActiveWindow(fmt.Sprintf("%02d:%02d-%02d:%02d", start.Hour(), start.Minute(), end.Hour(), end.Minute()))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return activeHours{w}
+	}
+
+	rn := defaultVaultResource()
+	rn.resource = "aws"
+	rn.path = "aws/creds/app"
+	rn.activeHours = window(2*time.Hour, 3*time.Hour)
+	x := &watchedResource{
+		resource:        rn,
+		secret:          &api.Secret{LeaseID: "aws/creds/app/1", Renewable: true},
+		leaseExpireTime: now.Add(time.Hour),
+	}
+	assert.True(t, service.outsideActiveHours(x, ch))
+	assert.True(t, x.inactive)
+	assert.Empty(t, x.secret.LeaseID)
+	assert.False(t, x.secret.Renewable)
+	// step: the retrieval is only deferred once
+	assert.True(t, service.outsideActiveHours(x, ch))
+
+	// step: within the hours the resource is processed as normal
+	rn.activeHours = window(-time.Hour, time.Hour)
+	assert.False(t, service.outsideActiveHours(x, ch))
+	assert.False(t, x.inactive)
+
+	rn.activeHours = nil
+	assert.False(t, service.outsideActiveHours(x, ch))
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// validateListenAddress checks the address of a listener is a host:port; an empty host or [::] listens on every
+// interface, over both ipv4 and ipv6 where the host allows, and an ipv6 address is enclosed in brackets i.e. [::1]:8080
+//	address		: the address of the listener
+func validateListenAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("should be host:port%s", ipv6Hint(address))
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("the port: %s is invalid", port)
+	}
+
+	return nil
+}
+
+// validateURL checks the url has a host, and a numeric port if any; an ipv6 address is enclosed in brackets
+// i.e. https://[fd00::1]:8200
+//	value		: the url
+func validateURL(value string) error {
+	authority := value
+	if i := strings.Index(authority, "://"); i >= 0 {
+		authority = authority[i+3:]
+	}
+	if i := strings.IndexAny(authority, "/?#"); i >= 0 {
+		authority = authority[:i]
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("should be scheme://host[:port]%s", ipv6Hint(authority))
+	}
+	// step: depending on the go version an unbracketed ipv6 address may parse, with part of the address as the port
+	if hint := ipv6Hint(u.Host); hint != "" {
+		return fmt.Errorf("should be scheme://host[:port]%s", hint)
+	}
+	if port := u.Port(); port != "" {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return fmt.Errorf("the port: %s is invalid", port)
+		}
+	}
+
+	return nil
+}
+
+// ipv6Hint returns a hint the address looks like an ipv6 address missing its brackets, empty otherwise
+//	address		: the host, host:port or authority of a url
+func ipv6Hint(address string) string {
+	if !strings.Contains(address, "[") && strings.Count(address, ":") > 1 {
+		return ", an ipv6 address must be enclosed in brackets i.e. [::1]:8200"
+	}
+
+	return ""
+}
+
+// dialAddress returns the address a client on the same host dials to reach a listener; a listener on every
+// interface is reached on the loopback of the same family, or localhost when no host was given
+//	address		: the address of the listener
+func dialAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case host == "":
+		host = "localhost"
+	case ip != nil && ip.IsUnspecified() && ip.To4() != nil:
+		host = "127.0.0.1"
+	case ip != nil && ip.IsUnspecified():
+		host = "::1"
+	}
+
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateListenAddress(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:8080", ":8080", "[::]:8080", "[::1]:8080", "[fe80::1%eth0]:8080", "localhost:8080"} {
+		assert.NoError(t, validateListenAddress(address), address)
+	}
+	for _, address := range []string{"127.0.0.1", "::1:8080", "[::1]", "[::1]:http", "localhost:70000"} {
+		assert.Error(t, validateListenAddress(address), address)
+	}
+	assert.Contains(t, validateListenAddress("::1:8080").Error(), "brackets")
+}
+
+func TestValidateURL(t *testing.T) {
+	for _, value := range []string{"https://vault.example.com:8200", "https://[fd00::1]:8200", "https://[fd00::1]", "http://[fe80::1%25eth0]:8200/v1"} {
+		assert.NoError(t, validateURL(value), value)
+	}
+	for _, value := range []string{"%invalid_url", "vault:8200", "https://fd00::1:8200", "https://[fd00::1]:port"} {
+		assert.Error(t, validateURL(value), value)
+	}
+	assert.Contains(t, validateURL("https://fd00::1:8200/v1").Error(), "brackets")
+	assert.NotContains(t, validateURL("https://vault.example.com:port").Error(), "brackets")
+}
+
+func TestDialAddress(t *testing.T) {
+	assert.Equal(t, "localhost:8080", dialAddress(":8080"))
+	assert.Equal(t, "127.0.0.1:8080", dialAddress("0.0.0.0:8080"))
+	assert.Equal(t, "[::1]:8080", dialAddress("[::]:8080"))
+	assert.Equal(t, "[fd00::10]:8080", dialAddress("[fd00::10]:8080"))
+	assert.Equal(t, "10.0.0.1:8080", dialAddress("10.0.0.1:8080"))
+}
+
+func TestAdminServerIPv6(t *testing.T) {
+	if listener, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skip("ipv6 is unavailable")
+	} else {
+		listener.Close()
+	}
+	listener, err := startAdminServer("[::]:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer listener.Close()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	resp, err := http.Get("http://" + dialAddress(net.JoinHostPort("::", port)) + "/health")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+}
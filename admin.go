@@ -0,0 +1,484 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	// registry is the global status of the resources, served by the admin endpoint
+	registry = newStatusRegistry()
+	// pausedInterval is the interval a paused resource is checked for being resumed
+	pausedInterval = time.Duration(10) * time.Second
+	// revokeExitDelay is the time the sidekick exits after its tokens are revoked
+	revokeExitDelay = time.Duration(1) * time.Second
+)
+
+// resourceStatus is the current state of a resource
+type resourceStatus struct {
+	// the identifier of the resource
+	ID string `json:"id"`
+	// the resource type
+	Resource string `json:"resource"`
+	// the path of the resource
+	Path string `json:"path"`
+	// the named vault the resource is retrieved from
+	Vault string `json:"vault,omitempty"`
+	// the tags of the resource
+	Tags []string `json:"tags,omitempty"`
+	// the filename the resource is written to
+	Filename string `json:"filename"`
+	// pending, ok or failed
+	Status string `json:"status"`
+	// the time of the last successful update
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// the time of the last failure
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	// the number of consecutive failures
+	Failures int `json:"failures"`
+	// the time of the first of the consecutive failures
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+	// the error of the last failure, empty once the resource has been updated
+	LastError string `json:"last_error,omitempty"`
+	// the policy the last secret retrieved broke, empty unless the resource is failing on it
+	Violation string `json:"violation,omitempty"`
+	// whether the updates of the resource are paused
+	Paused bool `json:"paused"`
+	// deleted or destroyed when the current version of a kv v2 secret has been removed upstream
+	Deleted string `json:"deleted,omitempty"`
+	// the metadata of the secret
+	Metadata *secretMetadata `json:"metadata,omitempty"`
+	// the resource itself
+	rn *VaultResource
+	// rotates the static role of the resource, nil unless the rotate option is set
+	rotate func() error
+	// renews the lease of the resource, or retrieves it again, nil until the resource is watched
+	refresh func() error
+}
+
+// MarshalJSON serializes the times of the status in the format of the -time-format option
+func (x resourceStatus) MarshalJSON() ([]byte, error) {
+	type plain resourceStatus
+	encoded := struct {
+		plain
+		LastSuccess  interface{} `json:"last_success,omitempty"`
+		LastFailure  interface{} `json:"last_failure,omitempty"`
+		FailingSince interface{} `json:"failing_since,omitempty"`
+	}{plain: plain(x)}
+	for _, field := range []struct {
+		value *time.Time
+		into  *interface{}
+	}{
+		{x.LastSuccess, &encoded.LastSuccess},
+		{x.LastFailure, &encoded.LastFailure},
+		{x.FailingSince, &encoded.FailingSince},
+	} {
+		if field.value != nil {
+			*field.into = formatTimeValue(*field.value)
+		}
+	}
+
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON reads the status back, the times in either format
+func (x *resourceStatus) UnmarshalJSON(content []byte) error {
+	type plain resourceStatus
+	decoded := struct {
+		*plain
+		LastSuccess  interface{} `json:"last_success"`
+		LastFailure  interface{} `json:"last_failure"`
+		FailingSince interface{} `json:"failing_since"`
+	}{plain: (*plain)(x)}
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		return err
+	}
+	for _, field := range []struct {
+		value interface{}
+		into  **time.Time
+	}{
+		{decoded.LastSuccess, &x.LastSuccess},
+		{decoded.LastFailure, &x.LastFailure},
+		{decoded.FailingSince, &x.FailingSince},
+	} {
+		if field.value == nil {
+			continue
+		}
+		t, err := parseTimeValue(field.value)
+		if err != nil {
+			return err
+		}
+		*field.into = &t
+	}
+
+	return nil
+}
+
+// statusRegistry holds the status of the resources
+type statusRegistry struct {
+	sync.RWMutex
+	// the status of the resources, keyed by id
+	items map[string]*resourceStatus
+}
+
+// newStatusRegistry creates an empty registry
+func newStatusRegistry() *statusRegistry {
+	return &statusRegistry{items: make(map[string]*resourceStatus, 0)}
+}
+
+// register adds a resource to the registry
+func (r *statusRegistry) register(rn *VaultResource) {
+	r.Lock()
+	defer r.Unlock()
+	r.items[rn.ID()] = &resourceStatus{
+		ID:       rn.ID(),
+		Resource: rn.resource,
+		Path:     rn.path,
+		Vault:    rn.vault,
+		Tags:     rn.tags,
+		Filename: rn.GetFilename(),
+		Status:   "pending",
+		rn:       rn,
+	}
+	metrics.set(metricResources, float64(len(r.items)))
+}
+
+// unregister removes a resource from the registry
+func (r *statusRegistry) unregister(rn *VaultResource) {
+	r.Lock()
+	defer r.Unlock()
+	if x, found := r.items[rn.ID()]; found && x.rn == rn {
+		delete(r.items, rn.ID())
+	}
+	metrics.set(metricResources, float64(len(r.items)))
+}
+
+// success records a successful update of a resource
+func (r *statusRegistry) success(rn *VaultResource, meta *secretMetadata) {
+	r.update(rn, func(x *resourceStatus) {
+		now := time.Now()
+		x.Status = "ok"
+		x.LastSuccess = &now
+		x.Failures = 0
+		x.FailingSince = nil
+		x.LastError = ""
+		x.Violation = ""
+		x.Metadata = meta
+	})
+}
+
+// failure records a failed update of a resource
+//	rn			: the resource
+//	err			: the error of the update, if known
+func (r *statusRegistry) failure(rn *VaultResource, err error) {
+	r.update(rn, func(x *resourceStatus) {
+		now := time.Now()
+		x.Status = "failed"
+		x.LastFailure = &now
+		x.Failures++
+		if err != nil {
+			x.LastError = err.Error()
+		}
+		if x.FailingSince == nil {
+			x.FailingSince = &now
+		}
+	})
+}
+
+// pause pauses or resumes the updates of a resource, returning the status of the resource
+//	id			: the identifier of the resource
+//	paused		: whether to pause or resume the resource
+func (r *statusRegistry) pause(id string, paused bool) (resourceStatus, bool) {
+	r.Lock()
+	defer r.Unlock()
+	x, found := r.items[id]
+	if !found {
+		return resourceStatus{}, false
+	}
+	x.Paused = paused
+
+	return *x, true
+}
+
+// lookup returns the resource with the id
+func (r *statusRegistry) lookup(id string) (*VaultResource, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	x, found := r.items[id]
+	if !found {
+		return nil, false
+	}
+
+	return x.rn, true
+}
+
+// rotator returns the function rotating the static role of the resource with the id
+func (r *statusRegistry) rotator(id string) (func() error, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	x, found := r.items[id]
+	if !found {
+		return nil, false
+	}
+
+	return x.rotate, true
+}
+
+// refresher returns the function renewing the resource with the id
+func (r *statusRegistry) refresher(id string) (func() error, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	x, found := r.items[id]
+	if !found {
+		return nil, false
+	}
+
+	return x.refresh, true
+}
+
+// isPaused checks if the updates of the resource are paused
+func (r *statusRegistry) isPaused(rn *VaultResource) bool {
+	r.RLock()
+	defer r.RUnlock()
+	x, found := r.items[rn.ID()]
+
+	return found && x.Paused
+}
+
+// update applies a change to the status of a resource
+func (r *statusRegistry) update(rn *VaultResource, fn func(*resourceStatus)) {
+	r.Lock()
+	defer r.Unlock()
+	if x, found := r.items[rn.ID()]; found {
+		fn(x)
+	}
+}
+
+// list returns a copy of the status of the resources, ordered by id
+func (r *statusRegistry) list() []resourceStatus {
+	r.RLock()
+	defer r.RUnlock()
+	var list []resourceStatus
+	for _, x := range r.items {
+		list = append(list, *x)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	return list
+}
+
+// newAdminHandler creates the routes of the admin endpoint
+func newAdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, req *http.Request) {
+		if ok, reasons := readiness.ready(); !ok {
+			http.Error(w, strings.Join(reasons, "\n"), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.render(w)
+	})
+	mux.HandleFunc("/v1/resources", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "    ")
+		encoder.Encode(registry.list())
+	})
+	mux.HandleFunc("/v1/schedule", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "    ")
+		encoder.Encode(schedule.list(time.Now()))
+	})
+	mux.HandleFunc("/v1/token", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "    ")
+		encoder.Encode(tokens.list())
+	})
+	mux.HandleFunc("/v1/token/revoke", revokeHandler)
+	mux.HandleFunc("/v1/resources/pause", pauseHandler(true))
+	mux.HandleFunc("/v1/resources/resume", pauseHandler(false))
+	mux.HandleFunc("/v1/resources/rollback", rollbackHandler)
+	mux.HandleFunc("/v1/resources/rotate", rotateHandler)
+	mux.HandleFunc("/v1/debug/http", debugHTTPHandler)
+	mux.HandleFunc("/v1/shutdown", shutdownHandler)
+
+	return mux
+}
+
+// pauseHandler pauses or resumes the resource given by the id parameter i.e.
+// POST /v1/resources/pause?id=mysql:database/creds/app
+func pauseHandler(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := req.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "the id of the resource is required", http.StatusBadRequest)
+			return
+		}
+		status, found := registry.pause(id, paused)
+		if !found {
+			http.Error(w, "resource not found", http.StatusNotFound)
+			return
+		}
+		glog.Infof("resource: %s has been %s via the admin api", id, map[bool]string{true: "paused", false: "resumed"}[paused])
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "    ")
+		encoder.Encode(status)
+	}
+}
+
+// rollbackHandler restores the previous version of the file of the resource given by the id parameter, pausing
+// the resource so the next update doesn't replace it again i.e. POST /v1/resources/rollback?id=pki:pki/issue/web
+func rollbackHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := req.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "the id of the resource is required", http.StatusBadRequest)
+		return
+	}
+	rn, found := registry.lookup(id)
+	if !found {
+		http.Error(w, "resource not found", http.StatusNotFound)
+		return
+	}
+	status, _ := registry.pause(id, true)
+	if err := rollbackResource(rn); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	glog.Warningf("resource: %s has been rolled back to the previous version and paused via the admin api", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(status)
+}
+
+// rotateHandler rotates the password of the database static role of the resource given by the id parameter and
+// writes the new credentials i.e. POST /v1/resources/rotate?id=postgres:database/static-creds/app
+func rotateHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := req.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "the id of the resource is required", http.StatusBadRequest)
+		return
+	}
+	rotate, found := registry.rotator(id)
+	if !found {
+		http.Error(w, "resource not found", http.StatusNotFound)
+		return
+	}
+	if rotate == nil {
+		http.Error(w, "the resource does not have the rotate option set", http.StatusForbidden)
+		return
+	}
+	glog.Infof("rotating the resource: %s via the admin api", id)
+	if err := rotate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	for _, x := range registry.list() {
+		if x.ID == id {
+			encoder.Encode(x)
+		}
+	}
+}
+
+// revokeHandler revokes the tokens of the sidekick, along with their child tokens and leases, then exits as
+// there is nothing left to do without a token i.e. POST /v1/token/revoke
+func revokeHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	glog.Warningf("revoking the tokens via the admin api")
+	if err := tokens.revoke(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(tokens.list())
+	// step: the response is given a moment to be sent before exiting
+	time.AfterFunc(revokeExitDelay, func() {
+		glog.Infof("the tokens have been revoked, exiting")
+		exitWith(1)
+	})
+}
+
+// startAdminServer serves the health, metrics and admin api, reusing a listener handed over
+// from a previous process if one exists
+//	address		: the interface to listen on
+func startAdminServer(address string) (net.Listener, error) {
+	listener, err := inheritedListener()
+	if err != nil {
+		return nil, err
+	}
+	if listener != nil {
+		glog.Infof("serving the admin api on the inherited listener: %s", listener.Addr())
+	} else {
+		if listener, err = net.Listen("tcp", address); err != nil {
+			return nil, err
+		}
+		glog.Infof("serving the admin api on: %s", listener.Addr())
+	}
+	go func() {
+		if err := http.Serve(listener, newAdminHandler()); err != nil {
+			glog.V(4).Infof("the admin api has stopped, error: %s", err)
+		}
+	}()
+
+	return listener, nil
+}
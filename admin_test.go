@@ -0,0 +1,125 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminHandler(t *testing.T) {
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "secret/admin"
+	registry.register(rn)
+	registry.failure(rn, nil)
+	registry.success(rn, &secretMetadata{Resource: "secret", Path: "secret/admin"})
+
+	server := httptest.NewServer(newAdminHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/health")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/metrics")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+
+	schedule.set(rn, scheduleFetch, time.Minute, "the update option of 1m0s", time.Time{})
+	resp, err = http.Get(server.URL + "/v1/schedule")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var updates []scheduledUpdate
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&updates))
+	resp.Body.Close()
+	assert.NotEmpty(t, updates)
+
+	resp, err = http.Get(server.URL + "/v1/resources")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	var list []resourceStatus
+	if !assert.NoError(t, json.NewDecoder(resp.Body).Decode(&list)) {
+		t.FailNow()
+	}
+	var found *resourceStatus
+	for i := range list {
+		if list[i].ID == rn.ID() {
+			found = &list[i]
+		}
+	}
+	if assert.NotNil(t, found) {
+		assert.Equal(t, "ok", found.Status)
+		assert.Equal(t, 0, found.Failures)
+		assert.NotNil(t, found.LastFailure)
+		assert.NotNil(t, found.Metadata)
+	}
+}
+
+func TestAdminPauseResume(t *testing.T) {
+	rn := defaultVaultResource()
+	rn.resource = "mysql"
+	rn.path = "mysql/creds/pause"
+	registry.register(rn)
+
+	server := httptest.NewServer(newAdminHandler())
+	defer server.Close()
+
+	cases := []struct {
+		Method string
+		URI    string
+		Code   int
+		Paused bool
+	}{
+		{Method: "GET", URI: "/v1/resources/pause?id=" + rn.ID(), Code: http.StatusMethodNotAllowed},
+		{Method: "POST", URI: "/v1/resources/pause", Code: http.StatusBadRequest},
+		{Method: "POST", URI: "/v1/resources/pause?id=mysql:missing", Code: http.StatusNotFound},
+		{Method: "POST", URI: "/v1/resources/pause?id=" + rn.ID(), Code: http.StatusOK, Paused: true},
+		{Method: "POST", URI: "/v1/resources/resume?id=" + rn.ID(), Code: http.StatusOK},
+	}
+	for i, c := range cases {
+		req, _ := http.NewRequest(c.Method, server.URL+c.URI, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err, "case %d", i) {
+			continue
+		}
+		assert.Equal(t, c.Code, resp.StatusCode, "case %d", i)
+		if c.Code == http.StatusOK {
+			var status resourceStatus
+			if assert.NoError(t, json.NewDecoder(resp.Body).Decode(&status), "case %d", i) {
+				assert.Equal(t, c.Paused, status.Paused, "case %d", i)
+				assert.Equal(t, c.Paused, registry.isPaused(rn), "case %d", i)
+			}
+		}
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// agentSecretRegex matches the opening of a with secret block of a consul template
+	agentSecretRegex = regexp.MustCompile(`\{\{(-?)\s*with\s+secret\s+"([^"]+)"((?:\s+"[^"]*")*)\s*(-?)\}\}`)
+	// agentUnsupportedRegex matches the consul template functions the templates of the sidekick have no equivalent of
+	agentUnsupportedRegex = regexp.MustCompile(`\b(secrets|key|keyOrDefault|ls|tree|service|services|pkiCert|env|plugin|executeTemplate)\s+"`)
+	// agentDataRegex matches the data of the secret in a with secret block
+	agentDataRegex = regexp.MustCompile(`\.Data(\.|\b)`)
+)
+
+// agentConfig is the sidekick equivalent of a vault agent configuration
+type agentConfig struct {
+	// the environment of the sidekick
+	env map[string]string
+	// the arguments of the sidekick
+	args []string
+	// the templates converted, keyed by the path they're written to
+	templates map[string][]byte
+	// what couldn't be converted
+	warnings []string
+}
+
+// warn records something which couldn't be converted
+func (c *agentConfig) warn(format string, args ...interface{}) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, args...))
+}
+
+// convertAgentConfig converts the vault, auto_auth and template stanzas of a vault agent configuration
+//	content		: the hcl of the agent configuration
+//	templateDir	: the directory the converted templates are to be written to, the templates are left as they are if empty
+func convertAgentConfig(content []byte, templateDir string) (*agentConfig, error) {
+	var doc map[string]interface{}
+	if err := hcl.Decode(&doc, string(content)); err != nil {
+		return nil, fmt.Errorf("unable to parse the agent configuration, error: %s", err)
+	}
+	cfg := &agentConfig{
+		env:       make(map[string]string, 0),
+		templates: make(map[string][]byte, 0),
+	}
+
+	for _, vault := range hclBlocks(doc, "vault") {
+		convertAgentVault(cfg, vault)
+	}
+	for _, auth := range hclBlocks(doc, "auto_auth") {
+		methods := hclBlocks(auth, "method")
+		if len(methods) > 1 {
+			cfg.warn("only the first of the %d auto_auth methods has been converted", len(methods))
+		}
+		if len(methods) > 0 {
+			convertAgentMethod(cfg, methods[0])
+		}
+		if len(hclBlocks(auth, "sink")) > 0 {
+			cfg.warn("the sinks of auto_auth have been ignored, the sidekick never writes its token out")
+		}
+	}
+	for i, x := range hclBlocks(doc, "template") {
+		convertAgentTemplate(cfg, i, x, templateDir)
+	}
+
+	return cfg, nil
+}
+
+// convertAgentVault converts the vault stanza into the arguments of the sidekick
+func convertAgentVault(cfg *agentConfig, vault map[string]interface{}) {
+	for _, x := range []struct{ key, arg string }{
+		{"address", "-vault"},
+		{"ca_cert", "-ca-cert"},
+		{"ca_path", "-ca-path"},
+		{"client_cert", "-client-cert"},
+		{"client_key", "-client-key"},
+		{"tls_server_name", "-tls-server-name"},
+		{"namespace", "-namespace"},
+	} {
+		if value := hclString(vault, x.key); value != "" {
+			cfg.args = append(cfg.args, fmt.Sprintf("%s=%s", x.arg, value))
+		}
+	}
+	if hclString(vault, "tls_skip_verify") == "true" {
+		cfg.args = append(cfg.args, "-tls-skip-verify=true")
+	}
+}
+
+// convertAgentMethod converts an auto_auth method into the environment of the sidekick; the method is given
+// either as the label of the block or its type attribute
+func convertAgentMethod(cfg *agentConfig, method map[string]interface{}) {
+	kind := hclString(method, "type")
+	if kind == "" && len(method) == 1 {
+		for label, x := range method {
+			if blocks := hclBlocks(method, label); len(blocks) > 0 {
+				kind, method = label, blocks[0]
+			} else {
+				cfg.warn("the auto_auth method: %v is not understood", x)
+			}
+		}
+	}
+	var config map[string]interface{}
+	if blocks := hclBlocks(method, "config"); len(blocks) > 0 {
+		config = blocks[0]
+	}
+	mountPath := strings.Trim(hclString(method, "mount_path"), "/")
+
+	switch kind {
+	case "kubernetes":
+		cfg.env["VAULT_AUTH_METHOD"] = "kubernetes"
+		cfg.env["VAULT_SIDEKICK_ROLE"] = hclString(config, "role")
+		if mountPath != "" && mountPath != "auth/kubernetes" {
+			cfg.env["VAULT_K8S_LOGIN_PATH"] = fmt.Sprintf("/v1/%s/login", mountPath)
+		}
+		if tokenPath := hclString(config, "token_path"); tokenPath != "" {
+			cfg.env["VAULT_K8S_TOKEN_PATH"] = tokenPath
+		}
+		return
+	case "approle":
+		cfg.env["VAULT_AUTH_METHOD"] = "approle"
+		cfg.warn("the approle role and secret id are read from %s and %s by the agent, the sidekick takes them from VAULT_SIDEKICK_ROLE_ID and VAULT_SIDEKICK_SECRET_ID",
+			hclString(config, "role_id_file_path"), hclString(config, "secret_id_file_path"))
+	case "aws":
+		if hclString(config, "type") != "ec2" {
+			cfg.warn("only the ec2 type of the aws auth method is supported by the sidekick")
+			return
+		}
+		cfg.env["VAULT_AUTH_METHOD"] = "aws-ec2"
+		cfg.env["VAULT_SIDEKICK_ROLE_ID"] = hclString(config, "role")
+	case "gcp":
+		if hclString(config, "type") != "gce" {
+			cfg.warn("only the gce type of the gcp auth method is supported by the sidekick")
+			return
+		}
+		cfg.env["VAULT_AUTH_METHOD"] = "gcp-gce"
+		cfg.env["VAULT_SIDEKICK_ROLE_ID"] = hclString(config, "role")
+	case "token_file":
+		cfg.env["VAULT_AUTH_METHOD"] = "token"
+		cfg.warn("the token is read from %s by the agent, the sidekick takes it from VAULT_TOKEN", hclString(config, "token_file_path"))
+		return
+	default:
+		cfg.warn("the auto_auth method: %s is not supported by the sidekick", kind)
+		return
+	}
+	if mountPath != "" && mountPath != "auth/"+kind {
+		cfg.warn("the mount path: %s of the %s auth method is not supported, the sidekick logs in at the default path", mountPath, kind)
+	}
+}
+
+// convertAgentTemplate converts a template stanza into a tpl resource, the with secret blocks of the consul
+// template are rewritten as with blocks over the secrets of the resource
+//	cfg			: the configuration being converted
+//	index		: the index of the template stanza
+//	stanza		: the template stanza
+//	templateDir	: the directory the converted template is written to
+func convertAgentTemplate(cfg *agentConfig, index int, stanza map[string]interface{}, templateDir string) {
+	destination := hclString(stanza, "destination")
+	if destination == "" {
+		cfg.warn("the template: %d has no destination, skipping", index)
+		return
+	}
+	source := hclString(stanza, "source")
+	content := []byte(hclString(stanza, "contents"))
+	if source != "" {
+		var err error
+		if content, err = ioutil.ReadFile(source); err != nil {
+			cfg.warn("unable to read the template: %s, skipping, error: %s", source, err)
+			return
+		}
+	}
+
+	// step: the secrets of the template are merged into the one resource
+	var paths []string
+	for _, x := range agentSecretRegex.FindAllSubmatch(content, -1) {
+		if len(x[3]) > 0 {
+			cfg.warn("the template: %s writes to the secret: %s, only reads are supported, skipping", destination, x[2])
+			return
+		}
+		if !containsName(paths, string(x[2])) {
+			paths = append(paths, string(x[2]))
+		}
+	}
+	if len(paths) == 0 {
+		cfg.warn("the template: %s reads no secrets, skipping", destination)
+		return
+	}
+	if len(paths) > 1 {
+		cfg.warn("the template: %s reads %d secrets, merged into one resource keys may clash", destination, len(paths))
+	}
+	if x := agentUnsupportedRegex.Find(content); x != nil {
+		cfg.warn("the template: %s uses the function: %s which has no equivalent in the sidekick", destination, strings.Fields(string(x))[0])
+	}
+
+	// step: write the converted template if a directory was given, otherwise the template is left as it was
+	templateFile := source
+	if templateDir != "" {
+		templateFile = filepath.Join(templateDir, filepath.Base(destination)+".tpl")
+		cfg.templates[templateFile] = rewriteAgentTemplate(content)
+	} else if source != "" {
+		cfg.warn("the template: %s is in the consul template syntax, convert it with -template-dir", source)
+	} else {
+		cfg.warn("the template: %s is inline, a -template-dir is required to write it out, skipping", destination)
+		return
+	}
+
+	options := []string{"tpl=" + quoteSpecValue(templateFile, ":"), "file=" + quoteSpecValue(destination, ":")}
+	if perms := hclString(stanza, "perms"); perms != "" {
+		options = append(options, "mode="+perms)
+	}
+	if command := agentCommand(stanza); command != "" {
+		options = append(options, "exec="+quoteSpecValue(command, ":"))
+	}
+	resource := fmt.Sprintf("tpl:%s:%s", strings.Join(paths, ","), strings.Join(options, ","))
+
+	// step: catch a resource the sidekick wouldn't accept here, rather than when it starts
+	var resources VaultResources
+	if err := resources.Set(resource); err != nil {
+		cfg.warn("the template: %s produced an invalid resource: %s, error: %s", destination, resource, err)
+		return
+	}
+	cfg.args = append(cfg.args, "-cn="+resource)
+}
+
+// rewriteAgentTemplate rewrites the with secret blocks of a consul template as with blocks over the secrets of
+// the tpl resource, the data of a secret being the secret itself
+func rewriteAgentTemplate(content []byte) []byte {
+	content = agentSecretRegex.ReplaceAll(content, []byte("{{$1 with .Secrets $4}}"))
+
+	return agentDataRegex.ReplaceAll(content, []byte("."))
+}
+
+// agentCommand returns the command of the template stanza, given as a string or a list
+func agentCommand(stanza map[string]interface{}) string {
+	switch x := stanza["command"].(type) {
+	case string:
+		return x
+	case []interface{}:
+		var list []string
+		for _, v := range x {
+			list = append(list, fmt.Sprintf("%v", v))
+		}
+		return strings.Join(list, " ")
+	}
+
+	return ""
+}
+
+// hclBlocks returns the blocks of the key
+func hclBlocks(m map[string]interface{}, key string) []map[string]interface{} {
+	list, _ := m[key].([]map[string]interface{})
+
+	return list
+}
+
+// hclString returns the value of the key as a string, empty if not found
+func hclString(m map[string]interface{}, key string) string {
+	x, found := m[key]
+	if !found {
+		return ""
+	}
+	if value, ok := x.(string); ok {
+		return value
+	}
+
+	return fmt.Sprintf("%v", x)
+}
+
+// runImportAgentConfigCommand converts a vault agent configuration into the environment and arguments of the
+// sidekick, printed as the env and args of a container, returning the exit code
+//	args		: the arguments following the command
+//	output		: the writer the configuration is printed to
+func runImportAgentConfigCommand(args []string, output io.Writer) int {
+	flags := flag.NewFlagSet("import-agent-config", flag.ContinueOnError)
+	templateDir := flags.String("template-dir", "", "the directory the converted templates are written to, the templates are referenced as they are if empty")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "[error] the agent configuration must be given, i.e. vault-sidekick import-agent-config agent.hcl")
+		return 1
+	}
+	content, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[error] unable to read the agent configuration, error: %s\n", err)
+		return 1
+	}
+	cfg, err := convertAgentConfig(content, *templateDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[error] %s\n", err)
+		return 1
+	}
+	for filename, content := range cfg.templates {
+		if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "[error] unable to write the template: %s, error: %s\n", filename, err)
+			return 1
+		}
+	}
+	for _, x := range cfg.warnings {
+		fmt.Fprintf(os.Stderr, "[warning] %s\n", x)
+	}
+
+	var names []string
+	for name := range cfg.env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var env []interface{}
+	for _, name := range names {
+		env = append(env, yaml.MapSlice{{Key: "name", Value: name}, {Key: "value", Value: cfg.env[name]}})
+	}
+	var list []interface{}
+	for _, x := range cfg.args {
+		list = append(list, x)
+	}
+	encoded, err := yaml.Marshal(yaml.MapSlice{{Key: "env", Value: env}, {Key: "args", Value: list}})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[error] %s\n", err)
+		return 1
+	}
+	output.Write(encoded)
+
+	return 0
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertAgentConfig(t *testing.T) {
+	agent := `
+vault {
+  address = "https://vault.example.com:8200"
+  ca_cert = "/etc/ssl/vault-ca.pem"
+}
+
+auto_auth {
+  method "kubernetes" {
+    mount_path = "auth/k8s"
+    config = {
+      role = "app"
+    }
+  }
+  sink "file" {
+    config = {
+      path = "/home/vault/.token"
+    }
+  }
+}
+
+template {
+  destination = "/etc/secrets/app.conf"
+  perms = "0640"
+  command = "pkill -HUP app"
+  contents = <<EOT
+{{- with secret "secret/data/app" -}}
+password={{ .Data.data.password }}
+{{- end }}
+EOT
+}
+
+template {
+  destination = "/etc/secrets/db.conf"
+  contents = "{{ with secret \"database/creds/app\" }}{{ .Data.username }}{{ end }}"
+}
+`
+	cfg, err := convertAgentConfig([]byte(agent), "/etc/templates")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, map[string]string{
+		"VAULT_AUTH_METHOD":    "kubernetes",
+		"VAULT_SIDEKICK_ROLE":  "app",
+		"VAULT_K8S_LOGIN_PATH": "/v1/auth/k8s/login",
+	}, cfg.env)
+	assert.Equal(t, []string{
+		"-vault=https://vault.example.com:8200",
+		"-ca-cert=/etc/ssl/vault-ca.pem",
+		"-cn=tpl:secret/data/app:tpl=/etc/templates/app.conf.tpl,file=/etc/secrets/app.conf,mode=0640,exec=pkill -HUP app",
+		"-cn=tpl:database/creds/app:tpl=/etc/templates/db.conf.tpl,file=/etc/secrets/db.conf",
+	}, cfg.args)
+	assert.Equal(t, "{{- with .Secrets -}}\npassword={{ .data.password }}\n{{- end }}\n", string(cfg.templates["/etc/templates/app.conf.tpl"]))
+	assert.Equal(t, "{{ with .Secrets }}{{ .username }}{{ end }}", string(cfg.templates["/etc/templates/db.conf.tpl"]))
+	assert.Len(t, cfg.warnings, 1)
+}
+
+func TestConvertAgentConfigUnsupported(t *testing.T) {
+	agent := `
+auto_auth {
+  method {
+    type = "azure"
+  }
+}
+
+template {
+  destination = "/etc/secrets/cert.pem"
+  contents = "{{ with secret \"pki/issue/web\" \"common_name=web\" }}{{ .Data.certificate }}{{ end }}"
+}
+
+template {
+  destination = "/etc/secrets/inline.conf"
+  contents = "{{ with secret \"secret/app\" }}{{ .Data.value }}{{ end }}"
+}
+`
+	cfg, err := convertAgentConfig([]byte(agent), "")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Empty(t, cfg.env)
+	assert.Empty(t, cfg.args)
+	assert.Len(t, cfg.warnings, 3)
+
+	_, err = convertAgentConfig([]byte("template {"), "")
+	assert.Error(t, err)
+}
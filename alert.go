@@ -0,0 +1,200 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// alertInterval is the interval the resources are checked for sustained failures
+var alertInterval = time.Duration(30) * time.Second
+
+// defaultAlertTemplate is the payload of an alert unless a template is given
+const defaultAlertTemplate = `{"action":{{toJson .Action}},"key":{{toJson .Key}},"summary":{{toJson .Summary}},"resource":{{toJson .ID}},` +
+	`"host":{{toJson .Host}},"failing_since":{{toJson .Since}},"failures":{{.Failures}},"violation":{{toJson .Violation}}}`
+
+// alertEvent is the data of the payload template of an alert
+type alertEvent struct {
+	// trigger when the resource has been failing past the threshold, resolve once it recovers
+	Action string
+	// a key identifying the resource on the host, to deduplicate the events
+	Key string
+	// a one line description of the alert
+	Summary string
+	// the identifier of the resource
+	ID string
+	// the resource type
+	Resource string
+	// the path of the resource
+	Path string
+	// the named vault of the resource
+	Vault string
+	// the filename of the resource
+	Filename string
+	// the name of the pod or host
+	Host string
+	// the time of the first of the consecutive failures
+	Since time.Time
+	// the number of consecutive failures
+	Failures int
+	// the policy the secret of the resource broke, empty unless it's failing on it
+	Violation string
+}
+
+// alertEmitter posts an event to a webhook when a resource has failed to update for longer than the threshold,
+// and again once it recovers, so a renewal failing for a while pages someone without scraping the metrics
+type alertEmitter struct {
+	// the url of the webhook
+	url string
+	// an additional header of the requests i.e. Authorization: GenieKey KEY
+	header string
+	// the time a resource has to be failing for
+	threshold time.Duration
+	// the template of the payload
+	payload *template.Template
+	// the http client
+	client *http.Client
+	// the resources alerted on, by id
+	alerted map[string]bool
+}
+
+// newAlertEmitter creates the emitter, the payload is read from the template file if given
+//	url			: the url of the webhook
+//	header		: an additional header of the requests, NAME: VALUE
+//	templateFile: the file holding the template of the payload, the generic payload if empty
+//	threshold	: the time a resource has to be failing for
+func newAlertEmitter(url, header, templateFile string, threshold time.Duration) (*alertEmitter, error) {
+	text := defaultAlertTemplate
+	if templateFile != "" {
+		content, err := ioutil.ReadFile(templateFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the alert template: %s, error: %s", templateFile, err)
+		}
+		text = string(content)
+	}
+	payload, err := template.New("alert").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("the alert template is invalid, error: %s", err)
+	}
+
+	return &alertEmitter{
+		url:       url,
+		header:    header,
+		threshold: threshold,
+		payload:   payload,
+		client:    &http.Client{Timeout: time.Duration(10) * time.Second},
+		alerted:   make(map[string]bool, 0),
+	}, nil
+}
+
+// start checks the resources on the interval
+func (a *alertEmitter) start(interval time.Duration) {
+	go func() {
+		for range time.NewTicker(interval).C {
+			a.check(time.Now())
+		}
+	}()
+}
+
+// check triggers an alert for each resource failing past the threshold, or at once for a secret breaking the policy
+// of its resource, and resolves those which have recovered; an event which couldn't be sent is sent again on the
+// next check
+func (a *alertEmitter) check(now time.Time) {
+	for _, x := range registry.list() {
+		failing := x.FailingSince != nil && (now.Sub(*x.FailingSince) >= a.threshold || x.Violation != "")
+		switch {
+		case failing && !a.alerted[x.ID]:
+			if err := a.send(a.event("trigger", x, now)); err != nil {
+				glog.Errorf("unable to send the alert for resource: %s, error: %s", x.ID, err)
+				continue
+			}
+			glog.Warningf("resource: %s has been failing since %s, alert sent", x.ID, x.FailingSince.Format(time.RFC3339))
+			a.alerted[x.ID] = true
+		case x.FailingSince == nil && a.alerted[x.ID]:
+			if err := a.send(a.event("resolve", x, now)); err != nil {
+				glog.Errorf("unable to resolve the alert for resource: %s, error: %s", x.ID, err)
+				continue
+			}
+			glog.Infof("resource: %s has recovered, alert resolved", x.ID)
+			delete(a.alerted, x.ID)
+		}
+	}
+}
+
+// event builds the event of the resource
+func (a *alertEmitter) event(action string, x resourceStatus, now time.Time) alertEvent {
+	host := podName()
+	event := alertEvent{
+		Action:    action,
+		Key:       fmt.Sprintf("%s/%s", host, x.ID),
+		ID:        x.ID,
+		Resource:  x.Resource,
+		Path:      x.Path,
+		Vault:     x.Vault,
+		Filename:  x.Filename,
+		Host:      host,
+		Failures:  x.Failures,
+		Violation: x.Violation,
+	}
+	switch {
+	case x.FailingSince != nil && x.Violation != "":
+		event.Since = *x.FailingSince
+		event.Summary = fmt.Sprintf("%s on %s refused the secret of the resource: %s, %s", prog, host, x.ID, x.Violation)
+	case x.FailingSince != nil:
+		event.Since = *x.FailingSince
+		event.Summary = fmt.Sprintf("%s on %s has failed to update the resource: %s for %s", prog, host, x.ID, now.Sub(event.Since).Truncate(time.Second))
+	default:
+		event.Summary = fmt.Sprintf("%s on %s has recovered the resource: %s", prog, host, x.ID)
+	}
+
+	return event
+}
+
+// send posts the event to the webhook
+func (a *alertEmitter) send(event alertEvent) error {
+	body := &bytes.Buffer{}
+	if err := a.payload.Execute(body, event); err != nil {
+		return fmt.Errorf("unable to render the alert template, error: %s", err)
+	}
+	request, err := http.NewRequest("POST", a.url, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if a.header != "" {
+		items := strings.SplitN(a.header, ":", 2)
+		request.Header.Set(strings.TrimSpace(items[0]), strings.TrimSpace(items[1]))
+	}
+	resp, err := a.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d from the webhook", resp.StatusCode)
+	}
+
+	return nil
+}
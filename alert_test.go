@@ -0,0 +1,120 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlertEmitter(t *testing.T) {
+	var lock sync.Mutex
+	var events []map[string]interface{}
+	var headers []string
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+		headers = append(headers, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	original := registry
+	registry = newStatusRegistry()
+	defer func() { registry = original }()
+	rn := defaultVaultResource()
+	rn.resource, rn.path = "aws", "aws/creds/app"
+	registry.register(rn)
+
+	alerts, err := newAlertEmitter(server.URL, "Authorization: GenieKey abc", "", time.Duration(10)*time.Minute)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	registry.failure(rn, nil)
+	since := *registry.list()[0].FailingSince
+	registry.failure(rn, nil)
+	assert.Equal(t, since, *registry.list()[0].FailingSince)
+
+	// step: nothing is sent within the threshold, and an event the webhook rejects is sent again
+	alerts.check(since.Add(time.Minute))
+	alerts.check(since.Add(time.Duration(11) * time.Minute))
+	assert.Empty(t, events)
+	lock.Lock()
+	failing = false
+	lock.Unlock()
+	alerts.check(since.Add(time.Duration(12) * time.Minute))
+	alerts.check(since.Add(time.Duration(13) * time.Minute))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "trigger", events[0]["action"])
+		assert.Equal(t, rn.ID(), events[0]["resource"])
+		assert.Equal(t, float64(2), events[0]["failures"])
+		assert.Equal(t, "GenieKey abc", headers[0])
+	}
+
+	// step: the recovery resolves the alert
+	registry.success(rn, nil)
+	assert.Nil(t, registry.list()[0].FailingSince)
+	alerts.check(time.Now())
+	alerts.check(time.Now())
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "resolve", events[1]["action"])
+		assert.Equal(t, events[0]["key"], events[1]["key"])
+	}
+}
+
+func TestAlertTemplate(t *testing.T) {
+	file, err := ioutil.TempFile("", "alert")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"routing_key":"abc","event_action":{{toJson .Action}},"dedup_key":{{toJson .Key}},"payload":{"summary":{{toJson .Summary}},"source":{{toJson .Host}},"severity":"critical"}}`)
+	file.Close()
+
+	alerts, err := newAlertEmitter("http://127.0.0.1", "", file.Name(), time.Minute)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	since := time.Now().Add(-time.Hour)
+	x := resourceStatus{ID: "aws:aws/creds/app", FailingSince: &since, Failures: 3}
+	event := alerts.event("trigger", x, time.Now())
+	assert.Contains(t, event.Summary, "aws:aws/creds/app for 1h0m0s")
+
+	_, err = newAlertEmitter("http://127.0.0.1", "", "/nonexistent", time.Minute)
+	assert.Error(t, err)
+	file, _ = ioutil.TempFile("", "alert")
+	defer os.Remove(file.Name())
+	file.WriteString(`{{ .Action `)
+	file.Close()
+	_, err = newAlertEmitter("http://127.0.0.1", "", file.Name(), time.Minute)
+	assert.Error(t, err)
+}
@@ -61,11 +61,6 @@ func (r authAppRolePlugin) Create(cfg *vaultAuthOptions) (string, error) {
 	}
 	defer resp.Body.Close()
 
-	// step: parse and return auth
-	secret, err := api.ParseSecret(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	return secret.Auth.ClientToken, nil
+	// step: parse the token, completing any mfa
+	return loginToken(r.client, resp.Body)
 }
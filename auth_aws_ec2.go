@@ -69,12 +69,7 @@ func (r authAWSEC2Plugin) Create(cfg *vaultAuthOptions) (string, error) {
 		payload["nonce"] = string(nonce)
 	}
 
-	resp, err := r.client.Logical().Write("auth/aws/login", payload)
-	if err != nil {
-		return "", err
-	}
-
-	return resp.Auth.ClientToken, nil
+	return writeLogin(r.client, "auth/aws/login", payload)
 }
 
 func getAWSIdentityDocument() ([]byte, error) {
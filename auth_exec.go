@@ -0,0 +1,105 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// execAuthProtocolVersion is the version of the exec protocol passed to the provider
+const execAuthProtocolVersion = "1"
+
+// the exec authentication plugin, delegating the login to an external provider
+type authExecPlugin struct {
+	// the vault client
+	client *api.Client
+	// the timeout on the provider
+	timeout time.Duration
+}
+
+// execAuthResponse is the json printed on stdout by the provider, either a token or the
+// response of a vault login i.e. vault login -format=json
+type execAuthResponse struct {
+	// the vault token
+	Token string `json:"token"`
+	// the auth section of a vault login response
+	Auth *struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+}
+
+// NewExecPlugin creates a new exec plugin
+func NewExecPlugin(client *api.Client, timeout time.Duration) AuthInterface {
+	return &authExecPlugin{
+		client:  client,
+		timeout: timeout,
+	}
+}
+
+// Create runs the provider and reads the token it prints on stdout
+func (r authExecPlugin) Create(cfg *vaultAuthOptions) (string, error) {
+	command, args := cfg.Command, cfg.Args
+	if command == "" {
+		fields := strings.Fields(os.Getenv("VAULT_SIDEKICK_AUTH_COMMAND"))
+		if len(fields) == 0 {
+			return "", fmt.Errorf("no auth command provided")
+		}
+		command, args = fields[0], fields[1:]
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(command, args...)
+	cmd.Env = append(os.Environ(),
+		"VAULT_ADDR="+r.client.Address(),
+		"VAULT_SIDEKICK_AUTH_PROTOCOL="+execAuthProtocolVersion)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("unable to run the auth command: %s, error: %s", command, err)
+	}
+	timer := time.AfterFunc(r.timeout, func() {
+		cmd.Process.Kill()
+	})
+	defer timer.Stop()
+
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("the auth command: %s failed: %s, %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	// step: parse the token from the output
+	var resp execAuthResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return "", fmt.Errorf("unable to decode the output of the auth command: %s, error: %s", command, err)
+	}
+	token := resp.Token
+	if token == "" && resp.Auth != nil {
+		token = resp.Auth.ClientToken
+	}
+	if token == "" {
+		return "", fmt.Errorf("the auth command: %s did not return a token", command)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,79 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth-exec")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	client, err := api.NewClient(&api.Config{Address: "http://127.0.0.1:8200"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	plugin := NewExecPlugin(client, 10*time.Second)
+
+	cases := []struct {
+		Script string
+		Token  string
+	}{
+		{Script: `echo '{"token": "s.provider"}'`, Token: "s.provider"},
+		{Script: `echo '{"auth": {"client_token": "s.login"}}'`, Token: "s.login"},
+		{Script: `echo "{\"token\": \"$VAULT_ADDR|$VAULT_SIDEKICK_AUTH_PROTOCOL\"}"`, Token: "http://127.0.0.1:8200|1"},
+		{Script: `echo '{}'`},
+		{Script: `echo 'not json'`},
+		{Script: `echo 'denied' >&2; exit 1`},
+	}
+	for i, c := range cases {
+		script := filepath.Join(dir, "provider.sh")
+		if !assert.NoError(t, ioutil.WriteFile(script, []byte("#!/bin/sh\n"+c.Script+"\n"), 0755)) {
+			t.FailNow()
+		}
+		token, err := plugin.Create(&vaultAuthOptions{Command: "/bin/sh", Args: []string{script}})
+		if c.Token == "" {
+			assert.Error(t, err, "case %d, expected an error", i)
+			continue
+		}
+		if assert.NoError(t, err, "case %d", i) {
+			assert.Equal(t, c.Token, token, "case %d", i)
+		}
+	}
+
+	// step: the command can be given in the environment
+	os.Setenv("VAULT_SIDEKICK_AUTH_COMMAND", "/bin/echo {\"token\":\"s.env\"}")
+	defer os.Unsetenv("VAULT_SIDEKICK_AUTH_COMMAND")
+	token, err := plugin.Create(&vaultAuthOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "s.env", token)
+	}
+}
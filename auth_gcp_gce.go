@@ -59,12 +59,7 @@ func (r authGCPGCEPlugin) Create(cfg *vaultAuthOptions) (string, error) {
 		"jwt":  string(jwtToken),
 	}
 
-	resp, err := r.client.Logical().Write("auth/gcp/login", payload)
-	if err != nil {
-		return "", err
-	}
-
-	return resp.Auth.ClientToken, nil
+	return writeLogin(r.client, "auth/gcp/login", payload)
 }
 
 // getGCPServiceAccountToken retrieves a JWT token from GCP metadata service
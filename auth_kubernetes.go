@@ -74,11 +74,6 @@ func (r authKubernetesPlugin) Create(cfg *vaultAuthOptions) (string, error) {
 	}
 	defer resp.Body.Close()
 
-	// parse the auth object into something useful
-	secret, err := api.ParseSecret(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	return secret.Auth.ClientToken, nil
+	// parse the token, completing any mfa
+	return loginToken(r.client, resp.Body)
 }
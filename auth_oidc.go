@@ -0,0 +1,163 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+// oidcLoginTimeout is the time the user has to complete the login in the browser
+var oidcLoginTimeout = time.Duration(2) * time.Minute
+
+// the oidc authentication plugin, an interactive login in the browser
+type authOIDCPlugin struct {
+	// vault client
+	client *api.Client
+}
+
+type oidcAuthURLRequest struct {
+	Role        string `json:"role,omitempty"`
+	RedirectURI string `json:"redirect_uri"`
+	ClientNonce string `json:"client_nonce"`
+}
+
+// oidcCallback is the redirect of the browser once the user has logged in with the provider
+type oidcCallback struct {
+	state string
+	code  string
+	err   error
+}
+
+// NewOIDCPlugin creates a new OIDC plugin
+func NewOIDCPlugin(client *api.Client) AuthInterface {
+	return &authOIDCPlugin{
+		client: client,
+	}
+}
+
+// Create logs in via the browser, as vault login -method=oidc does; the provider redirects the browser back to a
+// listener on the local host, whose address must be one of the allowed_redirect_uris of the role
+func (r authOIDCPlugin) Create(cfg *vaultAuthOptions) (string, error) {
+	role := os.Getenv("VAULT_SIDEKICK_ROLE")
+	mount := getEnv("VAULT_OIDC_MOUNT_PATH", "oidc")
+
+	// step: listen for the callback before the provider can redirect to it
+	address := getEnv("VAULT_OIDC_CALLBACK_ADDR", "localhost:8250")
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return "", fmt.Errorf("unable to listen for the oidc callback, error: %s", err)
+	}
+	defer listener.Close()
+	host, _, _ := net.SplitHostPort(address)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	redirect := fmt.Sprintf("http://%s/oidc/callback", net.JoinHostPort(host, port))
+
+	// step: ask vault for the url of the provider
+	nonce := newTraceID(16)
+	request := r.client.NewRequest("POST", fmt.Sprintf("/v1/auth/%s/oidc/auth_url", mount))
+	if err := request.SetJSONBody(oidcAuthURLRequest{Role: role, RedirectURI: redirect, ClientNonce: nonce}); err != nil {
+		return "", err
+	}
+	resp, err := r.client.RawRequest(request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var authURL struct {
+		Data struct {
+			AuthURL string `json:"auth_url"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&authURL); err != nil {
+		return "", err
+	}
+	if authURL.Data.AuthURL == "" {
+		return "", fmt.Errorf("no oidc auth url was returned, check the role: %q allows the redirect uri: %s", role, redirect)
+	}
+
+	// step: send the user to the provider and wait for the redirect back
+	callbacks := make(chan oidcCallback, 1)
+	server := &http.Server{Handler: oidcCallbackHandler(callbacks)}
+	go server.Serve(listener)
+	defer server.Close()
+
+	fmt.Fprintf(os.Stderr, "complete the login in your browser, or open: %s\n", authURL.Data.AuthURL)
+	if err := openBrowser(authURL.Data.AuthURL); err != nil {
+		glog.Warningf("unable to open the browser, error: %s", err)
+	}
+
+	var callback oidcCallback
+	select {
+	case callback = <-callbacks:
+	case <-time.After(oidcLoginTimeout):
+		return "", fmt.Errorf("the oidc login was not completed within %s", oidcLoginTimeout)
+	}
+	if callback.err != nil {
+		return "", callback.err
+	}
+
+	// step: exchange the code for a token
+	request = r.client.NewRequest("GET", fmt.Sprintf("/v1/auth/%s/oidc/callback", mount))
+	request.Params.Set("state", callback.state)
+	request.Params.Set("code", callback.code)
+	request.Params.Set("client_nonce", nonce)
+	resp, err = r.client.RawRequest(request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// step: parse the token, completing any mfa
+	return loginToken(r.client, resp.Body)
+}
+
+// oidcCallbackHandler handles the redirect of the browser from the provider, passing on the state and code
+//	callbacks	: the channel the callback is passed on
+func oidcCallbackHandler(callbacks chan oidcCallback) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/oidc/callback" {
+			http.NotFound(w, req)
+			return
+		}
+		query := req.URL.Query()
+		callback := oidcCallback{state: query.Get("state"), code: query.Get("code")}
+		if x := query.Get("error"); x != "" {
+			callback.err = fmt.Errorf("the oidc login failed, error: %s %s", x, query.Get("error_description"))
+		} else if callback.code == "" {
+			callback.err = fmt.Errorf("the oidc callback carried no code")
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if callback.err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "<html><body>%s login failed, see the terminal.</body></html>", prog)
+		} else {
+			fmt.Fprintf(w, "<html><body>%s is logged in to vault, you can close this window.</body></html>", prog)
+		}
+		select {
+		case callbacks <- callback:
+		default:
+		}
+	})
+}
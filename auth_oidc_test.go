@@ -0,0 +1,81 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	var nonce string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/oidc/oidc/auth_url":
+			var request oidcAuthURLRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			nonce = request.ClientNonce
+			provider := "https://idp.example.com/authorize?" + url.Values{"redirect_uri": {request.RedirectURI}, "state": {"st"}}.Encode()
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"auth_url": provider}})
+		case "/v1/auth/oidc/oidc/callback":
+			query := r.URL.Query()
+			if query.Get("state") != "st" || query.Get("code") != "abc" || query.Get("client_nonce") != nonce {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": "dev-token"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := newAPIClient(&config{vaultMaxRetries: 0}, server.URL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	os.Setenv("VAULT_OIDC_CALLBACK_ADDR", "127.0.0.1:0")
+	defer os.Unsetenv("VAULT_OIDC_CALLBACK_ADDR")
+
+	// step: the browser is the provider redirecting straight back
+	original := openBrowser
+	defer func() { openBrowser = original }()
+	openBrowser = func(location string) error {
+		u, _ := url.Parse(location)
+		redirect := u.Query().Get("redirect_uri") + "?" + url.Values{"state": {u.Query().Get("state")}, "code": {"abc"}}.Encode()
+		go http.Get(redirect)
+		return nil
+	}
+	token, err := NewOIDCPlugin(client).Create(&vaultAuthOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "dev-token", token)
+
+	// step: an error from the provider fails the login
+	openBrowser = func(location string) error {
+		u, _ := url.Parse(location)
+		go http.Get(u.Query().Get("redirect_uri") + "?error=access_denied")
+		return nil
+	}
+	_, err = NewOIDCPlugin(client).Create(&vaultAuthOptions{})
+	assert.Error(t, err)
+}
@@ -52,10 +52,24 @@ func (r authTokenPlugin) Create(cfg *vaultAuthOptions) (string, error) {
 		return token, nil
 	}
 
+	// step: check for a token given directly
+	if cfg.Token != "" {
+		return cfg.Token, nil
+	}
+
 	// step: check the VAULT_TOKEN
 	if val := os.Getenv("VAULT_TOKEN"); val != "" {
 		return val, nil
 	}
 
+	// step: fall back to the token stored by the vault cli
+	token, err := cliToken()
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		return token, nil
+	}
+
 	return "", fmt.Errorf("no token provided")
 }
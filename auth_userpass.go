@@ -62,11 +62,6 @@ func (r authUserPassPlugin) Create(cfg *vaultAuthOptions) (string, error) {
 	}
 	defer resp.Body.Close()
 
-	// step: parse and return auth
-	secret, err := api.ParseSecret(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	return secret.Auth.ClientToken, nil
+	// step: parse the token, completing any mfa
+	return loginToken(r.client, resp.Body)
 }
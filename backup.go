@@ -0,0 +1,271 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// backupSuffix is the suffix of the previous version of a file, older versions are numbered .prev.1, .prev.2 and so on
+const backupSuffix = ".prev"
+
+// backupFilename returns the filename of a previous version of the file, zero being the latest
+//	filename	: the file
+//	n			: the age of the version
+func backupFilename(filename string, n int) string {
+	if n == 0 {
+		return filename + backupSuffix
+	}
+
+	return fmt.Sprintf("%s%s.%d", filename, backupSuffix, n)
+}
+
+// resourceBackups returns the number of previous versions kept of the file of the resource
+func resourceBackups(rn *VaultResource) int {
+	if rn.backups > 0 {
+		return rn.backups
+	}
+
+	return options.backups
+}
+
+// resourceFiles returns the files written by the format of the resource, the files of a certificate or a
+// keystore are kept and rolled back as one set
+//	rn			: the resource
+//	filename	: the filename of the resource
+//	keys		: the keys of the secret, nil to find the files of a txt or binary resource with several keys on disk
+func resourceFiles(rn *VaultResource, filename string, keys []string) []string {
+	switch rn.format {
+	case "cert":
+		return []string{filename + ".crt", filename + ".ca", filename + ".key"}
+	case "bundle":
+		return []string{filename + "-bundle.pem", filename + ".pem", filename + "-ca.pem", filename + "-key.pem"}
+	case "spiffe":
+		return []string{filepath.Join(filename, svidFile), filepath.Join(filename, svidKeyFile), filepath.Join(filename, svidBundleFile)}
+	case "p12", "jks":
+		return []string{filename, filename + ".password"}
+	case "txt", "binary":
+		if keys == nil {
+			files := []string{filename}
+			matches, _ := filepath.Glob(filename + ".*")
+			for _, x := range matches {
+				if !strings.Contains(x[len(filename):], backupSuffix) {
+					files = append(files, x)
+				}
+			}
+			return files
+		}
+		if len(keys) > 1 {
+			var files []string
+			for _, key := range keys {
+				files = append(files, fmt.Sprintf("%s.%s", filename, key))
+			}
+			return files
+		}
+	}
+
+	return []string{filename}
+}
+
+// readPrevious reads the current content of the files before they're replaced, leaving out those which
+// don't exist; nil if there are none
+//	filenames	: the files of the resource
+func readPrevious(filenames []string) map[string][]byte {
+	var previous map[string][]byte
+	for _, filename := range filenames {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				glog.Errorf("unable to read the file: %s to keep the previous version, error: %s", filename, err)
+			}
+			continue
+		}
+		if previous == nil {
+			previous = make(map[string][]byte, len(filenames))
+		}
+		previous[filename] = content
+	}
+
+	return previous
+}
+
+// keepPrevious keeps the previous content of the files once replaced, shifting the older versions along and
+// dropping any beyond the retention; the files are kept as one set, so a certificate is never rolled back
+// without its key, and nothing is kept when the update left every file unchanged, so a renewal never pushes
+// the last good version out
+//	previous	: the content of the files before they were replaced
+//	retain		: the number of previous versions kept
+//	mode		: the file permissions, unless the file has its own
+func keepPrevious(previous map[string][]byte, retain int, mode os.FileMode) error {
+	defer func() {
+		for _, content := range previous {
+			zeroBytes(content)
+		}
+	}()
+	changed := false
+	for filename, content := range previous {
+		current, err := ioutil.ReadFile(filename)
+		if err != nil || !bytes.Equal(content, current) {
+			changed = true
+		}
+		zeroBytes(current)
+	}
+	if !changed {
+		return nil
+	}
+	for filename, content := range previous {
+		perm := mode
+		if stat, err := os.Stat(filename); err == nil {
+			perm = stat.Mode().Perm()
+		}
+		if err := keepPreviousFile(filename, content, retain, perm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keepPreviousFile keeps the previous content of a file, shifting the older versions along
+//	filename	: the file which has been replaced
+//	previous	: the content of the file before it was replaced
+//	retain		: the number of previous versions kept
+//	mode		: the file permissions
+func keepPreviousFile(filename string, previous []byte, retain int, mode os.FileMode) error {
+	// step: shift the versions along, the oldest being overwritten
+	for i := retain - 1; i > 0; i-- {
+		if err := os.Rename(backupFilename(filename, i-1), backupFilename(filename, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	glog.V(3).Infof("keeping the previous version of the file: %s", filename)
+	backup := backupFilename(filename, 0)
+	if err := ioutil.WriteFile(backup, previous, mode); err != nil {
+		return err
+	}
+
+	return os.Chmod(backup, mode)
+}
+
+// rollbackFile restores the previous version of the file, the older versions moving up in turn, so a
+// second rollback goes back another version
+//	filename	: the file to restore
+func rollbackFile(filename string) error {
+	previous, err := ioutil.ReadFile(backupFilename(filename, 0))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("there is no previous version of the file: %s", filename)
+	}
+	if err != nil {
+		return err
+	}
+	defer zeroBytes(previous)
+
+	mode := os.FileMode(0664)
+	if stat, err := os.Stat(filename); err == nil {
+		mode = stat.Mode().Perm()
+	}
+	// step: the guard would otherwise restore the version being rolled back
+	if guard != nil {
+		err = guard.write(filename, previous, mode, writeFile)
+	} else {
+		err = writeFile(filename, previous, mode)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(backupFilename(filename, 0)); err != nil {
+		return err
+	}
+	for i := 1; ; i++ {
+		if err := os.Rename(backupFilename(filename, i), backupFilename(filename, i-1)); err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollbackResource restores the previous version of the files of the resource, as one set
+func rollbackResource(rn *VaultResource) error {
+	if rn.isWildcard() {
+		return fmt.Errorf("the resource: %s is a wildcard, only single files can be rolled back", rn.ID())
+	}
+	filename := resolveFilename(rn.GetFilename())
+	var files []string
+	for _, x := range resourceFiles(rn, filename, nil) {
+		if found, _ := fileExists(backupFilename(x, 0)); found {
+			files = append(files, x)
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("there is no previous version of the file: %s", filename)
+	}
+	for _, x := range files {
+		if err := rollbackFile(x); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runRollbackCommand asks the sidekick serving the admin api to restore the previous version of the
+// file of a resource, returning the exit code
+//	args		: the arguments following the command
+func runRollbackCommand(args []string) int {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return 1
+	}
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "[error] the id of the resource must be given i.e. rollback pki:pki/issue/web")
+		return 1
+	}
+	if options.listen == "" {
+		fmt.Fprintln(os.Stderr, "[error] the admin api of the sidekick must be given by -listen or VAULT_SIDEKICK_LISTEN")
+		return 1
+	}
+	address := dialAddress(options.listen)
+	client := &http.Client{Timeout: time.Duration(30) * time.Second}
+	resp, err := client.Post(fmt.Sprintf("http://%s/v1/resources/rollback?id=%s", address, url.QueryEscape(flag.Arg(0))), "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[error] unable to reach the admin api, error: %s\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "[error] the resource was not rolled back, status: %d\n", resp.StatusCode)
+		return 1
+	}
+
+	return 0
+}
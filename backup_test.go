@@ -0,0 +1,117 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteResourceKeepsPrevious(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.txt")
+	rn := defaultVaultResource()
+	rn.format = "txt"
+	rn.backups = 2
+
+	for _, x := range []string{"one", "two", "two", "three", "four"} {
+		if err := writeResource(rn, filename, map[string]interface{}{"value": x}, nil); err != errResourceUnchanged {
+			assert.NoError(t, err)
+		}
+	}
+	read := func(name string) string {
+		content, _ := ioutil.ReadFile(name)
+		return string(content)
+	}
+	// step: an unchanged update keeps nothing, the retention drops the oldest
+	assert.Equal(t, "four", read(filename))
+	assert.Equal(t, "three", read(backupFilename(filename, 0)))
+	assert.Equal(t, "two", read(backupFilename(filename, 1)))
+	_, err = os.Stat(backupFilename(filename, 2))
+	assert.True(t, os.IsNotExist(err))
+
+	// step: each rollback goes back a version
+	assert.NoError(t, rollbackFile(filename))
+	assert.Equal(t, "three", read(filename))
+	assert.Equal(t, "two", read(backupFilename(filename, 0)))
+	assert.NoError(t, rollbackFile(filename))
+	assert.Equal(t, "two", read(filename))
+	assert.Error(t, rollbackFile(filename))
+	assert.Equal(t, "two", read(filename))
+}
+
+func TestWriteResourceKeepsCertificateSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { options.outputDir = dir }(options.outputDir)
+	options.outputDir = dir
+
+	rn := defaultVaultResource()
+	rn.resource = "pki"
+	rn.path = "pki/issue/web"
+	rn.format = "cert"
+	rn.backups = 2
+	rn.filename = "web"
+	filename := filepath.Join(dir, "web")
+	read := func(name string) string {
+		content, _ := ioutil.ReadFile(name)
+		return string(content)
+	}
+
+	// step: the key and ca are kept along with the certificate, even where unchanged
+	assert.NoError(t, writeResource(rn, filename, map[string]interface{}{"certificate": "crt1", "issuing_ca": "ca", "private_key": "key1"}, nil))
+	assert.NoError(t, writeResource(rn, filename, map[string]interface{}{"certificate": "crt2", "issuing_ca": "ca", "private_key": "key2"}, nil))
+	assert.Equal(t, "crt1", read(backupFilename(filename+".crt", 0)))
+	assert.Equal(t, "key1", read(backupFilename(filename+".key", 0)))
+	assert.Equal(t, "ca", read(backupFilename(filename+".ca", 0)))
+
+	// step: the rollback restores the set
+	assert.NoError(t, rollbackResource(rn))
+	assert.Equal(t, "crt1", read(filename+".crt"))
+	assert.Equal(t, "key1", read(filename+".key"))
+	assert.Error(t, rollbackResource(rn))
+}
+
+func TestResourceFiles(t *testing.T) {
+	rn := defaultVaultResource()
+	rn.format = "bundle"
+	assert.Equal(t, []string{"/tmp/web-bundle.pem", "/tmp/web.pem", "/tmp/web-ca.pem", "/tmp/web-key.pem"}, resourceFiles(rn, "/tmp/web", nil))
+	rn.format = "txt"
+	assert.Equal(t, []string{"/tmp/app.password", "/tmp/app.user"}, resourceFiles(rn, "/tmp/app", []string{"password", "user"}))
+	assert.Equal(t, []string{"/tmp/app"}, resourceFiles(rn, "/tmp/app", []string{"password"}))
+	rn.format = "json"
+	assert.Equal(t, []string{"/tmp/app"}, resourceFiles(rn, "/tmp/app", nil))
+}
+
+func TestRollbackResourceWildcard(t *testing.T) {
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "secret/apps/*"
+	assert.Error(t, rollbackResource(rn))
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+// caBundleUpdateInterval is the default interval the sources of a ca bundle are checked for a change
+var caBundleUpdateInterval = time.Duration(1) * time.Hour
+
+// caBundleKey is the key of the secret holding the trust bundle
+const caBundleKey = "ca_bundle"
+
+// getCABundle aggregates the ca certificates of the pki mounts of the resource, and any static files, into a
+// single trust bundle; the secret is unchanged unless the bundle differs from the last check, so the file and
+// hooks only follow a change of a source
+//	rn			: the watched resource
+func (r VaultService) getCABundle(rn *watchedResource) (*api.Secret, error) {
+	var bundle bytes.Buffer
+	seen := make(map[[sha256.Size]byte]bool, 0)
+	add := func(source string, content []byte) error {
+		count := 0
+		for {
+			var block *pem.Block
+			if block, content = pem.Decode(content); block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("unable to parse a certificate of: %s, error: %s", source, err)
+			}
+			if !cert.IsCA {
+				glog.Warningf("the certificate: %s of: %s is not a ca, adding it to the bundle regardless", cert.Subject, source)
+			}
+			count++
+			// step: a ca shared between the sources is only added once
+			if sum := sha256.Sum256(cert.Raw); !seen[sum] {
+				seen[sum] = true
+				pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+			}
+		}
+		if count == 0 {
+			return fmt.Errorf("no certificates found in: %s", source)
+		}
+
+		return nil
+	}
+
+	for _, mount := range rn.resource.paths() {
+		content, err := r.readCAChain(rn, mount)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(mount, content); err != nil {
+			return nil, err
+		}
+	}
+	for _, filename := range rn.resource.caFiles {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the ca file: %s, error: %s", filename, err)
+		}
+		if err := add(filename, content); err != nil {
+			return nil, err
+		}
+	}
+
+	// step: only report the bundle when it has changed
+	sum := sha256.Sum256(bundle.Bytes())
+	if rn.bundleSum != nil && *rn.bundleSum == sum {
+		return nil, errSecretUnchanged
+	}
+	if rn.bundleSum != nil {
+		glog.Infof("the trust bundle of the resource: %s has changed, now holding %d certificates", rn.resource, len(seen))
+	}
+	rn.bundleSum = &sum
+
+	return &api.Secret{
+		Renewable:     false,
+		Data:          map[string]interface{}{caBundleKey: bundle.String()},
+		LeaseDuration: int(rn.resource.update.Seconds()),
+	}, nil
+}
+
+// readCAChain reads the ca chain of a pki mount, falling back to the ca of the mount on vaults which don't serve
+// the chain
+//	rn			: the watched resource
+//	mount		: the path of the pki mount
+func (r VaultService) readCAChain(rn *watchedResource, mount string) ([]byte, error) {
+	mount = strings.Trim(mount, "/")
+	for _, path := range []string{mount + "/cert/ca_chain", mount + "/cert/ca"} {
+		secret, err := r.read(rn, path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the ca of the mount: %s, error: %s", mount, err)
+		}
+		if secret == nil || secret.Data == nil {
+			continue
+		}
+		if content, found := secret.Data["certificate"].(string); found && strings.TrimSpace(content) != "" {
+			return []byte(content), nil
+		}
+	}
+
+	return nil, fmt.Errorf("the mount: %s has no ca certificate", mount)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCABundleResourceOptions(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("cabundle:pki,pki_partner:ca-files=/etc/ssl/a.pem|/etc/ssl/b.pem"))
+	rn := resources.items[0]
+	assert.NoError(t, rn.IsValid())
+	assert.Equal(t, []string{"/etc/ssl/a.pem", "/etc/ssl/b.pem"}, rn.caFiles)
+	assert.Equal(t, []string{"pki", "pki_partner"}, rn.paths())
+	assert.Equal(t, "txt", rn.format)
+	assert.Equal(t, caBundleUpdateInterval, rn.update)
+
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("cabundle:pki:fmt=json,update=5m"))
+	assert.Equal(t, "json", resources.items[0].format)
+
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("secret:secret/app:ca-files=/etc/ssl/a.pem"))
+	assert.Error(t, resources.items[0].IsValid())
+}
+
+func TestGetCABundle(t *testing.T) {
+	service, _ := newMockService(t)
+
+	dir, err := ioutil.TempDir("", "cabundle")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	// step: the static file repeats the ca of the pki mount
+	data := readCertificateFixture(t)
+	filename := filepath.Join(dir, "extra.pem")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(data["issuing_ca"].(string)), 0644))
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("cabundle:pki,pki_partner:ca-files="+filename))
+	x := &watchedResource{resource: resources.items[0]}
+	secret, err := service.getCABundle(x)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	var names []string
+	content := []byte(secret.Data[caBundleKey].(string))
+	for {
+		var block *pem.Block
+		if block, content = pem.Decode(content); block == nil {
+			break
+		}
+		cert, err := parseCertificate(string(pem.EncodeToMemory(block)))
+		assert.NoError(t, err)
+		names = append(names, cert.Subject.CommonName)
+	}
+	assert.Equal(t, []string{"Mock Root CA", "Mock Partner CA"}, names)
+
+	// step: the bundle is unchanged until a source changes
+	_, err = service.getCABundle(x)
+	assert.Equal(t, errSecretUnchanged, err)
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(data["issuing_ca"].(string)+"\n"+data["certificate"].(string)), 0644))
+	secret, err = service.getCABundle(x)
+	assert.NoError(t, err)
+	assert.NotNil(t, secret)
+
+	// step: a missing mount or file fails
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("cabundle:pki_missing"))
+	_, err = service.getCABundle(&watchedResource{resource: resources.items[0]})
+	assert.Error(t, err)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// responseCache is a short lived cache of vault reads keyed by path, so resources reading the
+// same path within the window share a single request. Only static secrets are cached, a read of
+// a dynamic backend issues new credentials and must never be shared.
+type responseCache struct {
+	sync.Mutex
+	// the time an entry remains valid
+	ttl time.Duration
+	// the cached responses
+	entries map[string]*cacheEntry
+}
+
+// cacheEntry is a cached response from vault
+type cacheEntry struct {
+	// the secret read from vault
+	secret *api.Secret
+	// the time the entry expires
+	expires time.Time
+}
+
+// newResponseCache creates a cache, returns nil if caching is disabled
+//	ttl			: the time an entry remains valid
+func newResponseCache(ttl time.Duration) *responseCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &responseCache{
+		ttl:     ttl,
+		entries: make(map[string]*cacheEntry, 0),
+	}
+}
+
+// get retrieves a copy of the cached secret for a path
+func (c *responseCache) get(path string) (*api.Secret, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	entry, found := c.entries[path]
+	if !found {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, path)
+		return nil, false
+	}
+
+	return copySecret(entry.secret), true
+}
+
+// set caches the secret for a path
+func (c *responseCache) set(path string, secret *api.Secret) {
+	if c == nil || secret == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries[path] = &cacheEntry{
+		secret:  copySecret(secret),
+		expires: time.Now().Add(c.ttl),
+	}
+}
+
+// remove evicts a path from the cache i.e. after a write
+func (c *responseCache) remove(path string) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.entries, path)
+}
+
+// copySecret returns a copy of the secret, the resources update the lease in place
+func copySecret(secret *api.Secret) *api.Secret {
+	x := *secret
+	x.Data = make(map[string]interface{}, len(secret.Data))
+	for k, v := range secret.Data {
+		x.Data[k] = v
+	}
+
+	return &x
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache(t *testing.T) {
+	assert.Nil(t, newResponseCache(0))
+
+	cache := newResponseCache(time.Duration(50) * time.Millisecond)
+	cache.set("secret/app", &api.Secret{LeaseDuration: 60, Data: map[string]interface{}{"password": "test"}})
+
+	secret, found := cache.get("secret/app")
+	if !assert.True(t, found) {
+		t.FailNow()
+	}
+	assert.Equal(t, "test", secret.Data["password"])
+
+	// step: changes to a returned secret should not leak into the cache
+	secret.LeaseDuration = 10
+	secret.Data["password"] = "changed"
+	secret, _ = cache.get("secret/app")
+	assert.Equal(t, 60, secret.LeaseDuration)
+	assert.Equal(t, "test", secret.Data["password"])
+
+	cache.remove("secret/app")
+	_, found = cache.get("secret/app")
+	assert.False(t, found)
+
+	cache.set("secret/app", &api.Secret{})
+	time.Sleep(time.Duration(100) * time.Millisecond)
+	_, found = cache.get("secret/app")
+	assert.False(t, found)
+}
+
+func TestResponseCacheDisabled(t *testing.T) {
+	var cache *responseCache
+	cache.set("secret/app", &api.Secret{})
+	_, found := cache.get("secret/app")
+	assert.False(t, found)
+}
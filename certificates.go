@@ -0,0 +1,134 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// verifyCertificate checks a certificate issued by the pki backend matches what was requested; the
+// certificate must chain to the issuing ca, the private key must match and the common name and
+// alternative names must be present
+//	rn			: the resource requesting the certificate
+//	data		: the secret returned from vault
+func verifyCertificate(rn *VaultResource, data map[string]interface{}) error {
+	certPEM := fmt.Sprintf("%v", data["certificate"])
+	keyPEM := fmt.Sprintf("%v", data["private_key"])
+
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return fmt.Errorf("unable to parse the certificate, error: %s", err)
+	}
+
+	// step: check the private key belongs to the certificate
+	if _, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM)); err != nil {
+		return fmt.Errorf("the private key does not match the certificate, error: %s", err)
+	}
+
+	// step: check the certificate chains to the issuing ca
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	if ca, found := data["issuing_ca"]; found {
+		if !roots.AppendCertsFromPEM([]byte(fmt.Sprintf("%v", ca))) {
+			return fmt.Errorf("unable to parse the issuing ca")
+		}
+	}
+	if chain, found := data["ca_chain"].([]interface{}); found {
+		for _, x := range chain {
+			intermediates.AppendCertsFromPEM([]byte(fmt.Sprintf("%v", x)))
+			roots.AppendCertsFromPEM([]byte(fmt.Sprintf("%v", x)))
+		}
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("the certificate does not chain to the issuing ca, error: %s", err)
+	}
+
+	// step: check the names requested were issued
+	if cn := rn.options["common_name"]; cn != "" && cert.Subject.CommonName != cn {
+		return fmt.Errorf("the certificate common name: %s does not match the requested: %s", cert.Subject.CommonName, cn)
+	}
+	for _, name := range splitNames(rn.options["alt_names"]) {
+		if !containsName(cert.DNSNames, name) && !containsName(cert.EmailAddresses, name) {
+			return fmt.Errorf("the certificate is missing the requested alternative name: %s", name)
+		}
+	}
+	for _, address := range splitNames(rn.options["ip_sans"]) {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return fmt.Errorf("the requested ip san: %s is invalid", address)
+		}
+		found := false
+		for _, x := range cert.IPAddresses {
+			if x.Equal(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("the certificate is missing the requested ip san: %s", address)
+		}
+	}
+	if rn.spiffeID != "" {
+		if err := verifySPIFFEID(rn.spiffeID, cert); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseCertificate decodes the first certificate from the pem content
+func parseCertificate(content string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(content))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in the pem content")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// splitNames splits a comma separated list of names
+func splitNames(value string) []string {
+	var list []string
+	for _, x := range strings.Split(value, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			list = append(list, x)
+		}
+	}
+
+	return list
+}
+
+// containsName checks if the name is in the list, ignoring case
+func containsName(names []string, name string) bool {
+	for _, x := range names {
+		if strings.EqualFold(x, name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readCertificateFixture reads the data of the pki fixture
+func readCertificateFixture(t *testing.T) map[string]interface{} {
+	content, err := ioutil.ReadFile("tests/fixtures/pki/issue/example.json")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	fixture := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if !assert.NoError(t, json.Unmarshal(content, &fixture)) {
+		t.FailNow()
+	}
+
+	return fixture.Data
+}
+
+func TestVerifyCertificate(t *testing.T) {
+	cases := []struct {
+		Options map[string]string
+		Error   bool
+	}{
+		{Options: map[string]string{}},
+		{Options: map[string]string{"common_name": "app.example.com"}},
+		{Options: map[string]string{"common_name": "app.example.com", "alt_names": "www.example.com"}},
+		{Options: map[string]string{"common_name": "WWW.example.com"}, Error: true},
+		{Options: map[string]string{"common_name": "app.example.com", "alt_names": "api.example.com"}, Error: true},
+		{Options: map[string]string{"common_name": "app.example.com", "ip_sans": "127.0.0.1"}, Error: true},
+	}
+	data := readCertificateFixture(t)
+	for i, c := range cases {
+		rn := defaultVaultResource()
+		rn.resource = "pki"
+		rn.options = c.Options
+		err := verifyCertificate(rn, data)
+		if c.Error {
+			assert.Error(t, err, "case %d, should have failed", i)
+			continue
+		}
+		assert.NoError(t, err, "case %d, should not have failed", i)
+	}
+}
+
+func TestVerifyCertificateKeyMismatch(t *testing.T) {
+	data := readCertificateFixture(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	encoded, err := x509.MarshalECPrivateKey(key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	data["private_key"] = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: encoded}))
+
+	assert.Error(t, verifyCertificate(defaultVaultResource(), data))
+}
+
+func TestVerifyCertificateWrongCA(t *testing.T) {
+	data := readCertificateFixture(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Another CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	ca, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	data["issuing_ca"] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca}))
+	delete(data, "ca_chain")
+
+	assert.Error(t, verifyCertificate(defaultVaultResource(), data))
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// certStatusCurrent is the certificate last issued to a resource
+	certStatusCurrent = "current"
+	// certStatusSuperseded is a certificate replaced by a later issue
+	certStatusSuperseded = "superseded"
+	// certStatusRevoked is a superseded certificate which has been revoked
+	certStatusRevoked = "revoked"
+)
+
+// issuedCertificate is a certificate issued to a resource, recorded in the state file
+type issuedCertificate struct {
+	// the serial number of the certificate
+	Serial string `json:"serial"`
+	// the id of the resource the certificate was issued to
+	Resource string `json:"resource"`
+	// the common name of the certificate
+	CommonName string `json:"common_name"`
+	// the subject alternative names of the certificate
+	SANs []string `json:"sans,omitempty"`
+	// the time the certificate expires
+	Expires time.Time `json:"expires"`
+	// the file the certificate was written to
+	File string `json:"file"`
+	// the time the certificate was issued
+	Issued time.Time `json:"issued"`
+	// the time the certificate was replaced by a later issue
+	Superseded *time.Time `json:"superseded,omitempty"`
+	// the time the certificate was revoked
+	Revoked *time.Time `json:"revoked,omitempty"`
+}
+
+// status returns whether the certificate is current, superseded or revoked
+func (c *issuedCertificate) status() string {
+	switch {
+	case c.Revoked != nil:
+		return certStatusRevoked
+	case c.Superseded != nil:
+		return certStatusSuperseded
+	}
+
+	return certStatusCurrent
+}
+
+// certificateSerial returns the serial number of the certificate in a secret, empty if none
+//	secret		: the secret
+func certificateSerial(secret *api.Secret) string {
+	if secret == nil || secret.Data == nil {
+		return ""
+	}
+	serial, found := secret.Data["serial_number"]
+	if !found {
+		return ""
+	}
+
+	return fmt.Sprintf("%v", serial)
+}
+
+// newIssuedCertificate describes the certificate issued to a resource
+//	rn			: the watched resource
+//	serial		: the serial number of the certificate
+func newIssuedCertificate(rn *watchedResource, serial string) *issuedCertificate {
+	x := &issuedCertificate{
+		Serial:   serial,
+		Resource: rn.resource.ID(),
+		File:     resolveFilename(rn.resource.GetFilename()),
+		Issued:   rn.issued,
+	}
+	if content, found := rn.secret.Data["certificate"].(string); found {
+		if cert, err := parseCertificate(content); err == nil {
+			x.CommonName = cert.Subject.CommonName
+			x.Expires = cert.NotAfter
+			x.SANs = append(x.SANs, cert.DNSNames...)
+			for _, ip := range cert.IPAddresses {
+				x.SANs = append(x.SANs, ip.String())
+			}
+			x.SANs = append(x.SANs, cert.EmailAddresses...)
+			for _, uri := range cert.URIs {
+				x.SANs = append(x.SANs, uri.String())
+			}
+		}
+	}
+
+	return x
+}
+
+// recordCertificate adds a certificate issued to a resource, marking those it replaces as superseded; the
+// certificates which have expired are dropped. The caller holds the lock on the store
+//	rn			: the watched resource
+//	serial		: the serial number of the certificate
+func (s *leaseStore) recordCertificate(rn *watchedResource, serial string) {
+	now := time.Now()
+	id := rn.resource.ID()
+	list := make([]*issuedCertificate, 0, len(s.Certificates)+1)
+	found := false
+	for _, x := range s.Certificates {
+		if !x.Expires.IsZero() && now.After(x.Expires) {
+			continue
+		}
+		switch {
+		case x.Serial == serial:
+			found = true
+		case x.Resource == id && x.Superseded == nil:
+			x.Superseded = &now
+		}
+		list = append(list, x)
+	}
+	if !found {
+		list = append(list, newIssuedCertificate(rn, serial))
+	}
+	s.Certificates = list
+}
+
+// revokedCertificate marks a certificate as revoked and flushes the state file
+//	serial		: the serial number of the certificate
+func (s *leaseStore) revokedCertificate(serial string) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for _, x := range s.Certificates {
+		if x.Serial == serial {
+			x.Revoked = &now
+		}
+	}
+
+	return s.flush()
+}
+
+// supersede tracks the serial of the certificate issued to a resource, scheduling the revocation of the
+// certificate it replaces after the revoke delay if required, so the application has reloaded by then
+//	rn			: the watched resource
+func (r VaultService) supersede(rn *watchedResource) {
+	serial := certificateSerial(rn.secret)
+	if serial == "" {
+		return
+	}
+	previous := rn.serial
+	// step: the certificate issued before a restart is known from the state file
+	if previous == "" && r.state != nil {
+		if lease, found := r.state.get(rn.resource.ID()); found {
+			previous = lease.Serial
+		}
+	}
+	rn.serial = serial
+	if previous == "" || previous == serial || !rn.resource.revokeSuperseded {
+		return
+	}
+	resource := rn.resource
+	time.AfterFunc(resource.revokeDelay, func() {
+		if err := r.revokeCertificate(resource, previous); err != nil {
+			glog.Errorf("failed to revoke the superseded certificate: %s of resource: %s, error: %s", previous, resource, err)
+			return
+		}
+		glog.Infof("revoked the superseded certificate: %s of resource: %s", previous, resource)
+		if r.state != nil {
+			if err := r.state.revokedCertificate(previous); err != nil {
+				glog.Errorf("failed to persist the revocation of the certificate: %s, error: %s", previous, err)
+			}
+		}
+	})
+}
+
+// revokeCertificate revokes a certificate by its serial number via the revoke endpoint of the pki mount
+//	rn			: the resource the certificate was issued to
+//	serial		: the serial number of the certificate
+func (r VaultService) revokeCertificate(rn *VaultResource, serial string) error {
+	mount, found := pkiMount(rn.path)
+	if !found {
+		return fmt.Errorf("unable to determine the pki mount of the path: %s", rn.path)
+	}
+	var err error
+	if rn.namespace != "" {
+		if r, err = r.withNamespace(rn.namespace); err != nil {
+			return err
+		}
+	}
+	if r, err = r.tagRequests(rn); err != nil {
+		return err
+	}
+	_, err = r.client.Logical().Write(mount+"/revoke", map[string]interface{}{"serial_number": serial})
+
+	return err
+}
+
+// formatCertificates renders the certificates as a table, ordered by the resource and the time issued
+//	list		: the certificates
+func formatCertificates(list []*issuedCertificate) string {
+	sorted := append([]*issuedCertificate{}, list...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Resource != sorted[j].Resource {
+			return sorted[i].Resource < sorted[j].Resource
+		}
+		return sorted[i].Issued.Before(sorted[j].Issued)
+	})
+
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tCOMMON NAME\tSANS\tEXPIRES\tFILE\tSTATUS")
+	for _, x := range sorted {
+		sans, expires := "-", "-"
+		if len(x.SANs) > 0 {
+			sans = strings.Join(x.SANs, ",")
+		}
+		if !x.Expires.IsZero() {
+			expires = x.Expires.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", x.Serial, x.CommonName, sans, expires, x.File, x.status())
+	}
+	w.Flush()
+
+	return b.String()
+}
+
+// runCertsCommand lists the certificates issued to the resources, as recorded in the state file
+func runCertsCommand(args []string) int {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return 1
+	}
+	if options.stateFile == "" {
+		fmt.Fprintln(os.Stderr, "[error] the state file must be given by -state-file or VAULT_SIDEKICK_STATE_FILE")
+		return 1
+	}
+	if _, err := os.Stat(options.stateFile); err != nil {
+		fmt.Fprintf(os.Stderr, "[error] unable to read the state file: %s, error: %s\n", options.stateFile, err)
+		return 1
+	}
+	store, err := newLeaseStore(options.stateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[error] %s\n", err)
+		return 1
+	}
+	fmt.Print(formatCertificates(store.Certificates))
+
+	return 0
+}
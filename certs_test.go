@@ -0,0 +1,133 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevokeSupersededOption(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("pki:pki/issue/web:common_name=web,revoke-superseded=true,delay=1m"))
+	assert.NoError(t, resources.items[0].IsValid())
+	assert.True(t, resources.items[0].revokeSuperseded)
+	assert.Equal(t, time.Minute, resources.items[0].revokeDelay)
+
+	assert.Error(t, resources.Set("pki:pki/issue/web:revoke-superseded=maybe"))
+	assert.NoError(t, resources.Set("secret:secret/app:revoke-superseded=true"))
+	assert.Error(t, resources.items[1].IsValid())
+
+	rules := make(policyRules, 0)
+	resourcePolicy(rules, resources.items[0])
+	assert.True(t, rules["pki/revoke"]["update"])
+}
+
+func TestRecordCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	options.stateFile = filepath.Join(dir, "state.json")
+	defer func() { options.stateFile = "" }()
+
+	store, err := newLeaseStore(options.stateFile)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var resources VaultResources
+	assert.NoError(t, resources.Set("pki:pki/issue/example:common_name=example,file=tls"))
+	data := readCertificateFixture(t)
+	x := &watchedResource{resource: resources.items[0], issued: time.Now(), secret: &api.Secret{Data: data}}
+	assert.NoError(t, store.update(x))
+	if !assert.Len(t, store.Certificates, 1) {
+		t.FailNow()
+	}
+	cert := store.Certificates[0]
+	assert.Equal(t, data["serial_number"], cert.Serial)
+	assert.Equal(t, resources.items[0].ID(), cert.Resource)
+	assert.NotEmpty(t, cert.CommonName)
+	assert.False(t, cert.Expires.IsZero())
+	assert.Equal(t, resolveFilename("tls"), cert.File)
+	assert.Equal(t, certStatusCurrent, cert.status())
+
+	// step: a renewal of the same certificate isn't recorded again, a new issue supersedes it
+	assert.NoError(t, store.update(x))
+	assert.Len(t, store.Certificates, 1)
+	reissued := map[string]interface{}{"certificate": data["certificate"], "serial_number": "11:22:33"}
+	x.secret = &api.Secret{Data: reissued}
+	assert.NoError(t, store.update(x))
+	if !assert.Len(t, store.Certificates, 2) {
+		t.FailNow()
+	}
+	assert.Equal(t, certStatusSuperseded, store.Certificates[0].status())
+	assert.Equal(t, certStatusCurrent, store.Certificates[1].status())
+	assert.NoError(t, store.revokedCertificate(cert.Serial))
+	assert.Equal(t, certStatusRevoked, store.Certificates[0].status())
+
+	// step: an expired certificate is dropped on the next issue
+	store.Certificates[0].Expires = time.Now().Add(-time.Minute)
+	assert.NoError(t, store.update(x))
+	assert.Len(t, store.Certificates, 1)
+
+	// step: the listing reads the state file
+	loaded, err := newLeaseStore(options.stateFile)
+	assert.NoError(t, err)
+	listing := formatCertificates(loaded.Certificates)
+	assert.True(t, strings.HasPrefix(listing, "SERIAL"))
+	assert.Contains(t, listing, "11:22:33")
+	assert.Contains(t, listing, certStatusCurrent)
+	assert.Equal(t, 0, runCertsCommand(nil))
+	options.stateFile = filepath.Join(dir, "missing.json")
+	assert.Equal(t, 1, runCertsCommand(nil))
+}
+
+func TestSupersedeCertificate(t *testing.T) {
+	service, _ := newMockService(t)
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("pki:pki/issue/example:common_name=example,revoke-superseded=true"))
+	x := &watchedResource{resource: resources.items[0]}
+
+	// step: the first certificate has nothing to supersede
+	x.secret = &api.Secret{Data: map[string]interface{}{"serial_number": "aa:aa"}}
+	service.supersede(x)
+	assert.Equal(t, "aa:aa", x.serial)
+	secret, err := service.client.Logical().Read("pki/revoke")
+	assert.NoError(t, err)
+	assert.Nil(t, secret)
+
+	// step: a new issue revokes the certificate it replaces
+	x.secret = &api.Secret{Data: map[string]interface{}{"serial_number": "bb:bb"}}
+	service.supersede(x)
+	assert.Equal(t, "bb:bb", x.serial)
+	for i := 0; i < 50 && secret == nil; i++ {
+		time.Sleep(time.Duration(20) * time.Millisecond)
+		secret, _ = service.client.Logical().Read("pki/revoke")
+	}
+	if assert.NotNil(t, secret) {
+		assert.Equal(t, "aa:aa", secret.Data["serial_number"])
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// secretChanges is a summary of the keys which changed between two versions of a secret
+type secretChanges struct {
+	// the keys added and the hash of their value
+	added []string
+	// the keys removed
+	removed []string
+	// the keys whose value changed and the hash of the new value
+	changed []string
+}
+
+// empty checks if nothing has changed
+func (c secretChanges) empty() bool {
+	return len(c.added) == 0 && len(c.removed) == 0 && len(c.changed) == 0
+}
+
+// String returns the summary of the changes, the values are never included, only a short hash
+func (c secretChanges) String() string {
+	return fmt.Sprintf("added: [%s], removed: [%s], changed: [%s]",
+		strings.Join(c.added, " "), strings.Join(c.removed, " "), strings.Join(c.changed, " "))
+}
+
+// diffSecret compares the keys of the previous and current version of a secret
+//	previous	: the hashes of the previous secret, nil on the first retrieval
+//	current		: the hashes of the secret just retrieved
+func diffSecret(previous, current map[string]string) secretChanges {
+	var changes secretChanges
+	for key, hash := range current {
+		old, found := previous[key]
+		switch {
+		case !found:
+			changes.added = append(changes.added, fmt.Sprintf("%s=%s", key, hash))
+		case old != hash:
+			changes.changed = append(changes.changed, fmt.Sprintf("%s=%s", key, hash))
+		}
+	}
+	for key := range previous {
+		if _, found := current[key]; !found {
+			changes.removed = append(changes.removed, key)
+		}
+	}
+	sort.Strings(changes.added)
+	sort.Strings(changes.removed)
+	sort.Strings(changes.changed)
+
+	return changes
+}
+
+// hashValue returns a short sha256 of the value, enough to correlate a rotation without revealing it
+func hashValue(value interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%v", value)))
+	return "sha256:" + hex.EncodeToString(sum[:])[:12]
+}
+
+// hashData hashes the values of the secret, retained in place of the secret to compare the next version against
+func hashData(data map[string]interface{}) map[string]string {
+	hashes := make(map[string]string, len(data))
+	for k, v := range data {
+		hashes[k] = hashValue(v)
+	}
+
+	return hashes
+}
+
+// logSecretChanges logs a summary of the keys which changed in the resource
+func logSecretChanges(rn *VaultResource, previous, current map[string]string) {
+	changes := diffSecret(previous, current)
+	if changes.empty() {
+		glog.V(3).Infof("resource: %s, no keys have changed", rn)
+		return
+	}
+	glog.Infof("resource: %s, secret changed, %s", rn, changes)
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSecret(t *testing.T) {
+	previous := hashData(map[string]interface{}{"username": "app", "password": "first", "host": "db"})
+	current := hashData(map[string]interface{}{"username": "app", "password": "second", "port": 5432})
+
+	changes := diffSecret(previous, current)
+	assert.Equal(t, []string{"port=" + hashValue(5432)}, changes.added)
+	assert.Equal(t, []string{"host"}, changes.removed)
+	assert.Equal(t, []string{"password=" + hashValue("second")}, changes.changed)
+	assert.False(t, strings.Contains(changes.String(), "second"))
+
+	// step: the first retrieval reports every key as added
+	changes = diffSecret(nil, current)
+	assert.Len(t, changes.added, 3)
+	assert.Empty(t, changes.removed)
+
+	assert.True(t, diffSecret(current, current).empty())
+}
+
+func TestHashValue(t *testing.T) {
+	assert.Equal(t, hashValue("secret"), hashValue("secret"))
+	assert.NotEqual(t, hashValue("secret"), hashValue("other"))
+	assert.Len(t, hashValue("secret"), len("sha256:")+12)
+	assert.False(t, strings.Contains(hashValue("secret"), "secret"))
+}
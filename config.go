@@ -19,8 +19,8 @@ package main
 import (
 	"flag"
 	"fmt"
-	"net/url"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -37,6 +37,17 @@ type vaultAuthOptions struct {
 	FileFormat    string
 	Username      string
 	Password      string
+	// the address of a performance standby static secrets are read from
+	VaultReadURL string `json:"vaultReadAddr,omitempty" yaml:"vaultReadAddr,omitempty"`
+	// the provider run by the exec method and its arguments
+	Command string   `json:"command,omitempty" yaml:"command,omitempty"`
+	Args    []string `json:"args,omitempty" yaml:"args,omitempty"`
+	// request a periodic and or orphan token with the policies after login
+	TokenPeriod   string   `json:"token_period,omitempty" yaml:"token_period,omitempty"`
+	TokenPolicies []string `json:"token_policies,omitempty" yaml:"token_policies,omitempty"`
+	TokenOrphan   bool     `json:"token_orphan,omitempty" yaml:"token_orphan,omitempty"`
+	// additional named vault endpoints, each with their own authentication
+	Vaults map[string]*vaultAuthOptions `json:"vaults,omitempty" yaml:"vaults,omitempty"`
 }
 
 type config struct {
@@ -50,8 +61,14 @@ type config struct {
 	vaultAuthOptions *vaultAuthOptions
 	// renew the token based on ttl
 	vaultRenewToken bool
+	// the url of a performance standby or read replica for static reads
+	vaultReadURL string
 	// the vault ca file
 	vaultCaFile string
+	// a directory of ca certificates
+	vaultCaPath string
+	// the server name used to verify the vault certificate
+	tlsServerName string
 	// the place to write the resources
 	outputDir string
 	// switch on dry run
@@ -68,6 +85,129 @@ type config struct {
 	showVersion bool
 	// one-shot mode
 	oneShot bool
+	// exec the command once the resources are retrieved, implies one-shot
+	then bool
+	// hedge the reads of static secrets
+	hedgeReads bool
+	// print the schedule of the updates once every resource has been retrieved
+	printSchedule bool
+	// the path to the file used to persist leases across restarts
+	stateFile string
+	// the directory of fixtures served by the mock vault
+	mockDir string
+	// the otlp endpoint to export traces to
+	otlpEndpoint string
+	// the service name used in the traces
+	otlpServiceName string
+	// the time a read of a static secret is cached for
+	cacheTTL time.Duration
+	// the interface the admin api listens on
+	listen string
+	// capture a redacted trace of the requests to vault, served by the admin api
+	debugHTTP bool
+	// the number of traces of the requests to vault kept
+	debugHTTPSize int
+	// the selinux context the files written are labeled with, disabled if empty
+	selinuxContext string
+	// set or verify the selinux context of the files written
+	selinuxMode string
+	// the longest time the shutdown is held for after a termination signal, disabled if zero
+	drainPeriod time.Duration
+	// draw a live status screen of the resources on the terminal
+	tui bool
+	// the format of the times and durations in the metadata files, admin api and logs, rfc3339 or unix
+	timeFormat string
+	// the maximum size of a response from vault, zero is unlimited
+	maxResponseSize byteSize
+	// the maximum size of a file written, zero is unlimited
+	maxFileSize byteSize
+	// take a lock on the output directory while writing
+	flock bool
+	// the time allowed for the first retrieval of all the resources
+	startupTimeout time.Duration
+	// wait for vault to be unsealed and active before starting
+	waitForVault bool
+	// skip locking the memory of the process
+	disableMlock bool
+	// the webhook an alert is posted to when a resource keeps failing
+	alertURL string
+	// an additional header of the alert requests
+	alertHeader string
+	// the file holding the template of the alert payload
+	alertTemplate string
+	// the time a resource has to be failing for before an alert
+	alertAfter time.Duration
+	// the pkcs11 module of the token the private keys are generated in
+	pkcs11Module string
+	// the file holding the user pin of the pkcs11 token
+	pkcs11PinFile string
+	// run on a laptop, logging in via the browser and writing under ./secrets
+	dev bool
+	// log the keys which changed on each update
+	logChanges bool
+	// rewrite the files written if deleted or modified by another process
+	watchFiles bool
+	// the interval the output directory is scanned for out of place files, disabled if zero
+	scanInterval time.Duration
+	// report or fix the out of place files found
+	scanAction string
+	// the patterns of the files expected in the output directory besides the resources
+	scanAllow string
+	// log the processes opening the files written
+	auditAccess bool
+	// the file the token is shared through with the tools using the sidekick as their token helper
+	tokenHelperFile string
+	// inject the secrets into the command as environment variables, never writing files
+	execEnvOnly bool
+	// the command run with the secrets in the environment
+	command []string
+	// the interface the certificates of the pki resource are served on
+	servePKI string
+	// the vault enterprise namespace of the requests
+	vaultNamespace string
+	// the user agent of the requests to vault, the version and pod of the sidekick if empty
+	userAgent string
+	// the client certificate and key presented to vault
+	vaultClientCert string
+	vaultClientKey  string
+	// the number of retries of a failed request to vault, negative for the default
+	vaultMaxRetries int
+	// the directory of the downward api volume
+	podInfoDir string
+	// retrieve the pod metadata from the kubernetes api
+	podInfoAPI bool
+	// read the resources from the annotations of the pod
+	k8sAnnotations bool
+	// the configmap the resources are added and removed through, [namespace/]name
+	k8sConfigMap string
+	// the key of the configmap holding the resources
+	k8sConfigMapKey string
+	// the interval the configmap is checked for a change
+	k8sConfigMapInterval time.Duration
+	// the default bound on each request to vault for a resource
+	resourceDeadline time.Duration
+	// the number of resources retrieved at once at startup
+	startupConcurrency int
+	// the number of keys asked for in each page of the listing of a wildcard resource
+	listPageSize int
+	// the number of children of a wildcard resource read at once
+	listConcurrency int
+	// the number of children of a wildcard resource held at once, unlimited if zero
+	listMaxChildren int
+	// the number of previous versions of each file kept
+	backups int
+	// the file holding the passcode of an mfa enforced login
+	mfaPasscodeFile string
+	// the file holding the totp secret an mfa passcode is generated from
+	mfaTOTPFile string
+	// the kubernetes lease the replicas take turns holding while issuing certificates
+	pkiLease string
+	// the time the lease is held for
+	pkiLeaseDuration time.Duration
+	// only process the resources carrying one of the tags
+	onlyTags string
+	// skip the resources carrying one of the tags
+	skipTags string
 }
 
 var (
@@ -83,23 +223,88 @@ func init() {
 	}
 
 	flag.StringVar(&options.vaultURL, "vault", getEnv("VAULT_ADDR", "https://127.0.0.1:8200"), "url the vault service or VAULT_ADDR")
+	flag.StringVar(&options.vaultReadURL, "read-address", getEnv("VAULT_SIDEKICK_READ_ADDR", ""), "url of a performance standby or read replica static secrets are read from, reducing the load on the active node")
 	flag.StringVar(&options.vaultAuthFile, "auth", getEnv("AUTH_FILE", ""), "a configuration file in json or yaml containing authentication arguments")
 	flag.BoolVar(&options.vaultRenewToken, "renew-token", false, "renew vault token according to its ttl")
 	flag.StringVar(&options.vaultAuthFileFormat, "format", getEnv("AUTH_FORMAT", "default"), "the auth file format")
-	flag.StringVar(&options.outputDir, "output", getEnv("VAULT_OUTPUT", "/etc/secrets"), "the full path to write resources or VAULT_OUTPUT")
+	flag.StringVar(&options.outputDir, "output", getEnv("VAULT_OUTPUT", defaultOutputDir), "the full path to write resources or VAULT_OUTPUT, - writes them to stdout")
 	flag.BoolVar(&options.dryRun, "dryrun", false, "perform a dry run, printing the content to screen")
-	flag.BoolVar(&options.skipTLSVerify, "tls-skip-verify", false, "whether to check and verify the vault service certificate")
-	flag.StringVar(&options.vaultCaFile, "ca-cert", "", "the path to the file container the CA used to verify the vault service")
+	flag.BoolVar(&options.skipTLSVerify, "tls-skip-verify", getEnvBool("VAULT_SKIP_VERIFY", false), "skip verifying the vault service certificate, insecure and not recommended, or VAULT_SKIP_VERIFY")
+	flag.StringVar(&options.vaultCaFile, "ca-cert", getEnv("VAULT_CACERT", ""), "the path to the file container the CA used to verify the vault service or VAULT_CACERT")
+	flag.StringVar(&options.vaultCaPath, "ca-path", getEnv("VAULT_CAPATH", ""), "the path to a directory of CA certificates used to verify the vault service or VAULT_CAPATH")
+	flag.StringVar(&options.vaultNamespace, "namespace", getEnv("VAULT_NAMESPACE", ""), "the vault enterprise namespace of the requests or VAULT_NAMESPACE")
+	flag.StringVar(&options.userAgent, "user-agent", getEnv("VAULT_SIDEKICK_USER_AGENT", ""), "the user agent of the requests to vault, defaults to the version and pod of the sidekick or VAULT_SIDEKICK_USER_AGENT")
+	flag.StringVar(&options.vaultClientCert, "client-cert", getEnv("VAULT_CLIENT_CERT", ""), "the path to a client certificate presented to the vault service or VAULT_CLIENT_CERT")
+	flag.StringVar(&options.vaultClientKey, "client-key", getEnv("VAULT_CLIENT_KEY", ""), "the path to the private key of the client certificate or VAULT_CLIENT_KEY")
+	flag.IntVar(&options.vaultMaxRetries, "max-retries", getEnvInt("VAULT_MAX_RETRIES", -1), "the number of retries of a request to vault failing with a 5xx, or VAULT_MAX_RETRIES")
+	flag.StringVar(&options.tlsServerName, "tls-server-name", getEnv("VAULT_TLS_SERVER_NAME", ""), "the server name used to verify the vault service certificate or VAULT_TLS_SERVER_NAME")
 	flag.DurationVar(&options.statsInterval, "stats", time.Duration(1)*time.Hour, "the interval to produce statistics on the accessed resources")
 	flag.DurationVar(&options.execTimeout, "exec-timeout", time.Duration(60)*time.Second, "the timeout applied to commands on the exec option")
 	flag.BoolVar(&options.showVersion, "version", false, "show the vault-sidekick version")
-	flag.Var(options.resources, "cn", "a resource to retrieve and monitor from vault")
+	flag.Var(&resourceFlag{resources: options.resources}, "cn", "a resource to retrieve and monitor from vault")
 	flag.BoolVar(&options.oneShot, "one-shot", false, "retrieve resources from vault once and then exit")
+	flag.BoolVar(&options.then, "then", getEnvBool("VAULT_SIDEKICK_THEN", false), "retrieve the resources from vault once and then exec the command following -- in place of the sidekick i.e. -then -- /app/server")
+	flag.BoolVar(&options.hedgeReads, "hedge-reads", getEnvBool("VAULT_SIDEKICK_HEDGE_READS", false), "make a second read of a static secret, against the performance standby if any, when the first is slower than the 95th percentile of the latest reads")
+	flag.BoolVar(&options.printSchedule, "print-schedule", getEnvBool("VAULT_SIDEKICK_PRINT_SCHEDULE", false), "print when each resource will next be renewed or fetched and why, once every resource has been retrieved")
+	flag.DurationVar(&options.startupTimeout, "startup-timeout", time.Duration(0), "the time allowed for the first retrieval of all the resources before exiting non zero, disabled if zero")
+	flag.DurationVar(&options.resourceDeadline, "resource-deadline", time.Duration(0), "the time each request to vault for a resource is allowed before the attempt fails and is retried, overridden by the timeout option of the resource, the client default if zero")
+	flag.IntVar(&options.startupConcurrency, "startup-concurrency", getEnvInt("VAULT_SIDEKICK_STARTUP_CONCURRENCY", defaultStartupConcurrency), "the number of resources retrieved at once at startup, one at a time if one or zero")
+	flag.IntVar(&options.listPageSize, "list-page-size", getEnvInt("VAULT_SIDEKICK_LIST_PAGE_SIZE", defaultListPageSize), "the number of keys asked for in each page of the listing of a wildcard resource, a single listing if zero")
+	flag.IntVar(&options.listConcurrency, "list-concurrency", getEnvInt("VAULT_SIDEKICK_LIST_CONCURRENCY", defaultListConcurrency), "the number of children of a wildcard resource read at once, one at a time if one or zero")
+	flag.IntVar(&options.listMaxChildren, "list-max-children", getEnvInt("VAULT_SIDEKICK_LIST_MAX_CHILDREN", defaultListMaxChildren), "the number of children of a wildcard resource beyond which the update fails rather than holding them all, unlimited if zero")
+	flag.IntVar(&options.backups, "backups", getEnvInt("VAULT_SIDEKICK_BACKUPS", 0), "the number of previous versions of each file kept, FILE.prev the latest, restored by the rollback command, disabled if zero")
+	flag.StringVar(&options.mfaPasscodeFile, "mfa-passcode-file", getEnv("VAULT_SIDEKICK_MFA_PASSCODE_FILE", ""), "the file holding the passcode of a login enforcing mfa, read at each login")
+	flag.StringVar(&options.mfaTOTPFile, "mfa-totp-file", getEnv("VAULT_SIDEKICK_MFA_TOTP_FILE", ""), "the file holding the base32 totp secret or otpauth:// uri the passcode of a login enforcing mfa is generated from")
+	flag.StringVar(&options.pkiLease, "pki-lease", getEnv("VAULT_SIDEKICK_PKI_LEASE", ""), "the kubernetes lease in the namespace of the pod the replicas take turns holding while issuing certificates, disabled if empty")
+	flag.DurationVar(&options.pkiLeaseDuration, "pki-lease-duration", time.Duration(30)*time.Second, "the time the pki lease is held for before another replica may take it")
+	flag.BoolVar(&options.waitForVault, "wait-for-vault", getEnvBool("VAULT_SIDEKICK_WAIT_FOR_VAULT", false), "wait for vault to be initialized, unsealed and active before starting")
+	flag.StringVar(&options.mockDir, "mock", "", "serve canned responses from a fixtures directory via a local mock vault, for testing only")
+	flag.StringVar(&options.otlpEndpoint, "otlp-endpoint", getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""), "the otlp/http endpoint to export traces to, tracing is disabled if empty")
+	flag.StringVar(&options.otlpServiceName, "otlp-service-name", getEnv("OTEL_SERVICE_NAME", prog), "the service name attached to the exported traces")
+	flag.Var(&options.maxResponseSize, "max-response-size", "the maximum size of a response from vault i.e. 1Mi, unlimited if zero")
+	flag.Var(&options.maxFileSize, "max-file-size", "the maximum size of a file written to the output directory i.e. 512k, unlimited if zero")
+	flag.BoolVar(&options.flock, "flock", getEnvBool("VAULT_SIDEKICK_FLOCK", false), "hold an exclusive lock on the .vault-sidekick.lock file of the output directory while writing a resource")
+	flag.StringVar(&options.podInfoDir, "pod-info", getEnv("VAULT_SIDEKICK_POD_INFO", ""), "the directory of a downward api volume holding the name, namespace, labels and annotations of the pod")
+	flag.BoolVar(&options.podInfoAPI, "pod-info-api", getEnvBool("VAULT_SIDEKICK_POD_INFO_API", false), "retrieve the labels and annotations of the pod from the kubernetes api, requires get on pods")
+	flag.BoolVar(&options.k8sAnnotations, "k8s-annotations", getEnvBool("VAULT_SIDEKICK_K8S_ANNOTATIONS", false), "add the resources given by the vault.sidekick/resource.N annotations of the pod, read via -pod-info or -pod-info-api")
+	flag.StringVar(&options.k8sConfigMap, "k8s-configmap", getEnv("VAULT_SIDEKICK_K8S_CONFIGMAP", ""), "a configmap, [namespace/]name, whose resources are watched, added and removed as it changes, requires get on configmaps")
+	flag.StringVar(&options.k8sConfigMapKey, "k8s-configmap-key", getEnv("VAULT_SIDEKICK_K8S_CONFIGMAP_KEY", defaultConfigMapKey), "the key of the configmap holding the resources, one per line")
+	flag.DurationVar(&options.k8sConfigMapInterval, "k8s-configmap-interval", time.Duration(30)*time.Second, "the interval the configmap is checked for a change")
+	flag.StringVar(&options.alertURL, "alert-url", getEnv("VAULT_SIDEKICK_ALERT_URL", ""), "the webhook an event is posted to when a resource has failed to update for longer than -alert-after, and once it recovers, disabled if empty")
+	flag.StringVar(&options.alertHeader, "alert-header", getEnv("VAULT_SIDEKICK_ALERT_HEADER", ""), "an additional header of the alert requests i.e. 'Authorization: GenieKey KEY'")
+	flag.StringVar(&options.alertTemplate, "alert-template", getEnv("VAULT_SIDEKICK_ALERT_TEMPLATE", ""), "the file holding the go template of the alert payload, a generic json payload if empty")
+	flag.DurationVar(&options.alertAfter, "alert-after", time.Duration(10)*time.Minute, "the time a resource has to be failing for before an alert is sent")
+	flag.StringVar(&options.pkcs11Module, "pkcs11-module", getEnv("VAULT_SIDEKICK_PKCS11_MODULE", ""), "the pkcs11 module of the token the private keys of the resources with key=pkcs11:URI are generated in i.e. /usr/lib/softhsm/libsofthsm2.so")
+	flag.StringVar(&options.pkcs11PinFile, "pkcs11-pin-file", getEnv("VAULT_SIDEKICK_PKCS11_PIN_FILE", ""), "the file holding the user pin of the pkcs11 token")
+	flag.BoolVar(&options.dev, "dev", getEnvBool("VAULT_SIDEKICK_DEV", false), "local development mode, log in via oidc in the browser, write the files under ./secrets with relaxed permissions and colorize the logs")
+	flag.BoolVar(&options.disableMlock, "disable-mlock", getEnvBool("VAULT_SIDEKICK_DISABLE_MLOCK", false), "do not lock the memory of the process, for environments where the IPC_LOCK capability can't be granted")
+	flag.BoolVar(&options.watchFiles, "watch-files", getEnvBool("VAULT_SIDEKICK_WATCH_FILES", false), "watch the files written, rewriting any deleted or modified by another process")
+	flag.DurationVar(&options.scanInterval, "scan-interval", time.Duration(0), "the interval the output directory is scanned for unexpected files, wrong permissions or ownership, disabled if zero")
+	flag.StringVar(&options.scanAction, "scan-action", getEnv("VAULT_SIDEKICK_SCAN_ACTION", scanActionReport), "report or fix the out of place files found by the scan, fixing removes the unexpected files")
+	flag.StringVar(&options.scanAllow, "scan-allow", getEnv("VAULT_SIDEKICK_SCAN_ALLOW", ""), "a comma separated list of patterns of the files expected in the output directory besides the resources i.e. *.conf,README")
+	flag.BoolVar(&options.auditAccess, "audit-access", getEnvBool("VAULT_SIDEKICK_AUDIT_ACCESS", false), "log the processes opening the files written via fanotify, linux only and requires CAP_SYS_ADMIN")
+	flag.StringVar(&options.tokenHelperFile, "token-helper-file", getEnv("VAULT_SIDEKICK_TOKEN_HELPER_FILE", ""), "the file the token is shared through with the tools of the pod using vault-sidekick token-helper as their vault token helper, disabled if empty")
+	flag.BoolVar(&options.execEnvOnly, "exec-env-only", getEnvBool("VAULT_SIDEKICK_EXEC_ENV_ONLY", false), "run the command following -- with the secrets as environment variables, never writing files, restarting it when they change")
+	flag.StringVar(&options.servePKI, "serve-pki", getEnv("VAULT_SIDEKICK_SERVE_PKI", ""), "the interface to serve the certificate, ca chain and crl of the pki resource with serve=true on over https i.e. 127.0.0.1:8443 or [::]:8443")
+	flag.BoolVar(&options.logChanges, "log-changes", getEnvBool("VAULT_SIDEKICK_LOG_CHANGES", false), "log the keys added, removed and changed on each update of a resource, values are hashed")
+	flag.BoolVar(&options.debugHTTP, "debug-http", getEnvBool("VAULT_SIDEKICK_DEBUG_HTTP", false), "capture a redacted trace of the requests to vault, the paths, status codes, durations, headers and field names, served by the admin api on /v1/debug/http")
+	flag.IntVar(&options.debugHTTPSize, "debug-http-size", getEnvInt("VAULT_SIDEKICK_DEBUG_HTTP_SIZE", 500), "the number of the most recent requests to vault traced by -debug-http")
+	flag.StringVar(&options.selinuxContext, "selinux-context", getEnv("VAULT_SIDEKICK_SELINUX_CONTEXT", ""), "the selinux context the files written and their directories are labeled with i.e. system_u:object_r:container_file_t:s0, disabled if empty")
+	flag.StringVar(&options.selinuxMode, "selinux-mode", getEnv("VAULT_SIDEKICK_SELINUX_MODE", selinuxModeSet), "set the selinux context of the files written, or verify they already have it, failing the write otherwise")
+	flag.BoolVar(&options.tui, "tui", getEnvBool("VAULT_SIDEKICK_TUI", false), "draw a live status screen of the resources on the terminal, with keys to renew, pause or resume them")
+	flag.DurationVar(&options.drainPeriod, "drain-period", time.Duration(0), "the longest time the leases are kept renewed after a SIGTERM, ended early by the application via POST /v1/shutdown on the admin api, disabled if zero")
+	flag.StringVar(&options.timeFormat, "time-format", getEnv("VAULT_SIDEKICK_TIME_FORMAT", timeFormatRFC3339), "the format of the times and durations in the metadata files, the admin api and the logs, rfc3339 or unix")
+	flag.StringVar(&options.listen, "listen", getEnv("VAULT_SIDEKICK_LISTEN", ""), "the interface to serve the health, metrics and admin api on i.e. 127.0.0.1:8080 or [::]:8080, disabled if empty")
+	flag.DurationVar(&options.cacheTTL, "cache-ttl", time.Duration(0), "the time reads of static secrets are cached and shared between resources, disabled if zero")
+	flag.StringVar(&options.onlyTags, "only-tags", getEnv("VAULT_SIDEKICK_ONLY_TAGS", ""), "only process the resources tagged with one of the tags, a comma separated list or VAULT_SIDEKICK_ONLY_TAGS")
+	flag.StringVar(&options.skipTags, "skip-tags", getEnv("VAULT_SIDEKICK_SKIP_TAGS", ""), "skip the resources tagged with one of the tags, a comma separated list or VAULT_SIDEKICK_SKIP_TAGS")
+	flag.StringVar(&options.stateFile, "state-file", getEnv("VAULT_SIDEKICK_STATE_FILE", ""), "the path to a file used to persist leases across restarts")
 }
 
 // parseOptions validate the command line options and validates them
 func parseOptions() error {
 	flag.Parse()
+	options.command = flag.Args()
 	return validateOptions(&options)
 }
 
@@ -121,6 +326,12 @@ func validateOptions(cfg *config) (err error) {
 		}
 	}
 
+	if cfg.dev {
+		if err := applyDevMode(cfg); err != nil {
+			return err
+		}
+	}
+
 	if cfg.vaultURL == "" {
 		cfg.vaultURL = os.Getenv("VAULT_ADDR")
 	}
@@ -130,8 +341,29 @@ func validateOptions(cfg *config) (err error) {
 	}
 
 	// step: validate the vault url
-	if _, err = url.Parse(cfg.vaultURL); err != nil {
-		return fmt.Errorf("invalid vault url: '%s' specified", cfg.vaultURL)
+	if err = validateURL(cfg.vaultURL); err != nil {
+		return fmt.Errorf("invalid vault url: '%s' specified, %s", cfg.vaultURL, err)
+	}
+
+	if cfg.vaultReadURL != "" {
+		if err := validateURL(cfg.vaultReadURL); err != nil {
+			return fmt.Errorf("invalid read address: '%s' specified, %s", cfg.vaultReadURL, err)
+		}
+	}
+
+	// step: validate any additional named vaults
+	if cfg.vaultAuthOptions != nil {
+		for name, vault := range cfg.vaultAuthOptions.Vaults {
+			if vault == nil || vault.VaultURL == "" {
+				return fmt.Errorf("the vault: %s has no address specified", name)
+			}
+			if err = validateURL(vault.VaultURL); err != nil {
+				return fmt.Errorf("invalid vault url: '%s' specified for the vault: %s, %s", vault.VaultURL, name, err)
+			}
+			if vault.Method == "" {
+				vault.Method = "token"
+			}
+		}
 	}
 
 	if cfg.vaultCaFile != "" {
@@ -140,9 +372,213 @@ func validateOptions(cfg *config) (err error) {
 		}
 	}
 
-	if cfg.skipTLSVerify == true && cfg.vaultCaFile != "" {
+	if cfg.vaultCaPath != "" {
+		if info, err := os.Stat(cfg.vaultCaPath); err != nil || !info.IsDir() {
+			return fmt.Errorf("the ca path: %s does not exist or is not a directory", cfg.vaultCaPath)
+		}
+	}
+
+	if (cfg.vaultClientCert == "") != (cfg.vaultClientKey == "") {
+		return fmt.Errorf("the client certificate and key must be given together")
+	}
+	for _, filename := range []string{cfg.vaultClientCert, cfg.vaultClientKey} {
+		if filename != "" {
+			if exists, _ := fileExists(filename); !exists {
+				return fmt.Errorf("the client certificate file: %s does not exist", filename)
+			}
+		}
+	}
+
+	if cfg.debugHTTP {
+		if cfg.listen == "" {
+			return fmt.Errorf("the debug-http option requires the admin api, see -listen")
+		}
+		if cfg.debugHTTPSize <= 0 {
+			return fmt.Errorf("the debug-http-size: %d must be positive", cfg.debugHTTPSize)
+		}
+	}
+
+	if cfg.selinuxContext != "" {
+		if err := validateSELinuxContext(cfg.selinuxContext); err != nil {
+			return err
+		}
+	}
+	if cfg.selinuxMode != "" {
+		if err := validateSELinuxMode(cfg.selinuxMode); err != nil {
+			return err
+		}
+	}
+
+	if cfg.drainPeriod < 0 {
+		return fmt.Errorf("the drain-period: %s must not be negative", cfg.drainPeriod)
+	}
+	if cfg.drainPeriod > 0 && (cfg.oneShot || cfg.then || cfg.execEnvOnly) {
+		return fmt.Errorf("the drain-period option can't be used with one-shot, then or exec-env-only")
+	}
+
+	switch cfg.timeFormat {
+	case "", timeFormatRFC3339, timeFormatUnix:
+	default:
+		return fmt.Errorf("the time-format: %s is invalid, should be %s or %s", cfg.timeFormat, timeFormatRFC3339, timeFormatUnix)
+	}
+
+	// step: validate the interfaces of the listeners
+	for name, address := range map[string]string{"listen": cfg.listen, "serve-pki": cfg.servePKI} {
+		if address == "" {
+			continue
+		}
+		if err := validateListenAddress(address); err != nil {
+			return fmt.Errorf("invalid %s address: '%s' specified, %s", name, address, err)
+		}
+	}
+
+	if cfg.otlpEndpoint != "" {
+		if err := validateURL(cfg.otlpEndpoint); err != nil {
+			return fmt.Errorf("invalid otlp endpoint: '%s' specified, %s", cfg.otlpEndpoint, err)
+		}
+	}
+
+	if cfg.skipTLSVerify == true && (cfg.vaultCaFile != "" || cfg.vaultCaPath != "") {
 		return fmt.Errorf("you are skipping the tls but supplying a CA, doesn't make sense")
 	}
 
+	if cfg.resourceDeadline < 0 {
+		return fmt.Errorf("the resource deadline: %s must not be negative", cfg.resourceDeadline)
+	}
+
+	if cfg.then {
+		if len(cfg.command) == 0 {
+			return fmt.Errorf("the then option requires a command i.e. -then -- /app/server")
+		}
+		if cfg.execEnvOnly {
+			return fmt.Errorf("the then option can't be used with exec-env-only")
+		}
+		cfg.oneShot = true
+	}
+	if cfg.tui && (cfg.oneShot || cfg.execEnvOnly || cfg.dryRun) {
+		return fmt.Errorf("the tui option can't be used with one-shot, then, exec-env-only or dryrun")
+	}
+
+	if cfg.startupConcurrency < 0 {
+		return fmt.Errorf("the startup concurrency: %d must not be negative", cfg.startupConcurrency)
+	}
+	if cfg.listPageSize < 0 {
+		return fmt.Errorf("the list page size: %d must not be negative", cfg.listPageSize)
+	}
+	if cfg.listConcurrency < 0 {
+		return fmt.Errorf("the list concurrency: %d must not be negative", cfg.listConcurrency)
+	}
+	if cfg.listMaxChildren < 0 {
+		return fmt.Errorf("the list max children: %d must not be negative", cfg.listMaxChildren)
+	}
+
+	if cfg.scanInterval < 0 {
+		return fmt.Errorf("the scan interval: %s must not be negative", cfg.scanInterval)
+	}
+
+	if cfg.k8sConfigMap != "" {
+		if cfg.oneShot {
+			return fmt.Errorf("the k8s-configmap option can't be used with one-shot")
+		}
+		if cfg.k8sConfigMapInterval <= 0 {
+			return fmt.Errorf("the k8s-configmap-interval: %s must be positive", cfg.k8sConfigMapInterval)
+		}
+	}
+
+	if cfg.outputDir == stdoutOutput && (cfg.printSchedule || cfg.scanInterval > 0) {
+		return fmt.Errorf("writing the resources to stdout does not support the print-schedule or scan-interval options")
+	}
+
+	if cfg.backups < 0 {
+		return fmt.Errorf("the number of backups: %d must not be negative", cfg.backups)
+	}
+
+	if cfg.alertURL != "" {
+		if err := validateURL(cfg.alertURL); err != nil {
+			return fmt.Errorf("invalid alert url: '%s' specified, %s", cfg.alertURL, err)
+		}
+		if cfg.alertAfter <= 0 {
+			return fmt.Errorf("the alert threshold: %s must be positive", cfg.alertAfter)
+		}
+		if cfg.alertHeader != "" && !strings.Contains(cfg.alertHeader, ":") {
+			return fmt.Errorf("the alert header: %s should be NAME: VALUE", cfg.alertHeader)
+		}
+	}
+
+	if cfg.pkiLease != "" && cfg.pkiLeaseDuration < time.Second {
+		return fmt.Errorf("the pki lease duration: %s must be at least a second", cfg.pkiLeaseDuration)
+	}
+
+	if cfg.execEnvOnly {
+		if len(cfg.command) == 0 {
+			return fmt.Errorf("the exec-env-only option requires a command i.e. -exec-env-only -- /bin/app")
+		}
+		if cfg.oneShot || cfg.stateFile != "" {
+			return fmt.Errorf("the exec-env-only option can't be used with one-shot or a state file")
+		}
+	}
+
+	// step: add the resources given by the annotations of the pod
+	var pod *podInfo
+	if cfg.k8sAnnotations {
+		if cfg.podInfoDir == "" && !cfg.podInfoAPI {
+			return fmt.Errorf("the k8s-annotations option requires the pod-info or pod-info-api option")
+		}
+		if pod, err = loadPodInfo(cfg.podInfoDir, cfg.podInfoAPI); err != nil {
+			return err
+		}
+		if cfg.resources == nil {
+			cfg.resources = new(VaultResources)
+		}
+		for _, x := range annotationResources(pod.Annotations) {
+			cfg.resources.collect("annotation "+x.name, x.value)
+		}
+	}
+
+	if cfg.resources != nil {
+		served := 0
+		for _, rn := range cfg.resources.items {
+			if rn.servePKI {
+				served++
+			}
+		}
+		if (served > 0 || cfg.servePKI != "") && served != 1 {
+			return fmt.Errorf("the serve-pki option requires a single pki resource with serve=true, found %d", served)
+		}
+		if served > 0 && cfg.servePKI == "" {
+			return fmt.Errorf("the serve option on a resource requires the serve-pki option")
+		}
+	}
+
+	// step: select the resources by their tags
+	if cfg.resources != nil && (cfg.onlyTags != "" || cfg.skipTags != "") {
+		count := len(cfg.resources.items)
+		cfg.resources.items = selectResources(cfg.resources.items, splitTags(cfg.onlyTags), splitTags(cfg.skipTags))
+		if count > 0 && len(cfg.resources.items) == 0 {
+			return fmt.Errorf("none of the %d resources match the only-tags and skip-tags options", count)
+		}
+	}
+
+	// step: report every invalid resource at once, rather than one per deploy
+	if cfg.resources != nil {
+		if err := cfg.resources.validate(cfg); err != nil {
+			return err
+		}
+	}
+
+	// step: expand any pod placeholders in the resources
+	if cfg.resources != nil && hasPodPlaceholders(cfg.resources.items) {
+		if pod == nil {
+			if pod, err = loadPodInfo(cfg.podInfoDir, cfg.podInfoAPI); err != nil {
+				return err
+			}
+		}
+		for _, rn := range cfg.resources.items {
+			if err := expandPodPlaceholders(rn, pod); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
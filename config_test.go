@@ -1,7 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -75,3 +80,175 @@ func TestValidateOptionsWithVaultURLFromAuthFile(t *testing.T) {
 		t.Errorf("Expected Vault URL to be %s got %s", expected, actual)
 	}
 }
+
+func TestValidateOptionsWithNamedVaults(t *testing.T) {
+	cfg := &config{
+		vaultAuthFile: "tests/multi_vault_auth_file.json",
+	}
+	err := validateOptions(cfg)
+
+	if err != nil {
+		t.Errorf("raising an error %v", err)
+	}
+	if len(cfg.vaultAuthOptions.Vaults) != 2 {
+		t.Fatalf("Expected 2 named vaults got %d", len(cfg.vaultAuthOptions.Vaults))
+	}
+
+	team := cfg.vaultAuthOptions.Vaults["team"]
+	if team.VaultURL != "https://vault.team:8200" || team.Method != "approle" || team.RoleID != "admin" {
+		t.Errorf("Unexpected options for the team vault: %v", team)
+	}
+
+	legacy := cfg.vaultAuthOptions.Vaults["legacy"]
+	if legacy.Method != "token" {
+		t.Errorf("Expected the legacy vault to default to token got %s", legacy.Method)
+	}
+}
+
+func TestValidateOptionsWithCAPath(t *testing.T) {
+	cfg := &config{vaultCaPath: "tests/does_not_exist"}
+	if err := validateOptions(cfg); err == nil {
+		t.Errorf("should have raised error")
+	}
+
+	cfg = &config{vaultCaPath: "tests", skipTLSVerify: true}
+	if err := validateOptions(cfg); err == nil {
+		t.Errorf("should have raised error")
+	}
+
+	cfg = &config{vaultCaPath: "tests"}
+	if err := validateOptions(cfg); err != nil {
+		t.Errorf("raised an error: %v", err)
+	}
+}
+
+func TestValidateOptionsWithExecEnvOnly(t *testing.T) {
+	cfg := &config{vaultURL: "http://127.0.0.1:8200", execEnvOnly: true}
+	if err := validateOptions(cfg); err == nil {
+		t.Errorf("should have raised error")
+	}
+
+	cfg = &config{vaultURL: "http://127.0.0.1:8200", execEnvOnly: true, command: []string{"/bin/app"}, oneShot: true}
+	if err := validateOptions(cfg); err == nil {
+		t.Errorf("should have raised error")
+	}
+
+	cfg = &config{vaultURL: "http://127.0.0.1:8200", execEnvOnly: true, command: []string{"/bin/app"}}
+	if err := validateOptions(cfg); err != nil {
+		t.Errorf("raised an error: %v", err)
+	}
+}
+
+func TestBuildHTTPTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatalf("unable to create a directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	content, _ := ioutil.ReadFile("tests/fixtures/pki/issue/example.json")
+	fixture := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if err := json.Unmarshal(content, &fixture); err != nil {
+		t.Fatalf("unable to read the fixture: %s", err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "ca.pem"), []byte(fixture.Data["issuing_ca"].(string)), 0644)
+
+	transport, err := buildHTTPTransport(&config{vaultCaPath: dir, tlsServerName: "vault.example.com"})
+	if err != nil {
+		t.Fatalf("raised an error: %v", err)
+	}
+	if transport.TLSClientConfig.RootCAs == nil || len(transport.TLSClientConfig.RootCAs.Subjects()) != 1 {
+		t.Errorf("expected the ca to be loaded from the ca path")
+	}
+	if transport.TLSClientConfig.ServerName != "vault.example.com" {
+		t.Errorf("expected the server name to be set")
+	}
+
+	if _, err := buildHTTPTransport(&config{vaultCaFile: "tests/kubernetes_vault_auth_file.json"}); err == nil {
+		t.Errorf("should have raised error, the ca file has no certificates")
+	}
+
+	// step: a client certificate is presented
+	ioutil.WriteFile(filepath.Join(dir, "client.pem"), []byte(fixture.Data["certificate"].(string)), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "client-key.pem"), []byte(fixture.Data["private_key"].(string)), 0600)
+	transport, err = buildHTTPTransport(&config{vaultClientCert: filepath.Join(dir, "client.pem"), vaultClientKey: filepath.Join(dir, "client-key.pem")})
+	if err != nil {
+		t.Fatalf("raised an error: %v", err)
+	}
+	if len(transport.TLSClientConfig.Certificates) != 1 {
+		t.Errorf("expected the client certificate to be loaded")
+	}
+	if _, err := buildHTTPTransport(&config{vaultClientCert: filepath.Join(dir, "ca.pem"), vaultClientKey: filepath.Join(dir, "ca.pem")}); err == nil {
+		t.Errorf("should have raised error, the key is not a private key")
+	}
+}
+
+func TestValidateOptionsWithClientCert(t *testing.T) {
+	cfg := &config{vaultURL: "http://127.0.0.1:8200", vaultClientCert: "tests/does_not_exist.pem"}
+	if err := validateOptions(cfg); err == nil {
+		t.Errorf("should have raised error, the key is missing")
+	}
+
+	cfg = &config{vaultURL: "http://127.0.0.1:8200", vaultClientCert: "tests/does_not_exist.pem", vaultClientKey: "tests/does_not_exist.pem"}
+	if err := validateOptions(cfg); err == nil {
+		t.Errorf("should have raised error, the files don't exist")
+	}
+}
+
+func TestNewAPIClientNamespace(t *testing.T) {
+	namespace := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		namespace <- req.Header.Get("X-Vault-Namespace")
+		w.Write([]byte(`{"data": {"value": "test"}}`))
+	}))
+	defer server.Close()
+
+	client, err := newAPIClient(&config{vaultNamespace: "team-a", vaultMaxRetries: -1}, server.URL)
+	if err != nil {
+		t.Fatalf("raised an error: %v", err)
+	}
+	if _, err := client.Logical().Read("secret/app"); err != nil {
+		t.Fatalf("raised an error: %v", err)
+	}
+	if ns := <-namespace; ns != "team-a" {
+		t.Errorf("expected the namespace header to be team-a, got %q", ns)
+	}
+}
+
+func TestValidateOptionsWithK8sAnnotations(t *testing.T) {
+	cfg := &config{vaultURL: "http://127.0.0.1:8200", k8sAnnotations: true}
+	if err := validateOptions(cfg); err == nil {
+		t.Errorf("should have raised error, no source of the pod metadata")
+	}
+
+	dir, err := ioutil.TempDir("", "annotations")
+	if err != nil {
+		t.Fatalf("raised an error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	annotations := "vault.sidekick/resource.2=\"pki:pki/issue/web:common_name={pod.name}.svc\"\n" +
+		"vault.sidekick/resource.1=\"secret:secret/db:fmt=env\"\n"
+	ioutil.WriteFile(filepath.Join(dir, "name"), []byte("web"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "annotations"), []byte(annotations), 0644)
+
+	cfg = &config{vaultURL: "http://127.0.0.1:8200", k8sAnnotations: true, podInfoDir: dir}
+	if err := validateOptions(cfg); err != nil {
+		t.Fatalf("raised an error: %v", err)
+	}
+	if len(cfg.resources.items) != 2 {
+		t.Fatalf("expected two resources, got %d", len(cfg.resources.items))
+	}
+	if cfg.resources.items[0].path != "secret/db" || cfg.resources.items[0].format != "env" {
+		t.Errorf("unexpected first resource: %s", cfg.resources.items[0])
+	}
+	if cn := cfg.resources.items[1].options["common_name"]; cn != "web.svc" {
+		t.Errorf("expected the pod placeholder to be expanded, got %q", cn)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "annotations"), []byte("vault.sidekick/resource.1=\"secret\"\n"), 0644)
+	cfg = &config{vaultURL: "http://127.0.0.1:8200", k8sAnnotations: true, podInfoDir: dir}
+	if err := validateOptions(cfg); err == nil {
+		t.Errorf("should have raised error, the resource is invalid")
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// defaultConfigMapKey is the key of the configmap holding the resources
+const defaultConfigMapKey = "resources"
+
+// kubernetesConfigMap is the part of a configmap read by the sidekick
+type kubernetesConfigMap struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+// configMapWatcher adds and removes the resources as they are changed in a configmap, so the secrets a fleet of
+// sidekicks retrieves is adjusted without rolling the deployments
+type configMapWatcher struct {
+	// the client of the kubernetes api
+	api *kubernetesAPI
+	// the namespace and name of the configmap
+	namespace string
+	name      string
+	// the key holding the resources, one per line
+	key string
+	// the services of the vaults the resources are retrieved from
+	services map[string]*VaultService
+	// the resource version of the configmap last applied
+	version string
+	// the resources of the configmap, by the line given
+	current map[string][]*VaultResource
+}
+
+// newConfigMapWatcher creates a watcher of the configmap, in the namespace of the pod unless given
+//	ref			: the configmap i.e. sidekick-resources or platform/sidekick-resources
+//	key			: the key holding the resources
+//	services	: the services of the vaults
+func newConfigMapWatcher(ref, key string, services map[string]*VaultService) (*configMapWatcher, error) {
+	namespace, name := podNamespace(), ref
+	if i := strings.Index(ref, "/"); i >= 0 {
+		namespace, name = ref[:i], ref[i+1:]
+	}
+	if namespace == "" || name == "" {
+		return nil, fmt.Errorf("the configmap: %s should be NAME or NAMESPACE/NAME", ref)
+	}
+	api, err := newKubernetesAPI()
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		key = defaultConfigMapKey
+	}
+
+	return &configMapWatcher{
+		api:       api,
+		namespace: namespace,
+		name:      name,
+		key:       key,
+		services:  services,
+		current:   make(map[string][]*VaultResource, 0),
+	}, nil
+}
+
+// String returns the namespace and name of the configmap
+func (w *configMapWatcher) String() string {
+	return w.namespace + "/" + w.name
+}
+
+// initial reads the resources of the configmap at startup, they are watched along with those of the command line
+func (w *configMapWatcher) initial() ([]*VaultResource, error) {
+	specs, lines, version, err := w.read()
+	if err != nil {
+		return nil, err
+	}
+	resources, err := w.parse(specs, lines)
+	if err != nil {
+		return nil, err
+	}
+	w.version = version
+	w.current = resources
+
+	var list []*VaultResource
+	for _, spec := range specs {
+		list = append(list, resources[spec]...)
+	}
+	glog.Infof("added %d resources from the configmap: %s", len(list), w)
+
+	return list, nil
+}
+
+// start checks the configmap for a change on the interval
+func (w *configMapWatcher) start(interval time.Duration) {
+	go func() {
+		for range time.NewTicker(interval).C {
+			if err := w.sync(); err != nil {
+				glog.Errorf("unable to update the resources from the configmap: %s, keeping the current resources, error: %s", w, err)
+			}
+		}
+	}()
+}
+
+// sync applies a change of the configmap, removing the resources no longer given and adding the new ones;
+// an invalid configmap leaves the resources as they are
+func (w *configMapWatcher) sync() error {
+	specs, lines, version, err := w.read()
+	if err != nil {
+		return err
+	}
+	if version == w.version {
+		return nil
+	}
+	// step: only the lines added need parsing, the rest are kept as they are
+	var added []string
+	for _, spec := range specs {
+		if _, found := w.current[spec]; !found {
+			added = append(added, spec)
+		}
+	}
+	resources, err := w.parse(added, lines)
+	if err != nil {
+		return err
+	}
+
+	given := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		given[spec] = true
+	}
+	removed := 0
+	for spec, items := range w.current {
+		if given[spec] {
+			continue
+		}
+		for _, rn := range items {
+			glog.Infof("the resource: %s was removed from the configmap: %s, no longer watching it", rn, w)
+			w.services[rn.vault].Unwatch(rn)
+			registry.unregister(rn)
+			schedule.remove(rn)
+			removed++
+		}
+		delete(w.current, spec)
+	}
+	count := 0
+	for _, spec := range added {
+		for _, rn := range resources[spec] {
+			glog.Infof("the resource: %s was added to the configmap: %s, watching it", rn, w)
+			registry.register(rn)
+			w.services[rn.vault].Watch(rn)
+			count++
+		}
+		w.current[spec] = resources[spec]
+	}
+	w.version = version
+	glog.Infof("updated the resources from the configmap: %s, added: %d, removed: %d", w, count, removed)
+
+	return nil
+}
+
+// read retrieves the resources of the configmap, a line each, ignoring blank lines and comments, along with
+// the line number of each and the version of the configmap
+func (w *configMapWatcher) read() ([]string, map[string]int, string, error) {
+	var configMap kubernetesConfigMap
+	status, err := w.api.do("GET", fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", w.namespace, w.name), nil, &configMap)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("unable to retrieve the configmap: %s, error: %s", w, err)
+	}
+	if status != http.StatusOK {
+		return nil, nil, "", fmt.Errorf("unable to retrieve the configmap: %s, status: %d, requires get on configmaps", w, status)
+	}
+	content, found := configMap.Data[w.key]
+	if !found {
+		return nil, nil, "", fmt.Errorf("the configmap: %s has no key: %s", w, w.key)
+	}
+
+	var specs []string
+	lines := make(map[string]int, 0)
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if _, found := lines[line]; line == "" || strings.HasPrefix(line, "#") || found {
+			continue
+		}
+		lines[line] = i + 1
+		specs = append(specs, line)
+	}
+
+	return specs, lines, configMap.Metadata.ResourceVersion, nil
+}
+
+// parse parses and validates the resources, selecting them by tag and expanding any pod placeholders as for
+// the resources of the command line, reporting every invalid resource together
+//	specs		: the resources, as given to -cn
+//	lines		: the line number of each resource in the configmap
+func (w *configMapWatcher) parse(specs []string, lines map[string]int) (map[string][]*VaultResource, error) {
+	var resources VaultResources
+	origins := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		origin := fmt.Sprintf("configmap %s line #%d", w, lines[spec])
+		origins[origin] = spec
+		resources.collect(origin, spec)
+	}
+	resources.items = selectResources(resources.items, splitTags(options.onlyTags), splitTags(options.skipTags))
+	if err := resources.validate(&options); err != nil {
+		return nil, err
+	}
+	for _, rn := range resources.items {
+		if _, found := w.services[rn.vault]; !found {
+			return nil, fmt.Errorf("the resource: %s references an unknown vault: %s", rn, rn.vault)
+		}
+	}
+	if hasPodPlaceholders(resources.items) {
+		pod, err := loadPodInfo(options.podInfoDir, options.podInfoAPI)
+		if err != nil {
+			return nil, err
+		}
+		for _, rn := range resources.items {
+			if err := expandPodPlaceholders(rn, pod); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	list := make(map[string][]*VaultResource, len(specs))
+	for _, spec := range specs {
+		list[spec] = nil
+	}
+	for _, rn := range resources.items {
+		spec := origins[rn.origin]
+		list[spec] = append(list[spec], rn)
+	}
+
+	return list, nil
+}
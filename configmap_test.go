@@ -0,0 +1,136 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConfigMapServer is a kubernetes api holding a single configmap, the version bumped on each change
+func fakeConfigMapServer() (*httptest.Server, func(string)) {
+	var lock sync.Mutex
+	var content string
+	version := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.URL.Path != "/api/v1/namespaces/platform/configmaps/sidekick" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var configMap kubernetesConfigMap
+		configMap.Metadata.ResourceVersion = strconv.Itoa(version)
+		configMap.Data = map[string]string{defaultConfigMapKey: content}
+		json.NewEncoder(w).Encode(configMap)
+	}))
+
+	return server, func(resources string) {
+		lock.Lock()
+		defer lock.Unlock()
+		content = resources
+		version++
+	}
+}
+
+func TestConfigMapWatcher(t *testing.T) {
+	service, updates := newMockService(t)
+
+	dir, err := ioutil.TempDir("", "serviceaccount")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "token"), []byte("sa-token"), 0600))
+	original, originalRegistry := serviceAccountDir, registry
+	serviceAccountDir, registry = dir, newStatusRegistry()
+	defer func() { serviceAccountDir, registry = original, originalRegistry }()
+
+	server, update := fakeConfigMapServer()
+	defer server.Close()
+	update("# the secrets of the fleet\nsecret:secret/app:fmt=json\n\nmysql:mysql/creds/app:fmt=json\n")
+	w := &configMapWatcher{
+		api:       &kubernetesAPI{client: server.Client(), address: server.URL},
+		namespace: "platform",
+		name:      "sidekick",
+		key:       defaultConfigMapKey,
+		services:  map[string]*VaultService{"": service},
+		current:   make(map[string][]*VaultResource, 0),
+	}
+
+	items, err := w.initial()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !assert.Len(t, items, 2) {
+		t.FailNow()
+	}
+	assert.Equal(t, "configmap platform/sidekick line #2", items[0].origin)
+	for _, rn := range items {
+		registry.register(rn)
+		service.Watch(rn)
+	}
+	for range items {
+		assert.Equal(t, EventTypeSuccess, waitForEvent(t, updates).Type)
+	}
+
+	// step: an unchanged configmap is left alone
+	assert.NoError(t, w.sync())
+
+	// step: an invalid configmap keeps the current resources
+	update("secret:secret/app:fmt=json\npki:pki/issue/web\n")
+	err = w.sync()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "configmap platform/sidekick line #2")
+	assert.Len(t, w.current, 2)
+
+	// step: the removed resource is dropped and the added one retrieved
+	update("secret:secret/app:fmt=json\nsecret:secret/single:fmt=json\n")
+	assert.NoError(t, w.sync())
+	evt := waitForEvent(t, updates)
+	assert.Equal(t, EventTypeSuccess, evt.Type)
+	assert.Equal(t, "secret/single", evt.Resource.path)
+	assert.Len(t, w.current, 2)
+	var paths []string
+	for _, x := range registry.list() {
+		paths = append(paths, x.Path)
+	}
+	sort.Strings(paths)
+	assert.Equal(t, []string{"secret/app", "secret/single"}, paths)
+	select {
+	case evt := <-updates:
+		t.Errorf("unexpected event for the resource: %s", evt.Resource)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestNewConfigMapWatcher(t *testing.T) {
+	_, err := newConfigMapWatcher("platform/", defaultConfigMapKey, nil)
+	assert.Error(t, err)
+}
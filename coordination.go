@@ -0,0 +1,191 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	// issuance coordinates the issuing of certificates with the other replicas via a kubernetes lease, nil if disabled
+	issuance *issuanceLock
+	// issuanceRetryInterval is the interval the lease is checked while held by another replica
+	issuanceRetryInterval = time.Duration(2) * time.Second
+)
+
+// leaseTimeFormat is the format of the times of a kubernetes lease
+const leaseTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// kubernetesLease is the part of a coordination.k8s.io/v1 lease we are interested in
+type kubernetesLease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+	} `json:"spec"`
+}
+
+// expired checks if the holder of the lease has let it lapse, i.e. the replica died while issuing
+func (l kubernetesLease) expired(now time.Time) bool {
+	renewed, err := time.Parse(time.RFC3339Nano, l.Spec.RenewTime)
+	if err != nil {
+		return true
+	}
+
+	return now.After(renewed.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// issuanceLock is a kubernetes lease the replicas sharing a pki role take turns holding while issuing a
+// certificate, so a mass restart doesn't exceed the rate limits of the role
+type issuanceLock struct {
+	// the client of the kubernetes api
+	api *kubernetesAPI
+	// the namespace of the lease
+	namespace string
+	// the name of the lease
+	name string
+	// the identity of the replica, the name of the pod
+	identity string
+	// the time the lease is held for before another replica may take it
+	duration time.Duration
+}
+
+// newIssuanceLock creates the lock of the lease in the namespace of the pod
+//	name		: the name of the lease
+//	duration	: the time the lease is held for
+func newIssuanceLock(name string, duration time.Duration) (*issuanceLock, error) {
+	api, err := newKubernetesAPI()
+	if err != nil {
+		return nil, err
+	}
+	namespace := podNamespace()
+	if namespace == "" {
+		return nil, fmt.Errorf("the namespace of the pod is unknown")
+	}
+
+	return &issuanceLock{
+		api:       api,
+		namespace: namespace,
+		name:      name,
+		identity:  podName(),
+		duration:  duration,
+	}, nil
+}
+
+// acquire waits for the lease before a certificate of the resource is issued, returning a function to release it;
+// should the lease be held by others for twice its duration the attempt fails and is retried, while a failure to
+// reach the kubernetes api issues the certificate regardless, the coordination being a courtesy to vault
+//	rn			: the resource being issued
+func (l *issuanceLock) acquire(rn *VaultResource) (func(), error) {
+	deadline := time.Now().Add(2 * l.duration)
+	for {
+		acquired, holder, err := l.tryAcquire(time.Now())
+		if err != nil {
+			glog.Errorf("unable to take the lease: %s for resource: %s, issuing regardless, error: %s", l.name, rn, err)
+			return func() {}, nil
+		}
+		if acquired {
+			glog.V(4).Infof("took the lease: %s to issue resource: %s", l.name, rn)
+			return l.release, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the lease: %s held by: %s", l.name, holder)
+		}
+		glog.V(4).Infof("the lease: %s is held by: %s, waiting to issue resource: %s", l.name, holder, rn)
+		time.Sleep(issuanceRetryInterval)
+	}
+}
+
+// tryAcquire takes the lease if it's free, expired or already ours, returning the holder if not
+func (l *issuanceLock) tryAcquire(now time.Time) (bool, string, error) {
+	var lease kubernetesLease
+	status, err := l.api.do("GET", l.path(), nil, &lease)
+	if err != nil {
+		return false, "", err
+	}
+	switch status {
+	case http.StatusOK:
+		holder := lease.Spec.HolderIdentity
+		if holder != "" && holder != l.identity && !lease.expired(now) {
+			return false, holder, nil
+		}
+		l.hold(&lease, now)
+		status, err = l.api.do("PUT", l.path(), &lease, nil)
+	case http.StatusNotFound:
+		lease = kubernetesLease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		lease.Metadata.Name = l.name
+		lease.Metadata.Namespace = l.namespace
+		l.hold(&lease, now)
+		status, err = l.api.do("POST", fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", l.namespace), &lease, nil)
+	default:
+		return false, "", fmt.Errorf("unexpected status code: %d from the kubernetes api", status)
+	}
+	if err != nil {
+		return false, "", err
+	}
+	switch status {
+	case http.StatusOK, http.StatusCreated:
+		return true, "", nil
+	case http.StatusConflict:
+		// step: another replica beat us to it
+		return false, "another replica", nil
+	}
+
+	return false, "", fmt.Errorf("unexpected status code: %d from the kubernetes api", status)
+}
+
+// hold sets the lease as held by the replica
+func (l *issuanceLock) hold(lease *kubernetesLease, now time.Time) {
+	lease.Spec.HolderIdentity = l.identity
+	lease.Spec.LeaseDurationSeconds = int(l.duration.Seconds())
+	lease.Spec.AcquireTime = now.UTC().Format(leaseTimeFormat)
+	lease.Spec.RenewTime = lease.Spec.AcquireTime
+}
+
+// release gives up the lease once the certificate is issued, so the next replica needn't wait for it to expire
+func (l *issuanceLock) release() {
+	var lease kubernetesLease
+	status, err := l.api.do("GET", l.path(), nil, &lease)
+	if err == nil && status == http.StatusOK && lease.Spec.HolderIdentity == l.identity {
+		lease.Spec.HolderIdentity = ""
+		status, err = l.api.do("PUT", l.path(), &lease, nil)
+	}
+	if err != nil {
+		glog.Errorf("unable to release the lease: %s, error: %s", l.name, err)
+		return
+	}
+	if status != http.StatusOK {
+		glog.Errorf("unable to release the lease: %s, status code: %d", l.name, status)
+	}
+}
+
+// path is the path of the lease in the kubernetes api
+func (l *issuanceLock) path() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", l.namespace, l.name)
+}
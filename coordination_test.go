@@ -0,0 +1,146 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLeaseServer is a kubernetes api holding a single lease, rejecting stale updates
+func fakeLeaseServer() (*httptest.Server, func() *kubernetesLease) {
+	var lock sync.Mutex
+	var current *kubernetesLease
+	version := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.Method {
+		case "GET":
+			if current == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(current)
+		case "POST", "PUT":
+			var lease kubernetesLease
+			json.NewDecoder(r.Body).Decode(&lease)
+			if (r.Method == "POST" && current != nil) || (r.Method == "PUT" && lease.Metadata.ResourceVersion != current.Metadata.ResourceVersion) {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			version++
+			lease.Metadata.ResourceVersion = strconv.Itoa(version)
+			current = &lease
+			if r.Method == "POST" {
+				w.WriteHeader(http.StatusCreated)
+			}
+			json.NewEncoder(w).Encode(current)
+		}
+	}))
+
+	return server, func() *kubernetesLease {
+		lock.Lock()
+		defer lock.Unlock()
+		return current
+	}
+}
+
+func TestIssuanceLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serviceaccount")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "token"), []byte("sa-token"), 0600))
+	original := serviceAccountDir
+	serviceAccountDir = dir
+	defer func() { serviceAccountDir = original }()
+
+	server, lease := fakeLeaseServer()
+	defer server.Close()
+	api := &kubernetesAPI{client: server.Client(), address: server.URL}
+	web0 := &issuanceLock{api: api, namespace: "payments", name: "pki", identity: "web-0", duration: time.Duration(30) * time.Second}
+	web1 := &issuanceLock{api: api, namespace: "payments", name: "pki", identity: "web-1", duration: time.Duration(30) * time.Second}
+	now := time.Now()
+
+	// step: the first replica creates and holds the lease, the second has to wait
+	acquired, _, err := web0.tryAcquire(now)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, "web-0", lease().Spec.HolderIdentity)
+	acquired, holder, err := web1.tryAcquire(now)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+	assert.Equal(t, "web-0", holder)
+
+	// step: once released the second replica takes it
+	web0.release()
+	assert.Equal(t, "", lease().Spec.HolderIdentity)
+	acquired, _, err = web1.tryAcquire(now)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	// step: a lease left to expire by a replica is taken over
+	acquired, _, err = web0.tryAcquire(now.Add(time.Duration(31) * time.Second))
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, "web-0", lease().Spec.HolderIdentity)
+}
+
+func TestIssuanceLockTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serviceaccount")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "token"), []byte("sa-token"), 0600))
+	original, interval := serviceAccountDir, issuanceRetryInterval
+	serviceAccountDir, issuanceRetryInterval = dir, time.Duration(10)*time.Millisecond
+	defer func() { serviceAccountDir, issuanceRetryInterval = original, interval }()
+
+	server, _ := fakeLeaseServer()
+	defer server.Close()
+	api := &kubernetesAPI{client: server.Client(), address: server.URL}
+	// step: the lease is held well past the wait of the other replica
+	web0 := &issuanceLock{api: api, namespace: "payments", name: "pki", identity: "web-0", duration: time.Hour}
+	web1 := &issuanceLock{api: api, namespace: "payments", name: "pki", identity: "web-1", duration: time.Duration(50) * time.Millisecond}
+	release, err := web0.acquire(defaultVaultResource())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = web1.acquire(defaultVaultResource())
+	assert.Error(t, err)
+
+	// step: the api being unreachable issues regardless
+	release()
+	server.Close()
+	release, err = web1.acquire(defaultVaultResource())
+	assert.NoError(t, err)
+	assert.NotNil(t, release)
+}
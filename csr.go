@@ -0,0 +1,284 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// keySourceLocal generates the private key in the sidekick, only the csr is sent to vault
+	keySourceLocal = "local"
+	// pkcs11Scheme prefixes the pkcs11 uri of a private key held in a token, as per rfc 7512
+	pkcs11Scheme = "pkcs11:"
+)
+
+var (
+	// pkcs11ToolCommand is the opensc tool the private key is looked up and generated in the token with
+	pkcs11ToolCommand = "pkcs11-tool"
+	// opensslCommand is the openssl binary the csr of a key in a token is built with, via the pkcs11 engine
+	opensslCommand = "openssl"
+)
+
+// signCSR issues a certificate from a pki sign path for a private key which never leaves the host: the key is
+// either generated locally or held in a pkcs11 token, and vault is only ever sent the csr
+//	rn			: the watched resource
+//	params		: the parameters of the sign request
+func (r VaultService) signCSR(rn *watchedResource, params map[string]interface{}) (*api.Secret, error) {
+	commonName, dnsNames, ips := csrNames(params)
+	keyType, keyBits := fmt.Sprintf("%v", params["key_type"]), 0
+	if x, found := params["key_bits"]; found {
+		keyBits, _ = strconv.Atoi(fmt.Sprintf("%v", x))
+	}
+	if _, found := params["key_type"]; !found {
+		keyType = "ec"
+	}
+	// step: the key parameters are ours, the sign endpoint takes the key of the csr
+	delete(params, "key_type")
+	delete(params, "key_bits")
+
+	var csr, key []byte
+	var err error
+	if rn.resource.keySource == keySourceLocal {
+		csr, key, err = localCSR(commonName, dnsNames, ips, keyType, keyBits)
+	} else {
+		csr, err = pkcs11CSR(rn.resource.keySource, commonName, dnsNames, ips, keyType, keyBits)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the csr of resource: %s, error: %s", rn.resource, err)
+	}
+	params["csr"] = string(csr)
+
+	r.clampPKITTL(rn, params)
+	secret, err := r.client.Logical().Write(rn.resource.path, params)
+	if err != nil || secret == nil {
+		zeroBytes(key)
+		return secret, err
+	}
+	if key != nil {
+		secret.Data["private_key"] = string(key)
+		zeroBytes(key)
+	}
+	glog.V(3).Infof("resource: %s, signed the csr of the %s key", rn.resource, keyType)
+
+	return secret, nil
+}
+
+// csrNames returns the subject and alternative names of the csr from the parameters of the request
+func csrNames(params map[string]interface{}) (string, []string, []net.IP) {
+	var dnsNames []string
+	var ips []net.IP
+	commonName := fmt.Sprintf("%v", params["common_name"])
+	if _, found := params["common_name"]; !found {
+		commonName = ""
+	}
+	if x, found := params["alt_names"]; found {
+		for _, name := range strings.Split(fmt.Sprintf("%v", x), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				dnsNames = append(dnsNames, name)
+			}
+		}
+	}
+	if x, found := params["ip_sans"]; found {
+		for _, address := range strings.Split(fmt.Sprintf("%v", x), ",") {
+			if ip := net.ParseIP(strings.TrimSpace(address)); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	return commonName, dnsNames, ips
+}
+
+// localCSR generates a private key and its csr, returning both pem encoded
+//	commonName	: the common name of the certificate
+//	dnsNames	: the dns alternative names
+//	ips			: the ip alternative names
+//	keyType		: rsa or ec
+//	keyBits		: the size of the key, the default of the type if zero
+func localCSR(commonName string, dnsNames []string, ips []net.IP, keyType string, keyBits int) ([]byte, []byte, error) {
+	var signer crypto.Signer
+	var block *pem.Block
+	switch keyType {
+	case "rsa":
+		if keyBits == 0 {
+			keyBits = 2048
+		}
+		key, err := rsa.GenerateKey(rand.Reader, keyBits)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, block = key, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case "ec":
+		curves := map[int]elliptic.Curve{0: elliptic.P256(), 224: elliptic.P224(), 256: elliptic.P256(), 384: elliptic.P384(), 521: elliptic.P521()}
+		curve, found := curves[keyBits]
+		if !found {
+			return nil, nil, fmt.Errorf("the ec key size: %d is not supported", keyBits)
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		encoded, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, block = key, &pem.Block{Type: "EC PRIVATE KEY", Bytes: encoded}
+	default:
+		return nil, nil, fmt.Errorf("the key type: %s is not supported, should be rsa or ec", keyType)
+	}
+	defer zeroBytes(block.Bytes)
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: commonName},
+		DNSNames:    dnsNames,
+		IPAddresses: ips,
+	}, signer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), pem.EncodeToMemory(block), nil
+}
+
+// parsePKCS11URI returns the path attributes of a pkcs11 uri i.e. pkcs11:token=sidekick;object=web
+func parsePKCS11URI(uri string) (map[string]string, error) {
+	attributes := make(map[string]string, 0)
+	path := strings.TrimPrefix(uri, pkcs11Scheme)
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	for _, x := range strings.Split(path, ";") {
+		if x == "" {
+			continue
+		}
+		items := strings.SplitN(x, "=", 2)
+		if len(items) != 2 {
+			return nil, fmt.Errorf("the pkcs11 uri attribute: %s should be NAME=VALUE", x)
+		}
+		value, err := url.PathUnescape(items[1])
+		if err != nil {
+			return nil, fmt.Errorf("the pkcs11 uri attribute: %s is invalid, error: %s", x, err)
+		}
+		attributes[items[0]] = value
+	}
+	if attributes["object"] == "" {
+		return nil, fmt.Errorf("the pkcs11 uri: %s must name the key by its object attribute", uri)
+	}
+
+	return attributes, nil
+}
+
+// pkcs11CSR builds the csr of a private key in a pkcs11 token, generating the key in the token first if it doesn't
+// exist; the key is generated non extractable, so it only ever exists inside the token
+//	uri			: the pkcs11 uri of the key
+//	commonName	: the common name of the certificate
+//	dnsNames	: the dns alternative names
+//	ips			: the ip alternative names
+//	keyType		: rsa or ec
+//	keyBits		: the size of the key, the default of the type if zero
+func pkcs11CSR(uri, commonName string, dnsNames []string, ips []net.IP, keyType string, keyBits int) ([]byte, error) {
+	attributes, err := parsePKCS11URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	pin := ""
+	if options.pkcs11PinFile != "" {
+		content, err := ioutil.ReadFile(options.pkcs11PinFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the pkcs11 pin, error: %s", err)
+		}
+		pin = strings.TrimSpace(string(content))
+	}
+
+	// step: look for the key in the token, generating it if missing
+	args := []string{"--module", options.pkcs11Module}
+	if x := attributes["token"]; x != "" {
+		args = append(args, "--token-label", x)
+	}
+	if pin != "" {
+		args = append(args, "--login", "--pin", "env:"+keystorePasswordEnv)
+	}
+	found, err := runKeystoreCommand(pin, nil, pkcs11ToolCommand, append(args, "--list-objects", "--type", "privkey", "--label", attributes["object"])...)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(found, []byte("Private Key Object")) {
+		mechanism := "EC:prime256v1"
+		switch {
+		case keyType == "rsa" && keyBits == 0:
+			mechanism = "rsa:2048"
+		case keyType == "rsa":
+			mechanism = fmt.Sprintf("rsa:%d", keyBits)
+		case keyBits == 384:
+			mechanism = "EC:secp384r1"
+		case keyBits == 521:
+			mechanism = "EC:secp521r1"
+		}
+		glog.Infof("generating the %s key: %s in the pkcs11 token", mechanism, attributes["object"])
+		generate := append(args, "--keypairgen", "--key-type", mechanism, "--label", attributes["object"], "--usage-sign")
+		if x := attributes["id"]; x != "" {
+			generate = append(generate, "--id", fmt.Sprintf("%x", x))
+		}
+		if _, err := runKeystoreCommand(pin, nil, pkcs11ToolCommand, generate...); err != nil {
+			return nil, err
+		}
+	}
+
+	// step: have openssl sign the csr with the key via the pkcs11 engine
+	request := []string{"req", "-new", "-engine", "pkcs11", "-keyform", "engine", "-key", uri, "-outform", "PEM",
+		"-subj", "/CN=" + strings.Replace(commonName, "/", `\/`, -1)}
+	var names []string
+	for _, x := range dnsNames {
+		names = append(names, "DNS:"+x)
+	}
+	for _, x := range ips {
+		names = append(names, "IP:"+x.String())
+	}
+	if len(names) > 0 {
+		request = append(request, "-addext", "subjectAltName="+strings.Join(names, ","))
+	}
+	if pin != "" {
+		request = append(request, "-passin", "env:"+keystorePasswordEnv)
+	}
+	csr, err := runKeystoreCommand(pin, nil, opensslCommand, request...)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(csr); block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("openssl did not produce a csr")
+	}
+
+	return csr, nil
+}
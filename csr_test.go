@@ -0,0 +1,152 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignLocalCSR(t *testing.T) {
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/pki/sign/web" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"certificate": "CERT", "issuing_ca": "CA"}})
+	}))
+	defer server.Close()
+	client, err := newAPIClient(&config{vaultMaxRetries: 0}, server.URL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	service := VaultService{client: client}
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("pki:pki/sign/web:common_name=web.example.com,alt_names=a.example.com|b.example.com,ip_sans=10.0.0.1,key=local"))
+	assert.NoError(t, resources.items[0].IsValid())
+	assert.NoError(t, resources.Set("pki:pki/issue/web:common_name=web.example.com,key=local"))
+	assert.Error(t, resources.items[1].IsValid())
+	assert.Error(t, resources.Set("pki:pki/sign/web:key=disk"))
+
+	// step: only the csr is sent, the key generated is added to the secret
+	rn := &watchedResource{resource: resources.items[0]}
+	if !assert.NoError(t, service.get(rn)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "CERT", rn.secret.Data["certificate"])
+	block, _ := pem.Decode([]byte(request["csr"].(string)))
+	if !assert.NotNil(t, block) {
+		t.FailNow()
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "web.example.com", csr.Subject.CommonName)
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, csr.DNSNames)
+	assert.True(t, csr.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")))
+
+	block, _ = pem.Decode([]byte(rn.secret.Data["private_key"].(string)))
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if assert.NoError(t, err) {
+		assert.Equal(t, key.Public().(*ecdsa.PublicKey).X, csr.PublicKey.(*ecdsa.PublicKey).X)
+	}
+
+	// step: an rsa key is generated when asked for
+	csrPEM, keyPEM, err := localCSR("web.example.com", nil, nil, "rsa", 2048)
+	assert.NoError(t, err)
+	assert.Contains(t, string(csrPEM), "CERTIFICATE REQUEST")
+	assert.Contains(t, string(keyPEM), "RSA PRIVATE KEY")
+	_, _, err = localCSR("web.example.com", nil, nil, "dsa", 0)
+	assert.Error(t, err)
+}
+
+func TestPKCS11CSR(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the tools are faked with shell scripts")
+	}
+	dir, err := ioutil.TempDir("", "pkcs11")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	// step: fake the tools, the token holding no key until one is generated
+	csr, _, err := localCSR("web.example.com", nil, nil, "ec", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "csr.pem"), csr, 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pin"), []byte("1234\n"), 0600))
+	tool := filepath.Join(dir, "pkcs11-tool")
+	assert.NoError(t, ioutil.WriteFile(tool, []byte(`#!/bin/sh
+echo "$@ pin=$VAULT_SIDEKICK_KEYSTORE_PASSWORD" >> `+dir+`/calls
+case "$*" in
+*--keypairgen*) touch `+dir+`/generated ;;
+*--list-objects*) [ -f `+dir+`/generated ] && echo "Private Key Object; EC" ;;
+esac
+exit 0
+`), 0755))
+	openssl := filepath.Join(dir, "openssl")
+	assert.NoError(t, ioutil.WriteFile(openssl, []byte(`#!/bin/sh
+echo "$@" >> `+dir+`/calls
+cat `+dir+`/csr.pem
+`), 0755))
+	originalTool, originalOpenssl, originalOptions := pkcs11ToolCommand, opensslCommand, options
+	pkcs11ToolCommand, opensslCommand = tool, openssl
+	options.pkcs11Module, options.pkcs11PinFile, options.execTimeout = "/usr/lib/softhsm/libsofthsm2.so", filepath.Join(dir, "pin"), time.Duration(10)*time.Second
+	defer func() { pkcs11ToolCommand, opensslCommand, options = originalTool, originalOpenssl, originalOptions }()
+
+	uri := "pkcs11:token=sidekick;object=web"
+	for i := 0; i < 2; i++ {
+		x, err := pkcs11CSR(uri, "web.example.com", []string{"a.example.com"}, nil, "ec", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, csr, x)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	calls := strings.Split(strings.TrimSpace(string(content)), "\n")
+	// step: the key is generated once, the pin handed over in the environment
+	if assert.Len(t, calls, 5) {
+		assert.Contains(t, calls[0], "--token-label sidekick --login --pin env:VAULT_SIDEKICK_KEYSTORE_PASSWORD --list-objects")
+		assert.Contains(t, calls[0], "pin=1234")
+		assert.Contains(t, calls[1], "--keypairgen --key-type EC:prime256v1 --label web")
+		assert.Contains(t, calls[2], "-key "+uri)
+		assert.Contains(t, calls[2], "-addext subjectAltName=DNS:a.example.com")
+		assert.NotContains(t, calls[3], "--keypairgen")
+	}
+
+	_, err = parsePKCS11URI("pkcs11:token=sidekick")
+	assert.Error(t, err)
+	attributes, err := parsePKCS11URI("pkcs11:token=side%20kick;object=web;id=%01?pin-source=file:/pin")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"token": "side kick", "object": "web", "id": "\x01"}, attributes)
+}
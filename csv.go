@@ -0,0 +1,81 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"unicode/utf8"
+)
+
+// encodeCSV encodes the secret as rows of key and value, sorted by the key; the keys of nested maps are
+// joined by a dot and fields holding the delimiter, a quote or a newline are quoted
+//
+//	data		: the secret
+//	delimiter	: the delimiter of the fields, a comma if zero
+func encodeCSV(data map[string]interface{}, delimiter rune) ([]byte, error) {
+	rows := make(map[string]string, 0)
+	flattenCSV(rows, "", data)
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if delimiter != 0 {
+		w.Comma = delimiter
+	}
+	for _, key := range sortedKeys(rows) {
+		if err := w.Write([]string{key, rows[key]}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// flattenCSV adds the values of the secret to the rows, nested maps prefixing their keys
+func flattenCSV(rows map[string]string, prefix string, data map[string]interface{}) {
+	for key, value := range data {
+		name := key
+		if prefix != "" {
+			name = prefix + "." + key
+		}
+		if nested, found := value.(map[string]interface{}); found {
+			flattenCSV(rows, name, nested)
+			continue
+		}
+		rows[name] = flatValue(value)
+	}
+}
+
+// parseCSVDelimiter parses the delimiter option, a single character or tab
+//
+//	value		: the value of the option
+func parseCSVDelimiter(value string) (rune, error) {
+	if value == "tab" {
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(value)
+	if size != len(value) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("the delimiter option: %s is invalid, should be a single character or tab", value)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeCSV(t *testing.T) {
+	data := map[string]interface{}{
+		"username": "app",
+		"port":     json.Number("5432"),
+		"password": `p,a"ss`,
+		"note":     "a;b\nc",
+		"db":       map[string]interface{}{"host": "db.internal", "tls": map[string]interface{}{"ca": "ca.pem"}},
+	}
+	cases := []struct {
+		Delimiter rune
+		Expected  string
+	}{
+		{
+			Expected: "db.host,db.internal\ndb.tls.ca,ca.pem\nnote,\"a;b\nc\"\npassword,\"p,a\"\"ss\"\nport,5432\nusername,app\n",
+		},
+		{
+			Delimiter: ';',
+			Expected:  "db.host;db.internal\ndb.tls.ca;ca.pem\nnote;\"a;b\nc\"\npassword;\"p,a\"\"ss\"\nport;5432\nusername;app\n",
+		},
+		{
+			Delimiter: '\t',
+			Expected:  "db.host\tdb.internal\ndb.tls.ca\tca.pem\nnote\t\"a;b\nc\"\npassword\t\"p,a\"\"ss\"\nport\t5432\nusername\tapp\n",
+		},
+	}
+	for i, c := range cases {
+		content, err := encodeCSV(data, c.Delimiter)
+		if !assert.NoError(t, err, "case %d, should not have failed", i) {
+			continue
+		}
+		assert.Equal(t, c.Expected, string(content), "case %d, unexpected content", i)
+	}
+}
+
+func TestParseCSVDelimiter(t *testing.T) {
+	for value, expected := range map[string]rune{";": ';', "tab": '\t', "|": '|', "¦": '¦'} {
+		r, err := parseCSVDelimiter(value)
+		if assert.NoError(t, err, "delimiter: %q should not have failed", value) {
+			assert.Equal(t, expected, r)
+		}
+	}
+	for _, value := range []string{"", ";;", `"`, "\n", "space"} {
+		_, err := parseCSVDelimiter(value)
+		assert.Error(t, err, "delimiter: %q should have failed", value)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// databaseCredentials extracts the username and password of a database secret
+func databaseCredentials(data map[string]interface{}) (string, string, error) {
+	username, found := data["username"]
+	if !found {
+		return "", "", fmt.Errorf("the secret has no username, is it a database credential?")
+	}
+	password, found := data["password"]
+	if !found {
+		return "", "", fmt.Errorf("the secret has no password, is it a database credential?")
+	}
+
+	return fmt.Sprintf("%v", username), fmt.Sprintf("%v", password), nil
+}
+
+// escapePgpass escapes a field of a pgpass file, where a colon or backslash must be escaped
+func escapePgpass(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `:`, `\:`).Replace(value)
+}
+
+// writePgpassFile writes the credentials in the format of a postgres password file (PGPASSFILE), the host,
+// port and database default to a wildcard
+//	filename	: the filename to write to
+//	data		: the secret data
+//	rn			: the resource
+func writePgpassFile(filename string, data map[string]interface{}, rn *VaultResource) error {
+	username, password, err := databaseCredentials(data)
+	if err != nil {
+		return err
+	}
+	fields := []string{"*", "*", "*", escapePgpass(username), escapePgpass(password)}
+	for i, x := range []string{rn.dbHost, rn.dbPort, rn.dbName} {
+		if x != "" {
+			fields[i] = escapePgpass(x)
+		}
+	}
+
+	return writeResourceContent(rn, filename, []byte(strings.Join(fields, ":")+"\n"))
+}
+
+// quoteMyCnf quotes a value of a mysql option file
+func quoteMyCnf(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+// writeMyCnfFile writes the credentials in the format of a mysql option file, for the --defaults-extra-file option
+//	filename	: the filename to write to
+//	data		: the secret data
+//	rn			: the resource
+func writeMyCnfFile(filename string, data map[string]interface{}, rn *VaultResource) error {
+	username, password, err := databaseCredentials(data)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("[client]\n")
+	buf.WriteString(fmt.Sprintf("user=%s\n", quoteMyCnf(username)))
+	buf.WriteString(fmt.Sprintf("password=%s\n", quoteMyCnf(password)))
+	if rn.dbHost != "" {
+		buf.WriteString(fmt.Sprintf("host=%s\n", quoteMyCnf(rn.dbHost)))
+	}
+	if rn.dbPort != "" {
+		buf.WriteString(fmt.Sprintf("port=%s\n", rn.dbPort))
+	}
+	if rn.dbName != "" {
+		buf.WriteString(fmt.Sprintf("database=%s\n", quoteMyCnf(rn.dbName)))
+	}
+
+	return writeResourceContent(rn, filename, buf.Bytes())
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePgpassFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "database")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "pgpass")
+	data := map[string]interface{}{"username": "v-app-x1", "password": `pa:ss\word`}
+	rn := defaultVaultResource()
+	rn.format = "pgpass"
+
+	assert.NoError(t, writePgpassFile(filename, data, rn))
+	content, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "*:*:*:v-app-x1:pa\\:ss\\\\word\n", string(content))
+
+	rn.dbHost = "postgres"
+	rn.dbPort = "5432"
+	rn.dbName = "app"
+	assert.NoError(t, writePgpassFile(filename, data, rn))
+	content, err = ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "postgres:5432:app:v-app-x1:pa\\:ss\\\\word\n", string(content))
+
+	assert.Error(t, writePgpassFile(filename, map[string]interface{}{"password": "x"}, rn))
+}
+
+func TestWriteMyCnfFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "database")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "my.cnf")
+	data := map[string]interface{}{"username": "v-app-x1", "password": `pa"ss`}
+	rn := defaultVaultResource()
+	rn.format = "mycnf"
+
+	assert.NoError(t, writeMyCnfFile(filename, data, rn))
+	content, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "[client]\nuser=\"v-app-x1\"\npassword=\"pa\\\"ss\"\n", string(content))
+
+	rn.dbHost = "mysql"
+	rn.dbPort = "3306"
+	assert.NoError(t, writeMyCnfFile(filename, data, rn))
+	content, err = ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "[client]\nuser=\"v-app-x1\"\npassword=\"pa\\\"ss\"\nhost=\"mysql\"\nport=3306\n", string(content))
+}
+
+func TestPgpassDefaultMode(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("postgres:database/creds/app:fmt=pgpass,db-port=5432"))
+	assert.NoError(t, resources.Set("postgres:database/creds/app:fmt=pgpass,mode=0640"))
+	assert.Error(t, resources.Set("mysql:database/creds/app:fmt=mycnf,db-port=abc"))
+	if assert.Len(t, resources.items, 2) {
+		assert.Equal(t, os.FileMode(0600), resources.items[0].fileMode)
+		assert.Equal(t, "5432", resources.items[0].dbPort)
+		assert.Equal(t, os.FileMode(0640), resources.items[1].fileMode)
+	}
+}
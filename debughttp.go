@@ -0,0 +1,231 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// debugFieldsLimit is the largest body whose field names are captured
+	debugFieldsLimit = 1 << 20
+	// debugFieldsDepth is the depth of the nested fields captured
+	debugFieldsDepth = 3
+	// redactedValue replaces the value of a sensitive header
+	redactedValue = "[redacted]"
+)
+
+// httpTraces is the capture of the requests made to vault, nil if disabled
+var httpTraces *traceBuffer
+
+// httpTrace is the redacted trace of a request to vault; the values of the payloads are never captured, only the
+// names of their fields
+type httpTrace struct {
+	// the time the request was made
+	Time time.Time `json:"time"`
+	// the method of the request
+	Method string `json:"method"`
+	// the vault the request was made to
+	Host string `json:"host"`
+	// the path of the request
+	Path string `json:"path"`
+	// the names of the query parameters
+	Query []string `json:"query,omitempty"`
+	// the headers of the request, sensitive values redacted
+	RequestHeaders map[string]string `json:"request_headers,omitempty"`
+	// the names of the fields of the request body
+	RequestFields []string `json:"request_fields,omitempty"`
+	// the status code of the response
+	Status int `json:"status,omitempty"`
+	// the headers of the response, sensitive values redacted
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	// the names of the fields of the response body
+	ResponseFields []string `json:"response_fields,omitempty"`
+	// the time taken to receive the headers of the response, in milliseconds
+	Duration float64 `json:"duration_ms"`
+	// the error of the request, if it failed before a response
+	Error string `json:"error,omitempty"`
+}
+
+// traceBuffer is a ring buffer of the most recent traces
+type traceBuffer struct {
+	sync.Mutex
+	// the traces, oldest overwritten first
+	items []httpTrace
+	// the position of the next trace
+	next int
+	// whether the buffer has wrapped
+	full bool
+}
+
+// newTraceBuffer creates a buffer holding the most recent traces
+//	size		: the number of traces kept
+func newTraceBuffer(size int) *traceBuffer {
+	return &traceBuffer{items: make([]httpTrace, size)}
+}
+
+// add records a trace, overwriting the oldest once the buffer is full
+func (b *traceBuffer) add(trace httpTrace) {
+	b.Lock()
+	defer b.Unlock()
+	b.items[b.next] = trace
+	b.next = (b.next + 1) % len(b.items)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// list returns the traces, oldest first
+func (b *traceBuffer) list() []httpTrace {
+	b.Lock()
+	defer b.Unlock()
+	list := make([]httpTrace, 0, len(b.items))
+	if b.full {
+		list = append(list, b.items[b.next:]...)
+	}
+
+	return append(list, b.items[:b.next]...)
+}
+
+// debugTransport records a redacted trace of each request made to vault
+type debugTransport struct {
+	// the transport making the request
+	transport http.RoundTripper
+	// the buffer the traces are added to
+	traces *traceBuffer
+}
+
+// RoundTrip performs the request, recording the trace once the headers of the response are received
+func (d *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := httpTrace{
+		Time:           time.Now(),
+		Method:         req.Method,
+		Host:           req.URL.Host,
+		Path:           req.URL.Path,
+		RequestHeaders: redactHeaders(req.Header),
+	}
+	for k := range req.URL.Query() {
+		trace.Query = append(trace.Query, k)
+	}
+	sort.Strings(trace.Query)
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			trace.RequestFields = fieldNames(io.LimitReader(body, debugFieldsLimit))
+			body.Close()
+		}
+	}
+
+	resp, err := d.transport.RoundTrip(req)
+	trace.Duration = float64(time.Since(trace.Time).Microseconds()) / 1000
+	if err != nil {
+		trace.Error = err.Error()
+		d.traces.add(trace)
+		return nil, err
+	}
+	trace.Status = resp.StatusCode
+	trace.ResponseHeaders = redactHeaders(resp.Header)
+
+	// step: read the start of the body for its fields, handing the caller the whole of it still
+	if resp.Body != nil && resp.ContentLength <= debugFieldsLimit {
+		head, _ := ioutil.ReadAll(io.LimitReader(resp.Body, debugFieldsLimit+1))
+		if len(head) <= debugFieldsLimit {
+			trace.ResponseFields = fieldNames(bytes.NewReader(head))
+		}
+		resp.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(head), resp.Body), body: resp.Body}
+	}
+	d.traces.add(trace)
+
+	return resp, nil
+}
+
+// replayBody is a body partly read already, closing the original body
+type replayBody struct {
+	io.Reader
+	// the original body
+	body io.ReadCloser
+}
+
+// Close closes the original body
+func (r *replayBody) Close() error {
+	return r.body.Close()
+}
+
+// redactHeaders returns the headers with the values of any which could carry a credential redacted
+//	headers		: the headers of the request or response
+func redactHeaders(headers http.Header) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	redacted := make(map[string]string, len(headers))
+	for name, values := range headers {
+		lower := strings.ToLower(name)
+		value := strings.Join(values, ", ")
+		for _, x := range []string{"token", "auth", "cookie", "secret", "key", "password", "signature"} {
+			if strings.Contains(lower, x) {
+				value = redactedValue
+				break
+			}
+		}
+		redacted[name] = value
+	}
+
+	return redacted
+}
+
+// fieldNames returns the names of the fields of a json body, nested fields joined by a dot; nil if the body isn't
+// a json object
+//	body		: the body
+func fieldNames(body io.Reader) []string {
+	var document map[string]interface{}
+	if err := json.NewDecoder(body).Decode(&document); err != nil {
+		return nil
+	}
+	var names []string
+	var walk func(prefix string, value map[string]interface{}, depth int)
+	walk = func(prefix string, value map[string]interface{}, depth int) {
+		for k, v := range value {
+			names = append(names, prefix+k)
+			if nested, found := v.(map[string]interface{}); found && depth < debugFieldsDepth {
+				walk(prefix+k+".", nested, depth+1)
+			}
+		}
+	}
+	walk("", document, 1)
+	sort.Strings(names)
+
+	return names
+}
+
+// debugHTTPHandler dumps the traces captured as json
+func debugHTTPHandler(w http.ResponseWriter, req *http.Request) {
+	if httpTraces == nil {
+		http.Error(w, "the capture of the requests to vault is disabled, see -debug-http", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(httpTraces.list())
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceBuffer(t *testing.T) {
+	buffer := newTraceBuffer(3)
+	assert.Empty(t, buffer.list())
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		buffer.add(httpTrace{Path: path})
+	}
+	var paths []string
+	for _, x := range buffer.list() {
+		paths = append(paths, x.Path)
+	}
+	assert.Equal(t, []string{"/b", "/c", "/d"}, paths)
+}
+
+func TestRedactHeaders(t *testing.T) {
+	headers := redactHeaders(http.Header{
+		"X-Vault-Token":    {"s.abc"},
+		"Authorization":    {"Bearer abc"},
+		"X-Request-Id":     {"5d1c"},
+		"Content-Type":     {"application/json"},
+		"X-Vault-Wrap-Ttl": {"5m"},
+	})
+	assert.Equal(t, redactedValue, headers["X-Vault-Token"])
+	assert.Equal(t, redactedValue, headers["Authorization"])
+	assert.Equal(t, "5d1c", headers["X-Request-Id"])
+	assert.Equal(t, "application/json", headers["Content-Type"])
+	assert.Equal(t, "5m", headers["X-Vault-Wrap-Ttl"])
+	assert.Nil(t, redactHeaders(nil))
+}
+
+func TestFieldNames(t *testing.T) {
+	names := fieldNames(strings.NewReader(`{"data":{"password":"s3cr3t","nested":{"a":{"b":{"c":1}}}},"lease_id":""}`))
+	assert.Equal(t, []string{"data", "data.nested", "data.nested.a", "data.password", "lease_id"}, names)
+	assert.Nil(t, fieldNames(strings.NewReader("not json")))
+	assert.Nil(t, fieldNames(strings.NewReader("[1,2]")))
+}
+
+func TestDebugHTTPOptions(t *testing.T) {
+	assert.Error(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", debugHTTP: true, debugHTTPSize: 10}))
+	assert.Error(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", debugHTTP: true, listen: "127.0.0.1:8080"}))
+	assert.NoError(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", debugHTTP: true, debugHTTPSize: 10, listen: "127.0.0.1:8080"}))
+}
+
+func TestDebugTransport(t *testing.T) {
+	original := httpTraces
+	httpTraces = newTraceBuffer(10)
+	defer func() { httpTraces = original }()
+	service, _ := newMockService(t)
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/app:fmt=json"))
+	x := &watchedResource{resource: resources.items[0]}
+	if !assert.NoError(t, service.get(x)) {
+		t.FailNow()
+	}
+	// step: the body read for the trace is still handed to the client
+	assert.Equal(t, "s3cr3t", x.secret.Data["password"])
+
+	var trace *httpTrace
+	for _, item := range httpTraces.list() {
+		if item.Path == "/v1/secret/app" {
+			found := item
+			trace = &found
+		}
+	}
+	if !assert.NotNil(t, trace) {
+		t.FailNow()
+	}
+	assert.Equal(t, http.MethodGet, trace.Method)
+	assert.Equal(t, http.StatusOK, trace.Status)
+	assert.Equal(t, redactedValue, trace.RequestHeaders["X-Vault-Token"])
+	assert.Contains(t, trace.ResponseFields, "data.password")
+
+	// step: no value of the secret or token makes it into the dump
+	recorder := httptest.NewRecorder()
+	debugHTTPHandler(recorder, httptest.NewRequest(http.MethodGet, "/v1/debug/http", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "s3cr3t")
+	assert.NotContains(t, recorder.Body.String(), mockToken)
+	var traces []httpTrace
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &traces))
+	assert.NotEmpty(t, traces)
+
+	httpTraces = nil
+	recorder = httptest.NewRecorder()
+	debugHTTPHandler(recorder, httptest.NewRequest(http.MethodGet, "/v1/debug/http", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
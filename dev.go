@@ -0,0 +1,186 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// defaultOutputDir is the output directory unless given
+	defaultOutputDir = "/etc/secrets"
+	// devOutputDir is the output directory in dev mode, relative to the working directory
+	devOutputDir = "secrets"
+)
+
+// devLogs colorizes the logs written to a terminal in dev mode, nil otherwise
+var devLogs *colorLogs
+
+// openBrowser opens the url in the default browser of the platform
+var openBrowser = func(location string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", location)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", location)
+	default:
+		cmd = exec.Command("xdg-open", location)
+	}
+
+	return cmd.Start()
+}
+
+// applyDevMode adjusts the options for running the resources of a pod on a laptop: the developer logs in via
+// the browser, the files are written under ./secrets, including those given an absolute path, and the
+// kubernetes only features are turned off
+//	cfg			: the options
+func applyDevMode(cfg *config) error {
+	// step: log to the terminal, in color when it is one
+	flag.Set("logtostderr", "true")
+	if isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == "" {
+		devLogs = colorizeLogs()
+	}
+	glog.Infof("running in dev mode on %s/%s", runtime.GOOS, runtime.GOARCH)
+
+	// step: write to ./secrets unless told otherwise
+	if cfg.outputDir == defaultOutputDir {
+		cfg.outputDir = devOutputDir
+	}
+	directory, err := filepath.Abs(cfg.outputDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return fmt.Errorf("unable to create the output directory: %s, error: %s", directory, err)
+	}
+	cfg.outputDir = directory
+
+	// step: log in via the browser unless a token, including one stored by the vault cli, or another method is given
+	if cfg.vaultAuthFile == "" && cfg.vaultAuthOptions != nil && cfg.vaultAuthOptions.Method == "token" && os.Getenv("VAULT_TOKEN") == "" {
+		if token, _ := cliToken(); token == "" {
+			cfg.vaultAuthOptions.Method = "oidc"
+		}
+	}
+
+	// step: the files are the developer's to edit and remove, a read only file couldn't be rewritten either
+	if cfg.resources != nil {
+		for _, rn := range cfg.resources.items {
+			rn.fileMode |= 0600
+		}
+	}
+
+	// step: locking memory isn't supported on darwin and needs a capability elsewhere
+	cfg.disableMlock = true
+	if cfg.pkiLease != "" {
+		glog.Warningf("ignoring the pki lease: %s in dev mode", cfg.pkiLease)
+		cfg.pkiLease = ""
+	}
+
+	return nil
+}
+
+// devFilename places an absolute filename under the output directory in dev mode, so a resource
+// written to /etc/tls in the pod is written to ./secrets/etc/tls
+//	filename	: the absolute filename
+func devFilename(filename string) string {
+	if strings.HasPrefix(filename, options.outputDir+string(filepath.Separator)) {
+		return filename
+	}
+
+	return filepath.Join(options.outputDir, filename)
+}
+
+// colorLogs copies the logs written to stderr to the terminal, colored by their severity
+type colorLogs struct {
+	// the write end of the pipe standing in for stderr
+	writer *os.File
+	// closed once the logs have been copied
+	done chan struct{}
+}
+
+// colorizeLogs swaps stderr for a pipe, copying the lines written to it to the terminal in color
+func colorizeLogs() *colorLogs {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil
+	}
+	logs := &colorLogs{writer: writer, done: make(chan struct{})}
+	terminal := os.Stderr
+	os.Stderr = writer
+	go func() {
+		defer close(logs.done)
+		lines := bufio.NewReader(reader)
+		for {
+			line, err := lines.ReadString('\n')
+			if line != "" {
+				io.WriteString(terminal, colorLine(line))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return logs
+}
+
+// close flushes the logs to the terminal before the process exits
+func (c *colorLogs) close() {
+	glog.Flush()
+	c.writer.Close()
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+	}
+}
+
+// colorLine colors a glog line by its severity, the leading I, W, E or F of the header
+func colorLine(line string) string {
+	if line == "" {
+		return line
+	}
+	color := ""
+	switch line[0] {
+	case 'W':
+		color = "\x1b[33m"
+	case 'E', 'F':
+		color = "\x1b[31m"
+	case 'I':
+		// step: dim the header of an info line, leaving the message
+		if i := strings.Index(line, "] "); i > 0 {
+			return "\x1b[2m" + line[:i+1] + "\x1b[0m" + line[i+1:]
+		}
+		return line
+	default:
+		return line
+	}
+	text := strings.TrimSuffix(line, "\n")
+
+	return color + text + "\x1b[0m" + line[len(text):]
+}
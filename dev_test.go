@@ -0,0 +1,79 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyDevMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dev")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(dir))
+	defer os.Chdir(cwd)
+	token := os.Getenv("VAULT_TOKEN")
+	os.Unsetenv("VAULT_TOKEN")
+	defer os.Setenv("VAULT_TOKEN", token)
+
+	resources := new(VaultResources)
+	assert.NoError(t, resources.Set("pki:pki/issue/web:common_name=web.example.com,file=/etc/tls/web,mode=0400"))
+	cfg := &config{
+		outputDir:        defaultOutputDir,
+		vaultAuthOptions: &vaultAuthOptions{Method: "token"},
+		resources:        resources,
+		pkiLease:         "pki",
+	}
+	assert.NoError(t, applyDevMode(cfg))
+	expected, _ := filepath.EvalSymlinks(filepath.Join(dir, devOutputDir))
+	actual, _ := filepath.EvalSymlinks(cfg.outputDir)
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, "oidc", cfg.vaultAuthOptions.Method)
+	assert.Equal(t, os.FileMode(0600), resources.items[0].fileMode)
+	assert.True(t, cfg.disableMlock)
+	assert.Empty(t, cfg.pkiLease)
+
+	// step: an explicit method and output directory are kept
+	cfg = &config{outputDir: filepath.Join(dir, "out"), vaultAuthOptions: &vaultAuthOptions{Method: "approle"}}
+	assert.NoError(t, applyDevMode(cfg))
+	assert.Equal(t, "approle", cfg.vaultAuthOptions.Method)
+	assert.Equal(t, filepath.Join(dir, "out"), cfg.outputDir)
+}
+
+func TestDevFilename(t *testing.T) {
+	original := options.outputDir
+	options.outputDir = "/home/dev/app/secrets"
+	defer func() { options.outputDir = original }()
+
+	assert.Equal(t, "/home/dev/app/secrets/etc/tls/web.crt", devFilename("/etc/tls/web.crt"))
+	assert.Equal(t, "/home/dev/app/secrets/db.yaml", devFilename("/home/dev/app/secrets/db.yaml"))
+}
+
+func TestColorLine(t *testing.T) {
+	assert.Equal(t, "\x1b[33mW1016 10:00:00.000000 1 vault.go:1] slow\x1b[0m\n", colorLine("W1016 10:00:00.000000 1 vault.go:1] slow\n"))
+	assert.Equal(t, "\x1b[31mE1016 10:00:00.000000 1 vault.go:1] failed\x1b[0m", colorLine("E1016 10:00:00.000000 1 vault.go:1] failed"))
+	assert.Equal(t, "\x1b[2mI1016 10:00:00.000000 1 main.go:1]\x1b[0m starting\n", colorLine("I1016 10:00:00.000000 1 main.go:1] starting\n"))
+	assert.Equal(t, "plain\n", colorLine("plain\n"))
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// drain holds the sidekick up after a termination signal, nil if disabled
+var drain *shutdownDrain
+
+// shutdownDrain keeps the sidekick renewing the leases and serving the secrets after a termination signal, until the
+// application acknowledges its own shutdown or the drain period elapses, so the requests still in flight in the
+// application aren't left holding revoked credentials
+type shutdownDrain struct {
+	sync.Mutex
+	// the longest time the shutdown is held for
+	period time.Duration
+	// the time the termination signal was received, zero until then
+	started time.Time
+	// whether the application has acknowledged the shutdown
+	acknowledged bool
+	// closed once the drain is complete
+	done chan struct{}
+	// closes done once
+	once sync.Once
+}
+
+// shutdownDrainStatus is the state of the drain served by the admin api
+type shutdownDrainStatus struct {
+	// whether a termination signal has been received
+	Draining bool `json:"draining"`
+	// whether the application has acknowledged the shutdown
+	Acknowledged bool `json:"acknowledged"`
+	// the time left before the shutdown regardless
+	Remaining string `json:"remaining,omitempty"`
+}
+
+// newShutdownDrain creates a drain of the period
+//	period		: the longest time the shutdown is held for
+func newShutdownDrain(period time.Duration) *shutdownDrain {
+	return &shutdownDrain{period: period, done: make(chan struct{})}
+}
+
+// start begins the drain on a termination signal, returning a channel closed once it is complete; the drain is
+// complete straight away when the application acknowledged the shutdown ahead of the signal
+func (d *shutdownDrain) start() <-chan struct{} {
+	d.Lock()
+	defer d.Unlock()
+	if !d.started.IsZero() {
+		return d.done
+	}
+	d.started = time.Now()
+	if d.acknowledged {
+		d.finish()
+		return d.done
+	}
+	go func() {
+		select {
+		case <-time.After(d.period):
+			glog.Warningf("the application hasn't acknowledged the shutdown within the drain period of %s", d.period)
+			d.finish()
+		case <-d.done:
+		}
+	}()
+
+	return d.done
+}
+
+// acknowledge records the application has shut down, completing the drain if it has started
+func (d *shutdownDrain) acknowledge() {
+	d.Lock()
+	defer d.Unlock()
+	d.acknowledged = true
+	if !d.started.IsZero() {
+		glog.Infof("the application acknowledged the shutdown after %s", time.Since(d.started).Truncate(time.Millisecond))
+		d.finish()
+	}
+}
+
+// finish completes the drain
+func (d *shutdownDrain) finish() {
+	d.once.Do(func() { close(d.done) })
+}
+
+// status returns the state of the drain
+func (d *shutdownDrain) status() shutdownDrainStatus {
+	d.Lock()
+	defer d.Unlock()
+	status := shutdownDrainStatus{Draining: !d.started.IsZero(), Acknowledged: d.acknowledged}
+	if status.Draining && !status.Acknowledged {
+		if remaining := d.period - time.Since(d.started); remaining > 0 {
+			status.Remaining = formatDuration(remaining.Truncate(time.Second))
+		}
+	}
+
+	return status
+}
+
+// shutdownHandler serves the state of the drain, and takes the acknowledgement of the application it has shut down
+// i.e. POST /v1/shutdown
+func shutdownHandler(w http.ResponseWriter, req *http.Request) {
+	if drain == nil {
+		http.Error(w, "the shutdown drain is disabled, see -drain-period", http.StatusNotFound)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		drain.acknowledge()
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(drain.status())
+}
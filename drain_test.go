@@ -0,0 +1,102 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// drained checks if the drain is complete
+func drained(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Duration(100) * time.Millisecond):
+		return false
+	}
+}
+
+func TestShutdownDrainPeriod(t *testing.T) {
+	d := newShutdownDrain(time.Duration(50) * time.Millisecond)
+	assert.False(t, d.status().Draining)
+	done := d.start()
+	assert.True(t, d.status().Draining)
+	assert.True(t, drained(done))
+	// step: a second signal is given the same drain
+	assert.Equal(t, done, d.start())
+}
+
+func TestShutdownDrainAcknowledged(t *testing.T) {
+	d := newShutdownDrain(time.Hour)
+	done := d.start()
+	status := d.status()
+	assert.True(t, status.Draining)
+	assert.NotEmpty(t, status.Remaining)
+	assert.False(t, drained(done))
+	d.acknowledge()
+	assert.True(t, drained(done))
+	assert.Empty(t, d.status().Remaining)
+
+	// step: the application may shut down ahead of the signal reaching the sidekick
+	d = newShutdownDrain(time.Hour)
+	d.acknowledge()
+	assert.True(t, drained(d.start()))
+}
+
+func TestShutdownHandler(t *testing.T) {
+	defer func() { drain = nil }()
+	server := httptest.NewServer(newAdminHandler())
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/v1/shutdown", "", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	drain = newShutdownDrain(time.Hour)
+	done := drain.start()
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/v1/shutdown", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+		resp.Body.Close()
+	}
+	resp, err = http.Post(server.URL+"/v1/shutdown", "", nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var status shutdownDrainStatus
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.True(t, status.Draining)
+	assert.True(t, status.Acknowledged)
+	assert.True(t, drained(done))
+}
+
+func TestValidateOptionsWithDrainPeriod(t *testing.T) {
+	assert.NoError(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", drainPeriod: time.Minute}))
+	assert.Error(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", drainPeriod: -time.Minute}))
+	assert.Error(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", drainPeriod: time.Minute, oneShot: true}))
+}
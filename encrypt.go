@@ -0,0 +1,78 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// encryptCommand returns the command used to encrypt for the recipient, which is one of
+//	an age recipient i.e. age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+//	a file holding age recipients or ssh public keys
+//	a file holding an armored or binary gpg public key
+//	a gpg key id, fingerprint or email address within the keyring
+func encryptCommand(recipient string) (string, []string) {
+	if strings.HasPrefix(recipient, "age1") {
+		return "age", []string{"--encrypt", "--recipient", recipient}
+	}
+	if exists, _ := fileExists(recipient); exists {
+		content, _ := ioutil.ReadFile(recipient)
+		for _, prefix := range []string{"age1", "ssh-", "#"} {
+			if bytes.HasPrefix(bytes.TrimSpace(content), []byte(prefix)) {
+				return "age", []string{"--encrypt", "--recipients-file", recipient}
+			}
+		}
+		return "gpg", []string{"--batch", "--yes", "--quiet", "--trust-model", "always", "--encrypt", "--recipient-file", recipient, "--output", "-"}
+	}
+
+	return "gpg", []string{"--batch", "--yes", "--quiet", "--trust-model", "always", "--encrypt", "--recipient", recipient, "--output", "-"}
+}
+
+// encryptContent encrypts the content for the recipient using the age or gpg binaries, so only
+// the holder of the private key can read the file written
+//	recipient	: the recipient to encrypt for
+//	content		: the plaintext content
+func encryptContent(recipient string, content []byte) ([]byte, error) {
+	name, args := encryptCommand(recipient)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to run %s, error: %s", name, err)
+	}
+	timer := time.AfterFunc(options.execTimeout, func() {
+		cmd.Process.Kill()
+	})
+	defer timer.Stop()
+
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("%s failed: %s, %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("%s produced no output", name)
+	}
+
+	return stdout.Bytes(), nil
+}
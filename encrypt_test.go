@@ -0,0 +1,101 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypt")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	ageFile := filepath.Join(dir, "recipients.txt")
+	gpgFile := filepath.Join(dir, "app.asc")
+	ioutil.WriteFile(ageFile, []byte("# app\nage1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p\n"), 0644)
+	ioutil.WriteFile(gpgFile, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n"), 0644)
+
+	cases := []struct {
+		Recipient string
+		Command   string
+		Argument  string
+	}{
+		{Recipient: "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p", Command: "age", Argument: "--recipient"},
+		{Recipient: ageFile, Command: "age", Argument: "--recipients-file"},
+		{Recipient: gpgFile, Command: "gpg", Argument: "--recipient-file"},
+		{Recipient: "app@example.com", Command: "gpg", Argument: "--recipient"},
+	}
+	for i, c := range cases {
+		name, args := encryptCommand(c.Recipient)
+		assert.Equal(t, c.Command, name, "case %d, unexpected command", i)
+		assert.Contains(t, args, c.Argument, "case %d, missing argument", i)
+		assert.Contains(t, args, c.Recipient, "case %d, missing recipient", i)
+	}
+}
+
+func TestEncryptContentGPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	home, err := ioutil.TempDir("", "gnupg")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(home)
+	os.Setenv("GNUPGHOME", home)
+	defer os.Unsetenv("GNUPGHOME")
+	options.execTimeout = time.Duration(30) * time.Second
+
+	err = exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "app@example.com", "future-default", "default", "never").Run()
+	if err != nil {
+		t.Skipf("unable to generate a gpg key: %s", err)
+	}
+	public, err := exec.Command("gpg", "--batch", "--armor", "--export", "app@example.com").Output()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	publicFile := filepath.Join(home, "app.asc")
+	ioutil.WriteFile(publicFile, public, 0644)
+
+	for _, recipient := range []string{"app@example.com", publicFile} {
+		encrypted, err := encryptContent(recipient, []byte("password: secret"))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.NotContains(t, string(encrypted), "secret")
+
+		cmd := exec.Command("gpg", "--batch", "--quiet", "--decrypt")
+		cmd.Stdin = bytes.NewReader(encrypted)
+		decrypted, err := cmd.Output()
+		if assert.NoError(t, err) {
+			assert.Equal(t, "password: secret", string(decrypted))
+		}
+	}
+
+	_, err = encryptContent("unknown@example.com", []byte("password: secret"))
+	assert.Error(t, err)
+}
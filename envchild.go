@@ -0,0 +1,250 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// envNameRegex matches the characters which are not permitted in the name of an environment variable
+var envNameRegex = regexp.MustCompile("[^A-Z0-9_]")
+
+// envChild runs a command with the secrets injected as environment variables rather than written to files,
+// restarting the command when the secrets change
+type envChild struct {
+	sync.Mutex
+	// the command and arguments
+	command []string
+	// the resources, in order of precedence
+	resources []*VaultResource
+	// the environment variables of each resource
+	values map[*VaultResource]map[string]string
+	// the files of each resource handed over on sealed anonymous files
+	files map[*VaultResource][]memfdContent
+	// the running process, nil if not running
+	cmd *exec.Cmd
+	// closed when the running process exits
+	done chan struct{}
+	// the sha256 of the environment the process was started with
+	sum [sha256.Size]byte
+	// the time allowed for the process to exit when restarted
+	timeout time.Duration
+	// receives the result of the process when it exits on its own accord
+	exited chan error
+}
+
+// newEnvChild creates the child for the command, it is started once all the resources are retrieved
+//	command		: the command and arguments
+//	resources	: the resources, the variables of later resources taking precedence
+//	timeout		: the time allowed for the process to exit when restarted
+func newEnvChild(command []string, resources []*VaultResource, timeout time.Duration) *envChild {
+	return &envChild{
+		command:   command,
+		resources: resources,
+		values:    make(map[*VaultResource]map[string]string, 0),
+		files:     make(map[*VaultResource][]memfdContent, 0),
+		timeout:   timeout,
+		exited:    make(chan error, 1),
+	}
+}
+
+// envName converts a key of a secret into the name of an environment variable i.e. db-host => DB_HOST
+func envName(key string) string {
+	return envNameRegex.ReplaceAllString(strings.ToUpper(key), "_")
+}
+
+// update records the secret of the resource and starts or restarts the process if the environment changed
+//	rn			: the resource
+//	data		: the secret data
+func (c *envChild) update(rn *VaultResource, data map[string]interface{}) error {
+	c.Lock()
+	defer c.Unlock()
+
+	// step: a resource handed over on a sealed anonymous file is formatted rather than becoming variables
+	values := make(map[string]string, len(data))
+	if rn.memfd {
+		files, err := renderMemfd(rn, data)
+		if err != nil {
+			return err
+		}
+		for _, x := range c.files[rn] {
+			zeroBytes(x.content)
+		}
+		c.files[rn] = files
+	} else {
+		for k, v := range data {
+			values[envName(k)] = fmt.Sprintf("%v", v)
+		}
+	}
+	c.values[rn] = values
+
+	// step: wait for all the resources before starting
+	if len(c.values) < len(c.resources) {
+		return nil
+	}
+	env, files := c.environment()
+	hash := sha256.New()
+	hash.Write([]byte(strings.Join(env, "\x00")))
+	for _, x := range files {
+		hash.Write(x.content)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+	if c.cmd != nil {
+		if sum == c.sum {
+			return nil
+		}
+		glog.Infof("the secrets of the command have changed, restarting the command: %s", c.command[0])
+		c.stop()
+	}
+	c.sum = sum
+
+	return c.start(env, files)
+}
+
+// environment returns the environment of the process, the environment of the sidekick followed by the secrets,
+// and the files handed over on sealed anonymous files; the descriptor of each file follows stdin, stdout and
+// stderr and is given in the variable named after the file i.e. db.json => DB_JSON_FD=3
+func (c *envChild) environment() ([]string, []memfdContent) {
+	env := os.Environ()
+	var files []memfdContent
+	for _, rn := range c.resources {
+		for _, x := range c.files[rn] {
+			files = append(files, x)
+			env = append(env, fmt.Sprintf("%s_FD=%d", envName(x.name), len(files)+2))
+		}
+		values := c.values[rn]
+		var keys []string
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			env = append(env, k+"="+values[k])
+		}
+	}
+
+	return env, files
+}
+
+// start starts the process with the environment and the sealed anonymous files, the lock must be held
+func (c *envChild) start(env []string, files []memfdContent) error {
+	cmd := exec.Command(c.command[0], c.command[1:]...)
+	cmd.Env = env
+	// step: the process holds its own descriptors of the files once started, the copies here are closed
+	for _, x := range files {
+		file, err := newMemfd(x.name, x.content)
+		if err != nil {
+			closeFiles(cmd.ExtraFiles)
+			return fmt.Errorf("unable to hand the file: %s to the command, error: %s", x.name, err)
+		}
+		cmd.ExtraFiles = append(cmd.ExtraFiles, file)
+	}
+	defer closeFiles(cmd.ExtraFiles)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to start the command: %s, error: %s", c.command[0], err)
+	}
+	glog.Infof("started the command: %s, pid: %d", c.command[0], cmd.Process.Pid)
+
+	done := make(chan struct{})
+	c.cmd = cmd
+	c.done = done
+	go func() {
+		err := cmd.Wait()
+		close(done)
+		c.Lock()
+		defer c.Unlock()
+		// step: a process which was restarted is expected to exit
+		if c.cmd == cmd {
+			c.cmd = nil
+			c.exited <- err
+		}
+	}()
+
+	return nil
+}
+
+// stop terminates the process, killing it if it hasn't exited within the timeout; the lock must be held
+func (c *envChild) stop() {
+	cmd, done := c.cmd, c.done
+	c.cmd = nil
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		cmd.Process.Kill()
+	}
+	select {
+	case <-done:
+	case <-time.After(c.timeout):
+		glog.Warningf("the command: %s, pid: %d did not exit within %s, killing", c.command[0], cmd.Process.Pid, c.timeout)
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+// signal forwards the signal to the process, returns false if the process is not running
+func (c *envChild) signal(sig os.Signal) bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.cmd == nil {
+		return false
+	}
+	if err := c.cmd.Process.Signal(sig); err != nil {
+		glog.Errorf("unable to forward the signal: %s to the command, error: %s", sig, err)
+		return false
+	}
+
+	return true
+}
+
+// closeFiles closes the files
+func closeFiles(files []*os.File) {
+	for _, x := range files {
+		x.Close()
+	}
+}
+
+// exitCode returns the exit code of a process from the result of the wait
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if e, ok := err.(*exec.ExitError); ok {
+		if status, ok := e.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			if status.ExitStatus() > 0 {
+				return status.ExitStatus()
+			}
+		}
+	}
+
+	return 1
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "DB_HOST", envName("db-host"))
+	assert.Equal(t, "API_KEY", envName("api_key"))
+	assert.Equal(t, "A_B_C", envName("a.b/c"))
+}
+
+// waitForFile waits for the file to contain the text
+func waitForFile(t *testing.T, filename, text string) string {
+	var content []byte
+	for i := 0; i < 250; i++ {
+		content, _ = ioutil.ReadFile(filename)
+		if strings.Contains(string(content), text) {
+			return string(content)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("the file: %s does not contain: %s, content: %s", filename, text, content)
+
+	return ""
+}
+
+func TestEnvChild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "envchild")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "env")
+	db, api := defaultVaultResource(), defaultVaultResource()
+	c := newEnvChild([]string{"sh", "-c", "env > " + filename + ".tmp && mv " + filename + ".tmp " + filename + " && exec sleep 30"}, []*VaultResource{db, api}, 5*time.Second)
+
+	// step: the command waits for all the resources
+	assert.NoError(t, c.update(db, map[string]interface{}{"password": "one", "host": "db"}))
+	assert.Nil(t, c.cmd)
+	assert.NoError(t, c.update(api, map[string]interface{}{"api-key": "k3y", "host": "api"}))
+	content := waitForFile(t, filename, "PASSWORD=one")
+	assert.Contains(t, content, "API_KEY=k3y")
+	assert.Contains(t, content, "HOST=api")
+	pid := c.cmd.Process.Pid
+
+	// step: an unchanged secret leaves the command running
+	assert.NoError(t, c.update(db, map[string]interface{}{"password": "one", "host": "db"}))
+	assert.Equal(t, pid, c.cmd.Process.Pid)
+
+	// step: a changed secret restarts the command
+	assert.NoError(t, c.update(db, map[string]interface{}{"password": "two", "host": "db"}))
+	waitForFile(t, filename, "PASSWORD=two")
+	assert.NotEqual(t, pid, c.cmd.Process.Pid)
+	select {
+	case err := <-c.exited:
+		t.Fatalf("a restart should not report the command exited: %v", err)
+	default:
+	}
+
+	// step: the exit of the command is reported
+	assert.True(t, c.signal(os.Kill))
+	select {
+	case err := <-c.exited:
+		assert.Equal(t, 137, exitCode(err))
+	case <-time.After(5 * time.Second):
+		t.Fatal("the exit of the command was not reported")
+	}
+	assert.False(t, c.signal(os.Kill))
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, 0, exitCode(nil))
+	if runtime.GOOS == "windows" {
+		return
+	}
+	c := newEnvChild([]string{"sh", "-c", "exit 3"}, []*VaultResource{defaultVaultResource()}, time.Second)
+	assert.NoError(t, c.update(c.resources[0], map[string]interface{}{}))
+	select {
+	case err := <-c.exited:
+		assert.Equal(t, 3, exitCode(err))
+	case <-time.After(5 * time.Second):
+		t.Fatal("the exit of the command was not reported")
+	}
+}
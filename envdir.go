@@ -0,0 +1,111 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// envdirValue encodes a value as the content of an envdir file; envdir reads the first line only, taking a
+// nul as a newline, so the newlines of a multi-line value are written as nuls
+func envdirValue(value interface{}) []byte {
+	content := []byte(fmt.Sprintf("%v", value))
+
+	return append(bytes.Replace(content, []byte("\n"), []byte{0}, -1), '\n')
+}
+
+// writeEnvdirFiles writes the secret as a daemontools style envdir, a file per key named by the key in upper
+// case, as read by envdir, s6-envdir or chpst -e. The files are written to a new directory alongside and the
+// directory swapped in by renaming a symlink over it, so a supervisor never reads half of an update
+//	filename	: the path of the envdir
+//	data		: the secret data
+//	rn			: the resource
+func writeEnvdirFiles(filename string, data map[string]interface{}, rn *VaultResource) error {
+	keys := getKeys(data)
+	for _, key := range keys {
+		if key == "" || strings.ContainsAny(key, "=/") || strings.HasPrefix(key, ".") {
+			return fmt.Errorf("the key: %q can't be written as a file of the envdir: %s", key, filename)
+		}
+	}
+	if options.dryRun {
+		for _, key := range keys {
+			if err := writeFile(filepath.Join(filename, strings.ToUpper(key)), envdirValue(data[key]), rn.fileMode); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// step: write the files to a new directory
+	parent, base := filepath.Dir(filename), filepath.Base(filename)
+	directory, err := ioutil.TempDir(parent, "."+base+".")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		content := envdirValue(data[key])
+		err = writeFile(filepath.Join(directory, strings.ToUpper(key)), content, rn.fileMode)
+		zeroBytes(content)
+		if err != nil {
+			os.RemoveAll(directory)
+			return err
+		}
+	}
+	// step: the directory is searchable by whoever can read the files
+	if err := os.Chmod(directory, rn.fileMode.Perm()|(rn.fileMode.Perm()&0444)>>2); err != nil {
+		os.RemoveAll(directory)
+		return err
+	}
+
+	// step: swap the directory in, a directory left by an earlier version is removed first
+	previous, _ := os.Readlink(filename)
+	if stat, err := os.Lstat(filename); err == nil && stat.Mode()&os.ModeSymlink == 0 {
+		glog.Warningf("replacing the directory: %s with a link to the envdir", filename)
+		if err := os.RemoveAll(filename); err != nil {
+			os.RemoveAll(directory)
+			return err
+		}
+	}
+	link := filepath.Join(parent, "."+base+".link")
+	os.Remove(link)
+	if err := os.Symlink(filepath.Base(directory), link); err != nil {
+		os.RemoveAll(directory)
+		return err
+	}
+	if err := os.Rename(link, filename); err != nil {
+		os.Remove(link)
+		os.RemoveAll(directory)
+		return err
+	}
+	glog.V(3).Infof("swapped in the envdir: %s, %d keys", filename, len(keys))
+
+	// step: remove the directory replaced, only ever one of ours
+	if previous != "" && strings.HasPrefix(previous, "."+base+".") && !strings.Contains(previous, "/") {
+		if err := os.RemoveAll(filepath.Join(parent, previous)); err != nil {
+			glog.Errorf("unable to remove the previous envdir: %s, error: %s", previous, err)
+		}
+	}
+
+	return nil
+}
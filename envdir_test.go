@@ -0,0 +1,69 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteEnvdirFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the envdir is swapped in via a symlink")
+	}
+	dir, err := ioutil.TempDir("", "envdir")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app")
+	rn := defaultVaultResource()
+	rn.format = "envdir"
+	rn.fileMode = 0640
+
+	// step: an existing directory is replaced by the link
+	assert.NoError(t, os.Mkdir(filename, 0755))
+	assert.NoError(t, writeEnvdirFiles(filename, map[string]interface{}{"username": "app", "cert": "line1\nline2"}, rn))
+	content, err := ioutil.ReadFile(filepath.Join(filename, "USERNAME"))
+	assert.NoError(t, err)
+	assert.Equal(t, "app\n", string(content))
+	content, err = ioutil.ReadFile(filepath.Join(filename, "CERT"))
+	assert.NoError(t, err)
+	assert.Equal(t, "line1\x00line2\n", string(content))
+	stat, err := os.Stat(filepath.Join(filename, "CERT"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), stat.Mode().Perm())
+
+	// step: an update swaps in a new directory, removing the keys no longer there and the previous directory
+	assert.NoError(t, writeEnvdirFiles(filename, map[string]interface{}{"username": "app2"}, rn))
+	content, err = ioutil.ReadFile(filepath.Join(filename, "USERNAME"))
+	assert.NoError(t, err)
+	assert.Equal(t, "app2\n", string(content))
+	_, err = os.Stat(filepath.Join(filename, "CERT"))
+	assert.True(t, os.IsNotExist(err))
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 2)
+
+	// step: a key which can't be a file fails the update
+	assert.Error(t, writeEnvdirFiles(filename, map[string]interface{}{"a/b": "x"}, rn))
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// explodedDataLink is the link to the current version of the files of an exploded resource, as in a secret volume
+const explodedDataLink = "..data"
+
+// writeExplodedFiles writes each key of the secret as a file of the directory, laid out as kubernetes lays out
+// a secret volume: the files are written to a timestamped directory, swapped in by renaming the ..data link,
+// and each key is a link to ..data/KEY, so an update is seen all at once and the keys removed disappear
+//	dir			: the directory of the resource
+//	data		: the secret data
+//	rn			: the resource
+func writeExplodedFiles(dir string, data map[string]interface{}, rn *VaultResource) error {
+	keys := getKeys(data)
+	for _, key := range keys {
+		if key == "" || strings.Contains(key, "/") || strings.HasPrefix(key, "..") {
+			return fmt.Errorf("the key: %q can't be written as a file of the directory: %s", key, dir)
+		}
+	}
+	if options.dryRun {
+		for _, key := range keys {
+			if err := writeFile(filepath.Join(dir, key), []byte(fmt.Sprintf("%v", data[key])), rn.fileMode); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// step: write the files to a new timestamped directory
+	version, err := ioutil.TempDir(dir, time.Now().UTC().Format("..2006_01_02_15_04_05."))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		content := []byte(fmt.Sprintf("%v", data[key]))
+		err = writeFile(filepath.Join(version, key), content, rn.fileMode)
+		zeroBytes(content)
+		if err != nil {
+			os.RemoveAll(version)
+			return err
+		}
+	}
+	if err := os.Chmod(version, 0755); err != nil {
+		os.RemoveAll(version)
+		return err
+	}
+
+	// step: swap the new version in
+	link := filepath.Join(dir, explodedDataLink+"_tmp")
+	os.Remove(link)
+	if err := os.Symlink(filepath.Base(version), link); err != nil {
+		os.RemoveAll(version)
+		return err
+	}
+	if err := os.Rename(link, filepath.Join(dir, explodedDataLink)); err != nil {
+		os.Remove(link)
+		os.RemoveAll(version)
+		return err
+	}
+
+	// step: link the keys added and remove the links of the keys removed
+	current := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		current[key] = true
+		filename := filepath.Join(dir, key)
+		if target, err := os.Readlink(filename); err == nil && target == filepath.Join(explodedDataLink, key) {
+			continue
+		}
+		os.Remove(filename)
+		if err := os.Symlink(filepath.Join(explodedDataLink, key), filename); err != nil {
+			return err
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, x := range files {
+		filename := filepath.Join(dir, x.Name())
+		switch {
+		case current[x.Name()]:
+		case x.IsDir() && strings.HasPrefix(x.Name(), "..") && x.Name() != filepath.Base(version):
+			// step: the version replaced, or one left by an update which failed part way
+			if err := os.RemoveAll(filename); err != nil {
+				glog.Errorf("unable to remove the previous version: %s, error: %s", filename, err)
+			}
+		case x.Mode()&os.ModeSymlink != 0:
+			// step: only ever the links of ours
+			if target, err := os.Readlink(filename); err == nil && target == filepath.Join(explodedDataLink, x.Name()) {
+				glog.Infof("resource: %s no longer has the key: %s, removing the file: %s", rn, x.Name(), filename)
+				if err := os.Remove(filename); err != nil {
+					glog.Errorf("failed to remove the file: %s, error: %s", filename, err)
+				}
+			}
+		}
+	}
+	glog.V(3).Infof("swapped in the version: %s of the directory: %s, %d keys", filepath.Base(version), dir, len(keys))
+
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteExplodedFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the versions are swapped in via a symlink")
+	}
+	dir, err := ioutil.TempDir("", "explode")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/db:explode=true,mode=0640"))
+	assert.Error(t, resources.Set("secret:secret/db:explode=maybe"))
+	rn := resources.items[0]
+	assert.NoError(t, rn.IsValid())
+	filename := filepath.Join(dir, "db")
+
+	// step: each key is a file, a link into the current version
+	assert.NoError(t, writeResourceFile(rn, filename, map[string]interface{}{"username": "app", "password": "secret"}))
+	content, err := ioutil.ReadFile(filepath.Join(filename, "username"))
+	assert.NoError(t, err)
+	assert.Equal(t, "app", string(content))
+	target, err := os.Readlink(filepath.Join(filename, "password"))
+	assert.NoError(t, err)
+	assert.Equal(t, "..data/password", target)
+	stat, err := os.Stat(filepath.Join(filename, "password"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), stat.Mode().Perm())
+
+	// step: a file of our own is left alone, the keys removed and the previous version are cleaned up
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(filename, "README"), []byte("keep"), 0644))
+	assert.NoError(t, writeResourceFile(rn, filename, map[string]interface{}{"username": "app2"}))
+	content, err = ioutil.ReadFile(filepath.Join(filename, "username"))
+	assert.NoError(t, err)
+	assert.Equal(t, "app2", string(content))
+	_, err = os.Lstat(filepath.Join(filename, "password"))
+	assert.True(t, os.IsNotExist(err))
+	files, _ := ioutil.ReadDir(filename)
+	var names []string
+	for _, x := range files {
+		names = append(names, x.Name())
+	}
+	assert.Len(t, names, 4)
+	assert.Contains(t, names, "README")
+	assert.Contains(t, names, "..data")
+
+	// step: a key which can't be a file fails the update
+	assert.Error(t, writeResourceFile(rn, filename, map[string]interface{}{"a/b": "x"}))
+	assert.Error(t, writeResourceFile(rn, filename, map[string]interface{}{"..data": "x"}))
+
+	rn.format = "envdir"
+	assert.Error(t, rn.IsValid())
+}
@@ -0,0 +1,184 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// outFifo is the value of the out option handing the resource to the readers of a named pipe
+const outFifo = "fifo"
+
+// fifoReopenDelay is the pause after the secret is written to a named pipe, for the reader to read to the end and
+// close the pipe before it's opened for the next reader
+var fifoReopenDelay = time.Duration(100) * time.Millisecond
+
+// fifos are the named pipes the resources are served on, keyed by the path
+var fifos = struct {
+	sync.Mutex
+	items map[string]*fifoServer
+}{items: make(map[string]*fifoServer, 0)}
+
+// fifoServer writes the latest content of a resource to each reader opening its named pipe, so the secret is only
+// ever held in memory
+type fifoServer struct {
+	sync.Mutex
+	// the path of the named pipe
+	path string
+	// the file permissions of the named pipe
+	mode os.FileMode
+	// the latest content of the resource
+	content []byte
+}
+
+// notifyFifo writes a line naming the file to the named pipe, creating the pipe if required; the pipe is opened
+// without blocking, so the notification is skipped when the application isn't reading the pipe
+//	fifo		: the path of the named pipe
+//	filename	: the file which was updated
+func notifyFifo(fifo, filename string) error {
+	if exists, _ := fileExists(fifo); !exists {
+		if err := syscall.Mkfifo(fifo, 0600); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("unable to create the named pipe: %s, error: %s", fifo, err)
+		}
+	}
+	file, err := os.OpenFile(fifo, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		// step: there is no reader on the pipe
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.ENXIO {
+			glog.V(3).Infof("the named pipe: %s has no reader, skipping the notification", fifo)
+			return nil
+		}
+		return fmt.Errorf("unable to open the named pipe: %s, error: %s", fifo, err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(filename + "\n"); err != nil {
+		return fmt.Errorf("unable to write to the named pipe: %s, error: %s", fifo, err)
+	}
+
+	return nil
+}
+
+// serveFifo hands the content of a resource to the readers of its named pipe, creating the pipe and starting to
+// serve it on the first update; the content replaces that of the previous update
+//	path		: the path of the named pipe
+//	content		: the content of the resource
+//	mode		: the file permissions of the named pipe
+func serveFifo(path string, content []byte, mode os.FileMode) error {
+	if options.maxFileSize > 0 && int64(len(content)) > int64(options.maxFileSize) {
+		return fmt.Errorf("the file: %s is %d bytes, exceeding the maximum file size of %d bytes", path, len(content), options.maxFileSize)
+	}
+	if err := createFifo(path, mode); err != nil {
+		return err
+	}
+
+	fifos.Lock()
+	server, found := fifos.items[path]
+	if !found {
+		server = &fifoServer{path: path, mode: mode}
+		fifos.items[path] = server
+	}
+	fifos.Unlock()
+
+	server.Lock()
+	zeroBytes(server.content)
+	server.content = append([]byte(nil), content...)
+	server.Unlock()
+	if !found {
+		glog.Infof("serving the resource on the named pipe: %s", path)
+		go server.serve()
+	}
+
+	return nil
+}
+
+// createFifo creates the named pipe if it doesn't exist, refusing to replace a file of another type
+//	path		: the path of the named pipe
+//	mode		: the file permissions of the named pipe
+func createFifo(path string, mode os.FileMode) error {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode()&os.ModeNamedPipe == 0 {
+			return fmt.Errorf("the file: %s exists and is not a named pipe", path)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if err := syscall.Mkfifo(path, uint32(mode.Perm())); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("unable to create the named pipe: %s, error: %s", path, err)
+	}
+
+	// step: the umask may have dropped some of the permissions
+	return os.Chmod(path, mode.Perm())
+}
+
+// serve waits for a reader to open the named pipe, writes the latest content to it and closes the pipe, so the reader
+// sees the end of the secret, then waits for the next
+func (s *fifoServer) serve() {
+	for {
+		// step: the open blocks until a reader opens the other end
+		file, err := os.OpenFile(s.path, os.O_WRONLY, 0)
+		if err != nil {
+			glog.Errorf("unable to open the named pipe: %s, error: %s", s.path, err)
+			time.Sleep(time.Duration(5) * time.Second)
+			if err := createFifo(s.path, s.mode); err != nil {
+				glog.Errorf("unable to create the named pipe again, error: %s", err)
+			}
+			continue
+		}
+		s.Lock()
+		content := append([]byte(nil), s.content...)
+		s.Unlock()
+
+		if _, err := file.Write(content); err != nil {
+			glog.Warningf("unable to write the secret to the reader of the named pipe: %s, error: %s", s.path, err)
+		} else {
+			glog.V(3).Infof("handed the secret to a reader of the named pipe: %s", s.path)
+		}
+		zeroBytes(content)
+		file.Close()
+		time.Sleep(fifoReopenDelay)
+	}
+}
+
+// isValidFifo checks the options of a resource served on a named pipe, those writing several files, files alongside
+// or reading the file back can't be served
+func (r VaultResource) isValidFifo() error {
+	if !r.fifo {
+		return nil
+	}
+	switch r.format {
+	case "envdir", "patch", "spiffe", "p12", "jks":
+		return fmt.Errorf("the %s format can't be served on a named pipe", r.format)
+	}
+	if r.explode || r.isWildcard() || r.ocsp || r.validate != "" || r.merge != "" || r.backups > 0 {
+		return fmt.Errorf("a named pipe is not supported with the explode, ocsp, validate, merge or backups options, or wildcards")
+	}
+
+	return nil
+}
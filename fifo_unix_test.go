@@ -0,0 +1,108 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyFifo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fifo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	fifo := filepath.Join(dir, "creds")
+
+	// step: without a reader the notification is skipped, the pipe is created
+	assert.NoError(t, notifyFifo(fifo, "/etc/app/pgpass"))
+	info, err := os.Stat(fifo)
+	if assert.NoError(t, err) {
+		assert.True(t, info.Mode()&os.ModeNamedPipe != 0)
+	}
+
+	// step: a reader receives the filename
+	reader, err := os.OpenFile(fifo, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer reader.Close()
+	assert.NoError(t, notifyFifo(fifo, "/etc/app/pgpass"))
+	line, err := bufio.NewReader(reader).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/app/pgpass\n", line)
+}
+
+func TestServeFifo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fifo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	fifo := filepath.Join(dir, "db.json")
+
+	assert.NoError(t, serveFifo(fifo, []byte(`{"password":"first"}`), 0600))
+	info, err := os.Stat(fifo)
+	if assert.NoError(t, err) {
+		assert.True(t, info.Mode()&os.ModeNamedPipe != 0)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+	// step: each reader is given the whole of the latest secret
+	for i := 0; i < 2; i++ {
+		content, err := ioutil.ReadFile(fifo)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"password":"first"}`, string(content))
+	}
+	assert.NoError(t, serveFifo(fifo, []byte(`{"password":"second"}`), 0600))
+	content, err := ioutil.ReadFile(fifo)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"password":"second"}`, string(content))
+
+	// step: a regular file is never replaced
+	filename := filepath.Join(dir, "plain")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("x"), 0600))
+	assert.Error(t, serveFifo(filename, []byte("secret"), 0600))
+}
+
+func TestFifoResourceOptions(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/app:out=fifo,fmt=json"))
+	rn := resources.items[0]
+	assert.True(t, rn.fifo)
+	assert.NoError(t, rn.IsValid())
+
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("secret:secret/app:out=fifo,fmt=envdir"))
+	assert.Error(t, resources.items[0].IsValid())
+
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("secret:secret/app:out=fifo,explode=true"))
+	assert.Error(t, resources.items[0].IsValid())
+
+	resources = VaultResources{}
+	assert.Error(t, resources.Set("secret:secret/app:out=socket"))
+}
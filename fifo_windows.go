@@ -0,0 +1,47 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// outFifo is the value of the out option handing the resource to the readers of a named pipe
+const outFifo = "fifo"
+
+// notifyFifo is not supported on windows
+func notifyFifo(fifo, filename string) error {
+	return errors.New("named pipes are not supported on windows")
+}
+
+// serveFifo is not supported on windows
+func serveFifo(path string, content []byte, mode os.FileMode) error {
+	return errors.New("named pipes are not supported on windows")
+}
+
+// isValidFifo refuses a resource served on a named pipe, which is not supported on windows
+func (r VaultResource) isValidFifo() error {
+	if r.fifo {
+		return errors.New("named pipes are not supported on windows")
+	}
+
+	return nil
+}
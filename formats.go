@@ -18,54 +18,63 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"regexp"
 	"strings"
 
 	"github.com/golang/glog"
-	"gopkg.in/yaml.v2"
 )
 
-func writeIniFile(filename string, data map[string]interface{}, mode os.FileMode) error {
-	var buf bytes.Buffer
-	for key, val := range data {
-		buf.WriteString(fmt.Sprintf("%s = %v\n", key, val))
+func writeIniFile(filename string, data map[string]interface{}, rn *VaultResource) error {
+	content, err := encodeINI(data, rn.section)
+	if err != nil {
+		return err
 	}
 
-	return writeFile(filename, buf.Bytes(), mode)
+	return writeResourceContent(rn, filename, content)
 }
 
-func writeCSVFile(filename string, data map[string]interface{}, mode os.FileMode) error {
-	var buf bytes.Buffer
-	for key, val := range data {
-		buf.WriteString(fmt.Sprintf("%s,%v\n", key, val))
+func writeCSVFile(filename string, data map[string]interface{}, rn *VaultResource) error {
+	content, err := encodeCSV(data, rn.delimiter)
+	if err != nil {
+		return err
 	}
 
-	return writeFile(filename, buf.Bytes(), mode)
+	return writeResourceContent(rn, filename, content)
 }
 
-func writeYAMLFile(filename string, data map[string]interface{}, mode os.FileMode) error {
-	// marshall the content to yaml
-	content, err := yaml.Marshal(data)
-	if err != nil {
-		return err
+func writeYAMLFile(filename string, data map[string]interface{}, rn *VaultResource) error {
+	if !rn.documents {
+		return writeResourceContent(rn, filename, encodeYAML(data))
+	}
+
+	// step: write a document per secret, in the order of the paths
+	var content bytes.Buffer
+	for _, path := range rn.paths() {
+		secret, _ := data[path].(map[string]interface{})
+		content.WriteString(fmt.Sprintf("---\n# %s\n", path))
+		content.Write(encodeYAML(secret))
 	}
 
-	return writeFile(filename, content, mode)
+	return writeResourceContent(rn, filename, content.Bytes())
 }
 
-func writeEnvFile(filename string, data map[string]interface{}, mode os.FileMode) error {
+func writeEnvFile(filename string, data map[string]interface{}, rn *VaultResource) error {
 	var buf bytes.Buffer
-	for key, val := range data {
-		buf.WriteString(fmt.Sprintf("%s=%v\n", strings.ToUpper(key), val))
+	for _, key := range getKeys(data) {
+		buf.WriteString(fmt.Sprintf("%s=%v\n", strings.ToUpper(key), data[key]))
 	}
 
-	return writeFile(filename, buf.Bytes(), mode)
+	return writeResourceContent(rn, filename, buf.Bytes())
 }
 
-func writeCertificateFile(filename string, data map[string]interface{}, mode os.FileMode) error {
+func writeCertificateFile(filename string, data map[string]interface{}, rn *VaultResource) error {
 	files := map[string]string{
 		"certificate": "crt",
 		"issuing_ca":  "ca",
@@ -74,13 +83,17 @@ func writeCertificateFile(filename string, data map[string]interface{}, mode os.
 	for key, suffix := range files {
 		name := fmt.Sprintf("%s.%s", filename, suffix)
 		content, found := data[key]
+		if !found && key == "private_key" && strings.HasPrefix(rn.keySource, pkcs11Scheme) {
+			// step: the key never leaves the token
+			continue
+		}
 		if !found {
 			glog.Errorf("didn't find the certification option: %s in the resource: %s", key, name)
 			continue
 		}
 
 		// step: write the file
-		if err := writeFile(name, []byte(fmt.Sprintf("%s", content)), mode); err != nil {
+		if err := writeResourceContent(rn, name, []byte(fmt.Sprintf("%s", content))); err != nil {
 			glog.Errorf("failed to write resource: %s, element: %s, filename: %s, error: %s", filename, suffix, name, err)
 			continue
 		}
@@ -90,7 +103,7 @@ func writeCertificateFile(filename string, data map[string]interface{}, mode os.
 
 }
 
-func writeCertificateBundleFile(filename string, data map[string]interface{}, mode os.FileMode) error {
+func writeCertificateBundleFile(filename string, data map[string]interface{}, rn *VaultResource) error {
 	bundleFile := fmt.Sprintf("%s-bundle.pem", filename)
 	keyFile := fmt.Sprintf("%s-key.pem", filename)
 	caFile := fmt.Sprintf("%s-ca.pem", filename)
@@ -101,22 +114,25 @@ func writeCertificateBundleFile(filename string, data map[string]interface{}, mo
 	ca := fmt.Sprintf("%s\n", data["issuing_ca"])
 	certificate := fmt.Sprintf("%s\n", data["certificate"])
 
-	if err := writeFile(bundleFile, []byte(bundle), mode); err != nil {
+	if err := writeResourceContent(rn, bundleFile, []byte(bundle)); err != nil {
 		glog.Errorf("failed to write the bundled certificate file, error: %s", err)
 		return err
 	}
 
-	if err := writeFile(certFile, []byte(certificate), mode); err != nil {
+	if err := writeResourceContent(rn, certFile, []byte(certificate)); err != nil {
 		glog.Errorf("failed to write the certificate file, errro: %s", err)
 		return err
 	}
 
-	if err := writeFile(caFile, []byte(ca), mode); err != nil {
+	if err := writeResourceContent(rn, caFile, []byte(ca)); err != nil {
 		glog.Errorf("failed to write the ca file, errro: %s", err)
 		return err
 	}
 
-	if err := writeFile(keyFile, []byte(key), mode); err != nil {
+	if _, found := data["private_key"]; !found && strings.HasPrefix(rn.keySource, pkcs11Scheme) {
+		return nil
+	}
+	if err := writeResourceContent(rn, keyFile, []byte(key)); err != nil {
 		glog.Errorf("failed to write the key file, errro: %s", err)
 		return err
 	}
@@ -124,13 +140,13 @@ func writeCertificateBundleFile(filename string, data map[string]interface{}, mo
 	return nil
 }
 
-func writeTxtFile(filename string, data map[string]interface{}, mode os.FileMode) error {
+func writeTxtFile(filename string, data map[string]interface{}, rn *VaultResource) error {
 	keys := getKeys(data)
 	if len(keys) > 1 {
 		// step: for plain formats we need to iterate the keys and produce a file per key
-		for suffix, content := range data {
+		for _, suffix := range keys {
 			name := fmt.Sprintf("%s.%s", filename, suffix)
-			if err := writeFile(name, []byte(fmt.Sprintf("%v", content)), mode); err != nil {
+			if err := writeResourceContent(rn, name, []byte(fmt.Sprintf("%v", data[suffix]))); err != nil {
 				glog.Errorf("failed to write resource: %s, elemment: %s, filename: %s, error: %s",
 					filename, suffix, name, err)
 				continue
@@ -143,26 +159,309 @@ func writeTxtFile(filename string, data map[string]interface{}, mode os.FileMode
 	value, _ := data[keys[0]]
 	content := []byte(fmt.Sprintf("%s", value))
 
-	return writeFile(filename, content, mode)
+	return writeResourceContent(rn, filename, content)
 }
 
-func writeJSONFile(filename string, data map[string]interface{}, mode os.FileMode) error {
+func writeJSONFile(filename string, data map[string]interface{}, rn *VaultResource) error {
 	content, err := json.MarshalIndent(data, "", "    ")
 	if err != nil {
 		return err
 	}
 
-	return writeFile(filename, content, mode)
+	return writeResourceContent(rn, filename, content)
+}
+
+// errResourceUnchanged indicates the update left every file of the resource as it was
+var errResourceUnchanged = errors.New("the files of the resource have not changed")
+
+// filesDigest returns a digest of the names and content of the files, the missing ones included, so a write which
+// left every file of a resource as it was can be told apart and its hooks skipped
+//	filenames	: the files of the resource
+func filesDigest(filenames []string) [sha256.Size]byte {
+	hash := sha256.New()
+	for _, filename := range filenames {
+		hash.Write([]byte(filename))
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			hash.Write([]byte{0})
+			continue
+		}
+		hash.Write([]byte{1})
+		hash.Write(content)
+		zeroBytes(content)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+
+	return sum
+}
+
+// writeResourceContent writes the content of a resource, encrypting it for the recipient if required
+//	rn			: the resource being written
+//	filename	: the filename to write to
+//	content		: the content of the file
+func writeResourceContent(rn *VaultResource, filename string, content []byte) error {
+	defer zeroBytes(content)
+	if rn.encryptTo != "" && !options.dryRun {
+		encrypted, err := encryptContent(rn.encryptTo, content)
+		if err != nil {
+			return fmt.Errorf("unable to encrypt the file: %s, error: %s", filename, err)
+		}
+		defer zeroBytes(encrypted)
+		content = encrypted
+	}
+	if rn.toStdout() && !options.dryRun {
+		return writeStdout(content)
+	}
+	if rn.memfd && !options.dryRun {
+		return captureMemfd(rn, filename, content)
+	}
+	if rn.fifo && !options.dryRun {
+		if err := serveFifo(filename, content, rn.fileMode); err != nil {
+			return err
+		}
+		return labelFile(rn, filename)
+	}
+
+	write := writeFile
+	if rn.validate != "" && !options.dryRun {
+		write = func(filename string, content []byte, mode os.FileMode) error {
+			return writeValidatedFile(rn.validate, filename, content, mode)
+		}
+	}
+	var err error
+	if guard != nil && !options.dryRun {
+		err = guard.write(filename, content, rn.fileMode, write)
+	} else {
+		err = write(filename, content, rn.fileMode)
+	}
+	// step: label the file so a confined application can read it
+	if err == nil && !options.dryRun {
+		err = labelFile(rn, filename)
+	}
+
+	return err
 }
 
 // writeFile writes the file to stdout or an actual file
 func writeFile(filename string, content []byte, mode os.FileMode) error {
+	if options.maxFileSize > 0 && int64(len(content)) > int64(options.maxFileSize) {
+		return fmt.Errorf("the file: %s is %d bytes, exceeding the maximum file size of %d bytes", filename, len(content), options.maxFileSize)
+	}
 	if options.dryRun {
 		glog.Infof("dry-run: filename: %s, content:", filename)
 		fmt.Printf("%s\n", string(content))
 		return nil
 	}
+	if audit != nil {
+		audit.track(filename)
+	}
+	// step: a re-render of an unchanged secret leaves the file untouched, so watchers of the file aren't woken
+	if isUnchangedFile(filename, content) {
+		glog.V(4).Infof("the file: %s is unchanged, skipping the write", filename)
+		return nil
+	}
 	glog.V(3).Infof("saving the file: %s", filename)
 
 	return ioutil.WriteFile(filename, content, mode)
 }
+
+// isUnchangedFile checks if the file already holds the content
+//	filename	: the file to check
+//	content		: the content being written
+func isUnchangedFile(filename string, content []byte) bool {
+	stat, err := os.Stat(filename)
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() != int64(len(content)) {
+		return false
+	}
+	current, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	defer zeroBytes(current)
+
+	return bytes.Equal(current, content)
+}
+
+const (
+	// patchBeginMarker marks the start of a block managed by the sidekick in patch mode
+	patchBeginMarker = "vault-sidekick:begin"
+	// patchEndMarker marks the end of a block managed by the sidekick in patch mode
+	patchEndMarker = "vault-sidekick:end"
+)
+
+// writePatchFile updates the placeholders inside an existing file, leaving the rest of the content untouched
+func writePatchFile(filename string, data map[string]interface{}, pattern *regexp.Regexp) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("patch mode requires an existing file: %s, error: %s", filename, err)
+	}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+	var count int
+	if pattern != nil {
+		patched, count = patchRegexContent(content, pattern, data)
+	} else {
+		patched, count, err = patchMarkerContent(content, data)
+		if err != nil {
+			return err
+		}
+	}
+	if count == 0 {
+		glog.Warningf("no placeholders were found in the file: %s, nothing to patch", filename)
+		return nil
+	}
+	defer zeroBytes(patched)
+	if bytes.Equal(content, patched) {
+		glog.V(4).Infof("the file: %s is already up to date", filename)
+		return nil
+	}
+
+	return writeFile(filename, patched, stat.Mode())
+}
+
+// patchMarkerContent replaces the lines between the begin and end markers of a key with the value
+// of the key, i.e.
+//	# vault-sidekick:begin password
+//	the value is placed here
+//	# vault-sidekick:end password
+// The marker lines can use any comment syntax as we only look for the marker within the line
+func patchMarkerContent(content []byte, data map[string]interface{}) ([]byte, int, error) {
+	var buf bytes.Buffer
+	var key string
+	var count int
+
+	lines := strings.SplitAfter(string(content), "\n")
+	for i, line := range lines {
+		if key != "" {
+			if name, found := markerKey(line, patchEndMarker); found {
+				if name != key {
+					return nil, 0, fmt.Errorf("line %d: unexpected end marker for: %s, expected: %s", i+1, name, key)
+				}
+				buf.WriteString(fmt.Sprintf("%v\n", data[key]))
+				buf.WriteString(line)
+				key = ""
+				count++
+			}
+			continue
+		}
+		buf.WriteString(line)
+		if name, found := markerKey(line, patchBeginMarker); found {
+			if !hasKey(name, data) {
+				glog.Warningf("the placeholder: %s has no matching key in the secret", name)
+				continue
+			}
+			key = name
+		}
+	}
+	if key != "" {
+		return nil, 0, fmt.Errorf("the placeholder: %s is missing an end marker", key)
+	}
+
+	return buf.Bytes(), count, nil
+}
+
+// patchRegexContent replaces each named capture group of the pattern with the value of the
+// secret key of the same name
+func patchRegexContent(content []byte, pattern *regexp.Regexp, data map[string]interface{}) ([]byte, int) {
+	var buf bytes.Buffer
+	var count int
+	names := pattern.SubexpNames()
+
+	last := 0
+	for _, match := range pattern.FindAllSubmatchIndex(content, -1) {
+		for i := 1; i < len(names); i++ {
+			start, end := match[2*i], match[2*i+1]
+			if names[i] == "" || start < 0 || start < last {
+				continue
+			}
+			value, found := data[names[i]]
+			if !found {
+				continue
+			}
+			buf.Write(content[last:start])
+			buf.WriteString(fmt.Sprintf("%v", value))
+			last = end
+			count++
+		}
+	}
+	buf.Write(content[last:])
+
+	return buf.Bytes(), count
+}
+
+// markerKey extracts the key from a line holding the marker
+func markerKey(line, marker string) (string, bool) {
+	idx := strings.Index(line, marker)
+	if idx < 0 {
+		return "", false
+	}
+	fields := strings.Fields(line[idx+len(marker):])
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	return fields[0], true
+}
+
+// writeBinaryFile writes the values of the secret as raw bytes, decoding them first if required; as with
+// the txt format a secret with multiple keys produces a file per key
+func writeBinaryFile(filename string, data map[string]interface{}, rn *VaultResource) error {
+	keys := getKeys(data)
+	if len(keys) == 0 {
+		return fmt.Errorf("the resource has no content to write")
+	}
+	for _, key := range keys {
+		name := filename
+		if len(keys) > 1 {
+			name = fmt.Sprintf("%s.%s", filename, key)
+		}
+		// step: a base64 value is decoded straight to disk where we can, rather than buffered
+		if value, found := data[key].(string); found && rn.decode == "base64" && isStreamable(rn) {
+			if err := streamBase64File(name, value, rn.fileMode); err != nil {
+				return fmt.Errorf("unable to decode the key: %s, error: %s", key, err)
+			}
+			continue
+		}
+		content, err := decodeValue(data[key], rn.decode)
+		if err != nil {
+			return fmt.Errorf("unable to decode the key: %s, error: %s", key, err)
+		}
+		if err := writeResourceContent(rn, name, content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeValue converts the value to bytes, decoding according to the encoding
+func decodeValue(value interface{}, encoding string) ([]byte, error) {
+	var content []byte
+	switch v := value.(type) {
+	case []byte:
+		content = v
+	case string:
+		content = []byte(v)
+	default:
+		content = []byte(fmt.Sprintf("%v", v))
+	}
+
+	switch encoding {
+	case "":
+		return content, nil
+	case "base64":
+		// step: wrapped base64 i.e. from a pem or the base64 cli carries whitespace
+		cleaned := strings.Join(strings.Fields(string(content)), "")
+		if decoded, err := base64.StdEncoding.DecodeString(cleaned); err == nil {
+			return decoded, nil
+		}
+		return base64.RawStdEncoding.DecodeString(strings.TrimRight(cleaned, "="))
+	}
+
+	return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+}
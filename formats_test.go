@@ -0,0 +1,170 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchMarkerContent(t *testing.T) {
+	content := "listen: 8080\n# vault-sidekick:begin password\nold\n# vault-sidekick:end password\nname: app\n"
+	data := map[string]interface{}{"password": "secret"}
+
+	patched, count, err := patchMarkerContent([]byte(content), data)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, "listen: 8080\n# vault-sidekick:begin password\nsecret\n# vault-sidekick:end password\nname: app\n", string(patched))
+
+	// step: patching again should be idempotent
+	again, _, err := patchMarkerContent(patched, data)
+	assert.NoError(t, err)
+	assert.Equal(t, string(patched), string(again))
+
+	_, _, err = patchMarkerContent([]byte("# vault-sidekick:begin password\nold\n"), data)
+	assert.Error(t, err)
+
+	_, count, err = patchMarkerContent([]byte("# vault-sidekick:begin missing\nold\n# vault-sidekick:end missing\n"), data)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestPatchRegexContent(t *testing.T) {
+	pattern := regexp.MustCompile(`(?m)^db_(user|pass) (?P<username>\w+) (?P<password>\w+)$`)
+	content := "host localhost\ndb_user admin changeme\n"
+	data := map[string]interface{}{"username": "app", "password": "s3cr3t"}
+
+	patched, count := patchRegexContent([]byte(content), pattern, data)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, "host localhost\ndb_user app s3cr3t\n", string(patched))
+}
+
+func TestDecodeValue(t *testing.T) {
+	cases := []struct {
+		Value    interface{}
+		Encoding string
+		Expected []byte
+		Error    bool
+	}{
+		{Value: "plain", Expected: []byte("plain")},
+		{Value: "AAEC/w==", Encoding: "base64", Expected: []byte{0x00, 0x01, 0x02, 0xff}},
+		{Value: "AAEC\n/w==\n", Encoding: "base64", Expected: []byte{0x00, 0x01, 0x02, 0xff}},
+		{Value: "AAEC/w", Encoding: "base64", Expected: []byte{0x00, 0x01, 0x02, 0xff}},
+		{Value: "not base64!", Encoding: "base64", Error: true},
+		{Value: "plain", Encoding: "hex", Error: true},
+	}
+	for i, c := range cases {
+		decoded, err := decodeValue(c.Value, c.Encoding)
+		if c.Error {
+			assert.Error(t, err, "case %d, should have failed", i)
+			continue
+		}
+		if assert.NoError(t, err, "case %d, should not have failed", i) {
+			assert.Equal(t, c.Expected, decoded, "case %d, unexpected content", i)
+		}
+	}
+}
+
+func TestDeterministicFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "formats")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	data := make(map[string]interface{}, 0)
+	for i := 0; i < 32; i++ {
+		data[fmt.Sprintf("key_%d", i)] = map[string]interface{}{"a": i, "b": []interface{}{"x", i}}
+	}
+	for _, format := range []string{"yaml", "json", "ini", "csv", "env"} {
+		var resources VaultResources
+		assert.NoError(t, resources.Set("secret:secret/app:fmt="+format))
+		rn := resources.items[0]
+		filename := filepath.Join(dir, "app."+format)
+
+		var previous []byte
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, writeResourceFile(rn, filename, data))
+			content, err := ioutil.ReadFile(filename)
+			assert.NoError(t, err)
+			if previous != nil {
+				assert.Equal(t, string(previous), string(content), "format: %s is not deterministic", format)
+			}
+			previous = content
+		}
+	}
+}
+
+func TestWriteFileUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "formats")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app")
+	assert.NoError(t, writeFile(filename, []byte("content"), 0600))
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	assert.NoError(t, os.Chtimes(filename, past, past))
+
+	// step: the same content leaves the file untouched
+	assert.NoError(t, writeFile(filename, []byte("content"), 0600))
+	stat, err := os.Stat(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, past, stat.ModTime())
+
+	// step: a change is written
+	assert.NoError(t, writeFile(filename, []byte("changed"), 0600))
+	stat, err = os.Stat(filename)
+	assert.NoError(t, err)
+	assert.NotEqual(t, past, stat.ModTime())
+}
+
+func TestWriteResourceUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unchanged")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	// step: the metadata file is rewritten on each update, but isn't a change of the resource
+	filename := filepath.Join(dir, "app.txt")
+	rn := defaultVaultResource()
+	rn.format = "txt"
+	rn.metaFile = true
+	data := map[string]interface{}{"value": "one"}
+	assert.NoError(t, writeResource(rn, filename, data, &secretMetadata{Renewals: 1}))
+	assert.Equal(t, errResourceUnchanged, writeResource(rn, filename, data, &secretMetadata{Renewals: 2}))
+	assert.NoError(t, writeResource(rn, filename, map[string]interface{}{"value": "two"}, &secretMetadata{Renewals: 3}))
+
+	// step: an envdir is written to a new directory each time, a key removed is a change
+	envdir := filepath.Join(dir, "env")
+	rn = defaultVaultResource()
+	rn.format = "envdir"
+	data = map[string]interface{}{"user": "app", "password": "secret"}
+	assert.NoError(t, writeResource(rn, envdir, data, nil))
+	assert.Equal(t, errResourceUnchanged, writeResource(rn, envdir, data, nil))
+	assert.NoError(t, writeResource(rn, envdir, map[string]interface{}{"user": "app"}, nil))
+	assert.Equal(t, errResourceUnchanged, writeResource(rn, envdir, map[string]interface{}{"user": "app"}, nil))
+}
@@ -0,0 +1,295 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// maxFreezeDuration is the longest a resource can be frozen for, bounding the search for the end of a window
+var maxFreezeDuration = time.Duration(366*24) * time.Hour
+
+// freezeTimeLayouts are the layouts accepted for the start and end of a fixed window, in the local time
+// unless given a zone
+var freezeTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"}
+
+// freezeWindow is a change freeze, during which the new versions of a resource are held back rather than written
+type freezeWindow struct {
+	// the start and end of a fixed window
+	start time.Time
+	end   time.Time
+	// the schedule a recurring window opens on, and how long it stays open
+	schedule *cronSchedule
+	duration time.Duration
+}
+
+// parseFreezeWindow parses a freeze window, either a fixed range i.e. 2026-12-20/2027-01-04, or a cron expression
+// the window opens on followed by how long it lasts i.e. 0 18 * * 5+60h
+//	value		: the window
+func parseFreezeWindow(value string) (*freezeWindow, error) {
+	value = strings.TrimSpace(value)
+	// step: a cron expression has fields, whereas a fixed range may have a zone offset of +HH:MM
+	if i := strings.LastIndex(value, "+"); i > 0 && len(strings.Fields(value[:i])) > 1 {
+		duration, err := time.ParseDuration(strings.TrimSpace(value[i+1:]))
+		if err != nil || duration < time.Minute || duration > maxFreezeDuration {
+			return nil, fmt.Errorf("the freeze window: %s must last between a minute and %s", value, maxFreezeDuration)
+		}
+		schedule, err := parseCronSchedule(value[:i])
+		if err != nil {
+			return nil, fmt.Errorf("the freeze window: %s is invalid, error: %s", value, err)
+		}
+		return &freezeWindow{schedule: schedule, duration: duration}, nil
+	}
+
+	items := strings.Split(value, "/")
+	if len(items) != 2 {
+		return nil, fmt.Errorf("the freeze window: %s should be START/END or a cron expression and a duration i.e. 0 18 * * 5+60h", value)
+	}
+	w := &freezeWindow{}
+	for i, x := range []*time.Time{&w.start, &w.end} {
+		parsed, err := parseFreezeTime(strings.TrimSpace(items[i]))
+		if err != nil {
+			return nil, fmt.Errorf("the freeze window: %s is invalid, error: %s", value, err)
+		}
+		*x = parsed
+	}
+	if !w.end.After(w.start) {
+		return nil, fmt.Errorf("the freeze window: %s must end after it starts", value)
+	}
+
+	return w, nil
+}
+
+// parseFreezeTime parses the start or end of a fixed window
+func parseFreezeTime(value string) (time.Time, error) {
+	for _, layout := range freezeTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("the time: %s should be in the form 2006-01-02, 2006-01-02T15:04 or RFC3339", value)
+}
+
+// until checks if the window is open, returning when it closes; a recurring window opening again before it
+// closes is extended
+//	now			: the time to check
+func (w *freezeWindow) until(now time.Time) (time.Time, bool) {
+	if w.schedule == nil {
+		return w.end, !now.Before(w.start) && now.Before(w.end)
+	}
+	start, found := w.schedule.latest(now, w.duration)
+	if !found {
+		return time.Time{}, false
+	}
+	end := start.Add(w.duration)
+	for end.Sub(now) < maxFreezeDuration {
+		next, found := w.schedule.latest(end, w.duration)
+		if !found || !next.After(start) {
+			break
+		}
+		start, end = next, next.Add(w.duration)
+	}
+
+	return end, true
+}
+
+// cronSchedule is a standard five field cron expression: minute, hour, day of the month, month and day of the week
+type cronSchedule struct {
+	minute  map[int]bool
+	hour    map[int]bool
+	day     map[int]bool
+	month   map[int]bool
+	weekday map[int]bool
+	// the day of the month and week were restricted, a time matching either is matched as in cron
+	anyDay     bool
+	anyWeekday bool
+}
+
+// parseCronSchedule parses a cron expression i.e. 0 18 * * 5
+//	value		: the expression
+func parseCronSchedule(value string) (*cronSchedule, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("the cron expression: %s should have five fields", value)
+	}
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets []map[int]bool
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	// step: sunday may be given as 0 or 7
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+
+	return &cronSchedule{
+		minute:     sets[0],
+		hour:       sets[1],
+		day:        sets[2],
+		month:      sets[3],
+		weekday:    sets[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses a field of a cron expression, a list of values, ranges and steps i.e. 1-5,*/15
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := make(map[int]bool, 0)
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("the step of the cron field: %s is invalid", field)
+			}
+			step, item = n, item[:i]
+		}
+		low, high := min, max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("the cron field: %s is invalid", field)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("the cron field: %s is invalid", field)
+				}
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("the cron field: %s is out of the range %d-%d", field, min, max)
+		}
+		for x := low; x <= high; x += step {
+			set[x] = true
+		}
+	}
+
+	return set, nil
+}
+
+// matches checks if the schedule matches the minute of the time
+func (c *cronSchedule) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	day, weekday := c.day[t.Day()], c.weekday[int(t.Weekday())]
+	if !c.anyDay && !c.anyWeekday {
+		return day || weekday
+	}
+
+	return day && weekday
+}
+
+// latest returns the latest time the schedule matches within the period leading up to the time
+//	t			: the time
+//	period		: how far back to look
+func (c *cronSchedule) latest(t time.Time, period time.Duration) (time.Time, bool) {
+	earliest := t.Add(-period)
+	for x := t.Truncate(time.Minute); x.After(earliest); x = x.Add(-time.Minute) {
+		if c.matches(x) {
+			return x, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// freezer holds back the updates of the resources within their freeze window, releasing the latest once it closes
+type freezer struct {
+	sync.Mutex
+	// the latest update held back, by resource
+	held map[*VaultResource]VaultEvent
+	// the resources written since starting
+	written map[*VaultResource]bool
+	// the channel the updates are released onto
+	updates chan VaultEvent
+}
+
+// newFreezer creates a freezer releasing the updates onto the channel
+func newFreezer(updates chan VaultEvent) *freezer {
+	return &freezer{
+		held:    make(map[*VaultResource]VaultEvent, 0),
+		written: make(map[*VaultResource]bool, 0),
+		updates: updates,
+	}
+}
+
+// hold checks if the update falls within the freeze window of the resource, holding it back until the window
+// closes; a resource not yet written is written regardless, so the application can start
+//	evt			: the update
+func (f *freezer) hold(evt VaultEvent) bool {
+	rn := evt.Resource
+	if rn.freeze == nil {
+		return false
+	}
+	now := time.Now()
+	end, frozen := rn.freeze.until(now)
+	if !frozen {
+		return false
+	}
+	f.Lock()
+	defer f.Unlock()
+	if !f.written[rn] {
+		if _, err := os.Stat(resolveFilename(rn.GetFilename())); err != nil {
+			return false
+		}
+	}
+	_, waiting := f.held[rn]
+	f.held[rn] = evt
+	glog.Infof("the resource: %s is frozen until %s, holding back the new version", rn, formatTime(end))
+	if !waiting {
+		time.AfterFunc(end.Sub(now), func() {
+			f.release(rn)
+		})
+	}
+
+	return true
+}
+
+// record marks the resource as written
+func (f *freezer) record(rn *VaultResource) {
+	f.Lock()
+	defer f.Unlock()
+	f.written[rn] = true
+}
+
+// release hands the update held back for the resource to the processor, once its window has closed
+func (f *freezer) release(rn *VaultResource) {
+	f.Lock()
+	evt, found := f.held[rn]
+	delete(f.held, rn)
+	f.Unlock()
+	if found {
+		glog.Infof("the freeze window of the resource: %s has closed, writing the held back version", rn)
+		f.updates <- evt
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFreezeWindow(t *testing.T) {
+	for _, x := range []string{
+		"2026-12-20/2027-01-04",
+		"2026-12-20T18:00/2027-01-04T06:00",
+		"2026-12-20T18:00:00Z/2027-01-04T06:00:00+01:00",
+		"0 18 * * 5+60h",
+		"*/15 9-17 * * 1-5+5m",
+		"0 0 1,15 * *+24h",
+	} {
+		_, err := parseFreezeWindow(x)
+		assert.NoError(t, err, "window: %s", x)
+	}
+	for _, x := range []string{
+		"",
+		"2026-12-20",
+		"2027-01-04/2026-12-20",
+		"2026-12-20/tomorrow",
+		"0 18 * * 5",
+		"0 18 * * 5+30s",
+		"0 18 * * 5+9000h",
+		"0 18 * *+1h",
+		"0 24 * * *+1h",
+		"0 18 * * 8+1h",
+		"*/0 * * * *+1h",
+	} {
+		_, err := parseFreezeWindow(x)
+		assert.Error(t, err, "window: %s", x)
+	}
+}
+
+func TestFreezeOptionValid(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/app:freeze=2026-12-20/2027-01-04"))
+	assert.NoError(t, resources.items[0].IsValid())
+
+	// step: the lease behind a dynamic secret held back could expire or be revoked within the window
+	for _, x := range []string{
+		"mysql:mysql/creds/app:freeze=2026-12-20/2027-01-04",
+		"pki:pki/issue/web:common_name=web.svc,freeze=2026-12-20/2027-01-04",
+		"secret:database/static-creds/app:freeze=2026-12-20/2027-01-04",
+	} {
+		resources = VaultResources{}
+		if assert.NoError(t, resources.Set(x)) {
+			assert.Error(t, resources.items[0].IsValid(), "resource: %s", x)
+		}
+	}
+}
+
+func TestFreezeWindowUntil(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, time.Local)
+	}
+
+	w, _ := parseFreezeWindow("2026-10-16T18:00/2026-10-19T06:00")
+	_, frozen := w.until(at(16, 17, 59))
+	assert.False(t, frozen)
+	end, frozen := w.until(at(17, 12, 0))
+	assert.True(t, frozen)
+	assert.Equal(t, at(19, 6, 0), end)
+	_, frozen = w.until(at(19, 6, 0))
+	assert.False(t, frozen)
+
+	// step: friday 18:00 for 60 hours, 2026-10-16 is a friday
+	w, _ = parseFreezeWindow("0 18 * * 5+60h")
+	_, frozen = w.until(at(16, 17, 59))
+	assert.False(t, frozen)
+	end, frozen = w.until(at(18, 9, 30))
+	assert.True(t, frozen)
+	assert.Equal(t, at(19, 6, 0), end)
+	_, frozen = w.until(at(19, 6, 0))
+	assert.False(t, frozen)
+
+	// step: a window opening before the last closes extends it
+	w, _ = parseFreezeWindow("0 22 * * *+25h")
+	end, frozen = w.until(at(16, 23, 0))
+	assert.True(t, frozen)
+	assert.True(t, end.Sub(at(16, 23, 0)) >= maxFreezeDuration)
+}
+
+func TestCronScheduleMatches(t *testing.T) {
+	c, err := parseCronSchedule("30 9 1 * 1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// step: with the day of the month and week both given, either matches
+	assert.True(t, c.matches(time.Date(2026, time.October, 1, 9, 30, 0, 0, time.Local)))
+	assert.True(t, c.matches(time.Date(2026, time.October, 19, 9, 30, 0, 0, time.Local)))
+	assert.False(t, c.matches(time.Date(2026, time.October, 20, 9, 30, 0, 0, time.Local)))
+	assert.False(t, c.matches(time.Date(2026, time.October, 19, 9, 31, 0, 0, time.Local)))
+
+	c, _ = parseCronSchedule("0 0 * * 7")
+	assert.True(t, c.matches(time.Date(2026, time.October, 18, 0, 0, 0, 0, time.Local)))
+}
+
+func TestFreezerHold(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freeze")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	updates := make(chan VaultEvent, 1)
+	f := newFreezer(updates)
+	window := &freezeWindow{start: time.Now().Add(-time.Hour), end: time.Now().Add(200 * time.Millisecond)}
+	rn := &VaultResource{resource: "secret", path: "secret/app", filename: filepath.Join(dir, "app"), freeze: window}
+
+	// step: the first version is written regardless, the file doesn't exist
+	assert.False(t, f.hold(VaultEvent{Resource: rn, Type: EventTypeSuccess}))
+	f.record(rn)
+
+	// step: the later versions are held back, the latest released once the window closes
+	assert.True(t, f.hold(VaultEvent{Resource: rn, Secret: map[string]interface{}{"version": 1}}))
+	assert.True(t, f.hold(VaultEvent{Resource: rn, Secret: map[string]interface{}{"version": 2}}))
+	select {
+	case evt := <-updates:
+		assert.Equal(t, 2, evt.Secret["version"])
+		assert.False(t, f.hold(evt))
+	case <-time.After(2 * time.Second):
+		t.Errorf("the held back version was not released")
+	}
+
+	// step: a resource whose file exists is pinned after a restart
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pinned"), []byte("{}"), 0644))
+	pinned := &VaultResource{resource: "secret", path: "secret/app", filename: filepath.Join(dir, "pinned"),
+		freeze: &freezeWindow{start: time.Now().Add(-time.Hour), end: time.Now().Add(time.Hour)}}
+	assert.True(t, f.hold(VaultEvent{Resource: pinned}))
+	assert.False(t, f.hold(VaultEvent{Resource: &VaultResource{resource: "secret", path: "secret/app"}}))
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// the methods of a generic resource
+	genericMethodRead  = "read"
+	genericMethodWrite = "write"
+)
+
+// getGeneric performs a raw request against the path of a generic resource, so a secret engine the sidekick has no
+// support for i.e. kmip or a custom plugin can be used; the options not known to the sidekick are the parameters of
+// the request, sent as the query of a read or the body of a write
+//	rn			: the watched resource
+//	params		: the parameters of the request
+func (r VaultService) getGeneric(rn *watchedResource, params map[string]interface{}) (*api.Secret, error) {
+	path := rn.resource.path
+	if rn.resource.method == genericMethodWrite {
+		secret, err := r.client.Logical().Write(path, params)
+		if err != nil {
+			return nil, err
+		}
+		// step: an engine answering the write with no content is read back i.e. a role which is created then read
+		if secret != nil && len(secret.Data) > 0 {
+			return secret, nil
+		}
+		glog.V(4).Infof("resource: %s returned no data on write, reading the path", rn.resource)
+
+		return r.client.Logical().Read(path)
+	}
+	// step: the engine may issue credentials, so the read is never cached or served by a standby
+	if len(params) == 0 {
+		return r.read(rn, path)
+	}
+
+	client := r.reader(rn)
+	request := client.NewRequest(http.MethodGet, "/v1/"+path)
+	for k, v := range params {
+		request.Params.Add(k, fmt.Sprintf("%v", v))
+	}
+	resp, err := client.RawRequest(request)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ParseSecret(resp.Body)
+}
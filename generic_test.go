@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenericResourceOptions(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("generic:kmip/scope/app/role/web/credential/generate:method=write,format=pem"))
+	rn := resources.items[0]
+	assert.NoError(t, rn.IsValid())
+	assert.Equal(t, genericMethodWrite, rn.method)
+	assert.Equal(t, "pem", rn.options["format"])
+	assert.True(t, rn.isDynamic())
+
+	assert.Error(t, resources.Set("generic:kmip/scope/app:method=delete"))
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("secret:secret/app:method=read"))
+	assert.Error(t, resources.items[0].IsValid())
+}
+
+func TestGenericRead(t *testing.T) {
+	service, updates := newMockService(t)
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("generic:secret/app:fmt=json"))
+	service.Watch(resources.items[0])
+	evt := waitForEvent(t, updates)
+	assert.Equal(t, EventTypeSuccess, evt.Type)
+	assert.NotEmpty(t, evt.Secret)
+
+	// step: the parameters are sent as the query of the read
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("generic:secret/app:fmt=json,version=2"))
+	service.Watch(resources.items[0])
+	evt = waitForEvent(t, updates)
+	assert.Equal(t, EventTypeSuccess, evt.Type)
+	assert.NotEmpty(t, evt.Secret)
+}
+
+func TestGenericWriteRead(t *testing.T) {
+	service, updates := newMockService(t)
+
+	// step: the mock answers the write with no content, so the path is read back
+	var resources VaultResources
+	assert.NoError(t, resources.Set("generic:plugin/roles/web:method=write,fmt=json,scope=app"))
+	service.Watch(resources.items[0])
+	evt := waitForEvent(t, updates)
+	if assert.Equal(t, EventTypeSuccess, evt.Type) {
+		assert.Equal(t, "app", evt.Secret["scope"])
+	}
+}
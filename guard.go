@@ -0,0 +1,202 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// guard watches the files written, rewriting any which are deleted or modified by another process, nil if disabled
+var guard *fileGuard
+
+// guardInterval is the interval all the files are verified, catching any change the watcher missed
+var guardInterval = time.Duration(30) * time.Second
+
+// fileGuard holds the content last written to each file, so it can be restored
+type fileGuard struct {
+	sync.Mutex
+	// the files being guarded
+	files map[string]*guardedFile
+	// the directories being watched, by watch descriptor
+	watches map[int]string
+	// the watch descriptors, by directory
+	directories map[string]int
+	// the inotify descriptor, -1 when not watching
+	fd int
+}
+
+// guardedFile is the content and permissions last written to a file
+type guardedFile struct {
+	// the content written
+	content []byte
+	// the sha256 of the content
+	sum [sha256.Size]byte
+	// the file permissions
+	mode os.FileMode
+}
+
+// newFileGuard creates an empty guard
+func newFileGuard() *fileGuard {
+	return &fileGuard{
+		files:       make(map[string]*guardedFile, 0),
+		watches:     make(map[int]string, 0),
+		directories: make(map[string]int, 0),
+		fd:          -1,
+	}
+}
+
+// start begins watching the files, falling back to verifying them on the interval if the platform
+// has no file notifications
+func (g *fileGuard) start(interval time.Duration) {
+	if err := g.watch(); err != nil {
+		glog.Warningf("unable to watch the output files, verifying every %s, error: %s", interval, err)
+	}
+	go func() {
+		for range time.NewTicker(interval).C {
+			g.verifyAll()
+		}
+	}()
+}
+
+// write writes the file and records the content, holding the lock so a verification never
+// races the write
+//	filename	: the path of the file
+//	content		: the content to write
+//	mode		: the file permissions
+//	write		: the function writing the file
+func (g *fileGuard) write(filename string, content []byte, mode os.FileMode, write func(string, []byte, os.FileMode) error) error {
+	g.Lock()
+	defer g.Unlock()
+
+	if err := write(filename, content, mode); err != nil {
+		return err
+	}
+	if x, found := g.files[filename]; found {
+		zeroBytes(x.content)
+	}
+	g.files[filename] = &guardedFile{
+		content: append([]byte(nil), content...),
+		sum:     sha256.Sum256(content),
+		mode:    mode,
+	}
+	g.watchDirectory(filepath.Dir(filename))
+
+	return nil
+}
+
+// forget stops guarding the file, used when the sidekick removes it
+func (g *fileGuard) forget(filename string) {
+	g.Lock()
+	defer g.Unlock()
+
+	if x, found := g.files[filename]; found {
+		zeroBytes(x.content)
+		delete(g.files, filename)
+	}
+}
+
+// guarded checks if the file is being guarded
+func (g *fileGuard) guarded(filename string) bool {
+	g.Lock()
+	defer g.Unlock()
+	_, found := g.files[filename]
+
+	return found
+}
+
+// verifyAll verifies each of the files guarded
+func (g *fileGuard) verifyAll() {
+	g.Lock()
+	var list []string
+	for filename := range g.files {
+		list = append(list, filename)
+	}
+	g.Unlock()
+
+	for _, filename := range list {
+		g.verify(filename)
+	}
+}
+
+// verify checks the file still holds the content written, rewriting it if not; returns the
+// reason the file was rewritten, or empty if untouched
+func (g *fileGuard) verify(filename string) string {
+	g.Lock()
+	defer g.Unlock()
+
+	x, found := g.files[filename]
+	if !found {
+		return ""
+	}
+
+	reason := ""
+	content, err := ioutil.ReadFile(filename)
+	switch {
+	case os.IsNotExist(err):
+		reason = "deleted"
+	case err != nil:
+		glog.Errorf("unable to verify the file: %s, error: %s", filename, err)
+		return ""
+	default:
+		sum := sha256.Sum256(content)
+		zeroBytes(content)
+		if !bytes.Equal(sum[:], x.sum[:]) {
+			reason = "modified"
+		}
+	}
+	if reason == "" {
+		// step: restore the permissions if changed
+		if stat, err := os.Stat(filename); err == nil && stat.Mode().Perm() != x.mode.Perm() {
+			glog.Warningf("the permissions of the file: %s were changed to %s, restoring %s", filename, stat.Mode().Perm(), x.mode.Perm())
+			metrics.add(metricTampered, 1, "file", filename, "reason", "mode")
+			if err := os.Chmod(filename, x.mode); err != nil {
+				glog.Errorf("unable to restore the permissions of the file: %s, error: %s", filename, err)
+			}
+			return "mode"
+		}
+		return ""
+	}
+
+	glog.Warningf("the file: %s was %s by another process, rewriting", filename, reason)
+	metrics.add(metricTampered, 1, "file", filename, "reason", reason)
+
+	// step: the directory may have been removed with the file
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		glog.Errorf("unable to recreate the directory: %s, error: %s", dir, err)
+		return reason
+	}
+	if err := writeFile(filename, x.content, x.mode); err != nil {
+		glog.Errorf("unable to rewrite the file: %s, error: %s", filename, err)
+		return reason
+	}
+	// step: ioutil.WriteFile only applies the permissions on creation
+	if err := os.Chmod(filename, x.mode); err != nil {
+		glog.Errorf("unable to restore the permissions of the file: %s, error: %s", filename, err)
+	}
+	g.watchDirectory(dir)
+
+	return reason
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"github.com/golang/glog"
+)
+
+// guardEvents are the inotify events on a directory which could change a file
+const guardEvents = syscall.IN_CLOSE_WRITE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB
+
+// watch opens the inotify descriptor and reads the events in the background
+func (g *fileGuard) watch() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	g.Lock()
+	g.fd = fd
+	g.Unlock()
+
+	go g.readEvents(fd)
+
+	return nil
+}
+
+// watchDirectory adds a watch on the directory if not already watched, the directory rather than the
+// file is watched so a file deleted or replaced via a rename is noticed; the lock must be held
+func (g *fileGuard) watchDirectory(dir string) {
+	if g.fd < 0 {
+		return
+	}
+	if _, found := g.directories[dir]; found {
+		return
+	}
+	wd, err := syscall.InotifyAddWatch(g.fd, dir, guardEvents)
+	if err != nil {
+		glog.Errorf("unable to watch the directory: %s, error: %s", dir, err)
+		return
+	}
+	g.directories[dir] = wd
+	g.watches[wd] = dir
+}
+
+// readEvents reads the inotify events, verifying any of the files guarded
+func (g *fileGuard) readEvents(fd int) {
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(fd, buffer)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			glog.Errorf("stopped watching the output files, error: %v", err)
+			return
+		}
+		var files []string
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			name := buffer[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			g.Lock()
+			dir, found := g.watches[int(event.Wd)]
+			// step: the directory was removed, the watch is re-added when the file is restored
+			if found && event.Mask&syscall.IN_IGNORED != 0 {
+				delete(g.watches, int(event.Wd))
+				delete(g.directories, dir)
+			}
+			g.Unlock()
+			if !found || len(name) == 0 {
+				continue
+			}
+			files = append(files, filepath.Join(dir, string(bytes.TrimRight(name, "\x00"))))
+		}
+		for _, filename := range files {
+			if g.guarded(filename) {
+				g.verify(filename)
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+)
+
+// watch is not supported on this platform, the files are verified on the interval
+func (g *fileGuard) watch() error {
+	return errors.New("file notifications are not supported on this platform")
+}
+
+// watchDirectory is not supported on this platform
+func (g *fileGuard) watchDirectory(dir string) {}
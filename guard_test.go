@@ -0,0 +1,113 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newGuardedFile(t *testing.T) (*fileGuard, string, func()) {
+	dir, err := ioutil.TempDir("", "guard")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	g := newFileGuard()
+	filename := filepath.Join(dir, "tls.pem")
+	if !assert.NoError(t, g.write(filename, []byte("certificate"), 0600, writeFile)) {
+		t.FailNow()
+	}
+
+	return g, filename, func() { os.RemoveAll(dir) }
+}
+
+func TestFileGuardVerify(t *testing.T) {
+	g, filename, cleanup := newGuardedFile(t)
+	defer cleanup()
+
+	assert.Equal(t, "", g.verify(filename))
+
+	// step: a deleted file is rewritten
+	assert.NoError(t, os.Remove(filename))
+	assert.Equal(t, "deleted", g.verify(filename))
+	content, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "certificate", string(content))
+
+	// step: a modified file is rewritten
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("truncated"), 0600))
+	assert.Equal(t, "modified", g.verify(filename))
+	content, err = ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "certificate", string(content))
+
+	// step: a file removed with its directory is rewritten
+	assert.NoError(t, os.RemoveAll(filepath.Dir(filename)))
+	assert.Equal(t, "deleted", g.verify(filename))
+	content, err = ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "certificate", string(content))
+
+	// step: a file no longer guarded is left alone
+	g.forget(filename)
+	assert.NoError(t, os.Remove(filename))
+	assert.Equal(t, "", g.verify(filename))
+	exists, _ := fileExists(filename)
+	assert.False(t, exists)
+}
+
+func TestFileGuardVerifyMode(t *testing.T) {
+	g, filename, cleanup := newGuardedFile(t)
+	defer cleanup()
+
+	assert.NoError(t, os.Chmod(filename, 0644))
+	assert.Equal(t, "mode", g.verify(filename))
+	if stat, err := os.Stat(filename); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+	}
+}
+
+func TestFileGuardWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guard")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	g := newFileGuard()
+	if err := g.watch(); err != nil {
+		t.Skipf("file notifications are not supported: %s", err)
+	}
+	filename := filepath.Join(dir, "tls.pem")
+	if !assert.NoError(t, g.write(filename, []byte("certificate"), 0600, writeFile)) {
+		t.FailNow()
+	}
+	assert.NoError(t, os.Remove(filename))
+
+	restored := false
+	for i := 0; i < 100 && !restored; i++ {
+		time.Sleep(20 * time.Millisecond)
+		content, _ := ioutil.ReadFile(filename)
+		restored = string(content) == "certificate"
+	}
+	assert.True(t, restored, "the file should have been restored")
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateOptionsWithThen(t *testing.T) {
+	cfg := &config{vaultURL: "http://127.0.0.1:8200", then: true}
+	assert.Error(t, validateOptions(cfg))
+
+	cfg = &config{vaultURL: "http://127.0.0.1:8200", then: true, execEnvOnly: true, command: []string{"/app/server"}}
+	assert.Error(t, validateOptions(cfg))
+
+	// step: the resources are retrieved once before the hand over
+	cfg = &config{vaultURL: "http://127.0.0.1:8200", then: true, command: []string{"/app/server", "--flag"}}
+	assert.NoError(t, validateOptions(cfg))
+	assert.True(t, cfg.oneShot)
+
+	cfg = &config{vaultURL: "http://127.0.0.1:8200", then: true, command: []string{"/app/server"}, k8sConfigMap: "sidekick"}
+	assert.Error(t, validateOptions(cfg))
+}
+
+func TestHandoverMissingCommand(t *testing.T) {
+	assert.Error(t, handover([]string{"/nonexistent/vault-sidekick-command"}))
+}
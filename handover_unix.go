@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// handover replaces the sidekick with the command once the resources are retrieved, the command taking over the
+// pid, so as pid 1 of a container it receives the signals directly; it only returns if the exec fails
+//	command		: the command and arguments
+func handover(command []string) error {
+	binary, err := exec.LookPath(command[0])
+	if err != nil {
+		return fmt.Errorf("unable to find the command: %s, error: %s", command[0], err)
+	}
+	// step: flush the telemetry, nothing runs after the exec
+	flushTelemetry()
+	// step: the command starts with the default handling of the signals we caught
+	signal.Reset()
+
+	return syscall.Exec(binary, command, os.Environ())
+}
@@ -0,0 +1,60 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+
+	"github.com/golang/glog"
+)
+
+// handover runs the command once the resources are retrieved, windows can't replace the process so the command
+// is run as a child, the signals forwarded to it and its exit code passed on; it only returns if the start fails
+//	command		: the command and arguments
+func handover(command []string) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to start the command: %s, error: %s", command[0], err)
+	}
+
+	// step: the signals are forwarded to the command rather than shutting down the sidekick
+	signal.Reset()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		for sig := range signals {
+			if err := cmd.Process.Signal(sig); err != nil {
+				glog.Errorf("unable to forward the signal: %s to the command, error: %s", sig, err)
+			}
+		}
+	}()
+	err := cmd.Wait()
+	signal.Stop(signals)
+	exitWith(exitCode(err))
+
+	return nil
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+// vaultHealthInterval is the interval between the polls of the vault health
+var vaultHealthInterval = time.Duration(2) * time.Second
+
+// healthUpdateInterval is the default interval the health resource polls vault
+var healthUpdateInterval = time.Duration(10) * time.Second
+
+// readiness is the status of the vaults polled by the health resources, served by the readiness endpoint
+var readiness = newVaultReadiness()
+
+// vaultStatus is the health and seal status of a vault
+type vaultStatus struct {
+	// whether vault has been initialized
+	Initialized bool
+	// whether vault is sealed
+	Sealed bool
+	// whether the node is a standby
+	Standby bool
+	// the version of vault
+	Version string
+	// the name of the cluster
+	ClusterName string
+	// the number of unseal keys provided so far
+	Progress int
+	// the number of unseal keys required
+	Threshold int
+	// the number of unseal keys
+	Shares int
+}
+
+// data returns the status as the data of a secret
+func (s vaultStatus) data() map[string]interface{} {
+	return map[string]interface{}{
+		"initialized":      s.Initialized,
+		"sealed":           s.Sealed,
+		"standby":          s.Standby,
+		"version":          s.Version,
+		"cluster_name":     s.ClusterName,
+		"unseal_progress":  s.Progress,
+		"unseal_threshold": s.Threshold,
+		"unseal_shares":    s.Shares,
+	}
+}
+
+// vaultReadiness holds the last status of each of the vaults
+type vaultReadiness struct {
+	sync.RWMutex
+	// the status keyed by the name of the vault
+	items map[string]vaultStatus
+}
+
+// newVaultReadiness creates an empty readiness
+func newVaultReadiness() *vaultReadiness {
+	return &vaultReadiness{items: make(map[string]vaultStatus, 0)}
+}
+
+// update records the status of a vault and updates the metrics
+//	name		: the name of the vault
+//	status		: the status of the vault
+func (v *vaultReadiness) update(name string, status vaultStatus) {
+	v.Lock()
+	defer v.Unlock()
+	v.items[name] = status
+
+	labels := []string{"vault", name}
+	metrics.set(metricVaultInitialized, boolMetric(status.Initialized), labels...)
+	metrics.set(metricVaultSealed, boolMetric(status.Sealed), labels...)
+	metrics.set(metricVaultStandby, boolMetric(status.Standby), labels...)
+	metrics.set(metricVaultUnsealProgress, float64(status.Progress), labels...)
+}
+
+// ready checks none of the vaults are sealed or uninitialized, returning the reasons otherwise
+func (v *vaultReadiness) ready() (bool, []string) {
+	v.RLock()
+	defer v.RUnlock()
+	var reasons []string
+	for name, x := range v.items {
+		switch {
+		case !x.Initialized:
+			reasons = append(reasons, fmt.Sprintf("vault: %s is not initialized", name))
+		case x.Sealed:
+			reasons = append(reasons, fmt.Sprintf("vault: %s is sealed", name))
+		}
+	}
+	sort.Strings(reasons)
+
+	return len(reasons) == 0, reasons
+}
+
+// boolMetric converts a boolean into the value of a gauge
+func boolMetric(v bool) float64 {
+	if v {
+		return 1
+	}
+
+	return 0
+}
+
+// vaultLabel returns the name of the vault of a resource
+func vaultLabel(rn *VaultResource) string {
+	if rn.vault == "" {
+		return "default"
+	}
+
+	return rn.vault
+}
+
+// getHealth polls the sys/health and sys/seal-status of vault for a health resource, the secret is
+// unchanged unless the status differs from the last poll, so the file and hooks follow the transitions
+//	rn			: the watched resource
+func (r VaultService) getHealth(rn *watchedResource) (*api.Secret, error) {
+	client := r.reader(rn)
+	health, err := client.Sys().Health()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the health of vault, error: %s", err)
+	}
+	seal, err := client.Sys().SealStatus()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the seal status of vault, error: %s", err)
+	}
+	status := vaultStatus{
+		Initialized: health.Initialized,
+		Sealed:      health.Sealed,
+		Standby:     health.Standby,
+		Version:     health.Version,
+		ClusterName: health.ClusterName,
+		Progress:    seal.Progress,
+		Threshold:   seal.T,
+		Shares:      seal.N,
+	}
+	readiness.update(vaultLabel(rn.resource), status)
+
+	// step: only report the status when it has changed
+	if rn.health != nil && *rn.health == status {
+		return nil, errSecretUnchanged
+	}
+	if rn.health != nil {
+		glog.Infof("vault: %s has changed status, sealed: %t, standby: %t", vaultLabel(rn.resource), status.Sealed, status.Standby)
+	}
+	rn.health = &status
+
+	return &api.Secret{
+		Renewable:     false,
+		Data:          status.data(),
+		LeaseDuration: int(rn.resource.update.Seconds()),
+	}, nil
+}
+
+// vaultHealth is the response of the sys/health endpoint
+type vaultHealth struct {
+	// whether vault has been initialized
+	Initialized bool `json:"initialized"`
+	// whether vault is sealed
+	Sealed bool `json:"sealed"`
+	// whether the node is a standby
+	Standby bool `json:"standby"`
+}
+
+// waitForVault polls the health of vault until it's initialized, unsealed and active
+//	url			: the url of vault
+//	deadline	: the time to give up waiting, zero waits forever
+func waitForVault(url string, deadline time.Time) error {
+	transport, err := buildHTTPTransport(&options)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: transport, Timeout: time.Duration(10) * time.Second}
+
+	for {
+		status := checkVaultHealth(client, url)
+		if status == "" {
+			glog.Infof("vault: %s is unsealed and active", url)
+			return nil
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for vault: %s, status: %s", url, status)
+		}
+		glog.Infof("waiting for vault: %s to become active, status: %s", url, status)
+		time.Sleep(vaultHealthInterval)
+	}
+}
+
+// checkVaultHealth checks the health of vault, returning why it's not ready or empty if active
+func checkVaultHealth(client *http.Client, url string) string {
+	resp, err := client.Get(strings.TrimSuffix(url, "/") + "/v1/sys/health")
+	if err != nil {
+		return err.Error()
+	}
+	defer resp.Body.Close()
+
+	var health vaultHealth
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return fmt.Sprintf("unable to decode the health, status code: %d", resp.StatusCode)
+	}
+	switch {
+	case !health.Initialized:
+		return "not initialized"
+	case health.Sealed:
+		return "sealed"
+	case health.Standby:
+		return "standby"
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Sprintf("status code: %d", resp.StatusCode)
+	}
+
+	return ""
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForVault(t *testing.T) {
+	original := vaultHealthInterval
+	vaultHealthInterval = time.Duration(10) * time.Millisecond
+	defer func() { vaultHealthInterval = original }()
+
+	responses := []struct {
+		Code   int
+		Health vaultHealth
+	}{
+		{Code: http.StatusNotImplemented, Health: vaultHealth{}},
+		{Code: http.StatusServiceUnavailable, Health: vaultHealth{Initialized: true, Sealed: true}},
+		{Code: http.StatusTooManyRequests, Health: vaultHealth{Initialized: true, Standby: true}},
+		{Code: http.StatusOK, Health: vaultHealth{Initialized: true}},
+	}
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		x := responses[polls]
+		if polls < len(responses)-1 {
+			polls++
+		}
+		w.WriteHeader(x.Code)
+		json.NewEncoder(w).Encode(x.Health)
+	}))
+	defer server.Close()
+
+	assert.NoError(t, waitForVault(server.URL, time.Now().Add(5*time.Second)))
+	assert.Equal(t, len(responses)-1, polls)
+}
+
+func TestWaitForVaultDeadline(t *testing.T) {
+	original := vaultHealthInterval
+	vaultHealthInterval = time.Duration(10) * time.Millisecond
+	defer func() { vaultHealthInterval = original }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(vaultHealth{Initialized: true, Sealed: true})
+	}))
+	defer server.Close()
+
+	err := waitForVault(server.URL, time.Now().Add(50*time.Millisecond))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "sealed")
+	}
+}
+
+func TestGetHealth(t *testing.T) {
+	sealed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/health":
+			json.NewEncoder(w).Encode(map[string]interface{}{"initialized": true, "sealed": sealed, "version": "0.9.6"})
+		case "/v1/sys/seal-status":
+			json.NewEncoder(w).Encode(map[string]interface{}{"sealed": sealed, "t": 3, "n": 5, "progress": 1})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL, HttpClient: http.DefaultClient})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	service := VaultService{client: client}
+	var resources VaultResources
+	assert.NoError(t, resources.Set("health:sys/health:vault=health-test"))
+	rn := &watchedResource{resource: resources.items[0]}
+	assert.Equal(t, healthUpdateInterval, rn.resource.update)
+
+	// step: the first poll reports the status
+	secret, err := service.getHealth(rn)
+	if assert.NoError(t, err) {
+		assert.Equal(t, false, secret.Data["sealed"])
+		assert.Equal(t, 3, secret.Data["unseal_threshold"])
+		assert.Equal(t, "0.9.6", secret.Data["version"])
+		assert.Equal(t, int(healthUpdateInterval.Seconds()), secret.LeaseDuration)
+	}
+	ok, _ := readiness.ready()
+	assert.True(t, ok)
+
+	// step: an unchanged status isn't reported again
+	_, err = service.getHealth(rn)
+	assert.Equal(t, errSecretUnchanged, err)
+
+	// step: a sealed vault fails the readiness
+	sealed = true
+	secret, err = service.getHealth(rn)
+	if assert.NoError(t, err) {
+		assert.Equal(t, true, secret.Data["sealed"])
+	}
+	ok, reasons := readiness.ready()
+	assert.False(t, ok)
+	assert.Equal(t, []string{"vault: health-test is sealed"}, reasons)
+
+	admin := httptest.NewServer(newAdminHandler())
+	defer admin.Close()
+	resp, err := http.Get(admin.URL + "/ready")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	sealed = false
+	_, err = service.getHealth(rn)
+	assert.NoError(t, err)
+	resp, err = http.Get(admin.URL + "/ready")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// hedgeLatencySamples is the number of read latencies the hedge delay is taken from
+	hedgeLatencySamples = 100
+	// hedgeMinimumSamples is the number of reads before the latencies are trusted
+	hedgeMinimumSamples = 20
+	// hedgeDefaultDelay is the hedge delay until enough reads have been made
+	hedgeDefaultDelay = time.Duration(250) * time.Millisecond
+	// hedgeMinimumDelay is the shortest delay before a read is hedged
+	hedgeMinimumDelay = time.Duration(10) * time.Millisecond
+	// retryAfterAttempts is the number of times a request is retried as asked by vault
+	retryAfterAttempts = 3
+	// retryAfterMaximum is the longest wait for a retry asked by vault
+	retryAfterMaximum = time.Duration(30) * time.Second
+)
+
+// latencyWindow holds the latencies of the latest reads
+type latencyWindow struct {
+	sync.Mutex
+	// the latencies, a ring buffer
+	samples []time.Duration
+	// the next position in the ring buffer
+	next int
+}
+
+// newLatencyWindow creates an empty window
+func newLatencyWindow() *latencyWindow {
+	return &latencyWindow{}
+}
+
+// observe records the latency of a read
+func (l *latencyWindow) observe(latency time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	if len(l.samples) < hedgeLatencySamples {
+		l.samples = append(l.samples, latency)
+		return
+	}
+	l.samples[l.next] = latency
+	l.next = (l.next + 1) % hedgeLatencySamples
+}
+
+// delay returns the time a read is given before it's hedged, the 95th percentile of the latest reads
+func (l *latencyWindow) delay() time.Duration {
+	l.Lock()
+	samples := make([]time.Duration, len(l.samples))
+	copy(samples, l.samples)
+	l.Unlock()
+	if len(samples) < hedgeMinimumSamples {
+		return hedgeDefaultDelay
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	delay := samples[len(samples)*95/100]
+	if delay < hedgeMinimumDelay {
+		delay = hedgeMinimumDelay
+	}
+
+	return delay
+}
+
+// hedgedResult is the outcome of one of the attempts of a hedged read
+type hedgedResult struct {
+	secret *api.Secret
+	err    error
+	hedge  bool
+}
+
+// hedgedRead reads the path, making a second attempt against the other vault address when the first hasn't
+// answered within the 95th percentile of the latest reads, and returns the first success
+//
+//	primary		: the client the read is made with
+//	secondary	: the client the hedged read is made with, which may be the same client
+//	latency		: the latencies of the latest reads
+//	path		: the path to read
+func hedgedRead(primary, secondary *api.Client, latency *latencyWindow, path string) (*api.Secret, error) {
+	results := make(chan hedgedResult, 2)
+	attempt := func(client *api.Client, hedge bool) {
+		started := time.Now()
+		secret, err := client.Logical().Read(path)
+		if err == nil {
+			latency.observe(time.Since(started))
+		}
+		results <- hedgedResult{secret: secret, err: err, hedge: hedge}
+	}
+	go attempt(primary, false)
+
+	timer := time.NewTimer(latency.delay())
+	defer timer.Stop()
+	pending := 1
+	hedged := false
+	var failure hedgedResult
+	for {
+		select {
+		case <-timer.C:
+			glog.V(4).Infof("the read of: %s is slower than: %s, hedging against: %s", path, latency.delay(), secondary.Address())
+			hedged = true
+			pending++
+			go attempt(secondary, true)
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				if hedged {
+					metrics.add(metricHedgedReads, 1, "winner", map[bool]string{true: "hedge", false: "primary"}[result.hedge])
+				}
+				return result.secret, nil
+			}
+			failure = result
+			// step: a failure before the hedge is due is hedged straight away
+			if !hedged && timer.Stop() {
+				hedged = true
+				pending++
+				go attempt(secondary, true)
+			}
+			if pending == 0 {
+				return failure.secret, failure.err
+			}
+		}
+	}
+}
+
+// retryAfterTransport retries a request refused with a retry-after header, i.e. a rate limit quota
+// of vault, waiting the time asked for, which the retries of the vault client don't honour
+type retryAfterTransport struct {
+	// the underlying transport
+	transport http.RoundTripper
+}
+
+// RoundTrip performs the request, retrying if asked to
+func (r *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := r.transport.RoundTrip(req)
+		if err != nil || attempt >= retryAfterAttempts {
+			return resp, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+			return resp, err
+		}
+		wait, found := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !found || wait > retryAfterMaximum {
+			return resp, err
+		}
+		// step: the body must be sent again, which isn't possible for a request which can't be replayed
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+		resp.Body.Close()
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		glog.V(3).Infof("vault asked for the request: %s to be retried in: %s, status: %d", req.URL.Path, wait, resp.StatusCode)
+		metrics.add(metricRetryAfter, 1, "status", strconv.Itoa(resp.StatusCode))
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// parseRetryAfter parses the retry-after header, either a number of seconds or a date
+//
+//	value		: the value of the header
+//	now			: the current time
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+
+	return 0, true
+}
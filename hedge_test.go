@@ -0,0 +1,163 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// newHedgeTestServer creates a vault answering reads with the value after the delay
+func newHedgeTestServer(t *testing.T, value string, status int, delay time.Duration) (*api.Client, *int32, func()) {
+	hits := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(hits, 1)
+		time.Sleep(delay)
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{"data":{"value":"` + value + `"}}`))
+			return
+		}
+		w.Write([]byte(`{"errors":["failed"]}`))
+	}))
+	client, err := newAPIClient(&options, server.URL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	client.SetToken(mockToken)
+
+	return client, hits, server.Close
+}
+
+func TestLatencyWindow(t *testing.T) {
+	latency := newLatencyWindow()
+	assert.Equal(t, hedgeDefaultDelay, latency.delay())
+	for i := 1; i <= hedgeLatencySamples*2; i++ {
+		latency.observe(time.Duration(i%hedgeLatencySamples+1) * time.Millisecond * 10)
+	}
+	assert.Equal(t, hedgeLatencySamples, len(latency.samples))
+	assert.Equal(t, 960*time.Millisecond, latency.delay())
+
+	fast := newLatencyWindow()
+	for i := 0; i < hedgeMinimumSamples; i++ {
+		fast.observe(time.Millisecond)
+	}
+	assert.Equal(t, hedgeMinimumDelay, fast.delay())
+}
+
+func TestHedgedRead(t *testing.T) {
+	latency := newLatencyWindow()
+	for i := 0; i < hedgeMinimumSamples; i++ {
+		latency.observe(time.Millisecond)
+	}
+	slow, slowHits, closeSlow := newHedgeTestServer(t, "slow", http.StatusOK, 500*time.Millisecond)
+	defer closeSlow()
+	fast, fastHits, closeFast := newHedgeTestServer(t, "fast", http.StatusOK, 0)
+	defer closeFast()
+	failing, _, closeFailing := newHedgeTestServer(t, "", http.StatusBadRequest, 0)
+	defer closeFailing()
+
+	// step: a slow read is hedged and the hedge answers first
+	secret, err := hedgedRead(slow, fast, latency, "secret/app")
+	if assert.NoError(t, err) && assert.NotNil(t, secret) {
+		assert.Equal(t, "fast", secret.Data["value"])
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(slowHits))
+	assert.Equal(t, int32(1), atomic.LoadInt32(fastHits))
+
+	// step: a fast read isn't hedged
+	secret, err = hedgedRead(fast, slow, newLatencyWindow(), "secret/app")
+	if assert.NoError(t, err) && assert.NotNil(t, secret) {
+		assert.Equal(t, "fast", secret.Data["value"])
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(slowHits))
+
+	// step: a failed read is hedged straight away
+	secret, err = hedgedRead(failing, fast, newLatencyWindow(), "secret/app")
+	if assert.NoError(t, err) && assert.NotNil(t, secret) {
+		assert.Equal(t, "fast", secret.Data["value"])
+	}
+	_, err = hedgedRead(failing, failing, newLatencyWindow(), "secret/app")
+	assert.Error(t, err)
+}
+
+func TestRetryAfterTransport(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch atomic.AddInt32(&hits, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`{"data":{"value":"ok"}}`))
+		}
+	}))
+	defer server.Close()
+	client, err := newAPIClient(&options, server.URL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	secret, err := client.Logical().Read("secret/app")
+	if assert.NoError(t, err) && assert.NotNil(t, secret) {
+		assert.Equal(t, "ok", secret.Data["value"])
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+	// step: a wait beyond the maximum isn't honoured
+	hits = 0
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer limited.Close()
+	transport := &retryAfterTransport{transport: http.DefaultTransport}
+	req, _ := http.NewRequest(http.MethodGet, limited.URL, nil)
+	resp, err := transport.RoundTrip(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		Value    string
+		Expected time.Duration
+		Found    bool
+	}{
+		{Value: "", Found: false},
+		{Value: "5", Expected: 5 * time.Second, Found: true},
+		{Value: "-1", Found: false},
+		{Value: "soon", Found: false},
+		{Value: "Thu, 01 Jan 2026 12:00:30 GMT", Expected: 30 * time.Second, Found: true},
+		{Value: "Thu, 01 Jan 2026 11:00:00 GMT", Expected: 0, Found: true},
+	}
+	for i, c := range cases {
+		wait, found := parseRetryAfter(c.Value, now)
+		assert.Equal(t, c.Found, found, "case %d", i)
+		assert.Equal(t, c.Expected, wait, "case %d", i)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// hookEnvPrefix is the prefix of the environment variables passed to the exec hook
+	hookEnvPrefix = "VAULT_SIDEKICK_"
+	// hookPreviousPrefix is the prefix of the values of the previous update
+	hookPreviousPrefix = "VAULT_SIDEKICK_PREVIOUS_"
+)
+
+// hookContext builds the context of an update passed to the exec hook, so the hook can make rotation
+// decisions without parsing the files
+//	rn			: the resource
+//	filename	: the filename of the resource
+//	data		: the secret data
+//	meta		: the metadata of the secret
+func hookContext(rn *VaultResource, filename string, data map[string]interface{}, meta *secretMetadata) map[string]string {
+	context := map[string]string{
+		"RESOURCE":      rn.ID(),
+		"RESOURCE_TYPE": rn.resource,
+		"RESOURCE_PATH": rn.path,
+		"FILE":          filename,
+		"FORMAT":        rn.format,
+	}
+	if meta != nil {
+		context["LEASE_ID"] = meta.LeaseID
+		if meta.Expires != nil {
+			context["LEASE_EXPIRES"] = meta.Expires.UTC().Format(time.RFC3339)
+		}
+		if meta.Version > 0 {
+			context["VERSION"] = fmt.Sprintf("%d", meta.Version)
+		}
+	}
+	// step: add the details of the certificate if any
+	if content, found := data["certificate"].(string); found {
+		if cert, err := parseCertificate(content); err == nil {
+			context["CERT_SERIAL"] = formatFingerprint(cert.SerialNumber.Bytes())
+			sum := sha256.Sum256(cert.Raw)
+			context["CERT_FINGERPRINT"] = formatFingerprint(sum[:])
+			context["CERT_SUBJECT"] = cert.Subject.CommonName
+			context["CERT_NOT_AFTER"] = cert.NotAfter.UTC().Format(time.RFC3339)
+		}
+	}
+	if serial, found := data["serial_number"]; found {
+		context["CERT_SERIAL"] = fmt.Sprintf("%v", serial)
+	}
+
+	return context
+}
+
+// hookEnvironment returns the environment of the exec hook, the environment of the sidekick with the context of
+// the update and the context of the previous update; CHANGED is true when the certificate or lease differs
+//	current		: the context of the update
+//	previous	: the context of the previous update, nil on the first
+func hookEnvironment(current, previous map[string]string) []string {
+	env := appendHookValues(os.Environ(), hookEnvPrefix, current)
+	env = appendHookValues(env, hookPreviousPrefix, previous)
+
+	changed := previous == nil
+	for _, k := range []string{"CERT_FINGERPRINT", "LEASE_ID", "VERSION"} {
+		if previous != nil && current[k] != previous[k] {
+			changed = true
+		}
+	}
+
+	return append(env, fmt.Sprintf("%sCHANGED=%t", hookEnvPrefix, changed))
+}
+
+// appendHookValues appends the values to the environment of a hook, sorted by name
+//	env			: the environment
+//	prefix		: the prefix of the names
+//	values		: the values to append
+func appendHookValues(env []string, prefix string, values map[string]string) []string {
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, prefix+k+"="+values[k])
+	}
+
+	return env
+}
+
+// runHookCommand runs the command of a hook, killing it if it hasn't finished within the timeout
+//	name		: the command
+//	args		: the arguments of the command
+//	env			: the environment of the command
+//	timeout		: the time the command is allowed to run for
+func runHookCommand(name string, args, env []string, timeout time.Duration) error {
+	cmd := exec.Command(name, args...)
+	cmd.Env = env
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	timer := time.AfterFunc(timeout, func() {
+		if err := cmd.Process.Kill(); err != nil {
+			glog.Errorf("failed to kill the command, pid: %d, error: %s", cmd.Process.Pid, err)
+		}
+	})
+	defer timer.Stop()
+
+	return cmd.Wait()
+}
+
+// formatFingerprint formats the bytes as colon separated upper case hex, as printed by openssl
+func formatFingerprint(b []byte) string {
+	list := make([]string, len(b))
+	for i, x := range b {
+		list[i] = fmt.Sprintf("%02X", x)
+	}
+
+	return strings.Join(list, ":")
+}
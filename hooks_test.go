@@ -0,0 +1,124 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fixtureFingerprint = "63:BF:3D:98:20:64:92:2E:84:D3:20:C2:6E:6E:FC:BF:B1:0A:9A:B1:B2:24:22:F0:B1:D6:A5:F5:43:AC:94:2F"
+
+func TestHookContext(t *testing.T) {
+	rn := defaultVaultResource()
+	rn.resource = "pki"
+	rn.path = "pki/issue/example"
+	rn.format = "bundle"
+	rn.filename = "tls"
+
+	context := hookContext(rn, "/etc/secrets/tls", readCertificateFixture(t), &secretMetadata{LeaseID: "pki/issue/example/1"})
+	assert.Equal(t, "pki:pki/issue/example:tls", context["RESOURCE"])
+	assert.Equal(t, "/etc/secrets/tls", context["FILE"])
+	assert.Equal(t, "pki/issue/example/1", context["LEASE_ID"])
+	assert.Equal(t, "66:f2:ef:75:55:cc:17:1f:cd:45:1b:3f:93:a3:22:a9:9d:04:30:96", context["CERT_SERIAL"])
+	assert.Equal(t, fixtureFingerprint, context["CERT_FINGERPRINT"])
+	assert.Equal(t, "app.example.com", context["CERT_SUBJECT"])
+	assert.Equal(t, "2126-09-22T14:08:55Z", context["CERT_NOT_AFTER"])
+
+	context = hookContext(rn, "/etc/secrets/db", map[string]interface{}{"password": "s3cr3t"}, nil)
+	assert.Equal(t, "", context["CERT_FINGERPRINT"])
+	assert.Equal(t, "", context["LEASE_ID"])
+}
+
+func TestHookEnvironment(t *testing.T) {
+	lookup := func(env []string, name string) string {
+		for _, x := range env {
+			if strings.HasPrefix(x, name+"=") {
+				return strings.TrimPrefix(x, name+"=")
+			}
+		}
+		return ""
+	}
+	current := map[string]string{"FILE": "tls", "CERT_FINGERPRINT": "AA"}
+
+	env := hookEnvironment(current, nil)
+	assert.Equal(t, "tls", lookup(env, "VAULT_SIDEKICK_FILE"))
+	assert.Equal(t, "true", lookup(env, "VAULT_SIDEKICK_CHANGED"))
+	assert.Equal(t, "", lookup(env, "VAULT_SIDEKICK_PREVIOUS_CERT_FINGERPRINT"))
+
+	env = hookEnvironment(current, map[string]string{"FILE": "tls", "CERT_FINGERPRINT": "AA"})
+	assert.Equal(t, "false", lookup(env, "VAULT_SIDEKICK_CHANGED"))
+
+	env = hookEnvironment(current, map[string]string{"FILE": "tls", "CERT_FINGERPRINT": "BB"})
+	assert.Equal(t, "true", lookup(env, "VAULT_SIDEKICK_CHANGED"))
+	assert.Equal(t, "BB", lookup(env, "VAULT_SIDEKICK_PREVIOUS_CERT_FINGERPRINT"))
+}
+
+func TestProcessResourceHookEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "hooks")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "hook.sh")
+	assert.NoError(t, ioutil.WriteFile(script, []byte("#!/bin/sh\nenv | grep ^VAULT_SIDEKICK_ | sort > \"$1.env\"\n"), 0755))
+
+	rn := defaultVaultResource()
+	rn.resource = "pki"
+	rn.path = "pki/issue/example"
+	rn.format = "cert"
+	rn.filename = filepath.Join(dir, "tls")
+	rn.execPath = script
+	if !assert.NoError(t, processResource(rn, readCertificateFixture(t), &secretMetadata{})) {
+		t.FailNow()
+	}
+	content, err := ioutil.ReadFile(rn.filename + ".env")
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(content), "VAULT_SIDEKICK_CERT_FINGERPRINT="+fixtureFingerprint)
+		assert.Contains(t, string(content), "VAULT_SIDEKICK_CHANGED=true")
+		assert.NotContains(t, string(content), "VAULT_SIDEKICK_PREVIOUS_")
+	}
+
+	// step: the same certificate again leaves the files as they were, so the hook isn't run
+	assert.NoError(t, os.Remove(rn.filename+".env"))
+	if !assert.NoError(t, processResource(rn, readCertificateFixture(t), &secretMetadata{})) {
+		t.FailNow()
+	}
+	_, err = os.Stat(rn.filename + ".env")
+	assert.True(t, os.IsNotExist(err))
+
+	// step: a changed file runs the hook again
+	assert.NoError(t, ioutil.WriteFile(rn.filename+".crt", []byte("tampered"), 0600))
+	if !assert.NoError(t, processResource(rn, readCertificateFixture(t), &secretMetadata{})) {
+		t.FailNow()
+	}
+	content, err = ioutil.ReadFile(rn.filename + ".env")
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(content), "VAULT_SIDEKICK_CHANGED=false")
+		assert.Contains(t, string(content), "VAULT_SIDEKICK_PREVIOUS_CERT_FINGERPRINT="+fixtureFingerprint)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// encodeINI encodes the secret as an ini file, the keys sorted so the content only changes with the secret,
+// the keys of the section given first and nested maps written as sections of their own, named by the path of keys
+//
+//	data		: the secret
+//	section		: the section holding the top level keys, none if empty
+func encodeINI(data map[string]interface{}, section string) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeINISection(&b, data, section); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// writeINISection writes the keys of the section followed by the sections nested within it
+func writeINISection(b *bytes.Buffer, data map[string]interface{}, section string) error {
+	var keys, nested []string
+	for key, value := range data {
+		if _, found := value.(map[string]interface{}); found {
+			nested = append(nested, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sort.Strings(nested)
+
+	if section != "" && len(keys) > 0 {
+		if err := isValidININame(section); err != nil {
+			return err
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(fmt.Sprintf("[%s]\n", section))
+	}
+	for _, key := range keys {
+		if err := isValidININame(key); err != nil {
+			return err
+		}
+		if value := iniValue(flatValue(data[key])); value != "" {
+			b.WriteString(fmt.Sprintf("%s = %s\n", key, value))
+		} else {
+			b.WriteString(fmt.Sprintf("%s =\n", key))
+		}
+	}
+	for _, key := range nested {
+		name := key
+		if section != "" {
+			name = section + "." + key
+		}
+		if err := writeINISection(b, data[key].(map[string]interface{}), name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isValidININame checks the key or section can be written without changing the meaning of the file
+func isValidININame(name string) error {
+	if name == "" || strings.TrimSpace(name) != name || strings.ContainsAny(name, "=[];#\"\r\n") {
+		return fmt.Errorf("the name: %q can't be written to an ini file", name)
+	}
+
+	return nil
+}
+
+// iniValue quotes the value if it would otherwise be altered when read, i.e. surrounding whitespace is trimmed
+// and a semi-colon or hash starts a comment; within the quotes a backslash, quote or newline is escaped
+func iniValue(value string) string {
+	if strings.TrimSpace(value) == value && !strings.ContainsAny(value, ";#\"\r\n") {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", `\r`, "\n", `\n`)
+
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// flatValue converts a value of the secret to a string, a list or map is encoded as json
+//
+//	value		: the value of the secret
+func flatValue(value interface{}) string {
+	switch x := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	case bool:
+		return strconv.FormatBool(x)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case map[string]interface{}, []interface{}:
+		if content, err := json.Marshal(x); err == nil {
+			return string(content)
+		}
+	}
+
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeINI(t *testing.T) {
+	cases := []struct {
+		Data     map[string]interface{}
+		Section  string
+		Expected string
+	}{
+		{
+			Data:     map[string]interface{}{"username": "app", "password": "s3cr3t", "port": json.Number("5432")},
+			Expected: "password = s3cr3t\nport = 5432\nusername = app\n",
+		},
+		{
+			Data:     map[string]interface{}{"username": "app"},
+			Section:  "database",
+			Expected: "[database]\nusername = app\n",
+		},
+		{
+			Data: map[string]interface{}{
+				"comment": "a;b",
+				"hash":    "#1",
+				"padded":  " x ",
+				"quote":   `say "hi"`,
+				"lines":   "a\nb\\c",
+				"path":    `C:\temp`,
+				"empty":   "",
+				"list":    []interface{}{"a", json.Number("1")},
+				"enabled": true,
+			},
+			Expected: "comment = \"a;b\"\nempty =\nenabled = true\nhash = \"#1\"\nlines = \"a\\nb\\\\c\"\n" +
+				"list = \"[\\\"a\\\",1]\"\npadded = \" x \"\npath = C:\\temp\nquote = \"say \\\"hi\\\"\"\n",
+		},
+		{
+			Data: map[string]interface{}{
+				"name":  "app",
+				"db":    map[string]interface{}{"user": "app", "tls": map[string]interface{}{"ca": "ca.pem"}},
+				"cache": map[string]interface{}{"host": "redis"},
+			},
+			Section:  "app",
+			Expected: "[app]\nname = app\n\n[app.cache]\nhost = redis\n\n[app.db]\nuser = app\n\n[app.db.tls]\nca = ca.pem\n",
+		},
+		{
+			Data:     map[string]interface{}{"db": map[string]interface{}{"user": "app"}},
+			Expected: "[db]\nuser = app\n",
+		},
+	}
+	for i, c := range cases {
+		content, err := encodeINI(c.Data, c.Section)
+		if !assert.NoError(t, err, "case %d, should not have failed", i) {
+			continue
+		}
+		assert.Equal(t, c.Expected, string(content), "case %d, unexpected content", i)
+	}
+
+	for _, key := range []string{"a=b", "[a]", " a", "a;b", "a\nb"} {
+		_, err := encodeINI(map[string]interface{}{key: "x"}, "")
+		assert.Error(t, err, "key: %q should have failed", key)
+	}
+}
@@ -0,0 +1,343 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// injectVolume is the name of the volume the secrets are written to
+	injectVolume = "vault-sidekick-secrets"
+	// injectContainer is the name of the sidecar container
+	injectContainer = "vault-sidekick"
+	// injectInitContainer is the name of the init container
+	injectInitContainer = "vault-sidekick-init"
+	// injectedAnnotation marks a pod the sidekick has been injected into
+	injectedAnnotation = "vault.sidekick/injected"
+	// injectAnnotation opts a pod out of the injection when false
+	injectAnnotation = "vault.sidekick/inject"
+	// injectMountPath is the default path the secrets are mounted at
+	injectMountPath = "/etc/secrets"
+)
+
+// injectDocumentRegex splits a stream of yaml documents
+var injectDocumentRegex = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// injectRules are the rules deciding which pods the sidekick is injected into and how
+type injectRules struct {
+	// the image of the sidekick
+	Image string `yaml:"image"`
+	// the address of vault, passed as VAULT_ADDR
+	VaultAddr string `yaml:"vaultAddr"`
+	// the path the secrets are mounted at, defaults to /etc/secrets
+	MountPath string `yaml:"mountPath"`
+	// the rules, the first matching a pod is applied
+	Rules []injectRule `yaml:"rules"`
+}
+
+// injectRule is the sidekick injected into the pods matching the selector
+type injectRule struct {
+	// the name of the rule
+	Name string `yaml:"name"`
+	// the labels a pod must have to match the rule, every pod if empty
+	Selector map[string]string `yaml:"selector"`
+	// the resources retrieved by the sidekick
+	Resources []string `yaml:"resources"`
+	// any additional arguments of the sidekick
+	Args []string `yaml:"args"`
+	// whether to add an init container retrieving the resources before the pod starts
+	Init bool `yaml:"init"`
+	// the containers the secrets are mounted into, all if empty
+	Containers []string `yaml:"containers"`
+	// the path the secrets are mounted at, overriding the default
+	MountPath string `yaml:"mountPath"`
+}
+
+// matches checks if the labels of the pod satisfy the selector of the rule
+func (r injectRule) matches(labels map[string]string) bool {
+	for key, value := range r.Selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readInjectRules reads and validates the rules file
+//	filename	: the path to the rules file
+func readInjectRules(filename string) (*injectRules, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	rules := &injectRules{}
+	if err := yaml.Unmarshal(content, rules); err != nil {
+		return nil, fmt.Errorf("unable to parse the rules file: %s, error: %s", filename, err)
+	}
+	if rules.Image == "" {
+		return nil, fmt.Errorf("the rules file must specify the image of the sidekick")
+	}
+	if rules.MountPath == "" {
+		rules.MountPath = injectMountPath
+	}
+	if len(rules.Rules) == 0 {
+		return nil, fmt.Errorf("the rules file has no rules")
+	}
+	var resources VaultResources
+	for i, rule := range rules.Rules {
+		if len(rule.Resources) == 0 {
+			return nil, fmt.Errorf("the rule: %d (%s) has no resources", i, rule.Name)
+		}
+		// step: catch a mistake in the resources here, rather than when the pod starts
+		for j, x := range rule.Resources {
+			resources.collect(fmt.Sprintf("rule: %d (%s) resource: %d", i, rule.Name, j), x)
+		}
+	}
+	if err := resources.validate(nil); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// injectManifests injects the sidekick into the pods of the yaml documents, the documents which aren't a pod
+// or a workload with a pod template, or match none of the rules, are returned as they are
+//	input		: the yaml documents
+//	rules		: the injection rules
+func injectManifests(input []byte, rules *injectRules) ([]byte, error) {
+	var documents []string
+	for _, x := range injectDocumentRegex.Split(string(input), -1) {
+		if strings.TrimSpace(x) != "" {
+			documents = append(documents, x)
+		}
+	}
+
+	var b bytes.Buffer
+	for i, x := range documents {
+		var doc yaml.MapSlice
+		if err := yaml.Unmarshal([]byte(x), &doc); err != nil {
+			return nil, fmt.Errorf("unable to parse the document: %d, error: %s", i, err)
+		}
+		doc = injectDocument(doc, rules)
+		content, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			b.WriteString("---\n")
+		}
+		b.Write(content)
+	}
+
+	return b.Bytes(), nil
+}
+
+// injectDocument injects the sidekick into the pod template of the document
+func injectDocument(doc yaml.MapSlice, rules *injectRules) yaml.MapSlice {
+	kind, _ := yamlGet(doc, "kind").(string)
+	switch kind {
+	case "Pod":
+		return injectTemplate(doc, rules)
+	case "CronJob":
+		spec, _ := yamlGet(doc, "spec").(yaml.MapSlice)
+		job, _ := yamlGet(spec, "jobTemplate").(yaml.MapSlice)
+		jobSpec, _ := yamlGet(job, "spec").(yaml.MapSlice)
+		template, found := yamlGet(jobSpec, "template").(yaml.MapSlice)
+		if !found {
+			return doc
+		}
+		jobSpec = yamlSet(jobSpec, "template", injectTemplate(template, rules))
+		job = yamlSet(job, "spec", jobSpec)
+		return yamlSet(doc, "spec", yamlSet(spec, "jobTemplate", job))
+	}
+	spec, _ := yamlGet(doc, "spec").(yaml.MapSlice)
+	template, found := yamlGet(spec, "template").(yaml.MapSlice)
+	if !found {
+		return doc
+	}
+
+	return yamlSet(doc, "spec", yamlSet(spec, "template", injectTemplate(template, rules)))
+}
+
+// injectTemplate injects the sidekick into the pod or pod template, if it matches a rule
+func injectTemplate(template yaml.MapSlice, rules *injectRules) yaml.MapSlice {
+	metadata, _ := yamlGet(template, "metadata").(yaml.MapSlice)
+	annotations, _ := yamlGet(metadata, "annotations").(yaml.MapSlice)
+	if value, _ := yamlGet(annotations, injectedAnnotation).(string); value == "true" {
+		return template
+	}
+	if value, _ := yamlGet(annotations, injectAnnotation).(string); value == "false" {
+		return template
+	}
+	labels := make(map[string]string, 0)
+	if list, found := yamlGet(metadata, "labels").(yaml.MapSlice); found {
+		for _, x := range list {
+			labels[fmt.Sprintf("%v", x.Key)] = fmt.Sprintf("%v", x.Value)
+		}
+	}
+	var rule *injectRule
+	for i := range rules.Rules {
+		if rules.Rules[i].matches(labels) {
+			rule = &rules.Rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		return template
+	}
+	mountPath := rules.MountPath
+	if rule.MountPath != "" {
+		mountPath = rule.MountPath
+	}
+
+	// step: mount the secrets into the containers of the pod
+	spec, _ := yamlGet(template, "spec").(yaml.MapSlice)
+	containers, _ := yamlGet(spec, "containers").([]interface{})
+	for i, x := range containers {
+		container, ok := x.(yaml.MapSlice)
+		if !ok {
+			continue
+		}
+		name, _ := yamlGet(container, "name").(string)
+		if len(rule.Containers) > 0 && !containsName(rule.Containers, name) {
+			continue
+		}
+		mounts, _ := yamlGet(container, "volumeMounts").([]interface{})
+		mounts = append(mounts, yaml.MapSlice{
+			{Key: "name", Value: injectVolume},
+			{Key: "mountPath", Value: mountPath},
+			{Key: "readOnly", Value: true},
+		})
+		containers[i] = yamlSet(container, "volumeMounts", mounts)
+	}
+
+	// step: add the sidekick and optionally the init container
+	args := []string{"-output=" + mountPath}
+	for _, x := range rule.Resources {
+		args = append(args, "-cn="+x)
+	}
+	args = append(args, rule.Args...)
+	containers = append(containers, injectSidekick(injectContainer, rules, args, mountPath))
+	spec = yamlSet(spec, "containers", containers)
+	if rule.Init {
+		initContainers, _ := yamlGet(spec, "initContainers").([]interface{})
+		initContainers = append(initContainers, injectSidekick(injectInitContainer, rules, append([]string{"-one-shot"}, args...), mountPath))
+		spec = yamlSet(spec, "initContainers", initContainers)
+	}
+	volumes, _ := yamlGet(spec, "volumes").([]interface{})
+	volumes = append(volumes, yaml.MapSlice{
+		{Key: "name", Value: injectVolume},
+		{Key: "emptyDir", Value: yaml.MapSlice{{Key: "medium", Value: "Memory"}}},
+	})
+	spec = yamlSet(spec, "volumes", volumes)
+
+	// step: mark the pod as injected, so running the injection again changes nothing
+	annotations = yamlSet(annotations, injectedAnnotation, "true")
+	metadata = yamlSet(metadata, "annotations", annotations)
+	template = yamlSet(template, "metadata", metadata)
+
+	return yamlSet(template, "spec", spec)
+}
+
+// injectSidekick returns the container of the sidekick
+func injectSidekick(name string, rules *injectRules, args []string, mountPath string) yaml.MapSlice {
+	var list []interface{}
+	for _, x := range args {
+		list = append(list, x)
+	}
+	container := yaml.MapSlice{
+		{Key: "name", Value: name},
+		{Key: "image", Value: rules.Image},
+		{Key: "args", Value: list},
+	}
+	if rules.VaultAddr != "" {
+		container = append(container, yaml.MapItem{Key: "env", Value: []interface{}{
+			yaml.MapSlice{{Key: "name", Value: "VAULT_ADDR"}, {Key: "value", Value: rules.VaultAddr}},
+		}})
+	}
+
+	return append(container, yaml.MapItem{Key: "volumeMounts", Value: []interface{}{
+		yaml.MapSlice{{Key: "name", Value: injectVolume}, {Key: "mountPath", Value: mountPath}},
+	}})
+}
+
+// yamlGet returns the value of the key in the map, nil if not found
+func yamlGet(m yaml.MapSlice, key string) interface{} {
+	for _, x := range m {
+		if x.Key == key {
+			return x.Value
+		}
+	}
+
+	return nil
+}
+
+// yamlSet sets the value of the key in the map, appending the key if not found
+func yamlSet(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, x := range m {
+		if x.Key == key {
+			m[i].Value = value
+			return m
+		}
+	}
+
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+// runInjectCommand injects the sidekick into the pods read from stdin as per the rules file, writing the
+// result to stdout, returning the exit code
+//	args		: the arguments following the command
+func runInjectCommand(args []string, input io.Reader, output io.Writer) int {
+	flags := flag.NewFlagSet("inject", flag.ContinueOnError)
+	filename := flags.String("rules", getEnv("VAULT_SIDEKICK_INJECT_RULES", ""), "the path to the file of injection rules")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if *filename == "" {
+		fmt.Fprintln(os.Stderr, "[error] the rules file must be given, i.e. vault-sidekick inject -rules=rules.yaml < pod.yaml")
+		return 1
+	}
+	rules, err := readInjectRules(*filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[error] %s\n", err)
+		return 1
+	}
+	content, err := ioutil.ReadAll(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[error] unable to read the manifests, error: %s\n", err)
+		return 1
+	}
+	injected, err := injectManifests(content, rules)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[error] %s\n", err)
+		return 1
+	}
+	output.Write(injected)
+
+	return 0
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func testInjectRules() *injectRules {
+	return &injectRules{
+		Image:     "vault-sidekick:latest",
+		MountPath: injectMountPath,
+		Rules: []injectRule{
+			{
+				Name:       "web",
+				Selector:   map[string]string{"app": "web"},
+				Resources:  []string{"secret:secret/web:fmt=env"},
+				Containers: []string{"web"},
+				Init:       true,
+			},
+		},
+	}
+}
+
+func TestInjectManifests(t *testing.T) {
+	input := `kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: web
+        image: nginx
+      - name: proxy
+        image: envoy
+---
+kind: Pod
+metadata:
+  labels:
+    app: api
+spec:
+  containers:
+  - name: api
+`
+	output, err := injectManifests([]byte(input), testInjectRules())
+	if !assert.NoError(t, err) {
+		return
+	}
+	var doc yaml.MapSlice
+	if !assert.NoError(t, yaml.Unmarshal(output, &doc)) {
+		return
+	}
+	template := yamlGet(yamlGet(doc, "spec").(yaml.MapSlice), "template").(yaml.MapSlice)
+	metadata := yamlGet(template, "metadata").(yaml.MapSlice)
+	assert.Equal(t, "true", yamlGet(yamlGet(metadata, "annotations").(yaml.MapSlice), injectedAnnotation))
+	spec := yamlGet(template, "spec").(yaml.MapSlice)
+	containers := yamlGet(spec, "containers").([]interface{})
+	if assert.Len(t, containers, 3) {
+		assert.NotNil(t, yamlGet(containers[0].(yaml.MapSlice), "volumeMounts"))
+		assert.Nil(t, yamlGet(containers[1].(yaml.MapSlice), "volumeMounts"))
+		sidekick := containers[2].(yaml.MapSlice)
+		assert.Equal(t, injectContainer, yamlGet(sidekick, "name"))
+		assert.Equal(t, []interface{}{"-output=/etc/secrets", "-cn=secret:secret/web:fmt=env"}, yamlGet(sidekick, "args"))
+	}
+	assert.Len(t, yamlGet(spec, "initContainers"), 1)
+	assert.Len(t, yamlGet(spec, "volumes"), 1)
+
+	// the pod matching no rule is left as it is
+	assert.Contains(t, string(output), "---\nkind: Pod\nmetadata:\n  labels:\n    app: api\nspec:\n  containers:\n  - name: api\n")
+
+	// injecting again changes nothing
+	again, err := injectManifests(output, testInjectRules())
+	assert.NoError(t, err)
+	assert.Equal(t, string(output), string(again))
+}
+
+func TestInjectOptOut(t *testing.T) {
+	input := `kind: Pod
+metadata:
+  labels:
+    app: web
+  annotations:
+    vault.sidekick/inject: "false"
+spec:
+  containers:
+  - name: web
+`
+	output, err := injectManifests([]byte(input), testInjectRules())
+	assert.NoError(t, err)
+	assert.Equal(t, input, string(output))
+}
+
+func TestInjectCronJob(t *testing.T) {
+	input := `kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        metadata:
+          labels:
+            app: web
+        spec:
+          containers:
+          - name: web
+`
+	output, err := injectManifests([]byte(input), testInjectRules())
+	assert.NoError(t, err)
+	assert.Contains(t, string(output), "name: "+injectContainer)
+}
+
+func TestReadInjectRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inject")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	cs := []struct {
+		Content string
+		Ok      bool
+	}{
+		{Content: "image: sidekick\nrules:\n- resources: [\"secret:secret/web\"]\n", Ok: true},
+		{Content: "rules:\n- resources: [\"secret:secret/web\"]\n"},
+		{Content: "image: sidekick\n"},
+		{Content: "image: sidekick\nrules:\n- name: web\n"},
+		{Content: "image: sidekick\nrules:\n- resources: [\"nothing:secret/web\"]\n"},
+		{Content: "image: sidekick\nrules:\n- resources: [\"secret:secret/web:fmt=nothing\"]\n"},
+	}
+	filename := filepath.Join(dir, "rules.yaml")
+	for i, c := range cs {
+		assert.NoError(t, ioutil.WriteFile(filename, []byte(c.Content), 0600))
+		rules, err := readInjectRules(filename)
+		if c.Ok {
+			if assert.NoError(t, err, "case %d", i) {
+				assert.Equal(t, injectMountPath, rules.MountPath)
+			}
+			continue
+		}
+		assert.Error(t, err, "case %d", i)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// keystorePasswordKey is the key the keystore password is added to the data of a certificate under
+	keystorePasswordKey = "keystore_password"
+	// keystorePasswordEnv is the environment variable the password is handed to openssl and keytool in
+	keystorePasswordEnv = "VAULT_SIDEKICK_KEYSTORE_PASSWORD"
+)
+
+// keystoreCheckInterval is the interval the password of a keystore is checked for a rotation
+var keystoreCheckInterval = time.Duration(1) * time.Minute
+
+// getKeystore issues a certificate for a keystore along with the current password of the keystore; the
+// certificate is only reissued when it's due or the password has been rotated, so a rotation of either
+// regenerates the keystore
+//	rn			: the watched resource
+//	params		: the parameters of the certificate
+func (r VaultService) getKeystore(rn *watchedResource, params map[string]interface{}) (*api.Secret, error) {
+	password, err := r.readKeystorePassword(rn.resource.keystorePassword)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(password))
+	digest := hex.EncodeToString(sum[:])
+
+	if rn.secret != nil && digest == rn.keystoreDigest && time.Now().Before(rn.reissueTime) {
+		glog.V(4).Infof("resource: %s, the certificate and keystore password are unchanged", rn.resource)
+		return nil, errSecretUnchanged
+	}
+	if rn.keystoreDigest != "" && digest != rn.keystoreDigest {
+		glog.Infof("resource: %s, the keystore password has been rotated, regenerating the keystore", rn.resource)
+	}
+
+	var secret *api.Secret
+	if rn.resource.keySource != "" {
+		secret, err = r.signCSR(rn, params)
+	} else {
+		r.clampPKITTL(rn, params)
+		secret, err = r.client.Logical().Write(rn.resource.path, params)
+	}
+	if err != nil || secret == nil {
+		return secret, err
+	}
+	secret.Data[keystorePasswordKey] = password
+	rn.keystoreDigest = digest
+
+	// step: work out when the certificate is due, as the resource is checked more often for the password
+	renewal := rn.resource.update
+	if renewal <= 0 {
+		renewal = getDurationWithin(
+			int(float64(secret.LeaseDuration)*renewalMinimum),
+			int(float64(secret.LeaseDuration)*renewalMaximum))
+	}
+	rn.reissueTime = time.Now().Add(renewal)
+
+	return secret, nil
+}
+
+// readKeystorePassword reads the password of a keystore from the password key of a secret, bypassing
+// the cache so a rotation is seen
+//	path		: the path of the secret
+func (r VaultService) readKeystorePassword(path string) (string, error) {
+	secret, err := r.client.Logical().Read(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the keystore password: %s, error: %s", path, err)
+	}
+	if secret == nil {
+		return "", fmt.Errorf("the keystore password: %s does not exist", path)
+	}
+	data := secret.Data
+	// step: a version 2 kv backend nests the values under data
+	if nested, found := data["data"].(map[string]interface{}); found {
+		data = nested
+	}
+	password, found := data["password"]
+	if !found || fmt.Sprintf("%v", password) == "" {
+		return "", fmt.Errorf("the secret: %s has no password key", path)
+	}
+
+	return fmt.Sprintf("%v", password), nil
+}
+
+// writeKeystoreFile writes the certificate, private key and ca chain as a pkcs12 or java keystore, protected by
+// the password sourced from vault, which is written alongside to FILE.password readable only by the owner
+//	filename	: the filename to write to
+//	data		: the certificate data
+//	rn			: the resource
+func writeKeystoreFile(filename string, data map[string]interface{}, rn *VaultResource) error {
+	password, found := data[keystorePasswordKey]
+	if !found {
+		return fmt.Errorf("the resource has no keystore password")
+	}
+	alias := rn.options["common_name"]
+	if alias == "" {
+		alias = "vault-sidekick"
+	}
+
+	content, err := buildPKCS12(data, fmt.Sprintf("%s", password), alias)
+	if err != nil {
+		return err
+	}
+	defer zeroBytes(content)
+	if rn.format == "jks" {
+		p12 := content
+		if content, err = convertToJKS(p12, fmt.Sprintf("%s", password)); err != nil {
+			return err
+		}
+		defer zeroBytes(content)
+	}
+	if err := writeResourceContent(rn, filename, content); err != nil {
+		return err
+	}
+
+	return writeFile(filename+".password", []byte(fmt.Sprintf("%s", password)), 0600)
+}
+
+// buildPKCS12 bundles the private key, certificate and ca chain into a pkcs12 keystore with openssl
+//	data		: the certificate data
+//	password	: the password of the keystore
+//	alias		: the friendly name of the entry
+func buildPKCS12(data map[string]interface{}, password, alias string) ([]byte, error) {
+	var input bytes.Buffer
+	for _, key := range []string{"private_key", "certificate", "issuing_ca"} {
+		if x, found := data[key]; found {
+			input.WriteString(fmt.Sprintf("%s\n", x))
+		}
+	}
+	if chain, found := data["ca_chain"].([]interface{}); found {
+		for _, x := range chain {
+			input.WriteString(fmt.Sprintf("%s\n", x))
+		}
+	}
+	defer zeroBytes(input.Bytes())
+
+	return runKeystoreCommand(password, &input, "openssl", "pkcs12", "-export", "-name", alias,
+		"-passout", "env:"+keystorePasswordEnv)
+}
+
+// convertToJKS converts a pkcs12 keystore into a java keystore with keytool
+//	p12			: the pkcs12 keystore
+//	password	: the password of the keystore
+func convertToJKS(p12 []byte, password string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "keystore.p12")
+	destination := filepath.Join(dir, "keystore.jks")
+	if err := ioutil.WriteFile(source, p12, 0600); err != nil {
+		return nil, err
+	}
+	if _, err := runKeystoreCommand(password, nil, "keytool", "-importkeystore", "-noprompt",
+		"-srckeystore", source, "-srcstoretype", "PKCS12", "-srcstorepass:env", keystorePasswordEnv,
+		"-destkeystore", destination, "-deststoretype", "JKS", "-deststorepass:env", keystorePasswordEnv,
+		"-destkeypass:env", keystorePasswordEnv); err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadFile(destination)
+}
+
+// runKeystoreCommand runs openssl or keytool, handing the password over in the environment rather than the arguments
+//	password	: the password of the keystore
+//	stdin		: the input of the command, if any
+//	name		: the command
+//	args		: the arguments of the command
+func runKeystoreCommand(password string, stdin *bytes.Buffer, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), keystorePasswordEnv+"="+password)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to run %s, error: %s", name, err)
+	}
+	timer := time.AfterFunc(options.execTimeout, func() {
+		cmd.Process.Kill()
+	})
+	defer timer.Stop()
+
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("%s failed: %s, %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
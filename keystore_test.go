@@ -0,0 +1,153 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteKeystoreFile(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl is not installed")
+	}
+	dir, err := ioutil.TempDir("", "keystore")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "keystore.p12")
+	data := readCertificateFixture(t)
+	data[keystorePasswordKey] = "changeit"
+	rn := defaultVaultResource()
+	rn.resource = "pki"
+	rn.format = "p12"
+	rn.options = map[string]string{"common_name": "app.example.com"}
+
+	if !assert.NoError(t, writeKeystoreFile(filename, data, rn)) {
+		t.FailNow()
+	}
+
+	// step: the keystore opens with the password, which is written alongside
+	cmd := exec.Command("openssl", "pkcs12", "-in", filename, "-nodes", "-passin", "env:"+keystorePasswordEnv)
+	cmd.Env = append(os.Environ(), keystorePasswordEnv+"=changeit")
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(output))
+	assert.Contains(t, string(output), "friendlyName: app.example.com")
+	assert.Contains(t, string(output), "BEGIN CERTIFICATE")
+	assert.Contains(t, string(output), "PRIVATE KEY")
+
+	password, err := ioutil.ReadFile(filename + ".password")
+	assert.NoError(t, err)
+	assert.Equal(t, "changeit", string(password))
+	if stat, err := os.Stat(filename + ".password"); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+	}
+
+	delete(data, keystorePasswordKey)
+	assert.Error(t, writeKeystoreFile(filename, data, rn))
+}
+
+func TestGetKeystore(t *testing.T) {
+	fixture, err := ioutil.ReadFile("tests/fixtures/pki/issue/example.json")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	password := "changeit"
+	issued := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/secret/keystore":
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"password": password}})
+		case strings.HasPrefix(r.URL.Path, "/v1/pki/issue/"):
+			issued++
+			w.Write(fixture)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL, HttpClient: http.DefaultClient})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	service := VaultService{client: client}
+	var resources VaultResources
+	assert.NoError(t, resources.Set("pki:pki/issue/example:common_name=app.example.com,fmt=p12,keystore-password=secret/keystore"))
+	assert.NoError(t, resources.items[0].IsValid())
+	rn := &watchedResource{resource: resources.items[0]}
+
+	// step: the certificate is issued with the password
+	secret, err := service.getKeystore(rn, map[string]interface{}{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "changeit", secret.Data[keystorePasswordKey])
+	}
+	rn.secret = secret
+	assert.Equal(t, 1, issued)
+
+	// step: nothing has changed, so the certificate isn't reissued
+	_, err = service.getKeystore(rn, map[string]interface{}{})
+	assert.Equal(t, errSecretUnchanged, err)
+	assert.Equal(t, 1, issued)
+
+	// step: a rotation of the password regenerates the keystore
+	password = "rotated"
+	secret, err = service.getKeystore(rn, map[string]interface{}{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "rotated", secret.Data[keystorePasswordKey])
+	}
+	assert.Equal(t, 2, issued)
+
+	// step: as does the certificate falling due
+	rn.reissueTime = time.Now().Add(-time.Second)
+	_, err = service.getKeystore(rn, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, issued)
+}
+
+func TestKeystoreOptions(t *testing.T) {
+	cases := []struct {
+		Resource string
+		Ok       bool
+	}{
+		{Resource: "pki:pki/issue/example:common_name=app,fmt=jks,keystore-password=secret/keystore", Ok: true},
+		{Resource: "pki:pki/issue/example:common_name=app,fmt=p12"},
+		{Resource: "secret:secret/app:fmt=p12,keystore-password=secret/keystore"},
+		{Resource: "pki:pki/issue/example:common_name=app,fmt=bundle,keystore-password=secret/keystore"},
+	}
+	for i, c := range cases {
+		var resources VaultResources
+		if !assert.NoError(t, resources.Set(c.Resource), "case %d", i) {
+			continue
+		}
+		err := resources.items[0].IsValid()
+		assert.Equal(t, c.Ok, err == nil, "case %d, error: %v", i, err)
+	}
+}
@@ -0,0 +1,296 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+// errSecretUnchanged indicates the version of a kv secret has not changed since the last retrieval
+var errSecretUnchanged = errors.New("the secret has not changed")
+
+// kvDeletedError indicates the current version of a kv v2 secret has been deleted or destroyed upstream
+type kvDeletedError struct {
+	// the current version of the secret
+	version int
+	// whether the version was destroyed rather than deleted
+	destroyed bool
+}
+
+// state returns deleted or destroyed
+func (e *kvDeletedError) state() string {
+	if e.destroyed {
+		return "destroyed"
+	}
+
+	return "deleted"
+}
+
+// Error returns the description of the error
+func (e *kvDeletedError) Error() string {
+	return fmt.Sprintf("the version: %d of the secret has been %s", e.version, e.state())
+}
+
+// getKV retrieves a secret from a version 2 kv backend; when we already hold a version, the
+// lightweight metadata endpoint is checked first and the data only read on a new version
+//	rn			: the watched resource
+func (r VaultService) getKV(rn *watchedResource) (*api.Secret, error) {
+	dataPath, metadataPath, err := kvPaths(rn.resource.path)
+	if err != nil {
+		return nil, err
+	}
+
+	// step: check the current version against the one we hold
+	if rn.version > 0 && rn.secret != nil {
+		metadata, err := r.reader(rn).Logical().Read(metadataPath)
+		if err != nil {
+			return nil, err
+		}
+		if deleted := kvDeletion(metadata, time.Now()); deleted != nil {
+			return nil, deleted
+		}
+		if metadata != nil && toInt(metadata.Data["current_version"]) == rn.version {
+			glog.V(4).Infof("resource: %s is still at version: %d, skipping the read", rn.resource, rn.version)
+			return nil, errSecretUnchanged
+		}
+	}
+
+	secret, version, err := r.readKV(rn, dataPath)
+	if err != nil {
+		return nil, err
+	}
+	if rn.resource.create {
+		if secret, version, err = r.createKV(rn, dataPath, secret, version); err != nil {
+			return nil, err
+		}
+	}
+	if secret == nil {
+		// step: a deleted version reads as missing, the metadata tells the two apart
+		if metadata, err := r.reader(rn).Logical().Read(metadataPath); err == nil {
+			if deleted := kvDeletion(metadata, time.Now()); deleted != nil {
+				return nil, deleted
+			}
+		}
+		return nil, nil
+	}
+	rn.version = version
+
+	return secret, nil
+}
+
+// readKV reads a kv v2 secret, returning the secret unwrapped from the response and the version
+//	rn			: the watched resource
+//	dataPath	: the data path of the secret
+func (r VaultService) readKV(rn *watchedResource, dataPath string) (*api.Secret, int, error) {
+	secret, err := r.read(rn, dataPath)
+	if err != nil || secret == nil {
+		return secret, 0, err
+	}
+
+	// step: unwrap the data, the kv v2 response nests the secret alongside the metadata
+	data, found := secret.Data["data"].(map[string]interface{})
+	if !found {
+		return nil, 0, fmt.Errorf("the secret has no data, the latest version may have been deleted")
+	}
+	version := 0
+	if metadata, found := secret.Data["metadata"].(map[string]interface{}); found {
+		version = toInt(metadata["version"])
+	}
+	unwrapped := *secret
+	unwrapped.Data = data
+
+	return &unwrapped, version, nil
+}
+
+// maxCreateAttempts is the number of attempts at creating a kv v2 secret when losing a race with another writer
+const maxCreateAttempts = 3
+
+// createKV creates a kv v2 secret for the create option, or patches the generated value into a secret missing it, keeping
+// the other keys; the write uses check-and-set against the version read, so a concurrent writer isn't clobbered and the
+// secret is simply read again should another writer get there first
+//	rn			: the watched resource
+//	dataPath	: the data path of the secret
+//	secret		: the secret as read, nil if it doesn't exist
+//	version		: the version of the secret read, zero if it doesn't exist
+func (r VaultService) createKV(rn *watchedResource, dataPath string, secret *api.Secret, version int) (*api.Secret, int, error) {
+	for attempt := 1; attempt <= maxCreateAttempts; attempt++ {
+		if secret != nil && secret.Data["value"] != nil {
+			return secret, version, nil
+		}
+		data := make(map[string]interface{}, 0)
+		if secret != nil {
+			for k, v := range secret.Data {
+				data[k] = v
+			}
+		}
+		data["value"] = newPassword(int(rn.resource.size))
+
+		glog.V(3).Infof("creating the value of resource: %s, check-and-set version: %d", rn.resource, version)
+		_, err := r.client.Logical().Write(dataPath, map[string]interface{}{
+			"options": map[string]interface{}{"cas": version},
+			"data":    data,
+		})
+		if err != nil && !isCheckAndSetMismatch(err) {
+			return nil, 0, err
+		}
+		if err != nil {
+			glog.Infof("resource: %s was written by another writer, reading it again", rn.resource)
+		}
+		r.cache.remove(cacheKey(rn.resource, dataPath))
+		if secret, version, err = r.readKV(rn, dataPath); err != nil {
+			return nil, 0, err
+		}
+	}
+	if secret == nil || secret.Data["value"] == nil {
+		return nil, 0, fmt.Errorf("unable to create the secret after %d attempts, it's being written concurrently", maxCreateAttempts)
+	}
+
+	return secret, version, nil
+}
+
+// kvDeletion checks the metadata of a kv v2 secret for the deletion of its current version, returning nil
+// when the version is live; a deletion time in the future is a pending deletion, not yet applied
+//	metadata	: the metadata of the secret
+//	now			: the current time
+func kvDeletion(metadata *api.Secret, now time.Time) *kvDeletedError {
+	if metadata == nil {
+		return nil
+	}
+	current := toInt(metadata.Data["current_version"])
+	versions, _ := metadata.Data["versions"].(map[string]interface{})
+	version, _ := versions[strconv.Itoa(current)].(map[string]interface{})
+	if version == nil {
+		return nil
+	}
+	if destroyed, _ := version["destroyed"].(bool); destroyed {
+		return &kvDeletedError{version: current, destroyed: true}
+	}
+	deletion, _ := version["deletion_time"].(string)
+	if deletion == "" {
+		return nil
+	}
+	if when, err := time.Parse(time.RFC3339Nano, deletion); err == nil && when.After(now) {
+		return nil
+	}
+
+	return &kvDeletedError{version: current}
+}
+
+// kvDeleted records the deletion of the current version of a kv v2 secret upstream
+func (r VaultService) kvDeleted(rn *watchedResource, err *kvDeletedError) {
+	if rn.deleted != err.state() {
+		glog.Errorf("resource: %s, %s upstream, keeping the last good copy", rn.resource, err)
+	}
+	rn.deleted = err.state()
+	metrics.set(metricKVDeleted, 1, resourceLabels(rn.resource)...)
+	registry.update(rn.resource, func(x *resourceStatus) {
+		x.Deleted = rn.deleted
+	})
+}
+
+// kvRestored records a kv v2 secret previously deleted upstream being readable again
+func (r VaultService) kvRestored(rn *watchedResource) {
+	if rn.deleted == "" {
+		return
+	}
+	glog.Infof("resource: %s is readable again, it was %s upstream", rn.resource, rn.deleted)
+	rn.deleted = ""
+	metrics.set(metricKVDeleted, 0, resourceLabels(rn.resource)...)
+	registry.update(rn.resource, func(x *resourceStatus) {
+		x.Deleted = ""
+	})
+}
+
+// isCheckAndSetMismatch checks if a write was refused as the secret has changed since the version given
+func isCheckAndSetMismatch(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "check-and-set")
+}
+
+// getMerged reads the secrets of a resource listing several paths separated by a comma, merging them into
+// a single secret with the keys of the later secrets taking precedence i.e. secret/app/common,secret/app/prod
+func (r VaultService) getMerged(rn *watchedResource) (*api.Secret, error) {
+	secret := &api.Secret{Data: make(map[string]interface{}, 0)}
+	for _, path := range rn.resource.paths() {
+		var x *api.Secret
+		var err error
+		if rn.resource.kvVersion == 2 {
+			var dataPath string
+			if dataPath, _, err = kvPaths(path); err == nil {
+				x, _, err = r.readKV(rn, dataPath)
+			}
+		} else {
+			x, err = r.read(rn, path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the secret: %s, error: %s", path, err)
+		}
+		if x == nil {
+			return nil, fmt.Errorf("the secret: %s does not exist", path)
+		}
+		// step: the secrets are kept apart when written as yaml documents
+		if rn.resource.documents {
+			secret.Data[path] = x.Data
+		} else {
+			for k, v := range x.Data {
+				if _, found := secret.Data[k]; found {
+					glog.V(4).Infof("resource: %s, the key: %s is overridden by the secret: %s", rn.resource, k, path)
+				}
+				secret.Data[k] = v
+			}
+		}
+		// step: the lease of the merged secret is the shortest lease of the secrets
+		if secret.LeaseDuration == 0 || (x.LeaseDuration > 0 && x.LeaseDuration < secret.LeaseDuration) {
+			secret.LeaseDuration = x.LeaseDuration
+		}
+	}
+
+	return secret, nil
+}
+
+// kvPaths returns the data and metadata paths of a kv v2 secret, the first element of the path is the mount
+//	path		: the path of the secret i.e. secret/myapp/config
+func kvPaths(path string) (string, string, error) {
+	items := strings.SplitN(strings.Trim(path, "/"), "/", 2)
+	if len(items) != 2 || items[1] == "" {
+		return "", "", fmt.Errorf("the kv path: %s must include the mount and the secret", path)
+	}
+
+	return items[0] + "/data/" + items[1], items[0] + "/metadata/" + items[1], nil
+}
+
+// toInt converts a numeric value from a vault response
+func toInt(value interface{}) int {
+	switch v := value.(type) {
+	case json.Number:
+		i, _ := v.Int64()
+		return int(i)
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+
+	return 0
+}
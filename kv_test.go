@@ -0,0 +1,61 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKVPaths(t *testing.T) {
+	data, metadata, err := kvPaths("secret/myapp/config")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret/data/myapp/config", data)
+	assert.Equal(t, "secret/metadata/myapp/config", metadata)
+
+	_, _, err = kvPaths("secret")
+	assert.Error(t, err)
+}
+
+func TestToInt(t *testing.T) {
+	assert.Equal(t, 3, toInt(json.Number("3")))
+	assert.Equal(t, 3, toInt(float64(3)))
+	assert.Equal(t, 3, toInt(3))
+	assert.Equal(t, 0, toInt("3"))
+}
+
+func TestKVDeletion(t *testing.T) {
+	now := time.Date(2018, 3, 22, 12, 0, 0, 0, time.UTC)
+	metadata := func(version map[string]interface{}) *api.Secret {
+		return &api.Secret{Data: map[string]interface{}{
+			"current_version": json.Number("2"),
+			"versions":        map[string]interface{}{"2": version},
+		}}
+	}
+
+	assert.Nil(t, kvDeletion(nil, now))
+	assert.Nil(t, kvDeletion(metadata(map[string]interface{}{"deletion_time": "", "destroyed": false}), now))
+	assert.Nil(t, kvDeletion(metadata(map[string]interface{}{"deletion_time": "2018-03-23T00:00:00Z"}), now))
+	assert.Equal(t, &kvDeletedError{version: 2},
+		kvDeletion(metadata(map[string]interface{}{"deletion_time": "2018-03-22T02:24:06.945319214Z"}), now))
+	assert.Equal(t, &kvDeletedError{version: 2, destroyed: true},
+		kvDeletion(metadata(map[string]interface{}{"deletion_time": "", "destroyed": true}), now))
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// byteSizeRegex matches a size i.e. 512, 64k, 1Mi or 10MB
+var byteSizeRegex = regexp.MustCompile(`^([0-9]+)\s*([kKmMgG]?)(i?[bB]?)$`)
+
+// byteSize is a flag holding a size in bytes, zero is unlimited
+type byteSize int64
+
+// Set parses the size from the command line
+func (b *byteSize) Set(value string) error {
+	size, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(size)
+
+	return nil
+}
+
+// String returns the size in bytes
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+// parseByteSize parses a size, the units are powers of 1024
+//	value		: the size i.e. 512, 64k, 1Mi or 10MB
+func parseByteSize(value string) (int64, error) {
+	matches := byteSizeRegex.FindStringSubmatch(strings.TrimSpace(value))
+	if matches == nil {
+		return 0, fmt.Errorf("invalid size: %s, should be a number of bytes with an optional k, m or g unit", value)
+	}
+	size, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	switch strings.ToLower(matches[2]) {
+	case "k":
+		size *= 1 << 10
+	case "m":
+		size *= 1 << 20
+	case "g":
+		size *= 1 << 30
+	}
+
+	return size, nil
+}
+
+// limitedTransport rejects responses from vault larger than the limit, protecting us from dumping
+// i.e. a huge list response from a mis-pathed read onto the secrets volume
+type limitedTransport struct {
+	// the underlying transport
+	transport http.RoundTripper
+	// the maximum size of a response body
+	limit int64
+}
+
+// RoundTrip performs the request, wrapping the body of the response
+func (l *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := l.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength > l.limit {
+		resp.Body.Close()
+		return nil, fmt.Errorf("the response from: %s is %d bytes, exceeding the maximum response size of %d bytes",
+			req.URL.Path, resp.ContentLength, l.limit)
+	}
+	resp.Body = &limitedBody{body: resp.Body, remaining: l.limit, limit: l.limit, path: req.URL.Path}
+
+	return resp, nil
+}
+
+// limitedBody is a response body which errors once the limit is exceeded
+type limitedBody struct {
+	// the underlying body
+	body io.ReadCloser
+	// the number of bytes remaining
+	remaining int64
+	// the limit of the body
+	limit int64
+	// the path of the request
+	path string
+}
+
+// Read reads from the body, failing if the body exceeds the limit
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, fmt.Errorf("the response from: %s exceeds the maximum response size of %d bytes", l.path, l.limit)
+	}
+	// step: read one byte beyond the limit so we can tell the body was too large
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.body.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, fmt.Errorf("the response from: %s exceeds the maximum response size of %d bytes", l.path, l.limit)
+	}
+
+	return n, err
+}
+
+// Close closes the underlying body
+func (l *limitedBody) Close() error {
+	return l.body.Close()
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseByteSize(t *testing.T) {
+	cases := []struct {
+		Value    string
+		Expected int64
+		Error    bool
+	}{
+		{Value: "0", Expected: 0},
+		{Value: "512", Expected: 512},
+		{Value: "64k", Expected: 64 << 10},
+		{Value: "64KB", Expected: 64 << 10},
+		{Value: "1Mi", Expected: 1 << 20},
+		{Value: "1MiB", Expected: 1 << 20},
+		{Value: "2g", Expected: 2 << 30},
+		{Value: "1.5m", Error: true},
+		{Value: "ten", Error: true},
+		{Value: "10t", Error: true},
+	}
+	for i, c := range cases {
+		size, err := parseByteSize(c.Value)
+		if c.Error {
+			assert.Error(t, err, "case %d, should have failed", i)
+			continue
+		}
+		if assert.NoError(t, err, "case %d, should not have failed", i) {
+			assert.Equal(t, c.Expected, size, "case %d, unexpected size", i)
+		}
+	}
+}
+
+func TestLimitedTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		content := strings.Repeat("a", 100)
+		if req.URL.Path == "/chunked" {
+			// step: flushing forces a chunked response without a content length
+			w.Write([]byte(content[:50]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(content[50:]))
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &limitedTransport{transport: http.DefaultTransport, limit: 100}}
+	resp, err := client.Get(server.URL + "/exact")
+	if assert.NoError(t, err) {
+		content, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.NoError(t, err)
+		assert.Len(t, content, 100)
+	}
+
+	client = &http.Client{Transport: &limitedTransport{transport: http.DefaultTransport, limit: 99}}
+	_, err = client.Get(server.URL + "/exact")
+	assert.Error(t, err)
+
+	resp, err = client.Get(server.URL + "/chunked")
+	if assert.NoError(t, err) {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Error(t, err)
+	}
+}
+
+func TestWriteFileSizeLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "limits")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	options.maxFileSize = 10
+	defer func() { options.maxFileSize = 0 }()
+
+	assert.NoError(t, writeFile(filepath.Join(dir, "small"), []byte("small"), 0600))
+	assert.Error(t, writeFile(filepath.Join(dir, "large"), []byte("far too large"), 0600))
+	exists, _ := fileExists(filepath.Join(dir, "large"))
+	assert.False(t, exists)
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+)
+
+// lockFileName is the lock file in each output directory; the sidekick holds an exclusive lock
+// on the file while writing all the files of a resource, a reader wanting a consistent view takes
+// a shared lock before reading i.e. flock -s /etc/secrets/.vault-sidekick.lock cat /etc/secrets/tls.pem
+const lockFileName = ".vault-sidekick.lock"
+
+// lockDirectory takes an exclusive lock on the lock file of the directory, blocking until acquired,
+// and returns a function to release it
+//	directory	: the directory the files are written to
+func lockDirectory(directory string) (func(), error) {
+	filename := filepath.Join(directory, lockFileName)
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	glog.V(10).Infof("acquired the lock: %s", filename)
+
+	return func() {
+		if err := unlockFile(file); err != nil {
+			glog.Errorf("failed to release the lock: %s, error: %s", filename, err)
+		}
+		file.Close()
+	}, nil
+}
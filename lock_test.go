@@ -0,0 +1,63 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	unlock, err := lockDirectory(dir)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	exists, _ := fileExists(filepath.Join(dir, lockFileName))
+	assert.True(t, exists)
+
+	// step: a second writer should block until the lock is released
+	acquired := make(chan struct{})
+	go func() {
+		second, err := lockDirectory(dir)
+		if assert.NoError(t, err) {
+			second()
+		}
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("the lock should not have been acquired while held")
+	case <-time.After(time.Duration(100) * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Duration(5) * time.Second):
+		t.Fatalf("the lock was not acquired after being released")
+	}
+}
@@ -0,0 +1,59 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// lockfileExclusiveLock requests an exclusive lock from LockFileEx
+	lockfileExclusiveLock = 0x00000002
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// lockFile takes an exclusive lock on the first byte of the file
+func lockFile(file *os.File) error {
+	overlapped := new(syscall.Overlapped)
+	r, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock, 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// unlockFile releases the lock on the file
+func unlockFile(file *os.File) error {
+	overlapped := new(syscall.Overlapped)
+	r, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
@@ -17,11 +17,16 @@ limitations under the License.
 package main
 
 import (
+	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/golang/glog"
 )
@@ -34,6 +39,31 @@ var (
 
 func main() {
 	version := fmt.Sprintf("%s (git+sha %s)", release, gitsha)
+	// step: the policy subcommand prints the vault policy required by the resources
+	if len(os.Args) > 1 && os.Args[1] == "policy" {
+		os.Exit(runPolicyCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "revoke-self" {
+		os.Exit(runRevokeSelfCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "certs" {
+		os.Exit(runCertsCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rollback" {
+		os.Exit(runRollbackCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "inject" {
+		os.Exit(runInjectCommand(os.Args[2:], os.Stdin, os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import-agent-config" {
+		os.Exit(runImportAgentConfigCommand(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "token-helper" {
+		os.Exit(runTokenHelperCommand(os.Args[2:], os.Stdin, os.Stdout))
+	}
+	if filepath.Base(os.Args[0]) == tokenHelperName {
+		os.Exit(runTokenHelperCommand(os.Args[1:], os.Stdin, os.Stdout))
+	}
 	// step: parse and validate the command line / environment options
 	if err := parseOptions(); err != nil {
 		showUsage("invalid options, %s", err)
@@ -43,38 +73,237 @@ func main() {
 		return
 	}
 	glog.Infof("starting the %s, %s", prog, version)
+	if !options.disableMlock {
+		secureMemory()
+	}
+	if options.watchFiles && !options.dryRun {
+		guard = newFileGuard()
+		guard.start(guardInterval)
+	}
+	var deadline time.Time
+	if options.startupTimeout > 0 {
+		deadline = time.Now().Add(options.startupTimeout)
+	}
 
-	if options.oneShot {
+	if options.then {
+		glog.Infof("running in one-shot mode, handing over to the command: %s once the resources are retrieved", options.command[0])
+	} else if options.oneShot {
 		glog.Infof("running in one-shot mode")
 	}
 
+	// step: start the trace exporter if required
+	if options.otlpEndpoint != "" {
+		glog.Infof("exporting traces to: %s", options.otlpEndpoint)
+		tracer = newSpanExporter(options.otlpEndpoint, options.otlpServiceName)
+	}
+
+	// step: start the mock vault if required
+	if options.mockDir != "" {
+		mockURL, err := startMockVault(options.mockDir)
+		if err != nil {
+			showUsage("unable to start the mock vault: %s", err)
+		}
+		glog.Warningf("running against a mock vault: %s, fixtures: %s", mockURL, options.mockDir)
+		options.vaultURL = mockURL
+		options.vaultAuthFile = ""
+		options.vaultAuthOptions = &vaultAuthOptions{Method: "token", Token: mockToken}
+	}
+
+	// step: take turns issuing certificates with the other replicas if required
+	if options.pkiLease != "" {
+		var err error
+		if issuance, err = newIssuanceLock(options.pkiLease, options.pkiLeaseDuration); err != nil {
+			showUsage("unable to coordinate via the pki lease: %s", err)
+		}
+		glog.Infof("coordinating the issuance of certificates via the lease: %s/%s", issuance.namespace, issuance.name)
+	}
+
+	// step: load any persisted leases
+	var state *leaseStore
+	if options.stateFile != "" {
+		var err error
+		if state, err = newLeaseStore(options.stateFile); err != nil {
+			showUsage("unable to load the state file: %s", err)
+		}
+	}
+
+	// step: capture the requests to vault for the admin api if required
+	if options.debugHTTP {
+		glog.Warningf("capturing a trace of the last %d requests to vault on the admin api, the paths and field names are exposed", options.debugHTTPSize)
+		httpTraces = newTraceBuffer(options.debugHTTPSize)
+	}
+	// step: hold the shutdown until the application has drained if required
+	if options.drainPeriod > 0 {
+		drain = newShutdownDrain(options.drainPeriod)
+	}
+
+	// step: wait for the vaults to be unsealed and active if required
+	if options.waitForVault {
+		urls := []string{options.vaultURL}
+		for _, auth := range options.vaultAuthOptions.Vaults {
+			urls = append(urls, auth.VaultURL)
+		}
+		for _, url := range urls {
+			if err := waitForVault(url, deadline); err != nil {
+				glog.Errorf("vault is not available, error: %s", err)
+				exitWith(1)
+			}
+		}
+	}
+
 	// step: create a client to vault
-	vault, err := NewVaultService(options.vaultURL)
+	vault, err := NewVaultService(options.vaultURL, options.vaultAuthOptions, state)
 	if err != nil {
 		showUsage("unable to create the vault client: %s", err)
 	}
+	services := map[string]*VaultService{"": vault}
+
+	// step: create a client for each of the named vaults
+	for name, auth := range options.vaultAuthOptions.Vaults {
+		glog.Infof("creating a client for the vault: %s, address: %s", name, auth.VaultURL)
+		services[name], err = NewVaultService(auth.VaultURL, auth, state)
+		if err != nil {
+			showUsage("unable to create the client for the vault: %s, error: %s", name, err)
+		}
+	}
+
 	// step: create a channel to receive events upon and add our resources for renewal
 	updates := make(chan VaultEvent, 10)
-	vault.AddListener(updates)
+	freezes := newFreezer(updates)
+	for _, x := range services {
+		x.AddListener(updates)
+	}
+
+	// step: start the admin api if required
+	var adminListener net.Listener
+	if options.listen != "" {
+		if adminListener, err = startAdminServer(options.listen); err != nil {
+			showUsage("unable to start the admin api: %s", err)
+		}
+	}
 
 	// step: setup the termination signals
 	signalChannel := make(chan os.Signal)
 	signal.Notify(signalChannel, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	upgradeChannel := make(chan os.Signal, 1)
+	if upgradeSignal != nil {
+		signal.Notify(upgradeChannel, upgradeSignal)
+	}
+
+	// step: draw the status screen if required, the logs are kept off the terminal it's drawn on
+	if options.tui {
+		if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+			showUsage("the tui option requires a terminal")
+		}
+		flag.Set("logtostderr", "false")
+		flag.Set("alsologtostderr", "false")
+		flag.Set("stderrthreshold", "FATAL")
+		var err error
+		if screen, err = newStatusScreen(os.Stdin, os.Stdout, func() { signalChannel <- syscall.SIGTERM }); err != nil {
+			showUsage("%s", err)
+		}
+	}
+
+	// step: add the resources of the configmap if required
+	var configMap *configMapWatcher
+	if options.k8sConfigMap != "" {
+		var err error
+		if configMap, err = newConfigMapWatcher(options.k8sConfigMap, options.k8sConfigMapKey, services); err != nil {
+			showUsage("%s", err)
+		}
+		items, err := configMap.initial()
+		if err != nil {
+			showUsage("%s", err)
+		}
+		options.resources.items = append(options.resources.items, items...)
+	}
+
+	// step: log the processes opening the files written if required
+	if options.auditAccess && !options.dryRun && options.outputDir != stdoutOutput {
+		a := newAccessAudit()
+		if err := a.start(); err != nil {
+			glog.Warningf("unable to audit the access to the files, continuing without, error: %s", err)
+		} else {
+			audit = a
+		}
+	}
 
 	// step: add each of the resources to the service processor
+	startup = newStartupWaves(options.startupConcurrency)
 	for _, rn := range options.resources.items {
 		if err := rn.IsValid(); err != nil {
 			showUsage("%s", err)
 		}
-		vault.Watch(rn)
+		service, found := services[rn.vault]
+		if !found {
+			showUsage("the resource: %s references an unknown vault: %s", rn, rn.vault)
+		}
+		registry.register(rn)
+		startup.add(rn)
+		service.Watch(rn)
+	}
+
+	// step: follow the changes of the configmap if required
+	if configMap != nil {
+		configMap.start(options.k8sConfigMapInterval)
+	}
+
+	// step: scan the output directory for out of place files if required
+	if options.scanInterval > 0 && !options.dryRun {
+		scanner, err := newDirScanner(options.outputDir, options.scanAction, options.scanAllow, options.resources.items)
+		if err != nil {
+			showUsage("%s", err)
+		}
+		scanner.start(options.scanInterval)
+	}
+
+	// step: alert on the resources failing for too long if required
+	if options.alertURL != "" {
+		alerts, err := newAlertEmitter(options.alertURL, options.alertHeader, options.alertTemplate, options.alertAfter)
+		if err != nil {
+			showUsage("unable to create the alert emitter: %s", err)
+		}
+		glog.Infof("alerting on the resources failing for longer than %s", options.alertAfter)
+		alerts.start(alertInterval)
+	}
+
+	// step: start the pki server if required
+	var pkiFiles *pkiServer
+	if options.servePKI != "" {
+		if pkiFiles, err = startPKIServer(options.servePKI); err != nil {
+			showUsage("unable to start the pki server: %s", err)
+		}
+	}
+
+	// step: create the child process if the secrets are only injected into its environment
+	var child *envChild
+	var childExited chan error
+	if options.execEnvOnly {
+		child = newEnvChild(options.command, options.resources.items, options.execTimeout)
+		childExited = child.exited
 	}
 
 	toProcess := options.resources.items
 	toProcessLock := &sync.Mutex{}
 	failedResource := false
+
+	// step: track the resources yet to be retrieved for the first time
+	pending := make(map[*VaultResource]bool, len(toProcess))
+	for _, rn := range toProcess {
+		pending[rn] = true
+	}
+	schedulePrinted := false
+	var drainDone <-chan struct{}
+	var startupTimer <-chan time.Time
+	if !deadline.IsZero() && len(pending) > 0 {
+		startupTimer = time.After(deadline.Sub(time.Now()))
+	}
 	if options.oneShot && len(toProcess) == 0 {
+		if options.then {
+			handoverOrExit()
+		}
 		glog.Infof("nothing to retrieve from vault. exiting...")
-		os.Exit(0)
+		exitWith(0)
 	}
 	// step: we simply wait for events i.e. secrets from vault and write them to the output directory
 	for {
@@ -86,8 +315,46 @@ func main() {
 				defer toProcessLock.Unlock()
 				switch r.Type {
 				case EventTypeSuccess:
-					if err := processResource(evt.Resource, evt.Secret); err != nil {
+					// step: the new version is held back within a change freeze
+					if !r.Retained && freezes.hold(r) {
+						delete(pending, r.Resource)
+						break
+					}
+					// step: a secret which breaks the policy of the resource never replaces the last good copy, and
+					// a secret which isn't held leaves the files written before the restart as they are
+					var err error
+					if !evt.Retained {
+						err = checkSecretPolicy(evt.Resource, evt.Secret)
+						if err == nil && child != nil {
+							err = child.update(evt.Resource, evt.Secret)
+						} else if err == nil {
+							err = processResource(evt.Resource, evt.Secret, evt.Metadata)
+							metrics.add(metricWrites, 1, resourceLabels(evt.Resource, "status", statusLabel(err))...)
+						}
+						if err == nil && child == nil {
+							templateChain.render(evt.Resource)
+						}
+						if err == nil && pkiFiles != nil && evt.Resource.servePKI {
+							err = pkiFiles.update(evt.Resource, evt.Secret, vaultAddress(evt.Resource.vault))
+						}
+					}
+					if err != nil {
 						glog.Errorf("failed to write out the update, error: %s", err)
+						registry.failure(evt.Resource, err)
+						runOutcomeHooks(evt.Resource, nil, nil, err)
+					} else {
+						delete(pending, evt.Resource)
+						// step: print the schedule once the first retrieval of every resource is complete
+						if options.printSchedule && !schedulePrinted && len(pending) == 0 {
+							fmt.Print(formatSchedule(schedule.list(time.Now())))
+							schedulePrinted = true
+						}
+						registry.success(evt.Resource, evt.Metadata)
+						freezes.record(evt.Resource)
+						runOutcomeHooks(evt.Resource, evt.Secret, evt.Metadata, nil)
+						if evt.Metadata != nil && evt.Metadata.Expires != nil {
+							metrics.set(metricExpiry, float64(evt.Metadata.Expires.Unix()), resourceLabels(evt.Resource)...)
+						}
 					}
 					if options.oneShot {
 						for i, r := range toProcess {
@@ -97,6 +364,8 @@ func main() {
 						}
 					}
 				case EventTypeFailure:
+					registry.failure(evt.Resource, evt.Error)
+					runOutcomeHooks(evt.Resource, nil, nil, evt.Error)
 					if evt.Resource.maxRetries > 0 && evt.Resource.maxRetries < evt.Resource.retries {
 						for i, r := range toProcess {
 							if evt.Resource == r {
@@ -107,17 +376,94 @@ func main() {
 					}
 				}
 				if len(toProcess) == 0 {
+					// step: hand over to the command once every resource has been retrieved
+					if options.then && !failedResource {
+						handoverOrExit()
+					}
 					glog.Infof("no resources left to process. exiting...")
 					if failedResource {
-						os.Exit(1)
+						exitWith(1)
 					} else {
-						os.Exit(0)
+						exitWith(0)
 					}
 				}
 			}(evt)
-		case <-signalChannel:
+		case <-startupTimer:
+			toProcessLock.Lock()
+			if len(pending) > 0 {
+				for rn := range pending {
+					glog.Errorf("resource: %s was not retrieved within the startup timeout", rn)
+				}
+				glog.Errorf("timed out after %s waiting for the first retrieval of %d resources, exiting", options.startupTimeout, len(pending))
+				exitWith(1)
+			}
+			toProcessLock.Unlock()
+		case sig := <-signalChannel:
+			// step: the child is given the signal, we exit along with it
+			if child != nil && child.signal(sig) {
+				glog.Infof("recieved a signal: %s, forwarded to the command", sig)
+				break
+			}
+			// step: keep renewing the leases until the application has shut down, a second signal exits regardless
+			if drain != nil && drainDone == nil && sig == syscall.SIGTERM {
+				glog.Infof("recieved a termination signal, draining for up to %s or until the application acknowledges the shutdown", options.drainPeriod)
+				drainDone = drain.start()
+				break
+			}
 			glog.Infof("recieved a termination signal, shutting down the service")
-			os.Exit(0)
+			exitWith(0)
+		case <-drainDone:
+			glog.Infof("the drain is complete, shutting down the service")
+			exitWith(0)
+		case err := <-childExited:
+			glog.Infof("the command has exited, shutting down the service, result: %v", err)
+			exitWith(exitCode(err))
+		case <-upgradeChannel:
+			glog.Infof("recieved an upgrade signal, replacing the process with the binary on disk")
+			// step: wait for the update being written, so the exec doesn't cut off a file half written
+			toProcessLock.Lock()
+			if err := reexec(adminListener); err != nil {
+				glog.Errorf("unable to exec the new process, continuing, error: %s", err)
+			}
+			toProcessLock.Unlock()
 		}
 	}
 }
+
+// vaultAddress returns the address of the named vault, the default vault if empty
+func vaultAddress(name string) string {
+	if auth, found := options.vaultAuthOptions.Vaults[name]; found && name != "" {
+		return auth.VaultURL
+	}
+
+	return options.vaultURL
+}
+
+// exitWith flushes any pending telemetry and exits the process
+//	code		: the exit code
+func exitWith(code int) {
+	flushTelemetry()
+	os.Exit(code)
+}
+
+// handoverOrExit hands over to the command following -then, exiting non zero if it can't be run
+func handoverOrExit() {
+	glog.Infof("the resources have been retrieved, handing over to the command: %s", strings.Join(options.command, " "))
+	if err := handover(options.command); err != nil {
+		glog.Errorf("failed to hand over to the command, error: %s", err)
+		exitWith(1)
+	}
+}
+
+// flushTelemetry flushes the pending traces and logs ahead of the process exiting
+func flushTelemetry() {
+	if tracer != nil {
+		tracer.flush(time.Duration(5) * time.Second)
+	}
+	if devLogs != nil {
+		devLogs.close()
+	}
+	if screen != nil {
+		screen.close()
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// outMemfd is the value of the out option handing the resource to the command of exec-env-only on a sealed
+// anonymous file rather than writing a file
+const outMemfd = "memfd"
+
+// memfdContent is a file of a resource handed to the command on a sealed anonymous file
+type memfdContent struct {
+	// the name of the file, the environment variable holding the descriptor is derived from it
+	name string
+	// the formatted content
+	content []byte
+}
+
+// memfdCaptures collects the files of the resources being rendered for a sealed anonymous file
+var memfdCaptures = struct {
+	sync.Mutex
+	files map[*VaultResource]map[string][]byte
+}{files: make(map[*VaultResource]map[string][]byte, 0)}
+
+// captureMemfd records a file of the resource in place of writing it
+//	rn			: the resource
+//	filename	: the filename the file would be written to
+//	content		: the content of the file
+func captureMemfd(rn *VaultResource, filename string, content []byte) error {
+	memfdCaptures.Lock()
+	defer memfdCaptures.Unlock()
+	files, found := memfdCaptures.files[rn]
+	if !found {
+		return fmt.Errorf("the resource: %s is only handed to the command of exec-env-only", rn)
+	}
+	files[filepath.Base(filename)] = append([]byte(nil), content...)
+
+	return nil
+}
+
+// renderMemfd formats the secret of the resource as it would be written, returning the files in order of name
+//	rn			: the resource
+//	data		: the secret data
+func renderMemfd(rn *VaultResource, data map[string]interface{}) ([]memfdContent, error) {
+	if len(rn.keys) > 0 {
+		selected, err := selectKeys(data, rn.keys)
+		if err != nil {
+			return nil, err
+		}
+		data = selected
+	}
+	memfdCaptures.Lock()
+	memfdCaptures.files[rn] = make(map[string][]byte, 0)
+	memfdCaptures.Unlock()
+
+	filename := resolveFilename(rn.GetFilename())
+	var err error
+	if rn.resource == "tpl" {
+		err = writeTemplateFile(filename, data, rn, nil)
+	} else {
+		err = writeResourceFile(rn, filename, data)
+	}
+
+	memfdCaptures.Lock()
+	captured := memfdCaptures.files[rn]
+	delete(memfdCaptures.files, rn)
+	memfdCaptures.Unlock()
+	if err != nil {
+		for _, content := range captured {
+			zeroBytes(content)
+		}
+		return nil, err
+	}
+
+	var files []memfdContent
+	for name, content := range captured {
+		files = append(files, memfdContent{name: name, content: content})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	return files, nil
+}
@@ -0,0 +1,111 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// mfdCloexec closes the anonymous file on exec, the command only receives the descriptors handed to it
+	mfdCloexec = 0x1
+	// mfdAllowSealing permits the seals to be added to the anonymous file
+	mfdAllowSealing = 0x2
+	// fcntlAddSeals is the fcntl command adding seals to a file
+	fcntlAddSeals = 1033
+	// fcntlGetSeals is the fcntl command returning the seals of a file
+	fcntlGetSeals = 1034
+	// memfdSeals stop the content being changed, grown or shrunk, and any further seals being added or removed
+	memfdSeals = 0x1 | 0x2 | 0x4 | 0x8
+)
+
+// memfdSyscalls is the number of the memfd_create system call on each architecture
+var memfdSyscalls = map[string]uintptr{
+	"386":      356,
+	"amd64":    319,
+	"arm":      385,
+	"arm64":    279,
+	"loong64":  279,
+	"mips":     4354,
+	"mipsle":   4354,
+	"mips64":   5314,
+	"mips64le": 5314,
+	"ppc64":    360,
+	"ppc64le":  360,
+	"riscv64":  279,
+	"s390x":    350,
+}
+
+// newMemfd creates a sealed anonymous file holding the content, it has no path on any filesystem and is
+// gone once the last descriptor is closed; the file is positioned at the start of the content
+//	name		: the name of the file, only shown under /proc
+//	content		: the content of the file
+func newMemfd(name string, content []byte) (*os.File, error) {
+	number, found := memfdSyscalls[runtime.GOARCH]
+	if !found {
+		return nil, fmt.Errorf("sealed anonymous files are not supported on %s", runtime.GOARCH)
+	}
+	path, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	fd, _, errno := syscall.Syscall(number, uintptr(unsafe.Pointer(path)), mfdCloexec|mfdAllowSealing, 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("unable to create the anonymous file, error: %s", errno)
+	}
+	file := os.NewFile(fd, "memfd:"+name)
+
+	// step: write the content and seal it, the command can only read it
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("unable to write the anonymous file, error: %s", err)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, fcntlAddSeals, memfdSeals); errno != 0 {
+		file.Close()
+		return nil, fmt.Errorf("unable to seal the anonymous file, error: %s", errno)
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("unable to rewind the anonymous file, error: %s", err)
+	}
+
+	return file, nil
+}
+
+// isValidMemfd checks the options of a resource handed over on a sealed anonymous file, those writing
+// directories, files alongside or reading the file back can't be handed over
+func (r VaultResource) isValidMemfd() error {
+	if !r.memfd {
+		return nil
+	}
+	switch r.format {
+	case "envdir", "patch", "spiffe", "p12", "jks":
+		return fmt.Errorf("the %s format can't be handed over on a sealed anonymous file", r.format)
+	}
+	if r.explode || r.isWildcard() || r.metaFile || r.ocsp || r.validate != "" || r.merge != "" || r.backups > 0 {
+		return fmt.Errorf("a sealed anonymous file is not supported with the explode, meta, ocsp, validate, merge or backups options, or wildcards")
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMemfd(t *testing.T) {
+	file, err := newMemfd("db.json", []byte(`{"password":"s3cr3t"}`))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer file.Close()
+
+	// step: the content is readable from the start, the file is sealed against any change
+	content, err := ioutil.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"password":"s3cr3t"}`, string(content))
+	seals, _, errno := syscall.Syscall(syscall.SYS_FCNTL, file.Fd(), fcntlGetSeals, 0)
+	assert.Equal(t, syscall.Errno(0), errno)
+	assert.Equal(t, uintptr(memfdSeals), seals)
+	_, err = file.WriteAt([]byte("x"), 0)
+	assert.Error(t, err)
+	assert.Error(t, file.Truncate(0))
+}
+
+func TestIsValidMemfd(t *testing.T) {
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "db"
+	rn.memfd = true
+	assert.NoError(t, rn.IsValid())
+	rn.format = "envdir"
+	assert.Error(t, rn.IsValid())
+	rn.format = "json"
+	rn.metaFile = true
+	assert.Error(t, rn.IsValid())
+}
+
+func TestEnvChildMemfd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memfd")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "out")
+	db, api := defaultVaultResource(), defaultVaultResource()
+	db.resource, db.path, db.format, db.memfd = "secret", "db", "json", true
+	db.filename = "db.json"
+	c := newEnvChild([]string{"sh", "-c", "cat <&$DB_JSON_FD > " + filename + ".tmp && echo $API_KEY >> " + filename + ".tmp && mv " + filename + ".tmp " + filename + " && exec sleep 30"}, []*VaultResource{db, api}, 5*time.Second)
+	defer func() {
+		c.Lock()
+		c.stop()
+		c.Unlock()
+	}()
+
+	// step: the command reads the secret from the descriptor, no file is written
+	assert.NoError(t, c.update(db, map[string]interface{}{"password": "one"}))
+	assert.NoError(t, c.update(api, map[string]interface{}{"api-key": "k3y"}))
+	content := waitForFile(t, filename, "k3y")
+	assert.Contains(t, content, `"password": "one"`)
+	found, _ := fileExists(resolveFilename("db.json"))
+	assert.False(t, found)
+	pid := c.cmd.Process.Pid
+
+	// step: an unchanged secret leaves the command running, a changed one restarts it
+	assert.NoError(t, c.update(db, map[string]interface{}{"password": "one"}))
+	assert.Equal(t, pid, c.cmd.Process.Pid)
+	os.Remove(filename)
+	assert.NoError(t, c.update(db, map[string]interface{}{"password": "two"}))
+	content = waitForFile(t, filename, "k3y")
+	assert.Contains(t, content, `"password": "two"`)
+}
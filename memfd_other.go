@@ -0,0 +1,39 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// newMemfd is only supported on linux
+func newMemfd(name string, content []byte) (*os.File, error) {
+	return nil, errors.New("sealed anonymous files are only supported on linux")
+}
+
+// isValidMemfd refuses a resource handed over on a sealed anonymous file, which is only supported on linux
+func (r VaultResource) isValidMemfd() error {
+	if r.memfd {
+		return errors.New("sealed anonymous files are only supported on linux")
+	}
+
+	return nil
+}
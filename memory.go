@@ -0,0 +1,46 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/golang/glog"
+)
+
+// zeroBytes overwrites the buffer, so a secret doesn't linger in memory once written
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// secureMemory locks the memory of the process, so the secrets held are never swapped to disk
+func secureMemory() {
+	if err := lockMemory(); err != nil {
+		glog.Warningf("unable to lock the memory, secrets may be swapped to disk, grant the IPC_LOCK capability or use -disable-mlock, error: %s", err)
+		return
+	}
+	glog.V(3).Infof("locked the memory of the process")
+}
+
+// release drops the secret data held by the watched resource once handed upstream, the data is only
+// retained when the lease is renewed, as the files are written again from it on each renewal
+func (r *watchedResource) release() {
+	if r.secret == nil || r.resource.renewable {
+		return
+	}
+	r.secret.Data = nil
+}
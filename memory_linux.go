@@ -0,0 +1,73 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// rlimitMemlock is the resource limit on locked memory
+	rlimitMemlock = 0x8
+	// rlimitInfinity is an unlimited resource limit
+	rlimitInfinity = ^uint64(0)
+	// capIPCLock is the capability to lock memory
+	capIPCLock = 14
+)
+
+// lockMemory locks the current and future memory of the process, only attempted when permitted, as a
+// limited lock would have the allocations of the runtime fail once the limit is reached
+func lockMemory() error {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(rlimitMemlock, &limit); err != nil {
+		return err
+	}
+	if uint64(limit.Cur) != rlimitInfinity && !hasCapability(capIPCLock) {
+		return errors.New("requires the IPC_LOCK capability or an unlimited memlock limit")
+	}
+
+	return syscall.Mlockall(syscall.MCL_CURRENT | syscall.MCL_FUTURE)
+}
+
+// hasCapability checks the effective capabilities of the process
+func hasCapability(capability uint) bool {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "CapEff:") {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		if err != nil {
+			return false
+		}
+		return caps&(1<<capability) != 0
+	}
+
+	return false
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZeroBytes(t *testing.T) {
+	b := []byte("s3cr3t")
+	zeroBytes(b)
+	assert.Equal(t, make([]byte, 6), b)
+	zeroBytes(nil)
+}
+
+func TestWriteResourceContentZeroes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memory")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "secret")
+	content := []byte("s3cr3t")
+	if !assert.NoError(t, writeResourceContent(defaultVaultResource(), filename, content)) {
+		t.FailNow()
+	}
+	assert.Equal(t, make([]byte, 6), content)
+	written, err := ioutil.ReadFile(filename)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "s3cr3t", string(written))
+	}
+}
+
+func TestWatchedResourceRelease(t *testing.T) {
+	rn := defaultVaultResource()
+	x := &watchedResource{resource: rn, secret: &api.Secret{LeaseID: "lease", Data: map[string]interface{}{"password": "s3cr3t"}}}
+	x.release()
+	assert.Nil(t, x.secret.Data)
+	assert.Equal(t, "lease", x.secret.LeaseID)
+
+	// step: a renewed lease writes the files again from the data, so it must be kept
+	rn = defaultVaultResource()
+	rn.renewable = true
+	x = &watchedResource{resource: rn, secret: &api.Secret{Data: map[string]interface{}{"password": "s3cr3t"}}}
+	x.release()
+	assert.NotNil(t, x.secret.Data)
+
+	(&watchedResource{resource: rn}).release()
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// writeMergeFile deep merges the secret into an existing json or yaml file at the dotted path of the merge
+// option, so an application with a single configuration file keeps the rest of its settings; the file is
+// written back with its keys in order, any comments of a yaml file are lost
+//	filename	: the file to merge into
+//	data		: the secret data
+//	rn			: the resource
+func writeMergeFile(filename string, data map[string]interface{}, rn *VaultResource) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("merge mode requires an existing file: %s, error: %s", filename, err)
+	}
+	defer zeroBytes(content)
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	document, err := decodeMergeDocument(content, rn.format)
+	if err != nil {
+		return fmt.Errorf("unable to parse the file: %s, error: %s", filename, err)
+	}
+	// step: walk down to the map the secret is merged into, creating any missing along the way
+	node := document
+	for _, key := range mergePathKeys(rn.merge) {
+		value, found := node[key]
+		if !found || value == nil {
+			child := make(map[string]interface{}, 0)
+			node[key] = child
+			node = child
+			continue
+		}
+		child, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("unable to merge into the file: %s, the key: %s of the path: %s is not a map", filename, key, rn.merge)
+		}
+		node = child
+	}
+	mergeValues(node, normalizeValues(data).(map[string]interface{}))
+
+	var merged []byte
+	switch rn.format {
+	case "json":
+		if merged, err = json.MarshalIndent(document, "", "    "); err != nil {
+			return err
+		}
+		if bytes.HasSuffix(content, []byte("\n")) {
+			merged = append(merged, '\n')
+		}
+	default:
+		merged = encodeYAML(document)
+	}
+	defer zeroBytes(merged)
+
+	return writeFile(filename, merged, stat.Mode())
+}
+
+// decodeMergeDocument decodes the content of the file merged into, an empty file being an empty document
+//	content		: the content of the file
+//	format		: the format of the file, json or yaml
+func decodeMergeDocument(content []byte, format string) (map[string]interface{}, error) {
+	document := make(map[string]interface{}, 0)
+	if len(bytes.TrimSpace(content)) == 0 {
+		return document, nil
+	}
+	if format == "json" {
+		// step: numbers are kept as they were written rather than becoming floats
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(&document); err != nil {
+			return nil, err
+		}
+		return document, nil
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		return document, nil
+	}
+
+	return normalizeValues(values).(map[string]interface{}), nil
+}
+
+// mergePathKeys splits the dotted path of the merge option into its keys, the root of the document being '.'
+//	path		: the path i.e. database.credentials
+func mergePathKeys(path string) []string {
+	var keys []string
+	for _, key := range strings.Split(strings.Trim(path, "."), ".") {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// mergeFormat returns the format of the file merged into from its extension, yaml unless it is a json file
+//	filename	: the file merged into
+func mergeFormat(filename string) string {
+	if strings.ToLower(filepath.Ext(filename)) == ".json" {
+		return "json"
+	}
+
+	return "yaml"
+}
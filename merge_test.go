@@ -0,0 +1,87 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeResourceOptions(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/db:merge=database.credentials,file=/etc/app/config.json"))
+	assert.NoError(t, resources.Set("secret:secret/db:merge=.,file=/etc/app/config.yml"))
+	assert.NoError(t, resources.Set("secret:secret/db:merge=db,fmt=json,file=/etc/app/config"))
+	assert.Equal(t, "json", resources.items[0].format)
+	assert.Equal(t, "yaml", resources.items[1].format)
+	assert.Equal(t, "json", resources.items[2].format)
+	for _, rn := range resources.items {
+		assert.NoError(t, rn.IsValid())
+	}
+
+	resources = VaultResources{}
+	assert.Error(t, resources.Set("secret:secret/db:merge="))
+	assert.NoError(t, resources.Set("secret:secret/db:merge=db,fmt=env"))
+	assert.NoError(t, resources.Set("secret:secret/db:merge=db,explode=true"))
+	assert.NoError(t, resources.Set("secret:secret/db:merge=db,out=stdout"))
+	for _, rn := range resources.items {
+		assert.Error(t, rn.IsValid(), "resource: %s should be invalid", rn)
+	}
+}
+
+func TestMergePathKeys(t *testing.T) {
+	assert.Empty(t, mergePathKeys("."))
+	assert.Equal(t, []string{"database"}, mergePathKeys("database"))
+	assert.Equal(t, []string{"database", "credentials"}, mergePathKeys(".database.credentials."))
+}
+
+func TestWriteMergeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	data := map[string]interface{}{"username": "app", "password": "secret"}
+
+	// step: the secret is merged into the json file, keeping the other settings
+	filename := filepath.Join(dir, "config.json")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(`{"port": 8080, "database": {"host": "db", "username": "old"}}`+"\n"), 0640))
+	rn := &VaultResource{format: "json", merge: "database"}
+	assert.NoError(t, writeMergeFile(filename, data, rn))
+	content, _ := ioutil.ReadFile(filename)
+	assert.Equal(t, "{\n    \"database\": {\n        \"host\": \"db\",\n        \"password\": \"secret\",\n"+
+		"        \"username\": \"app\"\n    },\n    \"port\": 8080\n}\n", string(content))
+	stat, _ := os.Stat(filename)
+	assert.Equal(t, os.FileMode(0640), stat.Mode().Perm())
+
+	// step: the missing maps along the path are created in a yaml file
+	filename = filepath.Join(dir, "config.yaml")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("server:\n  port: 8080\n"), 0644))
+	rn = &VaultResource{format: "yaml", merge: "database.credentials"}
+	assert.NoError(t, writeMergeFile(filename, data, rn))
+	content, _ = ioutil.ReadFile(filename)
+	assert.Equal(t, "database:\n  credentials:\n    password: secret\n    username: app\nserver:\n  port: 8080\n", string(content))
+
+	// step: a path through a value, or a missing file, fails
+	rn = &VaultResource{format: "yaml", merge: "server.port"}
+	assert.Error(t, writeMergeFile(filename, data, rn))
+	assert.Error(t, writeMergeFile(filepath.Join(dir, "missing.yaml"), data, rn))
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// metadataFileSuffix is appended to the filename of the resource for the metadata file
+const metadataFileSuffix = ".meta.json"
+
+const (
+	// timeFormatRFC3339 serializes the times as rfc3339 and the durations as go durations i.e. 1h0m0s
+	timeFormatRFC3339 = "rfc3339"
+	// timeFormatUnix serializes the times as seconds since the epoch and the durations as seconds
+	timeFormatUnix = "unix"
+)
+
+const (
+	// sourceVault is a secret issued by the active vault
+	sourceVault = "vault"
+	// sourceStandby is a secret read from the performance standby
+	sourceStandby = "standby"
+	// sourceCache is a secret served from the response cache
+	sourceCache = "cache"
+	// sourceState is a lease resumed from the state file
+	sourceState = "state"
+)
+
+// secretMetadata describes the freshness of a secret, written alongside the secret so the
+// application and humans can introspect it without calling vault
+type secretMetadata struct {
+	// the resource type
+	Resource string `json:"resource"`
+	// the path of the resource in vault
+	Path string `json:"path"`
+	// the lease id if any
+	LeaseID string `json:"lease_id,omitempty"`
+	// whether the lease is renewable
+	Renewable bool `json:"renewable"`
+	// the time the secret was issued
+	Issued time.Time `json:"issued"`
+	// the time the secret or lease was last updated
+	Updated time.Time `json:"updated"`
+	// the time the lease expires
+	Expires *time.Time `json:"expires,omitempty"`
+	// the duration of the lease as last issued or renewed
+	LeaseDuration time.Duration `json:"lease_duration,omitempty"`
+	// the number of times the lease has been renewed since it was issued
+	Renewals int `json:"renewals"`
+	// where the secret was issued from, vault, standby, cache or state
+	Source string `json:"source,omitempty"`
+	// the version of a kv secret
+	Version int `json:"version,omitempty"`
+	// the serial number of a certificate
+	SerialNumber string `json:"serial_number,omitempty"`
+}
+
+// newSecretMetadata builds the metadata of a watched resource
+func newSecretMetadata(rn *watchedResource) *secretMetadata {
+	meta := &secretMetadata{
+		Resource: rn.resource.resource,
+		Path:     rn.resource.path,
+		Issued:   rn.issued,
+		Updated:  rn.lastUpdated,
+		Version:  rn.version,
+		Renewals: rn.renewals,
+		Source:   rn.source,
+	}
+	if rn.secret != nil {
+		if rn.secret.LeaseID != "raw" {
+			meta.LeaseID = rn.secret.LeaseID
+		}
+		meta.Renewable = rn.secret.Renewable
+		if rn.secret.LeaseDuration > 0 {
+			expires := rn.leaseExpireTime
+			meta.Expires = &expires
+			meta.LeaseDuration = time.Duration(rn.secret.LeaseDuration) * time.Second
+		}
+		if serial, found := rn.secret.Data["serial_number"]; found {
+			meta.SerialNumber = fmt.Sprintf("%v", serial)
+		}
+	}
+
+	return meta
+}
+
+// MarshalJSON serializes the times and durations of the metadata in the format of the -time-format option
+func (m secretMetadata) MarshalJSON() ([]byte, error) {
+	type plain secretMetadata
+	encoded := struct {
+		plain
+		Issued        interface{} `json:"issued"`
+		Updated       interface{} `json:"updated"`
+		Expires       interface{} `json:"expires,omitempty"`
+		LeaseDuration interface{} `json:"lease_duration,omitempty"`
+	}{
+		plain:   plain(m),
+		Issued:  formatTimeValue(m.Issued),
+		Updated: formatTimeValue(m.Updated),
+	}
+	if m.Expires != nil {
+		encoded.Expires = formatTimeValue(*m.Expires)
+	}
+	if m.LeaseDuration > 0 {
+		encoded.LeaseDuration = formatDurationValue(m.LeaseDuration)
+	}
+
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON reads the metadata back, the times and durations in either format
+func (m *secretMetadata) UnmarshalJSON(content []byte) error {
+	type plain secretMetadata
+	decoded := struct {
+		*plain
+		Issued        interface{} `json:"issued"`
+		Updated       interface{} `json:"updated"`
+		Expires       interface{} `json:"expires"`
+		LeaseDuration interface{} `json:"lease_duration"`
+	}{plain: (*plain)(m)}
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		return err
+	}
+	var err error
+	if m.Issued, err = parseTimeValue(decoded.Issued); err != nil {
+		return err
+	}
+	if m.Updated, err = parseTimeValue(decoded.Updated); err != nil {
+		return err
+	}
+	if decoded.Expires != nil {
+		expires, err := parseTimeValue(decoded.Expires)
+		if err != nil {
+			return err
+		}
+		m.Expires = &expires
+	}
+	m.LeaseDuration, err = parseDurationValue(decoded.LeaseDuration)
+
+	return err
+}
+
+// formatTimeValue returns the time in the format of the -time-format option, a string or the seconds since the epoch
+//	t			: the time to format
+func formatTimeValue(t time.Time) interface{} {
+	if options.timeFormat == timeFormatUnix {
+		return t.Unix()
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatDurationValue returns the duration in the format of the -time-format option, a string or the seconds
+//	d			: the duration to format
+func formatDurationValue(d time.Duration) interface{} {
+	if options.timeFormat == timeFormatUnix {
+		return int64(d.Seconds())
+	}
+
+	return d.String()
+}
+
+// parseTimeValue parses a time serialized by formatTimeValue in either format, zero if missing
+//	value		: the decoded json value
+func parseTimeValue(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, nil
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	}
+
+	return time.Time{}, fmt.Errorf("the time: %v is neither rfc3339 nor unix", value)
+}
+
+// parseDurationValue parses a duration serialized by formatDurationValue in either format, zero if missing
+//	value		: the decoded json value
+func parseDurationValue(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return time.Duration(v) * time.Second, nil
+	case string:
+		return time.ParseDuration(v)
+	}
+
+	return 0, fmt.Errorf("the duration: %v is neither a duration nor seconds", value)
+}
+
+// formatTime returns the time as logged, in the format of the -time-format option
+//	t			: the time to format
+func formatTime(t time.Time) string {
+	return fmt.Sprintf("%v", formatTimeValue(t))
+}
+
+// formatDuration returns the duration as logged, in the format of the -time-format option
+//	d			: the duration to format
+func formatDuration(d time.Duration) string {
+	if options.timeFormat == timeFormatUnix {
+		return fmt.Sprintf("%ds", int64(d.Seconds()))
+	}
+
+	return d.String()
+}
+
+// writeMetadataFile writes the metadata of the resource alongside the secret
+//	filename	: the filename of the secret
+//	meta		: the metadata of the secret
+//	mode		: the file permissions
+func writeMetadataFile(filename string, meta *secretMetadata, mode os.FileMode) error {
+	content, err := json.MarshalIndent(meta, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(filename+metadataFileSuffix, content, mode)
+}
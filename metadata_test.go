@@ -0,0 +1,165 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSecretMetadata(t *testing.T) {
+	rn := defaultVaultResource()
+	rn.resource = "pki"
+	rn.path = "pki/issue/example"
+	now := time.Now()
+	x := &watchedResource{
+		resource:        rn,
+		issued:          now,
+		lastUpdated:     now,
+		leaseExpireTime: now.Add(time.Hour),
+		secret: &api.Secret{
+			LeaseID:       "pki/issue/example/1234",
+			LeaseDuration: 3600,
+			Data:          map[string]interface{}{"serial_number": "39:dd:2e"},
+		},
+	}
+	meta := newSecretMetadata(x)
+	assert.Equal(t, "pki/issue/example/1234", meta.LeaseID)
+	assert.Equal(t, "39:dd:2e", meta.SerialNumber)
+	if assert.NotNil(t, meta.Expires) {
+		assert.Equal(t, now.Add(time.Hour), *meta.Expires)
+	}
+
+	// step: a secret without a lease has no expiry
+	x.secret = &api.Secret{LeaseID: "raw", Data: map[string]interface{}{}}
+	meta = newSecretMetadata(x)
+	assert.Empty(t, meta.LeaseID)
+	assert.Nil(t, meta.Expires)
+}
+
+func TestWriteMetadataFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meta")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+	meta := &secretMetadata{Resource: "mysql", Path: "mysql/creds/app", LeaseID: "mysql/creds/app/1", Version: 2}
+	if !assert.NoError(t, writeMetadataFile(filename, meta, 0600)) {
+		t.FailNow()
+	}
+	content, err := ioutil.ReadFile(filename + ".meta.json")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	decoded := make(map[string]interface{}, 0)
+	assert.NoError(t, json.Unmarshal(content, &decoded))
+	assert.Equal(t, "mysql/creds/app/1", decoded["lease_id"])
+	assert.Equal(t, float64(2), decoded["version"])
+	assert.NotContains(t, decoded, "serial_number")
+}
+
+func TestSecretMetadataTimeFormat(t *testing.T) {
+	defer func(format string) { options.timeFormat = format }(options.timeFormat)
+
+	issued := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	expires := issued.Add(time.Hour)
+	meta := &secretMetadata{
+		Resource:      "mysql",
+		Path:          "mysql/creds/app",
+		Issued:        issued,
+		Updated:       issued.Add(time.Minute),
+		Expires:       &expires,
+		LeaseDuration: time.Hour,
+		Renewals:      2,
+		Source:        sourceVault,
+	}
+	cases := []struct {
+		Format   string
+		Issued   interface{}
+		Duration interface{}
+	}{
+		{Format: timeFormatRFC3339, Issued: "2020-03-01T12:00:00Z", Duration: "1h0m0s"},
+		{Format: timeFormatUnix, Issued: float64(issued.Unix()), Duration: float64(3600)},
+	}
+	for i, c := range cases {
+		options.timeFormat = c.Format
+		content, err := json.Marshal(meta)
+		if !assert.NoError(t, err, "case %d", i) {
+			continue
+		}
+		decoded := make(map[string]interface{}, 0)
+		assert.NoError(t, json.Unmarshal(content, &decoded), "case %d", i)
+		assert.Equal(t, c.Issued, decoded["issued"], "case %d", i)
+		assert.Equal(t, c.Duration, decoded["lease_duration"], "case %d", i)
+		assert.Equal(t, float64(2), decoded["renewals"], "case %d", i)
+		assert.Equal(t, "vault", decoded["source"], "case %d", i)
+
+		// step: the metadata reads back in either format
+		var parsed secretMetadata
+		if assert.NoError(t, json.Unmarshal(content, &parsed), "case %d", i) {
+			assert.True(t, issued.Equal(parsed.Issued), "case %d", i)
+			assert.Equal(t, time.Hour, parsed.LeaseDuration, "case %d", i)
+			if assert.NotNil(t, parsed.Expires, "case %d", i) {
+				assert.True(t, expires.Equal(*parsed.Expires), "case %d", i)
+			}
+		}
+	}
+
+	status := resourceStatus{ID: "mysql:mysql/creds/app", LastSuccess: &issued}
+	options.timeFormat = timeFormatUnix
+	content, err := json.Marshal(status)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), fmt.Sprintf(`"last_success":%d`, issued.Unix()))
+	assert.NotContains(t, string(content), "last_failure")
+	var parsed resourceStatus
+	if assert.NoError(t, json.Unmarshal(content, &parsed)) && assert.NotNil(t, parsed.LastSuccess) {
+		assert.True(t, issued.Equal(*parsed.LastSuccess))
+	}
+}
+
+func TestSecretMetadataRenewals(t *testing.T) {
+	rn := defaultVaultResource()
+	rn.resource = "mysql"
+	rn.path = "mysql/creds/app"
+	x := &watchedResource{
+		resource: rn,
+		renewals: 3,
+		source:   sourceState,
+		secret:   &api.Secret{LeaseID: "mysql/creds/app/1", LeaseDuration: 600, Renewable: true},
+	}
+	meta := newSecretMetadata(x)
+	assert.Equal(t, 3, meta.Renewals)
+	assert.Equal(t, sourceState, meta.Source)
+	assert.Equal(t, time.Duration(10)*time.Minute, meta.LeaseDuration)
+}
+
+func TestTimeFormatOption(t *testing.T) {
+	for _, format := range []string{"", timeFormatRFC3339, timeFormatUnix} {
+		assert.NoError(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", timeFormat: format}), format)
+	}
+	assert.Error(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", timeFormat: "epoch"}))
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// the metric names
+	metricFetches   = "vault_sidekick_fetch_total"
+	metricRenewals  = "vault_sidekick_renew_total"
+	metricWrites    = "vault_sidekick_write_total"
+	metricExpiry    = "vault_sidekick_lease_expiry_timestamp_seconds"
+	metricResources = "vault_sidekick_resources"
+	metricTampered  = "vault_sidekick_file_tampered_total"
+	metricHooks     = "vault_sidekick_hook_total"
+
+	metricPolicyViolations = "vault_sidekick_policy_violations_total"
+
+	metricScanFindings = "vault_sidekick_scan_findings_total"
+	metricFileAccess   = "vault_sidekick_file_access_total"
+
+	metricHedgedReads = "vault_sidekick_hedged_reads_total"
+	metricRetryAfter  = "vault_sidekick_retry_after_total"
+	metricKVDeleted   = "vault_sidekick_kv_deleted"
+
+	metricVaultInitialized    = "vault_sidekick_vault_initialized"
+	metricVaultSealed         = "vault_sidekick_vault_sealed"
+	metricVaultStandby        = "vault_sidekick_vault_standby"
+	metricVaultUnsealProgress = "vault_sidekick_vault_unseal_progress"
+)
+
+// metrics is the global registry, exposed in the prometheus text format on the admin endpoint
+var metrics = newMetricsRegistry()
+
+// metricsRegistry is a minimal registry of counters and gauges
+type metricsRegistry struct {
+	sync.Mutex
+	// the registered metrics by name
+	items map[string]*metric
+}
+
+// metric is a counter or gauge with a value per label set
+type metric struct {
+	// the help text of the metric
+	help string
+	// counter or gauge
+	kind string
+	// the values keyed by the encoded labels
+	values map[string]float64
+}
+
+// newMetricsRegistry creates the registry with the metrics of the sidekick
+func newMetricsRegistry() *metricsRegistry {
+	m := &metricsRegistry{items: make(map[string]*metric, 0)}
+	m.register(metricFetches, "counter", "the number of retrievals of a resource from vault")
+	m.register(metricRenewals, "counter", "the number of lease renewals of a resource")
+	m.register(metricWrites, "counter", "the number of writes of a resource to disk")
+	m.register(metricExpiry, "gauge", "the time the lease of a resource expires, in seconds since the epoch")
+	m.register(metricResources, "gauge", "the number of resources being watched")
+	m.register(metricTampered, "counter", "the number of files rewritten after being deleted or modified by another process")
+	m.register(metricScanFindings, "counter", "the number of out of place files found in the output directory, by the kind of finding")
+	m.register(metricFileAccess, "counter", "the number of opens of the files written by other processes, by file and command")
+	m.register(metricHedgedReads, "counter", "the number of hedged reads, by whether the first or the hedged attempt answered first")
+	m.register(metricRetryAfter, "counter", "the number of requests retried after the time asked for by vault")
+	m.register(metricKVDeleted, "gauge", "whether the current version of a kv v2 secret has been deleted or destroyed upstream, the last good copy being kept")
+	m.register(metricVaultInitialized, "gauge", "whether vault is initialized, as polled by a health resource")
+	m.register(metricVaultSealed, "gauge", "whether vault is sealed, as polled by a health resource")
+	m.register(metricVaultStandby, "gauge", "whether the vault node is a standby, as polled by a health resource")
+	m.register(metricVaultUnsealProgress, "gauge", "the number of unseal keys provided to a sealed vault")
+
+	return m
+}
+
+// register adds a metric to the registry
+func (m *metricsRegistry) register(name, kind, help string) {
+	m.items[name] = &metric{help: help, kind: kind, values: make(map[string]float64, 0)}
+}
+
+// add increments a metric
+//	name		: the name of the metric
+//	value		: the value to add
+//	labels		: a list of label names and values i.e. "resource", "pki"
+func (m *metricsRegistry) add(name string, value float64, labels ...string) {
+	m.Lock()
+	defer m.Unlock()
+	if x, found := m.items[name]; found {
+		x.values[encodeLabels(labels)] += value
+	}
+}
+
+// set sets the value of a metric
+//	name		: the name of the metric
+//	value		: the value of the metric
+//	labels		: a list of label names and values i.e. "resource", "pki"
+func (m *metricsRegistry) set(name string, value float64, labels ...string) {
+	m.Lock()
+	defer m.Unlock()
+	if x, found := m.items[name]; found {
+		x.values[encodeLabels(labels)] = value
+	}
+}
+
+// render writes the metrics in the prometheus text format
+func (m *metricsRegistry) render(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+
+	var names []string
+	for name := range m.items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		x := m.items[name]
+		fmt.Fprintf(w, "# HELP %s %s\n", name, x.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, x.kind)
+		var keys []string
+		for key := range x.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s%s %s\n", name, key, strconv.FormatFloat(x.values[key], 'f', -1, 64))
+		}
+	}
+}
+
+// encodeLabels converts a list of label names and values into the prometheus format
+func encodeLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	var list []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(labels[i+1])
+		list = append(list, fmt.Sprintf("%s=\"%s\"", labels[i], value))
+	}
+
+	return "{" + strings.Join(list, ",") + "}"
+}
+
+// statusLabel returns the status label for the outcome of an operation
+func statusLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+
+	return "success"
+}
+
+// resourceLabels returns the labels identifying a resource
+func resourceLabels(rn *VaultResource, extra ...string) []string {
+	return append([]string{"resource", rn.resource, "path", rn.path}, extra...)
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsRender(t *testing.T) {
+	m := newMetricsRegistry()
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "secret/app"
+
+	m.add(metricFetches, 1, resourceLabels(rn, "status", statusLabel(nil))...)
+	m.add(metricFetches, 1, resourceLabels(rn, "status", statusLabel(nil))...)
+	m.add(metricFetches, 1, resourceLabels(rn, "status", statusLabel(errors.New("failed")))...)
+	m.set(metricExpiry, 1700000000, resourceLabels(rn)...)
+	m.set(metricResources, 2)
+	m.add("unknown_metric", 1)
+
+	output := &bytes.Buffer{}
+	m.render(output)
+	content := output.String()
+	assert.Contains(t, content, "# TYPE vault_sidekick_fetch_total counter\n")
+	assert.Contains(t, content, `vault_sidekick_fetch_total{resource="secret",path="secret/app",status="success"} 2`+"\n")
+	assert.Contains(t, content, `vault_sidekick_fetch_total{resource="secret",path="secret/app",status="failure"} 1`+"\n")
+	assert.Contains(t, content, `vault_sidekick_lease_expiry_timestamp_seconds{resource="secret",path="secret/app"} 1700000000`+"\n")
+	assert.Contains(t, content, "vault_sidekick_resources 2\n")
+	assert.NotContains(t, content, "unknown_metric")
+}
+
+func TestEncodeLabels(t *testing.T) {
+	assert.Equal(t, "", encodeLabels(nil))
+	assert.Equal(t, `{path="a\"b\\c"}`, encodeLabels([]string{"path", `a"b\c`}))
+}
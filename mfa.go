@@ -0,0 +1,240 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+// loginResponse is the auth section of the response of a login, holding either the token or the mfa
+// which must be validated before a token is issued
+type loginResponse struct {
+	Auth *struct {
+		ClientToken    string          `json:"client_token"`
+		MFARequirement *mfaRequirement `json:"mfa_requirement"`
+	} `json:"auth"`
+}
+
+// mfaRequirement is the mfa enforced on a login
+type mfaRequirement struct {
+	// the id of the request to validate
+	RequestID string `json:"mfa_request_id"`
+	// the constraints, each satisfied by any of its methods
+	Constraints map[string]struct {
+		Any []mfaMethod `json:"any"`
+	} `json:"mfa_constraints"`
+}
+
+// mfaMethod is a method satisfying an mfa constraint
+type mfaMethod struct {
+	// the type of the method i.e. totp, duo, okta or pingid
+	Type string `json:"type"`
+	// the id of the method
+	ID string `json:"id"`
+	// whether a passcode is required, otherwise the method is a push
+	UsesPasscode bool `json:"uses_passcode"`
+}
+
+// loginToken reads the token from the response of a login, completing the mfa validation if the login requires it
+//	client		: the vault client
+//	body		: the body of the login response
+func loginToken(client *api.Client, body io.Reader) (string, error) {
+	var resp loginResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return "", err
+	}
+	if resp.Auth == nil {
+		return "", fmt.Errorf("no auth was returned by the login")
+	}
+	if resp.Auth.MFARequirement != nil {
+		return validateMFA(client, resp.Auth.MFARequirement)
+	}
+
+	return resp.Auth.ClientToken, nil
+}
+
+// writeLogin makes a login request to the path, returning the token
+//	client		: the vault client
+//	path		: the login path i.e. auth/aws/login
+//	payload		: the body of the login
+func writeLogin(client *api.Client, path string, payload interface{}) (string, error) {
+	request := client.NewRequest("PUT", "/v1/"+path)
+	if err := request.SetJSONBody(payload); err != nil {
+		return "", err
+	}
+	resp, err := client.RawRequest(request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	return loginToken(client, resp.Body)
+}
+
+// validateMFA completes the mfa of a login with the first method of each constraint, returning the token
+//	client		: the vault client
+//	requirement	: the mfa required by the login
+func validateMFA(client *api.Client, requirement *mfaRequirement) (string, error) {
+	var names []string
+	for name := range requirement.Constraints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	payload := make(map[string][]string, 0)
+	for _, name := range names {
+		methods := requirement.Constraints[name].Any
+		if len(methods) == 0 {
+			continue
+		}
+		method := methods[0]
+		passcode := ""
+		if method.UsesPasscode {
+			var err error
+			if passcode, err = mfaPasscode(name, method); err != nil {
+				return "", err
+			}
+		}
+		payload[method.ID] = []string{passcode}
+	}
+	glog.Infof("the login requires mfa, validating the constraints: %s", strings.Join(names, ", "))
+
+	request := client.NewRequest("POST", "/v1/sys/mfa/validate")
+	if err := request.SetJSONBody(map[string]interface{}{"mfa_request_id": requirement.RequestID, "mfa_payload": payload}); err != nil {
+		return "", err
+	}
+	resp, err := client.RawRequest(request)
+	if err != nil {
+		return "", fmt.Errorf("the mfa validation failed, error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var validated loginResponse
+	if err := json.NewDecoder(resp.Body).Decode(&validated); err != nil {
+		return "", err
+	}
+	if validated.Auth == nil || validated.Auth.ClientToken == "" {
+		return "", fmt.Errorf("no token was returned by the mfa validation")
+	}
+
+	return validated.Auth.ClientToken, nil
+}
+
+// mfaPasscode obtains the passcode of the method from the passcode file, a code generated from the totp secret
+// or, when the sidekick is run interactively, a prompt
+//	name		: the name of the constraint
+//	method		: the mfa method
+func mfaPasscode(name string, method mfaMethod) (string, error) {
+	switch {
+	case options.mfaPasscodeFile != "":
+		content, err := ioutil.ReadFile(options.mfaPasscodeFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read the mfa passcode, error: %s", err)
+		}
+		return strings.TrimSpace(string(content)), nil
+	case options.mfaTOTPFile != "":
+		content, err := ioutil.ReadFile(options.mfaTOTPFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read the mfa totp secret, error: %s", err)
+		}
+		return totpCode(strings.TrimSpace(string(content)), time.Now())
+	case isTerminal(os.Stdin):
+		fmt.Fprintf(os.Stderr, "enter the passcode of the %s mfa method %s: ", method.Type, name)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(line), nil
+	}
+
+	return "", fmt.Errorf("the login requires a passcode for the %s mfa method %s, set -mfa-passcode-file or -mfa-totp-file", method.Type, name)
+}
+
+// totpCode generates the code of a totp secret at the time, as per rfc 6238; the secret is either the base32 key
+// or an otpauth:// uri carrying the secret, digits, period and algorithm
+//	secret		: the totp secret
+//	now			: the time of the code
+func totpCode(secret string, now time.Time) (string, error) {
+	digits, period, algorithm := 6, 30, "SHA1"
+	if strings.HasPrefix(secret, "otpauth://") {
+		u, err := url.Parse(secret)
+		if err != nil {
+			return "", fmt.Errorf("the totp uri is invalid, error: %s", err)
+		}
+		query := u.Query()
+		secret = query.Get("secret")
+		if x, err := strconv.Atoi(query.Get("digits")); err == nil && x > 0 {
+			digits = x
+		}
+		if x, err := strconv.Atoi(query.Get("period")); err == nil && x > 0 {
+			period = x
+		}
+		if x := query.Get("algorithm"); x != "" {
+			algorithm = strings.ToUpper(x)
+		}
+	}
+	algorithms := map[string]func() hash.Hash{"SHA1": sha1.New, "SHA256": sha256.New, "SHA512": sha512.New}
+	fn, found := algorithms[algorithm]
+	if !found {
+		return "", fmt.Errorf("the totp algorithm: %s is not supported", algorithm)
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(strings.TrimRight(strings.Replace(secret, " ", "", -1), "=")))
+	if err != nil {
+		return "", fmt.Errorf("the totp secret is not valid base32, error: %s", err)
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(now.Unix()/int64(period)))
+	mac := hmac.New(fn, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, code%modulo), nil
+}
+
+// isTerminal checks if the file is a terminal
+func isTerminal(file *os.File) bool {
+	stat, err := file.Stat()
+
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// step: the test vectors of rfc 6238, the secret being the ascii 12345678901234567890
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := []struct {
+		Secret string
+		Time   int64
+		Code   string
+	}{
+		{Secret: "otpauth://totp/test?secret=" + secret + "&digits=8", Time: 59, Code: "94287082"},
+		{Secret: "otpauth://totp/test?secret=" + secret + "&digits=8", Time: 1111111109, Code: "07081804"},
+		{Secret: "otpauth://totp/test?secret=" + secret + "&digits=8", Time: 2000000000, Code: "69279037"},
+		{Secret: secret, Time: 59, Code: "287082"},
+		{Secret: strings.ToLower(secret), Time: 1234567890, Code: "005924"},
+	}
+	for i, c := range cases {
+		code, err := totpCode(c.Secret, time.Unix(c.Time, 0))
+		assert.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.Code, code, "case %d", i)
+	}
+	_, err := totpCode("not base32!", time.Now())
+	assert.Error(t, err)
+	_, err = totpCode("otpauth://totp/test?secret="+secret+"&algorithm=MD5", time.Now())
+	assert.Error(t, err)
+}
+
+func TestLoginTokenMFA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mfa")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	passcodeFile := filepath.Join(dir, "passcode")
+	assert.NoError(t, ioutil.WriteFile(passcodeFile, []byte("123456\n"), 0600))
+	original := options.mfaPasscodeFile
+	options.mfaPasscodeFile = passcodeFile
+	defer func() { options.mfaPasscodeFile = original }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			RequestID string              `json:"mfa_request_id"`
+			Payload   map[string][]string `json:"mfa_payload"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/v1/sys/mfa/validate" || body.RequestID != "req-1" || body.Payload["totp-id"][0] != "123456" || body.Payload["duo-id"][0] != "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"auth":{"client_token":"s.validated"}}`))
+	}))
+	defer server.Close()
+	client, err := api.NewClient(&api.Config{Address: server.URL, HttpClient: server.Client()})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// step: a login without mfa returns the token as is
+	token, err := loginToken(client, strings.NewReader(`{"auth":{"client_token":"s.plain"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "s.plain", token)
+
+	// step: a login requiring mfa is validated with the passcode
+	token, err = loginToken(client, strings.NewReader(`{"auth":{"client_token":"","mfa_requirement":{"mfa_request_id":"req-1",
+		"mfa_constraints":{"otp":{"any":[{"type":"totp","id":"totp-id","uses_passcode":true}]},"push":{"any":[{"type":"duo","id":"duo-id"}]}}}}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "s.validated", token)
+
+	// step: a wrong passcode fails the login
+	assert.NoError(t, ioutil.WriteFile(passcodeFile, []byte("000000"), 0600))
+	_, err = loginToken(client, strings.NewReader(`{"auth":{"mfa_requirement":{"mfa_request_id":"req-1",
+		"mfa_constraints":{"otp":{"any":[{"type":"totp","id":"totp-id","uses_passcode":true}]}}}}}`))
+	assert.Error(t, err)
+}
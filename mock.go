@@ -0,0 +1,228 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// mockToken is the token handed out by the mock vault
+	mockToken = "mock-token"
+	// mockLeaseDuration is the lease duration given on renewals and logins
+	mockLeaseDuration = 3600
+)
+
+// mockVault is a fake vault service serving canned responses from a fixtures directory; a
+// read of secret/db is answered by the file secret/db.json, which holds a vault response i.e.
+//	{ "lease_id": "", "lease_duration": 60, "renewable": false, "data": { "password": "test" } }
+type mockVault struct {
+	sync.Mutex
+	// the directory containing the fixtures
+	fixtures string
+	// the secrets written to the mock
+	written map[string]map[string]interface{}
+}
+
+// startMockVault starts a mock vault on a random localhost port, returning the url
+//	fixtures	: the directory containing the canned responses
+func startMockVault(fixtures string) (string, error) {
+	if info, err := os.Stat(fixtures); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("the mock fixtures directory: %s does not exist", fixtures)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	mock := &mockVault{
+		fixtures: fixtures,
+		written:  make(map[string]map[string]interface{}, 0),
+	}
+	go func() {
+		if err := http.Serve(listener, mock); err != nil {
+			glog.Errorf("the mock vault has stopped, error: %s", err)
+		}
+	}()
+
+	return fmt.Sprintf("http://%s", listener.Addr().String()), nil
+}
+
+// ServeHTTP handles the requests to the mock vault
+func (m *mockVault) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/v1/"), "/")
+	glog.V(10).Infof("mock vault request, method: %s, path: %s", req.Method, path)
+
+	switch {
+	case path == "sys/health":
+		m.respond(w, http.StatusOK, map[string]interface{}{"initialized": true, "sealed": false, "standby": false})
+	case strings.HasPrefix(path, "auth/") && strings.Contains(path, "login"):
+		m.respond(w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token":   mockToken,
+				"accessor":       "mock-accessor",
+				"lease_duration": mockLeaseDuration,
+				"renewable":      true,
+			},
+		})
+	case path == "auth/token/lookup-self" || path == "auth/token/renew-self":
+		m.respond(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{
+				"id":        mockToken,
+				"accessor":  "mock-accessor",
+				"policies":  []string{"default"},
+				"ttl":       mockLeaseDuration,
+				"renewable": true,
+			},
+			"auth": map[string]interface{}{"client_token": mockToken, "lease_duration": mockLeaseDuration, "renewable": true},
+		})
+	case path == "auth/token/revoke-self":
+		w.WriteHeader(http.StatusNoContent)
+	case path == "sys/leases/renew" || path == "sys/renew":
+		request := make(map[string]interface{}, 0)
+		json.NewDecoder(req.Body).Decode(&request)
+		m.respond(w, http.StatusOK, map[string]interface{}{
+			"lease_id":       request["lease_id"],
+			"lease_duration": mockLeaseDuration,
+			"renewable":      true,
+		})
+	case strings.HasPrefix(path, "sys/leases/revoke") || strings.HasPrefix(path, "sys/revoke"):
+		w.WriteHeader(http.StatusNoContent)
+	case strings.Contains("/"+path+"/", rotateRolePath):
+		w.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodGet && req.URL.Query().Get("list") == "true":
+		m.list(w, path)
+	case req.Method == http.MethodGet:
+		m.read(w, path)
+	case req.Method == http.MethodPut || req.Method == http.MethodPost:
+		m.write(w, req, path)
+	case req.Method == http.MethodDelete:
+		m.Lock()
+		delete(m.written, path)
+		m.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		m.respond(w, http.StatusMethodNotAllowed, map[string]interface{}{"errors": []string{"unsupported method"}})
+	}
+}
+
+// read answers a read from the written secrets or the fixtures
+func (m *mockVault) read(w http.ResponseWriter, path string) {
+	m.Lock()
+	data, found := m.written[path]
+	m.Unlock()
+	if found {
+		m.respond(w, http.StatusOK, map[string]interface{}{"data": data})
+		return
+	}
+	m.fixture(w, path)
+}
+
+// write records the secret, the response is the fixture for the path if one exists i.e. pki issue
+func (m *mockVault) write(w http.ResponseWriter, req *http.Request, path string) {
+	if exists, _ := fileExists(m.fixturePath(path)); exists {
+		m.fixture(w, path)
+		return
+	}
+	data := make(map[string]interface{}, 0)
+	if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+		m.respond(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{err.Error()}})
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	if options, found := data["options"].(map[string]interface{}); found && strings.Contains(path, "/data/") {
+		m.writeKV(w, path, data["data"], options["cas"])
+		return
+	}
+	m.written[path] = data
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeKV records a kv v2 secret, honouring the check-and-set version; the lock must be held
+func (m *mockVault) writeKV(w http.ResponseWriter, path string, data, cas interface{}) {
+	version := 0
+	if metadata, found := m.written[path]["metadata"].(map[string]interface{}); found {
+		version = toInt(metadata["version"])
+	}
+	if cas != nil && toInt(cas) != version {
+		m.respond(w, http.StatusBadRequest, map[string]interface{}{
+			"errors": []string{"check-and-set parameter did not match the current version"},
+		})
+		return
+	}
+	version++
+	m.written[path] = map[string]interface{}{"data": data, "metadata": map[string]interface{}{"version": version}}
+	m.written[strings.Replace(path, "/data/", "/metadata/", 1)] = map[string]interface{}{"current_version": version}
+
+	m.respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"version": version}})
+}
+
+// list answers a list request from the fixtures directory structure
+func (m *mockVault) list(w http.ResponseWriter, path string) {
+	files, err := ioutil.ReadDir(filepath.Join(m.fixtures, filepath.FromSlash(path)))
+	if err != nil {
+		m.respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+		return
+	}
+	var keys []string
+	for _, x := range files {
+		switch {
+		case x.IsDir():
+			keys = append(keys, x.Name()+"/")
+		case strings.HasSuffix(x.Name(), ".json"):
+			keys = append(keys, strings.TrimSuffix(x.Name(), ".json"))
+		}
+	}
+	sort.Strings(keys)
+
+	m.respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+}
+
+// fixture responds with the content of the fixture file for the path
+func (m *mockVault) fixture(w http.ResponseWriter, path string) {
+	content, err := ioutil.ReadFile(m.fixturePath(path))
+	if err != nil {
+		m.respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// fixturePath returns the path of the fixture file for a vault path
+func (m *mockVault) fixturePath(path string) string {
+	return filepath.Join(m.fixtures, filepath.FromSlash(path)+".json")
+}
+
+// respond encodes the response as json
+func (m *mockVault) respond(w http.ResponseWriter, code int, content interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(content)
+}
@@ -0,0 +1,430 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+// newMockService creates a vault service speaking to a mock vault serving the test fixtures
+func newMockService(t *testing.T) (*VaultService, chan VaultEvent) {
+	url, err := startMockVault("tests/fixtures")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	options.vaultURL = url
+	options.vaultAuthFile = ""
+	options.vaultAuthOptions = &vaultAuthOptions{Method: "token", Token: mockToken}
+	options.statsInterval = time.Hour
+
+	service, err := NewVaultService(url, options.vaultAuthOptions, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// step: the processor reads the options, so it's stopped before the next test changes them
+	t.Cleanup(service.Stop)
+	updates := make(chan VaultEvent, 10)
+	service.AddListener(updates)
+
+	return service, updates
+}
+
+// waitForEvent waits for the next event on the channel
+func waitForEvent(t *testing.T, updates chan VaultEvent) VaultEvent {
+	select {
+	case evt := <-updates:
+		return evt
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for an event from the vault service")
+	}
+
+	return VaultEvent{}
+}
+
+func TestMockVaultGoldenFiles(t *testing.T) {
+	service, updates := newMockService(t)
+	dir, err := ioutil.TempDir("", "golden")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	options.outputDir = dir
+	defer func() { options.outputDir = "" }()
+
+	cases := []struct {
+		Resource string
+		Files    []string
+	}{
+		{Resource: "secret:secret/app:fmt=json,file=app.json", Files: []string{"app.json"}},
+		{Resource: "secret:secret/app:fmt=yaml,file=app.yaml", Files: []string{"app.yaml"}},
+		{Resource: "secret:secret/app:fmt=ini,section=database,file=app.ini", Files: []string{"app.ini"}},
+		{Resource: "secret:secret/app:fmt=csv,delimiter=;,file=app.csv", Files: []string{"app.csv"}},
+		{Resource: "secret:secret/single:fmt=env,file=single.env", Files: []string{"single.env"}},
+		{Resource: "secret:secret/single:fmt=txt,file=single.txt", Files: []string{"single.txt"}},
+		{Resource: "mysql:mysql/creds/app:fmt=json,file=mysql.json", Files: []string{"mysql.json"}},
+		{Resource: "secret:secret/layers/common,secret/layers/prod:fmt=json,file=layers.json", Files: []string{"layers.json"}},
+		{Resource: "secret:secret/layers/common,secret/layers/prod:fmt=yaml,documents=true,file=layers.yaml", Files: []string{"layers.yaml"}},
+		{
+			Resource: "tpl:secret/app,secret/single:tpl=tests/templates/app.yaml.tmpl,values=tests/templates/values.yaml|tests/templates/values-prod.yaml,file=app.conf",
+			Files:    []string{"app.conf"},
+		},
+		{
+			Resource: "pki:pki/issue/example:common_name=app.example.com,fmt=bundle,file=tls",
+			Files:    []string{"tls.pem", "tls-key.pem", "tls-ca.pem", "tls-bundle.pem"},
+		},
+	}
+	for i, c := range cases {
+		var items VaultResources
+		if !assert.NoError(t, items.Set(c.Resource), "case %d, unable to parse the resource", i) {
+			t.FailNow()
+		}
+		service.Watch(items.items[0])
+
+		evt := waitForEvent(t, updates)
+		if !assert.Equal(t, EventTypeSuccess, evt.Type, "case %d, the resource was not retrieved", i) {
+			t.FailNow()
+		}
+		if !assert.NoError(t, processResource(evt.Resource, evt.Secret, evt.Metadata), "case %d, unable to write the resource", i) {
+			t.FailNow()
+		}
+
+		for _, name := range c.Files {
+			content, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if !assert.NoError(t, err, "case %d, the file: %s was not written", i, name) {
+				continue
+			}
+			golden := filepath.Join("tests/golden", name)
+			if *updateGolden {
+				if !assert.NoError(t, ioutil.WriteFile(golden, content, 0644)) {
+					t.FailNow()
+				}
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if assert.NoError(t, err, "case %d, missing golden file: %s", i, golden) {
+				assert.Equal(t, string(expected), string(content), "case %d, file: %s does not match the golden file", i, name)
+			}
+		}
+	}
+}
+
+func TestMockVaultRenewal(t *testing.T) {
+	service, _ := newMockService(t)
+
+	rn := defaultVaultResource()
+	rn.resource = "mysql"
+	rn.path = "mysql/creds/app"
+	x := &watchedResource{resource: rn}
+	if !assert.NoError(t, service.get(x)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "v-app-8f53", x.secret.Data["username"])
+	assert.True(t, x.secret.Renewable)
+
+	if !assert.NoError(t, service.renew(x)) {
+		t.FailNow()
+	}
+	assert.Equal(t, mockLeaseDuration, x.secret.LeaseDuration)
+	assert.NoError(t, service.revoke(x.secret.LeaseID))
+}
+
+func TestMockVaultCreate(t *testing.T) {
+	service, _ := newMockService(t)
+
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "secret/generated"
+	rn.create = true
+	rn.size = 12
+	x := &watchedResource{resource: rn}
+	if !assert.NoError(t, service.get(x)) {
+		t.FailNow()
+	}
+	assert.Len(t, x.secret.Data["value"], 12)
+
+	// step: a second read should return the same generated secret
+	y := &watchedResource{resource: rn}
+	if !assert.NoError(t, service.get(y)) {
+		t.FailNow()
+	}
+	assert.Equal(t, x.secret.Data["value"], y.secret.Data["value"])
+}
+
+func TestMockVaultPKIRoleTTL(t *testing.T) {
+	service, _ := newMockService(t)
+
+	rn := defaultVaultResource()
+	rn.resource = "pki"
+	rn.path = "pki/issue/example"
+	rn.update = 48 * time.Hour
+	x := &watchedResource{resource: rn}
+
+	// step: the role in the fixtures has a max ttl of a day
+	params := map[string]interface{}{"common_name": "app.example.com", "ttl": "720h"}
+	service.clampPKITTL(x, params)
+	assert.True(t, x.roleChecked)
+	assert.Equal(t, 24*time.Hour, x.roleMaxTTL)
+	assert.Equal(t, "86400s", params["ttl"])
+
+	// step: a ttl within the max should be left alone
+	params = map[string]interface{}{"common_name": "app.example.com", "ttl": "1h"}
+	service.clampPKITTL(x, params)
+	assert.Equal(t, "1h", params["ttl"])
+
+	// step: a role which cannot be read should not clamp the request
+	rn.path = "pki/issue/missing"
+	y := &watchedResource{resource: rn}
+	params = map[string]interface{}{"ttl": "720h"}
+	service.clampPKITTL(y, params)
+	assert.Equal(t, time.Duration(0), y.roleMaxTTL)
+	assert.Equal(t, "720h", params["ttl"])
+}
+
+func TestMockVaultPaused(t *testing.T) {
+	original := pausedInterval
+	pausedInterval = time.Duration(20) * time.Millisecond
+	defer func() { pausedInterval = original }()
+	service, updates := newMockService(t)
+
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "secret/single"
+	registry.register(rn)
+	registry.pause(rn.ID(), true)
+	service.Watch(rn)
+
+	// step: nothing should be retrieved while paused
+	select {
+	case evt := <-updates:
+		t.Fatalf("the paused resource: %s was retrieved", evt.Resource)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	registry.pause(rn.ID(), false)
+	evt := waitForEvent(t, updates)
+	assert.Equal(t, EventTypeSuccess, evt.Type)
+	assert.Equal(t, "0123456789abcdef", evt.Secret["api_key"])
+}
+
+func TestMockVaultKVVersion2(t *testing.T) {
+	service, _ := newMockService(t)
+
+	publish := func(version int, password string) {
+		_, err := service.client.Logical().Write("kv/data/app", map[string]interface{}{
+			"data":     map[string]interface{}{"password": password},
+			"metadata": map[string]interface{}{"version": version},
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		_, err = service.client.Logical().Write("kv/metadata/app", map[string]interface{}{"current_version": version})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "kv/app"
+	rn.kvVersion = 2
+	x := &watchedResource{resource: rn}
+
+	publish(1, "first")
+	if !assert.NoError(t, service.get(x)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "first", x.secret.Data["password"])
+	assert.Equal(t, 1, x.version)
+
+	// step: the version has not changed so the data should not be read
+	assert.Equal(t, errSecretUnchanged, service.get(x))
+
+	publish(2, "second")
+	if !assert.NoError(t, service.get(x)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "second", x.secret.Data["password"])
+	assert.Equal(t, 2, x.version)
+}
+
+func TestMockVaultWildcard(t *testing.T) {
+	service, updates := newMockService(t)
+	dir, err := ioutil.TempDir("", "wildcard")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	options.outputDir = dir
+	defer func() { options.outputDir = "" }()
+
+	var items VaultResources
+	if !assert.NoError(t, items.Set("secret:secret/flags/*:fmt=txt,file={key}.flag")) {
+		t.FailNow()
+	}
+	rn := items.items[0]
+	if !assert.NoError(t, rn.IsValid()) {
+		t.FailNow()
+	}
+	service.Watch(rn)
+
+	evt := waitForEvent(t, updates)
+	if !assert.Equal(t, EventTypeSuccess, evt.Type) {
+		t.FailNow()
+	}
+	if !assert.NoError(t, processResource(evt.Resource, evt.Secret, evt.Metadata)) {
+		t.FailNow()
+	}
+	for name, expected := range map[string]string{"feature_a.flag": "enabled", "feature_b.flag": "disabled"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if assert.NoError(t, err, "the file: %s was not written", name) {
+			assert.Equal(t, expected, string(content))
+		}
+	}
+
+	// step: a child removed upstream should have its file removed
+	delete(evt.Secret, "feature_b")
+	if !assert.NoError(t, processResource(evt.Resource, evt.Secret, evt.Metadata)) {
+		t.FailNow()
+	}
+	exists, _ := fileExists(filepath.Join(dir, "feature_b.flag"))
+	assert.False(t, exists)
+	exists, _ = fileExists(filepath.Join(dir, "feature_a.flag"))
+	assert.True(t, exists)
+}
+
+func TestMockVaultKVCreate(t *testing.T) {
+	service, _ := newMockService(t)
+
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "kv/generated"
+	rn.kvVersion = 2
+	rn.create = true
+	rn.size = 12
+	assert.NoError(t, rn.IsValid())
+
+	// step: the secret is created with check-and-set
+	x := &watchedResource{resource: rn}
+	if !assert.NoError(t, service.get(x)) {
+		t.FailNow()
+	}
+	assert.Len(t, x.secret.Data["value"], 12)
+	assert.Equal(t, 1, x.version)
+
+	// step: a second sidekick reads the same value
+	y := &watchedResource{resource: rn}
+	if !assert.NoError(t, service.get(y)) {
+		t.FailNow()
+	}
+	assert.Equal(t, x.secret.Data["value"], y.secret.Data["value"])
+
+	// step: a secret missing the value is patched, keeping the other keys
+	_, err := service.client.Logical().Write("kv/data/patched", map[string]interface{}{
+		"options": map[string]interface{}{"cas": 0},
+		"data":    map[string]interface{}{"username": "app"},
+	})
+	assert.NoError(t, err)
+	patched := rn.clone()
+	patched.path = "kv/patched"
+	z := &watchedResource{resource: patched}
+	if !assert.NoError(t, service.get(z)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "app", z.secret.Data["username"])
+	assert.Len(t, z.secret.Data["value"], 12)
+	assert.Equal(t, 2, z.version)
+
+	// step: losing the race to another writer reads the secret written
+	_, err = service.client.Logical().Write("kv/data/raced", map[string]interface{}{
+		"options": map[string]interface{}{"cas": 0},
+		"data":    map[string]interface{}{"value": "theirs"},
+	})
+	assert.NoError(t, err)
+	raced := rn.clone()
+	raced.path = "kv/raced"
+	secret, version, err := service.createKV(&watchedResource{resource: raced}, "kv/data/raced", nil, 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "theirs", secret.Data["value"])
+		assert.Equal(t, 1, version)
+	}
+}
+
+func TestMockVaultKVDeleted(t *testing.T) {
+	service, _ := newMockService(t)
+
+	write := func(path string, data map[string]interface{}) {
+		if _, err := service.client.Logical().Write(path, data); !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	write("kv/data/deleted", map[string]interface{}{
+		"data":     map[string]interface{}{"password": "first"},
+		"metadata": map[string]interface{}{"version": 1},
+	})
+	write("kv/metadata/deleted", map[string]interface{}{"current_version": 1})
+
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "kv/deleted"
+	rn.kvVersion = 2
+	registry.register(rn)
+	x := &watchedResource{resource: rn}
+	if !assert.NoError(t, service.get(x)) {
+		t.FailNow()
+	}
+
+	// step: the current version is deleted, the last good copy is kept
+	_, err := service.client.Logical().Delete("kv/data/deleted")
+	assert.NoError(t, err)
+	write("kv/metadata/deleted", map[string]interface{}{
+		"current_version": 1,
+		"versions":        map[string]interface{}{"1": map[string]interface{}{"deletion_time": "2018-03-22T02:24:06Z", "destroyed": false}},
+	})
+	assert.Equal(t, errSecretUnchanged, service.get(x))
+	assert.Equal(t, "first", x.secret.Data["password"])
+	assert.Equal(t, "deleted", x.deleted)
+	for _, status := range registry.list() {
+		if status.ID == rn.ID() {
+			assert.Equal(t, "deleted", status.Deleted)
+		}
+	}
+
+	// step: a resource without a copy fails with the reason
+	y := &watchedResource{resource: rn}
+	err = service.get(y)
+	if assert.Error(t, err) {
+		assert.Equal(t, "the version: 1 of the secret has been deleted", err.Error())
+	}
+
+	// step: the version is undeleted
+	write("kv/data/deleted", map[string]interface{}{
+		"data":     map[string]interface{}{"password": "first"},
+		"metadata": map[string]interface{}{"version": 1},
+	})
+	write("kv/metadata/deleted", map[string]interface{}{"current_version": 1})
+	assert.Equal(t, errSecretUnchanged, service.get(x))
+	assert.Equal(t, "", x.deleted)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// namespaces holds the child clients of the resources read from a namespace of their own
+var namespaces = newNamespaceClients()
+
+// namespaceClients are the clones of the vault clients sending the requests to another namespace, keyed by
+// the client they were cloned from and the namespace
+type namespaceClients struct {
+	sync.Mutex
+	// the clients keyed by the parent client and namespace
+	items map[string]*api.Client
+}
+
+// newNamespaceClients creates an empty set of clients
+func newNamespaceClients() *namespaceClients {
+	return &namespaceClients{items: make(map[string]*api.Client, 0)}
+}
+
+// get returns a child of the client whose requests are made in the namespace
+//	client		: the parent client
+//	namespace	: the vault enterprise namespace
+func (n *namespaceClients) get(client *api.Client, namespace string) (*api.Client, error) {
+	n.Lock()
+	defer n.Unlock()
+
+	key := fmt.Sprintf("%p|%s", client, namespace)
+	x, found := n.items[key]
+	if !found {
+		// step: the clone shares the http client, keeping the timeout and retries of the parent
+		var err error
+		if x, err = client.Clone(); err != nil {
+			return nil, err
+		}
+		x.SetHeaders(http.Header{"X-Vault-Namespace": []string{namespace}})
+		n.items[key] = x
+	}
+	// step: the token may have been renewed or reissued since
+	x.SetToken(client.Token())
+
+	return x, nil
+}
+
+// withNamespace returns a copy of the service whose clients make the requests in the namespace
+//	namespace	: the vault enterprise namespace
+func (r VaultService) withNamespace(namespace string) (VaultService, error) {
+	client, err := namespaces.get(r.client, namespace)
+	if err != nil {
+		return r, err
+	}
+	r.client = client
+	if r.readClient != nil {
+		if r.readClient, err = namespaces.get(r.readClient, namespace); err != nil {
+			return r, err
+		}
+	}
+
+	return r, nil
+}
+
+// cacheKey is the key of a path of the resource in the response cache, the same path in different
+// namespaces being different secrets
+//	rn			: the resource
+//	path		: the path read
+func cacheKey(rn *VaultResource, path string) string {
+	if rn.namespace == "" {
+		return path
+	}
+
+	return strings.Trim(rn.namespace, "/") + "/" + path
+}
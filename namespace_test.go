@@ -0,0 +1,131 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceNamespace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"namespace": r.Header.Get("X-Vault-Namespace"),
+			"token":     r.Header.Get("X-Vault-Token"),
+		}})
+	}))
+	defer server.Close()
+
+	client, err := newAPIClient(&config{vaultMaxRetries: 0, vaultNamespace: "org"}, server.URL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	client.SetToken("token")
+	service := VaultService{client: client}
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:shared/db"))
+	assert.NoError(t, resources.Set("secret:shared/db:ns=/org/team-a/"))
+	assert.Equal(t, "org/team-a", resources.items[1].namespace)
+	assert.NotEqual(t, resources.items[0].ID(), resources.items[1].ID())
+
+	// step: the resources of the same path are read from their own namespaces
+	global := &watchedResource{resource: resources.items[0]}
+	team := &watchedResource{resource: resources.items[1]}
+	if assert.NoError(t, service.get(global)) {
+		assert.Equal(t, "org", global.secret.Data["namespace"])
+	}
+	if assert.NoError(t, service.get(team)) {
+		assert.Equal(t, "org/team-a", team.secret.Data["namespace"])
+		assert.Equal(t, "token", team.secret.Data["token"])
+	}
+
+	// step: the child client follows the token of the parent
+	client.SetToken("renewed")
+	if assert.NoError(t, service.get(team)) {
+		assert.Equal(t, "renewed", team.secret.Data["token"])
+	}
+	assert.Equal(t, "shared/db", cacheKey(resources.items[0], "shared/db"))
+	assert.Equal(t, "org/team-a/shared/db", cacheKey(resources.items[1], "shared/db"))
+}
+
+func TestRevokePreviousLease(t *testing.T) {
+	mock, err := startMockVault("tests/fixtures")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// step: each read issues a new lease, the revocations are recorded with their namespace
+	var lock sync.Mutex
+	issued := 0
+	revoked := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/mysql/creds/app":
+			lock.Lock()
+			issued++
+			lease := fmt.Sprintf("mysql/creds/app/%d", issued)
+			lock.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"lease_id":       lease,
+				"lease_duration": 3600,
+				"renewable":      true,
+				"data":           map[string]interface{}{"username": lease},
+			})
+		case strings.HasPrefix(r.URL.Path, "/v1/sys/leases/revoke/"):
+			revoked <- r.Header.Get("X-Vault-Namespace") + ":" + strings.TrimPrefix(r.URL.Path, "/v1/sys/leases/revoke/")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Redirect(w, r, mock+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+		}
+	}))
+	defer server.Close()
+
+	service, err := NewVaultService(server.URL, &vaultAuthOptions{Method: "token", Token: mockToken}, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer service.Stop()
+	updates := make(chan VaultEvent, 10)
+	service.AddListener(updates)
+
+	var resources VaultResources
+	if !assert.NoError(t, resources.Set("mysql:mysql/creds/app:ns=team,revoke=true,renew=false,update=100ms")) {
+		t.FailNow()
+	}
+	service.Watch(resources.items[0])
+
+	// step: the resource is retrieved again, the lease it replaces is revoked in its namespace
+	first := waitForEvent(t, updates)
+	assert.Equal(t, EventTypeSuccess, first.Type)
+	second := waitForEvent(t, updates)
+	assert.Equal(t, EventTypeSuccess, second.Type)
+	assert.NotEqual(t, first.Secret["username"], second.Secret["username"])
+	select {
+	case lease := <-revoked:
+		assert.Equal(t, fmt.Sprintf("team:%s", first.Secret["username"]), lease)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the previous lease to be revoked")
+	}
+}
@@ -0,0 +1,443 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	// the hash of the issuer in the request
+	_ "crypto/sha1"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// ocspRefreshInterval is the interval a staple is refreshed when the response has no next update
+	ocspRefreshInterval = time.Duration(1) * time.Hour
+	// ocspRetryInterval is the interval a staple is retried after a failure
+	ocspRetryInterval = time.Duration(5) * time.Minute
+	// ocspMinimumInterval is the shortest interval between the refreshes of a staple
+	ocspMinimumInterval = time.Duration(1) * time.Minute
+	// ocspMaxResponseSize is the largest response accepted from a responder
+	ocspMaxResponseSize = 1 << 20
+)
+
+var (
+	// oidSHA1 is the hash of the issuer name and key in the request
+	oidSHA1 = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	// oidOCSPBasic is the type of a basic ocsp response
+	oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	// ocspSignatureAlgorithms are the algorithms a response may be signed with
+	ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+		"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+		"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+		"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+		"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+		"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+		"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+		"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+		"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+	}
+	// ocspResponseStatus are the reasons a responder refuses a request
+	ocspResponseStatus = map[asn1.Enumerated]string{
+		1: "malformed request",
+		2: "internal error",
+		3: "try later",
+		5: "signature required",
+		6: "unauthorized",
+	}
+)
+
+// the ocsp request and response, rfc 6960
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequestEntry struct {
+	Cert ocspCertID
+}
+
+type ocspTBSRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []ocspRequestEntry
+}
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspResponse struct {
+	Status asn1.Enumerated
+	Bytes  ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+	Extensions  []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// staples holds the ocsp staples of the pki resources
+var staples = &ocspStaples{items: make(map[string]*ocspStaple, 0)}
+
+// ocspStaples holds the ocsp staples, keyed by the id of the resource
+type ocspStaples struct {
+	sync.Mutex
+	// the staples of the resources
+	items map[string]*ocspStaple
+	// the client used to query the responders
+	client *http.Client
+}
+
+// ocspStaple is the staple file of a certificate, refreshed on its own schedule
+type ocspStaple struct {
+	sync.Mutex
+	// the resource the certificate was issued for
+	resource *VaultResource
+	// the secret data of the certificate
+	data map[string]interface{}
+	// the path of the staple file
+	filename string
+	// the certificate and its issuer
+	certificate, issuer *x509.Certificate
+	// the url of the ocsp responder
+	responder string
+	// the next refresh of the staple
+	timer *time.Timer
+	// the client used to query the responder
+	client *http.Client
+}
+
+// update fetches the staple of a newly issued certificate and schedules its refresh, replacing the staple of the
+// previous certificate; a failure is logged and retried, as the certificate is of use without a staple
+//	rn			: the pki resource
+//	filename	: the filename of the resource
+//	data		: the secret data
+func (s *ocspStaples) update(rn *VaultResource, filename string, data map[string]interface{}) error {
+	certificate, err := parseCertificate(fmt.Sprintf("%v", data["certificate"]))
+	if err != nil {
+		return fmt.Errorf("unable to parse the certificate, error: %s", err)
+	}
+	issuer, err := parseCertificate(fmt.Sprintf("%v", data["issuing_ca"]))
+	if err != nil {
+		return fmt.Errorf("unable to parse the issuing ca, error: %s", err)
+	}
+	responder := ocspResponder(rn, certificate)
+	if responder == "" {
+		return fmt.Errorf("the certificate has no ocsp responder and the path: %s isn't a pki mount", rn.path)
+	}
+
+	s.Lock()
+	if s.client == nil {
+		transport, err := buildHTTPTransport(&options)
+		if err != nil {
+			s.Unlock()
+			return err
+		}
+		s.client = &http.Client{Transport: transport, Timeout: time.Duration(10) * time.Second}
+	}
+	if previous, found := s.items[rn.ID()]; found {
+		previous.stop()
+	}
+	staple := &ocspStaple{
+		resource:    rn,
+		data:        data,
+		filename:    filename + ".ocsp",
+		certificate: certificate,
+		issuer:      issuer,
+		responder:   responder,
+		client:      s.client,
+	}
+	s.items[rn.ID()] = staple
+	s.Unlock()
+
+	staple.refresh(false)
+
+	return nil
+}
+
+// ocspResponder returns the ocsp responder of the certificate, falling back to the responder of the pki mount
+//	rn			: the pki resource
+//	certificate	: the certificate issued
+func ocspResponder(rn *VaultResource, certificate *x509.Certificate) string {
+	if len(certificate.OCSPServer) > 0 {
+		return certificate.OCSPServer[0]
+	}
+	if mount, found := pkiMount(rn.path); found {
+		return fmt.Sprintf("%s/v1/%s/ocsp", strings.TrimSuffix(vaultAddress(rn.vault), "/"), mount)
+	}
+
+	return ""
+}
+
+// refresh fetches and writes the staple, then schedules the next refresh
+//	reload		: whether to reload the server after the staple is written
+func (s *ocspStaple) refresh(reload bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	next := ocspRetryInterval
+	content, refresh, err := fetchOCSPResponse(s.client, s.responder, s.certificate, s.issuer)
+	if err != nil {
+		glog.Errorf("unable to fetch the ocsp staple of resource: %s from: %s, error: %s", s.resource, s.responder, err)
+	} else if err = writeResourceContent(s.resource, s.filename, content); err != nil {
+		glog.Errorf("unable to write the ocsp staple: %s, error: %s", s.filename, err)
+	} else {
+		glog.V(3).Infof("wrote the ocsp staple: %s of resource: %s, refreshing in: %s", s.filename, s.resource, refresh)
+		next = refresh
+		if reload {
+			s.reload()
+		}
+	}
+
+	s.timer = time.AfterFunc(next, func() { s.refresh(true) })
+}
+
+// reload runs the systemd and server reloads of the resource, so the server picks up a refreshed staple
+func (s *ocspStaple) reload() {
+	if s.resource.systemdUnit != "" {
+		if err := reloadSystemdUnit(s.resource.systemdUnit, s.resource.systemdAction, options.execTimeout); err != nil {
+			glog.Errorf("failed to reload the systemd unit: %s after refreshing the ocsp staple, error: %s", s.resource.systemdUnit, err)
+		}
+	}
+	if s.resource.reloadServer != "" {
+		if err := reloadServer(s.resource, s.data, options.execTimeout); err != nil {
+			glog.Errorf("failed to reload: %s after refreshing the ocsp staple, error: %s", s.resource.reloadServer, err)
+		}
+	}
+}
+
+// stop cancels the refresh of the staple
+func (s *ocspStaple) stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// fetchOCSPResponse requests the status of the certificate from the responder, returning the response once
+// verified and the time until it should be refreshed, half way to its next update
+//	client		: the http client
+//	responder	: the url of the ocsp responder
+//	certificate	: the certificate
+//	issuer		: the issuer of the certificate
+func fetchOCSPResponse(client *http.Client, responder string, certificate, issuer *x509.Certificate) ([]byte, time.Duration, error) {
+	request, err := createOCSPRequest(certificate, issuer)
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, responder, bytes.NewReader(request))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("the responder returned the status: %d", resp.StatusCode)
+	}
+	content, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: ocspMaxResponseSize})
+	if err != nil {
+		return nil, 0, err
+	}
+	single, err := parseOCSPResponse(content, certificate, issuer)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return content, ocspRefresh(single, time.Now()), nil
+}
+
+// ocspRefresh returns the time until the response should be refreshed, half way between now and the next update
+func ocspRefresh(single *ocspSingleResponse, now time.Time) time.Duration {
+	if single.NextUpdate.IsZero() {
+		return ocspRefreshInterval
+	}
+	refresh := single.NextUpdate.Sub(now) / 2
+	if refresh < ocspMinimumInterval {
+		refresh = ocspMinimumInterval
+	}
+
+	return refresh
+}
+
+// createOCSPRequest creates a der encoded request for the status of the certificate
+//	certificate	: the certificate
+//	issuer		: the issuer of the certificate
+func createOCSPRequest(certificate, issuer *x509.Certificate) ([]byte, error) {
+	var publicKey struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &publicKey); err != nil {
+		return nil, err
+	}
+	nameHash := crypto.SHA1.New()
+	nameHash.Write(issuer.RawSubject)
+	keyHash := crypto.SHA1.New()
+	keyHash.Write(publicKey.PublicKey.RightAlign())
+
+	return asn1.Marshal(ocspRequest{
+		TBSRequest: ocspTBSRequest{
+			RequestList: []ocspRequestEntry{{
+				Cert: ocspCertID{
+					HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+					NameHash:      nameHash.Sum(nil),
+					IssuerKeyHash: keyHash.Sum(nil),
+					SerialNumber:  certificate.SerialNumber,
+				},
+			}},
+		},
+	})
+}
+
+// parseOCSPResponse parses and verifies the response, returning the status of the certificate if good
+//	content		: the der encoded response
+//	certificate	: the certificate
+//	issuer		: the issuer of the certificate
+func parseOCSPResponse(content []byte, certificate, issuer *x509.Certificate) (*ocspSingleResponse, error) {
+	var response ocspResponse
+	if _, err := asn1.Unmarshal(content, &response); err != nil {
+		return nil, fmt.Errorf("invalid ocsp response, error: %s", err)
+	}
+	if response.Status != 0 {
+		if reason, found := ocspResponseStatus[response.Status]; found {
+			return nil, fmt.Errorf("the responder refused the request: %s", reason)
+		}
+		return nil, fmt.Errorf("the responder refused the request, status: %d", response.Status)
+	}
+	if !response.Bytes.ResponseType.Equal(oidOCSPBasic) {
+		return nil, errors.New("the ocsp response isn't a basic response")
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(response.Bytes.Response, &basic); err != nil {
+		return nil, fmt.Errorf("invalid basic ocsp response, error: %s", err)
+	}
+	var data ocspResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return nil, fmt.Errorf("invalid ocsp response data, error: %s", err)
+	}
+
+	// step: verify the signature, by the issuer or a responder the issuer has delegated to
+	algorithm, found := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !found {
+		return nil, fmt.Errorf("unsupported ocsp signature algorithm: %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		responder, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ocsp responder certificate, error: %s", err)
+		}
+		if !bytes.Equal(responder.Raw, issuer.Raw) {
+			if err := responder.CheckSignatureFrom(issuer); err != nil {
+				return nil, fmt.Errorf("the ocsp responder certificate wasn't issued by the issuer, error: %s", err)
+			}
+			if !hasExtKeyUsage(responder, x509.ExtKeyUsageOCSPSigning) {
+				return nil, errors.New("the ocsp responder certificate isn't permitted to sign responses")
+			}
+		}
+		signer = responder
+	}
+	if err := signer.CheckSignature(algorithm, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()); err != nil {
+		return nil, fmt.Errorf("invalid ocsp response signature, error: %s", err)
+	}
+
+	// step: find the status of the certificate
+	for i := range data.Responses {
+		single := &data.Responses[i]
+		if single.CertID.SerialNumber == nil || single.CertID.SerialNumber.Cmp(certificate.SerialNumber) != 0 {
+			continue
+		}
+		switch {
+		case bool(single.Good):
+		case bool(single.Unknown):
+			return nil, errors.New("the certificate is unknown to the ocsp responder")
+		default:
+			return nil, fmt.Errorf("the certificate was revoked at: %s", single.Revoked.RevocationTime)
+		}
+		if !single.NextUpdate.IsZero() && single.NextUpdate.Before(time.Now()) {
+			return nil, fmt.Errorf("the ocsp response expired at: %s", single.NextUpdate)
+		}
+
+		return single, nil
+	}
+
+	return nil, fmt.Errorf("the ocsp response doesn't cover the certificate: %s", certificate.SerialNumber)
+}
+
+// hasExtKeyUsage checks if the certificate has the extended key usage
+func hasExtKeyUsage(certificate *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, x := range certificate.ExtKeyUsage {
+		if x == usage {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ocspTestCA is a certificate authority answering ocsp requests
+type ocspTestCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	// the status of the certificates, 0 good, 1 revoked or 2 unknown
+	status int
+	// the signer of the responses, the ca if nil
+	signer *ecdsa.PrivateKey
+}
+
+func newOCSPTestCA(t *testing.T) *ocspTestCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	certificate, _ := x509.ParseCertificate(der)
+
+	return &ocspTestCA{certificate: certificate, key: key}
+}
+
+// issue creates a certificate with the responder
+func (c *ocspTestCA) issue(t *testing.T, serial int64, responder string) *x509.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "app.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{responder},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.certificate, &key.PublicKey, c.key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	certificate, _ := x509.ParseCertificate(der)
+
+	return certificate
+}
+
+// ServeHTTP answers the ocsp request with the status of the certificate
+func (c *ocspTestCA) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	content, _ := ioutil.ReadAll(req.Body)
+	var request ocspRequest
+	if _, err := asn1.Unmarshal(content, &request); err != nil || len(request.TBSRequest.RequestList) != 1 {
+		w.Write([]byte{0x30, 0x03, 0x0a, 0x01, 0x01})
+		return
+	}
+	single := ocspSingleResponse{
+		CertID:     request.TBSRequest.RequestList[0].Cert,
+		ThisUpdate: time.Now().Add(-time.Minute).UTC(),
+		NextUpdate: time.Now().Add(time.Hour).UTC(),
+	}
+	switch c.status {
+	case 0:
+		single.Good = true
+	case 1:
+		single.Revoked = ocspRevokedInfo{RevocationTime: time.Now().Add(-time.Minute).UTC()}
+	case 2:
+		single.Unknown = true
+	}
+	keyHash := sha1.Sum(c.certificate.RawSubjectPublicKeyInfo)
+	keyID, _ := asn1.Marshal(keyHash[:])
+	tbs, _ := asn1.Marshal(ocspResponseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyID},
+		ProducedAt:  time.Now().UTC(),
+		Responses:   []ocspSingleResponse{single},
+	})
+	signer := c.signer
+	if signer == nil {
+		signer = c.key
+	}
+	digest := sha256.Sum256(tbs)
+	signature, _ := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	basic, _ := asn1.Marshal(ocspBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	})
+	response, _ := asn1.Marshal(ocspResponse{Bytes: ocspResponseBytes{ResponseType: oidOCSPBasic, Response: basic}})
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(response)
+}
+
+func TestFetchOCSPResponse(t *testing.T) {
+	ca := newOCSPTestCA(t)
+	server := httptest.NewServer(ca)
+	defer server.Close()
+	certificate := ca.issue(t, 1001, server.URL)
+
+	content, refresh, err := fetchOCSPResponse(http.DefaultClient, server.URL, certificate, ca.certificate)
+	if assert.NoError(t, err) {
+		assert.NotEmpty(t, content)
+		assert.True(t, refresh > 25*time.Minute && refresh <= 30*time.Minute, "unexpected refresh: %s", refresh)
+	}
+
+	ca.status = 1
+	_, _, err = fetchOCSPResponse(http.DefaultClient, server.URL, certificate, ca.certificate)
+	assert.Error(t, err, "a revoked certificate should have failed")
+	ca.status = 2
+	_, _, err = fetchOCSPResponse(http.DefaultClient, server.URL, certificate, ca.certificate)
+	assert.Error(t, err, "an unknown certificate should have failed")
+
+	ca.status = 0
+	ca.signer, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, _, err = fetchOCSPResponse(http.DefaultClient, server.URL, certificate, ca.certificate)
+	assert.Error(t, err, "a response signed by another key should have failed")
+}
+
+func TestOCSPRefresh(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, ocspRefreshInterval, ocspRefresh(&ocspSingleResponse{}, now))
+	assert.Equal(t, 2*time.Hour, ocspRefresh(&ocspSingleResponse{NextUpdate: now.Add(4 * time.Hour)}, now))
+	assert.Equal(t, ocspMinimumInterval, ocspRefresh(&ocspSingleResponse{NextUpdate: now.Add(time.Second)}, now))
+}
+
+func TestOCSPStaplesUpdate(t *testing.T) {
+	ca := newOCSPTestCA(t)
+	server := httptest.NewServer(ca)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "ocsp")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	var resources VaultResources
+	if !assert.NoError(t, resources.Set("pki:pki/issue/web:common_name=app.example.com,ocsp=true")) {
+		t.FailNow()
+	}
+	rn := resources.items[0]
+	assert.True(t, rn.ocsp)
+	encode := func(x *x509.Certificate) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: x.Raw}))
+	}
+	data := map[string]interface{}{
+		"certificate": encode(ca.issue(t, 1002, server.URL)),
+		"issuing_ca":  encode(ca.certificate),
+	}
+	filename := filepath.Join(dir, "tls")
+	if !assert.NoError(t, staples.update(rn, filename, data)) {
+		t.FailNow()
+	}
+	defer staples.items[rn.ID()].stop()
+
+	content, err := ioutil.ReadFile(filename + ".ocsp")
+	if assert.NoError(t, err) {
+		certificate, _ := parseCertificate(data["certificate"].(string))
+		_, err := parseOCSPResponse(content, certificate, ca.certificate)
+		assert.NoError(t, err)
+	}
+
+	assert.Error(t, resources.Set("secret:secret/app:ocsp=true"))
+	assert.Error(t, resources.Set("pki:pki/issue/web:common_name=app.example.com,ocsp=maybe"))
+}
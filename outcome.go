@@ -0,0 +1,116 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// outcomeState tracks the outcomes of the updates of a resource for the on-success, on-failure and on-change hooks
+type outcomeState struct {
+	// the number of consecutive failures
+	failures int
+	// the time of the first of the consecutive failures
+	failingSince time.Time
+	// the digest of the secret of the last successful update, empty before the first
+	digest string
+}
+
+// runOutcomeHooks runs the on-success and on-change hooks of the resource following a successful update, or the
+// on-failure hook following a failure; a hook which fails is logged rather than failing the update
+//	rn			: the resource
+//	data		: the secret data of a successful update
+//	meta		: the metadata of the secret
+//	failure		: the error of a failed update, nil on success
+func runOutcomeHooks(rn *VaultResource, data map[string]interface{}, meta *secretMetadata, failure error) {
+	state := &rn.outcome
+	context := hookContext(rn, resolveFilename(rn.GetFilename()), data, meta)
+
+	if failure != nil {
+		if state.failures == 0 {
+			state.failingSince = time.Now()
+		}
+		state.failures++
+		context["EVENT"] = "failure"
+		context["ERROR"] = failure.Error()
+		context["FAILURES"] = strconv.Itoa(state.failures)
+		context["FAILING_SINCE"] = state.failingSince.UTC().Format(time.RFC3339)
+		runOutcomeHook(rn, optionOnFailure, rn.onFailure, context)
+		return
+	}
+
+	// step: the failures the update recovered from are passed so the hook can resolve an alert
+	context["RECOVERED_FAILURES"] = strconv.Itoa(state.failures)
+	state.failures = 0
+
+	// step: the first update since the sidekick started is always a change
+	digest := secretDigest(data)
+	changed := digest != state.digest
+	state.digest = digest
+	context["CHANGED"] = strconv.FormatBool(changed)
+
+	context["EVENT"] = "success"
+	runOutcomeHook(rn, optionOnSuccess, rn.onSuccess, context)
+	if changed {
+		context["EVENT"] = "change"
+		runOutcomeHook(rn, optionOnChange, rn.onChange, context)
+	}
+}
+
+// runOutcomeHook runs a hook of the resource with the context in its environment
+//	rn			: the resource
+//	name		: the name of the hook
+//	command		: the command of the hook, nothing is run if empty
+//	context		: the context of the update
+func runOutcomeHook(rn *VaultResource, name, command string, context map[string]string) {
+	if command == "" {
+		return
+	}
+	glog.V(10).Infof("executing the %s hook: %s for resource: %s", name, command, rn)
+
+	parts := strings.Split(command, " ")
+	span := startSpan(rn, "hook."+name)
+	span.setAttribute("exec.command", parts[0])
+	err := runHookCommand(parts[0], parts[1:], appendHookValues(os.Environ(), hookEnvPrefix, context), options.execTimeout)
+	span.finish(err)
+	if err != nil {
+		glog.Errorf("the %s hook of resource: %s failed, error: %s", name, rn, err)
+	}
+	metrics.add(metricHooks, 1, resourceLabels(rn, "hook", name, "status", statusLabel(err))...)
+}
+
+// secretDigest returns a digest of the secret data, used to tell whether an update changed the secret
+//	data		: the secret data
+func secretDigest(data map[string]interface{}) string {
+	// step: the keys of a map are encoded in order, so the same secret always has the same digest
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		encoded = []byte(fmt.Sprintf("%v", data))
+	}
+	sum := sha256.Sum256(encoded)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutcomeHookOptions(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/app:on-success=/bin/ok,on-failure='/bin/alert db',on-change=/bin/reload"))
+	rn := resources.items[0]
+	assert.Equal(t, "/bin/ok", rn.onSuccess)
+	assert.Equal(t, "/bin/alert db", rn.onFailure)
+	assert.Equal(t, "/bin/reload", rn.onChange)
+}
+
+func TestSecretDigest(t *testing.T) {
+	a := secretDigest(map[string]interface{}{"user": "app", "password": "secret"})
+	assert.Equal(t, a, secretDigest(map[string]interface{}{"password": "secret", "user": "app"}))
+	assert.NotEqual(t, a, secretDigest(map[string]interface{}{"user": "app", "password": "changed"}))
+}
+
+func TestRunOutcomeHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks are shell scripts")
+	}
+	dir, err := ioutil.TempDir("", "outcome")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	// step: each hook appends its environment to a file of its own
+	hook := func(name string) string {
+		script := filepath.Join(dir, name+".sh")
+		content := "#!/bin/sh\n(env | grep ^VAULT_SIDEKICK_ | sort; echo) >> " + filepath.Join(dir, name) + "\n"
+		assert.NoError(t, ioutil.WriteFile(script, []byte(content), 0755))
+		return script
+	}
+	read := func(name string) string {
+		content, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		os.Remove(filepath.Join(dir, name))
+		return string(content)
+	}
+
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "secret/db"
+	rn.filename = filepath.Join(dir, "db")
+	rn.onSuccess = hook("success")
+	rn.onFailure = hook("failure")
+	rn.onChange = hook("change")
+
+	runOutcomeHooks(rn, nil, nil, errors.New("permission denied"))
+	runOutcomeHooks(rn, nil, nil, errors.New("permission denied"))
+	failure := read("failure")
+	assert.Contains(t, failure, "VAULT_SIDEKICK_EVENT=failure")
+	assert.Contains(t, failure, "VAULT_SIDEKICK_ERROR=permission denied")
+	assert.Contains(t, failure, "VAULT_SIDEKICK_FAILURES=2")
+	assert.Contains(t, failure, "VAULT_SIDEKICK_FAILING_SINCE=")
+	assert.Contains(t, failure, "VAULT_SIDEKICK_RESOURCE_PATH=secret/db")
+	assert.Empty(t, read("success"))
+	assert.Empty(t, read("change"))
+
+	// step: the first success recovers from the failures and is a change
+	data := map[string]interface{}{"password": "secret"}
+	runOutcomeHooks(rn, data, nil, nil)
+	success := read("success")
+	assert.Contains(t, success, "VAULT_SIDEKICK_EVENT=success")
+	assert.Contains(t, success, "VAULT_SIDEKICK_RECOVERED_FAILURES=2")
+	assert.Contains(t, success, "VAULT_SIDEKICK_CHANGED=true")
+	assert.Contains(t, read("change"), "VAULT_SIDEKICK_EVENT=change")
+
+	// step: the same secret again only runs the success hook
+	runOutcomeHooks(rn, data, nil, nil)
+	success = read("success")
+	assert.Contains(t, success, "VAULT_SIDEKICK_RECOVERED_FAILURES=0")
+	assert.Contains(t, success, "VAULT_SIDEKICK_CHANGED=false")
+	assert.Empty(t, read("change"))
+
+	// step: the count of the failures starts again after a success
+	runOutcomeHooks(rn, nil, nil, errors.New("timeout"))
+	assert.Contains(t, read("failure"), "VAULT_SIDEKICK_FAILURES=1")
+
+	runOutcomeHooks(rn, map[string]interface{}{"password": "rotated"}, nil, nil)
+	assert.Contains(t, read("change"), "VAULT_SIDEKICK_CHANGED=true")
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// pgpKeyParam is the parameter of the public key vault encrypts the values of the response with
+	pgpKeyParam = "pgp_key"
+	// pgpKeybasePrefix references a key published on keybase, resolved by vault itself
+	pgpKeybasePrefix = "keybase:"
+	// pgpArmorHeader starts an ascii armored public key
+	pgpArmorHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+)
+
+// loadPGPKey resolves the pgp_key option of a resource into the base64 encoded public key vault expects; a
+// keybase:USER reference is passed as is, otherwise the option is a file holding an armored, binary or base64
+// encoded key, or the base64 encoded key itself
+//	value		: the pgp_key option
+func loadPGPKey(value string) (string, error) {
+	if strings.HasPrefix(value, pgpKeybasePrefix) {
+		return value, nil
+	}
+	if _, err := os.Stat(value); err != nil {
+		if _, err := base64.StdEncoding.DecodeString(value); err != nil {
+			return "", fmt.Errorf("the pgp key: %s is neither a file, keybase user nor a base64 encoded key", value)
+		}
+		return value, nil
+	}
+	content, err := ioutil.ReadFile(value)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the pgp key: %s, error: %s", value, err)
+	}
+
+	key, err := decodePGPKey(content)
+	if err != nil {
+		return "", fmt.Errorf("the pgp key: %s is invalid, error: %s", value, err)
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// decodePGPKey returns the binary packets of a public key, as exported by gpg --export with or without --armor,
+// or base64 encoded
+//	content		: the content of the key file
+func decodePGPKey(content []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(content)
+	switch {
+	case len(trimmed) == 0:
+		return nil, fmt.Errorf("the key is empty")
+	case bytes.HasPrefix(trimmed, []byte(pgpArmorHeader)):
+		return decodePGPArmor(trimmed)
+	case content[0]&0x80 != 0:
+		// step: the first octet of an openpgp packet always has the high bit set, which base64 never does
+		return content, nil
+	}
+
+	return base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(trimmed), nil)))
+}
+
+// decodePGPArmor decodes the body of an ascii armored key, skipping the armor headers and the checksum
+//	content		: the armored key
+func decodePGPArmor(content []byte) ([]byte, error) {
+	var body strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Scan()
+	// step: the armor headers i.e. Version: end at the first blank line, which some exporters leave out
+	headers := true
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "-----END"):
+			return base64.StdEncoding.DecodeString(body.String())
+		case headers && line == "":
+			headers = false
+		case headers && strings.Contains(line, ": "):
+		case strings.HasPrefix(line, "=") && len(line) == 5:
+		default:
+			headers = false
+			body.WriteString(line)
+		}
+	}
+
+	return nil, fmt.Errorf("the armored key has no end line")
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPGPKey is the start of a public key packet, enough to tell the encodings of a key file apart
+var testPGPKey = []byte{0x99, 0x01, 0x0d, 0x04, 0x5f, 0x3a, 0x21, 0x10, 0x01, 0x08, 0x00, 0xc1, 0xfe}
+
+func TestLoadPGPKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pgp")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	encoded := base64.StdEncoding.EncodeToString(testPGPKey)
+	armored := "-----BEGIN PGP PUBLIC KEY BLOCK-----\nVersion: GnuPG v2\n\n" + encoded[:8] + "\n" + encoded[8:] + "\n=Ab1c\n-----END PGP PUBLIC KEY BLOCK-----\n"
+	files := map[string][]byte{
+		"binary.gpg":  testPGPKey,
+		"armored.asc": []byte(armored),
+		"base64.txt":  []byte(encoded + "\n"),
+		"empty.txt":   {},
+		"truncated":   []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n" + encoded + "\n"),
+	}
+	for name, content := range files {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), content, 0600))
+	}
+
+	for _, name := range []string{"binary.gpg", "armored.asc", "base64.txt"} {
+		key, err := loadPGPKey(filepath.Join(dir, name))
+		assert.NoError(t, err, name)
+		assert.Equal(t, encoded, key, name)
+	}
+	for _, name := range []string{"empty.txt", "truncated", "missing.gpg"} {
+		_, err := loadPGPKey(filepath.Join(dir, name))
+		assert.Error(t, err, name)
+	}
+
+	key, err := loadPGPKey("keybase:jefferai")
+	assert.NoError(t, err)
+	assert.Equal(t, "keybase:jefferai", key)
+	key, err = loadPGPKey(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, encoded, key)
+}
+
+func TestPGPKeyResourceOptions(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("aws:aws/creds/app:pgp_key='keybase:ops',fmt=json"))
+	rn := resources.items[0]
+	assert.Equal(t, "keybase:ops", rn.pgpKey)
+	assert.NotContains(t, rn.options, pgpKeyParam)
+	assert.NoError(t, rn.IsValid())
+
+	for _, spec := range []string{"secret:secret/app:pgp_key='keybase:ops'", "aws:aws/creds/app:pgp_key='keybase:ops',fmt=patch"} {
+		resources = VaultResources{}
+		assert.NoError(t, resources.Set(spec))
+		assert.Error(t, resources.items[0].IsValid(), spec)
+	}
+}
+
+func TestGetPGPEncrypted(t *testing.T) {
+	service, _ := newMockService(t)
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("aws:aws/creds/app:pgp_key='keybase:ops',fmt=json"))
+	x := &watchedResource{resource: resources.items[0]}
+	if !assert.NoError(t, service.get(x)) {
+		t.FailNow()
+	}
+	// step: the values are written as vault returned them, still encrypted
+	assert.Equal(t, "wcBMA5aQ8fGWB9XcAQgAiKpVg5eW3yT0nS1bXb8r2Lw9Hj6fYqZc", x.secret.Data["access_key"])
+
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("aws:aws/creds/app:pgp_key=/nonexistent/key.asc"))
+	assert.Error(t, service.get(&watchedResource{resource: resources.items[0]}))
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// pkiRolePath returns the path of the role configuration for a pki issue path i.e. pki/issue/web -> pki/roles/web
+func pkiRolePath(path string) (string, bool) {
+	items := strings.Split(strings.Trim(path, "/"), "/")
+	if len(items) < 3 || items[len(items)-2] != "issue" {
+		return "", false
+	}
+	items[len(items)-2] = "roles"
+
+	return strings.Join(items, "/"), true
+}
+
+// pkiMount returns the mount of a pki issue or sign path i.e. pki/int/issue/web -> pki/int
+func pkiMount(path string) (string, bool) {
+	items := strings.Split(strings.Trim(path, "/"), "/")
+	if len(items) < 3 || (items[len(items)-2] != "issue" && items[len(items)-2] != "sign") {
+		return "", false
+	}
+
+	return strings.Join(items[:len(items)-2], "/"), true
+}
+
+// clampPKITTL checks the ttl requested against the max ttl of the pki role, clamping the request
+// rather than having vault reject it on every attempt; the role is only read once per resource
+// and a policy denying the read simply disables the check
+//	rn			: the watched resource
+//	params		: the parameters of the issue request
+func (r VaultService) clampPKITTL(rn *watchedResource, params map[string]interface{}) {
+	if !rn.roleChecked {
+		rn.roleChecked = true
+		rolePath, found := pkiRolePath(rn.resource.path)
+		if !found {
+			return
+		}
+		role, err := r.client.Logical().Read(rolePath)
+		if err != nil || role == nil {
+			glog.V(3).Infof("unable to read the pki role: %s, skipping the ttl check, error: %v", rolePath, err)
+			return
+		}
+		if rn.roleMaxTTL, err = parseTTL(role.Data["max_ttl"]); err != nil {
+			glog.Warningf("unable to parse the max ttl of the pki role: %s, error: %s", rolePath, err)
+			return
+		}
+		if rn.roleMaxTTL > 0 && rn.resource.update > rn.roleMaxTTL {
+			glog.Warningf("resource: %s has an update of %s, but the role: %s can only issue certificates for %s, the certificate will expire before it's renewed",
+				rn.resource, rn.resource.update, rolePath, rn.roleMaxTTL)
+		}
+	}
+	if rn.roleMaxTTL <= 0 {
+		return
+	}
+
+	value, found := params["ttl"]
+	if !found {
+		return
+	}
+	requested, err := parseTTL(value)
+	if err != nil || requested <= rn.roleMaxTTL {
+		return
+	}
+	glog.Warningf("resource: %s requested a ttl of %s, exceeding the max ttl: %s of the role, clamping the request",
+		rn.resource, requested, rn.roleMaxTTL)
+	params["ttl"] = fmt.Sprintf("%ds", int64(rn.roleMaxTTL.Seconds()))
+}
+
+// parseTTL parses a ttl from vault or the options, either seconds or a duration i.e. 3600, "3600" or "1h"
+func parseTTL(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case json.Number:
+		seconds, err := v.Int64()
+		return time.Duration(seconds) * time.Second, err
+	case float64:
+		return time.Duration(v) * time.Second, nil
+	case int:
+		return time.Duration(v) * time.Second, nil
+	case string:
+		if v == "" {
+			return 0, nil
+		}
+		if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Duration(seconds) * time.Second, nil
+		}
+		return time.ParseDuration(v)
+	}
+
+	return 0, fmt.Errorf("unsupported ttl: %v", value)
+}
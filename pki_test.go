@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPKIRolePath(t *testing.T) {
+	cases := []struct {
+		Path     string
+		Expected string
+		Found    bool
+	}{
+		{Path: "pki/issue/example", Expected: "pki/roles/example", Found: true},
+		{Path: "/platform/pki/issue/web/", Expected: "platform/pki/roles/web", Found: true},
+		{Path: "pki/sign/example"},
+		{Path: "issue/example"},
+	}
+	for i, c := range cases {
+		path, found := pkiRolePath(c.Path)
+		assert.Equal(t, c.Found, found, "case %d, path: %s", i, c.Path)
+		assert.Equal(t, c.Expected, path, "case %d, path: %s", i, c.Path)
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	cases := []struct {
+		Value    interface{}
+		Expected time.Duration
+		Ok       bool
+	}{
+		{Value: nil, Ok: true},
+		{Value: "", Ok: true},
+		{Value: json.Number("3600"), Expected: time.Hour, Ok: true},
+		{Value: float64(60), Expected: time.Minute, Ok: true},
+		{Value: "7200", Expected: 2 * time.Hour, Ok: true},
+		{Value: "72h", Expected: 72 * time.Hour, Ok: true},
+		{Value: "bad"},
+		{Value: true},
+	}
+	for i, c := range cases {
+		ttl, err := parseTTL(c.Value)
+		if !c.Ok {
+			assert.Error(t, err, "case %d, value: %v", i, c.Value)
+			continue
+		}
+		if assert.NoError(t, err, "case %d, value: %v", i, c.Value) {
+			assert.Equal(t, c.Expected, ttl, "case %d, value: %v", i, c.Value)
+		}
+	}
+}
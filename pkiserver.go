@@ -0,0 +1,181 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// pkiCRLInterval is the interval the crl is refreshed from vault
+var pkiCRLInterval = time.Duration(5) * time.Minute
+
+// pkiServer serves the certificate, ca chain and crl of a pki resource over https, using the certificate
+// of the resource itself, for containers in the pod which can't mount the secrets volume
+type pkiServer struct {
+	sync.RWMutex
+	// the certificate served by the listener
+	certificate *tls.Certificate
+	// the files served by name
+	files map[string][]byte
+	// the url of the crl in vault
+	crlURL string
+	// the client used to retrieve the crl
+	client *http.Client
+	// started once the crl refresh is running
+	refreshing sync.Once
+	// the listener of the server
+	listener net.Listener
+}
+
+// startPKIServer starts the https server on the address, the handshakes fail until the certificate is issued
+//	address		: the interface to listen on i.e. 127.0.0.1:8443
+func startPKIServer(address string) (*pkiServer, error) {
+	transport, err := buildHTTPTransport(&options)
+	if err != nil {
+		return nil, err
+	}
+	server := &pkiServer{
+		files:  make(map[string][]byte, 0),
+		client: &http.Client{Transport: transport, Timeout: time.Duration(10) * time.Second},
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("serving the pki certificates on: https://%s", listener.Addr())
+	server.listener = listener
+
+	go func() {
+		tlsListener := tls.NewListener(listener, &tls.Config{GetCertificate: server.getCertificate})
+		if err := http.Serve(tlsListener, server); err != nil {
+			glog.Errorf("the pki server has stopped, error: %s", err)
+		}
+	}()
+
+	return server, nil
+}
+
+// getCertificate returns the current certificate for the handshake
+func (s *pkiServer) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.certificate == nil {
+		return nil, errors.New("the certificate has not been issued yet")
+	}
+
+	return s.certificate, nil
+}
+
+// update replaces the certificate and files with those of the resource, and refreshes the crl
+//	rn			: the pki resource
+//	data		: the secret data
+//	vaultURL	: the address of the vault the resource was issued by
+func (s *pkiServer) update(rn *VaultResource, data map[string]interface{}, vaultURL string) error {
+	cert, _ := data["certificate"].(string)
+	key, _ := data["private_key"].(string)
+	ca, _ := data["issuing_ca"].(string)
+	pair, err := tls.X509KeyPair([]byte(cert), []byte(key))
+	if err != nil {
+		return fmt.Errorf("unable to load the certificate of the resource: %s, error: %s", rn, err)
+	}
+	chain := ca
+	if list, found := data["ca_chain"].([]interface{}); found && len(list) > 0 {
+		var items []string
+		for _, x := range list {
+			items = append(items, strings.TrimSpace(fmt.Sprintf("%v", x)))
+		}
+		chain = strings.Join(items, "\n")
+	}
+
+	s.Lock()
+	s.certificate = &pair
+	s.files["cert.pem"] = []byte(strings.TrimSpace(cert) + "\n")
+	s.files["ca.pem"] = []byte(strings.TrimSpace(ca) + "\n")
+	s.files["ca_chain.pem"] = []byte(strings.TrimSpace(chain) + "\n")
+	if mount, found := pkiMount(rn.path); found {
+		s.crlURL = fmt.Sprintf("%s/v1/%s/crl/pem", strings.TrimSuffix(vaultURL, "/"), mount)
+	}
+	s.Unlock()
+
+	// step: refresh the crl now and on the interval
+	s.refreshCRL()
+	s.refreshing.Do(func() {
+		go func() {
+			for range time.NewTicker(pkiCRLInterval).C {
+				s.refreshCRL()
+			}
+		}()
+	})
+
+	return nil
+}
+
+// refreshCRL retrieves the crl from vault, keeping the previous crl on an error
+func (s *pkiServer) refreshCRL() {
+	s.RLock()
+	url := s.crlURL
+	s.RUnlock()
+	if url == "" {
+		return
+	}
+	resp, err := s.client.Get(url)
+	if err != nil {
+		glog.Errorf("unable to retrieve the crl: %s, error: %s", url, err)
+		return
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		glog.Errorf("unable to retrieve the crl: %s, status: %d, error: %v", url, resp.StatusCode, err)
+		return
+	}
+
+	s.Lock()
+	s.files["crl.pem"] = content
+	s.Unlock()
+}
+
+// ServeHTTP serves the files, the private key is never served
+func (s *pkiServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.RLock()
+	content, found := s.files[strings.TrimPrefix(req.URL.Path, "/")]
+	s.RUnlock()
+	if !found {
+		http.NotFound(w, req)
+		return
+	}
+	contentType := "application/x-pem-file"
+	if req.URL.Path == "/crl.pem" {
+		contentType = "application/pkix-crl"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(content)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPKIMount(t *testing.T) {
+	mount, found := pkiMount("pki/issue/web")
+	assert.True(t, found)
+	assert.Equal(t, "pki", mount)
+	mount, found = pkiMount("/pki/int/sign/web")
+	assert.True(t, found)
+	assert.Equal(t, "pki/int", mount)
+	_, found = pkiMount("secret/app")
+	assert.False(t, found)
+}
+
+func TestPKIServer(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/pki/crl/pem" {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprint(w, "-----BEGIN X509 CRL-----\nMIIB\n-----END X509 CRL-----\n")
+	}))
+	defer vault.Close()
+
+	server, err := startPKIServer("127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer server.listener.Close()
+
+	data := readCertificateFixture(t)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM([]byte(data["issuing_ca"].(string)))
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "app.example.com"}}}
+	address := fmt.Sprintf("https://%s", server.listener.Addr())
+
+	// step: the handshake fails until the certificate is issued
+	_, err = client.Get(address + "/ca.pem")
+	assert.Error(t, err)
+
+	rn := defaultVaultResource()
+	rn.resource = "pki"
+	rn.path = "pki/issue/example"
+	if !assert.NoError(t, server.update(rn, data, vault.URL)) {
+		t.FailNow()
+	}
+
+	for name, expected := range map[string]string{
+		"/ca.pem":   data["issuing_ca"].(string),
+		"/cert.pem": data["certificate"].(string),
+		"/crl.pem":  "-----BEGIN X509 CRL-----",
+	} {
+		resp, err := client.Get(address + name)
+		if !assert.NoError(t, err, "file: %s", name) {
+			continue
+		}
+		content, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "file: %s", name)
+		assert.Contains(t, string(content), expected, "file: %s", name)
+	}
+
+	resp, err := client.Get(address + "/key.pem")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+}
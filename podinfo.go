@@ -0,0 +1,376 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	// podPlaceholderRegex matches a pod placeholder i.e. {pod.name} or {pod.labels.app}
+	podPlaceholderRegex = regexp.MustCompile(`\{pod\.([^{}]+)\}`)
+	// serviceAccountDir is the directory the kubernetes service account is mounted in
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// annotationResourcePrefix is the prefix of the pod annotations holding a resource i.e. vault.sidekick/resource.1
+const annotationResourcePrefix = "vault.sidekick/resource."
+
+// annotationResource is a resource given by an annotation of the pod
+type annotationResource struct {
+	// the name of the annotation
+	name string
+	// the resource i.e. secret:secret/db:fmt=env
+	value string
+}
+
+// annotationResources returns the resources given by the annotations of the pod, ordered by the suffix
+// of the annotation, numerically where the suffix is a number
+func annotationResources(annotations map[string]string) []annotationResource {
+	var list []annotationResource
+	for name, value := range annotations {
+		if strings.HasPrefix(name, annotationResourcePrefix) && strings.TrimSpace(value) != "" {
+			list = append(list, annotationResource{name: name, value: strings.TrimSpace(value)})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := strings.TrimPrefix(list[i].name, annotationResourcePrefix), strings.TrimPrefix(list[j].name, annotationResourcePrefix)
+		x, errA := strconv.Atoi(a)
+		y, errB := strconv.Atoi(b)
+		switch {
+		case errA == nil && errB == nil:
+			return x < y
+		case errA == nil:
+			return true
+		case errB == nil:
+			return false
+		}
+		return a < b
+	})
+
+	return list
+}
+
+// podInfo is the metadata of the pod the sidekick is running in
+type podInfo struct {
+	// the name of the pod
+	Name string
+	// the namespace of the pod
+	Namespace string
+	// the labels on the pod
+	Labels map[string]string
+	// the annotations on the pod
+	Annotations map[string]string
+}
+
+// kubernetesPod is the part of a pod returned by the kubernetes api we are interested in
+type kubernetesPod struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+}
+
+// lookup resolves a placeholder key against the pod i.e. name, namespace, labels.app
+func (p *podInfo) lookup(key string) (string, bool) {
+	switch {
+	case key == "name":
+		return p.Name, p.Name != ""
+	case key == "namespace":
+		return p.Namespace, p.Namespace != ""
+	case strings.HasPrefix(key, "labels."):
+		value, found := p.Labels[strings.TrimPrefix(key, "labels.")]
+		return value, found
+	case strings.HasPrefix(key, "annotations."):
+		value, found := p.Annotations[strings.TrimPrefix(key, "annotations.")]
+		return value, found
+	}
+
+	return "", false
+}
+
+// expand replaces the pod placeholders in the value
+func (p *podInfo) expand(value string) (string, error) {
+	var err error
+	expanded := podPlaceholderRegex.ReplaceAllStringFunc(value, func(placeholder string) string {
+		key := podPlaceholderRegex.FindStringSubmatch(placeholder)[1]
+		resolved, found := p.lookup(key)
+		if !found && err == nil {
+			err = fmt.Errorf("the placeholder: %s could not be resolved from the pod metadata", placeholder)
+		}
+		return resolved
+	})
+
+	return expanded, err
+}
+
+// hasPodPlaceholders checks if any of the resources reference the pod metadata
+func hasPodPlaceholders(resources []*VaultResource) bool {
+	for _, rn := range resources {
+		if podPlaceholderRegex.MatchString(rn.path) || podPlaceholderRegex.MatchString(rn.filename) ||
+			podPlaceholderRegex.MatchString(rn.execPath) || podPlaceholderRegex.MatchString(rn.templateFile) ||
+			podPlaceholderRegex.MatchString(rn.onSuccess + rn.onFailure + rn.onChange) {
+			return true
+		}
+		for _, v := range rn.options {
+			if podPlaceholderRegex.MatchString(v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// expandPodPlaceholders replaces the pod placeholders in the path, filename, exec, template, outcome hooks and options of the resource
+//	rn		: the resource to expand
+//	pod		: the metadata of the pod
+func expandPodPlaceholders(rn *VaultResource, pod *podInfo) error {
+	var err error
+	for _, field := range []*string{&rn.path, &rn.filename, &rn.execPath, &rn.templateFile, &rn.onSuccess, &rn.onFailure, &rn.onChange} {
+		if *field, err = pod.expand(*field); err != nil {
+			return fmt.Errorf("resource: %s, %s", rn, err)
+		}
+	}
+	for k, v := range rn.options {
+		if rn.options[k], err = pod.expand(v); err != nil {
+			return fmt.Errorf("resource: %s, option: %s, %s", rn, k, err)
+		}
+	}
+
+	return nil
+}
+
+// podName returns the name of the pod from the POD_NAME environment variable, otherwise the hostname
+func podName() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+
+	return name
+}
+
+// podNamespace returns the namespace of the pod from the POD_NAMESPACE environment variable, otherwise the
+// namespace of the service account
+func podNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+
+	return strings.TrimSpace(string(content))
+}
+
+// loadPodInfo gathers the metadata of the pod from the environment, the downward api volume and
+// optionally the kubernetes api, the later sources taking precedence
+//	dir		: the directory the downward api volume is mounted in, skipped if empty
+//	useAPI	: retrieve the pod from the kubernetes api
+func loadPodInfo(dir string, useAPI bool) (*podInfo, error) {
+	pod := &podInfo{
+		Name:        podName(),
+		Namespace:   podNamespace(),
+		Labels:      make(map[string]string, 0),
+		Annotations: make(map[string]string, 0),
+	}
+
+	// step: read the files projected by the downward api volume
+	if dir != "" {
+		for _, name := range []string{"name", "namespace"} {
+			content, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			if name == "name" {
+				pod.Name = strings.TrimSpace(string(content))
+			} else {
+				pod.Namespace = strings.TrimSpace(string(content))
+			}
+		}
+		for name, values := range map[string]map[string]string{"labels": pod.Labels, "annotations": pod.Annotations} {
+			if err := readDownwardAPIFile(filepath.Join(dir, name), values); err != nil {
+				return nil, fmt.Errorf("unable to read the pod %s, error: %s", name, err)
+			}
+		}
+	}
+
+	if useAPI {
+		if err := pod.retrieve(); err != nil {
+			return nil, fmt.Errorf("unable to retrieve the pod from the kubernetes api, error: %s", err)
+		}
+	}
+	glog.V(3).Infof("pod metadata, name: %s, namespace: %s, labels: %d, annotations: %d",
+		pod.Name, pod.Namespace, len(pod.Labels), len(pod.Annotations))
+
+	return pod, nil
+}
+
+// readDownwardAPIFile reads a labels or annotations file of the downward api, one key="value" per line
+func readDownwardAPIFile(filename string, values map[string]string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		kp := strings.SplitN(line, "=", 2)
+		if len(kp) != 2 {
+			return fmt.Errorf("invalid line: %s, expected key=\"value\"", line)
+		}
+		value, err := strconv.Unquote(kp[1])
+		if err != nil {
+			value = kp[1]
+		}
+		values[kp[0]] = value
+	}
+
+	return scanner.Err()
+}
+
+// kubernetesAPI is a client of the kubernetes api authenticated by the service account of the pod
+type kubernetesAPI struct {
+	// the http client trusting the service account ca
+	client *http.Client
+	// the base url of the api i.e. https://10.0.0.1:443
+	address string
+}
+
+// newKubernetesAPI creates a client of the kubernetes api from the environment and service account of the pod
+func newKubernetesAPI() (*kubernetesAPI, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running inside kubernetes, KUBERNETES_SERVICE_HOST is unset")
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in the service account ca")
+	}
+
+	return &kubernetesAPI{
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		address: "https://" + net.JoinHostPort(host, port),
+	}, nil
+}
+
+// do makes a request to the kubernetes api, decoding a successful response into the result if given and
+// returning the status code; the token is read on each request, as a projected token is rotated
+//	method		: the http method
+//	path		: the path of the request
+//	body		: the body encoded as json, if not nil
+//	result		: the value a successful response is decoded into, if not nil
+func (k *kubernetesAPI) do(method, path string, body, result interface{}) (int, error) {
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return 0, err
+	}
+	var content io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		content = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequest(method, k.address+path, content)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := k.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if result != nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return resp.StatusCode, err
+		}
+	}
+
+	return resp.StatusCode, nil
+}
+
+// retrieve fetches the pod from the kubernetes api using the service account, requires get on pods
+func (p *podInfo) retrieve() error {
+	if p.Namespace == "" {
+		return fmt.Errorf("the namespace of the pod is unknown")
+	}
+	client, err := newKubernetesAPI()
+	if err != nil {
+		return err
+	}
+	var pod kubernetesPod
+	status, err := client.do("GET", fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", p.Namespace, p.Name), nil, &pod)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d from the kubernetes api", status)
+	}
+	p.Name = pod.Metadata.Name
+	p.Namespace = pod.Metadata.Namespace
+	for k, v := range pod.Metadata.Labels {
+		p.Labels[k] = v
+	}
+	for k, v := range pod.Metadata.Annotations {
+		p.Annotations[k] = v
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPodInfoDownwardAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "podinfo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"name":        "web-7d9f8-abcde\n",
+		"namespace":   "payments",
+		"labels":      "app=\"web\"\napp.kubernetes.io/version=\"1.2.3\"\n",
+		"annotations": "team=\"platform\"\ndescription=\"a \\\"quoted\\\" value\"\n",
+	}
+	for name, content := range files {
+		if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)) {
+			t.FailNow()
+		}
+	}
+
+	pod, err := loadPodInfo(dir, false)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "web-7d9f8-abcde", pod.Name)
+	assert.Equal(t, "payments", pod.Namespace)
+	assert.Equal(t, map[string]string{"app": "web", "app.kubernetes.io/version": "1.2.3"}, pod.Labels)
+	assert.Equal(t, "a \"quoted\" value", pod.Annotations["description"])
+}
+
+func TestExpandPodPlaceholders(t *testing.T) {
+	pod := &podInfo{
+		Name:        "web-0",
+		Namespace:   "payments",
+		Labels:      map[string]string{"app": "web"},
+		Annotations: map[string]string{"team": "platform"},
+	}
+
+	var items VaultResources
+	if !assert.NoError(t, items.Set("pki:pki/issue/{pod.namespace}:common_name={pod.name}.{pod.labels.app}.svc,file=/etc/tls/{pod.annotations.team}")) {
+		t.FailNow()
+	}
+	rn := items.items[0]
+	assert.True(t, hasPodPlaceholders(items.items))
+	if !assert.NoError(t, expandPodPlaceholders(rn, pod)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "pki/issue/payments", rn.path)
+	assert.Equal(t, "web-0.web.svc", rn.options["common_name"])
+	assert.Equal(t, "/etc/tls/platform", rn.filename)
+	assert.False(t, hasPodPlaceholders(items.items))
+
+	// step: an unknown label should be an error rather than an empty value
+	rn = defaultVaultResource()
+	rn.path = "secret/{pod.labels.missing}"
+	assert.Error(t, expandPodPlaceholders(rn, pod))
+}
+
+func TestLoadPodInfoKubernetesAPI(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sa-token" || r.URL.Path != "/api/v1/namespaces/payments/pods/web-0" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"metadata":{"name":"web-0","namespace":"payments","labels":{"app":"web"},"annotations":{"team":"platform"}}}`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "serviceaccount")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})
+	for name, content := range map[string][]byte{"token": []byte("sa-token\n"), "namespace": []byte("payments"), "ca.crt": ca} {
+		if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), content, 0600)) {
+			t.FailNow()
+		}
+	}
+	original := serviceAccountDir
+	serviceAccountDir = dir
+	defer func() { serviceAccountDir = original }()
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	for k, v := range map[string]string{"KUBERNETES_SERVICE_HOST": host, "KUBERNETES_SERVICE_PORT": port, "POD_NAME": "web-0"} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	pod, err := loadPodInfo("", true)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "payments", pod.Namespace)
+	assert.Equal(t, "web", pod.Labels["app"])
+	assert.Equal(t, "platform", pod.Annotations["team"])
+
+	// step: a pod we are not permitted to read should fail
+	os.Setenv("POD_NAME", "other")
+	_, err = loadPodInfo("", true)
+	assert.Error(t, err)
+}
+
+func TestAnnotationResources(t *testing.T) {
+	annotations := map[string]string{
+		"vault.sidekick/resource.10":  "secret:secret/ten",
+		"vault.sidekick/resource.2":   "secret:secret/two",
+		"vault.sidekick/resource.db":  "secret:database/creds/app:fmt=env",
+		"vault.sidekick/resource.1":   " pki:pki/issue/web:common_name=web.svc ",
+		"vault.sidekick/resource.3":   "",
+		"vault.sidekick/injected":     "true",
+		"kubernetes.io/config.source": "api",
+	}
+	var names, values []string
+	for _, x := range annotationResources(annotations) {
+		names = append(names, x.name)
+		values = append(values, x.value)
+	}
+	assert.Equal(t, []string{
+		"vault.sidekick/resource.1",
+		"vault.sidekick/resource.2",
+		"vault.sidekick/resource.10",
+		"vault.sidekick/resource.db",
+	}, names)
+	assert.Equal(t, "pki:pki/issue/web:common_name=web.svc", values[0])
+}
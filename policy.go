@@ -0,0 +1,194 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// policyRules are the capabilities required on each path, keyed by the path
+type policyRules map[string]map[string]bool
+
+// add grants capabilities on a path
+func (p policyRules) add(path string, capabilities ...string) {
+	path = strings.Trim(path, "/")
+	if _, found := p[path]; !found {
+		p[path] = make(map[string]bool, 0)
+	}
+	for _, x := range capabilities {
+		p[path][x] = true
+	}
+}
+
+// resourcePolicy adds the paths and capabilities the sidekick requires to retrieve, renew and revoke a resource
+//	rules		: the rules to add to
+//	rn			: the resource
+func resourcePolicy(rules policyRules, rn *VaultResource) {
+	switch rn.resource {
+	case "health":
+		// the health and seal status endpoints are unauthenticated
+		return
+	case "cabundle":
+		// the ca endpoints of a pki mount are unauthenticated
+		return
+	case "pki":
+		rules.add(rn.path, "update")
+		if rolePath, found := pkiRolePath(rn.path); found {
+			rules.add(rolePath, "read")
+		}
+		if rn.keystorePassword != "" {
+			rules.add(rn.keystorePassword, "read")
+		}
+	case "transit":
+		rules.add(rn.path, "update")
+	default:
+		for _, path := range rn.paths() {
+			switch {
+			case rn.isWildcard():
+				base := strings.TrimSuffix(path, wildcardSuffix)
+				rules.add(base, "list")
+				rules.add(base+"/*", "read")
+			case rn.kvVersion == 2:
+				dataPath, metadataPath, err := kvPaths(path)
+				if err != nil {
+					continue
+				}
+				rules.add(metadataPath, "read")
+				rules.add(dataPath, "read")
+				if rn.create {
+					rules.add(dataPath, "create", "update")
+				}
+			default:
+				rules.add(path, "read")
+				if rn.create {
+					rules.add(path, "create")
+				}
+			}
+		}
+	}
+	if rn.rotate {
+		rules.add(rn.rotateRolePath(), "update")
+	}
+	if rn.renewable {
+		rules.add("sys/leases/renew", "update")
+	}
+	if rn.revokeSuperseded {
+		if mount, found := pkiMount(rn.path); found {
+			rules.add(mount+"/revoke", "update")
+		}
+	}
+	if rn.revoked {
+		// step: a lease id is prefixed by the path it was issued from
+		rules.add("sys/leases/revoke/"+strings.Trim(rn.path, "/")+"/*", "update")
+	}
+}
+
+// generatePolicies returns the rules required by the resources, keyed by the name of the vault
+//	resources	: the resources
+func generatePolicies(resources []*VaultResource) map[string]policyRules {
+	policies := make(map[string]policyRules, 0)
+	for _, rn := range resources {
+		rules, found := policies[rn.vault]
+		if !found {
+			rules = make(policyRules, 0)
+			// step: the token is looked up, renewed and revoked by the sidekick
+			rules.add("auth/token/lookup-self", "read")
+			rules.add("auth/token/renew-self", "update")
+			rules.add("auth/token/revoke-self", "update")
+			policies[rn.vault] = rules
+		}
+		resourcePolicy(rules, rn)
+	}
+
+	return policies
+}
+
+// renderPolicy writes the rules as vault policy hcl, ordered by path
+//	w			: the writer
+//	rules		: the rules
+func renderPolicy(w io.Writer, rules policyRules) {
+	var paths []string
+	for path := range rules {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for i, path := range paths {
+		var capabilities []string
+		for x := range rules[path] {
+			capabilities = append(capabilities, fmt.Sprintf("%q", x))
+		}
+		sort.Strings(capabilities)
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "path %q {\n  capabilities = [%s]\n}\n", path, strings.Join(capabilities, ", "))
+	}
+}
+
+// formatPolicies renders the policy of each vault, headed by the name of the vault when there are several
+func formatPolicies(policies map[string]policyRules) string {
+	var names []string
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if len(names) > 1 {
+			label := name
+			if label == "" {
+				label = "default"
+			}
+			fmt.Fprintf(&b, "# vault: %s\n", label)
+		}
+		renderPolicy(&b, policies[name])
+	}
+
+	return b.String()
+}
+
+// runPolicyCommand handles the policy subcommand, printing the policy required by the resources given
+// on the command line without contacting vault i.e. vault-sidekick policy -cn=secret:secret/app
+//	args		: the arguments following the subcommand
+func runPolicyCommand(args []string) int {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return 1
+	}
+	if options.resources == nil || len(options.resources.items) == 0 {
+		fmt.Fprintln(os.Stderr, "[error] no resources have been specified, i.e. vault-sidekick policy -cn=secret:secret/app")
+		return 1
+	}
+	items := selectResources(options.resources.items, splitTags(options.onlyTags), splitTags(options.skipTags))
+	selected := &VaultResources{items: items, invalid: options.resources.invalid}
+	if err := selected.validate(nil); err != nil {
+		fmt.Fprintf(os.Stderr, "[error] %s\n", err)
+		return 1
+	}
+	fmt.Print(formatPolicies(generatePolicies(items)))
+
+	return 0
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratePolicies(t *testing.T) {
+	var resources VaultResources
+	for _, x := range []string{
+		"secret:secret/app",
+		"secret:secret/common,secret/prod",
+		"pki:pki/issue/web:common_name=web,revoke=true",
+		"secret:kv/app:kv=2,create=true",
+		"mysql:database/creds/app:renew=true",
+		"secret:secret/flags/*:vault=dr",
+		"health:sys/health",
+	} {
+		if !assert.NoError(t, resources.Set(x)) {
+			t.FailNow()
+		}
+	}
+	policies := generatePolicies(resources.items)
+	assert.Len(t, policies, 2)
+
+	expected := map[string][]string{
+		"auth/token/lookup-self":            {"read"},
+		"auth/token/renew-self":             {"update"},
+		"auth/token/revoke-self":            {"update"},
+		"secret/app":                        {"read"},
+		"secret/common":                     {"read"},
+		"secret/prod":                       {"read"},
+		"pki/issue/web":                     {"update"},
+		"pki/roles/web":                     {"read"},
+		"sys/leases/revoke/pki/issue/web/*": {"update"},
+		"kv/data/app":                       {"create", "read", "update"},
+		"kv/metadata/app":                   {"read"},
+		"database/creds/app":                {"read"},
+		"sys/leases/renew":                  {"update"},
+	}
+	assert.Len(t, policies[""], len(expected))
+	for path, capabilities := range expected {
+		for _, x := range capabilities {
+			assert.True(t, policies[""][path][x], "path: %s, capability: %s", path, x)
+		}
+	}
+	assert.True(t, policies["dr"]["secret/flags"]["list"])
+	assert.True(t, policies["dr"]["secret/flags/*"]["read"])
+}
+
+func TestRenderPolicy(t *testing.T) {
+	rules := make(policyRules, 0)
+	rules.add("secret/b", "read")
+	rules.add("/secret/a/", "update", "create")
+
+	var b bytes.Buffer
+	renderPolicy(&b, rules)
+	assert.Equal(t, "path \"secret/a\" {\n  capabilities = [\"create\", \"update\"]\n}\n\npath \"secret/b\" {\n  capabilities = [\"read\"]\n}\n", b.String())
+
+	assert.NotContains(t, formatPolicies(map[string]policyRules{"": rules}), "# vault")
+	assert.Contains(t, formatPolicies(map[string]policyRules{"": rules, "dr": rules}), "# vault: default\n")
+}
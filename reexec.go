@@ -0,0 +1,45 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenerFDEnv is the environment variable carrying the file descriptor of an inherited listener
+const listenerFDEnv = "VAULT_SIDEKICK_LISTENER_FD"
+
+// inheritedListener returns the admin listener handed over by a previous process, nil if none
+func inheritedListener() (net.Listener, error) {
+	value := os.Getenv(listenerFDEnv)
+	if value == "" {
+		return nil, nil
+	}
+	os.Unsetenv(listenerFDEnv)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inherited file descriptor: %s", value)
+	}
+	file := os.NewFile(uintptr(fd), "listener")
+	defer file.Close()
+
+	return net.FileListener(file)
+}
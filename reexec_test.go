@@ -0,0 +1,54 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInheritedListener(t *testing.T) {
+	listener, err := inheritedListener()
+	assert.NoError(t, err)
+	assert.Nil(t, listener)
+
+	original, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer original.Close()
+	file, err := original.(*net.TCPListener).File()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	os.Setenv(listenerFDEnv, fmt.Sprintf("%d", file.Fd()))
+
+	inherited, err := inheritedListener()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer inherited.Close()
+	assert.Equal(t, original.Addr().String(), inherited.Addr().String())
+	assert.Empty(t, os.Getenv(listenerFDEnv))
+}
@@ -0,0 +1,71 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/golang/glog"
+)
+
+// upgradeSignal is the signal requesting a re-exec of the binary
+var upgradeSignal os.Signal = syscall.SIGUSR1
+
+// reexec replaces the process with the binary on disk, handing over the admin listener; the pid is kept, so
+// as pid 1 of a container the upgrade doesn't stop the container, and the leases are handed over via the state
+// file, which the new process resumes from; it only returns if the exec fails
+//	listener	: the admin listener, nil if disabled
+func reexec(listener net.Listener) error {
+	binary, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if options.stateFile == "" {
+		glog.Warningf("no state file has been configured, the new process will issue new leases")
+	}
+	env := os.Environ()
+
+	// step: hand over the admin listener, the descriptor is kept open across the exec
+	if listener != nil {
+		tcp, ok := listener.(*net.TCPListener)
+		if !ok {
+			return fmt.Errorf("unable to hand over the listener: %s", listener.Addr())
+		}
+		file, err := tcp.File()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, file.Fd(), syscall.F_SETFD, 0); errno != 0 {
+			return fmt.Errorf("unable to hand over the listener, error: %s", errno)
+		}
+		env = append(env, fmt.Sprintf("%s=%d", listenerFDEnv, file.Fd()))
+	}
+
+	// step: flush the telemetry, nothing runs after the exec; the signals we catch are reset by the exec itself,
+	// so they're still handled should it fail
+	flushTelemetry()
+	glog.Flush()
+
+	return syscall.Exec(binary, os.Args, env)
+}
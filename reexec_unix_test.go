@@ -0,0 +1,80 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reexecStageEnv tells the helper process which side of the exec it is on
+const reexecStageEnv = "VAULT_SIDEKICK_TEST_REEXEC"
+
+// TestReexecHelper is run as a separate process by TestReexec, it listens and execs the binary in its place
+func TestReexecHelper(t *testing.T) {
+	switch os.Getenv(reexecStageEnv) {
+	case "listening":
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			fmt.Printf("error=%s\n", err)
+			return
+		}
+		fmt.Printf("pid=%d addr=%s\n", os.Getpid(), listener.Addr())
+		os.Setenv(reexecStageEnv, "upgraded")
+		fmt.Printf("error=%s\n", reexec(listener))
+	case "upgraded":
+		listener, err := inheritedListener()
+		if err != nil || listener == nil {
+			fmt.Printf("error=no inherited listener: %v\n", err)
+			return
+		}
+		defer listener.Close()
+		fmt.Printf("pid=%d addr=%s\n", os.Getpid(), listener.Addr())
+	default:
+		t.Skip("only run by TestReexec")
+	}
+}
+
+func TestReexec(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestReexecHelper$")
+	cmd.Env = append(os.Environ(), reexecStageEnv+"=listening")
+	output, err := cmd.Output()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var lines []string
+	for _, x := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(x, "pid=") || strings.HasPrefix(x, "error=") {
+			lines = append(lines, x)
+		}
+	}
+
+	// step: the new binary keeps the pid and the listener
+	if assert.Len(t, lines, 2, "unexpected output: %s", output) {
+		assert.True(t, strings.HasPrefix(lines[0], "pid="))
+		assert.Equal(t, lines[0], lines[1])
+	}
+}
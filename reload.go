@@ -0,0 +1,193 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// reloadServers are the servers supported by the reload option, with the default location of their pid file
+var reloadServers = map[string]string{
+	"nginx":   "/var/run/nginx.pid",
+	"haproxy": "/var/run/haproxy.pid",
+}
+
+// reloadCheckInterval is the interval the served certificate is checked after a reload
+var reloadCheckInterval = time.Duration(500) * time.Millisecond
+
+// reloadServer has the server reload its configuration gracefully, nginx is sent a SIGHUP and haproxy (in master worker
+// mode) a SIGUSR2, and optionally checks the server is serving the new certificate
+//	rn			: the resource
+//	data		: the secret data
+//	timeout		: the time allowed for the server to serve the new certificate
+func reloadServer(rn *VaultResource, data map[string]interface{}, timeout time.Duration) error {
+	signal, found := reloadSignals[rn.reloadServer]
+	if !found {
+		return fmt.Errorf("reloading %s is not supported on this platform", rn.reloadServer)
+	}
+	pid, err := serverPid(rn.reloadServer, rn.reloadPidFile)
+	if err != nil {
+		return err
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	glog.V(3).Infof("sending %s to %s, pid: %d", signal, rn.reloadServer, pid)
+	if err := process.Signal(signal); err != nil {
+		return fmt.Errorf("unable to signal %s, pid: %d, error: %s", rn.reloadServer, pid, err)
+	}
+	if rn.reloadCheck == "" {
+		return nil
+	}
+
+	// step: check the server picked up the certificate
+	content, found := data["certificate"].(string)
+	if !found {
+		glog.V(3).Infof("resource: %s has no certificate, skipping the check of %s", rn, rn.reloadCheck)
+		return nil
+	}
+	cert, err := parseCertificate(content)
+	if err != nil {
+		return err
+	}
+	serverName := rn.options["common_name"]
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(rn.reloadCheck)
+	}
+
+	return waitForCertificate(rn.reloadCheck, serverName, cert.Raw, timeout)
+}
+
+// waitForCertificate waits for the server to serve the certificate
+//	address		: the host:port of the server
+//	serverName	: the server name sent in the handshake
+//	expected	: the der encoding of the certificate
+//	timeout		: the time allowed for the server to serve the certificate
+func waitForCertificate(address, serverName string, expected []byte, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	served := "no certificate"
+	for {
+		dialer := &net.Dialer{Timeout: reloadCheckInterval * 2}
+		conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         serverName,
+		})
+		if err != nil {
+			served = err.Error()
+		} else {
+			peers := conn.ConnectionState().PeerCertificates
+			conn.Close()
+			if len(peers) > 0 {
+				if bytes.Equal(peers[0].Raw, expected) {
+					glog.V(3).Infof("the server at: %s is serving the new certificate", address)
+					return nil
+				}
+				served = fmt.Sprintf("the certificate: %s", formatFingerprint(peers[0].SerialNumber.Bytes()))
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the server at: %s is not serving the new certificate after %s, found %s", address, timeout, served)
+		}
+		time.Sleep(reloadCheckInterval)
+	}
+}
+
+// serverPid returns the pid of the server from the pid file, falling back to searching the processes for the
+// master process of the server when the default pid file doesn't exist i.e. a shared process namespace
+//	server		: the name of the server
+//	pidFile		: the pid file, empty for the default
+func serverPid(server, pidFile string) (int, error) {
+	filename := pidFile
+	if filename == "" {
+		filename = reloadServers[server]
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if pidFile == "" && os.IsNotExist(err) {
+			return findMasterProcess(server)
+		}
+		return 0, fmt.Errorf("unable to read the pid file: %s, error: %s", filename, err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("the pid file: %s does not contain a valid pid", filename)
+	}
+
+	return pid, nil
+}
+
+// findMasterProcess searches /proc for the process of the name whose parent is not of the same name
+func findMasterProcess(name string) (int, error) {
+	files, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return 0, err
+	}
+	parents := make(map[int]int, 0)
+	for _, filename := range files {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		pid, comm, ppid, err := parseProcessStat(string(content))
+		if err != nil || comm != name {
+			continue
+		}
+		parents[pid] = ppid
+	}
+	for pid, ppid := range parents {
+		if _, found := parents[ppid]; !found {
+			return pid, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unable to find the %s process, no pid file and not in the process namespace", name)
+}
+
+// parseProcessStat extracts the pid, command and parent pid from the content of /proc/PID/stat
+func parseProcessStat(content string) (int, string, int, error) {
+	start := strings.Index(content, "(")
+	end := strings.LastIndex(content, ")")
+	if start < 0 || end < start {
+		return 0, "", 0, fmt.Errorf("invalid process stat")
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(content[:start]))
+	if err != nil {
+		return 0, "", 0, err
+	}
+	fields := strings.Fields(content[end+1:])
+	if len(fields) < 2 {
+		return 0, "", 0, fmt.Errorf("invalid process stat")
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	return pid, content[start+1 : end], ppid, nil
+}
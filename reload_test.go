@@ -0,0 +1,140 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProcessStat(t *testing.T) {
+	pid, comm, ppid, err := parseProcessStat("42 (nginx) S 1 42 42 0 -1 4194560 1207 0 0 0")
+	assert.NoError(t, err)
+	assert.Equal(t, 42, pid)
+	assert.Equal(t, "nginx", comm)
+	assert.Equal(t, 1, ppid)
+
+	_, comm, ppid, err = parseProcessStat("43 (my (odd) name) S 42 42 42 0")
+	assert.NoError(t, err)
+	assert.Equal(t, "my (odd) name", comm)
+	assert.Equal(t, 42, ppid)
+
+	_, _, _, err = parseProcessStat("garbage")
+	assert.Error(t, err)
+}
+
+func TestServerPid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "nginx.pid")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("1234\n"), 0644))
+	pid, err := serverPid("nginx", filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 1234, pid)
+
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("nope"), 0644))
+	_, err = serverPid("nginx", filename)
+	assert.Error(t, err)
+
+	_, err = serverPid("nginx", filepath.Join(dir, "missing.pid"))
+	assert.Error(t, err)
+}
+
+func TestReloadServerSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals are not supported on windows")
+	}
+	dir, err := ioutil.TempDir("", "reload")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	// step: a sleep stands in for the server, a SIGHUP terminates it
+	cmd := exec.Command("sleep", "30")
+	if !assert.NoError(t, cmd.Start()) {
+		t.FailNow()
+	}
+	filename := filepath.Join(dir, "nginx.pid")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(fmt.Sprintf("%d", cmd.Process.Pid)), 0644))
+
+	rn := defaultVaultResource()
+	rn.reloadServer = "nginx"
+	rn.reloadPidFile = filename
+	assert.NoError(t, reloadServer(rn, map[string]interface{}{}, time.Second))
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		assert.Error(t, err, "the process should have been signalled")
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("the process was not signalled")
+	}
+}
+
+func TestWaitForCertificate(t *testing.T) {
+	data := readCertificateFixture(t)
+	pair, err := tls.X509KeyPair([]byte(data["certificate"].(string)), []byte(data["private_key"].(string)))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{pair}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	address := listener.Addr().String()
+	assert.NoError(t, waitForCertificate(address, "app.example.com", pair.Certificate[0], time.Second))
+
+	err = waitForCertificate(address, "app.example.com", []byte("another"), 100*time.Millisecond)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not serving the new certificate")
+	}
+
+	// step: nothing listening
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedAddress := closed.Addr().String()
+	closed.Close()
+	assert.Error(t, waitForCertificate(closedAddress, "", pair.Certificate[0], 100*time.Millisecond))
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// isPermissionDenied checks if vault refused a request as the token is no longer valid i.e. it has been revoked
+func isPermissionDenied(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "Code: 403") || strings.Contains(err.Error(), "permission denied"))
+}
+
+// canRelogin checks if a revoked token can be replaced by logging in again; a token given to the sidekick can't
+// be reissued, and one revoked via the admin api is meant to stay that way
+//	url			: the address of the vault
+//	auth		: the authentication options
+func canRelogin(url string, auth *vaultAuthOptions) bool {
+	return auth.Method != "token" && !tokens.isRevoked(url)
+}
+
+// reacquire retrieves again the dynamic secrets whose leases were issued with a token which has been revoked,
+// their leases having been revoked along with it; the renewal timers are left running, the retrieval
+// moving the lease the next renewal acts on
+//	items		: the watched resources
+func (r VaultService) reacquire(items []*watchedResource) {
+	for _, x := range items {
+		if !x.resource.isDynamic() || x.secret == nil || x.secret.LeaseID == "" {
+			continue
+		}
+		glog.Infof("reacquiring the resource: %s, the lease: %s was issued with the revoked token", x.resource, x.secret.LeaseID)
+
+		f := r.fetch(x, "")
+		if f.err != nil {
+			// step: the lease is dead, so the next renewal retrieves the secret rather than renewing it
+			glog.Errorf("failed to reacquire the resource: %s, request id: %s, error: %s", x.resource, f.requestID, f.err)
+			x.secret.Renewable = false
+			r.upstream(VaultEvent{Resource: x.resource, Type: EventTypeFailure, Error: f.err})
+			continue
+		}
+		if f.unchanged {
+			continue
+		}
+		r.persist(x)
+		r.upstream(VaultEvent{
+			Resource: x.resource,
+			Secret:   x.secret.Data,
+			Metadata: newSecretMetadata(x),
+			Type:     EventTypeSuccess,
+		})
+		x.release()
+	}
+}
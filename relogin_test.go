@@ -0,0 +1,76 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPermissionDenied(t *testing.T) {
+	assert.False(t, isPermissionDenied(nil))
+	assert.False(t, isPermissionDenied(errors.New("Code: 500. Errors:\n\n* internal error")))
+	assert.True(t, isPermissionDenied(errors.New("Error making API request.\n\nCode: 403. Errors:\n\n* permission denied")))
+}
+
+func TestCanRelogin(t *testing.T) {
+	url := "https://relogin.example.com"
+	assert.False(t, canRelogin(url, &vaultAuthOptions{Method: "token"}))
+	assert.True(t, canRelogin(url, &vaultAuthOptions{Method: "kubernetes"}))
+
+	tokens.Lock()
+	tokens.items[url] = &tokenStatus{Vault: url, Revoked: true}
+	tokens.Unlock()
+	defer func() {
+		tokens.Lock()
+		delete(tokens.items, url)
+		tokens.Unlock()
+	}()
+	assert.False(t, canRelogin(url, &vaultAuthOptions{Method: "kubernetes"}))
+}
+
+func TestReacquireAfterRelogin(t *testing.T) {
+	service, updates := newMockService(t)
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("mysql:mysql/creds/app:fmt=json"))
+	assert.NoError(t, resources.Set("secret:secret/app:fmt=json"))
+	for _, rn := range resources.items {
+		service.Watch(rn)
+	}
+	for range resources.items {
+		evt := waitForEvent(t, updates)
+		if !assert.Equal(t, EventTypeSuccess, evt.Type) {
+			t.FailNow()
+		}
+	}
+
+	// step: only the dynamic secret is retrieved again
+	service.reloginChannel <- struct{}{}
+	evt := waitForEvent(t, updates)
+	assert.Equal(t, EventTypeSuccess, evt.Type)
+	assert.Equal(t, "mysql", evt.Resource.resource)
+	assert.Equal(t, "v-app-8f53", evt.Secret["username"])
+	select {
+	case evt := <-updates:
+		t.Errorf("unexpected event for the resource: %s", evt.Resource)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
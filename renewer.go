@@ -0,0 +1,41 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/UKHomeOffice/vault-sidekick/renewer"
+	"github.com/golang/glog"
+)
+
+// NewRenewer creates a renewer of the leases of the watched resources issued by the vault, with no callbacks
+func (r *VaultService) NewRenewer() *renewer.Renewer {
+	return renewer.New(func(lease renewer.Lease) error {
+		x := lease.(*watchedResource)
+		span := startSpan(x.resource, "vault.renew")
+		span.setAttribute("lease.id", x.secret.LeaseID)
+		op := r.withRequestID()
+		span.setAttribute("request.id", op.requestID)
+		err := op.renew(x)
+		span.finish(err)
+		metrics.add(metricRenewals, 1, resourceLabels(x.resource, "status", statusLabel(err))...)
+		if err != nil && !renewer.IsNotRenewable(err) {
+			glog.Errorf("failed to renew the resource: %s for renewal, request id: %s, error: %s", x.resource, op.requestID, err)
+		}
+
+		return err
+	})
+}
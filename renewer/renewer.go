@@ -0,0 +1,92 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package renewer renews the leases issued by vault, handing the outcome of each renewal to the callbacks, so the
+// side effects of a renewal i.e. rescheduling the next or notifying the consumers of the secret are left to the
+// embedder
+package renewer
+
+import (
+	"strings"
+	"time"
+)
+
+// Lease is a lease issued by vault, as held by the embedder
+type Lease interface {
+	// LeaseID returns the id of the lease
+	LeaseID() string
+	// LeaseExpires returns the time the lease expires
+	LeaseExpires() time.Time
+	// LeaseRenewable checks if the lease can be renewed
+	LeaseRenewable() bool
+}
+
+// Renewer renews leases, handing the outcome to the callbacks; a callback left nil is skipped
+type Renewer struct {
+	// renew performs the renewal against vault
+	renew func(lease Lease) error
+	// OnRenew is called once the lease has been renewed
+	OnRenew func(lease Lease)
+	// OnExpire is called when the lease can't be renewed and a new secret has to be read; expired is true when
+	// the lease has expired or was never renewable, false when it's valid until it expires but can't be extended
+	OnExpire func(lease Lease, expired bool)
+	// OnError is called when the renewal failed and should be retried
+	OnError func(lease Lease, err error)
+}
+
+// New creates a renewer with no callbacks
+//	renew		: renews the lease against vault, updating the lease held by the embedder
+func New(renew func(lease Lease) error) *Renewer {
+	return &Renewer{renew: renew}
+}
+
+// Renew renews the lease, calling back with the outcome
+//	lease		: the lease to renew
+func (r *Renewer) Renew(lease Lease) {
+	// step: an expired lease can't be renewed, a new secret has to be read
+	if time.Now().After(lease.LeaseExpires()) || !lease.LeaseRenewable() {
+		r.expire(lease, true)
+		return
+	}
+
+	err := r.renew(lease)
+	switch {
+	case IsNotRenewable(err):
+		// step: the lease can't be renewed any further, a new secret is read before it expires rather than retrying
+		r.expire(lease, false)
+	case err != nil:
+		if r.OnError != nil {
+			r.OnError(lease, err)
+		}
+	default:
+		if r.OnRenew != nil {
+			r.OnRenew(lease)
+		}
+	}
+}
+
+// expire calls back that the lease can't be renewed
+func (r *Renewer) expire(lease Lease, expired bool) {
+	if r.OnExpire != nil {
+		r.OnExpire(lease, expired)
+	}
+}
+
+// IsNotRenewable checks if a renewal was refused as the lease can't be renewed, i.e. a non renewable lease or
+// one which has reached the max ttl of the backend
+func IsNotRenewable(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "not renewable")
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package renewer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLease is a lease held in memory
+type fakeLease struct {
+	expires   time.Time
+	renewable bool
+}
+
+func (l *fakeLease) LeaseID() string         { return "mysql/creds/app/1" }
+func (l *fakeLease) LeaseExpires() time.Time { return l.expires }
+func (l *fakeLease) LeaseRenewable() bool    { return l.renewable }
+
+// recordingRenewer returns a renewer whose renewal returns the error, recording the callbacks made
+func recordingRenewer(err error) (*Renewer, *[]string) {
+	var calls []string
+	renewer := New(func(lease Lease) error { return err })
+	renewer.OnRenew = func(lease Lease) { calls = append(calls, "renew") }
+	renewer.OnError = func(lease Lease, err error) { calls = append(calls, "error: "+err.Error()) }
+	renewer.OnExpire = func(lease Lease, expired bool) {
+		calls = append(calls, map[bool]string{true: "expired", false: "expiring"}[expired])
+	}
+
+	return renewer, &calls
+}
+
+func TestRenewerCallbacks(t *testing.T) {
+	lease := func(renewable bool, remaining time.Duration) *fakeLease {
+		return &fakeLease{expires: time.Now().Add(remaining), renewable: renewable}
+	}
+
+	renewer, calls := recordingRenewer(nil)
+	renewer.Renew(lease(true, time.Hour))
+	renewer.Renew(lease(true, -time.Second))
+	renewer.Renew(lease(false, time.Hour))
+	assert.Equal(t, []string{"renew", "expired", "expired"}, *calls)
+
+	renewer, calls = recordingRenewer(errors.New("Code: 400. Errors:\n\n* lease is not renewable"))
+	renewer.Renew(lease(true, time.Hour))
+	assert.Equal(t, []string{"expiring"}, *calls)
+
+	renewer, calls = recordingRenewer(errors.New("connection refused"))
+	renewer.Renew(lease(true, time.Hour))
+	assert.Equal(t, []string{"error: connection refused"}, *calls)
+
+	// step: an embedder need only set the callbacks it cares about
+	New(func(lease Lease) error { return nil }).Renew(lease(true, time.Hour))
+}
+
+func TestIsNotRenewable(t *testing.T) {
+	assert.True(t, IsNotRenewable(errors.New("Error making API request.\n\nCode: 400. Errors:\n\n* lease is not renewable")))
+	assert.False(t, IsNotRenewable(errors.New("permission denied")))
+	assert.False(t, IsNotRenewable(nil))
+}
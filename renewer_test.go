@@ -0,0 +1,46 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/UKHomeOffice/vault-sidekick/renewer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVaultRenewer(t *testing.T) {
+	service, _ := newMockService(t)
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("mysql:mysql/creds/app:fmt=json"))
+	x := &watchedResource{resource: resources.items[0]}
+	if !assert.NoError(t, service.get(x)) {
+		t.FailNow()
+	}
+
+	// step: the watched resource is the lease handed to the callbacks
+	renewed := false
+	renewals := service.NewRenewer()
+	renewals.OnRenew = func(lease renewer.Lease) { renewed = lease == x }
+	renewals.OnError = func(lease renewer.Lease, err error) { t.Errorf("unexpected error: %s", err) }
+	renewals.Renew(x)
+	assert.True(t, renewed)
+	assert.Equal(t, x.secret.LeaseID, x.LeaseID())
+	assert.True(t, x.leaseExpireTime.After(time.Now()))
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// requestIDHeader is the header carrying the id of the operation a request to vault was made for
+const requestIDHeader = "X-Request-ID"
+
+// userAgentTransport sets the user agent of the requests made to vault
+type userAgentTransport struct {
+	// the transport making the request
+	transport http.RoundTripper
+	// the user agent of the requests
+	agent string
+}
+
+// RoundTrip sets the user agent, leaving the request of the caller untouched
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.agent)
+
+	return t.transport.RoundTrip(req)
+}
+
+// userAgent returns the user agent of the requests made to vault, by default the version of the sidekick and the
+// pod it's running in, so the requests can be told apart in the audit log
+//	opts		: the configuration of the sidekick
+func userAgent(opts *config) string {
+	if opts.userAgent != "" {
+		return opts.userAgent
+	}
+	var details []string
+	if gitsha != "" {
+		details = append(details, "git+sha "+gitsha)
+	}
+	if name := podName(); name != "" {
+		if namespace := podNamespace(); namespace != "" {
+			name = namespace + "/" + name
+		}
+		details = append(details, "pod "+name)
+	}
+	agent := fmt.Sprintf("%s/%s", prog, release)
+	if len(details) > 0 {
+		agent = fmt.Sprintf("%s (%s)", agent, strings.Join(details, "; "))
+	}
+
+	return agent
+}
+
+// newRequestID generates a random version 4 uuid identifying an operation
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// withRequestID returns a copy of the service whose operations are tagged with a new request id
+func (r VaultService) withRequestID() VaultService {
+	r.requestID = newRequestID()
+
+	return r
+}
+
+// tagRequests returns a copy of the service whose clients send the request id of the operation, the clients
+// having been shaped for the resource already i.e. the timeout and namespace
+//	rn			: the resource the operation is for
+func (r VaultService) tagRequests(rn *VaultResource) (VaultService, error) {
+	if r.requestID == "" {
+		return r, nil
+	}
+	headers := http.Header{requestIDHeader: []string{r.requestID}}
+	if rn.namespace != "" {
+		headers.Set("X-Vault-Namespace", rn.namespace)
+	} else if options.vaultNamespace != "" {
+		headers.Set("X-Vault-Namespace", options.vaultNamespace)
+	}
+
+	var err error
+	if r.client, err = taggedClient(r.client, headers); err != nil {
+		return r, err
+	}
+	if r.readClient != nil {
+		if r.readClient, err = taggedClient(r.readClient, headers); err != nil {
+			return r, err
+		}
+	}
+
+	return r, nil
+}
+
+// taggedClient clones the client, sending the headers with each request
+//	client		: the client to clone
+//	headers		: the headers of the requests
+func taggedClient(client *api.Client, headers http.Header) (*api.Client, error) {
+	// step: the clone shares the http client, keeping the timeout and retries of the parent
+	x, err := client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	x.SetHeaders(headers)
+	x.SetToken(client.Token())
+
+	return x, nil
+}
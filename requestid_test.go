@@ -0,0 +1,93 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserAgent(t *testing.T) {
+	os.Setenv("POD_NAME", "web-0")
+	os.Setenv("POD_NAMESPACE", "apps")
+	defer os.Unsetenv("POD_NAME")
+	defer os.Unsetenv("POD_NAMESPACE")
+
+	assert.Equal(t, prog+"/"+release+" (pod apps/web-0)", userAgent(&config{}))
+	assert.Equal(t, "custom/1.0", userAgent(&config{userAgent: "custom/1.0"}))
+}
+
+func TestNewRequestID(t *testing.T) {
+	uuid := regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$")
+	first := newRequestID()
+	assert.Regexp(t, uuid, first)
+	assert.NotEqual(t, first, newRequestID())
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"agent":     r.Header.Get("User-Agent"),
+			"id":        r.Header.Get(requestIDHeader),
+			"namespace": r.Header.Get("X-Vault-Namespace"),
+			"token":     r.Header.Get("X-Vault-Token"),
+		}})
+	}))
+	defer server.Close()
+
+	namespace := options.vaultNamespace
+	options.vaultNamespace = "org"
+	defer func() { options.vaultNamespace = namespace }()
+	client, err := newAPIClient(&config{vaultMaxRetries: 0, vaultNamespace: "org", userAgent: "sidekick-test"}, server.URL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	client.SetToken("token")
+	service := VaultService{client: client}
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:shared/db:no-cache=true"))
+	assert.NoError(t, resources.Set("secret:shared/db:no-cache=true,ns=org/team-a"))
+	rn := &watchedResource{resource: resources.items[0]}
+
+	// step: without an operation only the user agent is sent
+	if assert.NoError(t, service.get(rn)) {
+		assert.Equal(t, "sidekick-test", rn.secret.Data["agent"])
+		assert.Equal(t, "", rn.secret.Data["id"])
+	}
+
+	// step: each operation has an id of its own, keeping the namespace and token of the client
+	op := service.withRequestID()
+	if assert.NoError(t, op.get(rn)) {
+		assert.Equal(t, op.requestID, rn.secret.Data["id"])
+		assert.Equal(t, "org", rn.secret.Data["namespace"])
+		assert.Equal(t, "token", rn.secret.Data["token"])
+	}
+	next := service.withRequestID()
+	assert.NotEqual(t, op.requestID, next.requestID)
+	team := &watchedResource{resource: resources.items[1]}
+	if assert.NoError(t, next.get(team)) {
+		assert.Equal(t, next.requestID, team.secret.Data["id"])
+		assert.Equal(t, "org/team-a", team.secret.Data["namespace"])
+	}
+}
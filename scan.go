@@ -0,0 +1,213 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// the actions taken on the findings of a scan
+	scanActionReport = "report"
+	scanActionFix    = "fix"
+	// the kinds of finding
+	scanUnexpected = "unexpected"
+	scanMode       = "mode"
+	scanOwner      = "owner"
+	// sidekickFilePrefix is the prefix of the files the sidekick keeps in the output directory i.e. the lock
+	sidekickFilePrefix = ".vault-sidekick"
+)
+
+// dirScanner periodically checks the output directory for files the sidekick didn't write, files whose
+// permissions are wider than the resource asked for and files owned by another user, as left by other
+// containers sharing the secrets volume
+type dirScanner struct {
+	// the directory scanned
+	dir string
+	// fix the findings rather than only report them
+	fix bool
+	// the patterns of the base names of files expected in the directory
+	allow []string
+	// the resources written to the directory
+	resources []*VaultResource
+}
+
+// scanFinding is a file found to be out of place by a scan
+type scanFinding struct {
+	// the path of the file
+	path string
+	// unexpected, mode or owner
+	kind string
+	// a description of what was found
+	detail string
+}
+
+// newDirScanner creates a scanner of the output directory
+//	dir			: the directory to scan
+//	action		: report or fix
+//	allow		: a comma separated list of patterns of the files expected in the directory
+//	resources	: the resources written to the directory
+func newDirScanner(dir, action, allow string, resources []*VaultResource) (*dirScanner, error) {
+	if action != scanActionReport && action != scanActionFix {
+		return nil, fmt.Errorf("the scan action: %s is invalid, should be %s or %s", action, scanActionReport, scanActionFix)
+	}
+	patterns := splitNames(allow)
+	for _, x := range patterns {
+		if _, err := filepath.Match(x, ""); err != nil {
+			return nil, fmt.Errorf("the scan pattern: %s is invalid, error: %s", x, err)
+		}
+	}
+
+	return &dirScanner{
+		dir:       filepath.Clean(dir),
+		fix:       action == scanActionFix,
+		allow:     patterns,
+		resources: resources,
+	}, nil
+}
+
+// start scans the directory on the interval
+func (s *dirScanner) start(interval time.Duration) {
+	glog.Infof("scanning the directory: %s every %s for unexpected files, fixing: %t", s.dir, interval, s.fix)
+	go func() {
+		for range time.NewTicker(interval).C {
+			s.scan()
+		}
+	}()
+}
+
+// scan walks the directory, reporting and if required fixing the files out of place
+func (s *dirScanner) scan() []scanFinding {
+	var findings []scanFinding
+	filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			glog.V(4).Infof("unable to scan the file: %s, error: %s", path, err)
+			return nil
+		}
+		if path == s.dir {
+			return nil
+		}
+		expected, rn := s.expected(path)
+		if !expected {
+			if info.IsDir() && s.ancestor(path) {
+				return nil
+			}
+			findings = append(findings, s.handle(scanFinding{path: path, kind: scanUnexpected, detail: "not written by the sidekick"}, info))
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if uid, found := fileOwner(info); found && uid != os.Geteuid() {
+			findings = append(findings, s.handle(scanFinding{path: path, kind: scanOwner, detail: fmt.Sprintf("owned by uid: %d", uid)}, info))
+		}
+		if rn != nil && info.Mode().IsRegular() {
+			if wider := info.Mode().Perm() &^ rn.fileMode.Perm(); wider != 0 {
+				findings = append(findings, s.handle(scanFinding{path: path, kind: scanMode, detail: fmt.Sprintf("mode: %s, expected: %s", info.Mode().Perm(), rn.fileMode.Perm())}, info))
+			}
+		}
+
+		return nil
+	})
+
+	return findings
+}
+
+// expected checks if the file belongs in the directory, returning the resource which wrote it if known
+//	path		: the path of the file
+func (s *dirScanner) expected(path string) (bool, *VaultResource) {
+	base := filepath.Base(path)
+	for _, x := range s.allow {
+		if matched, _ := filepath.Match(x, base); matched {
+			return true, nil
+		}
+	}
+	if strings.HasPrefix(base, sidekickFilePrefix) {
+		return true, nil
+	}
+	for _, rn := range s.resources {
+		filename := filepath.Clean(resolveFilename(rn.GetFilename()))
+		// step: the file itself, its directory layouts and the files kept alongside i.e. .prev, .meta.json, .password
+		if path == filename || strings.HasPrefix(path, filename+"/") ||
+			strings.HasPrefix(path, filename+".") || strings.HasPrefix(path, filename+"-") {
+			return true, rn
+		}
+		// step: the temporary file of a write in progress
+		if filepath.Dir(path) == filepath.Dir(filename) && strings.HasPrefix(base, "."+filepath.Base(filename)+".") {
+			return true, rn
+		}
+	}
+
+	return false, nil
+}
+
+// ancestor checks if the directory holds the file of a resource
+//	path		: the path of the directory
+func (s *dirScanner) ancestor(path string) bool {
+	for _, rn := range s.resources {
+		if strings.HasPrefix(filepath.Clean(resolveFilename(rn.GetFilename())), path+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handle reports a finding and fixes it if required; unexpected directories are only reported, the sidekick
+// having no way of knowing what's in use
+//	finding		: the file out of place
+//	info		: the file information
+func (s *dirScanner) handle(finding scanFinding, info os.FileInfo) scanFinding {
+	metrics.add(metricScanFindings, 1, "kind", finding.kind)
+	if !s.fix || (finding.kind == scanUnexpected && info.IsDir()) {
+		glog.Warningf("found an out of place file: %s in the output directory, %s", finding.path, finding.detail)
+		return finding
+	}
+
+	var err error
+	switch finding.kind {
+	case scanUnexpected:
+		err = os.Remove(finding.path)
+	case scanMode:
+		err = os.Chmod(finding.path, info.Mode().Perm()&s.modeOf(finding.path))
+	case scanOwner:
+		err = os.Lchown(finding.path, os.Geteuid(), os.Getegid())
+	}
+	if err != nil {
+		glog.Errorf("failed to fix the out of place file: %s, %s, error: %s", finding.path, finding.detail, err)
+		return finding
+	}
+	glog.Warningf("fixed the out of place file: %s in the output directory, %s", finding.path, finding.detail)
+
+	return finding
+}
+
+// modeOf returns the file mode of the resource which wrote the file
+//	path		: the path of the file
+func (s *dirScanner) modeOf(path string) os.FileMode {
+	if _, rn := s.expected(path); rn != nil {
+		return rn.fileMode.Perm()
+	}
+
+	return os.ModePerm
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newScanFixture(t *testing.T) (string, []*VaultResource) {
+	dir, err := ioutil.TempDir("", "scan")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/db:file="+filepath.Join(dir, "db.json")+",mode=0600"))
+	assert.NoError(t, resources.Set("pki:pki/issue/web:common_name=web.example.org,file="+filepath.Join(dir, "certs/web")))
+
+	for name, mode := range map[string]os.FileMode{
+		"db.json":              0600,
+		"db.json.prev":         0600,
+		".db.json.123":         0600,
+		".vault-sidekick.lock": 0644,
+		"certs/web.crt":        0664,
+		"certs/web.key":        0666,
+		"dropped.txt":          0644,
+		"app.conf":             0644,
+		"certs/other.pem":      0644,
+	} {
+		filename := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		assert.NoError(t, ioutil.WriteFile(filename, []byte("data"), mode))
+		assert.NoError(t, os.Chmod(filename, mode))
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "other"), 0755))
+
+	return dir, resources.items
+}
+
+func findingsOf(findings []scanFinding) []string {
+	var list []string
+	for _, x := range findings {
+		list = append(list, x.kind+":"+filepath.Base(x.path))
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+func TestNewDirScanner(t *testing.T) {
+	_, err := newDirScanner("/tmp", "delete", "", nil)
+	assert.Error(t, err)
+	_, err = newDirScanner("/tmp", scanActionFix, "[", nil)
+	assert.Error(t, err)
+	s, err := newDirScanner("/tmp/", scanActionFix, "*.conf, README", nil)
+	assert.NoError(t, err)
+	assert.True(t, s.fix)
+	assert.Equal(t, "/tmp", s.dir)
+	assert.Equal(t, []string{"*.conf", "README"}, s.allow)
+}
+
+func TestScanReport(t *testing.T) {
+	dir, resources := newScanFixture(t)
+	defer os.RemoveAll(dir)
+
+	s, err := newDirScanner(dir, scanActionReport, "*.conf", resources)
+	assert.NoError(t, err)
+	findings := findingsOf(s.scan())
+	assert.Equal(t, []string{"mode:web.key", "unexpected:dropped.txt", "unexpected:other", "unexpected:other.pem"}, findings)
+
+	// step: nothing is changed when reporting
+	_, err = os.Stat(filepath.Join(dir, "dropped.txt"))
+	assert.NoError(t, err)
+}
+
+func TestScanFix(t *testing.T) {
+	dir, resources := newScanFixture(t)
+	defer os.RemoveAll(dir)
+
+	s, err := newDirScanner(dir, scanActionFix, "*.conf", resources)
+	assert.NoError(t, err)
+	assert.Len(t, s.scan(), 4)
+
+	// step: the unexpected files are removed, the directories are kept and the permissions narrowed
+	_, err = os.Stat(filepath.Join(dir, "dropped.txt"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "certs/other.pem"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "other"))
+	assert.NoError(t, err)
+	stat, err := os.Stat(filepath.Join(dir, "certs/web.key"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0664), stat.Mode().Perm())
+
+	// step: only the unexpected directory is left to report
+	assert.Equal(t, []string{"unexpected:other"}, findingsOf(s.scan()))
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// scheduleRenew is a renewal of the lease of the secret
+	scheduleRenew = "renew"
+	// scheduleFetch is a retrieval of the secret from vault
+	scheduleFetch = "fetch"
+	// scheduleNone is a resource which is never updated
+	scheduleNone = "none"
+)
+
+// schedule holds the next update of each of the resources
+var schedule = newResourceSchedule()
+
+// scheduledUpdate is the next update of a resource
+type scheduledUpdate struct {
+	// the identifier of the resource
+	ID string `json:"id"`
+	// the resource type
+	Resource string `json:"resource"`
+	// the path of the resource
+	Path string `json:"path"`
+	// renew, fetch or none
+	Action string `json:"action"`
+	// the time of the update
+	Next *time.Time `json:"next,omitempty"`
+	// the time until the update, at the time the schedule was listed
+	In string `json:"in,omitempty"`
+	// why the update was scheduled at the time
+	Reason string `json:"reason"`
+	// the time the lease of the secret expires
+	LeaseExpires *time.Time `json:"lease_expires,omitempty"`
+}
+
+// resourceSchedule holds the next update of the resources
+type resourceSchedule struct {
+	sync.RWMutex
+	// the next update of the resources, keyed by id
+	items map[string]*scheduledUpdate
+}
+
+// newResourceSchedule creates an empty schedule
+func newResourceSchedule() *resourceSchedule {
+	return &resourceSchedule{items: make(map[string]*scheduledUpdate, 0)}
+}
+
+// set records the next update of a resource
+//
+//	rn			: the resource
+//	action		: the update, renew, fetch or none
+//	in			: the time until the update, ignored if the action is none
+//	reason		: why the update was scheduled at the time
+//	expires		: the time the lease of the secret expires, if any
+func (s *resourceSchedule) set(rn *VaultResource, action string, in time.Duration, reason string, expires time.Time) {
+	x := &scheduledUpdate{
+		ID:       rn.ID(),
+		Resource: rn.resource,
+		Path:     rn.path,
+		Action:   action,
+		Reason:   reason,
+	}
+	if action != scheduleNone {
+		next := time.Now().Add(in)
+		x.Next = &next
+	}
+	if !expires.IsZero() {
+		x.LeaseExpires = &expires
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.items[x.ID] = x
+}
+
+// remove drops the resource from the schedule
+//
+//	rn			: the resource
+func (s *resourceSchedule) remove(rn *VaultResource) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.items, rn.ID())
+}
+
+// list returns a copy of the schedule ordered by the time of the update, the resources never updated last
+//
+//	now			: the time the time until each update is calculated from
+func (s *resourceSchedule) list(now time.Time) []scheduledUpdate {
+	s.RLock()
+	defer s.RUnlock()
+	var list []scheduledUpdate
+	for _, x := range s.items {
+		item := *x
+		if item.Next != nil {
+			in := item.Next.Sub(now)
+			if in < 0 {
+				in = 0
+			}
+			item.In = in.Truncate(time.Second).String()
+		}
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		switch {
+		case list[i].Next == nil || list[j].Next == nil:
+			if list[i].Next == nil && list[j].Next == nil {
+				return list[i].ID < list[j].ID
+			}
+			return list[j].Next == nil
+		case list[i].Next.Equal(*list[j].Next):
+			return list[i].ID < list[j].ID
+		}
+		return list[i].Next.Before(*list[j].Next)
+	})
+
+	return list
+}
+
+// formatSchedule renders the schedule as a table
+//
+//	list		: the schedule
+func formatSchedule(list []scheduledUpdate) string {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tACTION\tNEXT\tIN\tLEASE EXPIRES\tREASON")
+	for _, x := range list {
+		next, expires := "-", "-"
+		if x.Next != nil {
+			next = x.Next.UTC().Format(time.RFC3339)
+		}
+		if x.LeaseExpires != nil {
+			expires = x.LeaseExpires.UTC().Format(time.RFC3339)
+		}
+		in := x.In
+		if in == "" {
+			in = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", x.ID, x.Action, next, in, expires, x.Reason)
+	}
+	w.Flush()
+
+	return b.String()
+}
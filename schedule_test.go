@@ -0,0 +1,129 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleList(t *testing.T) {
+	s := newResourceSchedule()
+	resource := func(path string) *VaultResource {
+		rn := defaultVaultResource()
+		rn.resource = "secret"
+		rn.path = path
+		return rn
+	}
+	expires := time.Now().Add(time.Hour)
+	s.set(resource("secret/later"), scheduleFetch, 30*time.Minute, "the update option of 30m0s", time.Time{})
+	s.set(resource("secret/never"), scheduleNone, 0, "no lease", time.Time{})
+	s.set(resource("secret/soon"), scheduleRenew, time.Minute, "80-95% of the lease ttl of 1h0m0s", expires)
+
+	list := s.list(time.Now())
+	if !assert.Len(t, list, 3) {
+		t.FailNow()
+	}
+	assert.Equal(t, "secret/soon", list[0].Path)
+	assert.Equal(t, scheduleRenew, list[0].Action)
+	assert.NotNil(t, list[0].LeaseExpires)
+	assert.NotEmpty(t, list[0].In)
+	assert.Equal(t, "secret/later", list[1].Path)
+	assert.Equal(t, "secret/never", list[2].Path)
+	assert.Nil(t, list[2].Next)
+	assert.Empty(t, list[2].In)
+
+	table := strings.Split(strings.TrimSpace(formatSchedule(list)), "\n")
+	if assert.Len(t, table, 4) {
+		assert.True(t, strings.HasPrefix(table[0], "RESOURCE"))
+		assert.Contains(t, table[1], "80-95% of the lease ttl of 1h0m0s")
+		assert.Contains(t, table[3], "none")
+	}
+}
+
+func TestNotifyOnRenewalSchedule(t *testing.T) {
+	cases := []struct {
+		Update    time.Duration
+		Resource  string
+		Secret    *api.Secret
+		Expires   time.Duration
+		Action    string
+		Reason    string
+		Scheduled bool
+	}{
+		{
+			Update:    time.Hour,
+			Secret:    &api.Secret{},
+			Action:    scheduleFetch,
+			Reason:    "the update option of 1h0m0s",
+			Scheduled: true,
+		},
+		{
+			Secret:    &api.Secret{LeaseID: "mysql/creds/app/1", LeaseDuration: 3600, Renewable: true},
+			Expires:   time.Hour,
+			Action:    scheduleRenew,
+			Reason:    "80-95% of the lease ttl of 1h0m0s",
+			Scheduled: true,
+		},
+		{
+			Resource:  "pki",
+			Secret:    &api.Secret{LeaseDuration: 3600},
+			Expires:   time.Hour,
+			Action:    scheduleFetch,
+			Reason:    "80-95% of the certificate ttl of 1h0m0s",
+			Scheduled: true,
+		},
+		{
+			Secret: &api.Secret{},
+			Action: scheduleNone,
+			Reason: "the secret has no lease duration and no update option is set",
+		},
+	}
+	for i, c := range cases {
+		rn := defaultVaultResource()
+		rn.resource = "secret"
+		if c.Resource != "" {
+			rn.resource = c.Resource
+		}
+		rn.path = "secret/schedule"
+		rn.update = c.Update
+		rn.renewable = true
+		x := &watchedResource{resource: rn, secret: c.Secret}
+		if c.Expires > 0 {
+			x.leaseExpireTime = time.Now().Add(c.Expires)
+		}
+		x.notifyOnRenewal(make(chan *watchedResource, 1))
+
+		var found *scheduledUpdate
+		for _, item := range schedule.list(time.Now()) {
+			if item.ID == rn.ID() {
+				item := item
+				found = &item
+			}
+		}
+		if !assert.NotNil(t, found, "case %d, the resource was not scheduled", i) {
+			continue
+		}
+		assert.Equal(t, c.Action, found.Action, "case %d, unexpected action", i)
+		assert.Equal(t, c.Reason, found.Reason, "case %d, unexpected reason", i)
+		assert.Equal(t, c.Scheduled, found.Next != nil, "case %d", i)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// secretPolicy is the requirements a secret must meet before it replaces the files of the resource, so an empty
+// password or a missing key never overwrites the last good copy
+type secretPolicy struct {
+	// the keys which must be present and not empty
+	required []string
+	// the minimum length of the values checked
+	minLength int
+	// the minimum shannon entropy of the values checked, in bits
+	minEntropy float64
+	// the patterns the values of the keys must match, by key
+	patterns map[string]*regexp.Regexp
+}
+
+// policyViolation is the error of a secret which breaks the policy of its resource
+type policyViolation struct {
+	// the resource
+	resource *VaultResource
+	// the requirements the secret breaks
+	reasons []string
+}
+
+// Error returns the requirements broken
+func (p *policyViolation) Error() string {
+	return fmt.Sprintf("the secret of resource: %s breaks its policy, %s", p.resource, strings.Join(p.reasons, ", "))
+}
+
+// check checks the secret against the policy; the length and entropy are checked on the values of the required
+// keys, or on every string value when none are required. The values themselves are never part of the error
+//	rn			: the resource
+//	data		: the secret data
+func (p *secretPolicy) check(rn *VaultResource, data map[string]interface{}) error {
+	var reasons []string
+	for _, key := range p.required {
+		if value, found := data[key]; !found || value == nil || value == "" {
+			reasons = append(reasons, fmt.Sprintf("the key: %s is missing or empty", key))
+		}
+	}
+
+	keys := p.required
+	if len(keys) == 0 {
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	for _, key := range keys {
+		value, found := data[key].(string)
+		if !found {
+			continue
+		}
+		if length := utf8.RuneCountInString(value); length < p.minLength {
+			reasons = append(reasons, fmt.Sprintf("the key: %s is %d characters, the minimum is %d", key, length, p.minLength))
+		}
+		if bits := entropyBits(value); bits < p.minEntropy {
+			reasons = append(reasons, fmt.Sprintf("the key: %s has %.1f bits of entropy, the minimum is %.0f", key, bits, p.minEntropy))
+		}
+	}
+
+	var patterned []string
+	for k := range p.patterns {
+		patterned = append(patterned, k)
+	}
+	sort.Strings(patterned)
+	for _, key := range patterned {
+		if value, found := data[key]; !found || !p.patterns[key].MatchString(fmt.Sprintf("%v", value)) {
+			reasons = append(reasons, fmt.Sprintf("the key: %s doesn't match the pattern: %s", key, p.patterns[key]))
+		}
+	}
+	if len(reasons) > 0 {
+		return &policyViolation{resource: rn, reasons: reasons}
+	}
+
+	return nil
+}
+
+// checkSecretPolicy checks the secret of an update against the policy of the resource, if any
+//	rn			: the resource
+//	data		: the secret data
+func checkSecretPolicy(rn *VaultResource, data map[string]interface{}) error {
+	if rn.policy == nil {
+		return nil
+	}
+	err := rn.policy.check(rn, data)
+	if err != nil {
+		metrics.add(metricPolicyViolations, 1, resourceLabels(rn)...)
+		registry.update(rn, func(x *resourceStatus) {
+			x.Violation = err.Error()
+		})
+	}
+
+	return err
+}
+
+// entropyBits estimates the entropy of the value from the frequency of its characters, the shannon entropy per
+// character times the length; a repeated character is 0 bits, 16 random hex characters around 64
+//	value		: the value
+func entropyBits(value string) float64 {
+	counts := make(map[rune]int, 0)
+	total := 0
+	for _, c := range value {
+		counts[c]++
+		total++
+	}
+	bits := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		bits -= p * math.Log2(p)
+	}
+
+	return bits * float64(total)
+}
+
+// secretPolicy returns the policy of the resource, creating it on the first of its options
+func (r *VaultResource) secretPolicy() *secretPolicy {
+	if r.policy == nil {
+		r.policy = &secretPolicy{patterns: make(map[string]*regexp.Regexp, 0)}
+	}
+
+	return r.policy
+}
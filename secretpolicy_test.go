@@ -0,0 +1,125 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretPolicyOptions(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/db:require=username|password,min-length=12,min-entropy=40,match.username='^app-[a-z]+$'"))
+	rn := resources.items[0]
+	if !assert.NotNil(t, rn.policy) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{"username", "password"}, rn.policy.required)
+	assert.Equal(t, 12, rn.policy.minLength)
+	assert.Equal(t, float64(40), rn.policy.minEntropy)
+	assert.Contains(t, rn.policy.patterns, "username")
+	assert.Empty(t, rn.options)
+
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("secret:secret/db"))
+	assert.Nil(t, resources.items[0].policy)
+
+	for _, spec := range []string{"secret:secret/db:min-length=x", "secret:secret/db:min-entropy=-1", "secret:secret/db:match.password=("} {
+		assert.Error(t, resources.Set(spec), spec)
+	}
+}
+
+func TestSecretPolicyCheck(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/db:require=username|password,min-length=8,match.username='^app-'"))
+	rn := resources.items[0]
+
+	assert.NoError(t, rn.policy.check(rn, map[string]interface{}{"username": "app-web01", "password": "Xk29!fQz7", "ttl": 3600}))
+
+	err := rn.policy.check(rn, map[string]interface{}{"username": "root", "password": ""})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the key: password is missing or empty")
+		assert.Contains(t, err.Error(), "the key: username is 4 characters, the minimum is 8")
+		assert.Contains(t, err.Error(), "the key: username doesn't match the pattern: ^app-")
+		assert.NotContains(t, err.Error(), "root")
+	}
+	assert.Error(t, rn.policy.check(rn, map[string]interface{}{"password": "Xk29!fQz7"}))
+
+	// step: without required keys every string value is checked
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("secret:secret/db:min-entropy=20"))
+	rn = resources.items[0]
+	assert.NoError(t, rn.policy.check(rn, map[string]interface{}{"token": "9f86d081884c7d65", "count": 1}))
+	assert.Error(t, rn.policy.check(rn, map[string]interface{}{"token": "9f86d081884c7d65", "other": "aaaaaaaaaaaa"}))
+}
+
+func TestEntropyBits(t *testing.T) {
+	assert.Equal(t, float64(0), entropyBits(""))
+	assert.Equal(t, float64(0), entropyBits("aaaaaaaa"))
+	assert.Equal(t, float64(8), entropyBits("abababab"))
+	assert.InDelta(t, 64, entropyBits("0123456789abcdef"), 0.001)
+}
+
+func TestSecretPolicyAlert(t *testing.T) {
+	var lock sync.Mutex
+	var events []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		var event map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+	}))
+	defer server.Close()
+
+	original := registry
+	registry = newStatusRegistry()
+	defer func() { registry = original }()
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/db:require=password"))
+	rn := resources.items[0]
+	registry.register(rn)
+	alerts, err := newAlertEmitter(server.URL, "", "", time.Duration(10)*time.Minute)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// step: a broken policy is alerted on at once rather than after the threshold
+	assert.Error(t, checkSecretPolicy(rn, map[string]interface{}{"password": ""}))
+	registry.failure(rn, nil)
+	alerts.check(time.Now())
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "trigger", events[0]["action"])
+		assert.Contains(t, events[0]["violation"], "the key: password is missing or empty")
+		assert.Contains(t, events[0]["summary"], "refused the secret")
+	}
+
+	assert.NoError(t, checkSecretPolicy(rn, map[string]interface{}{"password": "secret"}))
+	registry.success(rn, nil)
+	assert.Empty(t, registry.list()[0].Violation)
+	alerts.check(time.Now())
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "resolve", events[1]["action"])
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// selinuxModeSet labels the files written with the selinux context
+	selinuxModeSet = "set"
+	// selinuxModeVerify fails the write of a file not labeled with the selinux context
+	selinuxModeVerify = "verify"
+)
+
+// validateSELinuxContext checks the selinux context is user:role:type[:level] i.e. system_u:object_r:container_file_t:s0
+//	context		: the selinux context
+func validateSELinuxContext(context string) error {
+	items := strings.SplitN(context, ":", 4)
+	if len(items) < 3 || items[0] == "" || items[1] == "" || items[2] == "" || (len(items) == 4 && items[3] == "") {
+		return fmt.Errorf("the selinux context: %s should be user:role:type[:level] i.e. system_u:object_r:container_file_t:s0", context)
+	}
+
+	return nil
+}
+
+// validateSELinuxMode checks the mode is set or verify
+//	mode		: the mode of the labeling
+func validateSELinuxMode(mode string) error {
+	if mode != selinuxModeSet && mode != selinuxModeVerify {
+		return fmt.Errorf("the selinux mode: %s is invalid, should be %s or %s", mode, selinuxModeSet, selinuxModeVerify)
+	}
+
+	return nil
+}
+
+// resourceSELinux returns the selinux context and mode of the files of the resource, falling back to the global
+// options; the context is empty when the files aren't labeled
+//	rn			: the resource
+func resourceSELinux(rn *VaultResource) (string, string) {
+	context, mode := rn.selinuxContext, rn.selinuxMode
+	if context == "" {
+		context = options.selinuxContext
+	}
+	if mode == "" {
+		mode = options.selinuxMode
+	}
+	if mode == "" {
+		mode = selinuxModeSet
+	}
+
+	return context, mode
+}
+
+// labelFile sets or verifies the selinux context of a file written and the directory holding it, so a confined
+// application can read them without the volume being relabeled
+//	rn			: the resource
+//	filename	: the file written
+func labelFile(rn *VaultResource, filename string) error {
+	context, mode := resourceSELinux(rn)
+	if context == "" {
+		return nil
+	}
+	for _, path := range []string{filepath.Dir(filename), filename} {
+		current, err := getFileLabel(path)
+		if err != nil {
+			return fmt.Errorf("unable to read the selinux context of: %s, error: %s", path, err)
+		}
+		if current == context {
+			continue
+		}
+		if mode == selinuxModeVerify {
+			return fmt.Errorf("the file: %s has the selinux context: %s rather than: %s", path, current, context)
+		}
+		if err := setFileLabel(path, context); err != nil {
+			return fmt.Errorf("unable to set the selinux context of: %s, error: %s", path, err)
+		}
+		glog.V(4).Infof("labeled the file: %s with the selinux context: %s", path, context)
+	}
+
+	return nil
+}
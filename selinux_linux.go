@@ -0,0 +1,52 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+// selinuxAttribute is the extended attribute holding the selinux context of a file
+const selinuxAttribute = "security.selinux"
+
+// getFileLabel returns the selinux context of the file, empty if it has none
+//	path		: the path of the file
+func getFileLabel(path string) (string, error) {
+	value := make([]byte, 256)
+	for {
+		size, err := syscall.Getxattr(path, selinuxAttribute, value)
+		switch {
+		case err == syscall.ERANGE:
+			value = make([]byte, len(value)*2)
+			continue
+		case err == syscall.ENODATA || err == syscall.ENOTSUP:
+			return "", nil
+		case err != nil:
+			return "", err
+		}
+
+		return strings.TrimRight(string(value[:size]), "\x00"), nil
+	}
+}
+
+// setFileLabel sets the selinux context of the file, terminated by a nul as libselinux does
+//	path		: the path of the file
+//	context		: the selinux context
+func setFileLabel(path, context string) error {
+	return syscall.Setxattr(path, selinuxAttribute, append([]byte(context), 0), 0)
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSELinuxContext(t *testing.T) {
+	assert.NoError(t, validateSELinuxContext("system_u:object_r:container_file_t:s0"))
+	assert.NoError(t, validateSELinuxContext("system_u:object_r:container_file_t:s0:c1,c2"))
+	assert.NoError(t, validateSELinuxContext("system_u:object_r:etc_t"))
+	assert.Error(t, validateSELinuxContext("container_file_t"))
+	assert.Error(t, validateSELinuxContext("system_u::container_file_t:s0"))
+	assert.Error(t, validateSELinuxContext("system_u:object_r:container_file_t:"))
+
+	assert.NoError(t, validateSELinuxMode(selinuxModeVerify))
+	assert.Error(t, validateSELinuxMode("relabel"))
+}
+
+func TestSELinuxResourceOptions(t *testing.T) {
+	defer func(context, mode string) {
+		options.selinuxContext, options.selinuxMode = context, mode
+	}(options.selinuxContext, options.selinuxMode)
+	options.selinuxContext, options.selinuxMode = "system_u:object_r:container_file_t:s0", selinuxModeSet
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/app:selinux='system_u:object_r:httpd_sys_content_t:s0',selinux-mode=verify"))
+	context, mode := resourceSELinux(resources.items[0])
+	assert.Equal(t, "system_u:object_r:httpd_sys_content_t:s0", context)
+	assert.Equal(t, selinuxModeVerify, mode)
+
+	// step: the global options are the default
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("secret:secret/app"))
+	context, mode = resourceSELinux(resources.items[0])
+	assert.Equal(t, "system_u:object_r:container_file_t:s0", context)
+	assert.Equal(t, selinuxModeSet, mode)
+
+	resources = VaultResources{}
+	assert.Error(t, resources.Set("secret:secret/app:selinux=container_file_t"))
+	assert.Error(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", selinuxMode: "relabel"}))
+}
+
+func TestLabelFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selinux")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "db.json")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("{}"), 0600))
+
+	// step: without a context the files aren't labeled
+	rn := defaultVaultResource()
+	assert.NoError(t, labelFile(rn, filename))
+
+	// step: verifying fails on a file not holding the context
+	rn.selinuxContext = "system_u:object_r:container_file_t:s0"
+	rn.selinuxMode = selinuxModeVerify
+	current, err := getFileLabel(filename)
+	if err != nil || current == rn.selinuxContext {
+		t.Skip("selinux labels aren't supported or the file already has the context")
+	}
+	assert.Error(t, labelFile(rn, filename))
+
+	// step: setting the context requires selinux be enabled
+	rn.selinuxMode = selinuxModeSet
+	if err := labelFile(rn, filename); err != nil {
+		t.Skipf("unable to label the file, selinux is likely disabled: %s", err)
+	}
+	current, err = getFileLabel(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, rn.selinuxContext, current)
+}
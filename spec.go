@@ -0,0 +1,232 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// resourceSpec is a resource as given on the command line, TYPE:PATH[:OPTIONS]
+type resourceSpec struct {
+	// the type of the resource
+	kind string
+	// the path of the resource
+	path string
+	// the options in the order given
+	options []resourceSpecOption
+}
+
+// resourceSpecOption is a KEY=VALUE option of a resource
+type resourceSpecOption struct {
+	// the name of the option
+	name string
+	// the value of the option
+	value string
+}
+
+// specParser reads the fields of a resource; a field is either quoted as a whole, single quotes taking the
+// content literally and double quotes honouring a backslash escape, or unquoted, where a backslash escapes
+// a delimiter and a %XX sequence is url decoded
+type specParser struct {
+	// the resource being parsed
+	input string
+	// the position in the input
+	pos int
+	// the separator of the sections
+	sep string
+}
+
+// parseResourceSpec parses a resource into its type, path and options
+//	value		: the resource i.e. secret:myapp/db:file=db.yaml,fmt=yaml
+//	sep			: the separator of the sections, a colon by default
+func parseResourceSpec(value, sep string) (*resourceSpec, error) {
+	p := &specParser{input: value, sep: sep}
+	spec := &resourceSpec{}
+
+	// step: the type and the path
+	kind, _, err := p.field(false, sep)
+	if err != nil {
+		return nil, err
+	}
+	if p.done() {
+		return nil, fmt.Errorf("invalid resource, must have at least two sections TYPE%sPATH", sep)
+	}
+	path, _, err := p.field(false, sep)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "" || path == "" {
+		return nil, fmt.Errorf("invalid resource, neither type or path can be empty")
+	}
+	spec.kind, spec.path = kind, path
+	if p.done() {
+		return spec, nil
+	}
+
+	// step: the options, a pipe in an unquoted value standing in for a comma
+	for {
+		name, delimiter, err := p.field(false, "=", ",", sep)
+		if err != nil {
+			return nil, err
+		}
+		if delimiter != "=" {
+			if delimiter == sep {
+				return nil, fmt.Errorf("invalid resource, can only has three sections, TYPE%[1]sPATH[%[1]sOPTIONS]", sep)
+			}
+			return nil, fmt.Errorf("invalid resource option: %s, must be KEY=VALUE", name)
+		}
+		value, delimiter, err := p.field(true, ",", sep)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			return nil, fmt.Errorf("invalid resource option: %s=, must have a value", name)
+		}
+		if delimiter == sep {
+			return nil, fmt.Errorf("invalid resource, can only has three sections, TYPE%[1]sPATH[%[1]sOPTIONS]", sep)
+		}
+		spec.options = append(spec.options, resourceSpecOption{name: strings.TrimSpace(name), value: value})
+		if p.done() {
+			return spec, nil
+		}
+	}
+}
+
+// done checks if the input has been consumed
+func (p *specParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+// field reads a field up to the first of the delimiters, returning the field and the delimiter consumed,
+// empty at the end of the input
+//	value		: whether the field is an option value, where a pipe is a comma
+//	delimiters	: the delimiters ending the field
+func (p *specParser) field(value bool, delimiters ...string) (string, string, error) {
+	var content string
+	if p.pos < len(p.input) && (p.input[p.pos] == '"' || p.input[p.pos] == '\'') {
+		var err error
+		if content, err = p.quoted(); err != nil {
+			return "", "", err
+		}
+		if p.done() {
+			return content, "", nil
+		}
+		if delimiter := p.delimiter(delimiters); delimiter != "" {
+			p.pos += len(delimiter)
+			return content, delimiter, nil
+		}
+		return "", "", fmt.Errorf("invalid resource, unexpected %q after the quoted value: %s", p.input[p.pos], content)
+	}
+
+	buf := &bytes.Buffer{}
+	for !p.done() {
+		if delimiter := p.delimiter(delimiters); delimiter != "" {
+			p.pos += len(delimiter)
+			return buf.String(), delimiter, nil
+		}
+		c := p.input[p.pos]
+		switch {
+		case c == '\\' && p.escaped(p.pos+1) > 0:
+			size := p.escaped(p.pos + 1)
+			buf.WriteString(p.input[p.pos+1 : p.pos+1+size])
+			p.pos += 1 + size
+		case c == '%' && p.pos+2 < len(p.input) && isHex(p.input[p.pos+1]) && isHex(p.input[p.pos+2]):
+			x, _ := strconv.ParseUint(p.input[p.pos+1:p.pos+3], 16, 8)
+			buf.WriteByte(byte(x))
+			p.pos += 3
+		case c == '|' && value:
+			buf.WriteByte(',')
+			p.pos++
+		default:
+			buf.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	return buf.String(), "", nil
+}
+
+// quoted reads a quoted field, the position being at the opening quote
+func (p *specParser) quoted() (string, error) {
+	quote := p.input[p.pos]
+	start := p.pos
+	p.pos++
+	buf := &bytes.Buffer{}
+	for !p.done() {
+		c := p.input[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return buf.String(), nil
+		case c == '\\' && quote == '"' && p.pos+1 < len(p.input):
+			buf.WriteByte(p.input[p.pos+1])
+			p.pos += 2
+		default:
+			buf.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	return "", fmt.Errorf("invalid resource, the quote at position %d is not closed", start)
+}
+
+// delimiter returns the delimiter at the position, if any
+func (p *specParser) delimiter(delimiters []string) string {
+	for _, x := range delimiters {
+		if x != "" && strings.HasPrefix(p.input[p.pos:], x) {
+			return x
+		}
+	}
+
+	return ""
+}
+
+// escaped returns the size of the escapable text at the position, zero if a backslash there is taken literally,
+// so the backslashes of a regex are left alone
+func (p *specParser) escaped(pos int) int {
+	if pos >= len(p.input) {
+		return 0
+	}
+	if p.sep != "" && strings.HasPrefix(p.input[pos:], p.sep) {
+		return len(p.sep)
+	}
+	if strings.IndexByte(",=|%\"'", p.input[pos]) >= 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// isHex checks if the character is a hexadecimal digit
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// quoteSpecValue quotes a value of a resource when it holds any of the delimiters or the characters
+// the parser interprets, so it's read back as is
+//	value		: the value
+//	sep			: the separator of the sections
+func quoteSpecValue(value, sep string) string {
+	if !strings.Contains(value, sep) && !strings.ContainsAny(value, ",=|%\"'\\") {
+		return value
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
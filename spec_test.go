@@ -0,0 +1,103 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResourceSpec(t *testing.T) {
+	cases := []struct {
+		Spec    string
+		Kind    string
+		Path    string
+		Options []resourceSpecOption
+	}{
+		{
+			Spec: "secret:myapp/db",
+			Kind: "secret",
+			Path: "myapp/db",
+		},
+		{
+			Spec:    "pki:pki/issue/web:cn=a.example.com|b.example.com,fmt=bundle",
+			Kind:    "pki",
+			Path:    "pki/issue/web",
+			Options: []resourceSpecOption{{"cn", "a.example.com,b.example.com"}, {"fmt", "bundle"}},
+		},
+		{
+			Spec:    `secret:"urn:app:db":file='/etc/a,b',exec="echo \"a:b\", done"`,
+			Kind:    "secret",
+			Path:    "urn:app:db",
+			Options: []resourceSpecOption{{"file", "/etc/a,b"}, {"exec", `echo "a:b", done`}},
+		},
+		{
+			Spec:    `secret:urn\:app:common_name=a\,b,regex=password\s*\=\s*(?P<password>.*)`,
+			Kind:    "secret",
+			Path:    "urn:app",
+			Options: []resourceSpecOption{{"common_name", "a,b"}, {"regex", `password\s*=\s*(?P<password>.*)`}},
+		},
+		{
+			Spec:    "secret:myapp%3Adb:reload-check=localhost%3A8443,query=a=b,tags=x%7Cy",
+			Kind:    "secret",
+			Path:    "myapp:db",
+			Options: []resourceSpecOption{{"reload-check", "localhost:8443"}, {"query", "a=b"}, {"tags", "x|y"}},
+		},
+		{
+			Spec:    "secret:myapp:file=100%",
+			Kind:    "secret",
+			Path:    "myapp",
+			Options: []resourceSpecOption{{"file", "100%"}},
+		},
+	}
+	for i, c := range cases {
+		spec, err := parseResourceSpec(c.Spec, ":")
+		if !assert.NoError(t, err, "case %d, should not have failed", i) {
+			continue
+		}
+		assert.Equal(t, c.Kind, spec.kind, "case %d", i)
+		assert.Equal(t, c.Path, spec.path, "case %d", i)
+		assert.Equal(t, c.Options, spec.options, "case %d", i)
+	}
+
+	for _, x := range []string{
+		"secret",
+		"secret:",
+		":myapp",
+		"secret:myapp:file",
+		"secret:myapp:file=",
+		"secret:myapp:file=a:fmt=yaml",
+		`secret:"myapp`,
+		`secret:"myapp"x:file=a`,
+		`secret:myapp:file=""`,
+	} {
+		_, err := parseResourceSpec(x, ":")
+		assert.Error(t, err, "spec: %s should have failed", x)
+	}
+}
+
+func TestQuoteSpecValue(t *testing.T) {
+	for _, x := range []string{"plain", "a:b", "a,b", `say "hi", \o/`, "100%", "a|b", "it's"} {
+		quoted := quoteSpecValue(x, ":")
+		spec, err := parseResourceSpec("secret:myapp:exec="+quoted, ":")
+		if assert.NoError(t, err, "value: %s", x) {
+			assert.Equal(t, x, spec.options[0].value)
+		}
+	}
+	assert.Equal(t, "plain", quoteSpecValue("plain", ":"))
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// the files of the spiffe helper layout
+	svidFile       = "svid.pem"
+	svidKeyFile    = "svid_key.pem"
+	svidBundleFile = "bundle.pem"
+	// spiffeScheme is the scheme of a spiffe id
+	spiffeScheme = "spiffe"
+)
+
+// parseSPIFFEID checks the value is a valid spiffe id i.e. spiffe://trust.domain/workload
+//	value		: the spiffe id
+func parseSPIFFEID(value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("the spiffe id: %s is invalid, error: %s", value, err)
+	}
+	if u.Scheme != spiffeScheme || u.Host == "" || u.Port() != "" || u.User != nil {
+		return nil, fmt.Errorf("the spiffe id: %s is invalid, should be spiffe://trust-domain/path", value)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || strings.HasSuffix(u.Path, "/") {
+		return nil, fmt.Errorf("the spiffe id: %s can't have a query, fragment or trailing slash", value)
+	}
+
+	return u, nil
+}
+
+// splitTrustChain splits the ca chain of a certificate into the intermediates, sent with the svid, and the
+// self signed roots which make up the trust bundle; the issuing ca is the bundle when vault returns no root
+//	data		: the secret returned from vault
+func splitTrustChain(data map[string]interface{}) ([]byte, []byte, error) {
+	var chain []string
+	if list, found := data["ca_chain"].([]interface{}); found {
+		for _, x := range list {
+			chain = append(chain, fmt.Sprintf("%v", x))
+		}
+	}
+	if len(chain) == 0 {
+		if ca, found := data["issuing_ca"]; found {
+			chain = append(chain, fmt.Sprintf("%v", ca))
+		}
+	}
+
+	var intermediates, bundle bytes.Buffer
+	for _, content := range chain {
+		rest := []byte(content)
+		for {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to parse the ca chain, error: %s", err)
+			}
+			if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+				pem.Encode(&bundle, block)
+			} else {
+				pem.Encode(&intermediates, block)
+			}
+		}
+	}
+	// step: without a root the issuing ca is as far as the trust goes
+	if bundle.Len() == 0 {
+		if ca, found := data["issuing_ca"]; found {
+			bundle.WriteString(strings.TrimSpace(fmt.Sprintf("%v", ca)) + "\n")
+		}
+	}
+
+	return intermediates.Bytes(), bundle.Bytes(), nil
+}
+
+// pkcs8PrivateKey converts the pem private key from vault to the pkcs8 encoding of an svid key
+//	content		: the pem encoded private key
+func pkcs8PrivateKey(content string) ([]byte, error) {
+	block, _ := pem.Decode([]byte(content))
+	if block == nil {
+		return nil, fmt.Errorf("no private key found in the pem content")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		return pem.EncodeToMemory(block), nil
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(der)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// writeSPIFFEFiles writes a certificate in the layout of the spiffe helper, so a workload reading its x509 svid
+// from disk takes a vault issued certificate unchanged: svid.pem holds the certificate and intermediates,
+// svid_key.pem the pkcs8 private key and bundle.pem the trust bundle
+//	dir			: the directory the files are written to
+//	data		: the secret returned from vault
+//	rn			: the resource
+func writeSPIFFEFiles(dir string, data map[string]interface{}, rn *VaultResource) error {
+	certificate, found := data["certificate"]
+	if !found {
+		return fmt.Errorf("the resource has no certificate to write as an svid")
+	}
+	intermediates, bundle, err := splitTrustChain(data)
+	if err != nil {
+		return err
+	}
+	if !options.dryRun {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	svid := []byte(strings.TrimSpace(fmt.Sprintf("%v", certificate)) + "\n")
+	if err := writeResourceContent(rn, filepath.Join(dir, svidFile), append(svid, intermediates...)); err != nil {
+		return err
+	}
+	// step: the key never leaves a pkcs11 token
+	if !strings.HasPrefix(rn.keySource, pkcs11Scheme) {
+		key, err := pkcs8PrivateKey(fmt.Sprintf("%v", data["private_key"]))
+		if err != nil {
+			return fmt.Errorf("unable to encode the svid key, error: %s", err)
+		}
+		if err := writeResourceContent(rn, filepath.Join(dir, svidKeyFile), key); err != nil {
+			return err
+		}
+	}
+
+	return writeResourceContent(rn, filepath.Join(dir, svidBundleFile), bundle)
+}
+
+// verifySPIFFEID checks the certificate carries the spiffe id as its only uri san, as an svid must
+//	id			: the spiffe id requested
+//	cert		: the certificate issued
+func verifySPIFFEID(id string, cert *x509.Certificate) error {
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != id {
+		return fmt.Errorf("the certificate must hold the spiffe id: %s as its only uri san, has: %v", id, cert.URIs)
+	}
+
+	return nil
+}
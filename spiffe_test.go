@@ -0,0 +1,127 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSPIFFEID(t *testing.T) {
+	cases := []struct {
+		Value string
+		Error bool
+	}{
+		{Value: "spiffe://example.org/ns/default/sa/web"},
+		{Value: "spiffe://example.org"},
+		{Value: "https://example.org/web", Error: true},
+		{Value: "spiffe:///web", Error: true},
+		{Value: "spiffe://example.org:8443/web", Error: true},
+		{Value: "spiffe://user@example.org/web", Error: true},
+		{Value: "spiffe://example.org/web?x=1", Error: true},
+		{Value: "spiffe://example.org/web/", Error: true},
+	}
+	for i, c := range cases {
+		_, err := parseSPIFFEID(c.Value)
+		if c.Error {
+			assert.Error(t, err, "case %d, should have failed", i)
+		} else {
+			assert.NoError(t, err, "case %d, error: %s", i, err)
+		}
+	}
+}
+
+func TestSPIFFEResourceOptions(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("pki:pki/issue/web:common_name=web.example.org,fmt=spiffe,spiffe-id=\"spiffe://example.org/web\",file=/run/svid"))
+	rn := resources.items[0]
+	assert.NoError(t, rn.IsValid())
+	assert.Equal(t, "spiffe://example.org/web", rn.spiffeID)
+	assert.Equal(t, "spiffe", rn.format)
+
+	assert.Error(t, resources.Set("pki:pki/issue/web:common_name=web.example.org,spiffe-id=\"https://example.org/web\""))
+	for _, spec := range []string{
+		"secret:secret/web:fmt=spiffe",
+		"secret:secret/web:spiffe-id=\"spiffe://example.org/web\"",
+		"pki:pki/issue/web:common_name=web.example.org,spiffe-id=\"spiffe://example.org/web\",uri_sans=\"spiffe://example.org/other\"",
+		"pki:pki/issue/web:common_name=web.example.org,fmt=spiffe,explode=true",
+	} {
+		resources = VaultResources{}
+		assert.NoError(t, resources.Set(spec))
+		assert.Error(t, resources.items[0].IsValid(), "spec: %s should be invalid", spec)
+	}
+}
+
+func TestWriteSPIFFEFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spiffe")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("pki:pki/issue/web:common_name=web.example.org,fmt=spiffe,mode=0600"))
+	rn := resources.items[0]
+	data := readCertificateFixture(t)
+	filename := filepath.Join(dir, "svid")
+	assert.NoError(t, writeResourceFile(rn, filename, data))
+
+	// step: the svid holds the certificate, the root goes to the bundle
+	content, err := ioutil.ReadFile(filepath.Join(filename, svidFile))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "BEGIN CERTIFICATE"))
+	cert, err := parseCertificate(string(content))
+	assert.NoError(t, err)
+	assert.Equal(t, "app.example.com", cert.Subject.CommonName)
+
+	content, err = ioutil.ReadFile(filepath.Join(filename, svidBundleFile))
+	assert.NoError(t, err)
+	root, err := parseCertificate(string(content))
+	assert.NoError(t, err)
+	assert.Equal(t, "Mock Root CA", root.Subject.CommonName)
+
+	// step: the key is pkcs8
+	content, err = ioutil.ReadFile(filepath.Join(filename, svidKeyFile))
+	assert.NoError(t, err)
+	block, _ := pem.Decode(content)
+	if assert.NotNil(t, block) {
+		assert.Equal(t, "PRIVATE KEY", block.Type)
+		_, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		assert.NoError(t, err)
+	}
+	stat, err := os.Stat(filepath.Join(filename, svidKeyFile))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+}
+
+func TestVerifySPIFFEID(t *testing.T) {
+	id, _ := url.Parse("spiffe://example.org/web")
+	other, _ := url.Parse("spiffe://example.org/other")
+
+	assert.NoError(t, verifySPIFFEID("spiffe://example.org/web", &x509.Certificate{URIs: []*url.URL{id}}))
+	assert.Error(t, verifySPIFFEID("spiffe://example.org/web", &x509.Certificate{}))
+	assert.Error(t, verifySPIFFEID("spiffe://example.org/web", &x509.Certificate{URIs: []*url.URL{other}}))
+	assert.Error(t, verifySPIFFEID("spiffe://example.org/web", &x509.Certificate{URIs: []*url.URL{id, other}}))
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStandbyRedirect(t *testing.T) {
+	active, err := startMockVault("tests/fixtures")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// step: a standby with forwarding disabled redirects every request to the active node
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, active+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	}))
+	defer standby.Close()
+
+	service, err := NewVaultService(standby.URL, &vaultAuthOptions{Method: "token", Token: mockToken}, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer service.Stop()
+
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "secret/app"
+	x := &watchedResource{resource: rn}
+	if assert.NoError(t, service.get(x)) {
+		assert.Equal(t, "app", x.secret.Data["username"])
+	}
+
+	// step: a write should carry its body across the redirect
+	rn = defaultVaultResource()
+	rn.resource = "pki"
+	rn.path = "pki/issue/example"
+	rn.options["common_name"] = "app.example.com"
+	x = &watchedResource{resource: rn}
+	if assert.NoError(t, service.get(x)) {
+		assert.NotEmpty(t, x.secret.Data["certificate"])
+	}
+}
+
+func TestReadAddress(t *testing.T) {
+	var lock sync.Mutex
+	var paths []string
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		paths = append(paths, r.URL.Path)
+		lock.Unlock()
+		if r.Header.Get("X-Vault-Token") != mockToken {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_duration": 3600,
+			"data":           map[string]interface{}{"source": "standby"},
+		})
+	}))
+	defer standby.Close()
+
+	options.vaultReadURL = standby.URL
+	defer func() { options.vaultReadURL = "" }()
+	service, _ := newMockService(t)
+	if !assert.NotNil(t, service.readClient) {
+		t.FailNow()
+	}
+
+	// step: a static secret should be read from the standby
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "secret/app"
+	x := &watchedResource{resource: rn}
+	if assert.NoError(t, service.get(x)) {
+		assert.Equal(t, "standby", x.secret.Data["source"])
+	}
+
+	// step: a dynamic secret must be issued by the active node
+	rn = defaultVaultResource()
+	rn.resource = "mysql"
+	rn.path = "mysql/creds/app"
+	x = &watchedResource{resource: rn}
+	if assert.NoError(t, service.get(x)) {
+		assert.Equal(t, "v-app-8f53", x.secret.Data["username"])
+	}
+	assert.Equal(t, []string{"/v1/secret/app"}, paths)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// defaultStartupConcurrency is the number of resources retrieved at once at startup
+const defaultStartupConcurrency = 4
+
+// startup orders and bounds the first retrieval of the resources, replaced once the options are parsed
+var startup = newStartupWaves(defaultStartupConcurrency)
+
+// startupWaves holds back the first retrieval of a resource until the resources of the earlier waves have been
+// retrieved, and bounds the number retrieved at once
+type startupWaves struct {
+	sync.Mutex
+	// signalled whenever a wave may have completed
+	cond *sync.Cond
+	// the number of resources yet to be retrieved in each wave
+	pending map[int]int
+	// the resources yet to be retrieved
+	outstanding map[*VaultResource]bool
+	// the slots of the retrievals in progress
+	slots chan struct{}
+}
+
+// fetchResult is the outcome of the retrieval of a resource
+type fetchResult struct {
+	// the resource retrieved
+	resource *watchedResource
+	// the copy of the resource retrieved in the background, nil if retrieved by the service processor
+	staged *watchedResource
+	// the lease of the resource before the retrieval
+	leaseID string
+	// the id of the operation
+	requestID string
+	// whether the secret is unchanged since the last retrieval
+	unchanged bool
+	// the error if the retrieval failed
+	err error
+}
+
+// newStartupWaves creates the ordering of the first retrievals
+//	concurrency	: the number of resources retrieved at once
+func newStartupWaves(concurrency int) *startupWaves {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	w := &startupWaves{
+		pending:     make(map[int]int, 0),
+		outstanding: make(map[*VaultResource]bool, 0),
+		slots:       make(chan struct{}, concurrency),
+	}
+	w.cond = sync.NewCond(w)
+
+	return w
+}
+
+// add registers a resource to be retrieved in its wave
+func (w *startupWaves) add(rn *VaultResource) {
+	w.Lock()
+	defer w.Unlock()
+	if !w.outstanding[rn] {
+		w.outstanding[rn] = true
+		w.pending[rn.wave]++
+	}
+}
+
+// complete records the first retrieval of a resource, releasing the later waves once its wave is done
+func (w *startupWaves) complete(rn *VaultResource) {
+	w.Lock()
+	defer w.Unlock()
+	if !w.outstanding[rn] {
+		return
+	}
+	delete(w.outstanding, rn)
+	if w.pending[rn.wave]--; w.pending[rn.wave] == 0 {
+		delete(w.pending, rn.wave)
+		glog.V(3).Infof("the resources of the startup wave: %d have been retrieved", rn.wave)
+	}
+	w.cond.Broadcast()
+}
+
+// abandon gives up waiting on the first retrieval of a resource, so the later waves aren't held back by it
+//	rn			: the resource
+//	reason		: why the resource has not been retrieved
+func (w *startupWaves) abandon(rn *VaultResource, reason string) {
+	w.Lock()
+	outstanding := w.outstanding[rn]
+	w.Unlock()
+	if outstanding {
+		glog.Warningf("resource: %s of the startup wave: %d has not been retrieved, %s, releasing the later waves", rn, rn.wave, reason)
+		w.complete(rn)
+	}
+}
+
+// ready checks the resources of the waves before that of the resource have been retrieved; the lock must be held
+func (w *startupWaves) ready(rn *VaultResource) bool {
+	for wave := range w.pending {
+		if wave < rn.wave {
+			return false
+		}
+	}
+
+	return true
+}
+
+// acquire waits for the earlier waves and a free slot before the resource is retrieved
+func (w *startupWaves) acquire(rn *VaultResource) {
+	w.Lock()
+	for !w.ready(rn) {
+		glog.V(4).Infof("resource: %s is waiting for the earlier startup waves", rn)
+		w.cond.Wait()
+	}
+	w.Unlock()
+	w.slots <- struct{}{}
+}
+
+// release frees the slot of a retrieval
+func (w *startupWaves) release() {
+	<-w.slots
+}
+
+// fetch retrieves the resource from vault, tracing and counting the retrieval
+//	x			: the watched resource
+//	leaseID		: the lease of the resource before the retrieval
+func (r VaultService) fetch(x *watchedResource, leaseID string) *fetchResult {
+	span := startSpan(x.resource, "vault.fetch")
+	op := r.withRequestID()
+	span.setAttribute("request.id", op.requestID)
+	err := op.get(x)
+	unchanged := err == errSecretUnchanged
+	if unchanged {
+		err = nil
+	}
+	if x.secret != nil {
+		span.setAttribute("lease.id", x.secret.LeaseID)
+	}
+	span.finish(err)
+	metrics.add(metricFetches, 1, resourceLabels(x.resource, "status", statusLabel(err))...)
+
+	return &fetchResult{resource: x, leaseID: leaseID, requestID: op.requestID, unchanged: unchanged, err: err}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartupConcurrency(t *testing.T) {
+	waves := newStartupWaves(2)
+	var running, highest int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			waves.acquire(defaultVaultResource())
+			defer waves.release()
+			current := atomic.AddInt32(&running, 1)
+			for {
+				seen := atomic.LoadInt32(&highest)
+				if current <= seen || atomic.CompareAndSwapInt32(&highest, seen, current) {
+					break
+				}
+			}
+			time.Sleep(time.Duration(20) * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), highest)
+}
+
+func TestStartupWaves(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/db"))
+	assert.NoError(t, resources.Set("tpl:secret/app:tpl=app.tmpl,wave=1"))
+	assert.NoError(t, resources.Set("secret:secret/other:wave=2"))
+	assert.Error(t, resources.Set("secret:secret/db:wave=-1"))
+	assert.Error(t, resources.Set("secret:secret/db:wave=first"))
+	first, second, third := resources.items[0], resources.items[1], resources.items[2]
+	assert.Equal(t, 1, second.wave)
+
+	waves := newStartupWaves(4)
+	for _, x := range resources.items {
+		waves.add(x)
+	}
+	started := make(chan *VaultResource, 3)
+	for _, x := range []*VaultResource{third, second} {
+		go func(rn *VaultResource) {
+			waves.acquire(rn)
+			started <- rn
+			waves.release()
+		}(x)
+	}
+
+	// step: the later waves wait for the first
+	waves.acquire(first)
+	waves.release()
+	select {
+	case rn := <-started:
+		t.Fatalf("resource: %s started before the first wave was retrieved", rn)
+	case <-time.After(time.Duration(50) * time.Millisecond):
+	}
+	waves.complete(first)
+	assert.Equal(t, second, <-started)
+	select {
+	case rn := <-started:
+		t.Fatalf("resource: %s started before the second wave was retrieved", rn)
+	case <-time.After(time.Duration(50) * time.Millisecond):
+	}
+	// step: completing twice is harmless
+	waves.complete(second)
+	waves.complete(second)
+	assert.Equal(t, third, <-started)
+	waves.complete(third)
+	assert.Empty(t, waves.pending)
+}
+
+func TestStartupParallelFetch(t *testing.T) {
+	service, updates := newMockService(t)
+	startup = newStartupWaves(4)
+	defer func() { startup = newStartupWaves(defaultStartupConcurrency) }()
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/app:no-cache=true"))
+	assert.NoError(t, resources.Set("secret:secret/single:no-cache=true"))
+	assert.NoError(t, resources.Set("mysql:mysql/creds/app:wave=1"))
+	for _, x := range resources.items {
+		startup.add(x)
+		service.Watch(x)
+	}
+	var order []string
+	for range resources.items {
+		evt := waitForEvent(t, updates)
+		assert.Equal(t, EventTypeSuccess, evt.Type)
+		order = append(order, evt.Resource.path)
+	}
+	assert.Equal(t, "mysql/creds/app", order[2])
+}
+
+func TestStartupAbandoned(t *testing.T) {
+	service, updates := newMockService(t)
+	startup = newStartupWaves(4)
+	defer func() { startup = newStartupWaves(defaultStartupConcurrency) }()
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/app:no-cache=true"))
+	assert.NoError(t, resources.Set("secret:secret/single:no-cache=true,retries=1"))
+	assert.NoError(t, resources.Set("mysql:mysql/creds/app:wave=1"))
+	removed, exhausted, later := resources.items[0], resources.items[1], resources.items[2]
+	for _, x := range resources.items {
+		startup.add(x)
+	}
+
+	// step: a resource removed, or out of retries, before its first retrieval releases the later waves
+	exhausted.retries = 2
+	service.Watch(exhausted)
+	service.Watch(later)
+	select {
+	case evt := <-updates:
+		t.Fatalf("resource: %s was retrieved before the first wave was released", evt.Resource)
+	case <-time.After(time.Duration(100) * time.Millisecond):
+	}
+	service.Unwatch(removed)
+	evt := waitForEvent(t, updates)
+	assert.Equal(t, later, evt.Resource)
+	assert.Equal(t, EventTypeSuccess, evt.Type)
+	assert.Empty(t, startup.pending)
+}
+
+func TestStartupFailed(t *testing.T) {
+	service, updates := newMockService(t)
+	startup = newStartupWaves(4)
+	defer func() { startup = newStartupWaves(defaultStartupConcurrency) }()
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/missing:no-cache=true"))
+	assert.NoError(t, resources.Set("mysql:mysql/creds/app:wave=1"))
+	failing, later := resources.items[0], resources.items[1]
+	for _, x := range resources.items {
+		startup.add(x)
+		service.Watch(x)
+	}
+
+	// step: a resource of an earlier wave which keeps failing, with no limit on its retries, releases the later waves
+	evt := waitForEvent(t, updates)
+	assert.Equal(t, failing, evt.Resource)
+	assert.Equal(t, EventTypeFailure, evt.Type)
+	evt = waitForEvent(t, updates)
+	assert.Equal(t, later, evt.Resource)
+	assert.Equal(t, EventTypeSuccess, evt.Type)
+	assert.Empty(t, startup.pending)
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// stateFileMode is the permissions on the state file, it holds lease material so keep it private
+	stateFileMode = os.FileMode(0600)
+	// resumeMinimumTTL is the smallest remaining lease we are willing to resume rather than re-issue
+	resumeMinimumTTL = time.Duration(30) * time.Second
+)
+
+// leaseState is the persisted view of a watched resource
+type leaseState struct {
+	// the lease id given by vault
+	LeaseID string `json:"lease_id"`
+	// the lease duration in seconds as issued
+	LeaseDuration int `json:"lease_duration"`
+	// whether the lease is renewable
+	Renewable bool `json:"renewable"`
+	// the last time the resource was retrieved or renewed
+	LastUpdated time.Time `json:"last_updated"`
+	// the time the lease expires
+	ExpireTime time.Time `json:"expire_time"`
+	// the time the lease was issued, zero in the state files of older versions
+	Issued time.Time `json:"issued,omitempty"`
+	// the number of times the lease has been renewed
+	Renewals int `json:"renewals,omitempty"`
+	// the serial number of an issued certificate
+	Serial string `json:"serial,omitempty"`
+}
+
+// leaseStore persists the leases of the watched resources to a state file, allowing us
+// to resume renewals across restarts rather than issuing new credentials each time
+type leaseStore struct {
+	sync.Mutex
+	// the path to the state file
+	path string
+	// the leases keyed by the resource id
+	Leases map[string]*leaseState `json:"leases"`
+	// the certificates issued to the resources, until they expire
+	Certificates []*issuedCertificate `json:"certificates,omitempty"`
+}
+
+// newLeaseStore creates a lease store, loading any previous state from the file
+//	path		: the path to the state file
+func newLeaseStore(path string) (*leaseStore, error) {
+	store := &leaseStore{
+		path:   path,
+		Leases: make(map[string]*leaseState, 0),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, store); err != nil {
+		return nil, fmt.Errorf("unable to decode the state file: %s, error: %s", path, err)
+	}
+	if store.Leases == nil {
+		store.Leases = make(map[string]*leaseState, 0)
+	}
+	glog.V(3).Infof("loaded %d leases from the state file: %s", len(store.Leases), path)
+
+	return store, nil
+}
+
+// get retrieves the persisted state of a resource
+func (s *leaseStore) get(id string) (*leaseState, bool) {
+	s.Lock()
+	defer s.Unlock()
+	state, found := s.Leases[id]
+
+	return state, found
+}
+
+// update records the current lease of a watched resource and flushes the state file; the secret itself is
+// never persisted, only the lease, and only for the dynamic resources whose leases can be resumed
+func (s *leaseStore) update(rn *watchedResource) error {
+	if rn.secret == nil || !rn.resource.isDynamic() {
+		return nil
+	}
+	state := &leaseState{
+		LeaseID:       rn.secret.LeaseID,
+		LeaseDuration: rn.secret.LeaseDuration,
+		Renewable:     rn.secret.Renewable,
+		LastUpdated:   rn.lastUpdated,
+		ExpireTime:    rn.leaseExpireTime,
+		Issued:        rn.issued,
+		Renewals:      rn.renewals,
+	}
+	if serial, found := rn.secret.Data["serial_number"]; found {
+		state.Serial = fmt.Sprintf("%v", serial)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.Leases[rn.resource.ID()] = state
+	if state.Serial != "" {
+		s.recordCertificate(rn, state.Serial)
+	}
+
+	return s.flush()
+}
+
+// remove deletes the state of a resource and flushes the state file
+func (s *leaseStore) remove(id string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.Leases, id)
+
+	return s.flush()
+}
+
+// flush writes the state to disk via a temporary file, so a crash never leaves a partial state file
+func (s *leaseStore) flush() error {
+	content, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmpfile, err := ioutil.TempFile(filepath.Dir(s.path), ".state")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write(content); err != nil {
+		tmpfile.Close()
+		return err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpfile.Name(), stateFileMode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpfile.Name(), s.path)
+}
+
+// isResumable checks if the persisted lease can be picked up again rather than issuing a new one; as the
+// secret isn't persisted, the files written before the restart must still be in place
+func (l *leaseState) isResumable(rn *VaultResource) bool {
+	if !rn.isDynamic() || rn.toStdout() || rn.servePKI || rn.isWildcard() || rn.memfd {
+		return false
+	}
+	if !resourceWritten(rn) {
+		glog.V(3).Infof("the files of resource: %s are missing, the lease can't be resumed", rn)
+		return false
+	}
+	if l.LeaseID == "" && rn.resource != "pki" {
+		return false
+	}
+
+	return time.Until(l.ExpireTime) > resumeMinimumTTL
+}
+
+// restore rebuilds the watched resource from the persisted lease, the lease duration is
+// shortened to the time remaining so the renewal is calculated against the real expiration
+func (l *leaseState) restore(rn *watchedResource) {
+	rn.lastUpdated = l.LastUpdated
+	rn.issued = l.LastUpdated
+	if !l.Issued.IsZero() {
+		rn.issued = l.Issued
+	}
+	rn.renewals = l.Renewals
+	rn.serial = l.Serial
+	rn.source = sourceState
+	rn.leaseExpireTime = l.ExpireTime
+	rn.secret = &api.Secret{
+		LeaseID:       l.LeaseID,
+		LeaseDuration: int(time.Until(l.ExpireTime).Seconds()),
+		Renewable:     l.Renewable,
+	}
+}
+
+// resourceWritten checks if any of the files of the resource exist
+func resourceWritten(rn *VaultResource) bool {
+	for _, x := range resourceFiles(rn, resolveFilename(rn.GetFilename()), nil) {
+		if found, _ := fileExists(x); found {
+			return true
+		}
+	}
+
+	return false
+}
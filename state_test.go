@@ -0,0 +1,153 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { options.outputDir = dir }(options.outputDir)
+	options.outputDir = dir
+	path := filepath.Join(dir, "state.json")
+
+	store, err := newLeaseStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	rn := defaultVaultResource()
+	rn.resource = "pki"
+	rn.path = "pki/issue/example"
+	now := time.Now()
+	x := &watchedResource{
+		resource:        rn,
+		lastUpdated:     now,
+		issued:          now.Add(-time.Hour),
+		renewals:        2,
+		leaseExpireTime: now.Add(time.Hour),
+		secret: &api.Secret{
+			LeaseID:       "pki/issue/example/1234",
+			LeaseDuration: 3600,
+			Data: map[string]interface{}{
+				"serial_number": "aa:bb:cc",
+				"private_key":   "s3cr3t",
+			},
+		},
+	}
+	assert.NoError(t, store.update(x))
+
+	// step: the secret is never written to the state file, nor are the static resources
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "s3cr3t")
+	static := defaultVaultResource()
+	static.resource = "secret"
+	static.path = "secret/app"
+	assert.NoError(t, store.update(&watchedResource{resource: static, secret: &api.Secret{Data: map[string]interface{}{"password": "s3cr3t"}}}))
+	_, found := store.get(static.ID())
+	assert.False(t, found)
+
+	info, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, stateFileMode, info.Mode().Perm())
+	}
+
+	loaded, err := newLeaseStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	lease, found := loaded.get(rn.ID())
+	if !assert.True(t, found) {
+		return
+	}
+	assert.Equal(t, "pki/issue/example/1234", lease.LeaseID)
+	assert.Equal(t, "aa:bb:cc", lease.Serial)
+
+	// step: the lease is only resumed while the files written before the restart are in place
+	assert.False(t, lease.isResumable(rn))
+	assert.NoError(t, ioutil.WriteFile(resolveFilename(rn.GetFilename()), []byte("cert"), 0600))
+	assert.True(t, lease.isResumable(rn))
+
+	// step: the restored resource carries the issue time and renewals of the lease
+	restored := &watchedResource{resource: rn}
+	lease.restore(restored)
+	assert.True(t, now.Add(-time.Hour).Equal(restored.issued))
+	assert.Equal(t, 2, restored.renewals)
+	assert.Equal(t, sourceState, restored.source)
+
+	assert.NoError(t, loaded.remove(rn.ID()))
+	_, found = loaded.get(rn.ID())
+	assert.False(t, found)
+}
+
+func TestLeaseStateIsResumable(t *testing.T) {
+	cases := []struct {
+		Resource string
+		Lease    *leaseState
+		Expected bool
+	}{
+		{
+			Resource: "mysql",
+			Lease:    &leaseState{LeaseID: "mysql/creds/1", ExpireTime: time.Now().Add(time.Hour)},
+			Expected: true,
+		},
+		{
+			Resource: "mysql",
+			Lease:    &leaseState{LeaseID: "mysql/creds/1", ExpireTime: time.Now().Add(time.Second)},
+		},
+		{
+			Resource: "mysql",
+			Lease:    &leaseState{ExpireTime: time.Now().Add(time.Hour)},
+		},
+		{
+			Resource: "pki",
+			Lease:    &leaseState{ExpireTime: time.Now().Add(time.Hour)},
+			Expected: true,
+		},
+		{
+			Resource: "secret",
+			Lease:    &leaseState{LeaseID: "secret/1", ExpireTime: time.Now().Add(time.Hour)},
+		},
+	}
+	dir, err := ioutil.TempDir("", "state")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { options.outputDir = dir }(options.outputDir)
+	options.outputDir = dir
+
+	for i, c := range cases {
+		rn := defaultVaultResource()
+		rn.resource = c.Resource
+		rn.path = "app"
+		assert.NoError(t, ioutil.WriteFile(resolveFilename(rn.GetFilename()), []byte("secret"), 0600))
+		assert.Equal(t, c.Expected, c.Lease.isResumable(rn), "case %d, unexpected result", i)
+	}
+}
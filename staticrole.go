@@ -0,0 +1,153 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// staticCredsPath is the segment of the path reading the credentials of a database static role
+	staticCredsPath = "/static-creds/"
+	// rotateRolePath is the segment of the path rotating the password of a database static role
+	rotateRolePath = "/rotate-role/"
+)
+
+var (
+	// staticRotationDelay is the time after the rotation of a static role its credentials are read again
+	staticRotationDelay = time.Duration(5) * time.Second
+	// rotateTimeout bounds a rotation requested via the admin api
+	rotateTimeout = time.Duration(30) * time.Second
+)
+
+// rotateRequest asks the service processor to rotate the static role of a resource
+type rotateRequest struct {
+	// the resource whose role is rotated
+	resource *VaultResource
+	// the outcome of the rotation
+	done chan error
+}
+
+// isStaticRole checks if the resource reads the credentials of a database static role i.e. database/static-creds/app
+func (r VaultResource) isStaticRole() bool {
+	return strings.Contains("/"+strings.Trim(r.path, "/"), staticCredsPath)
+}
+
+// rotateRolePath returns the path rotating the static role of the resource i.e. database/rotate-role/app
+func (r VaultResource) rotateRolePath() string {
+	path := "/" + strings.Trim(r.path, "/")
+	index := strings.LastIndex(path, staticCredsPath)
+	if index < 0 {
+		return ""
+	}
+
+	return strings.TrimPrefix(path[:index]+rotateRolePath+path[index+len(staticCredsPath):], "/")
+}
+
+// staticRoleTTL returns the time until vault next rotates the password of a static role, zero if unknown
+//	secret		: the credentials read from the role
+func staticRoleTTL(secret *api.Secret) time.Duration {
+	if secret == nil {
+		return 0
+	}
+
+	return time.Duration(toInt(secret.Data["ttl"])) * time.Second
+}
+
+// Rotate asks the service processor to rotate the password of the static role of the resource, waiting for the
+// new credentials to be read
+//	rn			: the resource
+func (r VaultService) Rotate(rn *VaultResource) error {
+	request := &rotateRequest{resource: rn, done: make(chan error, 1)}
+	select {
+	case r.rotateChannel <- request:
+	case <-time.After(rotateTimeout):
+		return fmt.Errorf("timed out waiting to rotate the resource: %s", rn)
+	}
+	select {
+	case err := <-request.done:
+		return err
+	case <-time.After(rotateTimeout):
+		return fmt.Errorf("timed out waiting for the rotation of the resource: %s", rn)
+	}
+}
+
+// rotateStatic rotates the static role of a watched resource and reads the new credentials; the renewal timer of
+// the resource is left running, the read it triggers scheduling the next from the new ttl
+//	items		: the watched resources
+//	rn			: the resource to rotate
+func (r VaultService) rotateStatic(items []*watchedResource, rn *VaultResource) error {
+	var x *watchedResource
+	for _, item := range items {
+		if item.resource == rn {
+			x = item
+			break
+		}
+	}
+	if x == nil {
+		return fmt.Errorf("the resource: %s is not being watched", rn)
+	}
+
+	op := r.withRequestID()
+	span := startSpan(rn, "vault.rotate")
+	span.setAttribute("request.id", op.requestID)
+	err := op.rotateRole(x)
+	if err == nil {
+		if err = op.get(x); err == errSecretUnchanged {
+			err = nil
+		}
+	}
+	span.finish(err)
+	if err != nil {
+		glog.Errorf("failed to rotate the resource: %s, request id: %s, error: %s", rn, op.requestID, err)
+		r.upstream(VaultEvent{Resource: rn, Type: EventTypeFailure, Error: err})
+		return err
+	}
+	glog.Infof("rotated the static role of the resource: %s, request id: %s", rn, op.requestID)
+
+	r.upstream(VaultEvent{
+		Resource: rn,
+		Secret:   x.secret.Data,
+		Metadata: newSecretMetadata(x),
+		Type:     EventTypeSuccess,
+	})
+	x.release()
+
+	return nil
+}
+
+// rotateRole has vault rotate the password of the static role of the resource
+//	rn			: the watched resource
+func (r VaultService) rotateRole(rn *watchedResource) error {
+	var err error
+	if rn.resource.namespace != "" {
+		if r, err = r.withNamespace(rn.resource.namespace); err != nil {
+			return err
+		}
+	}
+	if r, err = r.tagRequests(rn.resource); err != nil {
+		return err
+	}
+	_, err = r.client.Logical().Write(rn.resource.rotateRolePath(), nil)
+
+	return err
+}
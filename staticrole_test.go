@@ -0,0 +1,121 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticRolePaths(t *testing.T) {
+	cases := []struct {
+		Path   string
+		Static bool
+		Rotate string
+	}{
+		{Path: "database/static-creds/app", Static: true, Rotate: "database/rotate-role/app"},
+		{Path: "/team/db/static-creds/app/", Static: true, Rotate: "team/db/rotate-role/app"},
+		{Path: "database/creds/app"},
+		{Path: "secret/static-creds"},
+	}
+	for i, c := range cases {
+		rn := &VaultResource{resource: "secret", path: c.Path}
+		assert.Equal(t, c.Static, rn.isStaticRole(), "case %d", i)
+		assert.Equal(t, c.Rotate, rn.rotateRolePath(), "case %d", i)
+		assert.Equal(t, c.Static, rn.isDynamic(), "case %d", i)
+	}
+}
+
+func TestStaticRoleOptions(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("postgres:database/static-creds/app:rotate=true"))
+	assert.NoError(t, resources.items[0].IsValid())
+	assert.True(t, resources.items[0].rotate)
+	assert.Error(t, resources.Set("postgres:database/static-creds/app:rotate=maybe"))
+
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("postgres:database/creds/app:rotate=true"))
+	assert.Error(t, resources.items[0].IsValid())
+
+	// step: the policy allows the rotation
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("postgres:database/static-creds/app:rotate=true"))
+	rules := generatePolicies(resources.items)[""]
+	assert.True(t, rules["database/rotate-role/app"]["update"])
+	assert.True(t, rules["database/static-creds/app"]["read"])
+}
+
+func TestStaticRoleRenewal(t *testing.T) {
+	rn := &watchedResource{
+		resource: &VaultResource{resource: "postgres", path: "database/static-creds/app"},
+		secret:   &api.Secret{Data: map[string]interface{}{"ttl": json.Number("600")}},
+	}
+	renewals := make(chan *watchedResource, 1)
+	rn.notifyOnRenewal(renewals)
+	assert.Equal(t, time.Duration(600)*time.Second+staticRotationDelay, rn.renewalTime)
+
+	// step: the update option takes precedence
+	rn.resource.update = time.Minute
+	rn.notifyOnRenewal(renewals)
+	assert.Equal(t, time.Minute, rn.renewalTime)
+}
+
+func TestRotateStaticRole(t *testing.T) {
+	service, updates := newMockService(t)
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("postgres:database/static-creds/app:rotate=true,fmt=json"))
+	rn := resources.items[0]
+	registry.register(rn)
+	service.Watch(rn)
+	evt := waitForEvent(t, updates)
+	if !assert.Equal(t, EventTypeSuccess, evt.Type) {
+		t.FailNow()
+	}
+	assert.Equal(t, "B2b-7k4m1q", evt.Secret["password"])
+
+	// step: the rotation is requested via the admin api, reading the credentials again
+	handler := newAdminHandler()
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/v1/resources/rotate?id="+rn.ID(), nil))
+		done <- recorder
+	}()
+	evt = waitForEvent(t, updates)
+	assert.Equal(t, EventTypeSuccess, evt.Type)
+	assert.Equal(t, "app", evt.Secret["username"])
+	recorder := <-done
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// step: a resource without the option can't be rotated
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("postgres:database/static-creds/other"))
+	registry.register(resources.items[0])
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/v1/resources/rotate?id="+resources.items[0].ID(), nil))
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/resources/rotate?id="+rn.ID(), nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// stdoutOutput is the output directory writing every resource to stdout i.e. -output=-
+	stdoutOutput = "-"
+	// outStdout is the value of the out option writing the resource to stdout
+	outStdout = "stdout"
+)
+
+var (
+	// stdout is where the resources written to stdout go, so the content of two resources never interleaves
+	stdout io.Writer = os.Stdout
+	// stdoutLock serializes the writes to stdout
+	stdoutLock sync.Mutex
+)
+
+// toStdout checks if the resource is written to stdout rather than a file
+func (r VaultResource) toStdout() bool {
+	return r.stdout || options.outputDir == stdoutOutput
+}
+
+// writeStdout writes the content of a resource to stdout, ending it with a newline so consecutive values are
+// split into lines; the logging goes to stderr or the log files, so stdout only ever holds the values
+//	content		: the formatted content
+func writeStdout(content []byte) error {
+	stdoutLock.Lock()
+	defer stdoutLock.Unlock()
+
+	if _, err := stdout.Write(content); err != nil {
+		return fmt.Errorf("unable to write to stdout, error: %s", err)
+	}
+	if len(content) == 0 || content[len(content)-1] != '\n' {
+		if _, err := stdout.Write([]byte("\n")); err != nil {
+			return fmt.Errorf("unable to write to stdout, error: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// isValidStdout checks the options of a resource written to stdout, those writing several files or files
+// alongside can't be piped
+func (r VaultResource) isValidStdout() error {
+	if !r.toStdout() {
+		return nil
+	}
+	switch r.format {
+	case "envdir", "patch", "spiffe", "p12", "jks":
+		return fmt.Errorf("the %s format can't be written to stdout", r.format)
+	}
+	if r.explode || r.isWildcard() || r.metaFile || r.ocsp || r.validate != "" || r.merge != "" {
+		return fmt.Errorf("writing to stdout is not supported with the explode, meta, ocsp, validate or merge options, or wildcards")
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func captureStdout(t *testing.T) (*bytes.Buffer, func()) {
+	buffer := &bytes.Buffer{}
+	previous := stdout
+	stdout = buffer
+
+	return buffer, func() { stdout = previous }
+}
+
+func TestStdoutResourceOptions(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/app:fmt=txt,out=stdout,keys=user|password"))
+	rn := resources.items[0]
+	assert.NoError(t, rn.IsValid())
+	assert.True(t, rn.toStdout())
+	assert.Equal(t, []string{"user", "password"}, rn.keys)
+
+	assert.Error(t, resources.Set("secret:secret/app:out=stderr"))
+	for _, spec := range []string{
+		"secret:secret/app:fmt=envdir,out=stdout",
+		"secret:secret/app:explode=true,out=stdout",
+		"secret:secret/app:meta=true,out=stdout",
+		"tpl:secret/app:tpl=/etc/app.tpl,keys=user",
+	} {
+		resources = VaultResources{}
+		assert.NoError(t, resources.Set(spec))
+		assert.Error(t, resources.items[0].IsValid(), "spec: %s should be invalid", spec)
+	}
+}
+
+func TestWriteStdout(t *testing.T) {
+	buffer, restore := captureStdout(t)
+	defer restore()
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/app:fmt=txt,out=stdout,keys=password"))
+	data := map[string]interface{}{"user": "app", "password": "s3cr3t"}
+	assert.NoError(t, processResource(resources.items[0], data, nil))
+	assert.Equal(t, "s3cr3t\n", buffer.String())
+
+	// step: a missing key fails the write
+	resources = VaultResources{}
+	assert.NoError(t, resources.Set("secret:secret/app:fmt=txt,out=stdout,keys=token"))
+	assert.Error(t, processResource(resources.items[0], data, nil))
+}
+
+func TestWriteStdoutOutput(t *testing.T) {
+	buffer, restore := captureStdout(t)
+	defer restore()
+	output := options.outputDir
+	options.outputDir = stdoutOutput
+	defer func() { options.outputDir = output }()
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/app:fmt=env"))
+	assert.NoError(t, processResource(resources.items[0], map[string]interface{}{"user": "app"}, nil))
+	assert.Equal(t, "USER=app\n", buffer.String())
+
+	assert.Error(t, validateOptions(&config{outputDir: stdoutOutput, printSchedule: true}))
+}
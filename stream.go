@@ -0,0 +1,138 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// base64Whitespace is the whitespace of a wrapped base64 value i.e. from a pem or the base64 cli
+	base64Whitespace = " \t\r\n"
+	// streamBufferSize is the size of the buffer the decoded content is written through
+	streamBufferSize = 64 << 10
+)
+
+// base64Filter reads a base64 value skipping the whitespace, so a wrapped value can be decoded as it's
+// read without building a cleaned copy
+type base64Filter struct {
+	// the base64 value
+	value string
+	// the offset of the next byte to read
+	offset int
+}
+
+// Read copies the next of the non whitespace bytes of the value
+func (f *base64Filter) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && f.offset < len(f.value) {
+		rest := f.value[f.offset:]
+		end := strings.IndexAny(rest, base64Whitespace)
+		if end == 0 {
+			f.offset++
+			continue
+		}
+		if end < 0 {
+			end = len(rest)
+		}
+		copied := copy(p[n:], rest[:end])
+		n += copied
+		f.offset += copied
+	}
+	if n == 0 && f.offset >= len(f.value) {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+// base64Source returns the encoding of the base64 value, the portion of the value to decode and the size
+// of the decoded content; as with decodeValue an unpadded value is decoded without the padding
+//	value		: the base64 value, optionally wrapped
+func base64Source(value string) (*base64.Encoding, string, int64) {
+	count, padding := 0, 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case ' ', '\t', '\r', '\n':
+		case '=':
+			count++
+			padding++
+		default:
+			count++
+			padding = 0
+		}
+	}
+	if count%4 == 0 {
+		return base64.StdEncoding, value, int64(count/4*3 - padding)
+	}
+
+	return base64.RawStdEncoding, strings.TrimRight(value, "="+base64Whitespace), int64(base64.RawStdEncoding.DecodedLen(count - padding))
+}
+
+// streamBase64File decodes the base64 value straight into a temporary file beside the file, renamed into place once
+// complete, so a large binary secret is never held decoded in memory and a corrupt value leaves the previous file
+//	filename	: the file to write
+//	value		: the base64 value
+//	mode		: the permissions of the file
+func streamBase64File(filename, value string, mode os.FileMode) error {
+	encoding, source, size := base64Source(value)
+	if options.maxFileSize > 0 && size > int64(options.maxFileSize) {
+		return fmt.Errorf("the file: %s is %d bytes, exceeding the maximum file size of %d bytes", filename, size, options.maxFileSize)
+	}
+	glog.V(3).Infof("streaming the decoded file: %s, size: %d", filename, size)
+
+	tmpfile, err := ioutil.TempFile(filepath.Dir(filename), ".vault-sidekick.")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile.Name())
+
+	// step: the decoder hands over little at a time, so the writes are buffered
+	writer := bufio.NewWriterSize(tmpfile, streamBufferSize)
+	if _, err := io.Copy(writer, base64.NewDecoder(encoding, &base64Filter{value: source})); err != nil {
+		tmpfile.Close()
+		return fmt.Errorf("unable to decode the value, error: %s", err)
+	}
+	if err := writer.Flush(); err != nil {
+		tmpfile.Close()
+		return err
+	}
+	if err := tmpfile.Chmod(mode); err != nil {
+		tmpfile.Close()
+		return err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpfile.Name(), filename)
+}
+
+// isStreamable checks if the files of the resource can be streamed to disk, the encryption, validation,
+// guarding, dry run and piping to stdout or a named pipe of a file each need the whole of the content
+func isStreamable(rn *VaultResource) bool {
+	return rn.encryptTo == "" && rn.validate == "" && guard == nil && !options.dryRun && !rn.toStdout() && !rn.fifo && !rn.memfd
+}
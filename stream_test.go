@@ -0,0 +1,136 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wrappedBase64 returns the base64 of the content wrapped at 76 characters, as the base64 cli does
+func wrappedBase64(content []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	var b bytes.Buffer
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\n")
+
+	return b.String()
+}
+
+func TestStreamBase64File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "secret.bin")
+
+	content := bytes.Repeat([]byte{0x00, 0x01, 0x02, 0xff, 0x7f}, 1000)
+	for i, value := range []string{
+		"AAEC/w==",
+		"AAEC\n/w==\n",
+		"AAEC/w",
+		"AAECAw",
+		wrappedBase64(content),
+		strings.TrimRight(base64.StdEncoding.EncodeToString(content[:10]), "="),
+	} {
+		expected, err := decodeValue(value, "base64")
+		if !assert.NoError(t, err, "case %d", i) {
+			continue
+		}
+		_, _, size := base64Source(value)
+		assert.Equal(t, int64(len(expected)), size, "case %d, unexpected size", i)
+		if assert.NoError(t, streamBase64File(filename, value, 0600), "case %d", i) {
+			written, _ := ioutil.ReadFile(filename)
+			assert.Equal(t, expected, written, "case %d, unexpected content", i)
+		}
+	}
+
+	// step: a corrupt value leaves the previous file in place
+	assert.NoError(t, streamBase64File(filename, "AAEC/w==", 0600))
+	assert.Error(t, streamBase64File(filename, "AAEC/w==not base64!", 0600))
+	written, _ := ioutil.ReadFile(filename)
+	assert.Equal(t, []byte{0x00, 0x01, 0x02, 0xff}, written)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1, "the temporary file should have been removed")
+
+	// step: the size of the decoded content is checked before writing
+	options.maxFileSize = 2
+	defer func() { options.maxFileSize = 0 }()
+	assert.Error(t, streamBase64File(filename, "AAEC/w==", 0600))
+}
+
+func TestStreamBase64FileMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	value := wrappedBase64(bytes.Repeat([]byte("0123456789abcdef"), 1<<19))
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	if !assert.NoError(t, streamBase64File(filepath.Join(dir, "large.bin"), value, 0600)) {
+		t.FailNow()
+	}
+	runtime.ReadMemStats(&after)
+
+	// step: the 8MiB secret is decoded through a fixed buffer, rather than copied
+	allocated := after.TotalAlloc - before.TotalAlloc
+	assert.True(t, allocated < 1<<20, "allocated %d bytes streaming an 8MiB secret", allocated)
+}
+
+// benchmarkBinaryValue is a wrapped base64 secret of 8MiB
+var benchmarkBinaryValue = wrappedBase64(bytes.Repeat([]byte("0123456789abcdef"), 1<<19))
+
+func BenchmarkWriteBinaryBuffered(b *testing.B) {
+	dir, _ := ioutil.TempDir("", "stream")
+	defer os.RemoveAll(dir)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		content, err := decodeValue(benchmarkBinaryValue, "base64")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := writeFile(filepath.Join(dir, "large.bin"), content, 0600); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteBinaryStreamed(b *testing.B) {
+	dir, _ := ioutil.TempDir("", "stream")
+	defer os.RemoveAll(dir)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := streamBase64File(filepath.Join(dir, "large.bin"), benchmarkBinaryValue, 0600); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
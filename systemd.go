@@ -0,0 +1,95 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// the default action taken on a systemd unit when a secret changes
+const defaultSystemdAction = "try-reload-or-restart"
+
+// validSystemdActions is the list of supported actions on a systemd unit
+var validSystemdActions = map[string]bool{
+	"reload":                true,
+	"restart":               true,
+	"try-restart":           true,
+	"reload-or-restart":     true,
+	"try-reload-or-restart": true,
+	"sighup":                true,
+}
+
+// reloadSystemdUnit informs a systemd unit a secret has changed
+//	unit		: the name of the unit i.e. nginx.service
+//	action		: the systemctl verb to call, or sighup to signal the main pid of the unit
+//	timeout		: the maximum amount of time to wait on systemctl
+func reloadSystemdUnit(unit, action string, timeout time.Duration) error {
+	if action == "" {
+		action = defaultSystemdAction
+	}
+	glog.V(3).Infof("performing: %s on the systemd unit: %s", action, unit)
+
+	if action == "sighup" {
+		output, err := runSystemctl(timeout, "show", "--property=MainPID", unit)
+		if err != nil {
+			return err
+		}
+		pid, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(output), "MainPID="))
+		if err != nil || pid <= 0 {
+			return fmt.Errorf("the systemd unit: %s has no main pid, is it running?", unit)
+		}
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return err
+		}
+
+		return process.Signal(syscall.SIGHUP)
+	}
+	_, err := runSystemctl(timeout, action, unit)
+
+	return err
+}
+
+// runSystemctl calls systemctl, which performs the action via d-bus on our behalf
+func runSystemctl(timeout time.Duration, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	timer := time.AfterFunc(timeout, func() {
+		cmd.Process.Kill()
+	})
+	defer timer.Stop()
+
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("systemctl %s failed: %s, %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,547 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// templateFuncs are the functions available to a template, a subset of those found in helm charts
+var templateFuncs = template.FuncMap{
+	"toYaml": func(v interface{}) (string, error) {
+		content, err := yaml.Marshal(v)
+		return strings.TrimSuffix(string(content), "\n"), err
+	},
+	"toJson": func(v interface{}) (string, error) {
+		content, err := json.Marshal(v)
+		return string(content), err
+	},
+	"b64enc": func(v interface{}) string {
+		return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%v", v)))
+	},
+	"b64dec": func(v interface{}) (string, error) {
+		content, err := base64.StdEncoding.DecodeString(fmt.Sprintf("%v", v))
+		return string(content), err
+	},
+	"quote": func(v interface{}) string {
+		return fmt.Sprintf("%q", fmt.Sprintf("%v", v))
+	},
+	"indent": func(spaces int, v string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.Replace(v, "\n", "\n"+pad, -1)
+	},
+	"nindent": func(spaces int, v string) string {
+		pad := strings.Repeat(" ", spaces)
+		return "\n" + pad + strings.Replace(v, "\n", "\n"+pad, -1)
+	},
+	"default": func(value, v interface{}) interface{} {
+		if v == nil || fmt.Sprintf("%v", v) == "" {
+			return value
+		}
+		return v
+	},
+	"required": func(message string, v interface{}) (interface{}, error) {
+		if v == nil || fmt.Sprintf("%v", v) == "" {
+			return nil, errors.New(message)
+		}
+		return v, nil
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+}
+
+// errTemplateUnchanged indicates none of the inputs referenced by a template have changed since it was rendered
+var errTemplateUnchanged = errors.New("the inputs of the template have not changed")
+
+// writeTemplateFile renders the template of the resource with the values files as .Values and the
+// secrets as .Secrets, in the style of a helm chart; the files of other resources read by the template
+// are tracked, so the template is rendered again when they're updated, and the render is skipped when
+// none of the secrets or files referenced by the template have changed
+//	filename	: the filename to write to
+//	data		: the secret data
+//	rn			: the resource
+//	meta		: the metadata of the secret
+func writeTemplateFile(filename string, data map[string]interface{}, rn *VaultResource, meta *secretMetadata) error {
+	// step: the output of a previous render is kept while none of its inputs have changed, the metadata file
+	// follows the lease so is written on every update
+	skippable := !rn.toStdout() && !options.dryRun && !rn.metaFile && !rn.memfd
+	if skippable {
+		if found, _ := fileExists(filename); found && templateChain.unchanged(rn, data, meta) {
+			return errTemplateUnchanged
+		}
+	}
+	content, files, err := renderTemplate(rn.templateFile, rn.valuesFiles, data)
+	if err != nil {
+		return err
+	}
+	templateChain.track(rn, files, data, meta)
+	digest, digestErr := templateDigest(rn, data, files)
+
+	if err := writeResourceContent(rn, filename, content); err != nil {
+		templateChain.forget(rn)
+		return err
+	}
+	if skippable && digestErr == nil {
+		templateChain.rendered(rn, digest)
+	} else {
+		templateChain.forget(rn)
+	}
+
+	return nil
+}
+
+// renderTemplate renders the template with the merged values files and the secret, returning the
+// files read by the template
+//	filename	: the path to the template
+//	values		: the values files, later files taking precedence
+//	data		: the secret data
+func renderTemplate(filename string, values []string, data map[string]interface{}) ([]byte, []string, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read the template: %s, error: %s", filename, err)
+	}
+	// step: the file function reads the output of another resource, relative to the output directory
+	var files []string
+	funcs := template.FuncMap{
+		"file": func(name string) (string, error) {
+			path := resolveFilename(name)
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("unable to read the file: %s, error: %s", path, err)
+			}
+			files = append(files, path)
+			return string(content), nil
+		},
+	}
+	tmpl, err := template.New(filepath.Base(filename)).Funcs(templateFuncs).Funcs(funcs).Parse(string(content))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse the template: %s, error: %s", filename, err)
+	}
+	merged, err := readValuesFiles(values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, map[string]interface{}{"Values": merged, "Secrets": data}); err != nil {
+		return nil, nil, fmt.Errorf("unable to render the template: %s, error: %s", filename, err)
+	}
+	glog.V(4).Infof("rendered the template: %s with %d values files and %d files", filename, len(values), len(files))
+
+	// step: as helm, a missing key renders as empty rather than <no value>
+	return bytes.Replace(b.Bytes(), []byte("<no value>"), []byte(""), -1), files, nil
+}
+
+// templateChain tracks the templates reading the files of other resources
+var templateChain = newTemplateDependencies()
+
+// chainedTemplate is a template resource which reads the files of other resources
+type chainedTemplate struct {
+	// the template resource
+	resource *VaultResource
+	// the secret data the template was last rendered with
+	data map[string]interface{}
+	// the metadata of the secret
+	meta *secretMetadata
+	// the files read by the template
+	files []string
+}
+
+// renderedTemplate is the inputs a template was last rendered from
+type renderedTemplate struct {
+	// the digest of the inputs referenced by the template
+	digest [sha256.Size]byte
+	// the files read by the template
+	files []string
+}
+
+// templateDependencies holds the chained templates
+type templateDependencies struct {
+	sync.Mutex
+	// the chained templates keyed by the resource
+	items map[*VaultResource]*chainedTemplate
+	// the inputs of the templates last rendered, keyed by the resource
+	renders map[*VaultResource]*renderedTemplate
+}
+
+// newTemplateDependencies creates an empty set of dependencies
+func newTemplateDependencies() *templateDependencies {
+	return &templateDependencies{
+		items:   make(map[*VaultResource]*chainedTemplate, 0),
+		renders: make(map[*VaultResource]*renderedTemplate, 0),
+	}
+}
+
+// unchanged checks if the inputs referenced by a template are those it was last rendered from; the data held
+// for a chained template is refreshed regardless, so a later render uses the latest secret
+//	rn			: the template resource
+//	data		: the secret data
+//	meta		: the metadata of the secret
+func (t *templateDependencies) unchanged(rn *VaultResource, data map[string]interface{}, meta *secretMetadata) bool {
+	t.Lock()
+	last, found := t.renders[rn]
+	if x, tracked := t.items[rn]; tracked {
+		x.data, x.meta = data, meta
+	}
+	t.Unlock()
+	if !found {
+		return false
+	}
+	digest, err := templateDigest(rn, data, last.files)
+	if err != nil {
+		glog.V(4).Infof("unable to digest the inputs of the template: %s, error: %s", rn, err)
+		return false
+	}
+
+	return digest == last.digest
+}
+
+// rendered records the digest of the inputs a template was rendered from
+//	rn			: the template resource
+//	digest		: the digest of the inputs
+func (t *templateDependencies) rendered(rn *VaultResource, digest [sha256.Size]byte) {
+	t.Lock()
+	defer t.Unlock()
+	var files []string
+	if x, found := t.items[rn]; found {
+		files = x.files
+	}
+	t.renders[rn] = &renderedTemplate{digest: digest, files: files}
+}
+
+// forget drops the inputs of a template, so it's rendered on the next update
+//	rn			: the template resource
+func (t *templateDependencies) forget(rn *VaultResource) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.renders, rn)
+}
+
+// track records the files read when rendering a template, keeping the data so it can be rendered again
+//	rn			: the template resource
+//	files		: the files read by the template
+//	data		: the secret data
+//	meta		: the metadata of the secret
+func (t *templateDependencies) track(rn *VaultResource, files []string, data map[string]interface{}, meta *secretMetadata) {
+	t.Lock()
+	defer t.Unlock()
+	if len(files) == 0 {
+		delete(t.items, rn)
+		return
+	}
+	t.items[rn] = &chainedTemplate{resource: rn, data: data, meta: meta, files: files}
+}
+
+// dependents returns the templates reading any of the files written by a resource
+//	filename	: the filename of the resource, the cert and bundle formats add a suffix
+func (t *templateDependencies) dependents(filename string) []*chainedTemplate {
+	t.Lock()
+	defer t.Unlock()
+	var list []*chainedTemplate
+	for _, x := range t.items {
+		for _, dependency := range x.files {
+			if dependency == filename || strings.HasPrefix(dependency, filename+".") ||
+				strings.HasPrefix(dependency, filename+"-") || strings.HasPrefix(dependency, filename+string(os.PathSeparator)) {
+				list = append(list, x)
+				break
+			}
+		}
+	}
+
+	return list
+}
+
+// render renders again the templates reading the files of a resource which has been updated, which in turn
+// renders the templates reading those; a template is rendered at most once per update so a cycle ends
+//	rn			: the resource which has been updated
+func (t *templateDependencies) render(rn *VaultResource) {
+	visited := map[*VaultResource]bool{rn: true}
+	queue := []*VaultResource{rn}
+	for len(queue) > 0 {
+		x := queue[0]
+		queue = queue[1:]
+		filename := resolveFilename(x.GetFilename())
+		if x.isWildcard() {
+			filename = wildcardDirectory(x)
+		}
+		for _, dependent := range t.dependents(filename) {
+			if visited[dependent.resource] {
+				continue
+			}
+			visited[dependent.resource] = true
+			glog.V(3).Infof("resource: %s has been updated, checking the template: %s", x, dependent.resource)
+			if err := processResource(dependent.resource, dependent.data, dependent.meta); err != nil {
+				glog.Errorf("failed to render the template: %s, error: %s", dependent.resource, err)
+				continue
+			}
+			queue = append(queue, dependent.resource)
+		}
+	}
+}
+
+// templateDigest returns a digest of the inputs of a template: the template itself, the values files, the keys
+// of the secret the template references and the files of other resources it read
+//	rn			: the template resource
+//	data		: the secret data
+//	files		: the files read by the template
+func templateDigest(rn *VaultResource, data map[string]interface{}, files []string) ([sha256.Size]byte, error) {
+	var digest [sha256.Size]byte
+	content, err := ioutil.ReadFile(rn.templateFile)
+	if err != nil {
+		return digest, err
+	}
+	keys, all, err := templateReferences(string(content))
+	if err != nil {
+		return digest, err
+	}
+
+	h := sha256.New()
+	add := func(name string, content []byte) {
+		fmt.Fprintf(h, "%s:%d:", name, len(content))
+		h.Write(content)
+	}
+	add("template", content)
+	for _, filename := range append(append([]string{}, rn.valuesFiles...), files...) {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return digest, err
+		}
+		add(filename, content)
+	}
+	// step: only the keys referenced by the template, unless it references the secret as a whole
+	if all {
+		keys = make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+	for _, key := range keys {
+		value, err := json.Marshal(data[key])
+		if err != nil {
+			return digest, err
+		}
+		add("secret."+key, value)
+	}
+	copy(digest[:], h.Sum(nil))
+
+	return digest, nil
+}
+
+// templateReferences parses a template for the keys of the secret it references, i.e. .Secrets.password or
+// index .Secrets "password"; all is set when the secret is referenced as a whole, i.e. toJson .Secrets
+//	content		: the content of the template
+func templateReferences(content string) ([]string, bool, error) {
+	funcs := template.FuncMap{"file": func(string) (string, error) { return "", nil }}
+	tmpl, err := template.New("references").Funcs(templateFuncs).Funcs(funcs).Parse(content)
+	if err != nil {
+		return nil, false, err
+	}
+	refs := &secretReferences{keys: make(map[string]bool, 0)}
+	for _, x := range tmpl.Templates() {
+		if x.Tree != nil {
+			refs.walk(x.Tree.Root, false)
+		}
+	}
+	var keys []string
+	for key := range refs.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys, refs.all, nil
+}
+
+// secretReferences collects the keys of the secret referenced by a template
+type secretReferences struct {
+	// the keys referenced
+	keys map[string]bool
+	// the secret is referenced as a whole
+	all bool
+}
+
+// walk visits the nodes of a template; within a with or range block dot is derived from the pipeline of the
+// block, the references of which are already collected
+//	node		: the node of the template
+//	rebound		: dot is no longer the root of the data
+func (s *secretReferences) walk(node parse.Node, rebound bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, x := range n.Nodes {
+			s.walk(x, rebound)
+		}
+	case *parse.ActionNode:
+		s.walk(n.Pipe, rebound)
+	case *parse.TemplateNode:
+		s.walk(n.Pipe, rebound)
+	case *parse.IfNode:
+		s.branch(&n.BranchNode, rebound, false)
+	case *parse.RangeNode:
+		s.branch(&n.BranchNode, rebound, true)
+	case *parse.WithNode:
+		s.branch(&n.BranchNode, rebound, true)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, x := range n.Cmds {
+			s.walk(x, rebound)
+		}
+	case *parse.CommandNode:
+		// step: index .Secrets "key" references a single key
+		if len(n.Args) >= 3 {
+			id, isIndex := n.Args[0].(*parse.IdentifierNode)
+			field, isField := n.Args[1].(*parse.FieldNode)
+			key, isString := n.Args[2].(*parse.StringNode)
+			if isIndex && isField && isString && id.Ident == "index" && len(field.Ident) == 1 && field.Ident[0] == "Secrets" {
+				s.keys[key.Text] = true
+				for _, x := range n.Args[3:] {
+					s.walk(x, rebound)
+				}
+				return
+			}
+		}
+		for _, x := range n.Args {
+			s.walk(x, rebound)
+		}
+	case *parse.FieldNode:
+		s.fields(n.Ident)
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" {
+			s.fields(n.Ident[1:])
+		}
+	case *parse.ChainNode:
+		switch x := n.Node.(type) {
+		case *parse.FieldNode:
+			s.fields(append(append([]string{}, x.Ident...), n.Field...))
+		case *parse.VariableNode:
+			if x.Ident[0] == "$" {
+				s.fields(append(append([]string{}, x.Ident[1:]...), n.Field...))
+			}
+		case *parse.DotNode:
+			if !rebound {
+				s.fields(n.Field)
+			}
+		default:
+			s.walk(n.Node, rebound)
+		}
+	case *parse.DotNode:
+		if !rebound {
+			s.all = true
+		}
+	}
+}
+
+// branch visits an if, range or with block; the else branch keeps the dot of the enclosing block
+//	n			: the block
+//	rebound		: dot is no longer the root of the data
+//	rebinds		: the block sets dot to its pipeline
+func (s *secretReferences) branch(n *parse.BranchNode, rebound, rebinds bool) {
+	s.walk(n.Pipe, rebound)
+	s.walk(n.List, rebound || rebinds)
+	s.walk(n.ElseList, rebound)
+}
+
+// fields records the reference of the fields from the root of the data
+//	ident		: the fields, i.e. Secrets, password
+func (s *secretReferences) fields(ident []string) {
+	switch {
+	case len(ident) == 0:
+		s.all = true
+	case ident[0] != "Secrets":
+	case len(ident) == 1:
+		s.all = true
+	default:
+		s.keys[ident[1]] = true
+	}
+}
+
+// readValuesFiles reads and deep merges the values files
+func readValuesFiles(files []string) (map[string]interface{}, error) {
+	merged := make(map[string]interface{}, 0)
+	for _, filename := range files {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the values file: %s, error: %s", filename, err)
+		}
+		var values map[string]interface{}
+		if err := yaml.Unmarshal(content, &values); err != nil {
+			return nil, fmt.Errorf("unable to parse the values file: %s, error: %s", filename, err)
+		}
+		mergeValues(merged, normalizeValues(values).(map[string]interface{}))
+	}
+
+	return merged, nil
+}
+
+// mergeValues deep merges the source into the destination, the source taking precedence
+func mergeValues(dest, src map[string]interface{}) {
+	for k, v := range src {
+		existing, found := dest[k].(map[string]interface{})
+		if values, ok := v.(map[string]interface{}); ok && found {
+			mergeValues(existing, values)
+			continue
+		}
+		dest[k] = v
+	}
+}
+
+// normalizeValues converts the maps decoded from yaml into string keyed maps, so they can be
+// encoded as json and indexed in the templates
+func normalizeValues(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		values := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			values[fmt.Sprintf("%v", k)] = normalizeValues(v)
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			values[k] = normalizeValues(v)
+		}
+		return values
+	case []interface{}:
+		for i := range x {
+			x[i] = normalizeValues(x[i])
+		}
+		return x
+	}
+
+	return v
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		Template string
+		Expected string
+		Ok       bool
+	}{
+		{Template: `{{ .Secrets.password | b64enc }}`, Expected: "czNjcjN0", Ok: true},
+		{Template: `{{ .Values.missing }}|{{ .Values.database.host | upper }}`, Expected: "|DB.PROD.SVC", Ok: true},
+		{Template: `{{ .Values.database | toJson }}`, Expected: `{"host":"db.prod.svc","pool":{"max":20,"min":5}}`, Ok: true},
+		{Template: `{{ required "the token is required" .Secrets.token }}`},
+		{Template: `{{ .Secrets.password `},
+	}
+	values := []string{filepath.Join(dir, "values.yaml"), filepath.Join(dir, "override.yaml")}
+	ioutil.WriteFile(values[0], []byte("database:\n  host: db.svc\n  pool:\n    min: 5\n    max: 10\n"), 0644)
+	ioutil.WriteFile(values[1], []byte("database:\n  host: db.prod.svc\n  pool:\n    max: 20\n"), 0644)
+
+	for i, c := range cases {
+		filename := filepath.Join(dir, "test.tmpl")
+		if !assert.NoError(t, ioutil.WriteFile(filename, []byte(c.Template), 0644)) {
+			t.FailNow()
+		}
+		content, _, err := renderTemplate(filename, values, map[string]interface{}{"password": "s3cr3t"})
+		if !c.Ok {
+			assert.Error(t, err, "case %d, expected an error", i)
+			continue
+		}
+		if assert.NoError(t, err, "case %d", i) {
+			assert.Equal(t, c.Expected, string(content), "case %d", i)
+		}
+	}
+}
+
+func TestTemplateResourceValid(t *testing.T) {
+	var items VaultResources
+	if !assert.NoError(t, items.Set("tpl:secret/app:tpl=/etc/templates/app.tmpl,values=/etc/values.yaml|/etc/prod.yaml")) {
+		t.FailNow()
+	}
+	assert.NoError(t, items.items[0].IsValid())
+	assert.Equal(t, []string{"/etc/values.yaml", "/etc/prod.yaml"}, items.items[0].valuesFiles)
+
+	assert.NoError(t, items.Set("tpl:secret/app"))
+	assert.Error(t, items.items[1].IsValid())
+
+	assert.NoError(t, items.Set("secret:secret/app:values=/etc/values.yaml"))
+	assert.Error(t, items.items[2].IsValid())
+}
+
+func TestTemplateChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chain")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	options.outputDir = dir
+	defer func() { options.outputDir = "" }()
+
+	template := filepath.Join(dir, "app.tmpl")
+	assert.NoError(t, ioutil.WriteFile(template, []byte(`{{ .Secrets.password }}|{{ file "tls.crt" | trim }}`), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tls.crt"), []byte("first\n"), 0644))
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("tpl:secret/app:tpl="+template+",file=app.conf"))
+	assert.NoError(t, resources.Set("pki:pki/issue/app:common_name=app,fmt=cert,file=tls"))
+	assert.NoError(t, resources.Set("secret:secret/other:file=other"))
+	tpl, pki, other := resources.items[0], resources.items[1], resources.items[2]
+
+	// step: the template reads the certificate
+	assert.NoError(t, processResource(tpl, map[string]interface{}{"password": "s3cr3t"}, nil))
+	content, err := ioutil.ReadFile(filepath.Join(dir, "app.conf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t|first", string(content))
+	assert.Len(t, templateChain.dependents(filepath.Join(dir, "tls")), 1)
+	assert.Len(t, templateChain.dependents(filepath.Join(dir, "other")), 0)
+
+	// step: an update of the certificate renders the template again
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tls.crt"), []byte("second\n"), 0644))
+	templateChain.render(other)
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "app.conf"))
+	assert.Equal(t, "s3cr3t|first", string(content))
+	templateChain.render(pki)
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "app.conf"))
+	assert.Equal(t, "s3cr3t|second", string(content))
+
+	// step: a template without files is no longer tracked
+	assert.NoError(t, ioutil.WriteFile(template, []byte(`{{ .Secrets.password }}`), 0644))
+	assert.NoError(t, processResource(tpl, map[string]interface{}{"password": "s3cr3t"}, nil))
+	assert.Len(t, templateChain.dependents(filepath.Join(dir, "tls")), 0)
+}
+
+func TestTemplateReferences(t *testing.T) {
+	cases := []struct {
+		Template string
+		Keys     []string
+		All      bool
+	}{
+		{Template: `{{ .Secrets.password }}`, Keys: []string{"password"}},
+		{Template: `{{ .Secrets.user }}:{{ .Secrets.password | b64enc }}{{ .Values.port }}`, Keys: []string{"password", "user"}},
+		{Template: `{{ index .Secrets "api-key" }}`, Keys: []string{"api-key"}},
+		{Template: `{{ with .Secrets.db }}{{ .user }}{{ end }}`, Keys: []string{"db"}},
+		{Template: `{{ range .Values.hosts }}{{ . }}{{ $.Secrets.token }}{{ end }}`, Keys: []string{"token"}},
+		{Template: `{{ if .Values.tls }}{{ file "tls.crt" }}{{ else }}{{ .Secrets.cert }}{{ end }}`, Keys: []string{"cert"}},
+		{Template: `{{ define "creds" }}{{ .Secrets.user }}{{ end }}{{ template "creds" . }}`, Keys: []string{"user"}, All: true},
+		{Template: `{{ toJson .Secrets }}`, All: true},
+		{Template: `{{ range $k, $v := .Secrets }}{{ $k }}{{ end }}`, All: true},
+		{Template: `{{ toYaml . }}`, All: true},
+		{Template: `static`},
+	}
+	for i, c := range cases {
+		keys, all, err := templateReferences(c.Template)
+		if assert.NoError(t, err, "case %d", i) {
+			assert.Equal(t, c.Keys, keys, "case %d", i)
+			assert.Equal(t, c.All, all, "case %d", i)
+		}
+	}
+	_, _, err := templateReferences(`{{ .Secrets.password `)
+	assert.Error(t, err)
+}
+
+func TestTemplateUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unchanged")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	options.outputDir = dir
+	defer func() { options.outputDir = "" }()
+
+	template := filepath.Join(dir, "app.tmpl")
+	output := filepath.Join(dir, "app.conf")
+	assert.NoError(t, ioutil.WriteFile(template, []byte(`{{ .Secrets.password }}|{{ file "tls.crt" | trim }}`), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tls.crt"), []byte("first\n"), 0644))
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("tpl:secret/app,secret/other:tpl="+template+",file=app.conf"))
+	assert.NoError(t, resources.Set("pki:pki/issue/app:common_name=app,fmt=cert,file=tls"))
+	tpl, pki := resources.items[0], resources.items[1]
+	defer templateChain.forget(tpl)
+
+	assert.NoError(t, processResource(tpl, map[string]interface{}{"password": "s3cr3t", "other": "a"}, nil))
+	content, _ := ioutil.ReadFile(output)
+	assert.Equal(t, "s3cr3t|first", string(content))
+
+	// step: a change of a key the template doesn't reference leaves the output as is
+	assert.NoError(t, ioutil.WriteFile(output, []byte("untouched"), 0644))
+	assert.NoError(t, processResource(tpl, map[string]interface{}{"password": "s3cr3t", "other": "b"}, nil))
+	content, _ = ioutil.ReadFile(output)
+	assert.Equal(t, "untouched", string(content))
+
+	// step: an update of the certificate with the same content leaves the output as is
+	templateChain.render(pki)
+	content, _ = ioutil.ReadFile(output)
+	assert.Equal(t, "untouched", string(content))
+
+	// step: a change of a referenced file, key or the template renders it again
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tls.crt"), []byte("second\n"), 0644))
+	templateChain.render(pki)
+	content, _ = ioutil.ReadFile(output)
+	assert.Equal(t, "s3cr3t|second", string(content))
+
+	assert.NoError(t, processResource(tpl, map[string]interface{}{"password": "changed", "other": "b"}, nil))
+	content, _ = ioutil.ReadFile(output)
+	assert.Equal(t, "changed|second", string(content))
+
+	assert.NoError(t, ioutil.WriteFile(template, []byte(`{{ .Secrets.password }}`), 0644))
+	assert.NoError(t, processResource(tpl, map[string]interface{}{"password": "changed", "other": "b"}, nil))
+	content, _ = ioutil.ReadFile(output)
+	assert.Equal(t, "changed", string(content))
+
+	// step: a missing output is rendered again
+	assert.NoError(t, os.Remove(output))
+	assert.NoError(t, processResource(tpl, map[string]interface{}{"password": "changed", "other": "b"}, nil))
+	content, _ = ioutil.ReadFile(output)
+	assert.Equal(t, "changed", string(content))
+}
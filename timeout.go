@@ -0,0 +1,92 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// timeouts holds the clients of the resources with a timeout
+var timeouts = newTimeoutClients()
+
+// timeoutClients are the clients bounding each request by a timeout, the http client being shared by
+// the clones of a vault client, keyed by the address of the vault and the timeout
+type timeoutClients struct {
+	sync.Mutex
+	// the clients keyed by the address and timeout
+	items map[string]*api.Client
+}
+
+// newTimeoutClients creates an empty set of clients
+func newTimeoutClients() *timeoutClients {
+	return &timeoutClients{items: make(map[string]*api.Client, 0)}
+}
+
+// get returns a client of the same vault as the client, bounding each request by the timeout
+//	client		: the client of the vault
+//	timeout		: the timeout of each request
+func (t *timeoutClients) get(client *api.Client, timeout time.Duration) (*api.Client, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	key := fmt.Sprintf("%s|%s", client.Address(), timeout)
+	x, found := t.items[key]
+	if !found {
+		var err error
+		if x, err = newAPIClient(&options, client.Address()); err != nil {
+			return nil, err
+		}
+		// step: a single attempt, the failure is retried along with the other resources rather than holding them up
+		x.SetClientTimeout(timeout)
+		x.SetMaxRetries(1)
+		t.items[key] = x
+	}
+	// step: the token may have been renewed or reissued since
+	x.SetToken(client.Token())
+
+	return x, nil
+}
+
+// resourceTimeout returns the bound on each request to vault for the resource, zero for the default of the client
+func resourceTimeout(rn *VaultResource) time.Duration {
+	if rn.timeout > 0 {
+		return rn.timeout
+	}
+
+	return options.resourceDeadline
+}
+
+// withTimeout returns a copy of the service whose clients bound each request by the timeout
+//	timeout		: the timeout of each request
+func (r VaultService) withTimeout(timeout time.Duration) (VaultService, error) {
+	client, err := timeouts.get(r.client, timeout)
+	if err != nil {
+		return r, err
+	}
+	r.client = client
+	if r.readClient != nil {
+		if r.readClient, err = timeouts.get(r.readClient, timeout); err != nil {
+			return r, err
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/database/creds/slow" {
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"password": "secret"}})
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := newAPIClient(&config{vaultMaxRetries: 0}, server.URL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	service := VaultService{client: client}
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:database/creds/slow:timeout=100ms"))
+	assert.NoError(t, resources.Set("secret:secret/fast"))
+	assert.Error(t, resources.Set("secret:secret/fast:timeout=never"))
+	assert.Error(t, resources.Set("secret:secret/fast:timeout=0s"))
+
+	// step: the slow resource fails once the timeout has passed
+	started := time.Now()
+	err = service.get(&watchedResource{resource: resources.items[0]})
+	if assert.Error(t, err) {
+		assert.True(t, strings.HasPrefix(err.Error(), "a request exceeded the timeout of 100ms"), err.Error())
+	}
+	assert.True(t, time.Since(started) < 2*time.Second)
+
+	// step: the resource deadline applies to the resources without a timeout
+	options.resourceDeadline = 50 * time.Millisecond
+	defer func() { options.resourceDeadline = 0 }()
+	assert.Equal(t, 100*time.Millisecond, resourceTimeout(resources.items[0]))
+	assert.Equal(t, 50*time.Millisecond, resourceTimeout(resources.items[1]))
+	fast := &watchedResource{resource: resources.items[1]}
+	if assert.NoError(t, service.get(fast)) {
+		assert.Equal(t, "secret", fast.secret.Data["password"])
+	}
+}
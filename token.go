@@ -0,0 +1,248 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+// requestToken exchanges the login token for a periodic and or orphan token with the policies requested
+// in the auth options, the login token is returned as is when nothing is requested
+//	client		: the vault client
+//	auth		: the authentication options
+//	token		: the token returned by the login
+func requestToken(client *api.Client, auth *vaultAuthOptions, token string) (string, error) {
+	// step: fallback to the environment
+	if auth.TokenPeriod == "" {
+		auth.TokenPeriod = os.Getenv("VAULT_SIDEKICK_TOKEN_PERIOD")
+	}
+	if len(auth.TokenPolicies) == 0 {
+		for _, x := range strings.Split(os.Getenv("VAULT_SIDEKICK_TOKEN_POLICIES"), ",") {
+			if x = strings.TrimSpace(x); x != "" {
+				auth.TokenPolicies = append(auth.TokenPolicies, x)
+			}
+		}
+	}
+	if !auth.TokenOrphan {
+		auth.TokenOrphan, _ = strconv.ParseBool(os.Getenv("VAULT_SIDEKICK_TOKEN_ORPHAN"))
+	}
+	if auth.TokenPeriod == "" && len(auth.TokenPolicies) == 0 && !auth.TokenOrphan {
+		return token, nil
+	}
+	if auth.TokenPeriod != "" {
+		if _, err := time.ParseDuration(auth.TokenPeriod); err != nil {
+			return "", fmt.Errorf("the token period: %s is invalid, should be a duration", auth.TokenPeriod)
+		}
+	}
+
+	client.SetToken(token)
+	request := &api.TokenCreateRequest{
+		Policies:    auth.TokenPolicies,
+		Period:      auth.TokenPeriod,
+		DisplayName: prog,
+	}
+	var secret *api.Secret
+	var err error
+	if auth.TokenOrphan {
+		secret, err = client.Auth().Token().CreateOrphan(request)
+	} else {
+		secret, err = client.Auth().Token().Create(request)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to create the token, error: %s", err)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return "", fmt.Errorf("no token was returned by vault")
+	}
+	glog.Infof("created a token, period: %q, orphan: %t, policies: %v", auth.TokenPeriod, auth.TokenOrphan, secret.Auth.Policies)
+
+	return secret.Auth.ClientToken, nil
+}
+
+// isRenewableToken checks if the token can be renewed, batch tokens never can
+func isRenewableToken(info *api.Secret) bool {
+	if info == nil || info.Data == nil {
+		return false
+	}
+	if kind, found := info.Data["type"].(string); found && kind == "batch" {
+		return false
+	}
+	renewable, err := info.TokenIsRenewable()
+
+	return err == nil && renewable
+}
+
+// renewToken renews the token of the client, or logs in again when the token is not renewable,
+// returning the information of the token
+//	client		: the vault client
+//	opts		: the configuration
+//	auth		: the authentication options
+//	renewable	: whether the token can be renewed
+func renewToken(client *api.Client, opts *config, auth *vaultAuthOptions, renewable bool) (*api.Secret, error) {
+	if renewable {
+		glog.Infof("attempting token renew")
+		return client.Auth().Token().RenewSelf(0)
+	}
+
+	glog.Infof("the token is not renewable, attempting to login again")
+	token, err := login(client, opts, auth)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(token)
+
+	return client.Auth().Token().LookupSelf()
+}
+
+// tokens holds the tokens the vaults are accessed with
+var tokens = &tokenRegistry{items: make(map[string]*tokenStatus, 0)}
+
+// tokenStatus is the token a vault is accessed with, the token itself is never exposed
+type tokenStatus struct {
+	// the address of the vault
+	Vault string `json:"vault"`
+	// the accessor of the token
+	Accessor string `json:"accessor"`
+	// the policies of the token
+	Policies []string `json:"policies,omitempty"`
+	// the time the token expires, if it does
+	Expires *time.Time `json:"expires,omitempty"`
+	// whether the token has been revoked
+	Revoked bool `json:"revoked"`
+	// the client holding the token
+	client *api.Client
+}
+
+// tokenRegistry holds the tokens of the vault clients
+type tokenRegistry struct {
+	sync.RWMutex
+	// the tokens keyed by the address of the vault
+	items map[string]*tokenStatus
+}
+
+// update records the token of the client after a login, logging the accessor
+//	url			: the address of the vault
+//	client		: the vault client
+//	info		: the lookup of the token
+func (t *tokenRegistry) update(url string, client *api.Client, info *api.Secret) {
+	status := &tokenStatus{Vault: url, client: client}
+	if info != nil && info.Data != nil {
+		status.Accessor, _ = info.Data["accessor"].(string)
+		if list, found := info.Data["policies"].([]interface{}); found {
+			for _, x := range list {
+				status.Policies = append(status.Policies, fmt.Sprintf("%v", x))
+			}
+		}
+		if ttl, err := info.TokenTTL(); err == nil && ttl > 0 {
+			expires := time.Now().Add(ttl)
+			status.Expires = &expires
+		}
+	}
+	glog.Infof("authenticated with vault: %s, token accessor: %s, policies: %v", url, status.Accessor, status.Policies)
+
+	t.Lock()
+	defer t.Unlock()
+	t.items[url] = status
+}
+
+// list returns a copy of the tokens, ordered by the address of the vault
+func (t *tokenRegistry) list() []tokenStatus {
+	t.RLock()
+	defer t.RUnlock()
+	var list []tokenStatus
+	for _, x := range t.items {
+		list = append(list, *x)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Vault < list[j].Vault })
+
+	return list
+}
+
+// isRevoked checks if the token of the vault has been revoked via the admin api
+//	url			: the address of the vault
+func (t *tokenRegistry) isRevoked(url string) bool {
+	t.RLock()
+	defer t.RUnlock()
+	x, found := t.items[url]
+
+	return found && x.Revoked
+}
+
+// revoke revokes the tokens, which revokes the child tokens and every lease issued with them
+func (t *tokenRegistry) revoke() error {
+	t.Lock()
+	defer t.Unlock()
+	var failed []string
+	for url, x := range t.items {
+		if x.Revoked {
+			continue
+		}
+		if err := x.client.Auth().Token().RevokeSelf(""); err != nil {
+			glog.Errorf("failed to revoke the token of vault: %s, accessor: %s, error: %s", url, x.Accessor, err)
+			failed = append(failed, url)
+			continue
+		}
+		x.Revoked = true
+		glog.Warningf("revoked the token of vault: %s, accessor: %s, along with its child tokens and leases", url, x.Accessor)
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("failed to revoke the tokens of: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// runRevokeSelfCommand asks the sidekick serving the admin api to revoke its tokens, i.e. when a token
+// is thought compromised, returning the exit code
+//	args		: the arguments following the command
+func runRevokeSelfCommand(args []string) int {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return 1
+	}
+	if options.listen == "" {
+		fmt.Fprintln(os.Stderr, "[error] the admin api of the sidekick must be given by -listen or VAULT_SIDEKICK_LISTEN")
+		return 1
+	}
+	address := dialAddress(options.listen)
+	client := &http.Client{Timeout: time.Duration(30) * time.Second}
+	resp, err := client.Post(fmt.Sprintf("http://%s/v1/token/revoke", address), "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[error] unable to reach the admin api, error: %s\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "[error] the tokens were not revoked, status: %d\n", resp.StatusCode)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// tokenVault is a fake vault handing out batch tokens on login and periodic tokens on create
+type tokenVault struct {
+	sync.Mutex
+	logins   int
+	requests []api.TokenCreateRequest
+	paths    []string
+}
+
+func (v *tokenVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.Lock()
+	defer v.Unlock()
+	v.paths = append(v.paths, r.URL.Path)
+
+	var resp interface{}
+	switch r.URL.Path {
+	case "/v1/auth/userpass/login/app":
+		v.logins++
+		resp = map[string]interface{}{"auth": map[string]interface{}{"client_token": fmt.Sprintf("batch-%d", v.logins)}}
+	case "/v1/auth/token/create", "/v1/auth/token/create-orphan":
+		var request api.TokenCreateRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		v.requests = append(v.requests, request)
+		resp = map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": "periodic", "policies": request.Policies, "renewable": true,
+		}}
+	case "/v1/auth/token/lookup-self":
+		resp = map[string]interface{}{"data": map[string]interface{}{
+			"id": r.Header.Get("X-Vault-Token"), "type": "batch", "renewable": false, "ttl": 600,
+		}}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newTokenVault(t *testing.T) (*tokenVault, *api.Client, func()) {
+	vault := &tokenVault{}
+	server := httptest.NewServer(vault)
+	client, err := api.NewClient(&api.Config{Address: server.URL, HttpClient: http.DefaultClient})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return vault, client, server.Close
+}
+
+func TestRequestToken(t *testing.T) {
+	vault, client, closer := newTokenVault(t)
+	defer closer()
+
+	// step: nothing requested should return the login token
+	token, err := requestToken(client, &vaultAuthOptions{}, "login")
+	assert.NoError(t, err)
+	assert.Equal(t, "login", token)
+	assert.Empty(t, vault.paths)
+
+	token, err = requestToken(client, &vaultAuthOptions{TokenPeriod: "24h", TokenPolicies: []string{"app"}}, "login")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "periodic", token)
+		assert.Equal(t, []string{"/v1/auth/token/create"}, vault.paths)
+		assert.Equal(t, "24h", vault.requests[0].Period)
+		assert.Equal(t, []string{"app"}, vault.requests[0].Policies)
+	}
+
+	_, err = requestToken(client, &vaultAuthOptions{TokenOrphan: true}, "login")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/v1/auth/token/create-orphan", vault.paths[1])
+	}
+
+	_, err = requestToken(client, &vaultAuthOptions{TokenPeriod: "daily"}, "login")
+	assert.Error(t, err)
+}
+
+func TestRenewBatchToken(t *testing.T) {
+	vault, client, closer := newTokenVault(t)
+	defer closer()
+
+	auth := &vaultAuthOptions{Method: "userpass", Username: "app", Password: "secret"}
+	token, err := login(client, &config{}, auth)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "batch-1", token)
+	client.SetToken(token)
+
+	info, err := client.Auth().Token().LookupSelf()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.False(t, isRenewableToken(info))
+
+	// step: a batch token should be replaced by logging in again rather than renewed
+	info, err = renewToken(client, &config{}, auth, false)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, 2, vault.logins)
+	assert.Equal(t, "batch-2", client.Token())
+	assert.NotContains(t, vault.paths, "/v1/auth/token/renew-self")
+}
+
+func TestIsRenewableToken(t *testing.T) {
+	assert.False(t, isRenewableToken(nil))
+	assert.True(t, isRenewableToken(&api.Secret{Data: map[string]interface{}{"type": "service", "renewable": true}}))
+	assert.False(t, isRenewableToken(&api.Secret{Data: map[string]interface{}{"type": "batch", "renewable": true}}))
+	assert.False(t, isRenewableToken(&api.Secret{Data: map[string]interface{}{"renewable": false}}))
+}
+
+func TestTokenRegistry(t *testing.T) {
+	service, _ := newMockService(t)
+	var found *tokenStatus
+	for _, x := range tokens.list() {
+		if x.Vault == service.vaultURL {
+			x := x
+			found = &x
+		}
+	}
+	if !assert.NotNil(t, found, "the token of the vault should have been registered") {
+		t.FailNow()
+	}
+	assert.Equal(t, "mock-accessor", found.Accessor)
+	assert.Equal(t, []string{"default"}, found.Policies)
+	assert.NotNil(t, found.Expires)
+	assert.False(t, found.Revoked)
+
+	content, err := json.Marshal(found)
+	if assert.NoError(t, err) {
+		assert.NotContains(t, string(content), mockToken, "the token should never be exposed")
+	}
+
+	local := &tokenRegistry{items: make(map[string]*tokenStatus, 0)}
+	local.update(service.vaultURL, service.client, nil)
+	assert.NoError(t, local.revoke())
+	if list := local.list(); assert.Len(t, list, 1) {
+		assert.True(t, list[0].Revoked)
+	}
+	assert.NoError(t, local.revoke(), "revoking again should be a noop")
+}
+
+func TestRevokeHandlerMethod(t *testing.T) {
+	server := httptest.NewServer(newAdminHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/token/revoke")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+	resp, err = http.Get(server.URL + "/v1/token")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/vault/api"
+)
+
+// tokenHelperName is the name the sidekick runs as a vault token helper under, i.e. a symlink given as the
+// token_helper of the vault cli, which passes only the operation
+const tokenHelperName = "vault-sidekick-token-helper"
+
+// defaultTokenFile returns the file the vault cli keeps its token in without a token helper
+func defaultTokenFile() string {
+	home, _ := os.UserHomeDir()
+
+	return filepath.Join(home, ".vault-token")
+}
+
+// runTokenHelperCommand implements the vault token helper protocol, get prints the stored token, store keeps the
+// token read from stdin and erase removes it; the token is kept in the file given by -file, or
+// VAULT_SIDEKICK_TOKEN_HELPER_FILE, which a sidekick with -token-helper-file shares its own token through
+//	args		: the arguments following the command
+//	input		: the reader the token to store is read from
+//	output		: the writer the token is printed to
+func runTokenHelperCommand(args []string, input io.Reader, output io.Writer) int {
+	flags := flag.NewFlagSet("token-helper", flag.ContinueOnError)
+	filename := flags.String("file", getEnv("VAULT_SIDEKICK_TOKEN_HELPER_FILE", defaultTokenFile()), "the file the token is kept in")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "[error] the operation must be given, i.e. vault-sidekick token-helper get|store|erase")
+		return 1
+	}
+
+	switch flags.Arg(0) {
+	case "get":
+		content, err := ioutil.ReadFile(*filename)
+		if os.IsNotExist(err) {
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[error] unable to read the token, error: %s\n", err)
+			return 1
+		}
+		defer zeroBytes(content)
+		output.Write(bytes.TrimSpace(content))
+	case "store":
+		content, err := ioutil.ReadAll(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[error] unable to read the token, error: %s\n", err)
+			return 1
+		}
+		defer zeroBytes(content)
+		if err := writeTokenFile(*filename, string(bytes.TrimSpace(content))); err != nil {
+			fmt.Fprintf(os.Stderr, "[error] %s\n", err)
+			return 1
+		}
+	case "erase":
+		if err := os.Remove(*filename); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "[error] unable to erase the token, error: %s\n", err)
+			return 1
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "[error] unsupported operation: %s, should be get, store or erase\n", flags.Arg(0))
+		return 1
+	}
+
+	return 0
+}
+
+// writeTokenFile replaces the token file, readable by the owner only, via a rename so a reader never sees
+// a partial token
+//	filename	: the token file
+//	token		: the token
+func writeTokenFile(filename, token string) error {
+	temp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return fmt.Errorf("unable to store the token, error: %s", err)
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.WriteString(token); err != nil {
+		temp.Close()
+		return fmt.Errorf("unable to store the token, error: %s", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("unable to store the token, error: %s", err)
+	}
+	if err := os.Chmod(temp.Name(), 0600); err != nil {
+		return fmt.Errorf("unable to store the token, error: %s", err)
+	}
+	if err := os.Rename(temp.Name(), filename); err != nil {
+		return fmt.Errorf("unable to store the token, error: %s", err)
+	}
+
+	return nil
+}
+
+// cliToken returns the token stored by the vault cli, via the token helper of its configuration file when
+// one is set, otherwise from ~/.vault-token; empty if the cli has no token
+func cliToken() (string, error) {
+	filename := os.Getenv("VAULT_CONFIG_PATH")
+	if filename == "" {
+		home, _ := os.UserHomeDir()
+		filename = filepath.Join(home, ".vault")
+	}
+	helper := ""
+	if content, err := ioutil.ReadFile(filename); err == nil {
+		var doc map[string]interface{}
+		if err := hcl.Decode(&doc, string(content)); err != nil {
+			return "", fmt.Errorf("unable to parse the vault cli configuration: %s, error: %s", filename, err)
+		}
+		helper = hclString(doc, "token_helper")
+	}
+
+	if helper == "" {
+		content, err := ioutil.ReadFile(defaultTokenFile())
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("unable to read the token of the vault cli, error: %s", err)
+		}
+		defer zeroBytes(content)
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(helper, "get")
+	cmd.Stderr = &stderr
+	content, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("the token helper: %s failed, error: %s, %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+	defer zeroBytes(content)
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// shareToken writes the token of the default vault to the token helper file, so the tools of the pod using
+// the sidekick as their token helper use the same token
+//	url			: the address of the vault
+//	client		: the vault client holding the token
+func shareToken(url string, client *api.Client) {
+	if options.tokenHelperFile == "" || url != options.vaultURL {
+		return
+	}
+	if err := writeTokenFile(options.tokenHelperFile, client.Token()); err != nil {
+		glog.Errorf("unable to share the token through the file: %s, error: %s", options.tokenHelperFile, err)
+	}
+}
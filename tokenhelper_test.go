@@ -0,0 +1,108 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunTokenHelperCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token-helper")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "token")
+	run := func(op, input string) (int, string) {
+		var output bytes.Buffer
+		code := runTokenHelperCommand([]string{"-file=" + filename, op}, strings.NewReader(input), &output)
+		return code, output.String()
+	}
+
+	code, output := run("get", "")
+	assert.Equal(t, 0, code)
+	assert.Empty(t, output)
+
+	code, _ = run("store", "s.helper\n")
+	assert.Equal(t, 0, code)
+	stat, err := os.Stat(filename)
+	if assert.NoError(t, err) && runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+	}
+	code, output = run("get", "")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "s.helper", output)
+
+	code, _ = run("erase", "")
+	assert.Equal(t, 0, code)
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+	code, _ = run("erase", "")
+	assert.Equal(t, 0, code)
+
+	code, _ = run("list", "")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, 1, runTokenHelperCommand(nil, nil, ioutil.Discard))
+}
+
+func TestCLIToken(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the token helper is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "cli-token")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	home, config := os.Getenv("HOME"), os.Getenv("VAULT_CONFIG_PATH")
+	defer func() {
+		os.Setenv("HOME", home)
+		os.Setenv("VAULT_CONFIG_PATH", config)
+	}()
+	os.Setenv("HOME", dir)
+	os.Setenv("VAULT_CONFIG_PATH", "")
+
+	token, err := cliToken()
+	assert.NoError(t, err)
+	assert.Empty(t, token)
+
+	// step: the token kept by the default helper of the cli
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".vault-token"), []byte("s.default\n"), 0600))
+	token, err = cliToken()
+	assert.NoError(t, err)
+	assert.Equal(t, "s.default", token)
+
+	// step: the token given by the token helper of the cli configuration
+	helper := filepath.Join(dir, "helper")
+	assert.NoError(t, ioutil.WriteFile(helper, []byte("#!/bin/sh\n[ \"$1\" = get ] && echo s.external\n"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".vault"), []byte("token_helper = \""+helper+"\"\n"), 0644))
+	token, err = cliToken()
+	assert.NoError(t, err)
+	assert.Equal(t, "s.external", token)
+
+	assert.NoError(t, ioutil.WriteFile(helper, []byte("#!/bin/sh\necho failed >&2\nexit 1\n"), 0755))
+	_, err = cliToken()
+	assert.Error(t, err)
+}
@@ -0,0 +1,363 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// the maximum number of spans to batch before exporting
+	traceBatchSize = 100
+	// the interval to export batched spans
+	traceBatchInterval = time.Duration(5) * time.Second
+	// the otlp span kinds
+	spanKindInternal = 1
+	spanKindClient   = 3
+	// the otlp status codes
+	spanStatusOK    = 1
+	spanStatusError = 2
+)
+
+// tracer is the global span exporter, nil when tracing is disabled
+var tracer *spanExporter
+
+// span is a single timed operation within a trace
+type span struct {
+	// the trace this span belongs to
+	traceID string
+	// the id of the span
+	spanID string
+	// the parent span if any
+	parentID string
+	// the name of the operation
+	name string
+	// the kind of span
+	kind int
+	// the time the operation started
+	start time.Time
+	// the attributes of the span
+	attributes map[string]string
+}
+
+// spanRecord is a finished span waiting to be exported
+type spanRecord struct {
+	span
+	// the time the operation finished
+	end time.Time
+	// the error if the operation failed
+	err error
+}
+
+// spanExporter batches finished spans and exports them via OTLP/HTTP in json encoding
+type spanExporter struct {
+	// the url of the otlp traces endpoint
+	endpoint string
+	// the service name attached to the spans
+	service string
+	// the client used to export
+	client *http.Client
+	// the channel of finished spans
+	spans chan *spanRecord
+	// a channel to request a flush of the pending spans
+	flushes chan chan struct{}
+	// the parent taken from the TRACEPARENT environment variable
+	parent *span
+	// a lock protecting the resource traces
+	sync.Mutex
+	// the trace id for each resource, so every operation on a resource is correlated
+	traces map[string]string
+}
+
+// newSpanExporter creates and starts the span exporter
+//	endpoint	: the base url of the otlp collector i.e. http://127.0.0.1:4318
+//	service		: the name of the service
+func newSpanExporter(endpoint, service string) *spanExporter {
+	exporter := &spanExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service:  service,
+		client:   &http.Client{Timeout: time.Duration(10) * time.Second},
+		spans:    make(chan *spanRecord, traceBatchSize*10),
+		flushes:  make(chan chan struct{}),
+		parent:   parseTraceParent(os.Getenv("TRACEPARENT")),
+		traces:   make(map[string]string, 0),
+	}
+	go exporter.run()
+
+	return exporter
+}
+
+// startSpan starts a span for an operation on a resource, returns nil if tracing is disabled
+//	rn			: the resource the operation relates to
+//	name		: the name of the operation
+func startSpan(rn *VaultResource, name string) *span {
+	if tracer == nil {
+		return nil
+	}
+
+	return tracer.start(rn, name, nil)
+}
+
+// child starts a span nested under this one
+func (s *span) child(rn *VaultResource, name string) *span {
+	if s == nil || tracer == nil {
+		return nil
+	}
+
+	return tracer.start(rn, name, s)
+}
+
+// setAttribute adds an attribute to the span
+func (s *span) setAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attributes[key] = fmt.Sprintf("%v", value)
+}
+
+// finish ends the span and queues it for export
+func (s *span) finish(err error) {
+	if s == nil || tracer == nil {
+		return
+	}
+	record := &spanRecord{span: *s, end: time.Now(), err: err}
+	select {
+	case tracer.spans <- record:
+	default:
+		glog.V(4).Infof("dropping the span: %s, the export queue is full", s.name)
+	}
+}
+
+// start creates a span within the trace of the resource
+func (e *spanExporter) start(rn *VaultResource, name string, parent *span) *span {
+	s := &span{
+		spanID:     newTraceID(8),
+		name:       name,
+		kind:       spanKindInternal,
+		start:      time.Now(),
+		attributes: make(map[string]string, 0),
+	}
+	switch {
+	case parent != nil:
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	case rn != nil:
+		s.traceID = e.resourceTrace(rn)
+		if e.parent != nil {
+			s.parentID = e.parent.spanID
+		}
+	case e.parent != nil:
+		s.traceID = e.parent.traceID
+		s.parentID = e.parent.spanID
+	default:
+		s.traceID = newTraceID(16)
+	}
+	if strings.HasPrefix(name, "vault.") {
+		s.kind = spanKindClient
+	}
+	if rn != nil {
+		s.attributes["resource.type"] = rn.resource
+		s.attributes["resource.path"] = rn.path
+	}
+
+	return s
+}
+
+// resourceTrace returns the trace id of a resource, when started with a TRACEPARENT all
+// resources join the trace of the parent i.e. the application startup
+func (e *spanExporter) resourceTrace(rn *VaultResource) string {
+	if e.parent != nil {
+		return e.parent.traceID
+	}
+	e.Lock()
+	defer e.Unlock()
+	id, found := e.traces[rn.ID()]
+	if !found {
+		id = newTraceID(16)
+		e.traces[rn.ID()] = id
+	}
+
+	return id
+}
+
+// run is the background routine exporting the batched spans
+func (e *spanExporter) run() {
+	var batch []*spanRecord
+	ticker := time.NewTicker(traceBatchInterval)
+	for {
+		select {
+		case record := <-e.spans:
+			batch = append(batch, record)
+			if len(batch) < traceBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case done := <-e.flushes:
+			batch = append(batch, e.drain()...)
+			if len(batch) > 0 {
+				if err := e.export(batch); err != nil {
+					glog.Errorf("failed to export %d spans to: %s, error: %s", len(batch), e.endpoint, err)
+				}
+			}
+			batch = nil
+			close(done)
+			continue
+		}
+		if err := e.export(batch); err != nil {
+			glog.Errorf("failed to export %d spans to: %s, error: %s", len(batch), e.endpoint, err)
+		}
+		batch = nil
+	}
+}
+
+// drain takes all the spans currently queued
+func (e *spanExporter) drain() []*spanRecord {
+	var list []*spanRecord
+	for {
+		select {
+		case record := <-e.spans:
+			list = append(list, record)
+		default:
+			return list
+		}
+	}
+}
+
+// flush exports any pending spans, waiting at most the timeout; used before the process exits
+func (e *spanExporter) flush(timeout time.Duration) {
+	done := make(chan struct{})
+	select {
+	case e.flushes <- done:
+	case <-time.After(timeout):
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// export sends the spans to the collector
+func (e *spanExporter) export(batch []*spanRecord) error {
+	content, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	glog.V(10).Infof("exported %d spans to: %s", len(batch), e.endpoint)
+
+	return nil
+}
+
+// encode converts the spans into the otlp json payload
+func (e *spanExporter) encode(batch []*spanRecord) map[string]interface{} {
+	var spans []map[string]interface{}
+	for _, x := range batch {
+		status := map[string]interface{}{"code": spanStatusOK}
+		if x.err != nil {
+			status = map[string]interface{}{"code": spanStatusError, "message": x.err.Error()}
+		}
+		encoded := map[string]interface{}{
+			"traceId":           x.traceID,
+			"spanId":            x.spanID,
+			"name":              x.name,
+			"kind":              x.kind,
+			"startTimeUnixNano": strconv.FormatInt(x.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(x.end.UnixNano(), 10),
+			"attributes":        encodeAttributes(x.attributes),
+			"status":            status,
+		}
+		if x.parentID != "" {
+			encoded["parentSpanId"] = x.parentID
+		}
+		spans = append(spans, encoded)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": encodeAttributes(map[string]string{
+						"service.name":    e.service,
+						"service.version": release,
+					}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": prog},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+}
+
+// encodeAttributes converts the attributes into otlp key values
+func encodeAttributes(attributes map[string]string) []interface{} {
+	var list []interface{}
+	for _, key := range sortedKeys(attributes) {
+		list = append(list, map[string]interface{}{
+			"key":   key,
+			"value": map[string]interface{}{"stringValue": attributes[key]},
+		})
+	}
+
+	return list
+}
+
+// parseTraceParent parses a w3c traceparent header i.e. 00-<trace-id>-<span-id>-<flags>
+func parseTraceParent(value string) *span {
+	items := strings.Split(strings.TrimSpace(value), "-")
+	if len(items) != 4 || len(items[1]) != 32 || len(items[2]) != 16 {
+		return nil
+	}
+
+	return &span{traceID: items[1], spanID: items[2]}
+}
+
+// newTraceID generates a random hex encoded identifier of size bytes
+func newTraceID(size int) string {
+	id := make([]byte, size)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(id)
+}
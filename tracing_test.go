@@ -0,0 +1,77 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	parent := parseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if assert.NotNil(t, parent) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", parent.traceID)
+		assert.Equal(t, "00f067aa0ba902b7", parent.spanID)
+	}
+	assert.Nil(t, parseTraceParent(""))
+	assert.Nil(t, parseTraceParent("00-bad-00f067aa0ba902b7-01"))
+}
+
+func TestSpanExporter(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/v1/traces", req.URL.Path)
+		content, _ := ioutil.ReadAll(req.Body)
+		payload := make(map[string]interface{})
+		assert.NoError(t, json.Unmarshal(content, &payload))
+		received <- payload
+	}))
+	defer server.Close()
+
+	tracer = newSpanExporter(server.URL, "test")
+	defer func() { tracer = nil }()
+
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "secret/db"
+	fetch := startSpan(rn, "vault.fetch")
+	write := startSpan(rn, "write")
+	hook := write.child(rn, "hook.exec")
+	assert.Equal(t, fetch.traceID, write.traceID, "spans of a resource should share a trace")
+	assert.Equal(t, write.spanID, hook.parentID)
+	fetch.finish(nil)
+	hook.finish(errors.New("failed"))
+	write.finish(nil)
+	tracer.flush(time.Second)
+
+	select {
+	case payload := <-received:
+		resourceSpans := payload["resourceSpans"].([]interface{})
+		scopeSpans := resourceSpans[0].(map[string]interface{})["scopeSpans"].([]interface{})
+		spans := scopeSpans[0].(map[string]interface{})["spans"].([]interface{})
+		assert.Len(t, spans, 3)
+	case <-time.After(time.Second):
+		t.Errorf("the spans were not exported")
+	}
+}
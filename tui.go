@@ -0,0 +1,346 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// tuiErrorWidth is the number of characters of the last error shown on the status screen
+	tuiErrorWidth = 60
+	// tuiClear moves the cursor home and clears the screen
+	tuiClear = "\x1b[H\x1b[2J"
+	// tuiOpen switches to the alternate screen and hides the cursor
+	tuiOpen = "\x1b[?1049h\x1b[?25l"
+	// tuiClose shows the cursor and switches back to the main screen
+	tuiClose = "\x1b[?25h\x1b[?1049l"
+)
+
+var (
+	// screen is the status screen drawn by -tui, nil otherwise
+	screen *statusScreen
+	// tuiRefreshInterval is the interval the status screen is drawn again
+	tuiRefreshInterval = time.Duration(1) * time.Second
+	// refreshTimeout bounds a renewal asked for on the status screen
+	refreshTimeout = time.Duration(30) * time.Second
+)
+
+// refreshRequest asks the service processor to renew a resource now
+type refreshRequest struct {
+	// the resource renewed
+	resource *VaultResource
+	// the outcome of the renewal
+	done chan error
+}
+
+// Refresh asks the service processor to renew the lease of the resource now, or retrieve the secret again when
+// the lease isn't renewable, waiting for the outcome
+//	rn			: the resource
+func (r VaultService) Refresh(rn *VaultResource) error {
+	request := &refreshRequest{resource: rn, done: make(chan error, 1)}
+	select {
+	case r.refreshChannel <- request:
+	case <-time.After(refreshTimeout):
+		return fmt.Errorf("timed out waiting to renew the resource: %s", rn)
+	}
+	select {
+	case err := <-request.done:
+		return err
+	case <-time.After(refreshTimeout):
+		return fmt.Errorf("timed out waiting for the renewal of the resource: %s", rn)
+	}
+}
+
+// refreshResource renews the lease of a watched resource, or retrieves the secret again; the renewal timer of the
+// resource is left running, so the next renewal happens as scheduled
+//	items		: the watched resources
+//	rn			: the resource to renew
+func (r VaultService) refreshResource(items []*watchedResource, rn *VaultResource) error {
+	var x *watchedResource
+	for _, item := range items {
+		if item.resource == rn {
+			x = item
+			break
+		}
+	}
+	if x == nil || x.lastUpdated.IsZero() {
+		return fmt.Errorf("the resource: %s has not been retrieved yet", rn)
+	}
+
+	op := r.withRequestID()
+	span := startSpan(rn, "vault.refresh")
+	span.setAttribute("request.id", op.requestID)
+	var err error
+	unchanged := false
+	if x.secret != nil && x.secret.LeaseID != "" && x.secret.Renewable && rn.renewable {
+		err = op.renew(x)
+	} else if err = op.get(x); err == errSecretUnchanged {
+		err, unchanged = nil, true
+	}
+	span.finish(err)
+	if err != nil {
+		glog.Errorf("failed to renew the resource: %s, request id: %s, error: %s", rn, op.requestID, err)
+		r.upstream(VaultEvent{Resource: rn, Type: EventTypeFailure, Error: err})
+		return err
+	}
+	// step: the secret has not changed and its data has been released, so there is nothing to write
+	if unchanged {
+		glog.Infof("the resource: %s is unchanged, request id: %s", rn, op.requestID)
+		return nil
+	}
+	glog.Infof("renewed the resource: %s on request, request id: %s", rn, op.requestID)
+	r.persist(x)
+
+	r.upstream(VaultEvent{
+		Resource: rn,
+		Secret:   x.secret.Data,
+		Metadata: newSecretMetadata(x),
+		Type:     EventTypeSuccess,
+		Retained: x.secret.Data == nil,
+	})
+	x.release()
+
+	return nil
+}
+
+// statusScreen draws a live table of the resources to a terminal, with keys to renew, pause or resume the
+// selected resource
+type statusScreen struct {
+	sync.Mutex
+	// the terminal drawn to
+	out io.Writer
+	// the id of the selected resource
+	selected string
+	// the outcome of the last action
+	message string
+	// asks the sidekick to shut down
+	quit func()
+	// restores the terminal
+	restore func()
+	// closed once the screen is closed
+	done chan struct{}
+}
+
+// newStatusScreen creates a status screen on the terminal, which is switched to reading a key at a time
+//	in			: the terminal read from
+//	out			: the terminal drawn to
+//	quit		: asks the sidekick to shut down
+func newStatusScreen(in, out *os.File, quit func()) (*statusScreen, error) {
+	restore, err := rawTerminal(in)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the keys of the terminal, error: %s", err)
+	}
+	s := &statusScreen{out: out, quit: quit, restore: restore, done: make(chan struct{})}
+	fmt.Fprint(out, tuiOpen)
+	go s.readKeys(in)
+	go func() {
+		ticker := time.NewTicker(tuiRefreshInterval)
+		defer ticker.Stop()
+		for {
+			s.draw()
+			select {
+			case <-ticker.C:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+// close restores the terminal, the screen is no longer drawn
+func (s *statusScreen) close() {
+	s.Lock()
+	defer s.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	close(s.done)
+	fmt.Fprint(s.out, tuiClose)
+	s.restore()
+}
+
+// draw draws the screen again
+func (s *statusScreen) draw() {
+	s.Lock()
+	defer s.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	fmt.Fprint(s.out, tuiClear+s.render(time.Now()))
+}
+
+// render returns the content of the screen, the selected resource marked
+//	now			: the current time
+func (s *statusScreen) render(now time.Time) string {
+	list := registry.list()
+	next := make(map[string]scheduledUpdate, 0)
+	for _, x := range schedule.list(now) {
+		next[x.ID] = x
+	}
+	if s.selected == "" && len(list) > 0 {
+		s.selected = list[0].ID
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "vault-sidekick  %s  %d resources\r\n", formatTime(now), len(list))
+	fmt.Fprint(&b, "up/down select  r renew  p pause/resume  q quit\r\n\r\n")
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprint(w, "  RESOURCE\tSTATUS\tNEXT\tLEASE TTL\tLAST ERROR\r\n")
+	for _, x := range list {
+		marker := " "
+		if x.ID == s.selected {
+			marker = ">"
+		}
+		status := x.Status
+		if x.Paused {
+			status = "paused"
+		}
+		update := "-"
+		if u, found := next[x.ID]; found && u.Action != scheduleNone && u.In != "" {
+			update = u.Action + " in " + u.In
+		} else if found {
+			update = u.Action
+		}
+		ttl := "-"
+		if x.Metadata != nil && x.Metadata.Expires != nil {
+			ttl = formatDuration(x.Metadata.Expires.Sub(now).Truncate(time.Second))
+		}
+		fmt.Fprintf(w, "%s %s\t%s\t%s\t%s\t%s\r\n", marker, x.ID, status, update, ttl, shortError(x.LastError))
+	}
+	w.Flush()
+	if s.message != "" {
+		fmt.Fprintf(&b, "\r\n%s\r\n", s.message)
+	}
+
+	return b.String()
+}
+
+// shortError returns the first line of an error, truncated to fit the screen
+func shortError(message string) string {
+	if message == "" {
+		return "-"
+	}
+	lines := strings.Split(strings.TrimSpace(message), "\n")
+	message = strings.TrimSpace(lines[len(lines)-1])
+	if len(message) > tuiErrorWidth {
+		message = message[:tuiErrorWidth-3] + "..."
+	}
+
+	return message
+}
+
+// readKeys reads the keys pressed until the terminal is closed
+//	in			: the terminal read from
+func (s *statusScreen) readKeys(in io.Reader) {
+	keys := bufio.NewReader(in)
+	for {
+		key, err := keys.ReadByte()
+		if err != nil {
+			return
+		}
+		// step: the arrow keys are an escape sequence i.e. ESC [ A
+		if key == 0x1b {
+			if next, _ := keys.ReadByte(); next != '[' {
+				continue
+			}
+			switch arrow, _ := keys.ReadByte(); arrow {
+			case 'A':
+				key = 'k'
+			case 'B':
+				key = 'j'
+			default:
+				continue
+			}
+		}
+		if !s.press(key) {
+			return
+		}
+		s.draw()
+	}
+}
+
+// press handles a key, returning false once the screen should no longer read the keys
+//	key			: the key pressed
+func (s *statusScreen) press(key byte) bool {
+	s.Lock()
+	defer s.Unlock()
+	list := registry.list()
+	index := 0
+	for i, x := range list {
+		if x.ID == s.selected {
+			index = i
+		}
+	}
+	switch key {
+	case 'k':
+		if index > 0 {
+			s.selected = list[index-1].ID
+		}
+	case 'j':
+		if index < len(list)-1 {
+			s.selected = list[index+1].ID
+		}
+	case 'p':
+		if len(list) == 0 {
+			break
+		}
+		paused := !list[index].Paused
+		registry.pause(s.selected, paused)
+		s.message = fmt.Sprintf("the resource: %s has been %s", s.selected, map[bool]string{true: "paused", false: "resumed"}[paused])
+		glog.Infof("resource: %s has been %s via the status screen", s.selected, map[bool]string{true: "paused", false: "resumed"}[paused])
+	case 'r':
+		refresh, found := registry.refresher(s.selected)
+		if !found || refresh == nil {
+			break
+		}
+		id := s.selected
+		s.message = fmt.Sprintf("renewing the resource: %s", id)
+		go func() {
+			message := fmt.Sprintf("the resource: %s has been renewed", id)
+			if err := refresh(); err != nil {
+				message = fmt.Sprintf("the resource: %s failed to renew: %s", id, shortError(err.Error()))
+			}
+			s.Lock()
+			s.message = message
+			s.Unlock()
+			s.draw()
+		}()
+	case 'q':
+		s.message = "shutting down"
+		go s.quit()
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusScreenRender(t *testing.T) {
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/tui/a"))
+	assert.NoError(t, resources.Set("secret:secret/tui/b"))
+	a, b := resources.items[0], resources.items[1]
+	registry.register(a)
+	registry.register(b)
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	registry.success(a, &secretMetadata{Expires: &expires})
+	registry.failure(b, errors.New("Error making API request.\n\nCode: 403. Errors:\n\n* permission denied"))
+	schedule.set(a, scheduleRenew, 10*time.Minute, "the update option", expires)
+
+	var out bytes.Buffer
+	s := &statusScreen{out: &out, selected: a.ID(), quit: func() {}, restore: func() {}, done: make(chan struct{})}
+	content := s.render(now)
+	var lineA, lineB string
+	for _, line := range strings.Split(content, "\r\n") {
+		switch {
+		case strings.Contains(line, a.ID()):
+			lineA = line
+		case strings.Contains(line, b.ID()):
+			lineB = line
+		}
+	}
+	assert.True(t, strings.HasPrefix(lineA, "> "))
+	assert.Contains(t, lineA, "ok")
+	assert.Contains(t, lineA, "renew in 10m")
+	assert.Contains(t, lineA, "1h0m0s")
+	assert.True(t, strings.HasPrefix(lineB, "  "))
+	assert.Contains(t, lineB, "failed")
+	assert.Contains(t, lineB, "* permission denied")
+
+	// step: the keys move the selection and pause the selected resource
+	s.selected = a.ID()
+	for s.selected != b.ID() && s.press('j') {
+	}
+	assert.Equal(t, b.ID(), s.selected)
+	assert.True(t, s.press('p'))
+	assert.True(t, registry.isPaused(b))
+	assert.Contains(t, s.render(now), "paused")
+	assert.True(t, s.press('p'))
+	assert.False(t, registry.isPaused(b))
+	assert.True(t, s.press('k'))
+	assert.NotEqual(t, b.ID(), s.selected)
+
+	// step: quitting asks the sidekick to shut down
+	quit := make(chan struct{})
+	s.quit = func() { close(quit) }
+	assert.False(t, s.press('q'))
+	select {
+	case <-quit:
+	case <-time.After(time.Second):
+		t.Error("the sidekick was not asked to shut down")
+	}
+
+	// step: a closed screen is no longer drawn
+	s.close()
+	out.Reset()
+	s.draw()
+	assert.Empty(t, out.String())
+}
+
+func TestShortError(t *testing.T) {
+	assert.Equal(t, "-", shortError(""))
+	assert.Equal(t, "* permission denied", shortError("Code: 403. Errors:\n\n* permission denied\n"))
+	assert.Len(t, shortError(strings.Repeat("x", 100)), tuiErrorWidth)
+}
+
+func TestRefreshResource(t *testing.T) {
+	service, updates := newMockService(t)
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/app"))
+	rn := resources.items[0]
+	x := &watchedResource{resource: rn}
+	assert.Error(t, service.refreshResource([]*watchedResource{x}, rn))
+
+	if !assert.NoError(t, service.get(x)) {
+		t.FailNow()
+	}
+	x.lastUpdated = time.Now()
+	assert.NoError(t, service.refreshResource([]*watchedResource{x}, rn))
+	evt := waitForEvent(t, updates)
+	assert.Equal(t, EventTypeSuccess, evt.Type)
+	assert.Equal(t, rn, evt.Resource)
+}
+
+func TestRefreshResourceUnchanged(t *testing.T) {
+	service, updates := newMockService(t)
+
+	_, err := service.client.Logical().Write("kv/data/app", map[string]interface{}{
+		"data":     map[string]interface{}{"password": "first"},
+		"metadata": map[string]interface{}{"version": 1},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = service.client.Logical().Write("kv/metadata/app", map[string]interface{}{"current_version": 1})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "kv/app"
+	rn.kvVersion = 2
+	x := &watchedResource{resource: rn}
+	if !assert.NoError(t, service.get(x)) {
+		t.FailNow()
+	}
+	x.lastUpdated = time.Now()
+	x.release()
+
+	// step: the version has not changed, so no event should be sent with the released data
+	assert.NoError(t, service.refreshResource([]*watchedResource{x}, rn))
+	select {
+	case evt := <-updates:
+		t.Errorf("unexpected event: %d for an unchanged secret", evt.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTUIOptionsValid(t *testing.T) {
+	assert.NoError(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", tui: true}))
+	assert.Error(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", tui: true, oneShot: true}))
+	assert.Error(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", tui: true, dryRun: true}))
+}
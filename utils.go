@@ -24,10 +24,12 @@ import (
 	"math/rand"
 	"os"
 	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"os/exec"
 	"path/filepath"
 
 	"github.com/golang/glog"
@@ -58,13 +60,27 @@ func hasKey(key string, data map[string]interface{}) bool {
 	return found
 }
 
-// getKeys retrieves a list of keys from the map
+// getKeys retrieves a sorted list of keys from the map, so the files rendered from the same secret are identical
 // 	data		: the map which you wish to extract the keys from
 func getKeys(data map[string]interface{}) []string {
 	var list []string
 	for key := range data {
 		list = append(list, key)
 	}
+	sort.Strings(list)
+
+	return list
+}
+
+// sortedKeys retrieves a sorted list of keys from the map
+//	data		: the map which you wish to extract the keys from
+func sortedKeys(data map[string]string) []string {
+	var list []string
+	for key := range data {
+		list = append(list, key)
+	}
+	sort.Strings(list)
+
 	return list
 }
 
@@ -130,6 +146,18 @@ func readYAMLFile(filename string) (*vaultAuthOptions, error) {
 	return o, nil
 }
 
+// hasNamedGroup checks if the regex has at least one named capture group
+//	re			: the compiled regular expression
+func hasNamedGroup(re *regexp.Regexp) bool {
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
 // getDurationWithin generate a random integer between min and max
 //	min			: the smallest number we can accept
 //	max			: the largest number we can accept
@@ -149,6 +177,28 @@ func getEnv(env, value string) string {
 	return value
 }
 
+// getEnvBool checks to see if an environment variable exists and is a boolean otherwise uses the default
+//	env			: the name of the environment variable you are checking for
+//	value		: the default value to return if the value is not there
+func getEnvBool(env string, value bool) bool {
+	if v, err := strconv.ParseBool(os.Getenv(env)); err == nil {
+		return v
+	}
+
+	return value
+}
+
+// getEnvInt checks to see if an environment variable exists and is an integer otherwise uses the default
+//	env			: the name of the environment variable you are checking for
+//	value		: the default value to return if the value is not there
+func getEnvInt(env string, value int) int {
+	if v, err := strconv.Atoi(os.Getenv(env)); err == nil {
+		return v
+	}
+
+	return value
+}
+
 // fileExists checks to see if a file exists
 //	filename		: the full path to the file you are checking for
 func fileExists(filename string) (bool, error) {
@@ -165,40 +215,36 @@ func fileExists(filename string) (bool, error) {
 // processResource is responsible for generating the specific content from the resource
 // 	rn		: a point to the vault resource
 //	data		: a map of the related secret associated to the resource
-func processResource(rn *VaultResource, data map[string]interface{}) (err error) {
+func processResource(rn *VaultResource, data map[string]interface{}, meta *secretMetadata) (err error) {
 	// step: determine the resource path
-	filename := rn.GetFilename()
-	if !strings.HasPrefix(filename, "/") {
-		filename = fmt.Sprintf("%s/%s", options.outputDir, filepath.Base(filename))
-	}
-	// step: format and write the file
-	switch rn.format {
-	case "yaml":
-		fallthrough
-	case "yml":
-		err = writeYAMLFile(filename, data, rn.fileMode)
-	case "json":
-		err = writeJSONFile(filename, data, rn.fileMode)
-	case "ini":
-		err = writeIniFile(filename, data, rn.fileMode)
-	case "csv":
-		err = writeCSVFile(filename, data, rn.fileMode)
-	case "env":
-		err = writeEnvFile(filename, data, rn.fileMode)
-	case "cert":
-		err = writeCertificateFile(filename, data, rn.fileMode)
-	case "txt":
-		err = writeTxtFile(filename, data, rn.fileMode)
-	case "bundle":
-		err = writeCertificateBundleFile(filename, data, rn.fileMode)
-	default:
-		return fmt.Errorf("unknown output format: %s", rn.format)
+	filename := resolveFilename(rn.GetFilename())
+	if rn.isWildcard() {
+		filename = wildcardDirectory(rn)
 	}
-	// step: check for an error
-	if err != nil {
+	span := startSpan(rn, "write")
+	span.setAttribute("file.name", filename)
+	span.setAttribute("file.format", rn.format)
+	defer func() { span.finish(err) }()
+
+	// step: format and write the files, a template whose inputs are unchanged is left as is, and the hooks are
+	// only run when a file has changed
+	if err = writeResource(rn, filename, data, meta); err == errTemplateUnchanged {
+		glog.V(3).Infof("the inputs of the template: %s are unchanged, skipping the render", rn)
+		return nil
+	} else if err == errResourceUnchanged {
+		glog.V(3).Infof("the files of resource: %s are unchanged, skipping the hooks", rn)
+		return nil
+	} else if err != nil {
 		return err
 	}
 
+	// step: fetch the ocsp staple of the certificate, refreshed on its own schedule thereafter
+	if rn.ocsp && !options.dryRun {
+		if err := staples.update(rn, filename, data); err != nil {
+			glog.Errorf("unable to staple the certificate of resource: %s, error: %s", rn, err)
+		}
+	}
+
 	// step: check if we need to execute a command
 	if rn.execPath != "" {
 		glog.V(10).Infof("executing the command: %s for resource: %s", rn.execPath, filename)
@@ -210,17 +256,243 @@ func processResource(rn *VaultResource, data map[string]interface{}) (err error)
 			args = []string{filename}
 		}
 
-		cmd := exec.Command(parts[0], args...)
-		cmd.Start()
-		timer := time.AfterFunc(options.execTimeout, func() {
-			if err = cmd.Process.Kill(); err != nil {
-				glog.Errorf("failed to kill the command, pid: %d, error: %s", cmd.Process.Pid, err)
+		hook := span.child(rn, "hook.exec")
+		hook.setAttribute("exec.command", parts[0])
+		context := hookContext(rn, filename, data, meta)
+		env := hookEnvironment(context, rn.hookContext)
+		rn.hookContext = context
+		err = runHookCommand(parts[0], args, env, options.execTimeout)
+		hook.finish(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// step: check if we need to reload a systemd unit
+	if rn.systemdUnit != "" {
+		hook := span.child(rn, "hook.systemd")
+		hook.setAttribute("systemd.unit", rn.systemdUnit)
+		err = reloadSystemdUnit(rn.systemdUnit, rn.systemdAction, options.execTimeout)
+		hook.finish(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// step: notify the application via the named pipe, a failure is logged rather than failing the update
+	if rn.notifyFifo != "" && !options.dryRun {
+		if err := notifyFifo(rn.notifyFifo, filename); err != nil {
+			glog.Errorf("failed to notify the named pipe: %s, error: %s", rn.notifyFifo, err)
+		}
+	}
+
+	// step: check if we need to reload a server
+	if rn.reloadServer != "" {
+		hook := span.child(rn, "hook.reload")
+		hook.setAttribute("reload.server", rn.reloadServer)
+		err = reloadServer(rn, data, options.execTimeout)
+		hook.finish(err)
+	}
+
+	return err
+}
+
+// writeResource writes the files of the resource, holding the lock on the directory if required so
+// cooperating readers never see a resource half way through an update; errResourceUnchanged is returned
+// when every file was left as it was
+//	rn			: the resource
+//	filename	: the filename of the resource, or the directory of a wildcard resource
+//	data		: the secret data
+//	meta		: the metadata of the secret
+func writeResource(rn *VaultResource, filename string, data map[string]interface{}, meta *secretMetadata) (err error) {
+	directory := filepath.Dir(filename)
+	if rn.isWildcard() {
+		directory = filename
+	}
+	// step: drop the keys of the secret not asked for
+	if len(rn.keys) > 0 {
+		selected, err := selectKeys(data, rn.keys)
+		if err != nil {
+			return err
+		}
+		data = selected
+	}
+	// step: the content is piped to stdout, there are no files to lock or keep
+	if rn.toStdout() {
+		if rn.resource == "tpl" {
+			return writeTemplateFile(filename, data, rn, meta)
+		}
+		return writeResourceFile(rn, filename, data)
+	}
+	// step: in dev mode the paths are rebased under the output directory, which starts out empty
+	if options.dev && !options.dryRun {
+		if err := os.MkdirAll(directory, 0755); err != nil {
+			return fmt.Errorf("unable to create the directory: %s, error: %s", directory, err)
+		}
+	}
+	if options.flock && !options.dryRun {
+		unlock, err := lockDirectory(directory)
+		if err != nil {
+			return fmt.Errorf("unable to lock the directory: %s, error: %s", directory, err)
+		}
+		defer unlock()
+	}
+	// step: the update is unchanged when it left every file of the resource as it was
+	if files := outputFiles(rn, filename, data); files != nil && !options.dryRun {
+		before := filesDigest(files)
+		defer func() {
+			if err == nil && filesDigest(files) == before {
+				err = errResourceUnchanged
+			}
+		}()
+	}
+
+	if rn.isWildcard() {
+		return writeWildcardFiles(rn, data)
+	}
+	// step: read the version being replaced if previous versions are kept
+	var previous map[string][]byte
+	retain := resourceBackups(rn)
+	if retain > 0 && !options.dryRun && rn.format != "envdir" && !rn.explode && !rn.fifo && !rn.memfd {
+		previous = readPrevious(resourceFiles(rn, filename, getKeys(data)))
+	}
+	if rn.resource == "tpl" {
+		err = writeTemplateFile(filename, data, rn, meta)
+	} else {
+		err = writeResourceFile(rn, filename, data)
+	}
+	if err != nil {
+		for _, content := range previous {
+			zeroBytes(content)
+		}
+		return err
+	}
+	if previous != nil {
+		if err := keepPrevious(previous, retain, rn.fileMode); err != nil {
+			glog.Errorf("unable to keep the previous version of the file: %s, error: %s", filename, err)
+		}
+	}
+
+	// step: write the metadata file if required
+	if rn.metaFile && meta != nil {
+		return writeMetadataFile(filename, meta, rn.fileMode)
+	}
+
+	return nil
+}
+
+// outputFiles returns the files the resource is written to, so a write can be checked for a change; nil when the
+// content isn't written to files i.e. it's piped to stdout or a named pipe
+//	rn			: the resource
+//	filename	: the filename of the resource, or the directory of a wildcard resource
+//	data		: the secret data
+func outputFiles(rn *VaultResource, filename string, data map[string]interface{}) []string {
+	if rn.toStdout() || rn.fifo || rn.memfd {
+		return nil
+	}
+	switch {
+	case rn.isWildcard():
+		var files []string
+		for name := range rn.wildcardFiles {
+			files = append(files, resourceFiles(rn, name, nil)...)
+		}
+		for _, key := range getKeys(data) {
+			if child, found := data[key].(map[string]interface{}); found {
+				files = append(files, resourceFiles(rn, resolveFilename(rn.wildcardFilename(key)), getKeys(child))...)
+			}
+		}
+		return files
+	case rn.explode || rn.format == "envdir":
+		// step: the keys removed are found on disk, the keys added in the secret
+		files, _ := filepath.Glob(filepath.Join(filename, "*"))
+		for _, key := range getKeys(data) {
+			if rn.format == "envdir" {
+				key = strings.ToUpper(key)
 			}
-		})
-		// step: wait for the command to finish
-		err = cmd.Wait()
-		timer.Stop()
+			files = append(files, filepath.Join(filename, key))
+		}
+		return files
+	}
+
+	return resourceFiles(rn, filename, getKeys(data))
+}
+
+// selectKeys returns the keys of the secret asked for, failing if any is missing
+//	data		: the secret data
+//	keys		: the keys to keep
+func selectKeys(data map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	selected := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		value, found := data[key]
+		if !found {
+			return nil, fmt.Errorf("the secret has no key: %s", key)
+		}
+		selected[key] = value
+	}
+
+	return selected, nil
+}
+
+// writeResourceFile formats and writes the secret to the file
+//	rn			: the resource
+//	filename	: the filename to write to
+//	data		: the secret data
+func writeResourceFile(rn *VaultResource, filename string, data map[string]interface{}) (err error) {
+	if rn.explode {
+		return writeExplodedFiles(filename, data, rn)
+	}
+	if rn.merge != "" {
+		return writeMergeFile(filename, data, rn)
+	}
+	switch rn.format {
+	case "yaml":
+		fallthrough
+	case "yml":
+		err = writeYAMLFile(filename, data, rn)
+	case "json":
+		err = writeJSONFile(filename, data, rn)
+	case "ini":
+		err = writeIniFile(filename, data, rn)
+	case "csv":
+		err = writeCSVFile(filename, data, rn)
+	case "env":
+		err = writeEnvFile(filename, data, rn)
+	case "envdir":
+		err = writeEnvdirFiles(filename, data, rn)
+	case "cert":
+		err = writeCertificateFile(filename, data, rn)
+	case "txt":
+		err = writeTxtFile(filename, data, rn)
+	case "bundle":
+		err = writeCertificateBundleFile(filename, data, rn)
+	case "patch":
+		err = writePatchFile(filename, data, rn.patchRegex)
+	case "binary":
+		err = writeBinaryFile(filename, data, rn)
+	case "pgpass":
+		err = writePgpassFile(filename, data, rn)
+	case "mycnf":
+		err = writeMyCnfFile(filename, data, rn)
+	case "p12", "jks":
+		err = writeKeystoreFile(filename, data, rn)
+	case "spiffe":
+		err = writeSPIFFEFiles(filename, data, rn)
+	default:
+		return fmt.Errorf("unknown output format: %s", rn.format)
 	}
 
 	return err
 }
+
+// resolveFilename places a relative filename in the output directory
+//	filename	: the filename of the resource
+func resolveFilename(filename string) string {
+	if !strings.HasPrefix(filename, "/") {
+		filename = fmt.Sprintf("%s/%s", options.outputDir, filepath.Base(filename))
+	} else if options.dev {
+		filename = devFilename(filename)
+	}
+
+	return filename
+}
+
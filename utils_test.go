@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"testing"
 )
 
@@ -113,3 +114,19 @@ func TestReadConfigTokenYAML(t *testing.T) {
 		t.Errorf("Expected token %s got %s", expected, o.Token)
 	}
 }
+
+func TestGetEnvBool(t *testing.T) {
+	os.Setenv("VAULT_SIDEKICK_TEST_BOOL", "true")
+	defer os.Unsetenv("VAULT_SIDEKICK_TEST_BOOL")
+	if !getEnvBool("VAULT_SIDEKICK_TEST_BOOL", false) {
+		t.Errorf("expected the environment variable to be true")
+	}
+
+	os.Setenv("VAULT_SIDEKICK_TEST_BOOL", "nope")
+	if !getEnvBool("VAULT_SIDEKICK_TEST_BOOL", true) {
+		t.Errorf("expected an invalid boolean to use the default")
+	}
+	if getEnvBool("VAULT_SIDEKICK_TEST_UNSET", false) {
+		t.Errorf("expected an unset environment variable to use the default")
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// filePlaceholder is replaced in the validate command by the file being validated
+const filePlaceholder = "{file}"
+
+// writeValidatedFile writes the content to a temporary file alongside the file and runs the validate command
+// against it, the temporary file is only renamed over the file if the command succeeds, so a failure leaves the
+// previous version in place
+//	command		: the validate command, the file replacing {file}, or appended if absent
+//	filename	: the file to write
+//	content		: the content to write
+//	mode		: the file permissions
+func writeValidatedFile(command, filename string, content []byte, mode os.FileMode) error {
+	// step: the file already holds the content, which was validated when written
+	if isUnchangedFile(filename, content) {
+		glog.V(4).Infof("the file: %s is unchanged, skipping the validation", filename)
+		return nil
+	}
+	// step: the temporary file keeps the name, as validators can be particular about the extension
+	tmpfile := filepath.Join(filepath.Dir(filename), ".vault-sidekick."+filepath.Base(filename))
+	if err := writeFile(tmpfile, content, mode); err != nil {
+		return err
+	}
+	if err := validateFile(command, tmpfile, options.execTimeout); err != nil {
+		os.Remove(tmpfile)
+		return fmt.Errorf("the file: %s failed validation, keeping the previous version, %s", filename, err)
+	}
+
+	return os.Rename(tmpfile, filename)
+}
+
+// validateFile runs the validate command against the file
+//	command		: the validate command
+//	filename	: the file to validate
+//	timeout		: the time allowed for the command
+func validateFile(command, filename string, timeout time.Duration) error {
+	parts := strings.Fields(command)
+	if len(parts) == 0 {
+		return fmt.Errorf("the validate command is empty")
+	}
+	args := parts[1:]
+	found := false
+	for i, x := range args {
+		if strings.Contains(x, filePlaceholder) {
+			args[i] = strings.Replace(x, filePlaceholder, filename, -1)
+			found = true
+		}
+	}
+	if !found {
+		args = append(args, filename)
+	}
+	glog.V(3).Infof("validating the file: %s with the command: %s", filename, parts[0])
+
+	var output bytes.Buffer
+	cmd := exec.Command(parts[0], args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to run the validate command: %s, error: %s", parts[0], err)
+	}
+	timer := time.AfterFunc(timeout, func() {
+		cmd.Process.Kill()
+	})
+	defer timer.Stop()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("error: %s, output: %s", err, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteValidatedFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the validate command is a shell")
+	}
+	dir, err := ioutil.TempDir("", "validate")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.conf")
+	rn := defaultVaultResource()
+	rn.format = "txt"
+	rn.validate = "grep -q listen {file}"
+
+	// step: a valid file is written
+	assert.NoError(t, writeResourceContent(rn, filename, []byte("listen 443\n")))
+	content, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "listen 443\n", string(content))
+
+	// step: an invalid file is rejected, keeping the previous version
+	err = writeResourceContent(rn, filename, []byte("garbage\n"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "failed validation")
+	}
+	content, err = ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "listen 443\n", string(content))
+
+	// step: the temporary file is removed
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+}
+
+func TestValidateFileArguments(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the validate command is a shell")
+	}
+	dir, err := ioutil.TempDir("", "validate")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.conf")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("listen 443\n"), 0644))
+
+	// step: the file is appended when there's no placeholder
+	assert.NoError(t, validateFile("test -s", filename, options.execTimeout))
+	assert.Error(t, validateFile("test -d", filename, options.execTimeout))
+	assert.Error(t, validateFile("/does/not/exist", filename, options.execTimeout))
+	assert.Error(t, validateFile(" ", filename, options.execTimeout))
+}
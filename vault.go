@@ -23,10 +23,13 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"strings"
 
+	"github.com/UKHomeOffice/vault-sidekick/renewer"
 	"github.com/golang/glog"
 	"github.com/hashicorp/vault/api"
 )
@@ -48,9 +51,31 @@ type VaultService struct {
 	// the token to authenticate with
 	token string
 	// the listener channel - technically we only have the one listener but there a long term reasons for adding this
-	listeners []chan VaultEvent
+	listeners *eventListeners
+	// the renewer of the leases, created before the service processor is started
+	renewer *renewer.Renewer
 	// a channel to inform of a new resource to processor
 	resourceChannel chan *watchedResource
+	// a channel of the rotations of static roles requested via the admin api
+	rotateChannel chan *rotateRequest
+	// a channel of the renewals asked for on the status screen
+	refreshChannel chan *refreshRequest
+	// a channel of the resources no longer to be watched
+	removeChannel chan *VaultResource
+	// a channel signalled when a revoked token has been replaced by a login
+	reloginChannel chan struct{}
+	// a channel signalled to stop the service processor
+	stopChannel chan struct{}
+	// the persisted lease state, nil if disabled
+	state *leaseStore
+	// the cache of static reads, nil if disabled
+	cache *responseCache
+	// a client to a performance standby or read replica for static reads, nil if disabled
+	readClient *api.Client
+	// the latencies of the latest reads, from which the hedge delay is taken
+	latency *latencyWindow
+	// the id of the operation the requests are made for, sent as the X-Request-ID header
+	requestID string
 }
 
 // VaultEvent is the definition which captures a change
@@ -59,8 +84,21 @@ type VaultEvent struct {
 	Resource *VaultResource
 	// the secret associated
 	Secret map[string]interface{}
+	// the metadata of the secret i.e. lease and expiry
+	Metadata *secretMetadata
 	// type of this event (success or failure)
 	Type EventType
+	// the error of a failure
+	Error error
+	// the secret isn't held i.e. a lease resumed from the state file, the files already written are kept
+	Retained bool
+}
+
+// eventListeners are the channels the events are sent to, listeners being added while the service processor runs
+type eventListeners struct {
+	sync.RWMutex
+	// the channels of the listeners
+	items []chan VaultEvent
 }
 
 type EventType int
@@ -72,24 +110,48 @@ const (
 
 // NewVaultService creates a new implementation to speak to vault and retrieve the resources
 //	url			: the url of the vault service
-func NewVaultService(url string) (*VaultService, error) {
+//	auth		: the authentication options for the vault service
+//	state		: the persisted lease state, nil if disabled
+func NewVaultService(url string, auth *vaultAuthOptions, state *leaseStore) (*VaultService, error) {
 	var err error
 
 	// step: create the config for client
 	service := new(VaultService)
 	service.vaultURL = url
-	service.listeners = make([]chan VaultEvent, 0)
+	service.listeners = &eventListeners{items: make([]chan VaultEvent, 0)}
 
 	// step: create the service processor channels
 	service.resourceChannel = make(chan *watchedResource, 20)
+	service.rotateChannel = make(chan *rotateRequest, 10)
+	service.refreshChannel = make(chan *refreshRequest, 10)
+	service.removeChannel = make(chan *VaultResource, 10)
+	service.reloginChannel = make(chan struct{}, 1)
+	service.stopChannel = make(chan struct{})
+
+	service.state = state
+	service.cache = newResponseCache(options.cacheTTL)
+	service.latency = newLatencyWindow()
 
 	// step: retrieve a vault client
-	service.client, err = newVaultClient(&options)
+	service.client, err = newVaultClient(&options, url, auth, service.reloginChannel)
 	if err != nil {
 		return nil, err
 	}
 
+	// step: create a client for static reads from a performance standby if required
+	readURL := auth.VaultReadURL
+	if readURL == "" && auth == options.vaultAuthOptions {
+		readURL = options.vaultReadURL
+	}
+	if readURL != "" {
+		glog.Infof("static secrets of the vault: %s are read from: %s", url, readURL)
+		if service.readClient, err = newAPIClient(&options, readURL); err != nil {
+			return nil, err
+		}
+	}
+
 	// step: start the service processor off
+	service.renewer = service.NewRenewer()
 	service.vaultServiceProcessor()
 
 	return service, nil
@@ -98,15 +160,34 @@ func NewVaultService(url string) (*VaultService, error) {
 // AddListener ... add a listener to the events listeners
 func (r *VaultService) AddListener(ch chan VaultEvent) {
 	glog.V(10).Infof("adding the listener: %v", ch)
-	r.listeners = append(r.listeners, ch)
+	r.listeners.Lock()
+	defer r.listeners.Unlock()
+	r.listeners.items = append(r.listeners.items, ch)
 }
 
 // Watch adds a watch on a resource and inform, renew which required and inform us when
 // the resource is ready
 func (r VaultService) Watch(rn *VaultResource) {
+	registry.update(rn, func(x *resourceStatus) {
+		x.refresh = func() error { return r.Refresh(rn) }
+		if rn.rotate {
+			x.rotate = func() error { return r.Rotate(rn) }
+		}
+	})
 	r.resourceChannel <- &watchedResource{resource: rn}
 }
 
+// Stop stops the service processor, returning once it has stopped; the timers set for the resources are left
+// to fire and ignored
+func (r *VaultService) Stop() {
+	r.stopChannel <- struct{}{}
+}
+
+// Unwatch stops watching a resource, its files are left as they are and any lease left to expire
+func (r VaultService) Unwatch(rn *VaultResource) {
+	r.removeChannel <- rn
+}
+
 // vaultServiceProcessor is the background routine responsible for retrieving the resources, renewing when required and
 // informing those who are watching the resource that something has changed
 func (r *VaultService) vaultServiceProcessor() {
@@ -120,6 +201,119 @@ func (r *VaultService) vaultServiceProcessor() {
 		revokeChannel := make(chan *watchedResource, 10)
 		statsChannel := time.NewTicker(options.statsInterval)
 
+		// the channel of the retrievals made in the background
+		fetchedChannel := make(chan *fetchResult, 10)
+
+		// renewer renews the leases of the resources, rescheduling them on the outcome
+		//  - once renewed, we setup a timer for the next renewal and send a notification upstream
+		//  - if the lease has expired or can't be renewed, we read a new secret, before the lease expires if we can
+		//  - if we encounter an error, we reschedule the attempt for the future
+		r.renewer.OnRenew = func(lease renewer.Lease) {
+			x := lease.(*watchedResource)
+			glog.V(4).Infof("successfully renewed resource: %s, leaseID: %s", x.resource, x.secret.LeaseID)
+			x.resource.retries = 0
+			r.persist(x)
+			x.notifyOnRenewal(renewChannel)
+			r.upstream(VaultEvent{
+				Resource: x.resource,
+				Secret:   x.secret.Data,
+				Metadata: newSecretMetadata(x),
+				Type:     EventTypeSuccess,
+				Retained: x.secret.Data == nil,
+			})
+			x.release()
+		}
+		r.renewer.OnExpire = func(lease renewer.Lease, expired bool) {
+			x := lease.(*watchedResource)
+			if expired {
+				glog.V(3).Infof("the lease on resource: %s has expired or is not renewable, we need to get a new lease", x.resource)
+				r.scheduleNow(x, retrieveChannel)
+				return
+			}
+			glog.Warningf("the lease of resource: %s can't be renewed, reading a new secret before it expires at: %s", x.resource, x.leaseExpireTime)
+			x.secret.Renewable = false
+			limit := x.beforeExpiry()
+			r.scheduleIn(x, retrieveChannel, limit)
+			schedule.set(x.resource, scheduleFetch, limit, "the lease can't be renewed any further and must be read again before it expires", x.leaseExpireTime)
+		}
+		r.renewer.OnError = func(lease renewer.Lease, err error) {
+			x := lease.(*watchedResource)
+			retry := getDurationWithin(3, 10)
+			r.scheduleIn(x, renewChannel, retry)
+			x.resource.retries++
+			schedule.set(x.resource, scheduleRenew, retry, fmt.Sprintf("retrying after %d failures", x.resource.retries), x.leaseExpireTime)
+			r.upstream(VaultEvent{
+				Resource: x.resource,
+				Type:     EventTypeFailure,
+				Error:    err,
+			})
+		}
+
+		// fetched handles the outcome of the retrieval of a resource
+		//  - if we error attempting to retrieve the secret, we background and reschedule an attempt to add it
+		//  - if ok, we grab the lease it and lease time, we setup a notification on renewal
+		fetched := func(f *fetchResult) {
+			x := f.resource
+			if x.dropped {
+				return
+			}
+			if f.err != nil {
+				glog.Errorf("failed to retrieve the resource: %s from vault, request id: %s, error: %s", x.resource, f.requestID, f.err)
+				// step: the later startup waves aren't held back by a resource which may never be retrieved
+				startup.abandon(x.resource, "its first retrieval has failed")
+				// reschedule the attempt for later
+				retry := getDurationWithin(3, 10)
+				r.scheduleIn(x, retrieveChannel, retry)
+				x.resource.retries++
+				schedule.set(x.resource, scheduleFetch, retry, fmt.Sprintf("retrying after %d failures", x.resource.retries), x.leaseExpireTime)
+				r.upstream(VaultEvent{
+					Resource: x.resource,
+					Type:     EventTypeFailure,
+					Error:    f.err,
+				})
+				return
+			}
+
+			// step: the secret is unchanged, there is nothing to write so just wait for the next update
+			if f.unchanged {
+				x.resource.retries = 0
+				x.notifyOnRenewal(renewChannel)
+				return
+			}
+
+			glog.V(4).Infof("successfully retrieved resource: %s, leaseID: %s", x.resource, x.secret.LeaseID)
+			x.resource.retries = 0
+			startup.complete(x.resource)
+			r.supersede(x)
+			r.persist(x)
+
+			// step: if we had a previous lease and the option is to revoke, lets throw into the revoke channel
+			if f.leaseID != "" && f.leaseID != x.secret.LeaseID && x.resource.revoked {
+				// step: make a rough copy, holding the previous lease rather than the one just issued
+				copy := &watchedResource{
+					resource: x.resource,
+					secret: &api.Secret{
+						LeaseID: f.leaseID,
+					},
+				}
+
+				r.scheduleIn(copy, revokeChannel, x.resource.revokeDelay)
+			}
+
+			// step: setup a timer for renewal
+			x.notifyOnRenewal(renewChannel)
+			r.closeActiveHours(x, retrieveChannel)
+
+			// step: update the upstream consumers
+			r.upstream(VaultEvent{
+				Resource: x.resource,
+				Secret:   x.secret.Data,
+				Metadata: newSecretMetadata(x),
+				Type:     EventTypeSuccess,
+			})
+			x.release()
+		}
+
 		for {
 			select {
 			// A new resource is being added to the service processor;
@@ -128,6 +322,20 @@ func (r *VaultService) vaultServiceProcessor() {
 				glog.V(4).Infof("adding a resource into the service processor, resource: %s", x.resource)
 				// step: add to the list of resources
 				items = append(items, x)
+				// step: resume a persisted lease if still valid
+				if r.resume(x) {
+					startup.complete(x.resource)
+					x.notifyOnRenewal(renewChannel)
+					r.closeActiveHours(x, retrieveChannel)
+					r.upstream(VaultEvent{
+						Resource: x.resource,
+						Metadata: newSecretMetadata(x),
+						Type:     EventTypeSuccess,
+						Retained: true,
+					})
+					x.release()
+					break
+				}
 				// step: push into the retrieval channel
 				r.scheduleNow(x, retrieveChannel)
 
@@ -136,9 +344,25 @@ func (r *VaultService) vaultServiceProcessor() {
 			//  - if we error attempting to retrieve the secret, we background and reschedule an attempt to add it
 			//  - if ok, we grab the lease it and lease time, we setup a notification on renewal
 			case x := <-retrieveChannel:
+				if x.dropped {
+					break
+				}
 				// step: skip this resource if it's reached maxRetries
 				if x.resource.maxRetries > 0 && x.resource.retries > x.resource.maxRetries {
 					glog.V(4).Infof("skipping resource %s as it's failed %d/%d times", x.resource.retries, x.resource.maxRetries+1)
+					startup.abandon(x.resource, "it has exhausted its retries")
+					break
+				}
+				// step: defer the retrieval while the resource is paused
+				if registry.isPaused(x.resource) {
+					glog.V(3).Infof("resource: %s is paused, deferring the retrieval", x.resource)
+					startup.abandon(x.resource, "it is paused")
+					r.scheduleIn(x, retrieveChannel, pausedInterval)
+					schedule.set(x.resource, scheduleFetch, pausedInterval, "the resource is paused", x.leaseExpireTime)
+					break
+				}
+				// step: the credentials are only issued within the active hours of the resource
+				if r.outsideActiveHours(x, retrieveChannel) {
 					break
 				}
 
@@ -149,123 +373,115 @@ func (r *VaultService) vaultServiceProcessor() {
 					glog.V(10).Infof("resource: %s has a previous lease: %s", x.resource, leaseID)
 				}
 
-				err := r.get(x)
-				if err != nil {
-					glog.Errorf("failed to retrieve the resource: %s from vault, error: %s", x.resource, err)
-					// reschedule the attempt for later
-					r.scheduleIn(x, retrieveChannel, getDurationWithin(3, 10))
-					x.resource.retries++
-					r.upstream(VaultEvent{
-						Resource: x.resource,
-						Type:     EventTypeFailure,
-					})
+				// step: the first retrieval is made in the background, so the resources are retrieved concurrently
+				// at startup, each wave once the earlier waves have been retrieved; the retrieval is made on a copy,
+				// the resource is only changed here once the retrieval is back
+				if x.lastUpdated.IsZero() {
+					staged := *x
+					go func(x, staged *watchedResource, leaseID string) {
+						startup.acquire(x.resource)
+						result := r.fetch(staged, leaseID)
+						startup.release()
+						result.resource, result.staged = x, staged
+						fetchedChannel <- result
+					}(x, &staged, leaseID)
 					break
 				}
+				fetched(r.fetch(x, leaseID))
 
-				glog.V(4).Infof("successfully retrieved resource: %s, leaseID: %s", x.resource, x.secret.LeaseID)
-				x.resource.retries = 0
-
-				// step: if we had a previous lease and the option is to revoke, lets throw into the revoke channel
-				if leaseID != "" && x.resource.revoked {
-					// step: make a rough copy
-					copy := &watchedResource{
-						secret: &api.Secret{
-							LeaseID: x.secret.LeaseID,
-						},
-					}
-
-					r.scheduleIn(copy, revokeChannel, x.resource.revokeDelay)
-				}
-
-				// step: setup a timer for renewal
-				x.notifyOnRenewal(renewChannel)
-
-				// step: update the upstream consumers
-				r.upstream(VaultEvent{
-					Resource: x.resource,
-					Secret:   x.secret.Data,
-					Type:     EventTypeSuccess,
-				})
+			// A retrieval made in the background has finished
+			//  - the resource takes on the outcome of the retrieval, which is ignored if it has been dropped since
+			case f := <-fetchedChannel:
+				f.resource.adopt(f.staged)
+				fetched(f)
 
 			// A watched resource is coming up for renewal
 			// 	- we attempt to renew the resource from vault
 			//	- if we encounter an error, we reschedule the attempt for the future
 			//	- if we're ok, we update the watchedResource and we send a notification of the change upstream
 			case x := <-renewChannel:
+				if x.dropped {
+					break
+				}
 				// step: skip this resource if it's reached maxRetries
 				if x.resource.maxRetries > 0 && x.resource.retries > x.resource.maxRetries {
 					glog.V(4).Infof("skipping resource %s as it's failed %d/%d times", x.resource.retries, x.resource.maxRetries+1)
 					break
 				}
-
-				glog.V(4).Infof("resource: %s, lease: %s up for renewal, renewable: %t, revoked: %t", x.resource,
-					x.secret.LeaseID, x.resource.renewable, x.resource.revoked)
-
-				// step: we need to check if the lease has expired?
-				if time.Now().Before(x.leaseExpireTime) {
-					glog.V(3).Infof("the lease on resource: %s has expired, we need to get a new lease", x.resource)
-					// push into the retrieval channel and break
-					r.scheduleNow(x, retrieveChannel)
+				// step: defer the renewal while the resource is paused
+				if registry.isPaused(x.resource) {
+					glog.V(3).Infof("resource: %s is paused, deferring the renewal", x.resource)
+					r.scheduleIn(x, renewChannel, pausedInterval)
+					schedule.set(x.resource, x.nextAction(), pausedInterval, "the resource is paused", x.leaseExpireTime)
 					break
 				}
-
-				// step: are we renewing the resource?
-				if x.resource.renewable {
-					// step: is the underlining resource even renewable? - otherwise we can just grab a new lease
-					if !x.secret.Renewable {
-						glog.V(10).Infof("the resource: %s is not renewable, retrieving a new lease instead", x.resource)
-						r.scheduleNow(x, retrieveChannel)
-						break
-					}
-
-					// step: lets renew the resource
-					err := r.renew(x)
-					if err != nil {
-						glog.Errorf("failed to renew the resource: %s for renewal, error: %s", x.resource, err)
-						// reschedule the attempt for later
-						r.scheduleIn(x, renewChannel, getDurationWithin(3, 10))
-						x.resource.retries++
-						r.upstream(VaultEvent{
-							Resource: x.resource,
-							Type:     EventTypeFailure,
-						})
-						break
-					}
-
-					glog.V(4).Infof("successfully renewed resource: %s, leaseID: %s", x.resource, x.secret.LeaseID)
-					x.resource.retries = 0
+				// step: the lease is revoked rather than renewed outside the active hours of the resource
+				if r.outsideActiveHours(x, retrieveChannel) {
+					break
 				}
 
+				glog.V(4).Infof("resource: %s, lease: %s up for renewal, renewable: %t, revoked: %t", x.resource,
+					x.secret.LeaseID, x.resource.renewable, x.resource.revoked)
+
 				// step: the option for this resource is not to renew the secret but regenerate a new secret
 				if !x.resource.renewable {
 					glog.V(4).Infof("resource: %s flagged as not renewable, shifting to regenerating the resource", x.resource)
 					r.scheduleNow(x, retrieveChannel)
 					break
 				}
-
-				// step: setup a timer for renewal
-				x.notifyOnRenewal(renewChannel)
-
-				// step: update any listener upstream
-				r.upstream(VaultEvent{
-					Resource: x.resource,
-					Secret:   x.secret.Data,
-					Type:     EventTypeSuccess,
-				})
+				r.renewer.Renew(x)
 
 			// We receive a lease ID along on the channel, just revoke the lease when you can
 			case x := <-revokeChannel:
-				err := r.revoke(x.secret.LeaseID)
+				span := startSpan(x.resource, "vault.revoke")
+				span.setAttribute("lease.id", x.secret.LeaseID)
+				op := r.withRequestID()
+				span.setAttribute("request.id", op.requestID)
+				err := op.revokeIn(x.resource, x.secret.LeaseID)
+				span.finish(err)
 				if err != nil {
-					glog.Errorf("failed to revoke the lease: %s, error: %s", x.secret.LeaseID, err)
+					glog.Errorf("failed to revoke the lease: %s, request id: %s, error: %s", x.secret.LeaseID, op.requestID, err)
+				}
+
+			// A rotation of the static role of a resource has been requested
+			//  - we rotate the role and read the new credentials, the renewal timer is left as is
+			case x := <-r.rotateChannel:
+				x.done <- r.rotateStatic(items, x.resource)
+
+			// A renewal of a resource has been asked for
+			//  - we renew the lease, or retrieve the secret again, the renewal timer is left as is
+			case x := <-r.refreshChannel:
+				x.done <- r.refreshResource(items, x.resource)
+
+			// A resource is no longer to be watched
+			//  - the resource is dropped, the timers set for it are left to fire and ignored
+			case rn := <-r.removeChannel:
+				startup.abandon(rn, "it is no longer watched")
+				for i, x := range items {
+					if x.resource == rn {
+						x.dropped = true
+						items = append(items[:i], items[i+1:]...)
+						break
+					}
 				}
 
+			// The token has been revoked and replaced by a login
+			//  - the leases issued with the token were revoked along with it, so the dynamic secrets are retrieved again
+			case <-r.reloginChannel:
+				r.reacquire(items)
+
+			// The service is stopping
+			//  - the processor exits, the resources are no longer renewed
+			case <-r.stopChannel:
+				statsChannel.Stop()
+				return
+
 			// The statistics timer has gone off; we iterate the watched items and
 			case <-statsChannel.C:
 				glog.V(3).Infof("stats: %d resources being watched", len(items))
 				for _, item := range items {
-					glog.V(3).Infof("resourse: %s, lease id: %s, renewal in: %s seconds, expiration: %s",
-						item.resource, item.secret.LeaseID, item.renewalTime, item.leaseExpireTime)
+					glog.V(3).Infof("resourse: %s, lease id: %s, renewal in: %s, expiration: %s, renewals: %d",
+						item.resource, item.secret.LeaseID, formatDuration(item.renewalTime), formatTime(item.leaseExpireTime), item.renewals)
 				}
 			}
 		}
@@ -295,11 +511,43 @@ func (r VaultService) scheduleIn(rn *watchedResource, ch chan *watchedResource,
 	}(rn)
 }
 
+// resume attempts to pick up a persisted lease for the resource rather than issuing a new one
+//	rn			: the watched resource
+func (r VaultService) resume(rn *watchedResource) bool {
+	if r.state == nil {
+		return false
+	}
+	lease, found := r.state.get(rn.resource.ID())
+	if !found || !lease.isResumable(rn.resource) {
+		return false
+	}
+	lease.restore(rn)
+	glog.Infof("resuming the lease: %s on resource: %s, expiration: %s", lease.LeaseID, rn.resource, formatTime(lease.ExpireTime))
+
+	return true
+}
+
+// persist records the current lease of the resource in the state file
+//	rn			: the watched resource
+func (r VaultService) persist(rn *watchedResource) {
+	if r.state == nil {
+		return
+	}
+	if err := r.state.update(rn); err != nil {
+		glog.Errorf("failed to persist the lease of resource: %s, error: %s", rn.resource, err)
+	}
+}
+
 // upstream ... the resource has changed thus we notify the upstream listener
 //	item		: the item which has changed
 func (r VaultService) upstream(item VaultEvent) {
 	// step: chunk this into a go-routine not to block us
-	for _, listener := range r.listeners {
+	if r.listeners == nil {
+		return
+	}
+	r.listeners.RLock()
+	defer r.listeners.RUnlock()
+	for _, listener := range r.listeners.items {
 		go func(ch chan VaultEvent) {
 			ch <- item
 		}(listener)
@@ -315,6 +563,17 @@ func (r VaultService) renew(rn *watchedResource) error {
 		return fmt.Errorf("the resource: %s is not renewable", rn.resource)
 	}
 
+	// step: the lease was issued in the namespace of the resource
+	var err error
+	if rn.resource.namespace != "" {
+		if r, err = r.withNamespace(rn.resource.namespace); err != nil {
+			return err
+		}
+	}
+	if r, err = r.tagRequests(rn.resource); err != nil {
+		return err
+	}
+
 	secret, err := r.client.Sys().Renew(rn.secret.LeaseID, 0)
 	if err != nil {
 		return err
@@ -322,10 +581,13 @@ func (r VaultService) renew(rn *watchedResource) error {
 
 	// step: update the resource
 	rn.lastUpdated = time.Now()
-	rn.leaseExpireTime = rn.lastUpdated.Add(time.Duration(secret.LeaseDuration))
+	rn.renewals++
+	rn.secret.LeaseDuration = secret.LeaseDuration
+	rn.leaseExpireTime = rn.lastUpdated.Add(time.Duration(secret.LeaseDuration) * time.Second)
 
-	glog.V(3).Infof("renewed resource: %s, leaseId: %s, lease_time: %s, expiration: %s",
-		rn.resource, rn.secret.LeaseID, rn.secret.LeaseID, rn.leaseExpireTime)
+	glog.V(3).Infof("renewed resource: %s, leaseId: %s, lease_time: %s, expiration: %s, renewals: %d",
+		rn.resource, rn.secret.LeaseID, formatDuration(time.Duration(secret.LeaseDuration)*time.Second),
+		formatTime(rn.leaseExpireTime), rn.renewals)
 
 	return nil
 }
@@ -344,6 +606,23 @@ func (r VaultService) revoke(lease string) error {
 	return nil
 }
 
+// revokeIn revokes the lease of a resource in the namespace it was issued in
+//	rn			: the resource
+//	lease		: the lease id
+func (r VaultService) revokeIn(rn *VaultResource, lease string) error {
+	var err error
+	if rn.namespace != "" {
+		if r, err = r.withNamespace(rn.namespace); err != nil {
+			return err
+		}
+	}
+	if r, err = r.tagRequests(rn); err != nil {
+		return err
+	}
+
+	return r.revoke(lease)
+}
+
 // get retrieves a secret from the vault
 //	rn			: the watched resource
 func (r VaultService) get(rn *watchedResource) error {
@@ -357,15 +636,44 @@ func (r VaultService) get(rn *watchedResource) error {
 	}
 	glog.V(10).Infof("resource: %s, path: %s, params: %v", rn.resource.resource, rn.resource.path, params)
 
-	glog.V(5).Infof("attempting to retrieve the resource: %s from vault", rn.resource)
+	// step: vault encrypts the values with the public key, so only the holder of the private key can read them
+	if rn.resource.pgpKey != "" {
+		key, err := loadPGPKey(rn.resource.pgpKey)
+		if err != nil {
+			return err
+		}
+		params[pgpKeyParam] = key
+	}
+
+	// step: bound the requests of the resource, so a slow backend can't hold up the other resources
+	timeout := resourceTimeout(rn.resource)
+	if timeout > 0 {
+		if r, err = r.withTimeout(timeout); err != nil {
+			return err
+		}
+	}
+	// step: read from the namespace of the resource rather than the global one
+	if rn.resource.namespace != "" {
+		if r, err = r.withNamespace(rn.resource.namespace); err != nil {
+			return err
+		}
+	}
+	if r, err = r.tagRequests(rn.resource); err != nil {
+		return err
+	}
+	started := time.Now()
+	rn.fetching = sourceVault
+
+	glog.V(5).Infof("attempting to retrieve the resource: %s from vault, request id: %s", rn.resource, r.requestID)
 	// step: perform a request to vault
 	switch rn.resource.resource {
 	case "raw":
-		request := r.client.NewRequest("GET", "/v1/"+rn.resource.path)
+		client := r.reader(rn)
+		request := client.NewRequest("GET", "/v1/"+rn.resource.path)
 		for k, v := range rn.resource.options {
 			request.Params.Add(k, v)
 		}
-		resp, err := r.client.RawRequest(request)
+		resp, err := client.RawRequest(request)
 		if err != nil {
 			return err
 		}
@@ -388,10 +696,41 @@ func (r VaultService) get(rn *watchedResource) error {
 			secret.LeaseDuration = int((time.Duration(24) * time.Hour).Seconds())
 		}
 	case "pki":
-		secret, err = r.client.Logical().Write(fmt.Sprintf(rn.resource.path), params)
+		// step: take turns with the other replicas issuing from the role if required
+		release := func() {}
+		if issuance != nil {
+			if release, err = issuance.acquire(rn.resource); err != nil {
+				return err
+			}
+		}
+		if rn.resource.spiffeID != "" {
+			params["uri_sans"] = rn.resource.spiffeID
+		}
+		if rn.resource.keystorePassword != "" {
+			secret, err = r.getKeystore(rn, params)
+		} else if rn.resource.keySource != "" {
+			secret, err = r.signCSR(rn, params)
+		} else {
+			r.clampPKITTL(rn, params)
+			secret, err = r.client.Logical().Write(fmt.Sprintf(rn.resource.path), params)
+		}
+		release()
 	case "transit":
 		secret, err = r.client.Logical().Write(fmt.Sprintf(rn.resource.path), params)
+	case "tpl":
+		secret, err = r.getMerged(rn)
+	case "health":
+		secret, err = r.getHealth(rn)
+	case "generic":
+		secret, err = r.getGeneric(rn, params)
+	case "cabundle":
+		secret, err = r.getCABundle(rn)
 	case "aws":
+		// step: the key is a parameter of the request, which a read can't carry
+		if rn.resource.pgpKey != "" {
+			secret, err = r.client.Logical().Write(rn.resource.path, params)
+			break
+		}
 		fallthrough
 	case "cubbyhole":
 		fallthrough
@@ -400,7 +739,19 @@ func (r VaultService) get(rn *watchedResource) error {
 	case "postgres":
 		fallthrough
 	case "secret":
-		secret, err = r.client.Logical().Read(rn.resource.path)
+		if rn.resource.isMerged() {
+			secret, err = r.getMerged(rn)
+			break
+		}
+		if rn.resource.kvVersion == 2 {
+			secret, err = r.getKV(rn)
+			break
+		}
+		if rn.resource.isWildcard() {
+			secret, err = r.getWildcard(rn)
+			break
+		}
+		secret, err = r.read(rn, rn.resource.path)
 		// We must generate the secret if we have the create flag
 		if rn.resource.create && secret == nil && err == nil {
 			glog.V(3).Infof("Create param specified, creating resource: %s", rn.resource.path)
@@ -409,11 +760,29 @@ func (r VaultService) get(rn *watchedResource) error {
 			glog.V(3).Infof("Secret created: %s", rn.resource.path)
 			if err == nil {
 				// Populate the secret data as stored in Vault...
-				secret, err = r.client.Logical().Read(rn.resource.path)
+				r.cache.remove(cacheKey(rn.resource, rn.resource.path))
+				secret, err = r.read(rn, rn.resource.path)
 			}
 		}
 	}
+	// step: keep serving the last good copy of a kv secret deleted upstream, rather than failing or writing it empty
+	if deleted, found := err.(*kvDeletedError); found {
+		r.kvDeleted(rn, deleted)
+		if rn.secret != nil {
+			rn.lastUpdated = time.Now()
+			return errSecretUnchanged
+		}
+	} else if err == nil || err == errSecretUnchanged {
+		r.kvRestored(rn)
+	}
 	// step: check the error if any
+	if err == errSecretUnchanged {
+		rn.lastUpdated = time.Now()
+		return err
+	}
+	if err != nil && timeout > 0 && time.Since(started) >= timeout {
+		err = fmt.Errorf("a request exceeded the timeout of %s, error: %s", timeout, err)
+	}
 	if err != nil {
 		if strings.Contains(err.Error(), "missing client token") {
 			// decision: until the rewrite, lets just exit for now
@@ -429,73 +798,135 @@ func (r VaultService) get(rn *watchedResource) error {
 		return fmt.Errorf("unable to retrieve the secret")
 	}
 
+	// step: check the certificate issued is what we asked for, revoking it otherwise
+	if rn.resource.verify {
+		if err := verifyCertificate(rn.resource, secret.Data); err != nil {
+			if secret.LeaseID != "" {
+				if e := r.revoke(secret.LeaseID); e != nil {
+					glog.Errorf("failed to revoke the lease: %s on the rejected certificate, error: %s", secret.LeaseID, e)
+				}
+			}
+			return fmt.Errorf("the certificate failed verification, error: %s", err)
+		}
+	}
+
 	// step: update the watched resource
 	rn.lastUpdated = time.Now()
+	rn.issued = rn.lastUpdated
+	rn.renewals = 0
+	rn.source = rn.fetching
+	if options.logChanges {
+		hashes := hashData(secret.Data)
+		logSecretChanges(rn.resource, rn.hashes, hashes)
+		rn.hashes = hashes
+	}
 	rn.secret = secret
-	rn.leaseExpireTime = rn.lastUpdated.Add(time.Duration(secret.LeaseDuration))
+	rn.leaseExpireTime = rn.lastUpdated.Add(time.Duration(secret.LeaseDuration) * time.Second)
 
-	glog.V(3).Infof("retrieved resource: %s, leaseId: %s, lease_time: %s",
-		rn.resource, rn.secret.LeaseID, time.Duration(rn.secret.LeaseDuration)*time.Second)
+	glog.V(3).Infof("retrieved resource: %s from: %s, leaseId: %s, lease_time: %s, issued: %s",
+		rn.resource, rn.source, rn.secret.LeaseID, formatDuration(time.Duration(rn.secret.LeaseDuration)*time.Second),
+		formatTime(rn.issued))
 
 	return err
 }
 
-// newVaultClient creates and authenticates a vault client
-func newVaultClient(opts *config) (*api.Client, error) {
+// read performs a logical read of the resource, static secrets are served from the cache when enabled
+//	rn			: the watched resource
+//	path		: the path to read
+func (r VaultService) read(rn *watchedResource, path string) (*api.Secret, error) {
+	cacheable := !rn.resource.noCache && !rn.resource.isDynamic()
+	if cacheable {
+		if secret, found := r.cache.get(cacheKey(rn.resource, path)); found {
+			glog.V(4).Infof("resource: %s served from the response cache", rn.resource)
+			rn.fetching = sourceCache
+			return secret, nil
+		}
+	}
+	var secret *api.Secret
 	var err error
-	var token string
+	if r.readClient != nil && r.reader(rn) == r.readClient {
+		rn.fetching = sourceStandby
+	}
+	if options.hedgeReads && !rn.resource.isDynamic() && !rn.resource.create {
+		secret, err = hedgedRead(r.reader(rn), r.hedger(rn), r.latency, path)
+	} else {
+		secret, err = r.reader(rn).Logical().Read(path)
+	}
+	if err == nil && cacheable {
+		r.cache.set(cacheKey(rn.resource, path), secret)
+	}
 
-	config := api.DefaultConfig()
-	config.Address = opts.vaultURL
+	return secret, err
+}
+
+// reader returns the client static reads of the resource are made with, the read client when a
+// performance standby is configured; dynamic secrets and those we create are always read from the
+// active node, the former being leases and the later possibly not yet replicated
+func (r VaultService) reader(rn *watchedResource) *api.Client {
+	if r.readClient == nil || rn.resource.isDynamic() || rn.resource.create {
+		return r.client
+	}
+	// step: the token may have been renewed or reissued since
+	r.readClient.SetToken(r.client.Token())
+
+	return r.readClient
+}
+
+// hedger returns the client a hedged read of the resource is made with, the other of the active node and the
+// performance standby when one is configured, otherwise the same vault
+func (r VaultService) hedger(rn *watchedResource) *api.Client {
+	if r.readClient == nil || r.reader(rn) == r.readClient {
+		return r.client
+	}
+	r.readClient.SetToken(r.client.Token())
+
+	return r.readClient
+}
 
-	config.HttpClient.Transport, err = buildHTTPTransport(opts)
+// newVaultClient creates and authenticates a vault client
+//	opts		: the configuration
+//	url			: the address of the vault
+//	auth		: the authentication options
+//	relogin		: signalled when a revoked token is replaced by a login
+func newVaultClient(opts *config, url string, auth *vaultAuthOptions, relogin chan struct{}) (*api.Client, error) {
+	client, err := newAPIClient(opts, url)
 	if err != nil {
 		return nil, err
 	}
 
-	// step: create the actual client
-	client, err := api.NewClient(config)
+	// step: login and set the token for the client
+	token, err := login(client, opts, auth)
 	if err != nil {
 		return nil, err
 	}
+	client.SetToken(token)
 
-	plugin := opts.vaultAuthOptions.Method
-	switch plugin {
-	case "userpass":
-		token, err = NewUserPassPlugin(client).Create(opts.vaultAuthOptions)
-	case "approle":
-		token, err = NewAppRolePlugin(client).Create(opts.vaultAuthOptions)
-	case "aws-ec2":
-		token, err = NewAWSEC2Plugin(client).Create(opts.vaultAuthOptions)
-	case "gcp-gce":
-		token, err = NewGCPGCEPlugin(client).Create(opts.vaultAuthOptions)
-	case "kubernetes":
-		token, err = NewKubernetesPlugin(client).Create(opts.vaultAuthOptions)
-	case "token":
-		opts.vaultAuthOptions.FileName = options.vaultAuthFile
-		opts.vaultAuthOptions.FileFormat = options.vaultAuthFileFormat
-		token, err = NewUserTokenPlugin(client).Create(opts.vaultAuthOptions)
-	default:
-		return nil, fmt.Errorf("unsupported authentication plugin: %s", plugin)
+	// step: lookup the token, logging the accessor rather than the token
+	tokeninfo, err := client.Auth().Token().LookupSelf()
+	if err != nil && opts.vaultRenewToken {
+		return nil, fmt.Errorf("failed to lookup token info: %s", err)
 	}
 	if err != nil {
-		return nil, err
+		glog.Warningf("unable to lookup the token of vault: %s, the accessor is unknown, error: %s", url, err)
 	}
-
-	// step: set the token for the client
-	client.SetToken(token)
+	tokens.update(url, client, tokeninfo)
+	shareToken(url, client)
 
 	if opts.vaultRenewToken {
-		tokeninfo, err := client.Auth().Token().LookupSelf()
-		if err != nil {
-			return nil, fmt.Errorf("failed to lookup token info: %s", err)
-		}
 
 		tokenttl, err := tokeninfo.TokenTTL()
 		if err != nil {
 			return nil, fmt.Errorf("failed to lookup token ttl: %s", err)
 		}
 		glog.Infof("token ttl is %v", tokenttl)
+
+		// step: batch tokens can't be renewed, we have to login again before they expire
+		renewable := isRenewableToken(tokeninfo)
+		if !renewable && auth.Method == "token" {
+			glog.Warningf("the token is not renewable and can't be reissued by the token method, it will expire in %v", tokenttl)
+			return client, nil
+		}
+
 		renewPeriod := tokenttl / 2
 		go func() {
 			for {
@@ -505,13 +936,32 @@ func newVaultClient(opts *config) (*api.Client, error) {
 				glog.Infof("scheduling token renew in %v", renewPeriod)
 				<-time.After(renewPeriod)
 
-				glog.Infof("attempting token renew")
-				newtokeninfo, err := client.Auth().Token().RenewSelf(0)
+				newtokeninfo, err := renewToken(client, opts, auth, renewable)
+				// step: a revoked token is replaced by a login, the leases issued with it are acquired again
+				relogged := false
+				if isPermissionDenied(err) && canRelogin(url, auth) {
+					glog.Warningf("the token of vault: %s has been revoked, logging in again, error: %s", url, err)
+					if newtokeninfo, err = renewToken(client, opts, auth, false); err == nil {
+						relogged = true
+					}
+				}
 				if err != nil {
 					renewPeriod = renewPeriod / 2
 					glog.Warningf("error: failed to renew token, retrying in %v: %v", renewPeriod, err)
 					continue
 				}
+				// step: a token which couldn't be renewed has been replaced by a login
+				if !renewable || relogged {
+					tokens.update(url, client, newtokeninfo)
+					shareToken(url, client)
+				}
+				if relogged {
+					renewable = isRenewableToken(newtokeninfo)
+					select {
+					case relogin <- struct{}{}:
+					default:
+					}
+				}
 
 				tokenttl, err := newtokeninfo.TokenTTL()
 				if err != nil {
@@ -527,6 +977,87 @@ func newVaultClient(opts *config) (*api.Client, error) {
 	return client, nil
 }
 
+// newAPIClient creates an unauthenticated vault client
+func newAPIClient(opts *config, url string) (*api.Client, error) {
+	var err error
+
+	config := api.DefaultConfig()
+	config.Address = url
+
+	transport, err := buildHTTPTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+	// step: trace each attempt of a request as it's made, beneath the retries
+	var base http.RoundTripper = transport
+	if httpTraces != nil {
+		base = &debugTransport{transport: transport, traces: httpTraces}
+	}
+	config.HttpClient.Transport = &retryAfterTransport{transport: base}
+	if opts.maxResponseSize > 0 {
+		config.HttpClient.Transport = &limitedTransport{
+			transport: config.HttpClient.Transport,
+			limit:     int64(opts.maxResponseSize),
+		}
+	}
+	config.HttpClient.Transport = &userAgentTransport{transport: config.HttpClient.Transport, agent: userAgent(opts)}
+
+	// step: the retries of the vault api are the attempts after the first, as with VAULT_MAX_RETRIES
+	if opts.vaultMaxRetries >= 0 {
+		config.MaxRetries = opts.vaultMaxRetries + 1
+	}
+
+	// step: create the actual client
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	if opts.vaultNamespace != "" {
+		client.SetHeaders(http.Header{"X-Vault-Namespace": []string{opts.vaultNamespace}})
+	}
+
+	return client, nil
+}
+
+// login authenticates with the plugin of the auth method, exchanging the token for a periodic or
+// orphan token if requested
+func login(client *api.Client, opts *config, auth *vaultAuthOptions) (string, error) {
+	var err error
+	var token string
+
+	plugin := auth.Method
+	switch plugin {
+	case "userpass":
+		token, err = NewUserPassPlugin(client).Create(auth)
+	case "approle":
+		token, err = NewAppRolePlugin(client).Create(auth)
+	case "aws-ec2":
+		token, err = NewAWSEC2Plugin(client).Create(auth)
+	case "gcp-gce":
+		token, err = NewGCPGCEPlugin(client).Create(auth)
+	case "kubernetes":
+		token, err = NewKubernetesPlugin(client).Create(auth)
+	case "exec":
+		token, err = NewExecPlugin(client, opts.execTimeout).Create(auth)
+	case "oidc":
+		token, err = NewOIDCPlugin(client).Create(auth)
+	case "token":
+		// step: the default vault reads the token from the auth file, named vaults carry their own
+		if auth == opts.vaultAuthOptions {
+			auth.FileName = opts.vaultAuthFile
+			auth.FileFormat = opts.vaultAuthFileFormat
+		}
+		token, err = NewUserTokenPlugin(client).Create(auth)
+	default:
+		return "", fmt.Errorf("unsupported authentication plugin: %s", plugin)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return requestToken(client, auth, token)
+}
+
 // buildHTTPTransport constructs a http transport for the http client
 func buildHTTPTransport(opts *config) (*http.Transport, error) {
 	// step: create the vault sidekick
@@ -539,22 +1070,51 @@ func buildHTTPTransport(opts *config) (*http.Transport, error) {
 		TLSHandshakeTimeout: 10 * time.Second,
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: opts.skipTLSVerify,
+			ServerName:         opts.tlsServerName,
 		},
 	}
 	if opts.skipTLSVerify {
-		glog.Warning("skipping TLS verification is not recommended")
+		glog.Warning("*** WARNING: tls verification of the vault service is disabled, the connection is open to interception ***")
+		glog.Warning("*** WARNING: skipping TLS verification is not recommended, use -ca-cert or -ca-path to trust an internal ca ***")
 	}
-	// step: are we loading a CA file
-	if opts.vaultCaFile != "" {
-		glog.V(3).Infof("loading the ca certificate: %s", opts.vaultCaFile)
-		caCert, err := ioutil.ReadFile(opts.vaultCaFile)
-		if err != nil {
-			return nil, fmt.Errorf("unable to read in the ca: %s, reason: %s", opts.vaultCaFile, err)
-		}
+	// step: are we loading a CA file or directory
+	if opts.vaultCaFile != "" || opts.vaultCaPath != "" {
 		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
+		var files []string
+		if opts.vaultCaFile != "" {
+			files = append(files, opts.vaultCaFile)
+		}
+		if opts.vaultCaPath != "" {
+			list, err := ioutil.ReadDir(opts.vaultCaPath)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read the ca path: %s, reason: %s", opts.vaultCaPath, err)
+			}
+			for _, x := range list {
+				if !x.IsDir() {
+					files = append(files, filepath.Join(opts.vaultCaPath, x.Name()))
+				}
+			}
+		}
+		for _, filename := range files {
+			glog.V(3).Infof("loading the ca certificate: %s", filename)
+			caCert, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read in the ca: %s, reason: %s", filename, err)
+			}
+			if !caCertPool.AppendCertsFromPEM(caCert) && filename == opts.vaultCaFile {
+				return nil, fmt.Errorf("no certificates found in the ca: %s", filename)
+			}
+		}
 		transport.TLSClientConfig.RootCAs = caCertPool
 	}
+	// step: are we presenting a client certificate
+	if opts.vaultClientCert != "" {
+		pair, err := tls.LoadX509KeyPair(opts.vaultClientCert, opts.vaultClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate: %s, reason: %s", opts.vaultClientCert, err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{pair}
+	}
 
 	return transport, nil
 }
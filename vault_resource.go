@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -52,12 +53,122 @@ const (
 	// to updates for this resource. If non-zero, a random value between 0 and
 	// maxJitter will be subtracted from the update period.
 	optionMaxJitter = "jitter"
+	// optionPatchRegex is a regex whose named capture groups are replaced by the secret keys in patch mode
+	optionPatchRegex = "regex"
+	// optionDecode decodes the values of the secret before writing i.e. base64
+	optionDecode = "decode"
+	// optionVault binds the resource to one of the named vaults in the auth file
+	optionVault = "vault"
+	// optionCommonNames is a list of common names, a certificate is issued for each
+	optionCommonNames = "cn"
+	// commonNamePlaceholder is replaced in the filename by the common name
+	commonNamePlaceholder = "{cn}"
+	// optionSystemd is a systemd unit to reload when the resource changes
+	optionSystemd = "systemd"
+	// optionSystemdAction is the action performed on the systemd unit
+	optionSystemdAction = "systemd-action"
+	// optionNoCache bypasses the response cache for the resource
+	optionNoCache = "no-cache"
+	// optionVerify verifies an issued certificate matches the request
+	optionVerify = "verify"
+	// optionKVVersion is the version of the kv backend serving the secret
+	optionKVVersion = "kv"
+	// optionMetaFile writes a metadata file alongside the secret
+	optionMetaFile = "meta"
+	// optionEncryptTo encrypts the files for an age or gpg recipient
+	optionEncryptTo = "encrypt-to"
+	// optionValues is a list of values files rendered into a template
+	optionValues = "values"
+	// optionReload is a server to reload gracefully when the resource changes i.e. nginx or haproxy
+	optionReload = "reload"
+	// optionReloadPid is the pid file of the server to reload
+	optionReloadPid = "reload-pid"
+	// optionReloadCheck is the address checked for the new certificate after a reload
+	optionReloadCheck = "reload-check"
+	// optionServe serves the certificate, ca chain and crl of the resource on the -serve-pki listener
+	optionServe = "serve"
+	// optionStagger spreads the renewals of the replicas over a window, by a delay derived from the pod name
+	optionStagger = "stagger"
+	// optionValidate is a command run against the file before it's swapped into place
+	optionValidate = "validate"
+	// optionDBHost is the host of the database written into the pgpass and mycnf formats
+	optionDBHost = "db-host"
+	// optionDBPort is the port of the database written into the pgpass and mycnf formats
+	optionDBPort = "db-port"
+	// optionDBName is the name of the database written into the pgpass and mycnf formats
+	optionDBName = "db-name"
+	// optionNotifyFifo is a named pipe written to when the resource changes
+	optionNotifyFifo = "notify-fifo"
+	// optionTags labels the resource, so a subset of the resources can be selected
+	optionTags = "tags"
+	// optionSection is the section of an ini file holding the keys of the secret
+	optionSection = "section"
+	// optionDelimiter is the delimiter of the fields of a csv file
+	optionDelimiter = "delimiter"
+	// optionOCSP writes an ocsp staple file alongside the certificate
+	optionOCSP = "ocsp"
+	// optionDocuments writes the secrets of a merged resource as separate yaml documents
+	optionDocuments = "documents"
+	// optionKeystorePassword is the secret holding the password of a p12 or jks keystore
+	optionKeystorePassword = "keystore-password"
+	// optionTimeout bounds each request to vault made for the resource
+	optionTimeout = "timeout"
+	// optionBackups is the number of previous versions of the file kept
+	optionBackups = "backups"
+	// optionNamespace is the vault enterprise namespace the resource is read from
+	optionNamespace = "ns"
+	// optionExplode writes each key of the secret as a file of a directory
+	optionExplode = "explode"
+	// optionKey generates the private key of a certificate locally or in a pkcs11 token, sending vault a csr
+	optionKey = "key"
+	// optionSPIFFEID requests a certificate holding the spiffe id as its uri san
+	optionSPIFFEID = "spiffe-id"
+	// optionRotate allows the static role of the resource to be rotated via the admin api
+	optionRotate = "rotate"
+	// optionWave is the startup wave the resource is first retrieved in
+	optionWave = "wave"
+	// optionMethod is the request a generic resource makes, read or write
+	optionMethod = "method"
+	// optionOut writes the resource to stdout, a named pipe or a sealed anonymous file rather than a file i.e. out=stdout
+	optionOut = "out"
+	// optionKeys is a list of the keys of the secret written, the rest being dropped
+	optionKeys = "keys"
+	// optionCAFiles is a list of files of ca certificates added to a trust bundle
+	optionCAFiles = "ca-files"
+	// optionMerge is the dotted path of an existing json or yaml file the secret is merged into
+	optionMerge = "merge"
+	// optionFreeze is a change freeze window the new versions of the resource are held back during
+	optionFreeze = "freeze"
+	// optionSELinux is the selinux context the files of the resource are labeled with
+	optionSELinux = "selinux"
+	// optionSELinuxMode sets or verifies the selinux context of the files of the resource
+	optionSELinuxMode = "selinux-mode"
+	// optionActiveHours is the hours the credentials of a dynamic resource are held, revoked outside them
+	optionActiveHours = "active-hours"
+	// optionRevokeSuperseded revokes the certificate replaced by a new issue of a pki resource
+	optionRevokeSuperseded = "revoke-superseded"
+	// optionPGPKey is the public key vault encrypts the values of the secret with, written still encrypted
+	optionPGPKey = "pgp_key"
+	// optionRequire is the keys a secret must hold, not empty, to be written
+	optionRequire = "require"
+	// optionMinLength is the minimum length of the values of a secret to be written
+	optionMinLength = "min-length"
+	// optionMinEntropy is the minimum entropy in bits of the values of a secret to be written
+	optionMinEntropy = "min-entropy"
+	// optionMatchPrefix prefixes the key whose value must match the pattern i.e. match.password=^[a-z]+$
+	optionMatchPrefix = "match."
+	// optionOnSuccess is a command run after every successful update of the resource
+	optionOnSuccess = "on-success"
+	// optionOnFailure is a command run after every failed retrieval or update of the resource
+	optionOnFailure = "on-failure"
+	// optionOnChange is a command run after an update which changed the content of the secret
+	optionOnChange = "on-change"
 	// defaultSize sets the default size of a generic secret
 	defaultSize = 20
 )
 
 var (
-	resourceFormatRegex = regexp.MustCompile("^(yaml|yml|json|env|ini|txt|cert|bundle|csv)$")
+	resourceFormatRegex = regexp.MustCompile("^(yaml|yml|json|env|envdir|ini|txt|cert|bundle|csv|patch|binary|pgpass|mycnf|p12|jks|spiffe)$")
 
 	// a map of valid resource to retrieve from vault
 	validResources = map[string]bool{
@@ -71,6 +182,19 @@ var (
 		"transit":   true,
 		"cubbyhole": true,
 		"cassandra": true,
+		"health":    true,
+		"generic":   true,
+		"cabundle":  true,
+	}
+
+	// a map of the resources which issue dynamic credentials under a lease
+	dynamicResources = map[string]bool{
+		"pki":       true,
+		"aws":       true,
+		"mysql":     true,
+		"postgres":  true,
+		"cassandra": true,
+		"generic":   true,
 	}
 )
 
@@ -124,6 +248,116 @@ type VaultResource struct {
 	// maxJitter is the maximum jitter duration to use for this resource when
 	// performing renewals
 	maxJitter time.Duration
+	// patchRegex is the regex used to locate the placeholders in patch mode
+	patchRegex *regexp.Regexp
+	// decode is the encoding of the values which should be decoded before writing
+	decode string
+	// vault is the name of the vault the resource is retrieved from, empty for the default
+	vault string
+	// systemdUnit is a systemd unit to reload when the resource changes
+	systemdUnit string
+	// systemdAction is the action performed on the systemd unit
+	systemdAction string
+	// noCache bypasses the response cache
+	noCache bool
+	// verify checks the issued certificate matches the request
+	verify bool
+	// kvVersion is the version of the kv backend, zero or one for the original
+	kvVersion int
+	// metaFile writes a metadata file alongside the secret
+	metaFile bool
+	// the files written by a wildcard resource
+	wildcardFiles map[string]bool
+	// the age or gpg recipient the files are encrypted for
+	encryptTo string
+	// the values files rendered into the template
+	valuesFiles []string
+	// the context passed to the last exec hook
+	hookContext map[string]string
+	// reloadServer is the server reloaded when the resource changes
+	reloadServer string
+	// reloadPidFile is the pid file of the server, empty for the default
+	reloadPidFile string
+	// reloadCheck is the address checked for the new certificate after a reload
+	reloadCheck string
+	// servePKI serves the certificate of the resource on the -serve-pki listener
+	servePKI bool
+	// stagger is the window the renewals of the replicas are spread over
+	stagger time.Duration
+	// validate is a command run against the file before it's swapped into place
+	validate string
+	// the host, port and name of the database written into the pgpass and mycnf formats
+	dbHost string
+	dbPort string
+	dbName string
+	// notifyFifo is a named pipe written to when the resource changes
+	notifyFifo string
+	// tags label the resource, selected by the only-tags and skip-tags options
+	tags []string
+	// section is the section of an ini file holding the keys of the secret
+	section string
+	// delimiter is the delimiter of the fields of a csv file, a comma if zero
+	delimiter rune
+	// ocsp writes an ocsp staple file alongside the certificate
+	ocsp bool
+	// documents writes the secrets of a merged resource as separate yaml documents
+	documents bool
+	// keystorePassword is the path of the secret holding the password of a keystore
+	keystorePassword string
+	// timeout bounds each request to vault made for the resource, the resource deadline if zero
+	timeout time.Duration
+	// backups is the number of previous versions of the file kept, the global default if zero
+	backups int
+	// namespace is the vault enterprise namespace of the resource, the global namespace if empty
+	namespace string
+	// explode writes each key of the secret as a file of the directory named by the filename
+	explode bool
+	// keySource is where the private key of a signed certificate is generated, local or a pkcs11 uri
+	keySource string
+	// spiffeID is the spiffe id the certificate is issued for, as its only uri san
+	spiffeID string
+	// rotate allows the static role of the resource to be rotated via the admin api
+	rotate bool
+	// wave is the startup wave the resource is first retrieved in, after the resources of the earlier waves
+	wave int
+	// method is the request a generic resource makes, a read unless write
+	method string
+	// stdout writes the resource to stdout rather than a file
+	stdout bool
+	// fifo hands the resource to the readers of a named pipe rather than writing a file
+	fifo bool
+	// memfd hands the resource to the command of exec-env-only on a sealed anonymous file rather than writing a file
+	memfd bool
+	// selinuxContext is the selinux context the files are labeled with, the global context if empty
+	selinuxContext string
+	// selinuxMode sets or verifies the selinux context, the global mode if empty
+	selinuxMode string
+	// keys is the keys of the secret written, all of them if empty
+	keys []string
+	// caFiles is the files of ca certificates added to the trust bundle of a cabundle resource
+	caFiles []string
+	// merge is the dotted path of the existing file the secret is merged into, disabled if empty
+	merge string
+	// freeze is the change freeze window the new versions are held back during, nil if none
+	freeze *freezeWindow
+	// activeHours is the windows the credentials are held within, always if empty
+	activeHours activeHours
+	// revokeSuperseded revokes the certificate replaced by a new issue, after the revoke delay
+	revokeSuperseded bool
+	// origin is where the resource was given i.e. -cn #2, reported with its validation errors
+	origin string
+	// position is the order the resource was given in
+	position int
+	// pgpKey is the file, keybase user or base64 encoded public key the secret is encrypted with by vault
+	pgpKey string
+	// policy is the requirements the secret must meet to be written, nil if none
+	policy *secretPolicy
+	// onSuccess, onFailure and onChange are the commands run following the outcome of an update
+	onSuccess string
+	onFailure string
+	onChange  string
+	// outcome tracks the consecutive failures and content of the resource for the outcome hooks
+	outcome outcomeState
 }
 
 // GetFilename generates a resource filename by default the resource name and resource type, which
@@ -136,6 +370,74 @@ func (r VaultResource) GetFilename() string {
 	return fmt.Sprintf("%s.%s", r.path, r.resource)
 }
 
+// clone returns a copy of the resource
+func (r VaultResource) clone() *VaultResource {
+	x := r
+	x.options = make(map[string]string, len(r.options))
+	for k, v := range r.options {
+		x.options[k] = v
+	}
+
+	return &x
+}
+
+// ID returns a stable identifier for the resource, used to key persisted state
+func (r VaultResource) ID() string {
+	id := fmt.Sprintf("%s:%s", r.resource, r.path)
+	if r.namespace != "" {
+		// step: as vault addresses a path in a child namespace
+		id = fmt.Sprintf("%s:%s/%s", r.resource, r.namespace, r.path)
+	}
+	if r.filename != "" {
+		id = fmt.Sprintf("%s:%s", id, r.filename)
+	}
+	if r.vault != "" {
+		id = fmt.Sprintf("%s@%s", id, r.vault)
+	}
+
+	return id
+}
+
+// isWildcard checks if the resource is expanded into the children of the path i.e. secret:myapp/config/*
+func (r VaultResource) isWildcard() bool {
+	return strings.HasSuffix(r.path, wildcardSuffix)
+}
+
+// wildcardFilename returns the filename of a child of a wildcard resource, the filename can be
+// templated with {key}, otherwise the key is appended to the filename
+func (r VaultResource) wildcardFilename(key string) string {
+	switch {
+	case r.filename == "":
+		return key
+	case strings.Contains(r.filename, keyPlaceholder):
+		return strings.Replace(r.filename, keyPlaceholder, key, -1)
+	default:
+		return fmt.Sprintf("%s.%s", r.filename, key)
+	}
+}
+
+// isMerged checks if the resource merges several secrets i.e. secret:secret/app/common,secret/app/prod
+func (r VaultResource) isMerged() bool {
+	return r.resource == "secret" && strings.Contains(r.path, ",")
+}
+
+// paths returns the paths of the resource, a template or merged resource can list several separated by a comma
+func (r VaultResource) paths() []string {
+	var list []string
+	for _, x := range strings.Split(r.path, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			list = append(list, x)
+		}
+	}
+
+	return list
+}
+
+// isDynamic checks if the resource issues dynamic credentials i.e. each retrieval is a new lease
+func (r VaultResource) isDynamic() bool {
+	return dynamicResources[r.resource] || r.isStaticRole()
+}
+
 // IsValid checks to see if the resource is valid
 func (r *VaultResource) IsValid() error {
 	// step: check the resource type
@@ -151,6 +453,38 @@ func (r *VaultResource) IsValid() error {
 	return nil
 }
 
+// isValidWith checks the resource can be used with the options
+//	cfg			: the options
+func (r *VaultResource) isValidWith(cfg *config) error {
+	if r.freeze != nil && cfg.oneShot {
+		return fmt.Errorf("the resource: %s has a freeze window, which can't be used with one-shot", r)
+	}
+	if r.memfd && !cfg.execEnvOnly {
+		return fmt.Errorf("the resource: %s is handed over on a sealed anonymous file, which requires the exec-env-only option", r)
+	}
+	if len(r.activeHours) > 0 && cfg.oneShot {
+		return fmt.Errorf("the resource: %s has active hours, which can't be used with one-shot", r)
+	}
+	if strings.HasPrefix(r.keySource, pkcs11Scheme) && cfg.pkcs11Module == "" {
+		return fmt.Errorf("the resource: %s has its key in a pkcs11 token, which requires the pkcs11-module option", r)
+	}
+
+	return nil
+}
+
+// hasTag checks if the resource carries any of the tags
+func (r *VaultResource) hasTag(tags ...string) bool {
+	for _, x := range tags {
+		for _, tag := range r.tags {
+			if x == tag {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // isValidResource validates the resource meets the requirements
 func (r *VaultResource) isValidResource() error {
 	switch r.resource {
@@ -163,10 +497,132 @@ func (r *VaultResource) isValidResource() error {
 			return fmt.Errorf("transit requires a ciphertext option")
 		}
 	case "tpl":
-		if _, found := r.options[optionTemplatePath]; !found {
+		if r.templateFile == "" {
 			return fmt.Errorf("template resource requires a template path option")
 		}
 	}
+	if len(r.valuesFiles) > 0 && r.resource != "tpl" {
+		return fmt.Errorf("the values option is only supported for the template resource")
+	}
+	if r.isWildcard() {
+		if r.resource != "secret" {
+			return fmt.Errorf("wildcard paths are only supported for the secret resource")
+		}
+		if r.create || r.kvVersion == 2 {
+			return fmt.Errorf("wildcard paths do not support the create or kv=2 options")
+		}
+	}
+	if r.isMerged() && (r.create || r.isWildcard()) {
+		return fmt.Errorf("merging several paths does not support the create option or wildcards")
+	}
+	if r.merge != "" {
+		if r.format != "json" && r.format != "yaml" && r.format != "yml" {
+			return fmt.Errorf("the merge option requires the json or yaml format")
+		}
+		if r.resource == "tpl" || r.explode || r.isWildcard() || r.documents || r.encryptTo != "" || r.validate != "" {
+			return fmt.Errorf("the merge option is not supported with templates, wildcards, or the explode, documents, encrypt-to or validate options")
+		}
+	}
+	if len(r.activeHours) > 0 && (!dynamicResources[r.resource] || r.isStaticRole()) {
+		return fmt.Errorf("the active-hours option is only supported for resources issuing leases i.e. aws, mysql or pki")
+	}
+	if r.freeze != nil && r.isDynamic() {
+		return fmt.Errorf("the freeze option is only supported for static secrets, the lease of a dynamic secret held back could expire or be revoked within the window")
+	}
+	if r.revokeSuperseded {
+		if _, found := pkiMount(r.path); r.resource != "pki" || !found {
+			return fmt.Errorf("the revoke-superseded option is only supported for pki resources issuing from mount/issue/role or mount/sign/role")
+		}
+	}
+	if r.pgpKey != "" {
+		if r.resource != "aws" && r.resource != "generic" {
+			return fmt.Errorf("the pgp_key option is only supported for the aws and generic resources")
+		}
+		if r.decode != "" || r.merge != "" || r.format == "patch" {
+			return fmt.Errorf("the values encrypted by the pgp_key option can't be decoded, merged or patched")
+		}
+	}
+	if r.encryptTo != "" && r.format == "patch" {
+		return fmt.Errorf("the encrypt-to option is not supported with the patch format")
+	}
+	if (r.reloadPidFile != "" || r.reloadCheck != "") && r.reloadServer == "" {
+		return fmt.Errorf("the reload-pid and reload-check options require the reload option")
+	}
+	if r.format == "envdir" && (r.encryptTo != "" || r.validate != "") {
+		return fmt.Errorf("the envdir format does not support the encrypt-to or validate options")
+	}
+	if r.keySource != "" {
+		if r.resource != "pki" || !strings.Contains("/"+strings.Trim(r.path, "/")+"/", "/sign/") {
+			return fmt.Errorf("the key option requires a pki sign path i.e. pki/sign/web")
+		}
+		if r.keystorePassword != "" && r.keySource != keySourceLocal {
+			return fmt.Errorf("a key held in a pkcs11 token can't be written to a keystore")
+		}
+	}
+	if r.format == "spiffe" && r.resource != "pki" {
+		return fmt.Errorf("the spiffe format is only supported for the pki resource")
+	}
+	if r.spiffeID != "" {
+		if r.resource != "pki" {
+			return fmt.Errorf("the spiffe-id option is only supported for the pki resource")
+		}
+		if _, found := r.options["uri_sans"]; found {
+			return fmt.Errorf("the spiffe-id option is the only uri san of an svid, remove the uri_sans option")
+		}
+	}
+	if r.rotate && (r.resource == "tpl" || r.isMerged() || !r.isStaticRole()) {
+		return fmt.Errorf("the rotate option requires a database static role i.e. database/static-creds/app")
+	}
+	if r.explode && (r.resource == "tpl" || r.encryptTo != "" || r.validate != "" || r.format == "patch" || r.format == "envdir" || r.format == "spiffe") {
+		return fmt.Errorf("the explode option is not supported with templates, the patch, envdir or spiffe formats, or the encrypt-to or validate options")
+	}
+	if r.validate != "" && (r.format == "patch" || r.encryptTo != "") {
+		return fmt.Errorf("the validate option is not supported with the patch format or the encrypt-to option")
+	}
+	if r.format == "p12" || r.format == "jks" {
+		if r.resource != "pki" {
+			return fmt.Errorf("the %s format is only supported for the pki resource", r.format)
+		}
+		if r.keystorePassword == "" {
+			return fmt.Errorf("the %s format requires the keystore-password option", r.format)
+		}
+	}
+	if r.keystorePassword != "" && r.format != "p12" && r.format != "jks" {
+		return fmt.Errorf("the keystore-password option requires the p12 or jks format")
+	}
+	if r.section != "" && r.format != "ini" {
+		return fmt.Errorf("the section option requires the ini format")
+	}
+	if r.delimiter != 0 && r.format != "csv" {
+		return fmt.Errorf("the delimiter option requires the csv format")
+	}
+	if r.documents && (!r.isMerged() || (r.format != "yaml" && r.format != "yml")) {
+		return fmt.Errorf("the documents option requires the yaml format and several paths")
+	}
+	if r.stagger > 0 && r.maxJitter > 0 {
+		return fmt.Errorf("the stagger and jitter options are mutually exclusive")
+	}
+	if len(r.caFiles) > 0 && r.resource != "cabundle" {
+		return fmt.Errorf("the ca-files option is only supported for the cabundle resource")
+	}
+	if r.method != "" && r.resource != "generic" {
+		return fmt.Errorf("the method option is only supported for the generic resource")
+	}
+	if len(r.keys) > 0 && (r.resource == "tpl" || r.isWildcard() || r.documents) {
+		return fmt.Errorf("the keys option is not supported with templates, wildcards or the documents option")
+	}
+	if err := r.isValidFifo(); err != nil {
+		return err
+	}
+	if err := r.isValidMemfd(); err != nil {
+		return err
+	}
+	if err := r.isValidStdout(); err != nil {
+		return err
+	}
+	if r.decode != "" && r.format != "binary" {
+		return fmt.Errorf("the decode option is only supported with the binary format")
+	}
 
 	return nil
 }
@@ -41,4 +41,55 @@ func TestIsValid(t *testing.T) {
 	assert.NotNil(t, resource.IsValid())
 	resource.resource = "pki"
 	assert.NotNil(t, resource.IsValid())
+	resource.resource = "secret"
+	resource.decode = "base64"
+	assert.NotNil(t, resource.IsValid())
+	resource.format = "binary"
+	assert.Nil(t, resource.IsValid())
+	resource.decode = ""
+	resource.format = "patch"
+	resource.encryptTo = "app@example.com"
+	assert.NotNil(t, resource.IsValid())
+	resource.format = "yaml"
+	resource.encryptTo = ""
+	resource.reloadCheck = "127.0.0.1:443"
+	assert.NotNil(t, resource.IsValid())
+	resource.reloadServer = "nginx"
+	assert.Nil(t, resource.IsValid())
+	resource.path = "secret/app/common, secret/app/prod"
+	assert.True(t, resource.isMerged())
+	assert.Equal(t, []string{"secret/app/common", "secret/app/prod"}, resource.paths())
+	assert.Nil(t, resource.IsValid())
+	resource.create = true
+	assert.NotNil(t, resource.IsValid())
+	resource.create = false
+	resource.section = "app"
+	assert.NotNil(t, resource.IsValid())
+	resource.format = "ini"
+	assert.Nil(t, resource.IsValid())
+	resource.section = ""
+	resource.delimiter = ';'
+	assert.NotNil(t, resource.IsValid())
+	resource.format = "csv"
+	assert.Nil(t, resource.IsValid())
+}
+
+func TestWildcardFilename(t *testing.T) {
+	rn := defaultVaultResource()
+	rn.resource = "secret"
+	rn.path = "myapp/config/*"
+	assert.True(t, rn.isWildcard())
+	assert.Nil(t, rn.IsValid())
+	assert.Equal(t, "debug", rn.wildcardFilename("debug"))
+	rn.filename = "/etc/flags/{key}.conf"
+	assert.Equal(t, "/etc/flags/debug.conf", rn.wildcardFilename("debug"))
+	assert.Equal(t, "/etc/flags", wildcardDirectory(rn))
+	rn.filename = "flags"
+	assert.Equal(t, "flags.debug", rn.wildcardFilename("debug"))
+
+	rn.create = true
+	assert.NotNil(t, rn.IsValid())
+	rn.create = false
+	rn.resource = "aws"
+	assert.NotNil(t, rn.IsValid())
 }
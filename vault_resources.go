@@ -19,16 +19,70 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/golang/glog"
 )
 
 // VaultResources is a collection of type resource
 type VaultResources struct {
 	// an array of resource to retrieve
 	items []*VaultResource
+	// the resources which failed to parse, reported along with the invalid ones
+	invalid resourceErrors
+	// the number of resources given so far
+	given int
+}
+
+// resourceError is a resource which failed to parse or validate, along with where it was given
+type resourceError struct {
+	// where the resource was given i.e. -cn #2
+	origin string
+	// the position the resource was given in
+	position int
+	// the resource as given, empty if it parsed
+	value string
+	// the error
+	err error
+}
+
+// resourceErrors is the errors of all the invalid resources, reported together
+type resourceErrors []resourceError
+
+// Error returns the errors, a line for each invalid resource
+func (e resourceErrors) Error() string {
+	lines := []string{fmt.Sprintf("found %d invalid resources:", len(e))}
+	for _, x := range e {
+		if x.value != "" {
+			lines = append(lines, fmt.Sprintf("  %s %q: %s", x.origin, x.value, x.err))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %s", x.origin, x.err))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// resourceFlag is the -cn flag, recording the resources which fail to parse rather than exiting on the first
+type resourceFlag struct {
+	resources *VaultResources
+}
+
+// Set parses the resource, any error is reported by the validation of the options
+func (f *resourceFlag) Set(value string) error {
+	f.resources.collect(fmt.Sprintf("-cn #%d", f.resources.given+1), value)
+	return nil
+}
+
+// String returns a string representation of the flag
+func (f *resourceFlag) String() string {
+	return ""
 }
 
 // Set is the implementation for the parser
@@ -36,125 +90,519 @@ type VaultResources struct {
 func (r *VaultResources) Set(value string) error {
 	rn := defaultVaultResource()
 
-	// step: split on the separator, default ':'
+	// step: parse the sections, split on the separator, default ':'
 	sep := getEnv("VAULT_SIDEKICK_SEPARATOR", ":")
-	items := strings.Split(os.ExpandEnv(value), sep)
-	if len(items) < 2 {
-		return fmt.Errorf("invalid resource, must have at least two sections TYPE:PATH")
-	}
-	if len(items) > 3 {
-		return fmt.Errorf("invalid resource, can only has three sections, TYPE:PATH[:OPTIONS]")
-	}
-	if items[0] == "" || items[1] == "" {
-		return fmt.Errorf("invalid resource, neither type or path can be empty")
+	spec, err := parseResourceSpec(os.ExpandEnv(value), sep)
+	if err != nil {
+		return err
 	}
 
 	// step: extract the elements
-	rn.resource = items[0]
-	rn.path = items[1]
+	rn.resource = spec.kind
+	rn.path = spec.path
 	rn.options = make(map[string]string, 0)
+	var commonNames []string
+	modeSet := false
+	formatSet := false
 
 	// step: extract any options
-	if len(items) > 2 {
-		for _, x := range strings.Split(items[2], ",") {
-			kp := strings.Split(x, "=")
-			if len(kp) != 2 {
-				return fmt.Errorf("invalid resource option: %s, must be KEY=VALUE", x)
-			}
-			if kp[1] == "" {
-				return fmt.Errorf("invalid resource option: %s, must have a value", x)
-			}
-			// step: set the name and value
-			name := strings.TrimSpace(kp[0])
-			value := strings.Replace(kp[1], "|", ",", -1)
-
-			// step: extract the control options from the path resource parameters
-			switch name {
-			case optionMode:
-				if !strings.HasPrefix(value, "0") {
-					value = "0" + value
-				}
-				if len(value) != 4 {
-					return errors.New("the file permission invalid, should be octal 0444 or alike")
-				}
-				v, err := strconv.ParseUint(value, 0, 32)
-				if err != nil {
-					return errors.New("invalid file permissions on resource")
-				}
-				rn.fileMode = os.FileMode(v)
-			case optionFormat:
-				if matched := resourceFormatRegex.MatchString(value); !matched {
-					return fmt.Errorf("unsupported output format: %s", value)
-				}
-				rn.format = value
-			case optionUpdate:
-				duration, err := time.ParseDuration(value)
-				if err != nil {
-					return fmt.Errorf("update option: %s is not value, should be a duration format", value)
-				}
-				rn.update = duration
-			case optionRevoke:
-				choice, err := strconv.ParseBool(value)
-				if err != nil {
-					return fmt.Errorf("the revoke option: %s is invalid, should be a boolean", value)
-				}
-				rn.revoked = choice
-			case optionsRevokeDelay:
-				duration, err := time.ParseDuration(value)
-				if err != nil {
-					return fmt.Errorf("the revoke delay option: %s is not value, should be a duration format", value)
-				}
-				rn.revokeDelay = duration
-			case optionRenewal:
-				choice, err := strconv.ParseBool(value)
-				if err != nil {
-					return fmt.Errorf("the renewal option: %s is invalid, should be a boolean", value)
-				}
-				rn.renewable = choice
-			case optionCreate:
-				choice, err := strconv.ParseBool(value)
-				if err != nil {
-					return fmt.Errorf("the create option: %s is invalid, should be a boolean", value)
+	for _, option := range spec.options {
+		name, value := option.name, option.value
+
+		// step: extract the control options from the path resource parameters
+		switch name {
+		case optionMode:
+			if !strings.HasPrefix(value, "0") {
+				value = "0" + value
+			}
+			if len(value) != 4 {
+				return errors.New("the file permission invalid, should be octal 0444 or alike")
+			}
+			v, err := strconv.ParseUint(value, 0, 32)
+			if err != nil {
+				return errors.New("invalid file permissions on resource")
+			}
+			rn.fileMode = os.FileMode(v)
+			modeSet = true
+		case optionFormat:
+			if matched := resourceFormatRegex.MatchString(value); !matched {
+				return fmt.Errorf("unsupported output format: %s", value)
+			}
+			rn.format = value
+			formatSet = true
+		case optionUpdate:
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("update option: %s is not value, should be a duration format", value)
+			}
+			rn.update = duration
+		case optionRevoke:
+			choice, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the revoke option: %s is invalid, should be a boolean", value)
+			}
+			rn.revoked = choice
+		case optionsRevokeDelay:
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("the revoke delay option: %s is not value, should be a duration format", value)
+			}
+			rn.revokeDelay = duration
+		case optionRenewal:
+			choice, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the renewal option: %s is invalid, should be a boolean", value)
+			}
+			rn.renewable = choice
+		case optionCreate:
+			choice, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the create option: %s is invalid, should be a boolean", value)
+			}
+			if rn.resource != "secret" {
+				return fmt.Errorf("the create option is only supported for 'cn=secret' at this time")
+			}
+			rn.create = choice
+		case optionSize:
+			size, err := strconv.ParseInt(value, 10, 16)
+			if err != nil {
+				return fmt.Errorf("the size option: %s is invalid, should be an integer", value)
+			}
+			rn.size = size
+		case optionExec:
+			rn.execPath = value
+		case optionRequire:
+			rn.secretPolicy().required = splitNames(value)
+		case optionMinLength:
+			length, err := strconv.Atoi(value)
+			if err != nil || length < 0 {
+				return fmt.Errorf("the min-length option: %s is invalid, should be a positive integer", value)
+			}
+			rn.secretPolicy().minLength = length
+		case optionMinEntropy:
+			bits, err := strconv.ParseFloat(value, 64)
+			if err != nil || bits < 0 {
+				return fmt.Errorf("the min-entropy option: %s is invalid, should be a positive number of bits", value)
+			}
+			rn.secretPolicy().minEntropy = bits
+		case optionPGPKey:
+			rn.pgpKey = value
+		case optionOnSuccess:
+			rn.onSuccess = value
+		case optionOnFailure:
+			rn.onFailure = value
+		case optionOnChange:
+			rn.onChange = value
+		case optionFilename:
+			rn.filename = value
+		case optionTemplatePath:
+			rn.templateFile = value
+		case optionMaxRetries:
+			maxRetries, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return fmt.Errorf("the retries option: %s is invalid, should be an integer", value)
+			}
+			rn.maxRetries = int(maxRetries)
+		case optionPatchRegex:
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return fmt.Errorf("the regex option: %s is invalid, error: %s", value, err)
+			}
+			if !hasNamedGroup(re) {
+				return fmt.Errorf("the regex option: %s must have at least one named capture group", value)
+			}
+			rn.patchRegex = re
+		case optionDecode:
+			if value != "base64" {
+				return fmt.Errorf("the decode option: %s is invalid, only base64 is supported", value)
+			}
+			rn.decode = value
+		case optionCommonNames:
+			if rn.resource != "pki" {
+				return fmt.Errorf("the cn option is only supported for 'cn=pki' at this time")
+			}
+			for _, x := range strings.Split(value, ",") {
+				if x = strings.TrimSpace(x); x != "" {
+					commonNames = append(commonNames, x)
 				}
-				if rn.resource != "secret" {
-					return fmt.Errorf("the create option is only supported for 'cn=secret' at this time")
+			}
+		case optionSystemd:
+			rn.systemdUnit = value
+		case optionSystemdAction:
+			if !validSystemdActions[value] {
+				return fmt.Errorf("the systemd-action option: %s is invalid", value)
+			}
+			rn.systemdAction = value
+		case optionReload:
+			if _, found := reloadServers[value]; !found {
+				return fmt.Errorf("the reload option: %s is invalid, should be nginx or haproxy", value)
+			}
+			rn.reloadServer = value
+		case optionReloadPid:
+			rn.reloadPidFile = value
+		case optionReloadCheck:
+			// step: a port alone is the local server, as the separator is usually a colon; localhost is dialed over
+			// both ipv6 and ipv4 so a server listening on either is found
+			if _, err := strconv.ParseUint(value, 10, 16); err == nil {
+				value = net.JoinHostPort("localhost", value)
+			}
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				return fmt.Errorf("the reload-check option: %s is invalid, should be a port or host:port", value)
+			}
+			rn.reloadCheck = value
+		case optionServe:
+			choice, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the serve option: %s is invalid, should be a boolean", value)
+			}
+			if rn.resource != "pki" {
+				return fmt.Errorf("the serve option is only supported for 'cn=pki' at this time")
+			}
+			rn.servePKI = choice
+		case optionNoCache:
+			choice, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the no-cache option: %s is invalid, should be a boolean", value)
+			}
+			rn.noCache = choice
+		case optionVerify:
+			choice, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the verify option: %s is invalid, should be a boolean", value)
+			}
+			if rn.resource != "pki" {
+				return fmt.Errorf("the verify option is only supported for 'cn=pki' at this time")
+			}
+			rn.verify = choice
+		case optionKVVersion:
+			if value != "1" && value != "2" {
+				return fmt.Errorf("the kv option: %s is invalid, should be 1 or 2", value)
+			}
+			if rn.resource != "secret" {
+				return fmt.Errorf("the kv option is only supported for 'cn=secret' at this time")
+			}
+			rn.kvVersion, _ = strconv.Atoi(value)
+		case optionMetaFile:
+			choice, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the meta option: %s is invalid, should be a boolean", value)
+			}
+			rn.metaFile = choice
+		case optionEncryptTo:
+			rn.encryptTo = value
+		case optionValues:
+			for _, x := range strings.Split(value, ",") {
+				if x = strings.TrimSpace(x); x != "" {
+					rn.valuesFiles = append(rn.valuesFiles, x)
 				}
-				rn.create = choice
-			case optionSize:
-				size, err := strconv.ParseInt(value, 10, 16)
-				if err != nil {
-					return fmt.Errorf("the size option: %s is invalid, should be an integer", value)
+			}
+		case optionVault:
+			rn.vault = value
+		case optionDBHost:
+			rn.dbHost = value
+		case optionDBPort:
+			if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+				return fmt.Errorf("the db-port option: %s is invalid, should be a port", value)
+			}
+			rn.dbPort = value
+		case optionDBName:
+			rn.dbName = value
+		case optionNotifyFifo:
+			rn.notifyFifo = value
+		case optionTags:
+			rn.tags = splitTags(value)
+		case optionSection:
+			if err := isValidININame(value); err != nil {
+				return err
+			}
+			rn.section = value
+		case optionDelimiter:
+			delimiter, err := parseCSVDelimiter(value)
+			if err != nil {
+				return err
+			}
+			rn.delimiter = delimiter
+		case optionOCSP:
+			choice, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the ocsp option: %s is invalid, should be a boolean", value)
+			}
+			if rn.resource != "pki" {
+				return fmt.Errorf("the ocsp option is only supported for 'cn=pki' at this time")
+			}
+			rn.ocsp = choice
+		case optionDocuments:
+			choice, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the documents option: %s is invalid, should be a boolean", value)
+			}
+			rn.documents = choice
+		case optionKeystorePassword:
+			rn.keystorePassword = value
+		case optionTimeout:
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("the timeout option: %s is invalid, should be a positive duration", value)
+			}
+			rn.timeout = timeout
+		case optionBackups:
+			backups, err := strconv.Atoi(value)
+			if err != nil || backups < 0 {
+				return fmt.Errorf("the backups option: %s is invalid, should be a positive integer", value)
+			}
+			rn.backups = backups
+		case optionNamespace:
+			rn.namespace = strings.Trim(value, "/")
+		case optionExplode:
+			choice, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the explode option: %s is invalid, should be a boolean", value)
+			}
+			rn.explode = choice
+		case optionKey:
+			if value != keySourceLocal && !strings.HasPrefix(value, pkcs11Scheme) {
+				return fmt.Errorf("the key option: %s is invalid, should be local or a pkcs11: uri", value)
+			}
+			if strings.HasPrefix(value, pkcs11Scheme) {
+				if _, err := parsePKCS11URI(value); err != nil {
+					return err
 				}
-				rn.size = size
-			case optionExec:
-				rn.execPath = value
-			case optionFilename:
-				rn.filename = value
-			case optionTemplatePath:
-				rn.templateFile = value
-			case optionMaxRetries:
-				maxRetries, err := strconv.ParseInt(value, 10, 32)
+			}
+			rn.keySource = value
+		case optionSPIFFEID:
+			if _, err := parseSPIFFEID(value); err != nil {
+				return err
+			}
+			rn.spiffeID = value
+		case optionRotate:
+			choice, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the rotate option: %s is invalid, should be a boolean", value)
+			}
+			rn.rotate = choice
+		case optionWave:
+			wave, err := strconv.ParseInt(value, 10, 16)
+			if err != nil || wave < 0 {
+				return fmt.Errorf("the wave option: %s is invalid, should be a positive integer", value)
+			}
+			rn.wave = int(wave)
+		case optionMethod:
+			if value != genericMethodRead && value != genericMethodWrite {
+				return fmt.Errorf("the method option: %s is invalid, should be %s or %s", value, genericMethodRead, genericMethodWrite)
+			}
+			rn.method = value
+		case optionOut:
+			switch value {
+			case outStdout:
+				rn.stdout = true
+			case outFifo:
+				rn.fifo = true
+			case outMemfd:
+				rn.memfd = true
+			default:
+				return fmt.Errorf("the out option: %s is invalid, should be %s, %s or %s", value, outStdout, outFifo, outMemfd)
+			}
+		case optionKeys:
+			rn.keys = splitNames(value)
+		case optionCAFiles:
+			rn.caFiles = splitNames(value)
+		case optionMerge:
+			if strings.TrimSpace(value) == "" {
+				return fmt.Errorf("the merge option requires a path, '.' for the root of the file")
+			}
+			rn.merge = value
+		case optionFreeze:
+			window, err := parseFreezeWindow(value)
+			if err != nil {
+				return err
+			}
+			rn.freeze = window
+		case optionSELinux:
+			if err := validateSELinuxContext(value); err != nil {
+				return err
+			}
+			rn.selinuxContext = value
+		case optionSELinuxMode:
+			if err := validateSELinuxMode(value); err != nil {
+				return err
+			}
+			rn.selinuxMode = value
+		case optionActiveHours:
+			for _, x := range splitNames(value) {
+				window, err := parseActiveWindow(x)
 				if err != nil {
-					return fmt.Errorf("the retries option: %s is invalid, should be an integer", value)
+					return err
 				}
-				rn.maxRetries = int(maxRetries)
-			case optionMaxJitter:
-				maxJitter, err := time.ParseDuration(value)
+				rn.activeHours = append(rn.activeHours, window)
+			}
+		case optionRevokeSuperseded:
+			choice, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("the revoke-superseded option: %s is invalid, should be a boolean", value)
+			}
+			rn.revokeSuperseded = choice
+		case optionValidate:
+			rn.validate = value
+		case optionStagger:
+			stagger, err := time.ParseDuration(value)
+			if err != nil || stagger < 0 {
+				return fmt.Errorf("the stagger option: %s is invalid, should be in duration format", value)
+			}
+			rn.stagger = stagger
+		case optionMaxJitter:
+			maxJitter, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("the jitter option: %s is invalid, should be in duration format", value)
+			}
+			rn.maxJitter = maxJitter
+		default:
+			if key := strings.TrimPrefix(name, optionMatchPrefix); key != name && key != "" {
+				pattern, err := regexp.Compile(value)
 				if err != nil {
-					return fmt.Errorf("the jitter option: %s is invalid, should be in duration format", value)
+					return fmt.Errorf("the pattern of the key: %s is invalid, error: %s", key, err)
 				}
-				rn.maxJitter = maxJitter
-			default:
-				rn.options[name] = value
+				rn.secretPolicy().patterns[key] = pattern
+				break
 			}
+			rn.options[name] = value
+		}
+	}
+	// step: the health resource polls vault rather than following a lease
+	if rn.resource == "health" && rn.update <= 0 {
+		rn.update = healthUpdateInterval
+	}
+	// step: a trust bundle is checked for a change of its sources, and written as pem unless asked otherwise
+	if rn.resource == "cabundle" {
+		if rn.update <= 0 {
+			rn.update = caBundleUpdateInterval
+		}
+		if !formatSet {
+			rn.format = "txt"
 		}
 	}
+	// step: a file merged into is in the format of its extension unless asked otherwise
+	if rn.merge != "" && !formatSet {
+		rn.format = mergeFormat(rn.GetFilename())
+	}
+	// step: libpq ignores a password file readable by the group or others
+	if rn.format == "pgpass" && !modeSet {
+		rn.fileMode = os.FileMode(0600)
+	}
+
+	// step: expand a list of common names into a resource per certificate
+	if len(commonNames) > 0 {
+		if _, found := rn.options["common_name"]; found {
+			return fmt.Errorf("the cn and common_name options are mutually exclusive")
+		}
+		r.items = append(r.items, expandCommonNames(rn, commonNames)...)
+		return nil
+	}
+
 	// step: append to the list of resources
 	r.items = append(r.items, rn)
 
 	return nil
 }
 
+// expandCommonNames produces a pki resource for each of the common names, the filename can be
+// templated with {cn}, otherwise the common name is appended to the filename
+func expandCommonNames(rn *VaultResource, commonNames []string) []*VaultResource {
+	var list []*VaultResource
+	for _, cn := range commonNames {
+		x := rn.clone()
+		x.options["common_name"] = cn
+		switch {
+		case rn.filename == "":
+			x.filename = cn
+		case strings.Contains(rn.filename, commonNamePlaceholder):
+			x.filename = strings.Replace(rn.filename, commonNamePlaceholder, cn, -1)
+		default:
+			x.filename = fmt.Sprintf("%s.%s", rn.filename, cn)
+		}
+		list = append(list, x)
+	}
+
+	return list
+}
+
+// selectResources returns the resources carrying any of the only tags, all if none are given, less those
+// carrying any of the skip tags
+//	items		: the resources
+//	only		: the tags a resource must have one of
+//	skip		: the tags a resource must have none of
+func selectResources(items []*VaultResource, only, skip []string) []*VaultResource {
+	var list []*VaultResource
+	for _, rn := range items {
+		if len(only) > 0 && !rn.hasTag(only...) {
+			glog.V(3).Infof("resource: %s has none of the tags: %s, skipping", rn, strings.Join(only, ","))
+			continue
+		}
+		if rn.hasTag(skip...) {
+			glog.V(3).Infof("resource: %s has one of the tags: %s, skipping", rn, strings.Join(skip, ","))
+			continue
+		}
+		list = append(list, rn)
+	}
+
+	return list
+}
+
+// splitTags splits a comma separated list of tags, ignoring any empty ones
+func splitTags(value string) []string {
+	var list []string
+	for _, x := range strings.Split(value, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			list = append(list, x)
+		}
+	}
+
+	return list
+}
+
+// collect parses the resource, recording rather than returning an error so every mistake can be reported together
+//	origin		: where the resource was given i.e. the annotation
+//	value		: the resource
+func (r *VaultResources) collect(origin, value string) {
+	r.given++
+	count := len(r.items)
+	if err := r.Set(value); err != nil {
+		r.invalid = append(r.invalid, resourceError{origin: origin, position: r.given, value: value, err: err})
+		return
+	}
+	for _, rn := range r.items[count:] {
+		rn.origin = origin
+		rn.position = r.given
+	}
+}
+
+// validate checks all the resources, returning the errors of every resource which failed to parse or is invalid,
+// in the order they were given
+//	cfg			: the options the resources are used with, nil to check the resources alone
+func (r *VaultResources) validate(cfg *config) error {
+	list := append(resourceErrors{}, r.invalid...)
+	for i, rn := range r.items {
+		err := rn.IsValid()
+		if err == nil && cfg != nil {
+			err = rn.isValidWith(cfg)
+		}
+		if err != nil {
+			origin := rn.origin
+			if origin == "" {
+				origin = fmt.Sprintf("resource #%d", i+1)
+			}
+			list = append(list, resourceError{origin: origin, position: rn.position, err: err})
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].position < list[j].position
+	})
+
+	return list
+}
+
 // String returns a string representation of the struct
 func (r VaultResources) String() string {
 	return ""
@@ -35,12 +35,174 @@ func TestSetResources(t *testing.T) {
 	assert.Nil(t, items.Set("pki:example-dot-com:common_name=blah.example.com,file=/etc/certs/ssl/blah.example.com"))
 	assert.Nil(t, items.Set("pki:example-dot-com:common_name=blah.example.com,renew=true"))
 	assert.Nil(t, items.Set("secret:secrets/${ENV}/me:file=filename.test,fmt=yaml"))
+	assert.Nil(t, items.Set("secret:test:file=app.conf,fmt=patch,regex=password (?P<password>.*)"))
+	assert.Nil(t, items.Set("secret:test:file=keystore.jks,fmt=binary,decode=base64"))
+	assert.Nil(t, items.Set("pki:pki/issue/web:common_name=web.example.com,fmt=bundle,systemd=nginx.service,systemd-action=reload"))
+	assert.Nil(t, items.Set("secret:test:no-cache=true"))
+	assert.Nil(t, items.Set("secret:secret/myapp:kv=2,update=1m"))
+	assert.Nil(t, items.Set("mysql:mysql/creds/app:meta=true"))
+	assert.Nil(t, items.Set("secret:secret/app:fmt=json,encrypt-to=age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"))
+	assert.Nil(t, items.Set("pki:pki/issue/web:common_name=web.example.com,verify=true,retries=3"))
+	assert.Nil(t, items.Set("pki:pki/issue/web:common_name=web.example.com,update=24h,stagger=30m"))
+	assert.Nil(t, items.Set("pki:pki/issue/web:common_name=web.example.com,fmt=bundle,reload=haproxy,reload-check=8443"))
+	assert.Equal(t, "localhost:8443", items.items[len(items.items)-1].reloadCheck)
 
 	assert.NotNil(t, items.Set("secret:"))
 	assert.NotNil(t, items.Set("secret:test:file=filename.test,fmt="))
 	assert.NotNil(t, items.Set("secret::file=filename.test,fmt=yaml"))
 	assert.NotNil(t, items.Set("secret:te1st:file=filename.test,fmt="))
 	assert.NotNil(t, items.Set("file=filename.test,fmt=yaml"))
+	assert.NotNil(t, items.Set("secret:test:fmt=patch,regex=password (.*)"))
+	assert.NotNil(t, items.Set("secret:test:fmt=binary,decode=hex"))
+	assert.NotNil(t, items.Set("secret:test:systemd=nginx.service,systemd-action=bounce"))
+	assert.NotNil(t, items.Set("secret:test:no-cache=maybe"))
+	assert.NotNil(t, items.Set("secret:test:verify=true"))
+	assert.NotNil(t, items.Set("secret:test:kv=3"))
+	assert.NotNil(t, items.Set("secret:test:fmt=csv,delimiter=ab"))
+	assert.NotNil(t, items.Set("secret:test:fmt=ini,section=a]b"))
+	assert.NotNil(t, items.Set("secret:test:meta=yes"))
+	assert.NotNil(t, items.Set("aws:aws/creds/app:kv=2"))
+	assert.NotNil(t, items.Set("pki:pki/issue/web:common_name=web.example.com,reload=apache"))
+	assert.NotNil(t, items.Set("pki:pki/issue/web:common_name=web.example.com,stagger=soon"))
+	assert.NotNil(t, items.Set("pki:pki/issue/web:common_name=web.example.com,reload=nginx,reload-check=localhost"))
+}
+
+func TestSetCommonNames(t *testing.T) {
+	cases := []struct {
+		ResourceText string
+		Filenames    []string
+	}{
+		{
+			ResourceText: "pki:pki/issue/web:cn=a.example.com|b.example.com,fmt=bundle",
+			Filenames:    []string{"a.example.com", "b.example.com"},
+		},
+		{
+			ResourceText: "pki:pki/issue/web:cn=a.example.com|b.example.com,file=/etc/certs/{cn}/tls",
+			Filenames:    []string{"/etc/certs/a.example.com/tls", "/etc/certs/b.example.com/tls"},
+		},
+		{
+			ResourceText: "pki:pki/issue/web:cn=a.example.com|b.example.com,file=tls",
+			Filenames:    []string{"tls.a.example.com", "tls.b.example.com"},
+		},
+	}
+	for i, c := range cases {
+		var resource VaultResources
+		if !assert.NoError(t, resource.Set(c.ResourceText), "case %d, should not have failed", i) {
+			continue
+		}
+		if !assert.Len(t, resource.items, len(c.Filenames), "case %d, unexpected number of resources", i) {
+			continue
+		}
+		for j, x := range resource.items {
+			assert.Equal(t, c.Filenames[j], x.filename, "case %d, unexpected filename", i)
+		}
+		assert.Equal(t, "a.example.com", resource.items[0].options["common_name"], "case %d", i)
+		assert.Equal(t, "b.example.com", resource.items[1].options["common_name"], "case %d", i)
+	}
+
+	var resource VaultResources
+	assert.Error(t, resource.Set("secret:secret/app:cn=a.example.com"))
+	assert.Error(t, resource.Set("pki:pki/issue/web:cn=a.example.com,common_name=b.example.com"))
+}
+
+func TestSelectResources(t *testing.T) {
+	var resources VaultResources
+	for _, x := range []string{
+		"secret:secret/bootstrap:tags=bootstrap",
+		"pki:pki/issue/web:common_name=web.example.com,tags=tls|bootstrap",
+		"pki:pki/issue/api:common_name=api.example.com,tags= tls ",
+		"secret:secret/app",
+	} {
+		if !assert.NoError(t, resources.Set(x)) {
+			return
+		}
+	}
+	assert.Equal(t, []string{"tls", "bootstrap"}, resources.items[1].tags)
+	assert.Equal(t, []string{"tls"}, resources.items[2].tags)
+
+	cases := []struct {
+		Only     string
+		Skip     string
+		Expected []string
+	}{
+		{Expected: []string{"secret/bootstrap", "pki/issue/web", "pki/issue/api", "secret/app"}},
+		{Only: "bootstrap", Expected: []string{"secret/bootstrap", "pki/issue/web"}},
+		{Only: "tls,bootstrap", Expected: []string{"secret/bootstrap", "pki/issue/web", "pki/issue/api"}},
+		{Skip: "bootstrap", Expected: []string{"pki/issue/api", "secret/app"}},
+		{Only: "tls", Skip: "bootstrap", Expected: []string{"pki/issue/api"}},
+		{Only: "missing"},
+	}
+	for i, c := range cases {
+		var paths []string
+		for _, rn := range selectResources(resources.items, splitTags(c.Only), splitTags(c.Skip)) {
+			paths = append(paths, rn.path)
+		}
+		assert.Equal(t, c.Expected, paths, "case %d, unexpected resources", i)
+	}
+}
+
+func TestValidateResources(t *testing.T) {
+	var resources VaultResources
+	flag := &resourceFlag{resources: &resources}
+	for _, x := range []string{
+		"secret:secret/app:fmt=json",
+		"pki:pki/issue/web:fmt=bad",
+		"pki:pki/issue/api",
+		"secret:secret/db",
+		"missing:path",
+	} {
+		assert.NoError(t, flag.Set(x))
+	}
+	assert.Equal(t, 4, len(resources.items))
+
+	// step: every invalid resource is reported, in the order given
+	err := resources.validate(nil)
+	if !assert.Error(t, err) {
+		t.FailNow()
+	}
+	list, ok := err.(resourceErrors)
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	var origins []string
+	for _, x := range list {
+		origins = append(origins, x.origin)
+	}
+	assert.Equal(t, []string{"-cn #2", "-cn #3", "-cn #5"}, origins)
+	assert.Contains(t, err.Error(), "found 3 invalid resources")
+	assert.Contains(t, err.Error(), `-cn #2 "pki:pki/issue/web:fmt=bad": unsupported output format: bad`)
+	assert.Contains(t, err.Error(), "-cn #3: invalid resource: ")
+	assert.Contains(t, err.Error(), "-cn #5: unsupported resource type: missing")
+
+	resources = VaultResources{}
+	resources.collect("annotation vault.sidekick/resource.1", "pki:pki/issue/web:cn=a.example.com|b.example.com")
+	assert.NoError(t, resources.validate(nil))
+	for _, rn := range resources.items {
+		assert.Equal(t, "annotation vault.sidekick/resource.1", rn.origin)
+	}
+}
+
+func TestValidateResourcesWithOptions(t *testing.T) {
+	var resources VaultResources
+	resources.collect("-cn #1", "secret:secret/app:freeze=2026-12-20/2027-01-04")
+	resources.collect("-cn #2", "aws:aws/creds/app:active-hours='09:00-17:00'")
+	resources.collect("-cn #3", "pki:pki/issue/web:fmt=bad")
+	resources.collect("-cn #4", "secret:secret/db")
+
+	// step: the resources which can't be used with the options are reported along with the invalid ones
+	err := validateOptions(&config{vaultURL: "http://127.0.0.1:8200", oneShot: true, resources: &resources})
+	list, ok := err.(resourceErrors)
+	if !assert.True(t, ok, "unexpected error: %v", err) {
+		t.FailNow()
+	}
+	var origins []string
+	for _, x := range list {
+		origins = append(origins, x.origin)
+	}
+	assert.Equal(t, []string{"-cn #1", "-cn #2", "-cn #3"}, origins)
+	assert.Contains(t, err.Error(), "has a freeze window, which can't be used with one-shot")
+	assert.Contains(t, err.Error(), "has active hours, which can't be used with one-shot")
+	assert.NoError(t, resources.items[0].IsValid())
 }
 
 func TestSetEnvironmentResource(t *testing.T) {
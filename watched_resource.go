@@ -17,6 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
@@ -35,41 +40,175 @@ type watchedResource struct {
 	resource *VaultResource
 	// the last time the resource was retrieved
 	lastUpdated time.Time
+	// the time the secret was issued
+	issued time.Time
+	// the number of times the lease has been renewed since it was issued
+	renewals int
+	// where the secret was issued from, vault, standby, cache or state
+	source string
+	// where the secret being retrieved is coming from, the source once retrieved
+	fetching string
+	// the serial number of the certificate last issued, empty if none
+	serial string
 	// the time which the lease expires
 	leaseExpireTime time.Time
 	// the duration until we next time to renew lease
 	renewalTime time.Duration
 	// the secret
 	secret *api.Secret
+	// the version of a kv v2 secret
+	version int
+	// deleted or destroyed when the current version of a kv v2 secret has been removed upstream
+	deleted string
+	// whether the pki role has been checked
+	roleChecked bool
+	// the max ttl of the pki role, zero if unknown
+	roleMaxTTL time.Duration
+	// the hashes of the values of the secret, used to log the changes
+	hashes map[string]string
+	// the last status of vault polled by a health resource
+	health *vaultStatus
+	// the sha256 of the trust bundle last written by a cabundle resource
+	bundleSum *[sha256.Size]byte
+	// the digest of the password of a keystore, used to spot a rotation
+	keystoreDigest string
+	// the time the certificate of a keystore is due to be reissued
+	reissueTime time.Time
+	// the resource is outside its active hours, its retrieval deferred until they open
+	inactive bool
+	// the resource is no longer watched, the timers firing later are ignored
+	dropped bool
+}
+
+// LeaseID returns the id of the lease of the resource, as renewed by the renewer
+func (r *watchedResource) LeaseID() string {
+	return r.secret.LeaseID
+}
+
+// LeaseExpires returns the time the lease of the resource expires
+func (r *watchedResource) LeaseExpires() time.Time {
+	return r.leaseExpireTime
+}
+
+// LeaseRenewable checks if the lease of the resource can be renewed
+func (r *watchedResource) LeaseRenewable() bool {
+	return r.secret.Renewable
+}
+
+// adopt takes on the state of a copy of the resource retrieved in the background, so the resource itself is only
+// ever changed by the service processor
+func (r *watchedResource) adopt(staged *watchedResource) {
+	dropped := r.dropped
+	*r = *staged
+	r.dropped = dropped
 }
 
 // notifyOnRenewal creates a trigger and notifies when a resource is up for renewal
 func (r *watchedResource) notifyOnRenewal(ch chan *watchedResource) {
-	go func() {
-		// step: check if the resource has a pre-configured renewal time
-		r.renewalTime = r.resource.update
-		// step: if the answer is no, we set the notification between 80-95% of the lease time of the secret
-		if r.renewalTime <= 0 {
-			// if there is no lease time, we canout set a renewal, just fade into the background
-			if r.secret.LeaseDuration <= 0 {
-				glog.Warningf("resource: %s has no lease duration, no custom update set, so item will not be updated", r.resource.path)
-				return
-			}
-			r.renewalTime = r.calculateRenewal()
+	// step: check if the resource has a pre-configured renewal time
+	r.renewalTime = r.resource.update
+	reasons := []string{fmt.Sprintf("the update option of %s", r.resource.update)}
+	// step: the credentials of a static role are read again just after vault rotates the password
+	if r.renewalTime <= 0 && r.resource.isStaticRole() {
+		if ttl := staticRoleTTL(r.secret); ttl > 0 {
+			r.renewalTime = ttl + staticRotationDelay
+			reasons[0] = fmt.Sprintf("the rotation of the static role in %s", ttl)
+		}
+	}
+	// step: if the answer is no, we set the notification between 80-95% of the lease time of the secret
+	if r.renewalTime <= 0 {
+		// if there is no lease time, we canout set a renewal, just fade into the background
+		if r.secret.LeaseDuration <= 0 {
+			glog.Warningf("resource: %s has no lease duration, no custom update set, so item will not be updated", r.resource.path)
+			schedule.set(r.resource, scheduleNone, 0, "the secret has no lease duration and no update option is set", time.Time{})
+			return
+		}
+		r.renewalTime = r.calculateRenewal()
+		reasons[0] = fmt.Sprintf("%.0f-%.0f%% of the lease ttl of %s", renewalMinimum*100, renewalMaximum*100,
+			time.Duration(r.secret.LeaseDuration)*time.Second)
+		if r.resource.resource == "pki" {
+			reasons[0] = fmt.Sprintf("%.0f-%.0f%% of the certificate ttl of %s", renewalMinimum*100, renewalMaximum*100,
+				time.Duration(r.secret.LeaseDuration)*time.Second)
+		}
+		// step: a staggered resource renews at a fixed point of the lease, so the order of the pods holds
+		if r.resource.stagger > 0 {
+			r.renewalTime = time.Duration(float64(r.secret.LeaseDuration)*renewalMaximum) * time.Second
 		}
-		if r.resource.maxJitter != 0 {
-			glog.V(4).Infof("using maxJitter (%s) to calculate renewal time", r.resource.maxJitter)
-			r.renewalTime = time.Duration(getDurationWithin(
-				int((r.renewalTime-r.resource.maxJitter)/time.Second),
-				int(r.renewalTime/time.Second),
-			))
+	}
+	if r.resource.stagger > 0 {
+		r.renewalTime = staggerRenewal(r.renewalTime, r.resource.stagger, podName())
+		reasons = append(reasons, fmt.Sprintf("staggered within %s", r.resource.stagger))
+		glog.V(4).Infof("using stagger (%s) to calculate renewal time", r.resource.stagger)
+	}
+	if r.resource.maxJitter != 0 {
+		glog.V(4).Infof("using maxJitter (%s) to calculate renewal time", r.resource.maxJitter)
+		r.renewalTime = time.Duration(getDurationWithin(
+			int((r.renewalTime-r.resource.maxJitter)/time.Second),
+			int(r.renewalTime/time.Second),
+		))
+		reasons = append(reasons, fmt.Sprintf("jittered by up to %s", r.resource.maxJitter))
+	}
+	// step: a keystore is checked for a rotation of the password more often than the certificate is reissued
+	if r.resource.keystorePassword != "" && r.renewalTime > keystoreCheckInterval {
+		r.renewalTime = keystoreCheckInterval
+		reasons = []string{fmt.Sprintf("checking the keystore password every %s", keystoreCheckInterval)}
+	}
+	// step: a lease which can't be renewed must be read again before it expires, whatever the update
+	if r.secret.LeaseID != "" && !r.secret.Renewable && !r.leaseExpireTime.IsZero() {
+		if limit := r.beforeExpiry(); r.renewalTime > limit {
+			glog.V(3).Infof("resource: %s has a lease which can't be renewed expiring at: %s, reading it again in: %s",
+				r.resource, formatTime(r.leaseExpireTime), formatDuration(limit))
+			r.renewalTime = limit
+			reasons = []string{"the lease can't be renewed and must be read again before it expires"}
 		}
-		glog.V(3).Infof("setting a renewal notification on resource: %s, time: %s", r.resource, r.renewalTime)
+	}
+	schedule.set(r.resource, r.nextAction(), r.renewalTime, strings.Join(reasons, ", "), r.leaseExpireTime)
+
+	glog.V(3).Infof("setting a renewal notification on resource: %s, time: %s", r.resource, formatDuration(r.renewalTime))
+	go func(renewal time.Duration) {
 		// step: wait for the duration
-		<-time.After(r.renewalTime)
+		<-time.After(renewal)
 		// step: send the notification on the renewal channel
 		ch <- r
-	}()
+	}(r.renewalTime)
+}
+
+// nextAction returns whether the lease of the secret will be renewed at the renewal, or the secret retrieved again
+func (r watchedResource) nextAction() string {
+	if r.resource.renewable && r.secret.LeaseID != "" && r.secret.Renewable &&
+		time.Now().Add(r.renewalTime).Before(r.leaseExpireTime) {
+		return scheduleRenew
+	}
+
+	return scheduleFetch
+}
+
+// beforeExpiry returns a time between 80-95% of the time remaining on the lease, zero if it has expired
+func (r watchedResource) beforeExpiry() time.Duration {
+	remaining := r.leaseExpireTime.Sub(time.Now())
+	if remaining <= 0 {
+		return 0
+	}
+
+	return time.Duration(float64(remaining) * (renewalMinimum + rand.Float64()*(renewalMaximum-renewalMinimum)))
+}
+
+// staggerRenewal brings the renewal forward by up to the window, by an amount derived from the identity of the
+// pod, so the replicas of a deployment rotate one after another in the same order on every renewal
+//	renewal		: the time until the renewal
+//	window		: the window the renewals of the replicas are spread over
+//	identity	: the identity of the pod i.e. its name
+func staggerRenewal(renewal, window time.Duration, identity string) time.Duration {
+	if window > renewal {
+		window = renewal
+	}
+	if window <= 0 {
+		return renewal
+	}
+	sum := sha256.Sum256([]byte(identity))
+	offset := time.Duration(binary.BigEndian.Uint64(sum[:8]) % uint64(window))
+
+	return renewal - window + offset
 }
 
 // calculateRenewal calculate the renewal between
@@ -0,0 +1,69 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBeforeExpiry(t *testing.T) {
+	x := &watchedResource{leaseExpireTime: time.Now().Add(100 * time.Second)}
+	for i := 0; i < 10; i++ {
+		d := x.beforeExpiry()
+		assert.True(t, d >= 79*time.Second && d <= 95*time.Second, "unexpected duration: %s", d)
+	}
+	x.leaseExpireTime = time.Now().Add(-time.Second)
+	assert.Equal(t, time.Duration(0), x.beforeExpiry())
+}
+
+func TestStaggerRenewal(t *testing.T) {
+	renewal, window := time.Hour, 10*time.Minute
+	seen := make(map[time.Duration]bool, 0)
+	for _, pod := range []string{"web-0", "web-1", "web-2", "web-3"} {
+		d := staggerRenewal(renewal, window, pod)
+		assert.True(t, d > renewal-window && d <= renewal, "pod: %s, unexpected renewal: %s", pod, d)
+		assert.Equal(t, d, staggerRenewal(renewal, window, pod), "pod: %s, the renewal should be deterministic", pod)
+		seen[d] = true
+	}
+	assert.Len(t, seen, 4)
+
+	// step: the window is bounded by the renewal
+	d := staggerRenewal(time.Minute, time.Hour, "web-0")
+	assert.True(t, d >= 0 && d < time.Minute, "unexpected renewal: %s", d)
+	assert.Equal(t, time.Minute, staggerRenewal(time.Minute, 0, "web-0"))
+}
+
+func TestNotifyOnRenewalNotRenewable(t *testing.T) {
+	rn := defaultVaultResource()
+	rn.update = time.Hour
+	x := &watchedResource{
+		resource:        rn,
+		secret:          &api.Secret{LeaseID: "aws/creds/app/1", LeaseDuration: 1},
+		leaseExpireTime: time.Now().Add(time.Second),
+	}
+	ch := make(chan *watchedResource, 1)
+	x.notifyOnRenewal(ch)
+	select {
+	case <-ch:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the lease should have been read again before it expired")
+	}
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// defaultListPageSize is the number of keys asked for in each page of a listing
+	defaultListPageSize = 1000
+	// defaultListConcurrency is the number of children read at once
+	defaultListConcurrency = 8
+	// defaultListMaxChildren is the number of children beyond which the update of a wildcard resource fails
+	defaultListMaxChildren = 10000
+	// listBackoffAttempts is the number of attempts at reading a child while vault is throttling
+	listBackoffAttempts = 5
+	// listBackoffInitial is the first wait before reading a throttled child again, doubling each attempt
+	listBackoffInitial = time.Duration(500) * time.Millisecond
+	// listBackoffMaximum is the longest wait before reading a throttled child again
+	listBackoffMaximum = time.Duration(10) * time.Second
+	// wildcardSuffix marks a resource path expanded into the children of the path
+	wildcardSuffix = "/*"
+	// keyPlaceholder is replaced in the filename by the key of the child
+	keyPlaceholder = "{key}"
+)
+
+// getWildcard lists the children of the path a page at a time and reads each page of them, a bounded number
+// at once; the data of the secret is a map of the child key to the data of the child. As the children are held
+// until written, a listing of more than -list-max-children fails rather than growing without bound.
+// Sub-directories are not expanded.
+//	rn			: the watched resource
+func (r VaultService) getWildcard(rn *watchedResource) (*api.Secret, error) {
+	base := strings.TrimSuffix(rn.resource.path, wildcardSuffix)
+	secret := &api.Secret{Data: make(map[string]interface{}, 0)}
+	after, pages, children := "", 0, 0
+	for {
+		keys, err := r.listPage(rn, base, after, options.listPageSize)
+		if err != nil {
+			return nil, err
+		}
+		if keys == nil && pages == 0 {
+			glog.Warningf("resource: %s has no children", rn.resource)
+			return secret, nil
+		}
+		pages++
+		// step: a vault ignoring the pagination returns the keys already read, which ends the listing
+		var page []string
+		for _, key := range keys {
+			if (after == "" || key > after) && !strings.HasSuffix(key, "/") {
+				page = append(page, key)
+			}
+		}
+		if children += len(page); options.listMaxChildren > 0 && children > options.listMaxChildren {
+			return nil, fmt.Errorf("the resource has more than %d children, the limit of -list-max-children", options.listMaxChildren)
+		}
+		if err := r.readChildren(rn, base, page, secret); err != nil {
+			return nil, err
+		}
+		if options.listPageSize <= 0 || len(keys) < options.listPageSize || len(keys) == 0 || keys[len(keys)-1] <= after {
+			break
+		}
+		after = keys[len(keys)-1]
+	}
+	glog.V(4).Infof("resource: %s expanded into %d children over %d pages", rn.resource, len(secret.Data), pages)
+
+	return secret, nil
+}
+
+// listPage lists a page of the keys under a path, nil if the path doesn't exist; the after and limit
+// parameters are honoured by a vault supporting paginated listings, an older vault returning every key
+//	rn			: the watched resource
+//	base		: the path listed
+//	after		: the key the page starts after, the first page if empty
+//	limit		: the number of keys in the page, unlimited if zero
+func (r VaultService) listPage(rn *watchedResource, base, after string, limit int) ([]string, error) {
+	client := r.reader(rn)
+	req := client.NewRequest("GET", "/v1/"+base)
+	req.Params.Set("list", "true")
+	if limit > 0 {
+		req.Params.Set("limit", strconv.Itoa(limit))
+		if after != "" {
+			req.Params.Set("after", after)
+		}
+	}
+	resp, err := client.RawRequest(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list, err := api.ParseSecret(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	if list != nil {
+		values, _ := list.Data["keys"].([]interface{})
+		for _, x := range values {
+			keys = append(keys, fmt.Sprintf("%v", x))
+		}
+	}
+
+	return keys, nil
+}
+
+// readChildren reads the children of a page, -list-concurrency at a time, adding them to the secret; the
+// lease of the set is the shortest lease of the children
+//	rn			: the watched resource
+//	base		: the path listed
+//	keys		: the keys of the children
+//	secret		: the secret the children are added to
+func (r VaultService) readChildren(rn *watchedResource, base string, keys []string, secret *api.Secret) error {
+	workers := options.listConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var failure error
+	slots := make(chan struct{}, workers)
+	for _, key := range keys {
+		lock.Lock()
+		failed := failure != nil
+		lock.Unlock()
+		if failed {
+			break
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(key string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			// step: each read records its source on its own resource, so the reads don't race
+			x := &watchedResource{resource: rn.resource}
+			child, err := r.readChild(x, base+"/"+key)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if failure == nil {
+					failure = fmt.Errorf("unable to read the child: %s, error: %s", key, err)
+				}
+				return
+			}
+			if x.fetching != "" && rn.fetching != sourceStandby {
+				rn.fetching = x.fetching
+			}
+			if child == nil {
+				return
+			}
+			secret.Data[key] = child.Data
+			if secret.LeaseDuration == 0 || (child.LeaseDuration > 0 && child.LeaseDuration < secret.LeaseDuration) {
+				secret.LeaseDuration = child.LeaseDuration
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	return failure
+}
+
+// readChild reads a child of a wildcard resource, backing off and trying again while vault is throttling
+// the requests, so a large listing slows down rather than failing
+//	rn			: the watched resource
+//	path		: the path of the child
+func (r VaultService) readChild(rn *watchedResource, path string) (*api.Secret, error) {
+	delay := listBackoffInitial
+	for attempt := 1; ; attempt++ {
+		secret, err := r.read(rn, path)
+		if err == nil || !isThrottled(err) || attempt >= listBackoffAttempts {
+			return secret, err
+		}
+		glog.V(3).Infof("the read of: %s was throttled, attempt: %d, backing off for %s", path, attempt, delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > listBackoffMaximum {
+			delay = listBackoffMaximum
+		}
+	}
+}
+
+// isThrottled checks if a request was refused by a rate limit quota or an overloaded vault
+func isThrottled(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "Code: 429") || strings.Contains(err.Error(), "Code: 503"))
+}
+
+// writeWildcardFiles writes a file per child of a wildcard resource, removing the files of
+// children which no longer exist
+//	rn			: the wildcard resource
+//	data		: a map of the child key to the data of the child
+func writeWildcardFiles(rn *VaultResource, data map[string]interface{}) error {
+	written := make(map[string]bool, 0)
+	keys := getKeys(data)
+	for _, key := range keys {
+		child, found := data[key].(map[string]interface{})
+		if !found {
+			continue
+		}
+		filename := resolveFilename(rn.wildcardFilename(key))
+		if err := writeResourceFile(rn, filename, child); err != nil {
+			return fmt.Errorf("unable to write the child: %s, error: %s", key, err)
+		}
+		written[filename] = true
+	}
+
+	// step: remove the files of any children which have been removed
+	for filename := range rn.wildcardFiles {
+		if written[filename] {
+			continue
+		}
+		glog.Infof("resource: %s no longer has the child file: %s, removing", rn, filename)
+		if options.dryRun {
+			continue
+		}
+		if guard != nil {
+			guard.forget(filename)
+		}
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			glog.Errorf("failed to remove the file: %s, error: %s", filename, err)
+		}
+	}
+	rn.wildcardFiles = written
+
+	return nil
+}
+
+// wildcardDirectory returns the directory the children of a wildcard resource are written to
+func wildcardDirectory(rn *VaultResource) string {
+	return filepath.Dir(resolveFilename(rn.wildcardFilename(keyPlaceholder)))
+}
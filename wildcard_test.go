@@ -0,0 +1,177 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// newListingVault serves a listing of the children, paginated if paged, throttling the first read of
+// the throttled child and recording the listings and the most reads in flight at once
+func newListingVault(t *testing.T, children []string, paged bool, throttled string) (*VaultService, *[]string, *int32) {
+	var lock sync.Mutex
+	var listings []string
+	var inflight, most int32
+	refused := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+		if r.URL.Query().Get("list") == "true" {
+			lock.Lock()
+			listings = append(listings, r.URL.RawQuery)
+			lock.Unlock()
+			keys := append([]string{"nested/"}, children...)
+			sort.Strings(keys)
+			if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); paged && err == nil {
+				var page []string
+				for _, key := range keys {
+					if key > r.URL.Query().Get("after") && len(page) < limit {
+						page = append(page, key)
+					}
+				}
+				keys = page
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+			return
+		}
+		current := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		lock.Lock()
+		if current > most {
+			most = current
+		}
+		throttle := strings.HasSuffix(path, "/"+throttled) && !refused
+		if throttle {
+			refused = true
+		}
+		lock.Unlock()
+		if throttle {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"rate limit quota exceeded"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"lease_duration": 60, "data": map[string]interface{}{"value": path}})
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	client.SetMaxRetries(0)
+
+	return &VaultService{client: client}, &listings, &most
+}
+
+func TestGetWildcardPaginated(t *testing.T) {
+	defer func(size, workers int) { options.listPageSize, options.listConcurrency = size, workers }(options.listPageSize, options.listConcurrency)
+	options.listPageSize, options.listConcurrency = 2, 2
+
+	var children []string
+	for i := 0; i < 5; i++ {
+		children = append(children, fmt.Sprintf("child%d", i))
+	}
+	service, listings, most := newListingVault(t, children, true, "child3")
+
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/flags/*"))
+	secret, err := service.getWildcard(&watchedResource{resource: resources.items[0]})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Len(t, secret.Data, 5)
+	assert.Equal(t, map[string]interface{}{"value": "secret/flags/child3"}, secret.Data["child3"])
+	assert.Equal(t, 60, secret.LeaseDuration)
+	assert.Equal(t, []string{
+		"limit=2&list=true",
+		"after=child1&limit=2&list=true",
+		"after=child3&limit=2&list=true",
+		"after=nested%2F&limit=2&list=true",
+	}, *listings)
+	assert.True(t, *most <= 2)
+}
+
+func TestGetWildcardUnpaginated(t *testing.T) {
+	defer func(size int) { options.listPageSize = size }(options.listPageSize)
+	options.listPageSize = 2
+
+	// step: a vault ignoring the pagination returns every key, the second listing ends it
+	service, listings, _ := newListingVault(t, []string{"a", "b", "c"}, false, "")
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/flags/*"))
+	secret, err := service.getWildcard(&watchedResource{resource: resources.items[0]})
+	if assert.NoError(t, err) {
+		assert.Len(t, secret.Data, 3)
+	}
+	assert.Len(t, *listings, 2)
+
+	// step: without a page size the path is listed once
+	options.listPageSize = 0
+	*listings = nil
+	secret, err = service.getWildcard(&watchedResource{resource: resources.items[0]})
+	if assert.NoError(t, err) {
+		assert.Len(t, secret.Data, 3)
+	}
+	assert.Equal(t, []string{"list=true"}, *listings)
+}
+
+func TestGetWildcardMaxChildren(t *testing.T) {
+	defer func(size, most int) { options.listPageSize, options.listMaxChildren = size, most }(options.listPageSize, options.listMaxChildren)
+	options.listPageSize, options.listMaxChildren = 2, 3
+
+	// step: the listing fails once it grows past the limit, before the children beyond it are read
+	service, listings, _ := newListingVault(t, []string{"a", "b", "c", "d", "e"}, true, "")
+	var resources VaultResources
+	assert.NoError(t, resources.Set("secret:secret/flags/*"))
+	_, err := service.getWildcard(&watchedResource{resource: resources.items[0]})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "more than 3 children")
+	}
+	assert.Len(t, *listings, 2)
+
+	// step: unlimited if zero
+	options.listMaxChildren = 0
+	secret, err := service.getWildcard(&watchedResource{resource: resources.items[0]})
+	if assert.NoError(t, err) {
+		assert.Len(t, secret.Data, 5)
+	}
+}
+
+func TestIsThrottled(t *testing.T) {
+	assert.False(t, isThrottled(nil))
+	assert.True(t, isThrottled(errors.New("Error making API request.\n\nCode: 503. Errors:\n\n* rate limit quota exceeded")))
+	assert.False(t, isThrottled(errors.New("Code: 403. Errors:\n\n* permission denied")))
+}
+
+func TestListOptionsValid(t *testing.T) {
+	assert.Error(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", listPageSize: -1}))
+	assert.Error(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", listConcurrency: -1}))
+	assert.Error(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", listMaxChildren: -1}))
+	assert.NoError(t, validateOptions(&config{vaultURL: "http://127.0.0.1:8200", listPageSize: 100, listConcurrency: 4}))
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	// yamlPlainRegex matches the strings safe to write unquoted, anything starting with a digit, sign or
+	// indicator is quoted so it isn't read back as a number, date or time
+	yamlPlainRegex = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_./@-]*$`)
+	// yamlReserved are the words a yaml 1.1 reader takes as a boolean or null
+	yamlReserved = map[string]bool{
+		"y": true, "yes": true, "n": true, "no": true, "on": true, "off": true,
+		"true": true, "false": true, "null": true,
+	}
+)
+
+// encodeYAML encodes the data as yaml with the keys in order; unlike the yaml library strings which look like
+// a number, boolean, date or time are always quoted and numbers decoded from vault are written as numbers
+//	data		: the data to encode
+func encodeYAML(data map[string]interface{}) []byte {
+	var b bytes.Buffer
+	if len(data) == 0 {
+		b.WriteString("{}\n")
+		return b.Bytes()
+	}
+	writeYAMLMap(&b, data, 0)
+
+	return b.Bytes()
+}
+
+// writeYAMLMap writes the keys of a map in order at the indentation given
+func writeYAMLMap(b *bytes.Buffer, data map[string]interface{}, indent int) {
+	var keys []string
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// step: the first key of a map within a list follows the dash
+		if b.Len() == 0 || b.Bytes()[b.Len()-1] == '\n' {
+			b.WriteString(strings.Repeat(" ", indent))
+		}
+		b.WriteString(yamlScalar(k))
+		b.WriteString(":")
+		writeYAMLValue(b, data[k], indent)
+	}
+}
+
+// writeYAMLValue writes a value following a key or dash, nested maps and lists on the following lines
+func writeYAMLValue(b *bytes.Buffer, value interface{}, indent int) {
+	switch x := value.(type) {
+	case map[string]interface{}:
+		if len(x) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		b.WriteString("\n")
+		writeYAMLMap(b, x, indent+2)
+	case []interface{}:
+		if len(x) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		b.WriteString("\n")
+		for _, item := range x {
+			b.WriteString(strings.Repeat(" ", indent) + "-")
+			if nested, ok := item.(map[string]interface{}); ok && len(nested) > 0 {
+				b.WriteString(" ")
+				writeYAMLMap(b, nested, indent+2)
+				continue
+			}
+			writeYAMLValue(b, item, indent+2)
+		}
+	case string:
+		if isYAMLBlock(x) {
+			writeYAMLBlock(b, x, indent+2)
+			return
+		}
+		b.WriteString(" " + yamlScalar(x) + "\n")
+	default:
+		b.WriteString(" " + yamlScalar(x) + "\n")
+	}
+}
+
+// writeYAMLBlock writes a multi-line string as a literal block, i.e. a certificate
+func writeYAMLBlock(b *bytes.Buffer, value string, indent int) {
+	indicator := "|"
+	switch {
+	case !strings.HasSuffix(value, "\n"):
+		indicator = "|-"
+	case strings.HasSuffix(value, "\n\n"):
+		indicator = "|+"
+	}
+	b.WriteString(" " + indicator + "\n")
+	pad := strings.Repeat(" ", indent)
+	for _, line := range strings.Split(strings.TrimSuffix(value, "\n"), "\n") {
+		if line == "" {
+			b.WriteString("\n")
+			continue
+		}
+		b.WriteString(pad + line + "\n")
+	}
+}
+
+// isYAMLBlock checks if a string can be written as a literal block, it must span several lines, have printable
+// characters only and not start with a space, which would be taken as the indentation of the block
+func isYAMLBlock(value string) bool {
+	if !strings.Contains(value, "\n") || strings.HasPrefix(value, " ") {
+		return false
+	}
+
+	return strings.IndexFunc(value, func(r rune) bool { return r != '\n' && !unicode.IsPrint(r) }) < 0
+}
+
+// yamlScalar returns a scalar as it should be written
+func yamlScalar(value interface{}) string {
+	switch x := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(x)
+	case json.Number:
+		return x.String()
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case int, int64, uint64:
+		return fmt.Sprintf("%d", x)
+	case string:
+		if yamlPlainRegex.MatchString(x) && !yamlReserved[strings.ToLower(x)] {
+			return x
+		}
+		return strconv.Quote(x)
+	}
+
+	return strconv.Quote(fmt.Sprintf("%v", value))
+}
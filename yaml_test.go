@@ -0,0 +1,97 @@
+/*
+Copyright 2015 Home Office All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestEncodeYAML(t *testing.T) {
+	data := map[string]interface{}{
+		"pin":         "0123",
+		"enabled":     "yes",
+		"date":        "2001-12-14",
+		"time":        "1:20",
+		"port":        json.Number("5432"),
+		"ratio":       0.5,
+		"debug":       true,
+		"empty":       "",
+		"none":        nil,
+		"name":        "app",
+		"n":           "value",
+		"certificate": "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n",
+		"nested":      map[string]interface{}{"host": "db.svc", "tags": []interface{}{"a", map[string]interface{}{"b": "1", "c": "d"}}},
+		"list":        []interface{}{},
+	}
+	expected := `certificate: |
+  -----BEGIN CERTIFICATE-----
+  MIIB
+  -----END CERTIFICATE-----
+date: "2001-12-14"
+debug: true
+empty: ""
+enabled: "yes"
+list: []
+"n": value
+name: app
+nested:
+  host: db.svc
+  tags:
+  - a
+  - b: "1"
+    c: d
+none: null
+pin: "0123"
+port: 5432
+ratio: 0.5
+time: "1:20"
+`
+	content := encodeYAML(data)
+	assert.Equal(t, expected, string(content))
+
+	// step: the values read back are the ones written
+	var decoded map[string]interface{}
+	if assert.NoError(t, yaml.Unmarshal(content, &decoded)) {
+		assert.Equal(t, "0123", decoded["pin"])
+		assert.Equal(t, "yes", decoded["enabled"])
+		assert.Equal(t, "2001-12-14", decoded["date"])
+		assert.Equal(t, 5432, decoded["port"])
+		assert.Equal(t, data["certificate"], decoded["certificate"])
+	}
+
+	assert.Equal(t, "{}\n", string(encodeYAML(map[string]interface{}{})))
+}
+
+func TestEncodeYAMLBlocks(t *testing.T) {
+	cases := []string{
+		"line one\nline two",
+		"line one\nline two\n",
+		"line one\n\n",
+		" indented\nline",
+		"tab\tseparated\nline",
+	}
+	for i, c := range cases {
+		var decoded map[string]interface{}
+		if assert.NoError(t, yaml.Unmarshal(encodeYAML(map[string]interface{}{"value": c}), &decoded), "case %d", i) {
+			assert.Equal(t, c, decoded["value"], "case %d", i)
+		}
+	}
+}